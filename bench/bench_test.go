@@ -0,0 +1,38 @@
+package bench
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEchoBench(t *testing.T) {
+	server := NewEchoServer("127.0.0.1:0")
+	defer server.Close()
+
+	for _, rate := range []int{0, 200} {
+		report, err := Run(context.Background(), Config{
+			Addr:        server.Addr().String(),
+			Connections: 2,
+			MessageSize: 128,
+			Rate:        rate,
+			Duration:    300 * time.Millisecond,
+		})
+		assert.Nil(t, err)
+		assert.True(t, report.Received > 0, report.String())
+		assert.True(t, report.Sent >= report.Received)
+		assert.Equal(t, report.Received, report.Latency.Count())
+		assert.True(t, report.Throughput() > 0)
+		if rate > 0 {
+			// 2 connections * 200 msg/s * 0.3s
+			assert.True(t, report.Sent <= 130, report.String())
+		}
+	}
+
+	_, err := Run(context.Background(), Config{Addr: "127.0.0.1:1", Duration: time.Millisecond})
+	assert.NotNil(t, err)
+}
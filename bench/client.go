@@ -0,0 +1,218 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+)
+
+const (
+	defaultMessageSize    = 64
+	defaultDuration       = 10 * time.Second
+	defaultConnectTimeout = 3 * time.Second
+)
+
+// Config is the config of the load generator.
+type Config struct {
+	// echo server address
+	Addr string
+	// the number of the connections. Its default value is 1.
+	Connections int
+	// the payload size of a message. Its default value is 64.
+	MessageSize int
+	// the messages sent per second by every connection. 0 means the closed loop mode, in
+	// which a connection sends the next message when the previous one is echoed back.
+	Rate int
+	// the duration of the load. Its default value is 10s.
+	Duration time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.Connections <= 0 {
+		c.Connections = 1
+	}
+	if c.MessageSize <= 0 {
+		c.MessageSize = defaultMessageSize
+	}
+	if c.Rate < 0 {
+		c.Rate = 0
+	}
+	if c.Duration <= 0 {
+		c.Duration = defaultDuration
+	}
+
+	return c
+}
+
+// Report is the result of a load.
+type Report struct {
+	Config  Config
+	Elapsed time.Duration
+	// the number of the sent & echoed messages, and the failed writes
+	Sent     uint64
+	Received uint64
+	Errors   uint64
+	// round trip time of the echoed messages
+	Latency *getty.Histogram
+}
+
+// Throughput returns the echoed messages per second.
+func (r *Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Received) / r.Elapsed.Seconds()
+}
+
+// Bandwidth returns the echoed bytes per second, including the frame headers.
+func (r *Report) Bandwidth() float64 {
+	return r.Throughput() * float64(frameHeaderLen+r.Config.MessageSize)
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("connections:%d, message size:%d, rate:%d, elapsed:%s, sent:%d, received:%d, errors:%d, "+
+		"throughput:%.1f msg/s, bandwidth:%.1f B/s, latency:%s",
+		r.Config.Connections, r.Config.MessageSize, r.Config.Rate, r.Elapsed, r.Sent, r.Received, r.Errors,
+		r.Throughput(), r.Bandwidth(), r.Latency)
+}
+
+// loadHandler collects the echoed messages of the load generator sessions
+type loadHandler struct {
+	config  Config
+	payload []byte
+	report  *Report
+	running int32
+
+	lock     sync.Mutex
+	sessions []getty.Session
+	opened   chan struct{}
+}
+
+func (h *loadHandler) OnOpen(ss getty.Session) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.sessions = append(h.sessions, ss)
+	if len(h.sessions) == h.config.Connections {
+		close(h.opened)
+	}
+
+	return nil
+}
+
+func (h *loadHandler) OnClose(ss getty.Session)            {}
+func (h *loadHandler) OnError(ss getty.Session, err error) {}
+func (h *loadHandler) OnCron(ss getty.Session)             {}
+
+func (h *loadHandler) OnMessage(ss getty.Session, pkg interface{}) {
+	if atomic.LoadInt32(&h.running) == 0 {
+		return
+	}
+	f, ok := pkg.(*frame)
+	if !ok {
+		return
+	}
+	h.report.Latency.Record(time.Duration(time.Now().UnixNano() - f.sendTime))
+	atomic.AddUint64(&h.report.Received, 1)
+	if h.config.Rate == 0 {
+		h.send(ss, 0)
+	}
+}
+
+func (h *loadHandler) send(ss getty.Session, timeout time.Duration) {
+	if atomic.LoadInt32(&h.running) == 0 {
+		return
+	}
+	if err := ss.WritePkg(newFrame(h.payload), timeout); err != nil {
+		atomic.AddUint64(&h.report.Errors, 1)
+		return
+	}
+	atomic.AddUint64(&h.report.Sent, 1)
+}
+
+// send the messages of @ss at the configured rate until @done is closed
+func (h *loadHandler) pace(ss getty.Session, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second / time.Duration(h.config.Rate))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			h.send(ss, time.Second)
+		}
+	}
+}
+
+// Run connects @config.Connections connections to the echo server, loads them for
+// @config.Duration or until @ctx is done, and reports the throughput and the latency.
+func Run(ctx context.Context, config Config, opts ...getty.ClientOption) (*Report, error) {
+	config = config.withDefaults()
+	h := &loadHandler{
+		config:  config,
+		payload: make([]byte, config.MessageSize),
+		report:  &Report{Config: config, Latency: getty.NewHistogram()},
+		opened:  make(chan struct{}),
+	}
+
+	opts = append([]getty.ClientOption{
+		getty.WithServerAddress(config.Addr),
+		getty.WithConnectionNumber(config.Connections),
+	}, opts...)
+	client := getty.NewTCPClient(opts...)
+	defer client.Close()
+	go client.RunEventLoop(func(ss getty.Session) error {
+		initSession(ss, h)
+		return nil
+	})
+
+	select {
+	case <-h.opened:
+	case <-time.After(defaultConnectTimeout):
+		return nil, jerrors.Errorf("failed to connect %d connections to %s", config.Connections, config.Addr)
+	case <-ctx.Done():
+		return nil, jerrors.Trace(ctx.Err())
+	}
+
+	var (
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	start := time.Now()
+	atomic.StoreInt32(&h.running, 1)
+	h.lock.Lock()
+	for _, ss := range h.sessions {
+		if config.Rate == 0 {
+			h.send(ss, 0)
+			continue
+		}
+		wg.Add(1)
+		go func(ss getty.Session) {
+			defer wg.Done()
+			h.pace(ss, done)
+		}(ss)
+	}
+	h.lock.Unlock()
+
+	timer := time.NewTimer(config.Duration)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+	atomic.StoreInt32(&h.running, 0)
+	h.report.Elapsed = time.Since(start)
+	close(done)
+	wg.Wait()
+
+	return h.report, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+import (
+	"github.com/AlexStocks/getty/bench"
+)
+
+var (
+	mode     = flag.String("mode", "client", "server: run the echo server, client: run the load generator, both: run both in process")
+	addr     = flag.String("addr", "127.0.0.1:20000", "echo server address")
+	conns    = flag.Int("conns", 1, "connection number of the load generator")
+	size     = flag.Int("size", 64, "message payload size in bytes")
+	rate     = flag.Int("rate", 0, "messages per second per connection, 0 means closed loop")
+	duration = flag.Duration("duration", 10*time.Second, "load duration")
+)
+
+func main() {
+	flag.Parse()
+	// the debug logs of getty slow down the load
+	log.SetLogLevel(log.WARNING)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	switch *mode {
+	case "server":
+		server := bench.NewEchoServer(*addr)
+		fmt.Printf("echo server listens on %s\n", server.Addr())
+		<-ctx.Done()
+		server.Close()
+
+	case "client", "both":
+		serverAddr := *addr
+		if *mode == "both" {
+			server := bench.NewEchoServer(*addr)
+			defer server.Close()
+			serverAddr = server.Addr().String()
+		}
+		report, err := bench.Run(ctx, bench.Config{
+			Addr:        serverAddr,
+			Connections: *conns,
+			MessageSize: *size,
+			Rate:        *rate,
+			Duration:    *duration,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bench error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(report)
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
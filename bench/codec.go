@@ -0,0 +1,79 @@
+package bench
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+)
+
+const (
+	// length(4 bytes) | send time in unix nanoseconds(8 bytes) | payload
+	frameHeaderLen = 12
+	maxFrameLen    = 16 * 1024 * 1024
+)
+
+var (
+	errIllegalFrame = jerrors.New("illegal bench frame")
+)
+
+// frame is the message exchanged by the load generator and the echo server
+type frame struct {
+	sendTime int64
+	payload  []byte
+}
+
+func newFrame(payload []byte) *frame {
+	return &frame{sendTime: time.Now().UnixNano(), payload: payload}
+}
+
+type frameCodec struct{}
+
+func (frameCodec) Read(ss getty.Session, data []byte) (interface{}, int, error) {
+	if len(data) < frameHeaderLen {
+		return nil, 0, nil
+	}
+	length := int(binary.BigEndian.Uint32(data))
+	if length < frameHeaderLen || length > maxFrameLen {
+		return nil, 0, jerrors.Annotatef(errIllegalFrame, "length %d", length)
+	}
+	if len(data) < length {
+		return nil, 0, nil
+	}
+
+	return &frame{
+		sendTime: int64(binary.BigEndian.Uint64(data[4:])),
+		payload:  append([]byte(nil), data[frameHeaderLen:length]...),
+	}, length, nil
+}
+
+func (frameCodec) Write(ss getty.Session, pkg interface{}) ([]byte, error) {
+	f, ok := pkg.(*frame)
+	if !ok {
+		return nil, jerrors.Errorf("illegal pkg type %T", pkg)
+	}
+
+	b := make([]byte, frameHeaderLen+len(f.payload))
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	binary.BigEndian.PutUint64(b[4:], uint64(f.sendTime))
+	copy(b[frameHeaderLen:], f.payload)
+	return b, nil
+}
+
+func initSession(ss getty.Session, listener getty.EventListener) {
+	ss.SetName("getty-bench")
+	ss.SetMaxMsgLen(maxFrameLen)
+	ss.SetPkgHandler(frameCodec{})
+	ss.SetEventListener(listener)
+	ss.SetWQLen(1024)
+	ss.SetReadTimeout(time.Second)
+	ss.SetWriteTimeout(5 * time.Second)
+	ss.SetCronPeriod(int(time.Minute / time.Millisecond))
+	ss.SetWaitTime(time.Second)
+}
@@ -0,0 +1,57 @@
+package bench
+
+import (
+	"net"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+)
+
+type echoHandler struct{}
+
+func (echoHandler) OnOpen(ss getty.Session) error       { return nil }
+func (echoHandler) OnClose(ss getty.Session)            {}
+func (echoHandler) OnError(ss getty.Session, err error) {}
+func (echoHandler) OnCron(ss getty.Session)             {}
+
+func (echoHandler) OnMessage(ss getty.Session, pkg interface{}) {
+	if err := ss.WritePkg(pkg, 0); err != nil {
+		log.Warn("%s, echo error:%v", ss.Stat(), err)
+	}
+}
+
+// EchoServer is a tcp server which sends every bench frame back.
+type EchoServer struct {
+	server getty.Server
+}
+
+// NewEchoServer starts an echo server listening on @addr, e.g. "127.0.0.1:0".
+func NewEchoServer(addr string, opts ...getty.ServerOption) *EchoServer {
+	server := getty.NewTCPServer(append([]getty.ServerOption{getty.WithLocalAddress(addr)}, opts...)...)
+	server.RunEventLoop(func(ss getty.Session) error {
+		initSession(ss, echoHandler{})
+		return nil
+	})
+
+	return &EchoServer{server: server}
+}
+
+// Addr returns the listen address of the echo server.
+func (s *EchoServer) Addr() net.Addr {
+	return s.server.Listener().Addr()
+}
+
+// Metrics returns the metrics of the echo server.
+func (s *EchoServer) Metrics() *getty.EndPointMetrics {
+	return s.server.Metrics()
+}
+
+// Close stops the echo server.
+func (s *EchoServer) Close() {
+	s.server.Close()
+}
@@ -0,0 +1,240 @@
+/******************************************************
+# DESC    : chat server with rooms
+# AUTHOR  : Alex Stocks
+# LICENCE : Apache License 2.0
+# EMAIL   : alexstocks@foxmail.com
+# MOD     : 2020-05-15 10:20
+# FILE    : chat.go
+******************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+	log "github.com/AlexStocks/log4go"
+)
+
+// chat commands. The other lines are said to the room of the sender.
+const (
+	chatNick  = "/nick"
+	chatJoin  = "/join"
+	chatLeave = "/leave"
+	chatWho   = "/who"
+)
+
+type chatMember struct {
+	nick string
+	room string
+}
+
+// chatHandler is the chat server. Every session is a member, who can join one room at a time.
+type chatHandler struct {
+	config *serverConfig
+
+	lock    sync.RWMutex
+	members map[getty.Session]*chatMember
+	rooms   map[string]map[getty.Session]struct{}
+	nextID  int
+}
+
+func newChatHandler(config *serverConfig) *chatHandler {
+	return &chatHandler{
+		config:  config,
+		members: make(map[getty.Session]*chatMember),
+		rooms:   make(map[string]map[getty.Session]struct{}),
+	}
+}
+
+func (h *chatHandler) OnOpen(session getty.Session) error {
+	h.lock.Lock()
+	h.nextID++
+	h.members[session] = &chatMember{nick: fmt.Sprintf("guest%d", h.nextID)}
+	h.lock.Unlock()
+
+	log.Info("chat session opened:%s", session.Stat())
+	return nil
+}
+
+func (h *chatHandler) OnClose(session getty.Session) {
+	h.lock.Lock()
+	if member, ok := h.members[session]; ok {
+		h.leave(session, member)
+		delete(h.members, session)
+	}
+	h.lock.Unlock()
+
+	log.Info("chat session closed:%s", session.Stat())
+}
+
+func (h *chatHandler) OnError(session getty.Session, err error) {
+	log.Info("chat session{%s} got error:%v", session.Stat(), err)
+}
+
+func (h *chatHandler) OnCron(session getty.Session) {
+	h.config.checkHeartbeat(session)
+}
+
+func (h *chatHandler) OnMessage(session getty.Session, pkg interface{}) {
+	line := pkg.(string)
+	if line == heartbeatRequest {
+		h.reply(session, heartbeatResponse)
+		return
+	}
+
+	cmd, arg := line, ""
+	if idx := strings.IndexByte(line, ' '); idx >= 0 {
+		cmd, arg = line[:idx], strings.TrimSpace(line[idx+1:])
+	}
+	switch cmd {
+	case chatNick:
+		h.reply(session, h.nick(session, arg))
+	case chatJoin:
+		h.reply(session, h.join(session, arg))
+	case chatLeave:
+		h.reply(session, h.leaveRoom(session))
+	case chatWho:
+		h.reply(session, h.who(session))
+	default:
+		if err := h.say(session, line); err != nil {
+			h.reply(session, "error "+err.Error())
+		}
+	}
+}
+
+func (h *chatHandler) reply(session getty.Session, line string) {
+	if err := session.WritePkg(line, writePkgTimeout); err != nil {
+		log.Warn("chat session{%s} write error:%v", session.Stat(), err)
+	}
+}
+
+// send @line to the members of @room except @from. It should be invoked with the lock held.
+func (h *chatHandler) broadcast(room string, from getty.Session, line string) {
+	for session := range h.rooms[room] {
+		if session != from {
+			h.reply(session, line)
+		}
+	}
+}
+
+func (h *chatHandler) nick(session getty.Session, nick string) string {
+	if nick == "" || strings.ContainsAny(nick, " :") {
+		return "error illegal nick"
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, m := range h.members {
+		if m.nick == nick {
+			return "error nick " + nick + " is in use"
+		}
+	}
+	member := h.members[session]
+	if member.room != "" {
+		h.broadcast(member.room, session, fmt.Sprintf("* %s is now %s", member.nick, nick))
+	}
+	member.nick = nick
+	return "ok nick " + nick
+}
+
+func (h *chatHandler) join(session getty.Session, room string) string {
+	if room == "" || strings.ContainsAny(room, " ") {
+		return "error illegal room"
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	member := h.members[session]
+	h.leave(session, member)
+	members, ok := h.rooms[room]
+	if !ok {
+		members = make(map[getty.Session]struct{})
+		h.rooms[room] = members
+	}
+	members[session] = struct{}{}
+	member.room = room
+	h.broadcast(room, session, fmt.Sprintf("* %s joined %s", member.nick, room))
+	return "ok join " + room
+}
+
+func (h *chatHandler) leaveRoom(session getty.Session) string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	member := h.members[session]
+	if member.room == "" {
+		return "error not in a room"
+	}
+	room := member.room
+	h.leave(session, member)
+	return "ok leave " + room
+}
+
+// leave the room of @member. It should be invoked with the lock held.
+func (h *chatHandler) leave(session getty.Session, member *chatMember) {
+	if member.room == "" {
+		return
+	}
+
+	room := member.room
+	member.room = ""
+	delete(h.rooms[room], session)
+	if len(h.rooms[room]) == 0 {
+		delete(h.rooms, room)
+		return
+	}
+	h.broadcast(room, session, fmt.Sprintf("* %s left %s", member.nick, room))
+}
+
+func (h *chatHandler) who(session getty.Session) string {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	member := h.members[session]
+	if member.room == "" {
+		return "error not in a room"
+	}
+	nicks := make([]string, 0, len(h.rooms[member.room]))
+	for s := range h.rooms[member.room] {
+		nicks = append(nicks, h.members[s].nick)
+	}
+	sort.Strings(nicks)
+	return "members " + strings.Join(nicks, ",")
+}
+
+func (h *chatHandler) say(session getty.Session, line string) error {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	member := h.members[session]
+	if member.room == "" {
+		return errors.New("join a room first")
+	}
+	h.broadcast(member.room, session, fmt.Sprintf("[%s] %s: %s", member.room, member.nick, line))
+	return nil
+}
+
+func runChat(args []string) (*exampleServer, error) {
+	config := serverConfig{network: "tcp"}
+	if err := parseFlags("chat", args, &config, "127.0.0.1:10001"); err != nil {
+		return nil, err
+	}
+
+	return newChatServer(config)
+}
+
+func newChatServer(config serverConfig) (*exampleServer, error) {
+	if config.network == "udp" {
+		return nil, errors.New("the chat server does not serve udp")
+	}
+
+	handler := newChatHandler(&config)
+	return startServer(config, func(session getty.Session) {
+		session.SetPkgHandler(lineCodec{maxLen: config.maxMsgLen})
+		session.SetEventListener(handler)
+	}), nil
+}
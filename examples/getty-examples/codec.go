@@ -0,0 +1,59 @@
+/******************************************************
+# DESC    : line codec of the examples
+# AUTHOR  : Alex Stocks
+# LICENCE : Apache License 2.0
+# EMAIL   : alexstocks@foxmail.com
+# MOD     : 2020-05-15 10:20
+# FILE    : codec.go
+******************************************************/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+)
+
+const (
+	heartbeatRequest  = "ping"
+	heartbeatResponse = "pong"
+)
+
+var (
+	errLineTooLong = errors.New("line is too long")
+)
+
+// lineCodec frames the pkgs as "\n" terminated lines, so the examples can be tried by
+// telnet or nc. Every websocket message should be a line too.
+type lineCodec struct {
+	maxLen int
+}
+
+func (c lineCodec) Read(session getty.Session, data []byte) (interface{}, int, error) {
+	idx := bytes.IndexByte(data, '\n')
+	if idx < 0 {
+		if len(data) > c.maxLen {
+			return nil, 0, errLineTooLong
+		}
+		return nil, 0, nil
+	}
+	if idx > c.maxLen {
+		return nil, 0, errLineTooLong
+	}
+
+	return string(bytes.TrimSuffix(data[:idx], []byte("\r"))), idx + 1, nil
+}
+
+func (c lineCodec) Write(session getty.Session, pkg interface{}) ([]byte, error) {
+	line, ok := pkg.(string)
+	if !ok {
+		return nil, fmt.Errorf("illegal pkg %#v", pkg)
+	}
+
+	return []byte(line + "\n"), nil
+}
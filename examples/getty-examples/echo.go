@@ -0,0 +1,72 @@
+/******************************************************
+# DESC    : echo server
+# AUTHOR  : Alex Stocks
+# LICENCE : Apache License 2.0
+# EMAIL   : alexstocks@foxmail.com
+# MOD     : 2020-05-15 10:20
+# FILE    : echo.go
+******************************************************/
+
+package main
+
+import (
+	"errors"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+	log "github.com/AlexStocks/log4go"
+)
+
+// echoHandler writes every line back to its sender, and answers "ping" with "pong".
+type echoHandler struct {
+	config *serverConfig
+}
+
+func (h *echoHandler) OnOpen(session getty.Session) error {
+	log.Info("echo session opened:%s", session.Stat())
+	return nil
+}
+
+func (h *echoHandler) OnClose(session getty.Session) {
+	log.Info("echo session closed:%s", session.Stat())
+}
+
+func (h *echoHandler) OnError(session getty.Session, err error) {
+	log.Info("echo session{%s} got error:%v", session.Stat(), err)
+}
+
+func (h *echoHandler) OnCron(session getty.Session) {
+	h.config.checkHeartbeat(session)
+}
+
+func (h *echoHandler) OnMessage(session getty.Session, pkg interface{}) {
+	line := pkg.(string)
+	if line == heartbeatRequest {
+		line = heartbeatResponse
+	}
+	if err := session.WritePkg(line, writePkgTimeout); err != nil {
+		log.Warn("echo session{%s} write error:%v", session.Stat(), err)
+	}
+}
+
+func runEcho(args []string) (*exampleServer, error) {
+	config := serverConfig{network: "tcp"}
+	if err := parseFlags("echo", args, &config, "127.0.0.1:10000"); err != nil {
+		return nil, err
+	}
+
+	return newEchoServer(config)
+}
+
+func newEchoServer(config serverConfig) (*exampleServer, error) {
+	if config.network == "udp" {
+		return nil, errors.New("the echo server does not serve udp")
+	}
+
+	handler := &echoHandler{config: &config}
+	return startServer(config, func(session getty.Session) {
+		session.SetPkgHandler(lineCodec{maxLen: config.maxMsgLen})
+		session.SetEventListener(handler)
+	}), nil
+}
@@ -0,0 +1,93 @@
+/******************************************************
+# DESC    : example servers compiled as the subcommands of one binary
+# AUTHOR  : Alex Stocks
+# LICENCE : Apache License 2.0
+# EMAIL   : alexstocks@foxmail.com
+# MOD     : 2020-05-15 10:20
+# FILE    : main.go
+******************************************************/
+
+// getty-examples is a set of runnable reference servers:
+//
+//	getty-examples echo      [flags]  # echo server over tcp/ws/wss
+//	getty-examples chat      [flags]  # chat server with rooms over tcp/ws/wss
+//	getty-examples telemetry [flags]  # udp telemetry ingest
+//
+// Run "getty-examples <command> -h" for the flags of a command.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+	log "github.com/AlexStocks/log4go"
+)
+
+type command struct {
+	desc string
+	// build the server of the command from its arguments
+	run func(args []string) (*exampleServer, error)
+}
+
+var commands = map[string]command{
+	"echo":      {"echo every line back to its sender", runEcho},
+	"chat":      {"chat in rooms, see the /nick, /join, /leave and /who commands", runChat},
+	"telemetry": {"ingest \"name value\" samples from udp datagrams", runTelemetry},
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].desc)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	server, err := cmd.run(os.Args[2:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[1], err)
+		os.Exit(2)
+	}
+	log.Info("getty-examples %s starts, getty version:%s, addr:%s", os.Args[1], getty.Version, server.addr())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	log.Info("getty-examples %s got signal %s, exits", os.Args[1], sig)
+	server.close()
+	log.Close()
+}
+
+// parse the flags of a command into @config
+func parseFlags(name string, args []string, config *serverConfig, defaultAddr string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	config.register(fs, defaultAddr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	return config.check()
+}
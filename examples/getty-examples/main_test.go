@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestConfig(t *testing.T, network string, args ...string) serverConfig {
+	config := serverConfig{network: network}
+	args = append([]string{"-addr", "127.0.0.1:0", "-task-pool", "4"}, args...)
+	assert.Nil(t, parseFlags("test", args, &config, ""))
+	return config
+}
+
+type lineClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialLine(t *testing.T, addr string) *lineClient {
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &lineClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *lineClient) send(line string) {
+	_, err := c.conn.Write([]byte(line + "\n"))
+	assert.Nil(c.t, err)
+}
+
+func (c *lineClient) recv() string {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	assert.Nil(c.t, err)
+	return line[:len(line)-1]
+}
+
+func (c *lineClient) call(line string) string {
+	c.send(line)
+	return c.recv()
+}
+
+func TestParseFlags(t *testing.T) {
+	var config serverConfig
+	assert.NotNil(t, parseFlags("test", []string{"-net", "quic"}, &config, ""))
+	config = serverConfig{}
+	assert.NotNil(t, parseFlags("test", []string{"-net", "wss"}, &config, ""))
+	config = serverConfig{network: "tcp"}
+	assert.NotNil(t, parseFlags("test", []string{"-heartbeat", "0s"}, &config, ""))
+
+	config = serverConfig{network: "tcp"}
+	assert.Nil(t, parseFlags("test", []string{"-timeout", "3s"}, &config, "127.0.0.1:1"))
+	assert.Equal(t, "127.0.0.1:1", config.addr)
+	assert.Equal(t, 3*time.Second, config.sessionTimeout)
+	assert.Equal(t, 4096, config.maxMsgLen)
+}
+
+func TestLineCodec(t *testing.T) {
+	codec := lineCodec{maxLen: 8}
+	pkg, n, err := codec.Read(nil, []byte("hello\r\nworld"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", pkg)
+	assert.Equal(t, 7, n)
+
+	pkg, n, err = codec.Read(nil, []byte("world"))
+	assert.Nil(t, err)
+	assert.Nil(t, pkg)
+	assert.Equal(t, 0, n)
+
+	_, _, err = codec.Read(nil, []byte("too long line\n"))
+	assert.Equal(t, errLineTooLong, err)
+	_, _, err = codec.Read(nil, []byte("too long line"))
+	assert.Equal(t, errLineTooLong, err)
+
+	data, err := codec.Write(nil, "hi")
+	assert.Nil(t, err)
+	assert.Equal(t, "hi\n", string(data))
+	_, err = codec.Write(nil, 1)
+	assert.NotNil(t, err)
+}
+
+func TestEchoServer(t *testing.T) {
+	server, err := newEchoServer(newTestConfig(t, "tcp", "-heartbeat", "50ms", "-timeout", "300ms"))
+	assert.Nil(t, err)
+	defer server.close()
+
+	client := dialLine(t, server.addr())
+	assert.Equal(t, "hello", client.call("hello"))
+	assert.Equal(t, heartbeatResponse, client.call(heartbeatRequest))
+
+	// the heartbeats keep the session alive
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, heartbeatResponse, client.call(heartbeatRequest))
+	}
+
+	// the idle session is closed by the heartbeat check
+	idle := dialLine(t, server.addr())
+	idle.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = idle.r.ReadString('\n')
+	assert.NotNil(t, err)
+	if netErr, ok := err.(net.Error); ok {
+		assert.False(t, netErr.Timeout())
+	}
+
+	_, err = newEchoServer(newTestConfig(t, "udp"))
+	assert.NotNil(t, err)
+}
+
+// write a self-signed certificate & its private key into @dir
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "getty-examples"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	cert, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return cert, keyFile
+}
+
+func TestEchoServerWSS(t *testing.T) {
+	cert, key := writeTestCert(t, t.TempDir())
+	server, err := newEchoServer(newTestConfig(t, "wss", "-path", "/echo", "-cert", cert, "-key", key))
+	assert.Nil(t, err)
+	defer server.close()
+
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	var conn *websocket.Conn
+	// the wss server listens asynchronously
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if conn, _, err = dialer.Dial("wss://"+server.addr()+"/echo", nil); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	for _, line := range []string{"hello", heartbeatRequest} {
+		assert.Nil(t, conn.WriteMessage(websocket.TextMessage, []byte(line+"\n")))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := conn.ReadMessage()
+		assert.Nil(t, err)
+		if line == heartbeatRequest {
+			line = heartbeatResponse
+		}
+		assert.Equal(t, line+"\n", string(data))
+	}
+}
+
+func TestChatServer(t *testing.T) {
+	server, err := newChatServer(newTestConfig(t, "tcp"))
+	assert.Nil(t, err)
+	defer server.close()
+
+	alice, bob := dialLine(t, server.addr()), dialLine(t, server.addr())
+	assert.Equal(t, "ok nick alice", alice.call("/nick alice"))
+	assert.Equal(t, "error nick alice is in use", bob.call("/nick alice"))
+	assert.Equal(t, "ok nick bob", bob.call("/nick bob"))
+	assert.Equal(t, "error join a room first", alice.call("hi"))
+	assert.Equal(t, "error illegal room", alice.call("/join"))
+
+	assert.Equal(t, "ok join go", alice.call("/join go"))
+	assert.Equal(t, "ok join go", bob.call("/join go"))
+	assert.Equal(t, "* bob joined go", alice.recv())
+	assert.Equal(t, "members alice,bob", alice.call("/who"))
+
+	alice.send("hi bob")
+	assert.Equal(t, "[go] alice: hi bob", bob.recv())
+	assert.Equal(t, heartbeatResponse, bob.call(heartbeatRequest))
+
+	// the members of the other rooms do not hear it
+	carol := dialLine(t, server.addr())
+	assert.Equal(t, "ok join rust", carol.call("/join rust"))
+	bob.send("bye")
+	assert.Equal(t, "[go] bob: bye", alice.recv())
+	assert.Equal(t, "ok leave go", bob.call("/leave"))
+	assert.Equal(t, "* bob left go", alice.recv())
+	assert.Equal(t, "error not in a room", bob.call("/who"))
+
+	// the closed session leaves its room
+	assert.Equal(t, "ok join rust", alice.call("/join rust"))
+	assert.Equal(t, "* alice joined rust", carol.recv())
+	alice.conn.Close()
+	assert.Equal(t, "* alice left rust", carol.recv())
+	assert.Equal(t, "members guest3", carol.call("/who"))
+}
+
+func TestTelemetryCodec(t *testing.T) {
+	data := []byte("cpu 0.5\nmem 1024\n\nbad\ncpu NaN\ndisk x\n")
+	pkg, n, err := telemetryCodec{}.Read(nil, data)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), n)
+	assert.Equal(t, telemetryBatch{
+		Samples: []telemetrySample{{"cpu", 0.5}, {"mem", 1024}},
+		Dropped: 3,
+	}, pkg)
+}
+
+func TestTelemetryServer(t *testing.T) {
+	// the bound address of a udp endpoint is not exposed, so a free port is picked first
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	// the datagrams are aggregated in order without a task pool
+	config := newTestConfig(t, "udp", "-heartbeat", "50ms", "-task-pool", "0")
+	config.addr = addr
+	server, handler := newTelemetryServer(config)
+	defer server.close()
+
+	conn, err := net.Dial("udp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	for _, datagram := range []string{"cpu 0.5\nmem 1024", "cpu 1.5", "cpu -1\nbad"} {
+		_, err = conn.Write([]byte(datagram))
+		assert.Nil(t, err)
+	}
+
+	var (
+		stats   map[string]telemetryStat
+		dropped int64
+	)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if stats, dropped = handler.snapshot(); stats["cpu"].Count == 3 && dropped == 1 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, telemetryStat{Count: 3, Sum: 1, Min: -1, Max: 1.5, Last: -1}, stats["cpu"])
+	assert.Equal(t, telemetryStat{Count: 1, Sum: 1024, Min: 1024, Max: 1024, Last: 1024}, stats["mem"])
+	assert.Equal(t, int64(1), dropped)
+}
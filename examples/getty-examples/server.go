@@ -0,0 +1,160 @@
+/******************************************************
+# DESC    : the server config shared by the examples
+# AUTHOR  : Alex Stocks
+# LICENCE : Apache License 2.0
+# EMAIL   : alexstocks@foxmail.com
+# MOD     : 2020-05-15 10:20
+# FILE    : server.go
+******************************************************/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+	log "github.com/AlexStocks/log4go"
+	"github.com/dubbogo/gost/sync"
+)
+
+const (
+	writePkgTimeout = 100 * time.Millisecond
+)
+
+// serverConfig is the config of an example server, which is set by the flags of its command.
+type serverConfig struct {
+	network string // tcp, ws, wss or udp
+	addr    string
+	// the websocket path of a ws/wss server
+	path string
+	// the certificate & private key files of a wss server
+	cert string
+	key  string
+
+	// the period of the cron of the sessions, i.e. the heartbeat check
+	heartbeat time.Duration
+	// the session is closed if nothing is read from it in it
+	sessionTimeout time.Duration
+	maxMsgLen      int
+	// the goroutine number of the task pool which handles the pkgs. 0 means the pkgs are
+	// handled in the read goroutine of the session.
+	taskPoolSize int
+}
+
+func (c *serverConfig) register(fs *flag.FlagSet, defaultAddr string) {
+	fs.StringVar(&c.network, "net", c.network, "network of the server: tcp, ws or wss")
+	fs.StringVar(&c.addr, "addr", defaultAddr, "listen address")
+	fs.StringVar(&c.path, "path", "/", "websocket path of a ws/wss server")
+	fs.StringVar(&c.cert, "cert", "", "certificate file of a wss server")
+	fs.StringVar(&c.key, "key", "", "private key file of a wss server")
+	fs.DurationVar(&c.heartbeat, "heartbeat", 10*time.Second, "period of the heartbeat check")
+	fs.DurationVar(&c.sessionTimeout, "timeout", time.Minute, "idle timeout of a session")
+	fs.IntVar(&c.maxMsgLen, "max-msg-len", 4096, "max length of a message")
+	fs.IntVar(&c.taskPoolSize, "task-pool", 0, "goroutine number of the task pool, 0 means no task pool")
+}
+
+func (c *serverConfig) check() error {
+	switch c.network {
+	case "tcp", "ws", "udp":
+	case "wss":
+		if c.cert == "" || c.key == "" {
+			return errors.New("a wss server needs -cert and -key")
+		}
+	default:
+		return fmt.Errorf("illegal network %q", c.network)
+	}
+	if c.heartbeat <= 0 || c.sessionTimeout <= 0 {
+		return errors.New("-heartbeat and -timeout should be positive")
+	}
+
+	return nil
+}
+
+// exampleServer is a running example server.
+type exampleServer struct {
+	config   serverConfig
+	server   getty.Server
+	taskPool *gxsync.TaskPool
+}
+
+// start the server of @config. @setup sets the codec & the event listener of a new session.
+func startServer(config serverConfig, setup func(getty.Session)) *exampleServer {
+	s := &exampleServer{config: config}
+	if config.taskPoolSize > 0 {
+		s.taskPool = gxsync.NewTaskPool(
+			gxsync.WithTaskPoolTaskPoolSize(config.taskPoolSize),
+			gxsync.WithTaskPoolTaskQueueLength(64),
+			gxsync.WithTaskPoolTaskQueueNumber(config.taskPoolSize),
+		)
+	}
+
+	switch config.network {
+	case "tcp":
+		s.server = getty.NewTCPServer(getty.WithLocalAddress(config.addr))
+	case "ws":
+		s.server = getty.NewWSServer(getty.WithLocalAddress(config.addr), getty.WithWebsocketServerPath(config.path))
+	case "wss":
+		s.server = getty.NewWSSServer(
+			getty.WithLocalAddress(config.addr),
+			getty.WithWebsocketServerPath(config.path),
+			getty.WithWebsocketServerCert(config.cert),
+			getty.WithWebsocketServerPrivateKey(config.key),
+		)
+	case "udp":
+		s.server = getty.NewUDPPEndPoint(getty.WithLocalAddress(config.addr))
+	}
+
+	s.server.RunEventLoop(func(session getty.Session) error {
+		if tcpConn, ok := session.Conn().(*net.TCPConn); ok {
+			tcpConn.SetNoDelay(true)
+			tcpConn.SetKeepAlive(true)
+		}
+		session.SetName(config.network)
+		session.SetMaxMsgLen(config.maxMsgLen)
+		session.SetReadTimeout(config.sessionTimeout)
+		session.SetWriteTimeout(writePkgTimeout)
+		session.SetCronPeriod(int(config.heartbeat / time.Millisecond))
+		session.SetWaitTime(time.Second)
+		if s.taskPool != nil {
+			session.SetTaskPool(s.taskPool)
+		}
+		setup(session)
+		log.Debug("new session:%s", session.Stat())
+		return nil
+	})
+
+	return s
+}
+
+// the listen address of the server, which is the bound address of a tcp/ws/wss server
+func (s *exampleServer) addr() string {
+	if l := s.server.Listener(); l != nil {
+		return l.Addr().String()
+	}
+	return s.config.addr
+}
+
+func (s *exampleServer) close() {
+	s.server.Close()
+	if s.taskPool != nil {
+		s.taskPool.Close()
+	}
+}
+
+// close @session if nothing has been read from it in the session timeout, which is the
+// heartbeat check invoked by OnCron. The clients keep their sessions alive by sending "ping".
+func (c *serverConfig) checkHeartbeat(session getty.Session) bool {
+	if idle := time.Since(session.GetActive()); idle > c.sessionTimeout {
+		log.Warn("session{%s} timeout, idle:%s", session.Stat(), idle)
+		session.Close()
+		return false
+	}
+
+	return true
+}
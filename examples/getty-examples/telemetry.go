@@ -0,0 +1,182 @@
+/******************************************************
+# DESC    : udp telemetry ingest server
+# AUTHOR  : Alex Stocks
+# LICENCE : Apache License 2.0
+# EMAIL   : alexstocks@foxmail.com
+# MOD     : 2020-05-15 10:20
+# FILE    : telemetry.go
+******************************************************/
+
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+	log "github.com/AlexStocks/log4go"
+)
+
+// telemetrySample is a sample of a metric, which is a "name value" line of a datagram.
+type telemetrySample struct {
+	Name  string
+	Value float64
+}
+
+// telemetryBatch is the samples of a datagram. The malformed lines are counted in Dropped.
+type telemetryBatch struct {
+	Samples []telemetrySample
+	Dropped int
+}
+
+// telemetryCodec decodes a datagram into a telemetryBatch. It never fails, for a udp sender
+// can not be told about its malformed lines.
+type telemetryCodec struct{}
+
+func (c telemetryCodec) Read(session getty.Session, data []byte) (interface{}, int, error) {
+	var batch telemetryBatch
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			batch.Dropped++
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			batch.Dropped++
+			continue
+		}
+		batch.Samples = append(batch.Samples, telemetrySample{Name: fields[0], Value: value})
+	}
+
+	return batch, len(data), nil
+}
+
+func (c telemetryCodec) Write(session getty.Session, pkg interface{}) ([]byte, error) {
+	return nil, errors.New("the telemetry server does not reply")
+}
+
+// telemetryStat is the aggregation of the samples of a metric.
+type telemetryStat struct {
+	Count int64
+	Sum   float64
+	Min   float64
+	Max   float64
+	Last  float64
+}
+
+func (s *telemetryStat) add(v float64) {
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Count++
+	s.Sum += v
+	s.Last = v
+}
+
+// telemetryHandler aggregates the samples, and logs the aggregation in its cron.
+type telemetryHandler struct {
+	lock    sync.Mutex
+	stats   map[string]*telemetryStat
+	dropped int64
+}
+
+func newTelemetryHandler() *telemetryHandler {
+	return &telemetryHandler{stats: make(map[string]*telemetryStat)}
+}
+
+func (h *telemetryHandler) OnOpen(session getty.Session) error {
+	log.Info("telemetry session opened:%s", session.Stat())
+	return nil
+}
+
+func (h *telemetryHandler) OnClose(session getty.Session) {
+	log.Info("telemetry session closed:%s", session.Stat())
+}
+
+func (h *telemetryHandler) OnError(session getty.Session, err error) {
+	log.Info("telemetry session{%s} got error:%v", session.Stat(), err)
+}
+
+func (h *telemetryHandler) OnCron(session getty.Session) {
+	stats, dropped := h.snapshot()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := stats[name]
+		log.Info("telemetry %s: count:%d, sum:%g, min:%g, max:%g, last:%g",
+			name, s.Count, s.Sum, s.Min, s.Max, s.Last)
+	}
+	if dropped != 0 {
+		log.Warn("telemetry dropped %d malformed samples", dropped)
+	}
+}
+
+func (h *telemetryHandler) OnMessage(session getty.Session, pkg interface{}) {
+	ctx, ok := pkg.(getty.UDPContext)
+	if !ok {
+		log.Error("illegal telemetry pkg %#v", pkg)
+		return
+	}
+	batch := ctx.Pkg.(telemetryBatch)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, sample := range batch.Samples {
+		stat, ok := h.stats[sample.Name]
+		if !ok {
+			stat = &telemetryStat{}
+			h.stats[sample.Name] = stat
+		}
+		stat.add(sample.Value)
+	}
+	h.dropped += int64(batch.Dropped)
+}
+
+// a copy of the aggregation & the number of the dropped samples
+func (h *telemetryHandler) snapshot() (map[string]telemetryStat, int64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	stats := make(map[string]telemetryStat, len(h.stats))
+	for name, stat := range h.stats {
+		stats[name] = *stat
+	}
+	return stats, h.dropped
+}
+
+func runTelemetry(args []string) (*exampleServer, error) {
+	config := serverConfig{network: "udp"}
+	if err := parseFlags("telemetry", args, &config, "127.0.0.1:10002"); err != nil {
+		return nil, err
+	}
+
+	server, _ := newTelemetryServer(config)
+	return server, nil
+}
+
+// the udp session of the server never times out, so its cron only logs the aggregation.
+func newTelemetryServer(config serverConfig) (*exampleServer, *telemetryHandler) {
+	config.network = "udp"
+	handler := newTelemetryHandler()
+	return startServer(config, func(session getty.Session) {
+		session.SetPkgHandler(telemetryCodec{})
+		session.SetEventListener(handler)
+	}), handler
+}
@@ -226,6 +226,18 @@ func (c *Client) AsyncCall(ctx context.Context, typ rpc.CodecType, service, vers
 	return jerrors.Trace(c.Client.AsyncCall(typ, addr, service, method, args, callback, reply, opts...))
 }
 
+func (c *Client) CallStream(ctx context.Context, typ rpc.CodecType, service, version, method string,
+	args interface{}, opts ...rpc.CallOption) (*rpc.ClientStream, error) {
+
+	addr, err := c.getServiceAddr(ctx, typ, service, version)
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+
+	stream, err := c.Client.CallStream(typ, addr, service, method, args, opts...)
+	return stream, jerrors.Trace(err)
+}
+
 func (c *Client) Close() {
 	c.filter.Close()
 	c.registry.Close()
@@ -0,0 +1,1009 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+////////////////////////////////////////////
+//  cbor codec
+////////////////////////////////////////////
+
+// the major types of cbor
+const (
+	cborUint byte = iota
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+const (
+	cborFalse     = 0xf4
+	cborTrue      = 0xf5
+	cborNull      = 0xf6
+	cborUndefined = 0xf7
+	cborFloat16   = 0xf9
+	cborFloat32   = 0xfa
+	cborFloat64   = 0xfb
+	cborBreak     = 0xff
+
+	// the additional information of the indefinite length items
+	cborIndefinite = 31
+	// the max nesting depth of the arrays, maps & tags
+	maxCBORDepth = 128
+)
+
+var (
+	errCBORTruncated = jerrors.New("cbor: unexpected end of data")
+	errCBORIllegal   = jerrors.New("cbor: malformed data")
+	errCBORDepth     = jerrors.New("cbor: exceeded max nesting depth")
+)
+
+// CBORCodec is the codec of CBOR(RFC 8949). The structs are encoded as the maps keyed by the
+// field names, which can be renamed or omitted by the "cbor" tags or else the "json" tags, e.g.
+//
+//	type Reading struct {
+//		Device string  `cbor:"dev"`
+//		Value  float64 `cbor:"v,omitempty"`
+//	}
+//
+// The arrays & maps of any type are decoded into interface{} as []interface{} and
+// map[interface{}]interface{}, and the tags are ignored, so the COSE messages are decoded as
+// their contents.
+type CBORCodec struct {
+	// encode by the core deterministic encoding requirements of RFC 8949 4.2.1, i.e. the
+	// shortest heads, the floats in the shortest forms which keep their values and the map
+	// keys sorted by their encoded bytes, so the equal values are always encoded into the same
+	// bytes, which can be signed, e.g. the payloads of COSE. The decoding is not affected.
+	Canonical bool
+}
+
+func (c CBORCodec) Encode(i interface{}) ([]byte, error) {
+	e := cborEncoder{canonical: c.Canonical}
+	if err := e.encode(reflect.ValueOf(i)); err != nil {
+		return nil, err
+	}
+
+	return e.buf, nil
+}
+
+func (c CBORCodec) Decode(data []byte, i interface{}) (err error) {
+	v := reflect.ValueOf(i)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return jerrors.Errorf("cbor: can not decode into %T", i)
+	}
+	// the reflection panics on the values which can not be set, e.g. the unexported fields
+	defer func() {
+		if r := recover(); r != nil {
+			err = jerrors.Errorf("cbor decode panic: %v", r)
+		}
+	}()
+
+	d := cborDecoder{data: data}
+	if err = d.decode(v.Elem()); err != nil {
+		return err
+	}
+	if d.off != len(d.data) {
+		return jerrors.Annotatef(errCBORIllegal, "%d bytes of extra data", len(d.data)-d.off)
+	}
+
+	return nil
+}
+
+////////////////////////////////////////////
+//  cbor struct fields
+////////////////////////////////////////////
+
+type cborField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+type cborFields struct {
+	// in the declaration order
+	list []cborField
+	// in the order of their encoded keys
+	sorted []cborField
+}
+
+var cborFieldCache sync.Map // map[reflect.Type]*cborFields
+
+func getCBORFields(typ reflect.Type) *cborFields {
+	if fields, ok := cborFieldCache.Load(typ); ok {
+		return fields.(*cborFields)
+	}
+
+	fields := &cborFields{list: collectCBORFields(typ, nil)}
+	fields.sorted = append([]cborField(nil), fields.list...)
+	// the encoded text keys are sorted by their lengths at first
+	sort.Slice(fields.sorted, func(i, j int) bool {
+		a, b := fields.sorted[i].name, fields.sorted[j].name
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	cborFieldCache.Store(typ, fields)
+
+	return fields
+}
+
+func collectCBORFields(typ reflect.Type, index []int) []cborField {
+	var fields []cborField
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag, ok := f.Tag.Lookup("cbor")
+		if !ok {
+			tag = f.Tag.Get("json")
+		}
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.IndexByte(tag, ','); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+
+		fieldIndex := append(append([]int(nil), index...), i)
+		// the fields of the untagged embedded structs are promoted as the ones of json
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, collectCBORFields(f.Type, fieldIndex)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, cborField{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+		})
+	}
+
+	return fields
+}
+
+func (fs *cborFields) lookup(name string) *cborField {
+	for i := range fs.list {
+		if fs.list[i].name == name {
+			return &fs.list[i]
+		}
+	}
+	for i := range fs.list {
+		if strings.EqualFold(fs.list[i].name, name) {
+			return &fs.list[i]
+		}
+	}
+
+	return nil
+}
+
+func isEmptyCBORValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+////////////////////////////////////////////
+//  cbor encoder
+////////////////////////////////////////////
+
+type cborEncoder struct {
+	buf       []byte
+	canonical bool
+	depth     int
+}
+
+// append the head of major type @major with argument @n in the shortest form
+func (e *cborEncoder) head(major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		e.buf = append(e.buf, m|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, m|24, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, m|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		e.buf = append(e.buf, m|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	default:
+		e.buf = append(e.buf, m|27, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(e.buf[len(e.buf)-8:], n)
+	}
+}
+
+func (e *cborEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf = append(e.buf, cborNull)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, cborTrue)
+		} else {
+			e.buf = append(e.buf, cborFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := v.Int(); i >= 0 {
+			e.head(cborUint, uint64(i))
+		} else {
+			e.head(cborNegInt, uint64(-1-i))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.head(cborUint, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.float(v.Float(), v.Kind() == reflect.Float32)
+	case reflect.String:
+		e.head(cborText, uint64(v.Len()))
+		e.buf = append(e.buf, v.String()...)
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, cborNull)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.head(cborBytes, uint64(v.Len()))
+			e.buf = append(e.buf, v.Bytes()...)
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.head(cborBytes, uint64(len(b)))
+			e.buf = append(e.buf, b...)
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, cborNull)
+			return nil
+		}
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.buf = append(e.buf, cborNull)
+			return nil
+		}
+		if err := e.enter(); err != nil {
+			return err
+		}
+		defer e.leave()
+		return e.encode(v.Elem())
+	default:
+		return jerrors.Errorf("cbor: can not encode %s", v.Type())
+	}
+
+	return nil
+}
+
+func (e *cborEncoder) enter() error {
+	e.depth++
+	if e.depth > maxCBORDepth {
+		return errCBORDepth
+	}
+	return nil
+}
+
+func (e *cborEncoder) leave() {
+	e.depth--
+}
+
+func (e *cborEncoder) float(f float64, isFloat32 bool) {
+	if !e.canonical {
+		if isFloat32 {
+			e.buf = append(e.buf, cborFloat32, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(e.buf[len(e.buf)-4:], math.Float32bits(float32(f)))
+		} else {
+			e.buf = append(e.buf, cborFloat64, 0, 0, 0, 0, 0, 0, 0, 0)
+			binary.BigEndian.PutUint64(e.buf[len(e.buf)-8:], math.Float64bits(f))
+		}
+		return
+	}
+
+	// the shortest form which keeps the value, and all the NaNs are encoded as the quiet NaN
+	if math.IsNaN(f) {
+		e.buf = append(e.buf, cborFloat16, 0x7e, 0x00)
+		return
+	}
+	if f32 := float32(f); float64(f32) == f {
+		if h, ok := float32ToFloat16(f32); ok {
+			e.buf = append(e.buf, cborFloat16, byte(h>>8), byte(h))
+			return
+		}
+		e.buf = append(e.buf, cborFloat32, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(e.buf[len(e.buf)-4:], math.Float32bits(f32))
+		return
+	}
+	e.buf = append(e.buf, cborFloat64, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(e.buf[len(e.buf)-8:], math.Float64bits(f))
+}
+
+// convert the non-NaN @f into the half precision float if it keeps the value of @f
+func float32ToFloat16(f float32) (uint16, bool) {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23) & 0xff
+	mant := bits & 0x7fffff
+
+	switch {
+	case exp == 0xff: // infinity
+		return sign | 0x7c00, true
+	case exp == 0 && mant == 0:
+		return sign, true
+	case exp == 0: // the subnormals of float32 are too small for float16
+		return 0, false
+	}
+
+	exp -= 127
+	switch {
+	case 15 < exp:
+		return 0, false
+	case -14 <= exp: // normal
+		if mant&0x1fff != 0 {
+			return 0, false
+		}
+		return sign | uint16(exp+15)<<10 | uint16(mant>>13), true
+	case -24 <= exp: // subnormal
+		full := mant | 0x800000
+		shift := uint(-exp - 1)
+		if full&(1<<shift-1) != 0 {
+			return 0, false
+		}
+		return sign | uint16(full>>shift), true
+	}
+
+	return 0, false
+}
+
+func float16ToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+
+	return f
+}
+
+func (e *cborEncoder) encodeArray(v reflect.Value) error {
+	if err := e.enter(); err != nil {
+		return err
+	}
+	defer e.leave()
+
+	e.head(cborArray, uint64(v.Len()))
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *cborEncoder) encodeMap(v reflect.Value) error {
+	if err := e.enter(); err != nil {
+		return err
+	}
+	defer e.leave()
+
+	e.head(cborMap, uint64(v.Len()))
+	if !e.canonical {
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encode(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encode(iter.Value()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// encode the pairs after the head, and sort them by their encoded keys
+	type pair struct {
+		key, item []byte
+	}
+	var (
+		start = len(e.buf)
+		pairs = make([]pair, 0, v.Len())
+		iter  = v.MapRange()
+	)
+	for iter.Next() {
+		offset := len(e.buf)
+		if err := e.encode(iter.Key()); err != nil {
+			return err
+		}
+		keyLen := len(e.buf) - offset
+		if err := e.encode(iter.Value()); err != nil {
+			return err
+		}
+		item := e.buf[offset:]
+		pairs = append(pairs, pair{key: item[:keyLen], item: item})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return bytes.Compare(pairs[i].key, pairs[j].key) < 0
+	})
+	for i := 1; i < len(pairs); i++ {
+		if bytes.Equal(pairs[i-1].key, pairs[i].key) {
+			return jerrors.Errorf("cbor: duplicate map key of %s", v.Type())
+		}
+	}
+
+	sorted := make([]byte, 0, len(e.buf)-start)
+	for _, p := range pairs {
+		sorted = append(sorted, p.item...)
+	}
+	e.buf = append(e.buf[:start], sorted...)
+
+	return nil
+}
+
+func (e *cborEncoder) encodeStruct(v reflect.Value) error {
+	if err := e.enter(); err != nil {
+		return err
+	}
+	defer e.leave()
+
+	fields := getCBORFields(v.Type())
+	list := fields.list
+	if e.canonical {
+		list = fields.sorted
+	}
+
+	values := make([]reflect.Value, len(list))
+	num := 0
+	for i, f := range list {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && isEmptyCBORValue(fv) {
+			continue
+		}
+		values[i] = fv
+		num++
+	}
+
+	e.head(cborMap, uint64(num))
+	for i, f := range list {
+		if !values[i].IsValid() {
+			continue
+		}
+		e.head(cborText, uint64(len(f.name)))
+		e.buf = append(e.buf, f.name...)
+		if err := e.encode(values[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+////////////////////////////////////////////
+//  cbor decoder
+////////////////////////////////////////////
+
+type cborDecoder struct {
+	data  []byte
+	off   int
+	depth int
+}
+
+func (d *cborDecoder) enter() error {
+	d.depth++
+	if d.depth > maxCBORDepth {
+		return errCBORDepth
+	}
+	return nil
+}
+
+func (d *cborDecoder) leave() {
+	d.depth--
+}
+
+func (d *cborDecoder) remaining() uint64 {
+	return uint64(len(d.data) - d.off)
+}
+
+func (d *cborDecoder) peek() (byte, error) {
+	if d.off >= len(d.data) {
+		return 0, errCBORTruncated
+	}
+	return d.data[d.off], nil
+}
+
+// read the head of the next item. The argument @n of the indefinite items is 0.
+func (d *cborDecoder) head() (major byte, info byte, n uint64, err error) {
+	if d.off >= len(d.data) {
+		return 0, 0, 0, errCBORTruncated
+	}
+	b := d.data[d.off]
+	d.off++
+	major, info = b>>5, b&0x1f
+
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	case info == cborIndefinite:
+		switch major {
+		case cborBytes, cborText, cborArray, cborMap, cborSimple:
+			return major, info, 0, nil
+		}
+		return 0, 0, 0, jerrors.Annotatef(errCBORIllegal, "indefinite length of major type %d", major)
+	default:
+		return 0, 0, 0, jerrors.Annotatef(errCBORIllegal, "additional information %d", info)
+	}
+
+	if len(d.data)-d.off < size {
+		return 0, 0, 0, errCBORTruncated
+	}
+	arg := d.data[d.off : d.off+size]
+	d.off += size
+	switch size {
+	case 1:
+		n = uint64(arg[0])
+	case 2:
+		n = uint64(binary.BigEndian.Uint16(arg))
+	case 4:
+		n = uint64(binary.BigEndian.Uint32(arg))
+	default:
+		n = binary.BigEndian.Uint64(arg)
+	}
+
+	return major, info, n, nil
+}
+
+// read the content of the byte or text string of the head (@major, @info, @n)
+func (d *cborDecoder) str(major, info byte, n uint64) ([]byte, error) {
+	if info != cborIndefinite {
+		if d.remaining() < n {
+			return nil, errCBORTruncated
+		}
+		s := d.data[d.off : d.off+int(n)]
+		d.off += int(n)
+		if major == cborText && !utf8.Valid(s) {
+			return nil, jerrors.Annotate(errCBORIllegal, "invalid utf-8 text")
+		}
+		return s, nil
+	}
+
+	// the chunks of an indefinite string are the definite strings of the same major type
+	var s []byte
+	for {
+		b, err := d.peek()
+		if err != nil {
+			return nil, err
+		}
+		if b == cborBreak {
+			d.off++
+			return s, nil
+		}
+		chunkMajor, chunkInfo, chunkLen, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if chunkMajor != major || chunkInfo == cborIndefinite {
+			return nil, jerrors.Annotate(errCBORIllegal, "illegal chunk of indefinite string")
+		}
+		chunk, err := d.str(chunkMajor, chunkInfo, chunkLen)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, chunk...)
+	}
+}
+
+// whether the next item of the container of the head (@info, @n) exists after @i items
+func (d *cborDecoder) more(info byte, n uint64, i uint64) (bool, error) {
+	if info != cborIndefinite {
+		return i < n, nil
+	}
+
+	b, err := d.peek()
+	if err != nil {
+		return false, err
+	}
+	if b == cborBreak {
+		d.off++
+		return false, nil
+	}
+	return true, nil
+}
+
+// check the item number @n of a definite container whose items are @size bytes at least
+func (d *cborDecoder) checkLen(info byte, n uint64, size uint64) error {
+	if info != cborIndefinite && d.remaining()/size < n {
+		return errCBORTruncated
+	}
+	return nil
+}
+
+func (d *cborDecoder) typeError(what string, v reflect.Value) error {
+	return jerrors.Errorf("cbor: can not decode %s into %s", what, v.Type())
+}
+
+func (d *cborDecoder) decode(v reflect.Value) error {
+	b, err := d.peek()
+	if err != nil {
+		return err
+	}
+	if b == cborNull || b == cborUndefined {
+		d.off++
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			value, err := d.decodeAny()
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				v.Set(reflect.Zero(v.Type()))
+			} else {
+				v.Set(reflect.ValueOf(value))
+			}
+			return nil
+		}
+		if !v.IsNil() && v.Elem().Kind() == reflect.Ptr {
+			return d.decode(v.Elem())
+		}
+		return jerrors.Errorf("cbor: can not decode into %s", v.Type())
+	}
+
+	major, info, n, err := d.head()
+	if err != nil {
+		return err
+	}
+	switch major {
+	case cborUint:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if n > math.MaxInt64 || v.OverflowInt(int64(n)) {
+				return jerrors.Errorf("cbor: %d overflows %s", n, v.Type())
+			}
+			v.SetInt(int64(n))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if v.OverflowUint(n) {
+				return jerrors.Errorf("cbor: %d overflows %s", n, v.Type())
+			}
+			v.SetUint(n)
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(float64(n))
+		default:
+			return d.typeError("unsigned integer", v)
+		}
+	case cborNegInt:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if n > math.MaxInt64 || v.OverflowInt(-1-int64(n)) {
+				return jerrors.Errorf("cbor: -1-%d overflows %s", n, v.Type())
+			}
+			v.SetInt(-1 - int64(n))
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(-1 - float64(n))
+		default:
+			return d.typeError("negative integer", v)
+		}
+	case cborBytes:
+		s, err := d.str(major, info, n)
+		if err != nil {
+			return err
+		}
+		switch {
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(append([]byte{}, s...))
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+			if len(s) != v.Len() {
+				return jerrors.Errorf("cbor: can not decode %d bytes into %s", len(s), v.Type())
+			}
+			reflect.Copy(v, reflect.ValueOf(s))
+		default:
+			return d.typeError("byte string", v)
+		}
+	case cborText:
+		s, err := d.str(major, info, n)
+		if err != nil {
+			return err
+		}
+		if v.Kind() != reflect.String {
+			return d.typeError("text string", v)
+		}
+		v.SetString(string(s))
+	case cborArray:
+		return d.decodeArray(v, info, n)
+	case cborMap:
+		return d.decodeMap(v, info, n)
+	case cborTag:
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		// the tag number is ignored, and its content is decoded
+		return d.decode(v)
+	default:
+		return d.decodeSimple(v, info, n)
+	}
+
+	return nil
+}
+
+func (d *cborDecoder) decodeSimple(v reflect.Value, info byte, n uint64) error {
+	switch info {
+	case cborFalse & 0x1f, cborTrue & 0x1f:
+		if v.Kind() != reflect.Bool {
+			return d.typeError("bool", v)
+		}
+		v.SetBool(info == cborTrue&0x1f)
+	case cborFloat16 & 0x1f, cborFloat32 & 0x1f, cborFloat64 & 0x1f:
+		if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+			return d.typeError("float", v)
+		}
+		v.SetFloat(cborFloat(info, n))
+	default:
+		return jerrors.Annotatef(errCBORIllegal, "simple value %d", n)
+	}
+
+	return nil
+}
+
+// the float of the head (@info, @n) of major type 7
+func cborFloat(info byte, n uint64) float64 {
+	switch info {
+	case cborFloat16 & 0x1f:
+		return float16ToFloat64(uint16(n))
+	case cborFloat32 & 0x1f:
+		return float64(math.Float32frombits(uint32(n)))
+	}
+
+	return math.Float64frombits(n)
+}
+
+func (d *cborDecoder) decodeArray(v reflect.Value, info byte, n uint64) error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	if err := d.checkLen(info, n, 1); err != nil {
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.Slice:
+		if info == cborIndefinite {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+		} else {
+			v.Set(reflect.MakeSlice(v.Type(), int(n), int(n)))
+		}
+	case reflect.Array:
+	default:
+		return d.typeError("array", v)
+	}
+
+	var i uint64
+	for ; ; i++ {
+		ok, err := d.more(info, n, i)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		switch {
+		case v.Kind() == reflect.Array && i >= uint64(v.Len()):
+			// the extra items of the arrays are dropped as the ones of json
+			if _, err = d.decodeAny(); err != nil {
+				return err
+			}
+			continue
+		case v.Kind() == reflect.Slice && info == cborIndefinite:
+			v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+		}
+		if err = d.decode(v.Index(int(i))); err != nil {
+			return err
+		}
+	}
+	if v.Kind() == reflect.Array {
+		for ; i < uint64(v.Len()); i++ {
+			v.Index(int(i)).Set(reflect.Zero(v.Type().Elem()))
+		}
+	}
+
+	return nil
+}
+
+func (d *cborDecoder) decodeMap(v reflect.Value, info byte, n uint64) error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	if err := d.checkLen(info, n, 2); err != nil {
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		typ := v.Type()
+		for i := uint64(0); ; i++ {
+			ok, err := d.more(info, n, i)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+			key := reflect.New(typ.Key()).Elem()
+			if err = d.decode(key); err != nil {
+				return err
+			}
+			if typ.Key().Kind() == reflect.Interface && key.Elem().IsValid() && !key.Elem().Type().Comparable() {
+				return jerrors.Annotatef(errCBORIllegal, "map key of %s", key.Elem().Type())
+			}
+			value := reflect.New(typ.Elem()).Elem()
+			if err = d.decode(value); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, value)
+		}
+	case reflect.Struct:
+		fields := getCBORFields(v.Type())
+		for i := uint64(0); ; i++ {
+			ok, err := d.more(info, n, i)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+			key, err := d.decodeAny()
+			if err != nil {
+				return err
+			}
+			name, ok := key.(string)
+			var f *cborField
+			if ok {
+				f = fields.lookup(name)
+			}
+			if f == nil {
+				// the unknown fields are skipped
+				if _, err = d.decodeAny(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err = d.decode(v.FieldByIndex(f.index)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return d.typeError("map", v)
+}
+
+// decode the next item into the generic value, i.e. uint64, int64, []byte, string,
+// []interface{}, map[interface{}]interface{}, bool, float64 or nil
+func (d *cborDecoder) decodeAny() (interface{}, error) {
+	major, info, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		return n, nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return nil, jerrors.Errorf("cbor: -1-%d overflows int64", n)
+		}
+		return -1 - int64(n), nil
+	case cborBytes:
+		s, err := d.str(major, info, n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, s...), nil
+	case cborText:
+		s, err := d.str(major, info, n)
+		if err != nil {
+			return nil, err
+		}
+		return string(s), nil
+	case cborArray:
+		var array []interface{}
+		err = d.decodeArray(reflect.ValueOf(&array).Elem(), info, n)
+		return array, err
+	case cborMap:
+		var m map[interface{}]interface{}
+		err = d.decodeMap(reflect.ValueOf(&m).Elem(), info, n)
+		return m, err
+	case cborTag:
+		if err = d.enter(); err != nil {
+			return nil, err
+		}
+		defer d.leave()
+		return d.decodeAny()
+	}
+
+	switch info {
+	case cborFalse & 0x1f:
+		return false, nil
+	case cborTrue & 0x1f:
+		return true, nil
+	case cborNull & 0x1f, cborUndefined & 0x1f:
+		return nil, nil
+	case cborFloat16 & 0x1f, cborFloat32 & 0x1f, cborFloat64 & 0x1f:
+		return cborFloat(info, n), nil
+	}
+
+	return nil, jerrors.Annotatef(errCBORIllegal, "simple value %d", n)
+}
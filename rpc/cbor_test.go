@@ -0,0 +1,220 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type cborReading struct {
+	Device  string            `cbor:"dev"`
+	Value   float64           `cbor:"v,omitempty"`
+	Tags    []string          `json:"tags"`
+	Raw     []byte            `cbor:"raw,omitempty"`
+	Labels  map[string]uint16 `cbor:"labels"`
+	Next    *cborReading      `cbor:"next,omitempty"`
+	Ignored int               `json:"-"`
+	secret  int
+}
+
+func TestCBORCodec_Vectors(t *testing.T) {
+	// the examples of RFC 8949 Appendix A
+	vectors := []struct {
+		value interface{}
+		hex   string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000, "1903e8"},
+		{1000000, "1a000f4240"},
+		{uint64(18446744073709551615), "1bffffffffffffffff"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{int64(math.MinInt64), "3b7fffffffffffffff"},
+		{0.0, "f90000"},
+		{math.Copysign(0, -1), "f98000"},
+		{1.0, "f93c00"},
+		{1.1, "fb3ff199999999999a"},
+		{1.5, "f93e00"},
+		{65504.0, "f97bff"},
+		{100000.0, "fa47c35000"},
+		{3.4028234663852886e+38, "fa7f7fffff"},
+		{1.0e+300, "fb7e37e43c8800759c"},
+		{5.960464477539063e-8, "f90001"},
+		{0.00006103515625, "f90400"},
+		{-4.0, "f9c400"},
+		{math.Inf(1), "f97c00"},
+		{math.NaN(), "f97e00"},
+		{math.Inf(-1), "f9fc00"},
+		{false, "f4"},
+		{true, "f5"},
+		{nil, "f6"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{"", "60"},
+		{"IETF", "6449455446"},
+		{"ü", "62c3bc"},
+		{[]int{}, "80"},
+		{[]interface{}{1, []int{2, 3}, []int{4, 5}}, "8301820203820405"},
+		{map[string]interface{}{"a": 1, "b": []int{2, 3}}, "a26161016162820203"},
+		{map[int]int{1: 2, 3: 4}, "a201020304"},
+	}
+
+	codec := CBORCodec{Canonical: true}
+	for _, v := range vectors {
+		data, err := codec.Encode(v.value)
+		assert.Nil(t, err)
+		assert.Equal(t, v.hex, hex.EncodeToString(data), "%v", v.value)
+	}
+}
+
+func TestCBORCodec_Canonical(t *testing.T) {
+	m := map[interface{}]interface{}{
+		"aa": 1, "b": 2, 10: 3, -1: 4, 100: 5, false: 6, [1]byte{1}: 7,
+	}
+	// the keys are sorted by their encoded bytes
+	expected := "a7" + "0a03" + "186405" + "2004" + "410107" + "616202" + "62616101" + "f406"
+	for i := 0; i < 8; i++ {
+		data, err := CBORCodec{Canonical: true}.Encode(m)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, hex.EncodeToString(data))
+	}
+
+	// the equal keys of different types are not allowed
+	_, err := CBORCodec{Canonical: true}.Encode(map[interface{}]int{1: 1, uint(1): 2})
+	assert.NotNil(t, err)
+
+	// the struct fields are sorted by their encoded names
+	reading := cborReading{Device: "d1", Value: 0.5, Labels: map[string]uint16{"z": 1, "a": 2}}
+	data, err := CBORCodec{Canonical: true}.Encode(&reading)
+	assert.Nil(t, err)
+	assert.Equal(t, "a4"+"6176f93800"+"63646576626431"+"6474616773f6"+"666c6162656c73a2616102617a01",
+		hex.EncodeToString(data))
+
+	// the floats keep their widths without the canonical mode
+	data, err = CBORCodec{}.Encode([]interface{}{float32(1.5), 1.5})
+	assert.Nil(t, err)
+	assert.Equal(t, "82"+"fa3fc00000"+"fb3ff8000000000000", hex.EncodeToString(data))
+}
+
+func TestCBORCodec_Decode(t *testing.T) {
+	codec := CBORCodec{Canonical: true}
+	reading := cborReading{
+		Device:  "d1",
+		Value:   -2.75,
+		Tags:    []string{"a", "b"},
+		Raw:     []byte{0xde, 0xad},
+		Labels:  map[string]uint16{"x": 7},
+		Next:    &cborReading{Device: "d2"},
+		Ignored: 1,
+		secret:  2,
+	}
+	data, err := codec.Encode(&reading)
+	assert.Nil(t, err)
+
+	var decoded cborReading
+	assert.Nil(t, codec.Decode(data, &decoded))
+	reading.Ignored, reading.secret = 0, 0
+	assert.Equal(t, reading, decoded)
+
+	// the generic values
+	var value interface{}
+	assert.Nil(t, codec.Decode(mustDecodeHex(t, "a26161016162820203"), &value))
+	assert.Equal(t, map[interface{}]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}, value)
+
+	// the indefinite lengths & the tags, e.g. a COSE_Sign1 message of tag 18
+	var array []interface{}
+	assert.Nil(t, codec.Decode(mustDecodeHex(t, "d2"+"9f"+"5f42010243030405ff"+"7f657374726561646d696e67ff"+"bf6346756ef5ff"+"ff"), &array))
+	assert.Equal(t, []interface{}{[]byte{1, 2, 3, 4, 5}, "streaming", map[interface{}]interface{}{"Fun": true}}, array)
+
+	// the fields are matched case-insensitively, and the unknown ones are skipped
+	decoded = cborReading{}
+	assert.Nil(t, codec.Decode(mustDecodeHex(t, "a3"+"63444556626433"+"63666f6f820102"+"617606"), &decoded))
+	assert.Equal(t, cborReading{Device: "d3", Value: 6}, decoded)
+
+	// null resets the values
+	decoded = cborReading{Device: "d4", Next: &cborReading{}}
+	assert.Nil(t, codec.Decode(mustDecodeHex(t, "a2"+"63646576f6"+"646e657874f6"), &decoded))
+	assert.Equal(t, cborReading{}, decoded)
+}
+
+func TestCBORCodec_Malformed(t *testing.T) {
+	codec := CBORCodec{}
+	var (
+		value interface{}
+		i8    int8
+		u     uint
+		s     string
+	)
+	for _, data := range []string{
+		"",                   // empty
+		"18",                 // truncated argument
+		"1c",                 // reserved additional information
+		"1f",                 // indefinite integer
+		"62c3",               // truncated string
+		"62c328",             // invalid utf-8
+		"5f4101ff00",         // extra data
+		"5f6161ff",           // text chunk of byte string
+		"9b00000000ffffffff", // too many items
+		"9f01",               // unterminated indefinite array
+		"a1818080",           // unhashable key
+		"ff",                 // break
+		"fc",                 // reserved simple value
+		"3bffffffffffffffff", // overflowed negative integer
+		strings.Repeat("c0", maxCBORDepth+1) + "00", // too deep
+	} {
+		assert.NotNil(t, codec.Decode(mustDecodeHex(t, data), &value), data)
+	}
+
+	assert.NotNil(t, codec.Decode(mustDecodeHex(t, "190100"), &i8))
+	assert.NotNil(t, codec.Decode(mustDecodeHex(t, "20"), &u))
+	assert.NotNil(t, codec.Decode(mustDecodeHex(t, "01"), &s))
+	assert.NotNil(t, codec.Decode(mustDecodeHex(t, "01"), s))
+	assert.NotNil(t, codec.Decode(mustDecodeHex(t, "01"), nil))
+
+	// the cyclic values can not be encoded
+	cyclic := &cborReading{}
+	cyclic.Next = cyclic
+	_, err := codec.Encode(cyclic)
+	assert.NotNil(t, err)
+	_, err = codec.Encode(make(chan int))
+	assert.NotNil(t, err)
+}
+
+func TestCBORCodec_Package(t *testing.T) {
+	assert.Equal(t, "cbor", CodecType(CodecCBOR).String())
+	assert.Equal(t, CodecType(CodecCBOR), GetCodecType("cbor"))
+
+	pkg := GettyPackage{
+		H: GettyPackageHeader{Magic: gettyPackageMagic, Command: gettyCmdRPCRequest, CodecType: CodecCBOR},
+		B: &GettyRPCRequest{
+			header: GettyRPCRequestHeader{Service: "Test", Method: "Count", CallType: CT_TwoWay},
+			body:   &CountReq{N: 3},
+		},
+	}
+	buf, err := pkg.Marshal()
+	assert.Nil(t, err)
+
+	decoded := GettyPackage{B: NewGettyRPCRequest()}
+	n, err := decoded.Unmarshal(bytes.NewBuffer(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, buf.Len(), n)
+	req := decoded.B.(*GettyRPCRequest)
+	assert.Equal(t, pkg.B.(*GettyRPCRequest).header, req.header)
+
+	var countReq CountReq
+	assert.Nil(t, GetCodec(CodecCBOR).Decode(req.GetBody(), &countReq))
+	assert.Equal(t, CountReq{N: 3}, countReq)
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	data, err := hex.DecodeString(s)
+	assert.Nil(t, err)
+	return data
+}
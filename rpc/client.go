@@ -106,7 +106,7 @@ func (c *Client) CallOneway(typ CodecType, addr, service, method string, args in
 		o(&copts)
 	}
 
-	return jerrors.Trace(c.call(CT_OneWay, typ, addr, service, method, args, nil, nil, copts))
+	return jerrors.Trace(c.call(CT_OneWay, typ, addr, service, method, args, nil, nil, nil, copts))
 }
 
 // if @reply is nil, the transport layer will get the response without notify the invoker.
@@ -122,7 +122,7 @@ func (c *Client) Call(typ CodecType, addr, service, method string, args, reply i
 		ct = CT_TwoWayNoReply
 	}
 
-	return jerrors.Trace(c.call(ct, typ, addr, service, method, args, reply, nil, copts))
+	return jerrors.Trace(c.call(ct, typ, addr, service, method, args, reply, nil, nil, copts))
 }
 
 func (c *Client) AsyncCall(typ CodecType, addr, service, method string, args interface{},
@@ -133,11 +133,31 @@ func (c *Client) AsyncCall(typ CodecType, addr, service, method string, args int
 		o(&copts)
 	}
 
-	return jerrors.Trace(c.call(CT_TwoWay, typ, addr, service, method, args, reply, callback, copts))
+	return jerrors.Trace(c.call(CT_TwoWay, typ, addr, service, method, args, reply, callback, nil, copts))
+}
+
+// CallStream invokes a streaming method, whose reply type is *ResponseStream, and returns
+// the stream of its response frames. The stream should be closed if it is not read to the
+// end. A unary method can be invoked by CallStream too, and its reply is the only frame.
+// The response timeout is the max interval between the frames.
+func (c *Client) CallStream(typ CodecType, addr, service, method string, args interface{},
+	opts ...CallOption) (*ClientStream, error) {
+
+	var copts CallOptions
+	for _, o := range opts {
+		o(&copts)
+	}
+
+	stream := newClientStream(c, typ)
+	if err := c.call(CT_TwoWay, typ, addr, service, method, args, nil, nil, stream, copts); err != nil {
+		return nil, jerrors.Trace(err)
+	}
+
+	return stream, nil
 }
 
 func (c *Client) call(ct CallType, typ CodecType, addr, service, method string,
-	args, reply interface{}, callback AsyncCallback, opts CallOptions) error {
+	args, reply interface{}, callback AsyncCallback, stream *ClientStream, opts CallOptions) error {
 
 	if opts.RequestTimeout == 0 {
 		opts.RequestTimeout = c.conf.GettySessionParam.tcpWriteTimeout
@@ -160,8 +180,13 @@ func (c *Client) call(ct CallType, typ CodecType, addr, service, method string,
 		rsp = NewPendingResponse()
 		rsp.reply = reply
 		rsp.callback = callback
+		rsp.stream = stream
 		rsp.opts = opts
 	}
+	if stream != nil {
+		stream.rsp = rsp
+		stream.timeout = opts.ResponseTimeout
+	}
 
 	var (
 		err     error
@@ -187,7 +212,7 @@ func (c *Client) call(ct CallType, typ CodecType, addr, service, method string,
 		return jerrors.Trace(err)
 	}
 
-	if ct == CT_OneWay || callback != nil {
+	if ct == CT_OneWay || callback != nil || stream != nil {
 		return nil
 	}
 
@@ -268,6 +293,12 @@ func (c *Client) addPendingResponse(pr *PendingResponse) {
 	c.pendingResponses[pr.seq] = pr
 }
 
+func (c *Client) getPendingResponse(seq SequenceType) *PendingResponse {
+	c.pendingLock.RLock()
+	defer c.pendingLock.RUnlock()
+	return c.pendingResponses[seq]
+}
+
 func (c *Client) removePendingResponse(seq SequenceType) *PendingResponse {
 	c.pendingLock.Lock()
 	defer c.pendingLock.Unlock()
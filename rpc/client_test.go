@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"io"
 	"net"
 	"testing"
 	"time"
@@ -96,6 +97,21 @@ func (suite *ClientTestSuite) TestClient_Json_Call() {
 	suite.Nil(err)
 }
 
+func (suite *ClientTestSuite) TestClient_RegisteredCodec_CallStream() {
+	ts := MockService{}
+	addr := net.JoinHostPort(suite.serverConf.Host, suite.serverConf.Ports[0])
+
+	stream, err := suite.client.CallStream(codecGob, addr, ts.Service(), "Count", &CountReq{N: 3},
+		WithCallRequestTimeout(100e6), WithCallResponseTimeout(1e9))
+	suite.Nil(err)
+	var rsp CountRsp
+	for i := 0; i < 3; i++ {
+		suite.Nil(stream.Recv(&rsp))
+		suite.Equal(i, rsp.I)
+	}
+	suite.Equal(io.EOF, stream.Recv(&rsp))
+}
+
 func (suite *ClientTestSuite) TestClient_Json_AsyncCall() {
 	var err error
 	ts := MockService{}
@@ -110,6 +126,51 @@ func (suite *ClientTestSuite) TestClient_Json_AsyncCall() {
 	suite.Nil(err)
 }
 
+func (suite *ClientTestSuite) TestClient_Json_CallStream() {
+	ts := MockService{}
+	addr := net.JoinHostPort(suite.serverConf.Host, suite.serverConf.Ports[0])
+
+	stream, err := suite.client.CallStream(CodecJson, addr, ts.Service(), "Count", &CountReq{N: 100},
+		WithCallRequestTimeout(100e6), WithCallResponseTimeout(500e6))
+	suite.Nil(err)
+	var rsp CountRsp
+	for i := 0; i < 100; i++ {
+		suite.Nil(stream.Recv(&rsp))
+		suite.Equal(i, rsp.I)
+	}
+	suite.Equal(io.EOF, stream.Recv(&rsp))
+	suite.Equal(io.EOF, stream.Recv(&rsp))
+
+	// the error of the streaming method is got after its frames
+	stream, err = suite.client.CallStream(CodecJson, addr, ts.Service(), "Count", &CountReq{N: 1, Fail: true},
+		WithCallRequestTimeout(100e6), WithCallResponseTimeout(500e6))
+	suite.Nil(err)
+	suite.Nil(stream.Recv(&rsp))
+	err = stream.Recv(&rsp)
+	suite.NotNil(err)
+	suite.Equal("count failed", err.Error())
+
+	// the closed stream
+	stream, err = suite.client.CallStream(CodecJson, addr, ts.Service(), "Count", &CountReq{N: 1000},
+		WithCallRequestTimeout(100e6), WithCallResponseTimeout(500e6))
+	suite.Nil(err)
+	suite.Nil(stream.Recv(&rsp))
+	stream.Close()
+	suite.Equal(errStreamClosed, stream.Recv(&rsp))
+
+	// the reply of a unary method is the only frame
+	stream, err = suite.client.CallStream(CodecJson, addr, ts.Service(), "Test", &TestReq{},
+		WithCallRequestTimeout(100e6), WithCallResponseTimeout(500e6))
+	suite.Nil(err)
+	suite.Nil(stream.Recv(&TestRsp{}))
+	suite.Equal(io.EOF, stream.Recv(&TestRsp{}))
+
+	// the streaming method invoked by Call
+	err = suite.client.Call(CodecJson, addr, ts.Service(), "Count", &CountReq{}, &rsp,
+		WithCallRequestTimeout(100e6), WithCallResponseTimeout(500e6))
+	suite.Nil(err)
+}
+
 func TestClientTestSuite(t *testing.T) {
 	suite.Run(t, new(ClientTestSuite))
 }
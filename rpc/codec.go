@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"reflect"
+	"sync"
 	"time"
 	"unsafe"
 )
@@ -29,6 +30,10 @@ const (
 	gettyCmdHbResponse               = 0x02
 	gettyCmdRPCRequest               = 0x03
 	gettyCmdRPCResponse              = 0x04
+	// a response frame of a streaming method
+	gettyCmdRPCStreamResponse = 0x05
+	// the trailer of the response frames of a streaming method, which carries its error
+	gettyCmdRPCStreamEnd = 0x06
 )
 
 var gettyCommandStrings = [...]string{
@@ -37,6 +42,8 @@ var gettyCommandStrings = [...]string{
 	"getty-heartbeat-response",
 	"getty-request",
 	"getty-response",
+	"getty-stream-response",
+	"getty-stream-end",
 }
 
 func (c gettyCommand) String() string {
@@ -58,49 +65,105 @@ const (
 //  getty codec type
 ////////////////////////////////////////////
 
+// CodecType is the content type of the body of a frame, which selects the Codec of the frame.
+// The frames of a session can use different codecs, so one server can serve the clients of
+// different serializations simultaneously. The codec types are carried by the 2 bytes of the frame
+// header, i.e. 0x0001 ~ 0x7FFF.
 type CodecType int16
 
 const (
 	CodecUnknown  CodecType = 0x00
 	CodecJson               = 0x01
 	CodecProtobuf           = 0x02
+	// the codec types reserved for msgpack & hessian, whose codecs should be registered
+	// by RegisterCodec.
+	CodecMsgpack = 0x03
+	CodecHessian = 0x04
+	// the canonical cbor, see CBORCodec
+	CodecCBOR = 0x05
 )
 
 var (
-	gettyCodecStrings = [...]string{
-		"unknown",
-		"json",
-		"protobuf",
+	errIllegalCodec = jerrors.New("illegal codec registration")
+
+	// guard the registry, whose codecs can be registered while the frames are decoded
+	codecLock  sync.RWMutex
+	codecNames = map[CodecType]string{
+		CodecUnknown:  "unknown",
+		CodecJson:     "json",
+		CodecProtobuf: "protobuf",
+		CodecCBOR:     "cbor",
 	}
 
+	// Codecs is the codec registry keyed by the codec types. Pls use RegisterCodec to add a codec,
+	// and GetCodec to get one.
 	Codecs = map[CodecType]Codec{
 		CodecJson:     &JSONCodec{},
 		CodecProtobuf: &PBCodec{},
+		CodecCBOR:     &CBORCodec{Canonical: true},
 	}
 )
 
-func (c CodecType) String() string {
-	if c == CodecJson || c == CodecProtobuf {
-		return gettyCodecStrings[c]
+// RegisterCodec registers @codec of the codec type @typ, whose name @name is the protocol of
+// the configs, e.g.
+//
+//	func init() {
+//		rpc.RegisterCodec(rpc.CodecMsgpack, "msgpack", msgpackCodec{})
+//	}
+//
+// It should be invoked before the clients & servers are built. A registered codec type or
+// name can not be registered again.
+func RegisterCodec(typ CodecType, name string, codec Codec) error {
+	if typ <= CodecUnknown || name == "" || codec == nil {
+		return jerrors.Annotatef(errIllegalCodec, "codec type:%d, name:%q", typ, name)
+	}
+
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	if _, ok := codecNames[typ]; ok {
+		return jerrors.Annotatef(errIllegalCodec, "codec type %d has been registered", typ)
+	}
+	if getCodecType(name) != CodecUnknown {
+		return jerrors.Annotatef(errIllegalCodec, "codec name %q has been registered", name)
 	}
 
-	return gettyCodecStrings[CodecUnknown]
+	codecNames[typ] = name
+	Codecs[typ] = codec
+	return nil
 }
 
-func (c CodecType) CheckValidity() bool {
-	if c == CodecJson || c == CodecProtobuf {
-		return true
+// GetCodec returns the registered codec of the codec type @typ, or nil.
+func GetCodec(typ CodecType) Codec {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	return Codecs[typ]
+}
+
+func (c CodecType) String() string {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	if name, ok := codecNames[c]; ok && Codecs[c] != nil {
+		return name
 	}
 
-	return false
+	return codecNames[CodecUnknown]
+}
+
+func (c CodecType) CheckValidity() bool {
+	return GetCodec(c) != nil
 }
 
 func GetCodecType(codecType string) CodecType {
-	switch codecType {
-	case gettyCodecStrings[CodecJson]:
-		return CodecJson
-	case gettyCodecStrings[CodecProtobuf]:
-		return CodecProtobuf
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	return getCodecType(codecType)
+}
+
+func getCodecType(codecType string) CodecType {
+	for typ, name := range codecNames {
+		if typ != CodecUnknown && name == codecType {
+			return typ
+		}
 	}
 
 	return CodecUnknown
@@ -126,7 +189,13 @@ func (c JSONCodec) Encode(i interface{}) ([]byte, error) {
 	return jsonstd.Marshal(i)
 }
 
-func (c JSONCodec) Decode(data []byte, i interface{}) error {
+func (c JSONCodec) Decode(data []byte, i interface{}) (err error) {
+	// the json unmarshaler of the pb enums panics on some malformed values
+	defer func() {
+		if r := recover(); r != nil {
+			err = jerrors.Errorf("json decode panic: %v", r)
+		}
+	}()
 	// return json.Unmarshal(data, i)
 	return jsonstd.Unmarshal(data, i)
 }
@@ -238,7 +307,8 @@ func (p *GettyPackage) Marshal() (*bytes.Buffer, error) {
 	//buf = gxbytes.GetBytesBuffer()
 	//defer gxbytes.PutBytesBuffer(buf)
 
-	// body
+	// body. the header of a reply without body may be copied from its request
+	p.H.PkgLen = 0
 	if p.B != nil {
 		length, err := p.B.Marshal(p.H.CodecType, buf)
 		if err != nil {
@@ -274,12 +344,19 @@ func (p *GettyPackage) Unmarshal(buf *bytes.Buffer) (int, error) {
 		return 0, ErrIllegalMagic
 	}
 
-	totalLen := int(PkgLenType(gettyPackageHeaderLen) + p.H.PkgLen)
+	if p.H.PkgLen < 0 {
+		return 0, ErrInvalidPackage
+	}
+	totalLen := gettyPackageHeaderLen + int(p.H.PkgLen)
 	if totalLen > maxPackageLen {
 		return 0, ErrTooLargePackage
 	}
+	// the body of a truncated package can not be unmarshaled
+	if bufLen < totalLen {
+		return 0, ErrNotEnoughStream
+	}
 
-	if bufLen >= totalLen && p.H.PkgLen != 0 {
+	if p.H.PkgLen != 0 {
 		if err := p.B.Unmarshal(p.H.CodecType, bytes.NewBuffer(buf.Next(int(p.H.PkgLen)))); err != nil {
 			return 0, jerrors.Trace(err)
 		}
@@ -320,7 +397,7 @@ func NewGettyRPCRequest() RPCPackage {
 }
 
 func (req *GettyRPCRequest) Marshal(sz CodecType, buf *bytes.Buffer) (int, error) {
-	codec := Codecs[sz]
+	codec := GetCodec(sz)
 	if codec == nil {
 		return 0, jerrors.Errorf("can not find codec for %s", sz)
 	}
@@ -366,7 +443,10 @@ func (req *GettyRPCRequest) Unmarshal(ct CodecType, buf *bytes.Buffer) error {
 	headerp = gxbytes.GetBytes(int(headerLen))
 	defer func() {
 		gxbytes.PutBytes(headerp)
-		gxbytes.PutBytes(bodyp)
+		// the body is not got if the header is truncated
+		if bodyp != nil {
+			gxbytes.PutBytes(bodyp)
+		}
 	}()
 	header := *headerp
 
@@ -390,7 +470,7 @@ func (req *GettyRPCRequest) Unmarshal(ct CodecType, buf *bytes.Buffer) error {
 		return jerrors.Trace(err)
 	}
 
-	codec := Codecs[ct]
+	codec := GetCodec(ct)
 	if codec == nil {
 		return jerrors.Errorf("can not find codec for %d", ct)
 	}
@@ -400,12 +480,15 @@ func (req *GettyRPCRequest) Unmarshal(ct CodecType, buf *bytes.Buffer) error {
 		return jerrors.Trace(err)
 	}
 
-	req.body = body
+	// the body is returned to the pool when it returns
+	req.body = append([]byte(nil), body...)
 	return nil
 }
 
 func (req *GettyRPCRequest) GetBody() []byte {
-	return req.body.([]byte)
+	// the body of a package without body is nil
+	body, _ := req.body.([]byte)
+	return body
 }
 
 func (req *GettyRPCRequest) GetHeader() interface{} {
@@ -436,7 +519,7 @@ func NewGettyRPCResponse() RPCPackage {
 }
 
 func (resp *GettyRPCResponse) Marshal(sz CodecType, buf *bytes.Buffer) (int, error) {
-	codec := Codecs[sz]
+	codec := GetCodec(sz)
 	if codec == nil {
 		return 0, jerrors.Errorf("can not find codec for %d", sz)
 	}
@@ -490,7 +573,10 @@ func (resp *GettyRPCResponse) Unmarshal(sz CodecType, buf *bytes.Buffer) error {
 	headerp = gxbytes.GetBytes(int(headerLen))
 	defer func() {
 		gxbytes.PutBytes(headerp)
-		gxbytes.PutBytes(bodyp)
+		// the body is not got if the header is truncated
+		if bodyp != nil {
+			gxbytes.PutBytes(bodyp)
+		}
 	}()
 	header := *headerp
 
@@ -514,7 +600,7 @@ func (resp *GettyRPCResponse) Unmarshal(sz CodecType, buf *bytes.Buffer) error {
 		return jerrors.Trace(err)
 	}
 
-	codec := Codecs[sz]
+	codec := GetCodec(sz)
 	if codec == nil {
 		return jerrors.Errorf("can not find codec for %d", sz)
 	}
@@ -524,12 +610,15 @@ func (resp *GettyRPCResponse) Unmarshal(sz CodecType, buf *bytes.Buffer) error {
 		return jerrors.Trace(err)
 	}
 
-	resp.body = body
+	// the body is returned to the pool when it returns
+	resp.body = append([]byte(nil), body...)
 	return nil
 }
 
 func (resp *GettyRPCResponse) GetBody() []byte {
-	return resp.body.([]byte)
+	// the body of a package without body is nil
+	body, _ := resp.body.([]byte)
+	return body
 }
 
 func (resp *GettyRPCResponse) GetHeader() interface{} {
@@ -547,6 +636,7 @@ type PendingResponse struct {
 	readStart time.Time
 	callback  AsyncCallback
 	reply     interface{}
+	stream    *ClientStream
 	opts      CallOptions
 	done      chan struct{}
 }
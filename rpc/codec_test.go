@@ -0,0 +1,97 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	codecGob CodecType = 0x10
+	// a codec type wider than 1 byte
+	codecGobWide CodecType = 0x1010
+)
+
+type gobCodec struct{}
+
+func (c gobCodec) Encode(i interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(i)
+	return buf.Bytes(), err
+}
+
+func (c gobCodec) Decode(data []byte, i interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(i)
+}
+
+func init() {
+	if err := RegisterCodec(codecGob, "gob", gobCodec{}); err != nil {
+		panic(err)
+	}
+	if err := RegisterCodec(codecGobWide, "gob-wide", gobCodec{}); err != nil {
+		panic(err)
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	assert.Equal(t, "gob", codecGob.String())
+	assert.True(t, codecGob.CheckValidity())
+	assert.Equal(t, codecGob, GetCodecType("gob"))
+	assert.Equal(t, CodecType(CodecJson), GetCodecType("json"))
+
+	// the reserved codec types are not valid until their codecs are registered
+	assert.False(t, CodecType(CodecMsgpack).CheckValidity())
+	assert.Equal(t, "unknown", CodecType(CodecMsgpack).String())
+	assert.Equal(t, CodecUnknown, GetCodecType("msgpack"))
+	assert.Equal(t, CodecUnknown, GetCodecType("unknown"))
+
+	assert.NotNil(t, RegisterCodec(CodecUnknown, "none", gobCodec{}))
+	assert.NotNil(t, RegisterCodec(-1, "negative", gobCodec{}))
+	assert.NotNil(t, RegisterCodec(0x20, "", gobCodec{}))
+	assert.NotNil(t, RegisterCodec(0x20, "nil", nil))
+	assert.NotNil(t, RegisterCodec(CodecJson, "json2", gobCodec{}))
+	assert.NotNil(t, RegisterCodec(0x20, "gob", gobCodec{}))
+	assert.False(t, CodecType(0x20).CheckValidity())
+}
+
+func TestRegisterCodecWide(t *testing.T) {
+	assert.Equal(t, "gob-wide", codecGobWide.String())
+
+	// the codec type is carried by the 2 bytes of the frame header
+	pkg := GettyPackage{
+		H: GettyPackageHeader{Magic: gettyPackageMagic, Command: gettyCmdRPCRequest, CodecType: codecGobWide},
+		B: &GettyRPCRequest{
+			header: GettyRPCRequestHeader{Service: "Test", Method: "Count", CallType: CT_TwoWay},
+			body:   &CountReq{N: 3},
+		},
+	}
+	buf, err := pkg.Marshal()
+	assert.Nil(t, err)
+	decoded := GettyPackage{B: NewGettyRPCRequest()}
+	_, err = decoded.Unmarshal(bytes.NewBuffer(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, codecGobWide, decoded.H.CodecType)
+}
+
+func TestRegisterCodecConcurrency(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			// the codecs of the previous runs of the test have been registered
+			RegisterCodec(CodecType(0x2000+i), fmt.Sprintf("gob-%d", i), gobCodec{})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		assert.NotNil(t, GetCodec(codecGob))
+		assert.Equal(t, codecGob, GetCodecType("gob"))
+		assert.Equal(t, "gob", codecGob.String())
+	}
+	<-done
+	assert.Equal(t, "gob-99", CodecType(0x2000+99).String())
+}
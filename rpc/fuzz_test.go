@@ -0,0 +1,52 @@
+package rpc
+
+import (
+	"bytes"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func FuzzClientPackageRead(f *testing.F) {
+	for _, codecType := range []CodecType{CodecJson, CodecProtobuf, CodecCBOR} {
+		pkg := GettyPackage{
+			H: GettyPackageHeader{Magic: gettyPackageMagic, Command: gettyCmdRPCResponse, CodecType: codecType},
+			B: &GettyRPCResponse{header: GettyRPCResponseHeader{Error: "error"}, body: &GettyRPCResponseHeader{}},
+		}
+		buf, err := pkg.Marshal()
+		assert.Nil(f, err)
+		f.Add(buf.Bytes())
+		// truncated frame
+		f.Add(buf.Bytes()[:buf.Len()-1])
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkg, n, err := rpcClientPackageHandler.Read(nil, data)
+		if err != nil || pkg == nil {
+			return
+		}
+		if n <= 0 || n > len(data) {
+			t.Fatalf("pkg length %d of %d bytes", n, len(data))
+		}
+	})
+}
+
+func FuzzGettyPackageUnmarshal(f *testing.F) {
+	pkg := GettyPackage{
+		H: GettyPackageHeader{Magic: gettyPackageMagic, Command: gettyCmdRPCRequest, CodecType: CodecJson},
+		B: &GettyRPCRequest{header: GettyRPCRequestHeader{Service: "Test", Method: "Add"}, body: []int{1, 2}},
+	}
+	buf, err := pkg.Marshal()
+	assert.Nil(f, err)
+	f.Add(buf.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkg := &GettyPackage{B: NewGettyRPCRequest()}
+		n, err := pkg.Unmarshal(bytes.NewBuffer(data))
+		if err == nil && (n <= 0 || n > len(data)) {
+			t.Fatalf("pkg length %d of %d bytes", n, len(data))
+		}
+	})
+}
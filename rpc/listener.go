@@ -121,6 +121,10 @@ func (h *RpcServerHandler) OnMessage(session getty.Session, pkg interface{}) {
 		function.Call([]reflect.Value{req.service.rcvr, req.argv, req.replyv})
 		return
 	}
+	if req.methodType.ReplyType == typeOfResponseStream {
+		h.callStreamService(session, req)
+		return
+	}
 	err := h.callService(session, req, req.service, req.methodType, req.argv, req.replyv)
 	if err != nil {
 		log.Error("h.callService(session:%#v, req:%#v) = %s", session, req, jerrors.ErrorStack(err))
@@ -192,6 +196,21 @@ func (h *RpcServerHandler) callService(session getty.Session, req GettyRPCReques
 	return jerrors.Trace(session.WritePkg(resp, 5*time.Second))
 }
 
+// call the streaming method, and send the end frame after it returns
+func (h *RpcServerHandler) callStreamService(session getty.Session, req GettyRPCRequestPackage) {
+	stream := req.replyv.Interface().(*ResponseStream)
+	stream.session = session
+	stream.h = req.H
+
+	function := req.methodType.method.Func
+	returnValues := function.Call([]reflect.Value{req.service.rcvr, req.argv, req.replyv})
+	var errStr string
+	if errInter := returnValues[0].Interface(); errInter != nil {
+		errStr = errInter.(error).Error()
+	}
+	h.replyCmd(session, req, gettyCmdRPCStreamEnd, errStr)
+}
+
 ////////////////////////////////////////////
 // RpcClientHandler
 ////////////////////////////////////////////
@@ -228,6 +247,15 @@ func (h *RpcClientHandler) OnMessage(session getty.Session, pkg interface{}) {
 	// log.Debug("get rpc response{%#v}", p)
 	h.conn.updateSession(session)
 
+	// the response frames of a stream are delivered until its end frame
+	if p.H.Command == gettyCmdRPCStreamResponse {
+		pendingResponse := h.conn.pool.rpcClient.getPendingResponse(p.H.Sequence)
+		if pendingResponse != nil && pendingResponse.stream != nil {
+			pendingResponse.stream.push(streamFrame{body: p.body})
+			return
+		}
+	}
+
 	pendingResponse := h.conn.pool.rpcClient.removePendingResponse(p.H.Sequence)
 	if pendingResponse == nil {
 		log.Error("failed to get pending response context for response package %s", *p)
@@ -236,6 +264,10 @@ func (h *RpcClientHandler) OnMessage(session getty.Session, pkg interface{}) {
 	if p.H.Command == gettyCmdHbResponse {
 		return
 	}
+	if pendingResponse.stream != nil {
+		h.endStream(pendingResponse.stream, p)
+		return
+	}
 	if p.H.Code == GettyFail && len(p.header.Error) > 0 {
 		pendingResponse.err = jerrors.New(p.header.Error)
 		if pendingResponse.callback == nil {
@@ -245,13 +277,17 @@ func (h *RpcClientHandler) OnMessage(session getty.Session, pkg interface{}) {
 		}
 		return
 	}
-	codec := Codecs[p.H.CodecType]
+	codec := GetCodec(p.H.CodecType)
 	if codec == nil {
 		pendingResponse.err = jerrors.Errorf("can not find codec for %d", p.H.CodecType)
 		pendingResponse.done <- struct{}{}
 		return
 	}
-	err := codec.Decode(p.body, pendingResponse.reply)
+	var err error
+	// the end frame of a streaming method invoked by Call carries no reply
+	if p.H.Command != gettyCmdRPCStreamEnd {
+		err = codec.Decode(p.body, pendingResponse.reply)
+	}
 	pendingResponse.err = err
 	if pendingResponse.callback == nil {
 		pendingResponse.done <- struct{}{}
@@ -260,6 +296,18 @@ func (h *RpcClientHandler) OnMessage(session getty.Session, pkg interface{}) {
 	}
 }
 
+// end @stream by the end frame of a streaming method, or the response of a unary method
+func (h *RpcClientHandler) endStream(stream *ClientStream, p *GettyRPCResponsePackage) {
+	var err error
+	switch {
+	case p.H.Code == GettyFail && len(p.header.Error) > 0:
+		err = jerrors.New(p.header.Error)
+	case p.H.Command == gettyCmdRPCResponse:
+		stream.push(streamFrame{body: p.body})
+	}
+	stream.push(streamFrame{end: true, err: err})
+}
+
 func (h *RpcClientHandler) OnCron(session getty.Session) {
 	rpcSession, err := h.conn.getClientRpcSession(session)
 	if err != nil {
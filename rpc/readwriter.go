@@ -67,7 +67,7 @@ func (p *RpcServerPackageHandler) Read(ss getty.Session, data []byte) (interface
 		req.argv = reflect.New(req.methodType.ArgType)
 		argIsValue = true
 	}
-	codec := Codecs[req.H.CodecType]
+	codec := GetCodec(req.H.CodecType)
 	if codec == nil {
 		return nil, 0, jerrors.Errorf("can not find codec for %d", req.H.CodecType)
 	}
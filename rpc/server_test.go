@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -13,6 +14,13 @@ type (
 	TestReq  struct{}
 	TestRsp  struct{}
 	EventReq struct{}
+	CountReq struct {
+		N    int
+		Fail bool
+	}
+	CountRsp struct {
+		I int
+	}
 )
 
 type MockService struct {
@@ -43,6 +51,18 @@ func (r *MockService) Event(req *TestReq) error {
 	return nil
 }
 
+func (r *MockService) Count(req *CountReq, stream *ResponseStream) error {
+	for i := 0; i < req.N; i++ {
+		if err := stream.Send(&CountRsp{I: i}); err != nil {
+			return err
+		}
+	}
+	if req.Fail {
+		return errors.New("count failed")
+	}
+	return nil
+}
+
 const (
 	ServerHost = "127.0.0.1"
 	ServerPort = "65432"
@@ -0,0 +1,146 @@
+package rpc
+
+import (
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+)
+
+const (
+	// the max number of the received frames which have not been read by Recv
+	clientStreamBufferSize = 64
+)
+
+var (
+	errStreamClosed = jerrors.New("stream closed")
+
+	typeOfResponseStream = reflect.TypeOf((*ResponseStream)(nil))
+)
+
+////////////////////////////////////////////
+// ResponseStream
+////////////////////////////////////////////
+
+// ResponseStream is the reply of a streaming method, e.g.
+//
+//	func (s *Service) List(req *ListReq, stream *rpc.ResponseStream) error {
+//		for _, item := range s.items {
+//			if err := stream.Send(&item); err != nil {
+//				return err
+//			}
+//		}
+//		return nil
+//	}
+//
+// The frames sent by Send are terminated by an end frame after the method returns,
+// which carries the error of the method.
+type ResponseStream struct {
+	session getty.Session
+	h       GettyPackageHeader
+}
+
+// Send sends @reply as a response frame.
+func (s *ResponseStream) Send(reply interface{}) error {
+	resp := GettyPackage{
+		H: s.h,
+	}
+	resp.H.Code = GettyOK
+	resp.H.Command = gettyCmdRPCStreamResponse
+	resp.B = &GettyRPCResponse{
+		body: reply,
+	}
+
+	return jerrors.Trace(s.session.WritePkg(resp, 5*time.Second))
+}
+
+////////////////////////////////////////////
+// ClientStream
+////////////////////////////////////////////
+
+type streamFrame struct {
+	body []byte
+	end  bool
+	err  error
+}
+
+// ClientStream is the response frames of a CallStream, which are read by Recv.
+type ClientStream struct {
+	client  *Client
+	codec   Codec
+	rsp     *PendingResponse
+	timeout time.Duration
+	frames  chan streamFrame
+	once    sync.Once
+	done    chan struct{}
+	err     error
+}
+
+func newClientStream(client *Client, typ CodecType) *ClientStream {
+	return &ClientStream{
+		client: client,
+		codec:  GetCodec(typ),
+		frames: make(chan streamFrame, clientStreamBufferSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// Recv decodes the next response frame into @reply. It returns io.EOF after the last
+// frame, or the error of the streaming method. It should not be invoked concurrently.
+func (s *ClientStream) Recv(reply interface{}) error {
+	if s.err != nil {
+		return s.err
+	}
+	select {
+	case <-s.done:
+		s.err = errStreamClosed
+		return s.err
+	default:
+	}
+
+	select {
+	case frame := <-s.frames:
+		if frame.end {
+			s.err = frame.err
+			if s.err == nil {
+				s.err = io.EOF
+			}
+			return s.err
+		}
+		return jerrors.Trace(s.codec.Decode(frame.body, reply))
+
+	case <-getty.GetTimeWheel().After(s.timeout):
+		s.Close()
+		s.err = errClientReadTimeout
+		return jerrors.Trace(s.err)
+
+	case <-s.done:
+		s.err = errStreamClosed
+		return s.err
+	}
+}
+
+// Close stops receiving the response frames.
+func (s *ClientStream) Close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.client.removePendingResponse(s.rsp.seq)
+	})
+}
+
+// push the received frame. It is invoked by the read goroutine of the session, which is
+// blocked if Recv falls behind by clientStreamBufferSize frames.
+func (s *ClientStream) push(frame streamFrame) {
+	select {
+	case s.frames <- frame:
+	case <-s.done:
+	}
+}
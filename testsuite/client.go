@@ -0,0 +1,131 @@
+package testsuite
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+)
+
+const (
+	// the reconnect interval of the harness clients in nanoseconds
+	clientReconnectInterval = int(10 * time.Millisecond)
+)
+
+var (
+	errNoSession = jerrors.New("client has no open session")
+)
+
+// ClientConfig is the config of a harness client.
+type ClientConfig struct {
+	SessionConfig
+	Options []getty.ClientOption
+	// the timeout of the waits of the steps. Its default value is 5s.
+	Timeout time.Duration
+}
+
+// Client is a client with one session connected to a harness server, which reconnects after
+// its session is closed. All callbacks of its sessions are recorded by its Recorder.
+type Client struct {
+	*Recorder
+	network Network
+	timeout time.Duration
+	client  getty.Client
+
+	lock sync.Mutex
+	sent []interface{}
+}
+
+// StartClient starts a client of @config connected to @server. It is closed when the test
+// finishes, and @tb fails if its session is not opened in the timeout.
+func StartClient(tb testing.TB, server *Server, config ClientConfig) *Client {
+	tb.Helper()
+
+	c, err := startClient(server, config)
+	if c != nil {
+		tb.Cleanup(c.Close)
+	}
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c
+}
+
+func startClient(server *Server, config ClientConfig) (*Client, error) {
+	session := config.SessionConfig.withDefaults()
+	c := &Client{Recorder: NewRecorder(session.Handler), network: server.network, timeout: config.Timeout}
+	if c.timeout <= 0 {
+		c.timeout = defaultTimeout
+	}
+
+	opts := append([]getty.ClientOption{
+		getty.WithServerAddress(server.Addr()),
+		getty.WithConnectionNumber(1),
+		getty.WithReconnectInterval(clientReconnectInterval),
+	}, config.Options...)
+	switch server.network {
+	case TCP:
+		c.client = getty.NewTCPClient(opts...)
+	case WS:
+		c.client = getty.NewWSClient(opts...)
+	case UDP:
+		c.client = getty.NewUDPClient(opts...)
+	}
+
+	// RunEventLoop returns after the session is connected
+	go c.client.RunEventLoop(func(ss getty.Session) error {
+		session.setup(ss, server.network, c.Recorder)
+		return nil
+	})
+	return c, jerrors.Trace(c.Wait(EventOpen, 1, c.timeout))
+}
+
+// Session returns the latest open session of the client.
+func (c *Client) Session() getty.Session {
+	sessions := c.Sessions()
+	if len(sessions) == 0 {
+		return nil
+	}
+	return sessions[len(sessions)-1]
+}
+
+// Send writes @pkg by the open session of the client, and remembers it for ExpectEchoes.
+// The pkg of a udp client is wrapped in UDPContext.
+func (c *Client) Send(pkg interface{}) error {
+	ss := c.Session()
+	if ss == nil {
+		return errNoSession
+	}
+
+	c.lock.Lock()
+	c.sent = append(c.sent, pkg)
+	c.lock.Unlock()
+	if c.network == UDP {
+		return jerrors.Trace(ss.WritePkg(getty.UDPContext{Pkg: pkg}, c.timeout))
+	}
+	return jerrors.Trace(ss.WritePkg(pkg, c.timeout))
+}
+
+// Sent returns the pkgs written by Send in order.
+func (c *Client) Sent() []interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]interface{}(nil), c.sent...)
+}
+
+// EndPoint returns the getty client.
+func (c *Client) EndPoint() getty.Client {
+	return c.client
+}
+
+// Close closes the client and its sessions.
+func (c *Client) Close() {
+	c.client.Close()
+}
@@ -0,0 +1,86 @@
+package testsuite
+
+import (
+	"encoding/binary"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+)
+
+const (
+	// length(4 bytes) | payload
+	frameHeaderLen = 4
+	// the default max payload length of FrameCodec
+	DefaultMaxFrameLen = 16 * 1024 * 1024
+)
+
+var (
+	errIllegalFrame = jerrors.New("illegal frame")
+)
+
+// FrameCodec frames the pkgs by a 4 bytes big endian length header. Its pkgs are []byte, and
+// a string pkg is written as its bytes. The pkgs of a udp session are wrapped in UDPContext,
+// and every datagram is one frame.
+type FrameCodec struct {
+	// the max payload length. Its default value is DefaultMaxFrameLen.
+	MaxLen int
+}
+
+func (c FrameCodec) maxLen() int {
+	if c.MaxLen <= 0 {
+		return DefaultMaxFrameLen
+	}
+	return c.MaxLen
+}
+
+func (c FrameCodec) Read(ss getty.Session, data []byte) (interface{}, int, error) {
+	if len(data) < frameHeaderLen {
+		return nil, 0, nil
+	}
+	length := int(binary.BigEndian.Uint32(data))
+	if length > c.maxLen() {
+		return nil, 0, jerrors.Annotatef(errIllegalFrame, "length %d", length)
+	}
+	if len(data) < frameHeaderLen+length {
+		return nil, 0, nil
+	}
+
+	return append([]byte(nil), data[frameHeaderLen:frameHeaderLen+length]...), frameHeaderLen + length, nil
+}
+
+func (c FrameCodec) Write(ss getty.Session, pkg interface{}) ([]byte, error) {
+	if ctx, ok := pkg.(getty.UDPContext); ok {
+		pkg = ctx.Pkg
+	}
+
+	var payload []byte
+	switch p := pkg.(type) {
+	case []byte:
+		payload = p
+	case string:
+		payload = []byte(p)
+	default:
+		return nil, jerrors.Errorf("illegal pkg type %T", pkg)
+	}
+	if len(payload) > c.maxLen() {
+		return nil, jerrors.Annotatef(errIllegalFrame, "length %d", len(payload))
+	}
+
+	b := make([]byte, frameHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(len(payload)))
+	copy(b[frameHeaderLen:], payload)
+	return b, nil
+}
+
+// Echo sends every pkg back to its sender. It can be the handler of a Recorder.
+func Echo(ss getty.Session, pkg interface{}) {
+	if err := ss.WritePkg(pkg, 0); err != nil {
+		log.Warn("%s, echo error:%v", ss.Stat(), err)
+	}
+}
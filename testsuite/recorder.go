@@ -0,0 +1,203 @@
+package testsuite
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+)
+
+// EventKind is the kind of a listener callback.
+type EventKind int
+
+const (
+	EventOpen EventKind = iota
+	EventClose
+	EventError
+	EventCron
+	EventMessage
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventOpen:
+		return "open"
+	case EventClose:
+		return "close"
+	case EventError:
+		return "error"
+	case EventCron:
+		return "cron"
+	case EventMessage:
+		return "message"
+	}
+
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// Event is a recorded listener callback.
+type Event struct {
+	Kind    EventKind
+	Time    time.Time
+	Session getty.Session
+	// the pkg of an EventMessage, which is unwrapped from the UDPContext of a udp session
+	Pkg interface{}
+	// the peer of the pkg of an EventMessage of a udp session
+	Peer *net.UDPAddr
+	// the error of an EventError
+	Err error
+}
+
+// Recorder is an EventListener which records all callbacks, so a test can wait for and
+// assert on them.
+type Recorder struct {
+	// invoked by OnMessage after the message is recorded, e.g. Echo
+	handler func(getty.Session, interface{})
+	// the sleep of OnMessage per message in nanoseconds, which makes a slow reader
+	delay int64
+
+	lock    sync.Mutex
+	events  []Event
+	changed chan struct{}
+}
+
+// NewRecorder builds a recorder. @handler handles the messages after they are recorded,
+// and it can be nil.
+func NewRecorder(handler func(getty.Session, interface{})) *Recorder {
+	return &Recorder{handler: handler, changed: make(chan struct{})}
+}
+
+func (r *Recorder) record(e Event) {
+	e.Time = time.Now()
+	r.lock.Lock()
+	r.events = append(r.events, e)
+	close(r.changed)
+	r.changed = make(chan struct{})
+	r.lock.Unlock()
+}
+
+func (r *Recorder) OnOpen(ss getty.Session) error {
+	r.record(Event{Kind: EventOpen, Session: ss})
+	return nil
+}
+
+func (r *Recorder) OnClose(ss getty.Session) {
+	r.record(Event{Kind: EventClose, Session: ss})
+}
+
+func (r *Recorder) OnError(ss getty.Session, err error) {
+	r.record(Event{Kind: EventError, Session: ss, Err: err})
+}
+
+func (r *Recorder) OnCron(ss getty.Session) {
+	r.record(Event{Kind: EventCron, Session: ss})
+}
+
+func (r *Recorder) OnMessage(ss getty.Session, pkg interface{}) {
+	e := Event{Kind: EventMessage, Session: ss, Pkg: pkg}
+	if ctx, ok := pkg.(getty.UDPContext); ok {
+		e.Pkg, e.Peer = ctx.Pkg, ctx.PeerAddr
+	}
+	r.record(e)
+
+	if delay := atomic.LoadInt64(&r.delay); delay > 0 {
+		time.Sleep(time.Duration(delay))
+	}
+	if r.handler != nil {
+		r.handler(ss, pkg)
+	}
+}
+
+// SetReadDelay makes OnMessage sleep @d per message. As OnMessage is invoked by the read
+// goroutine of a session without task pool, the session reads slowly and its peer is
+// throttled by the flow control of the transport.
+func (r *Recorder) SetReadDelay(d time.Duration) {
+	atomic.StoreInt64(&r.delay, int64(d))
+}
+
+// Events returns the recorded events of @kind.
+func (r *Recorder) Events(kind EventKind) []Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var events []Event
+	for _, e := range r.events {
+		if e.Kind == kind {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Count returns the number of the recorded events of @kind.
+func (r *Recorder) Count(kind EventKind) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var n int
+	for _, e := range r.events {
+		if e.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Messages returns the pkgs of the recorded messages in order.
+func (r *Recorder) Messages() []interface{} {
+	events := r.Events(EventMessage)
+	pkgs := make([]interface{}, len(events))
+	for i, e := range events {
+		pkgs[i] = e.Pkg
+	}
+	return pkgs
+}
+
+// Sessions returns the opened sessions which have not been closed.
+func (r *Recorder) Sessions() []getty.Session {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var sessions []getty.Session
+	for _, e := range r.events {
+		switch e.Kind {
+		case EventOpen:
+			sessions = append(sessions, e.Session)
+		case EventClose:
+			for i, ss := range sessions {
+				if ss == e.Session {
+					sessions = append(sessions[:i], sessions[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return sessions
+}
+
+// Wait waits until at least @n events of @kind are recorded, or returns an error after @timeout.
+func (r *Recorder) Wait(kind EventKind, n int, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		r.lock.Lock()
+		changed := r.changed
+		r.lock.Unlock()
+		count := r.Count(kind)
+		if count >= n {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return fmt.Errorf("wait for %d %s events timeout(%s), got %d", n, kind, timeout, count)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package testsuite
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+// Step is a step of a scripted scenario, which is run by a client.
+type Step func(c *Client) error
+
+// Send writes @pkgs in order.
+func Send(pkgs ...interface{}) Step {
+	return func(c *Client) error {
+		for _, pkg := range pkgs {
+			if err := c.Send(pkg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// SendFrame writes a frame of @size payload bytes, e.g. a large frame beyond the socket buffers.
+func SendFrame(size int) Step {
+	return func(c *Client) error {
+		payload := make([]byte, size)
+		for i := range payload {
+			payload[i] = byte(i)
+		}
+		return c.Send(payload)
+	}
+}
+
+// Expect waits until the client has received @n messages in total.
+func Expect(n int) Step {
+	return func(c *Client) error {
+		return c.Wait(EventMessage, n, c.timeout)
+	}
+}
+
+// ExpectEchoes waits until every pkg written by Send is received back in order, i.e. the
+// server is an echo server. The string pkgs are compared with the []byte messages by bytes.
+func ExpectEchoes() Step {
+	return func(c *Client) error {
+		sent := c.Sent()
+		if err := c.Wait(EventMessage, len(sent), c.timeout); err != nil {
+			return err
+		}
+		received := c.Messages()
+		for i, pkg := range sent {
+			if !equalPkg(pkg, received[i]) {
+				return fmt.Errorf("echo %d mismatch, sent %d bytes, received %d bytes",
+					i, pkgLen(pkg), pkgLen(received[i]))
+			}
+		}
+		return nil
+	}
+}
+
+func pkgBytes(pkg interface{}) ([]byte, bool) {
+	switch p := pkg.(type) {
+	case []byte:
+		return p, true
+	case string:
+		return []byte(p), true
+	}
+	return nil, false
+}
+
+func equalPkg(a, b interface{}) bool {
+	ab, aok := pkgBytes(a)
+	bb, bok := pkgBytes(b)
+	if aok && bok {
+		return bytes.Equal(ab, bb)
+	}
+	return a == b
+}
+
+func pkgLen(pkg interface{}) int {
+	b, _ := pkgBytes(pkg)
+	return len(b)
+}
+
+// Reconnect closes the open session of the client, and waits until the session is closed
+// and the client reconnects.
+func Reconnect() Step {
+	return func(c *Client) error {
+		ss := c.Session()
+		if ss == nil {
+			return errNoSession
+		}
+		opened, closed := c.Count(EventOpen), c.Count(EventClose)
+		ss.Close()
+		if err := c.Wait(EventClose, closed+1, c.timeout); err != nil {
+			return err
+		}
+		return c.Wait(EventOpen, opened+1, c.timeout)
+	}
+}
+
+// SlowRead makes the client handle every message @d slowly, and 0 @d restores it.
+func SlowRead(d time.Duration) Step {
+	return func(c *Client) error {
+		c.SetReadDelay(d)
+		return nil
+	}
+}
+
+// Sleep sleeps @d.
+func Sleep(d time.Duration) Step {
+	return func(c *Client) error {
+		time.Sleep(d)
+		return nil
+	}
+}
+
+// Run starts @n clients of @config connected to @server, runs @steps by every client
+// concurrently, and returns the clients. @tb fails with the errors of the clients.
+func Run(tb testing.TB, server *Server, n int, config ClientConfig, steps ...Step) []*Client {
+	tb.Helper()
+
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		errs    []error
+		clients = make([]*Client, n)
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := func() error {
+				c, err := startClient(server, config)
+				clients[i] = c
+				if err != nil {
+					return err
+				}
+				for j, step := range steps {
+					if err := step(c); err != nil {
+						return jerrors.Annotatef(err, "step %d", j)
+					}
+				}
+				return nil
+			}()
+			if err != nil {
+				lock.Lock()
+				errs = append(errs, jerrors.Annotatef(err, "client %d", i))
+				lock.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, c := range clients {
+		if c != nil {
+			tb.Cleanup(c.Close)
+		}
+	}
+	for _, err := range errs {
+		tb.Error(err)
+	}
+	if len(errs) != 0 {
+		tb.FailNow()
+	}
+	return clients
+}
@@ -0,0 +1,152 @@
+package testsuite
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+)
+
+// Network is the network of a harness server and its clients.
+type Network string
+
+const (
+	TCP Network = "tcp"
+	WS  Network = "ws"
+	UDP Network = "udp"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	// the websocket path of a ws server
+	wsPath = "/testsuite"
+	// the max payload of a udp datagram, which bounds the read buffer of a udp session
+	maxDatagramLen = 65507
+)
+
+// SessionConfig is the session config of a harness server or client.
+type SessionConfig struct {
+	// the codec of the sessions. Its default value is FrameCodec{}.
+	Codec getty.ReadWriter
+	// the max message length of the sessions. Its default value is DefaultMaxFrameLen.
+	MaxMsgLen int
+	// handle the messages after they are recorded, e.g. Echo
+	Handler func(getty.Session, interface{})
+	// set up a new session after the defaults are set, e.g. its read timeout or task pool
+	Setup func(getty.Session)
+}
+
+func (c SessionConfig) withDefaults() SessionConfig {
+	if c.Codec == nil {
+		c.Codec = FrameCodec{}
+	}
+	if c.MaxMsgLen <= 0 {
+		c.MaxMsgLen = DefaultMaxFrameLen
+	}
+
+	return c
+}
+
+func (c SessionConfig) setup(ss getty.Session, network Network, listener getty.EventListener) {
+	// the max message length includes the frame header
+	maxMsgLen := c.MaxMsgLen + frameHeaderLen
+	if network == UDP && maxMsgLen > maxDatagramLen {
+		maxMsgLen = maxDatagramLen
+	}
+	ss.SetName("getty-testsuite")
+	ss.SetMaxMsgLen(maxMsgLen)
+	ss.SetPkgHandler(c.Codec)
+	ss.SetEventListener(listener)
+	ss.SetWQLen(1024)
+	ss.SetReadTimeout(time.Second)
+	ss.SetWriteTimeout(defaultTimeout)
+	ss.SetCronPeriod(int(time.Minute / time.Millisecond))
+	ss.SetWaitTime(time.Second)
+	if c.Setup != nil {
+		c.Setup(ss)
+	}
+}
+
+// ServerConfig is the config of a harness server.
+type ServerConfig struct {
+	SessionConfig
+	Network Network
+	Options []getty.ServerOption
+}
+
+// Server is an ephemeral server listening on a random port of 127.0.0.1. All callbacks of its
+// sessions are recorded by its Recorder.
+type Server struct {
+	*Recorder
+	network Network
+	addr    string
+	server  getty.Server
+}
+
+// StartServer starts a server of @config. It is closed when the test finishes, and @tb
+// fails if the server can not start.
+func StartServer(tb testing.TB, config ServerConfig) *Server {
+	tb.Helper()
+
+	session := config.SessionConfig.withDefaults()
+	s := &Server{Recorder: NewRecorder(session.Handler), network: config.Network}
+	opts := append([]getty.ServerOption{getty.WithLocalAddress("127.0.0.1:0")}, config.Options...)
+	switch config.Network {
+	case TCP:
+		s.server = getty.NewTCPServer(opts...)
+	case WS:
+		s.server = getty.NewWSServer(append(opts, getty.WithWebsocketServerPath(wsPath))...)
+	case UDP:
+		s.server = getty.NewUDPPEndPoint(opts...)
+	default:
+		tb.Fatalf("illegal network %q", config.Network)
+	}
+
+	// the bound address of a udp endpoint is the local address of its only session
+	udpAddr := make(chan string, 1)
+	s.server.RunEventLoop(func(ss getty.Session) error {
+		session.setup(ss, config.Network, s.Recorder)
+		if config.Network == UDP {
+			udpAddr <- ss.LocalAddr()
+		}
+		return nil
+	})
+	tb.Cleanup(s.Close)
+
+	if config.Network != UDP {
+		s.addr = s.server.Listener().Addr().String()
+		return s
+	}
+	select {
+	case s.addr = <-udpAddr:
+	case <-time.After(defaultTimeout):
+		tb.Fatalf("udp server does not start in %s", defaultTimeout)
+	}
+	return s
+}
+
+// Addr returns the address the clients dial, which is a url of a ws server.
+func (s *Server) Addr() string {
+	if s.network == WS {
+		return fmt.Sprintf("ws://%s%s", s.addr, wsPath)
+	}
+	return s.addr
+}
+
+// Network returns the network of the server.
+func (s *Server) Network() Network {
+	return s.network
+}
+
+// EndPoint returns the getty server.
+func (s *Server) EndPoint() getty.Server {
+	return s.server
+}
+
+// Close closes the server and its sessions.
+func (s *Server) Close() {
+	s.server.Close()
+}
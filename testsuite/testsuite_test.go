@@ -0,0 +1,110 @@
+package testsuite
+
+import (
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+import (
+	"github.com/AlexStocks/getty/transport"
+)
+
+func TestFrameCodec(t *testing.T) {
+	codec := FrameCodec{MaxLen: 8}
+	data, err := codec.Write(nil, "hello")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}, data)
+	ctxData, err := codec.Write(nil, getty.UDPContext{Pkg: []byte("hello")})
+	assert.Nil(t, err)
+	assert.Equal(t, data, ctxData)
+	_, err = codec.Write(nil, "too long payload")
+	assert.NotNil(t, err)
+	_, err = codec.Write(nil, 1)
+	assert.NotNil(t, err)
+
+	for i := 0; i < len(data); i++ {
+		pkg, n, err := codec.Read(nil, data[:i])
+		assert.Nil(t, err)
+		assert.Nil(t, pkg)
+		assert.Equal(t, 0, n)
+	}
+	pkg, n, err := codec.Read(nil, append(data, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), pkg)
+	assert.Equal(t, len(data), n)
+
+	_, _, err = codec.Read(nil, []byte{0, 0, 0, 9})
+	assert.NotNil(t, err)
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder(nil)
+	assert.NotNil(t, r.Wait(EventOpen, 1, 10*time.Millisecond))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		r.OnOpen(nil)
+		r.OnMessage(nil, getty.UDPContext{Pkg: []byte("hi")})
+	}()
+	assert.Nil(t, r.Wait(EventMessage, 1, time.Second))
+	assert.Equal(t, 1, r.Count(EventOpen))
+	assert.Equal(t, []interface{}{[]byte("hi")}, r.Messages())
+	assert.Len(t, r.Sessions(), 1)
+	r.OnClose(nil)
+	assert.Len(t, r.Sessions(), 0)
+}
+
+func TestEcho(t *testing.T) {
+	for _, network := range []Network{TCP, WS, UDP} {
+		server := StartServer(t, ServerConfig{Network: network, SessionConfig: SessionConfig{Handler: Echo}})
+		clients := Run(t, server, 4, ClientConfig{}, Send("hello", []byte("world")), ExpectEchoes())
+		assert.Len(t, clients, 4)
+		assert.Nil(t, server.Wait(EventMessage, 8, time.Second), network)
+		if network != UDP {
+			assert.Equal(t, 4, server.Count(EventOpen), network)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	for _, network := range []Network{TCP, WS} {
+		server := StartServer(t, ServerConfig{Network: network, SessionConfig: SessionConfig{Handler: Echo}})
+		clients := Run(t, server, 2, ClientConfig{},
+			Send("a"), Expect(1), Reconnect(), Send("b"), ExpectEchoes())
+		for _, c := range clients {
+			assert.Equal(t, 2, c.Count(EventOpen), network)
+			assert.Equal(t, 1, c.Count(EventClose), network)
+		}
+		assert.Nil(t, server.Wait(EventClose, 2, 5*time.Second), network)
+	}
+}
+
+func TestLargeFrames(t *testing.T) {
+	for _, network := range []Network{TCP, WS} {
+		server := StartServer(t, ServerConfig{Network: network, SessionConfig: SessionConfig{Handler: Echo}})
+		Run(t, server, 2, ClientConfig{}, SendFrame(4<<20), SendFrame(1), SendFrame(1<<20), ExpectEchoes())
+	}
+}
+
+func TestSlowReader(t *testing.T) {
+	const (
+		num   = 10
+		delay = 20 * time.Millisecond
+	)
+	server := StartServer(t, ServerConfig{Network: TCP})
+	client := StartClient(t, server, ClientConfig{})
+	assert.Nil(t, SlowRead(delay)(client))
+	assert.Nil(t, server.Wait(EventOpen, 1, time.Second))
+
+	start := time.Now()
+	ss := server.Sessions()[0]
+	for i := 0; i < num; i++ {
+		assert.Nil(t, ss.WritePkg("slow", time.Second))
+	}
+	assert.Nil(t, Expect(num)(client))
+	assert.True(t, time.Since(start) >= (num-1)*delay)
+}
@@ -0,0 +1,264 @@
+/******************************************************
+# DESC       : access log of the server sessions & frames
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-14 11:15
+# FILE       : accesslog.go
+******************************************************/
+
+package getty
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	defaultAccessLogMaxSize    = 64 * 1024 * 1024
+	defaultAccessLogMaxBackups = 3
+	// the time layout of the common log format
+	commonLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+)
+
+// AccessLogFormat is the line format of an access log.
+type AccessLogFormat int
+
+const (
+	// the common log format of the http servers, i.e.
+	// host - user [time] "request" status bytes
+	AccessLogCommon AccessLogFormat = iota
+	// one json object per line
+	AccessLogJSON
+)
+
+func (f AccessLogFormat) String() string {
+	switch f {
+	case AccessLogCommon:
+		return "common"
+	case AccessLogJSON:
+		return "json"
+	}
+
+	return fmt.Sprintf("AccessLogFormat(%d)", int(f))
+}
+
+// AccessLogRequester is implemented by the codec of a session to name the request of a
+// decoded frame in the access log, e.g. the method of a rpc frame. The frames of a tcp session
+// are only logged if its codec implements it.
+type AccessLogRequester interface {
+	AccessLogRequest(pkg interface{}) string
+}
+
+// AccessRecord is a record of the access log, which is logged when a session is closed or
+// when a frame is decoded.
+type AccessRecord struct {
+	// the open time of the session, or the arrival time of the frame
+	Time       time.Time `json:"time"`
+	Session    string    `json:"session"`
+	RemoteAddr string    `json:"remote_addr"`
+	// the principal of the client, see (SessionInspector)Identity
+	Principal string `json:"principal,omitempty"`
+	// the request line of the upgrade request of a ws/wss session, the network of a tcp
+	// session, or the request of a frame named by the AccessLogRequester
+	Request   string `json:"request"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// 101 for a ws/wss session, and 0 for a tcp session or a frame
+	Status int `json:"status,omitempty"`
+	// the bytes read & written by the session, or the length of the frame in ReadBytes
+	ReadBytes  uint64        `json:"read_bytes"`
+	WriteBytes uint64        `json:"write_bytes"`
+	Duration   time.Duration `json:"duration,omitempty"`
+	Frame      bool          `json:"frame,omitempty"`
+}
+
+// Common formats the record in the common log format. The bytes field is the bytes written
+// by the session, or the length of the frame.
+func (r AccessRecord) Common() string {
+	user := r.Principal
+	if user == "" {
+		user = "-"
+	}
+	status := "-"
+	if r.Status != 0 {
+		status = strconv.Itoa(r.Status)
+	}
+	size := r.WriteBytes
+	if r.Frame {
+		size = r.ReadBytes
+	}
+
+	return fmt.Sprintf("%s - %s [%s] %q %s %d",
+		banIP(r.RemoteAddr), user, r.Time.Format(commonLogTimeLayout), r.Request, status, size)
+}
+
+// AccessLogConfig is the config of an access log.
+type AccessLogConfig struct {
+	Format AccessLogFormat
+	// the access log file the records are appended to. It is rotated to Path.1 when it grows
+	// beyond MaxSize, Path.1 to Path.2, and so on.
+	Path string
+	// the writer the records are written to if Path is empty, e.g. os.Stdout
+	Writer io.Writer
+	// log a record per decoded frame besides the record per session
+	Frames bool
+	// Its default value is 64MB.
+	MaxSize int64
+	// the number of the rotated files which are kept. Its default value is 3.
+	MaxBackups int
+}
+
+func (c AccessLogConfig) withDefaults() AccessLogConfig {
+	if c.MaxSize <= 0 {
+		c.MaxSize = defaultAccessLogMaxSize
+	}
+	if c.MaxBackups <= 0 {
+		c.MaxBackups = defaultAccessLogMaxBackups
+	}
+
+	return c
+}
+
+// AccessLog logs one record per server session, and optionally one per frame. One access log
+// can be shared by several servers by WithServerAccessLog.
+type AccessLog struct {
+	config AccessLogConfig
+
+	lock sync.Mutex
+	file *rotatingFile
+}
+
+// NewAccessLog builds an access log. If @config.Path is not empty, the access log file is
+// opened in append mode.
+func NewAccessLog(config AccessLogConfig) (*AccessLog, error) {
+	l := &AccessLog{config: config.withDefaults()}
+	if l.config.Path != "" {
+		file, err := openRotatingFile(l.config.Path, l.config.MaxSize, l.config.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		l.file = file
+	}
+
+	return l, nil
+}
+
+// Log formats @r and writes it as one line.
+func (l *AccessLog) Log(r AccessRecord) error {
+	var line string
+	switch l.config.Format {
+	case AccessLogJSON:
+		data, err := json.Marshal(r)
+		if err != nil {
+			return jerrors.Trace(err)
+		}
+		line = string(data)
+	default:
+		line = r.Common()
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file != nil {
+		return l.file.writeLine(line)
+	}
+	if l.config.Writer != nil {
+		_, err := io.WriteString(l.config.Writer, line+"\n")
+		return jerrors.Trace(err)
+	}
+	return nil
+}
+
+// Close syncs & closes the access log file.
+func (l *AccessLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.close()
+	l.file = nil
+	return err
+}
+
+/////////////////////////////////////////
+// session access log
+/////////////////////////////////////////
+
+// remember the upgrade request of a ws/wss session for its access record
+func (s *session) setAccessRequest(r *http.Request) {
+	if s.accessLog == nil {
+		return
+	}
+
+	s.accessRequest = fmt.Sprintf("%s %s %s", r.Method, r.URL.RequestURI(), r.Proto)
+	s.userAgent = r.UserAgent()
+}
+
+func (s *session) logAccess(r AccessRecord) {
+	r.Session = s.sessionName()
+	r.RemoteAddr = s.RemoteAddr()
+	if id := s.Identity(); id != nil {
+		r.Principal = id.Principal()
+	}
+	if err := s.accessLog.Log(r); err != nil {
+		log.Warn("%s, [session.logAccess] error:%s", s.sessionToken(), err)
+	}
+}
+
+// log the access record of the closed session
+func (s *session) logSessionAccess() {
+	if s.accessLog == nil {
+		return
+	}
+
+	r := AccessRecord{
+		Time:      s.accessStart,
+		Request:   s.accessRequest,
+		UserAgent: s.userAgent,
+		Duration:  time.Since(s.accessStart),
+	}
+	if _, ok := s.Connection.(*gettyWSConn); ok {
+		r.Status = http.StatusSwitchingProtocols
+	}
+	if r.Request == "" && s.endPoint != nil {
+		r.Request = s.endPoint.EndPointType().String()
+	}
+	if conn := s.gettyConn(); conn != nil {
+		r.ReadBytes = uint64(atomic.LoadUint32(&conn.readBytes))
+		r.WriteBytes = uint64(atomic.LoadUint32(&conn.writeBytes))
+	}
+	s.logAccess(r)
+}
+
+// log the access record of the frame @pkg of @length bytes. @ws means it is a websocket message,
+// which is logged even if the codec is not an AccessLogRequester.
+func (s *session) logFrameAccess(pkg interface{}, length int, ws bool) {
+	if s.accessLog == nil || !s.accessLog.config.Frames {
+		return
+	}
+
+	requester, ok := s.getReader().(AccessLogRequester)
+	if !ok && !ws {
+		return
+	}
+	r := AccessRecord{Time: time.Now(), ReadBytes: uint64(length), Frame: true}
+	if ok {
+		r.Request = requester.AccessLogRequest(pkg)
+	} else {
+		r.Request = fmt.Sprintf("%T", pkg)
+	}
+	s.logAccess(r)
+}
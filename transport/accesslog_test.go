@@ -0,0 +1,129 @@
+package getty
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+type accessLineCodec struct {
+	lineTransferCodec
+}
+
+func (c *accessLineCodec) AccessLogRequest(pkg interface{}) string {
+	return "LINE " + pkg.(string)
+}
+
+// every Write of the access log is one line
+type accessLineWriter chan string
+
+func (w accessLineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestAccessRecordCommon(t *testing.T) {
+	r := AccessRecord{
+		Time:       time.Date(2020, 5, 14, 11, 15, 0, 0, time.FixedZone("", 8*3600)),
+		RemoteAddr: "10.0.0.1:1024",
+		Request:    "GET /ws HTTP/1.1",
+		Status:     101,
+		ReadBytes:  10,
+		WriteBytes: 20,
+	}
+	assert.Equal(t, `10.0.0.1 - - [14/May/2020:11:15:00 +0800] "GET /ws HTTP/1.1" 101 20`, r.Common())
+
+	r.Principal, r.Status, r.Frame = "alice", 0, true
+	assert.Equal(t, `10.0.0.1 - alice [14/May/2020:11:15:00 +0800] "GET /ws HTTP/1.1" - 10`, r.Common())
+}
+
+func TestAccessLogFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getty-access")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	l, err := NewAccessLog(AccessLogConfig{Path: path, MaxSize: 100, MaxBackups: 1})
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, l.Log(AccessRecord{Time: time.Now(), RemoteAddr: "127.0.0.1:80", Request: "TCP_SERVER"}))
+	}
+	assert.Nil(t, l.Close())
+
+	files, err := filepath.Glob(path + "*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{path, path + ".1"}, files)
+	data, err := ioutil.ReadFile(path + ".1")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "127.0.0.1 - - ["))
+}
+
+func TestServerAccessLog(t *testing.T) {
+	lines := make(accessLineWriter, 8)
+	accessLog, err := NewAccessLog(AccessLogConfig{Format: AccessLogJSON, Writer: lines, Frames: true})
+	assert.Nil(t, err)
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+	newSession := func(ss Session) error {
+		ss.SetPkgHandler(&accessLineCodec{})
+		ss.SetEventListener(listener)
+		return nil
+	}
+	next := func() AccessRecord {
+		var r AccessRecord
+		assert.Nil(t, json.Unmarshal([]byte(<-lines), &r))
+		return r
+	}
+
+	// tcp
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerAccessLog(accessLog))
+	server.RunEventLoop(newSession)
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.streamListener.Addr().String())
+	assert.Nil(t, err)
+	conn.Write([]byte("hello\n"))
+	<-listener.msgs
+	r := next()
+	assert.True(t, r.Frame)
+	assert.Equal(t, "LINE hello", r.Request)
+	assert.Equal(t, uint64(6), r.ReadBytes)
+	assert.Equal(t, conn.LocalAddr().String(), r.RemoteAddr)
+	conn.Close()
+	r = next()
+	assert.False(t, r.Frame)
+	assert.Equal(t, "TCP_SERVER", r.Request)
+	assert.Equal(t, uint64(6), r.ReadBytes)
+	assert.Equal(t, 0, r.Status)
+
+	// ws
+	wsServer := newServer(WS_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerAccessLog(accessLog))
+	handler := newWSHandler(wsServer, newSession)
+	handler.HandleFunc("/ws", handler.serveWSRequest)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	wsConn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?token=1",
+		http.Header{"User-Agent": []string{"device/1.0"}})
+	assert.Nil(t, err)
+	assert.Nil(t, wsConn.WriteMessage(websocket.BinaryMessage, []byte("world\n")))
+	<-listener.msgs
+	r = next()
+	assert.True(t, r.Frame)
+	assert.Equal(t, "LINE world", r.Request)
+	wsConn.Close()
+	r = next()
+	assert.Equal(t, "GET /ws?token=1 HTTP/1.1", r.Request)
+	assert.Equal(t, "device/1.0", r.UserAgent)
+	assert.Equal(t, http.StatusSwitchingProtocols, r.Status)
+	assert.True(t, r.Duration > 0)
+}
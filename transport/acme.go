@@ -0,0 +1,42 @@
+/******************************************************
+# DESC       : acme certificate provisioning of wss server
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-24 11:20
+# FILE       : acme.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/tls"
+)
+
+const (
+	// the alpn protocol of the TLS-ALPN-01 challenge, see rfc 8737
+	acmeTLSALPNProto = "acme-tls/1"
+)
+
+// ACMEManager provisions & renews the certificates of a wss server automatically by the acme
+// protocol, e.g. Let's Encrypt. *autocert.Manager of golang.org/x/crypto/acme/autocert
+// implements it, whose Cache is the pluggable certificate storage, e.g. autocert.DirCache,
+// and whose HostPolicy restricts the domains it requests certificates for.
+//
+// The wss listener answers the TLS-ALPN-01 challenges, which the acme servers only send to
+// port 443, so pls listen on 443 or forward 443 to the listener. The HTTP-01 challenges are
+// sent to port 80, which is not served by getty, e.g.
+//
+//	go http.ListenAndServe(":80", manager.HTTPHandler(nil))
+type ACMEManager interface {
+	// get the certificate of the tls handshake, and answer the TLS-ALPN-01 challenges
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// the tls config of the wss server whose certificates are provisioned by @m
+func acmeTLSConfig(m ACMEManager) *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"http/1.1", acmeTLSALPNProto},
+	}
+}
@@ -0,0 +1,63 @@
+package getty
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type testACMEManager struct {
+	cert tls.Certificate
+
+	lock   sync.Mutex
+	protos []string
+}
+
+func (m *testACMEManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.lock.Lock()
+	m.protos = append(m.protos, hello.SupportedProtos...)
+	m.lock.Unlock()
+	return &m.cert, nil
+}
+
+func TestACMEServer(t *testing.T) {
+	m := &testACMEManager{cert: newTestTLSConfig(t).Certificates[0]}
+	s := newServer(WSS_SERVER, WithLocalAddress("127.0.0.1:0"), WithWebsocketServerPath("/getty"), WithServerACME(m))
+	s.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(&MessageHandler{})
+		return nil
+	})
+	defer s.Close()
+	addr := s.streamListener.Addr().String()
+
+	// the TLS-ALPN-01 challenge reaches the acme manager
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{acmeTLSALPNProto}})
+	assert.Nil(t, err)
+	assert.Equal(t, acmeTLSALPNProto, conn.ConnectionState().NegotiatedProtocol)
+	conn.Close()
+	m.lock.Lock()
+	assert.Contains(t, m.protos, acmeTLSALPNProto)
+	m.lock.Unlock()
+
+	// the wss handshakes use the certificate of the acme manager
+	conn, err = tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}})
+	assert.Nil(t, err)
+	assert.Equal(t, m.cert.Certificate[0], conn.ConnectionState().PeerCertificates[0].Raw)
+	conn.Close()
+
+	// the HTTP-01 challenges are not answered on the wss listener
+	rsp, err := http.Get("http://" + addr + "/.well-known/acme-challenge/token")
+	assert.Nil(t, err)
+	body, err := io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	assert.NotContains(t, string(body), "token")
+}
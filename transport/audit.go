@@ -0,0 +1,255 @@
+/******************************************************
+# DESC       : audit log of security-relevant events
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-12 10:40
+# FILE       : audit.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+// AuditEvent is the type of an audit record.
+type AuditEvent int32
+
+const (
+	// the client of a session is identified by the IdentityProvider
+	AuditAuthSuccess AuditEvent = iota
+	// the client of a session fails to be identified by the IdentityProvider
+	AuditAuthFailure
+	// a remote ip is banned by the BanList for its violations
+	AuditBan
+	// a connection from a banned remote ip is rejected
+	AuditBanReject
+	// a session is rejected for the session limit of its tenant, see ResourceGroups
+	AuditQuotaViolation
+	// a tls handshake of a wss endpoint fails
+	AuditTLSError
+)
+
+func (e AuditEvent) String() string {
+	switch e {
+	case AuditAuthSuccess:
+		return "auth success"
+	case AuditAuthFailure:
+		return "auth failure"
+	case AuditBan:
+		return "ban"
+	case AuditBanReject:
+		return "ban reject"
+	case AuditQuotaViolation:
+		return "quota violation"
+	case AuditTLSError:
+		return "tls error"
+	}
+
+	return fmt.Sprintf("AuditEvent(%d)", int32(e))
+}
+
+// AuditRecord is a security-relevant event emitted to the audit sink. Seq & Hash are set by
+// the AuditLog: Seq increases by one per record, and Hash chains the record to its previous
+// one, so that a removed, inserted or modified record is detected by VerifyAuditRecords.
+type AuditRecord struct {
+	Seq   uint64
+	Time  time.Time
+	Event AuditEvent
+	// name:endpoint type:session id, which is empty if the event happens before the session is built
+	Session    string
+	LocalAddr  string
+	RemoteAddr string
+	// the identity of the client, see (SessionInspector)Identity
+	Principal string
+	Tenant    string
+	// the details of the event, e.g. the error
+	Detail string
+	// the hex sha256 of the hash of the previous record & the payload of this record
+	Hash string
+}
+
+// the fields covered by the hash
+func (r AuditRecord) payload() string {
+	return fmt.Sprintf("%d %s %s {%s:%s<->%s} principal:%q tenant:%q detail:%q",
+		r.Seq, r.Time.UTC().Format(time.RFC3339Nano), r.Event, r.Session, r.LocalAddr, r.RemoteAddr,
+		r.Principal, r.Tenant, r.Detail)
+}
+
+func (r AuditRecord) hash(prevHash string) string {
+	sum := sha256.Sum256([]byte(prevHash + "\n" + r.payload()))
+	return hex.EncodeToString(sum[:])
+}
+
+// String formats the record as one line of the audit file.
+func (r AuditRecord) String() string {
+	return r.payload() + " hash:" + r.Hash
+}
+
+// VerifyAuditRecords checks that @records are consecutive in the hash chain. @prevHash is the
+// hash of the record before records[0], or empty if records[0] is the first one. It returns
+// ErrAuditChainBroken with the first broken record.
+func VerifyAuditRecords(prevHash string, records []AuditRecord) error {
+	for i, r := range records {
+		if i > 0 && r.Seq != records[i-1].Seq+1 {
+			return newGettyError(ErrAuditChainBroken,
+				fmt.Errorf("record %d follows record %d", r.Seq, records[i-1].Seq))
+		}
+		if r.hash(prevHash) != r.Hash {
+			return newGettyError(ErrAuditChainBroken, fmt.Errorf("record %d hash mismatch", r.Seq))
+		}
+		prevHash = r.Hash
+	}
+
+	return nil
+}
+
+// AuditSink stores the audit records, e.g. in a WORM storage or a siem. WriteAudit is invoked
+// in the order of Seq, one record at a time, and it should not block for long.
+type AuditSink interface {
+	WriteAudit(AuditRecord) error
+}
+
+// AuditSinkFunc is the AuditSink of a function.
+type AuditSinkFunc func(AuditRecord) error
+
+func (f AuditSinkFunc) WriteAudit(r AuditRecord) error {
+	return f(r)
+}
+
+type auditWriterSink struct {
+	w io.Writer
+}
+
+// NewAuditWriterSink returns the AuditSink which writes every record to @w as one line.
+func NewAuditWriterSink(w io.Writer) AuditSink {
+	return auditWriterSink{w: w}
+}
+
+func (s auditWriterSink) WriteAudit(r AuditRecord) error {
+	_, err := io.WriteString(s.w, r.String()+"\n")
+	return jerrors.Trace(err)
+}
+
+// AuditConfig is the config of an audit log.
+type AuditConfig struct {
+	Sink AuditSink
+	// the Seq & Hash of the last record emitted by the previous run, which continue the hash
+	// chain after a restart. Zero values start a new chain.
+	LastSeq  uint64
+	LastHash string
+}
+
+// AuditLog numbers & chains the security-relevant events of the endpoints, and emits them to
+// its sink. One audit log can be shared by all endpoints by WithServerAuditLog/WithClientAuditLog.
+type AuditLog struct {
+	lock     sync.Mutex
+	sink     AuditSink
+	seq      uint64
+	lastHash string
+}
+
+// NewAuditLog builds an audit log.
+func NewAuditLog(config AuditConfig) *AuditLog {
+	return &AuditLog{sink: config.Sink, seq: config.LastSeq, lastHash: config.LastHash}
+}
+
+// Emit numbers & chains @r and writes it to the sink. The application can emit its own records
+// too, e.g. the auth failures of its protocol. The Seq of a record which fails to be written
+// is not reused, so the failure is evident in the chain.
+func (a *AuditLog) Emit(r AuditRecord) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.seq++
+	r.Seq = a.seq
+	r.Hash = r.hash(a.lastHash)
+	a.lastHash = r.Hash
+	if a.sink == nil {
+		return nil
+	}
+	return a.sink.WriteAudit(r)
+}
+
+// Last returns the Seq & Hash of the last emitted record, which are persisted as AuditConfig
+// for the next run.
+func (a *AuditLog) Last() (uint64, string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.seq, a.lastHash
+}
+
+// emit @r, and log the sink error. It does nothing if the audit log is nil.
+func (a *AuditLog) emit(r AuditRecord) {
+	if a == nil {
+		return
+	}
+	if err := a.Emit(r); err != nil {
+		log.Warn("[AuditLog.emit] write %s record error:%s", r.Event, jerrors.ErrorStack(err))
+	}
+}
+
+// check whether @err is a tls handshake error, i.e. a bad certificate or a tls alert
+func isTLSError(err error) bool {
+	e := jerrors.Cause(err)
+	var (
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+		hostnameErr  x509.HostnameError
+	)
+	if errors.As(e, &recordErr) || errors.As(e, &authorityErr) ||
+		errors.As(e, &invalidErr) || errors.As(e, &hostnameErr) {
+		return true
+	}
+
+	// the tls alerts are not exported
+	return strings.Contains(e.Error(), "tls: ")
+}
+
+/////////////////////////////////////////
+// session audit
+/////////////////////////////////////////
+
+// emit @event of the session to its audit log
+func (s *session) audit(event AuditEvent, detail string) {
+	if s.auditLog == nil {
+		return
+	}
+
+	r := AuditRecord{
+		Event:      event,
+		Session:    s.sessionName(),
+		LocalAddr:  s.LocalAddr(),
+		RemoteAddr: s.RemoteAddr(),
+		Detail:     detail,
+	}
+	if id := s.Identity(); id != nil {
+		r.Principal, r.Tenant = id.Principal(), id.Tenant()
+	}
+	s.auditLog.emit(r)
+}
+
+// emit @event of the connection from @remoteAddr, which has no session yet
+func (s *server) audit(event AuditEvent, remoteAddr string, detail string) {
+	s.auditLog.emit(AuditRecord{Event: event, LocalAddr: s.addr, RemoteAddr: remoteAddr, Detail: detail})
+}
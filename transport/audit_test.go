@@ -0,0 +1,170 @@
+package getty
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type auditRecorder struct {
+	lock    sync.Mutex
+	records []AuditRecord
+}
+
+func (r *auditRecorder) WriteAudit(record AuditRecord) error {
+	r.lock.Lock()
+	r.records = append(r.records, record)
+	r.lock.Unlock()
+	return nil
+}
+
+func (r *auditRecorder) events() []AuditEvent {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	events := make([]AuditEvent, 0, len(r.records))
+	for _, record := range r.records {
+		events = append(events, record.Event)
+	}
+	return events
+}
+
+func TestAuditLogChain(t *testing.T) {
+	recorder := &auditRecorder{}
+	audit := NewAuditLog(AuditConfig{Sink: recorder})
+	for _, event := range []AuditEvent{AuditAuthSuccess, AuditBan, AuditTLSError} {
+		assert.Nil(t, audit.Emit(AuditRecord{Event: event, RemoteAddr: "10.0.0.1:80", Detail: "x"}))
+	}
+	records := recorder.records
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, uint64(1), records[0].Seq)
+	assert.Equal(t, uint64(3), records[2].Seq)
+	assert.Nil(t, VerifyAuditRecords("", records))
+
+	// modified
+	tampered := append([]AuditRecord(nil), records...)
+	tampered[1].Detail = "y"
+	err := VerifyAuditRecords("", tampered)
+	assert.True(t, errors.Is(err, ErrAuditChainBroken))
+	assert.Contains(t, err.Error(), "record 2 hash mismatch")
+
+	// removed
+	err = VerifyAuditRecords("", []AuditRecord{records[0], records[2]})
+	assert.Contains(t, err.Error(), "record 3 follows record 1")
+
+	// the chain continues after a restart
+	seq, hash := audit.Last()
+	assert.Equal(t, uint64(3), seq)
+	assert.Equal(t, records[2].Hash, hash)
+	audit = NewAuditLog(AuditConfig{Sink: recorder, LastSeq: seq, LastHash: hash})
+	assert.Nil(t, audit.Emit(AuditRecord{Event: AuditAuthFailure}))
+	assert.Equal(t, uint64(4), recorder.records[3].Seq)
+	assert.Nil(t, VerifyAuditRecords("", recorder.records))
+	assert.Nil(t, VerifyAuditRecords(records[1].Hash, recorder.records[2:]))
+}
+
+func TestAuditWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	audit := NewAuditLog(AuditConfig{Sink: NewAuditWriterSink(&buf)})
+	assert.Nil(t, audit.Emit(AuditRecord{Event: AuditQuotaViolation, Principal: "alice", Tenant: "acme"}))
+	_, hash := audit.Last()
+
+	line := buf.String()
+	assert.True(t, strings.HasPrefix(line, "1 "))
+	assert.Contains(t, line, `quota violation {:<->} principal:"alice" tenant:"acme" detail:""`)
+	assert.True(t, strings.HasSuffix(line, " hash:"+hash+"\n"))
+}
+
+func TestServerAudit(t *testing.T) {
+	var (
+		reject   int32
+		recorder = &auditRecorder{}
+		sessions = make(chan Session, 4)
+		listener = &lineListener{msgs: make(chan interface{}, 4)}
+	)
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"),
+		WithServerAuditLog(NewAuditLog(AuditConfig{Sink: recorder})),
+		WithServerIdentity(&IdentityConfig{
+			Provider:       newTestIdentityProvider(&reject),
+			ResourceGroups: NewResourceGroups(ResourceGroupLimits{MaxSessions: 1}),
+		}))
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(listener)
+		sessions <- ss
+		return nil
+	})
+	defer server.Close()
+	addr := server.streamListener.Addr().String()
+
+	closed := func(conn net.Conn) {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := conn.Read(make([]byte, 1))
+		assert.NotNil(t, err)
+	}
+	dial := func(first string) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		assert.Nil(t, err)
+		conn.Write([]byte(first))
+		<-sessions
+		return conn
+	}
+
+	conn := dial("login alice@acme\nhello\n")
+	defer conn.Close()
+	<-listener.msgs
+	conn2 := dial("login bob@acme\n")
+	defer conn2.Close()
+	closed(conn2)
+	conn3 := dial("hello\n")
+	defer conn3.Close()
+	closed(conn3)
+
+	assert.Equal(t, []AuditEvent{AuditAuthSuccess, AuditAuthSuccess, AuditQuotaViolation, AuditAuthFailure},
+		recorder.events())
+	recorder.lock.Lock()
+	records := recorder.records
+	recorder.lock.Unlock()
+	assert.Equal(t, "alice", records[0].Principal)
+	assert.Equal(t, "acme", records[0].Tenant)
+	assert.Equal(t, conn.LocalAddr().String(), records[0].RemoteAddr)
+	assert.Equal(t, "bob", records[2].Principal)
+	assert.Equal(t, "tenant:acme", records[2].Detail)
+	assert.Equal(t, "login required", records[3].Detail)
+	assert.Nil(t, VerifyAuditRecords("", records))
+}
+
+func TestServerAuditBanReject(t *testing.T) {
+	recorder := &auditRecorder{}
+	banList := NewBanList(nil)
+	defer banList.Close()
+	banList.Ban("127.0.0.1", time.Minute)
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerBanList(banList),
+		WithServerAuditLog(NewAuditLog(AuditConfig{Sink: recorder})))
+	server.RunEventLoop(func(ss Session) error { return nil })
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.streamListener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	assert.Equal(t, []AuditEvent{AuditBanReject}, recorder.events())
+}
+
+func TestIsTLSError(t *testing.T) {
+	assert.True(t, isTLSError(jerrors.Trace(x509.UnknownAuthorityError{})))
+	assert.True(t, isTLSError(errors.New("remote error: tls: bad certificate")))
+	assert.False(t, isTLSError(errors.New("connection refused")))
+}
@@ -0,0 +1,235 @@
+/******************************************************
+# DESC       : remote ip ban list
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-14 17:25
+# FILE       : ban.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+const (
+	banSweepInterval = time.Second
+)
+
+// BanTrigger is the kind of violation which may get a remote ip banned
+type BanTrigger int32
+
+const (
+	// reported by the application when the peer fails to pass the authentication
+	BanTriggerAuthFailure BanTrigger = 1
+	// reported by getty when the codec fails to parse the peer's pkg, or the pkg is too large
+	BanTriggerProtocolError BanTrigger = 2
+	// reported by the application when the peer exceeds its rate limit
+	BanTriggerRateViolation BanTrigger = 3
+)
+
+func (t BanTrigger) String() string {
+	switch t {
+	case BanTriggerAuthFailure:
+		return "auth failure"
+	case BanTriggerProtocolError:
+		return "protocol error"
+	case BanTriggerRateViolation:
+		return "rate violation"
+	}
+
+	return strconv.Itoa(int(t))
+}
+
+// BanPolicy decides when and how long a remote ip is banned for a BanTrigger.
+type BanPolicy struct {
+	// the remote ip will be banned after it triggers @Threshold violations in @Window.
+	Threshold int
+	Window    time.Duration
+	// the duration of the first ban. it will be doubled for every subsequent ban of
+	// the same ip until it reaches @MaxDuration.
+	Duration    time.Duration
+	MaxDuration time.Duration
+}
+
+type banEntry struct {
+	// violation timestamps of every trigger in the current window
+	violations map[BanTrigger][]time.Time
+	// ban times, it decides the next ban duration
+	bans  uint
+	until time.Time
+	// the ban level will be reset if the ip keeps quiet after its ban expiry for @maxDuration
+	forgetAt time.Time
+}
+
+// BanList bans remote ips at the accept filter level of tcp/ws/wss servers. It is set by
+// WithServerBanList, and the expired entries are removed by a sweeper goroutine. A BanList can
+// be shared by several servers, so it will not be closed when the server is closed.
+type BanList struct {
+	lock     sync.Mutex
+	policies map[BanTrigger]BanPolicy
+	entries  map[string]*banEntry
+
+	once sync.Once
+	done chan struct{}
+}
+
+// NewBanList creates a BanList. The violation of a trigger without policy will be ignored.
+func NewBanList(policies map[BanTrigger]BanPolicy) *BanList {
+	b := &BanList{
+		policies: make(map[BanTrigger]BanPolicy, len(policies)),
+		entries:  make(map[string]*banEntry),
+		done:     make(chan struct{}),
+	}
+	for trigger, policy := range policies {
+		if policy.Threshold <= 0 {
+			policy.Threshold = 1
+		}
+		if policy.MaxDuration < policy.Duration {
+			policy.MaxDuration = policy.Duration
+		}
+		b.policies[trigger] = policy
+	}
+
+	go b.sweep()
+	return b
+}
+
+// get the ip of @addr, whose format may be "ip:port" or "ip".
+func banIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
+
+// the entry can be removed if it is not banned and all its violations are out of window
+func (e *banEntry) expired(now time.Time, policies map[BanTrigger]BanPolicy) bool {
+	if now.Before(e.until) || now.Before(e.forgetAt) {
+		return false
+	}
+	for trigger, violations := range e.violations {
+		if n := len(violations); n > 0 && now.Sub(violations[n-1]) <= policies[trigger].Window {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (b *BanList) entry(ip string) *banEntry {
+	e, ok := b.entries[ip]
+	if !ok {
+		e = &banEntry{violations: make(map[BanTrigger][]time.Time)}
+		b.entries[ip] = e
+	}
+
+	return e
+}
+
+// Report records a violation of the remote @addr. It returns true if @addr gets banned.
+func (b *BanList) Report(addr string, trigger BanTrigger) bool {
+	policy, ok := b.policies[trigger]
+	if !ok {
+		return false
+	}
+
+	ip := banIP(addr)
+	now := time.Now()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e := b.entry(ip)
+	if now.Before(e.until) {
+		return true
+	}
+	violations := e.violations[trigger]
+	for len(violations) > 0 && now.Sub(violations[0]) > policy.Window {
+		violations = violations[1:]
+	}
+	violations = append(violations, now)
+	if len(violations) < policy.Threshold {
+		e.violations[trigger] = violations
+		return false
+	}
+
+	delete(e.violations, trigger)
+	// check the bound before shifting, for the shifted duration may overflow
+	d := policy.MaxDuration
+	if policy.Duration <= policy.MaxDuration>>e.bans {
+		d = policy.Duration << e.bans
+	}
+	if d <= 0 {
+		d = policy.MaxDuration
+	}
+	e.bans++
+	e.until = now.Add(d)
+	e.forgetAt = e.until.Add(policy.MaxDuration)
+	log.Warn("remote ip %s is banned for %s, trigger:%s, ban times:%d", ip, d, trigger, e.bans)
+	return true
+}
+
+// Ban bans the remote @addr for @d manually.
+func (b *BanList) Ban(addr string, d time.Duration) {
+	ip := banIP(addr)
+	b.lock.Lock()
+	e := b.entry(ip)
+	e.until = time.Now().Add(d)
+	if e.forgetAt.Before(e.until) {
+		e.forgetAt = e.until
+	}
+	b.lock.Unlock()
+}
+
+// Unban removes the remote @addr from the ban list and forgets its violations.
+func (b *BanList) Unban(addr string) {
+	b.lock.Lock()
+	delete(b.entries, banIP(addr))
+	b.lock.Unlock()
+}
+
+// IsBanned checks whether the remote @addr is banned now.
+func (b *BanList) IsBanned(addr string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e, ok := b.entries[banIP(addr)]
+	return ok && time.Now().Before(e.until)
+}
+
+func (b *BanList) sweep() {
+	ticker := time.NewTicker(banSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+
+		case now := <-ticker.C:
+			b.lock.Lock()
+			for ip, e := range b.entries {
+				if e.expired(now, b.policies) {
+					delete(b.entries, ip)
+				}
+			}
+			b.lock.Unlock()
+		}
+	}
+}
+
+// Close stops the sweeper goroutine.
+func (b *BanList) Close() {
+	b.once.Do(func() {
+		close(b.done)
+	})
+}
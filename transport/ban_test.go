@@ -0,0 +1,58 @@
+package getty
+
+import (
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBanList(t *testing.T) {
+	b := NewBanList(map[BanTrigger]BanPolicy{
+		BanTriggerAuthFailure: {Threshold: 2, Window: time.Minute, Duration: time.Minute, MaxDuration: 3 * time.Minute},
+	})
+	defer b.Close()
+
+	addr := "127.0.0.1:10000"
+	assert.False(t, b.Report(addr, BanTriggerRateViolation))
+	assert.False(t, b.Report(addr, BanTriggerAuthFailure))
+	assert.False(t, b.IsBanned(addr))
+	assert.True(t, b.Report(addr, BanTriggerAuthFailure))
+	assert.True(t, b.IsBanned("127.0.0.1:10001"))
+	assert.True(t, b.IsBanned("127.0.0.1"))
+	assert.False(t, b.IsBanned("127.0.0.2:10000"))
+
+	// the ban duration is doubled, and limited by MaxDuration
+	e := b.entries["127.0.0.1"]
+	for _, d := range []time.Duration{2 * time.Minute, 3 * time.Minute} {
+		e.until = time.Now()
+		b.Report(addr, BanTriggerAuthFailure)
+		assert.True(t, b.Report(addr, BanTriggerAuthFailure))
+		assert.InDelta(t, float64(d), float64(time.Until(e.until)), float64(time.Second))
+	}
+
+	b.Unban(addr)
+	assert.False(t, b.IsBanned(addr))
+	b.Ban(addr, time.Minute)
+	assert.True(t, b.IsBanned(addr))
+	assert.False(t, b.entries["127.0.0.1"].expired(time.Now(), b.policies))
+	assert.True(t, b.entries["127.0.0.1"].expired(time.Now().Add(2*time.Minute), b.policies))
+}
+
+func TestBanListLargeDuration(t *testing.T) {
+	// the durations shifted left overflow int64, e.g. 5<<62 wraps to 1<<62
+	b := NewBanList(map[BanTrigger]BanPolicy{
+		BanTriggerAuthFailure: {Threshold: 1, Window: time.Minute, Duration: 5 << 58, MaxDuration: 1<<63 - 1},
+	})
+	defer b.Close()
+
+	addr := "127.0.0.1:10000"
+	for _, d := range []time.Duration{5 << 58, 5 << 59, 5 << 60, 1<<63 - 1, 1<<63 - 1} {
+		assert.True(t, b.Report(addr, BanTriggerAuthFailure))
+		e := b.entries["127.0.0.1"]
+		assert.InDelta(t, float64(d), float64(time.Until(e.until)), float64(time.Second))
+		e.until = time.Now()
+	}
+}
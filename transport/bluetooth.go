@@ -0,0 +1,84 @@
+/******************************************************
+# DESC       : bluetooth transport
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-24 15:20
+# FILE       : bluetooth.go
+******************************************************/
+
+package getty
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	bluetoothRFCOMM = "rfcomm"
+	bluetoothL2CAP  = "l2cap"
+)
+
+// bluetoothAddr is a parsed bluetooth server address
+type bluetoothAddr struct {
+	proto string
+	// big-endian device address, e.g. AA:BB:CC:DD:EE:FF is {0xAA, ..., 0xFF}
+	bdaddr [6]byte
+	// rfcomm channel or l2cap psm
+	port uint16
+}
+
+// parse the bluetooth server address @addr, e.g. "rfcomm://AA:BB:CC:DD:EE:FF/1" or
+// "l2cap://AA:BB:CC:DD:EE:FF/4097".
+func parseBluetoothAddr(addr string) (*bluetoothAddr, error) {
+	var a bluetoothAddr
+
+	idx := strings.Index(addr, "://")
+	if idx < 0 {
+		return nil, jerrors.Errorf("illegal bluetooth address %s", addr)
+	}
+	a.proto = addr[:idx]
+	if a.proto != bluetoothRFCOMM && a.proto != bluetoothL2CAP {
+		return nil, jerrors.Errorf("illegal bluetooth protocol %s", a.proto)
+	}
+	fields := strings.Split(addr[idx+3:], "/")
+	if len(fields) != 2 {
+		return nil, jerrors.Errorf("illegal bluetooth address %s", addr)
+	}
+
+	octets := strings.Split(fields[0], ":")
+	if len(octets) != len(a.bdaddr) {
+		return nil, jerrors.Errorf("illegal bluetooth device address %s", fields[0])
+	}
+	for i, octet := range octets {
+		b, err := hex.DecodeString(octet)
+		if err != nil || len(b) != 1 {
+			return nil, jerrors.Errorf("illegal bluetooth device address %s", fields[0])
+		}
+		a.bdaddr[i] = b[0]
+	}
+
+	port, err := strconv.ParseUint(fields[1], 0, 16)
+	if err != nil {
+		return nil, jerrors.Annotatef(err, "illegal bluetooth port %s", fields[1])
+	}
+	if a.proto == bluetoothRFCOMM && (port == 0 || 30 < port) {
+		return nil, jerrors.Errorf("illegal rfcomm channel %d", port)
+	}
+	a.port = uint16(port)
+
+	return &a, nil
+}
+
+// NewBluetoothClient builds an experimental bluetooth client whose server address is like
+// "rfcomm://AA:BB:CC:DD:EE:FF/1" or "l2cap://AA:BB:CC:DD:EE:FF/4097". Its session reads &
+// writes the rfcomm stream or l2cap sequential packets as a tcp stream, and the client
+// reconnects when the session is closed. It is only supported on linux with BlueZ.
+func NewBluetoothClient(opts ...ClientOption) Client {
+	return newClient(BLUETOOTH_CLIENT, opts...)
+}
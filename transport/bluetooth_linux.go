@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+/******************************************************
+# DESC       : bluetooth socket on linux
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-24 15:20
+# FILE       : bluetooth_linux.go
+******************************************************/
+
+package getty
+
+import (
+	"io"
+	"os"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+	"golang.org/x/sys/unix"
+)
+
+// connect to the bluetooth server @addr
+func openBluetooth(addr string) (io.ReadWriteCloser, error) {
+	a, err := parseBluetoothAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		fd int
+		sa unix.Sockaddr
+	)
+	switch a.proto {
+	case bluetoothRFCOMM:
+		fd, err = unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, unix.BTPROTO_RFCOMM)
+		rfcomm := &unix.SockaddrRFCOMM{Channel: uint8(a.port)}
+		// the rfcomm address is little-endian
+		for i := range a.bdaddr {
+			rfcomm.Addr[i] = a.bdaddr[len(a.bdaddr)-1-i]
+		}
+		sa = rfcomm
+	case bluetoothL2CAP:
+		fd, err = unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, unix.BTPROTO_L2CAP)
+		sa = &unix.SockaddrL2{PSM: a.port, Addr: a.bdaddr}
+	}
+	if err != nil {
+		return nil, jerrors.Annotatef(err, "bluetooth socket(%s)", a.proto)
+	}
+
+	if err = unix.Connect(fd, sa); err != nil {
+		unix.Close(fd)
+		return nil, jerrors.Annotatef(err, "bluetooth connect(%s)", addr)
+	}
+	// the non-blocking file is added to the runtime poller, so its deadlines work
+	if err = unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, jerrors.Trace(err)
+	}
+
+	return os.NewFile(uintptr(fd), addr), nil
+}
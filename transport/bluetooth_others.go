@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+/******************************************************
+# DESC       : bluetooth socket stub
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-24 15:20
+# FILE       : bluetooth_others.go
+******************************************************/
+
+package getty
+
+import (
+	"io"
+)
+
+func openBluetooth(addr string) (io.ReadWriteCloser, error) {
+	if _, err := parseBluetoothAddr(addr); err != nil {
+		return nil, err
+	}
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,43 @@
+package getty
+
+import (
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBluetoothAddr(t *testing.T) {
+	a, err := parseBluetoothAddr("rfcomm://AA:BB:CC:DD:EE:0F/3")
+	assert.Nil(t, err)
+	assert.Equal(t, bluetoothAddr{proto: bluetoothRFCOMM, bdaddr: [6]byte{0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0x0F}, port: 3}, *a)
+
+	a, err = parseBluetoothAddr("l2cap://00:11:22:33:44:55/0x1001")
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(0x1001), a.port)
+
+	for _, addr := range []string{
+		"AA:BB:CC:DD:EE:FF/1",
+		"sco://AA:BB:CC:DD:EE:FF/1",
+		"rfcomm://AA:BB:CC:DD:EE/1",
+		"rfcomm://AA:BB:CC:DD:EE:GG/1",
+		"rfcomm://AA:BB:CC:DD:EE:FF/31",
+		"rfcomm://AA:BB:CC:DD:EE:FF",
+	} {
+		_, err = parseBluetoothAddr(addr)
+		assert.NotNil(t, err, addr)
+	}
+}
+
+func TestBluetoothClient(t *testing.T) {
+	clt := NewBluetoothClient(WithServerAddress("rfcomm://AA:BB:CC:DD:EE:FF/1"), WithConnectionNumber(1))
+	assert.Equal(t, BLUETOOTH_CLIENT, clt.EndPointType())
+	clt.Close()
+
+	// no bluetooth adapter in the test environment
+	_, err := openBluetooth("rfcomm://AA:BB:CC:DD:EE:FF/1")
+	assert.NotNil(t, err)
+	_, err = openBluetooth("rfcomm://AA:BB:CC:DD:EE:FF")
+	assert.NotNil(t, err)
+}
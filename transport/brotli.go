@@ -0,0 +1,167 @@
+/******************************************************
+# DESC       : brotli compression of tcp/websocket session
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-16 10:20
+# FILE       : brotli.go
+******************************************************/
+
+package getty
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	MinBrotliQuality     = 0
+	MaxBrotliQuality     = 11
+	defaultBrotliQuality = 6
+	// the brotli compress types are from compressBrotliBase(quality 0) to
+	// compressBrotliBase+MaxBrotliQuality(quality 11)
+	compressBrotliBase = 20
+)
+
+var (
+	errBrotliMsgTooLarge = jerrors.New("brotli decoded message too large")
+)
+
+// BrotliWriter is the compressing writer of a BrotliCodec.
+type BrotliWriter interface {
+	io.WriteCloser
+	// write out the pending bytes, so the peer can decode all of the written bytes
+	Flush() error
+}
+
+// BrotliCodec is the brotli implementation of CompressBrotli. getty does not depend on any
+// brotli library, so pls set it by SetBrotliCodec before using brotli, e.g. by the pure go
+// github.com/andybalholm/brotli:
+//
+//	getty.SetBrotliCodec(&getty.BrotliCodec{
+//		NewReader: func(r io.Reader) io.Reader { return brotli.NewReader(r) },
+//		NewWriter: func(w io.Writer, quality int) getty.BrotliWriter {
+//			return brotli.NewWriterLevel(w, quality)
+//		},
+//	})
+//
+// or by github.com/google/brotli/go/cbrotli whose writer is created by cbrotli.NewWriter(w,
+// cbrotli.WriterOptions{Quality: quality}).
+type BrotliCodec struct {
+	// returns the decompressing reader of @r
+	NewReader func(r io.Reader) io.Reader
+	// returns the compressing writer of @w, @quality is between MinBrotliQuality and
+	// MaxBrotliQuality
+	NewWriter func(w io.Writer, quality int) BrotliWriter
+}
+
+var (
+	brotliCodecLock sync.RWMutex
+	brotliCodecInst *BrotliCodec
+)
+
+// SetBrotliCodec sets the brotli implementation, and nil @codec unsets it. The sessions whose
+// compress type has been set keep the old one.
+func SetBrotliCodec(codec *BrotliCodec) {
+	brotliCodecLock.Lock()
+	brotliCodecInst = codec
+	brotliCodecLock.Unlock()
+}
+
+// get the brotli implementation, it panics if it is not set.
+func getBrotliCodec() *BrotliCodec {
+	brotliCodecLock.RLock()
+	defer brotliCodecLock.RUnlock()
+	if brotliCodecInst == nil {
+		panic("brotli codec is not set, pls set it by SetBrotliCodec")
+	}
+	return brotliCodecInst
+}
+
+// CompressBrotliQuality returns the brotli compress type of @quality, which is between
+// MinBrotliQuality and MaxBrotliQuality. CompressBrotli is of quality 6, and the higher
+// qualities get better ratios by more cpu.
+func CompressBrotliQuality(quality int) CompressType {
+	if quality < MinBrotliQuality || MaxBrotliQuality < quality {
+		panic(fmt.Sprintf("illegal brotli quality %d", quality))
+	}
+
+	return CompressType(compressBrotliBase + quality)
+}
+
+// get the brotli quality of @c, and false if @c is not brotli.
+func brotliQuality(c CompressType) (int, bool) {
+	quality := int(c) - compressBrotliBase
+	return quality, MinBrotliQuality <= quality && quality <= MaxBrotliQuality
+}
+
+type brotliWrapper struct {
+	codec   *BrotliCodec
+	quality int
+}
+
+func (w brotliWrapper) WrapReader(r io.Reader) io.Reader {
+	return w.codec.NewReader(r)
+}
+
+func (w brotliWrapper) WrapWriter(writer io.Writer) io.Writer {
+	return &brotliFlusher{writer: w.codec.NewWriter(writer, w.quality)}
+}
+
+// get the brotliWrapper stored in @v, whose codec is nil if nothing is stored
+func loadBrotli(v *atomic.Value) brotliWrapper {
+	brotli, _ := v.Load().(brotliWrapper)
+	return brotli
+}
+
+// brotliFlusher flushes every pkg written, so the peer can decode it at once
+type brotliFlusher struct {
+	writer BrotliWriter
+}
+
+func (f *brotliFlusher) Write(p []byte) (int, error) {
+	n, err := f.writer.Write(p)
+	if err != nil {
+		return n, jerrors.Trace(err)
+	}
+	if err = f.writer.Flush(); err != nil {
+		return 0, jerrors.Trace(err)
+	}
+
+	return n, nil
+}
+
+// compress @data into a whole brotli stream, which is the payload of a websocket message.
+func brotliEncode(codec *BrotliCodec, quality int, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := codec.NewWriter(&buf, quality)
+	if _, err := w.Write(data); err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, jerrors.Trace(err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompress the payload @data of a websocket message.
+func brotliDecode(codec *BrotliCodec, data []byte) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(codec.NewReader(bytes.NewReader(data)), maxFramedMessageLen+1))
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	if len(b) > maxFramedMessageLen {
+		return nil, errBrotliMsgTooLarge
+	}
+
+	return b, nil
+}
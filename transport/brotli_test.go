@@ -0,0 +1,122 @@
+package getty
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+type flateBrotliWriter struct {
+	*flate.Writer
+}
+
+// flateBrotliCodec stands in for a brotli library, which is not a dependency of getty.
+// The quality is taken as the flate level.
+var flateBrotliCodec = &BrotliCodec{
+	NewReader: func(r io.Reader) io.Reader { return flate.NewReader(r) },
+	NewWriter: func(w io.Writer, quality int) BrotliWriter {
+		if quality > flate.BestCompression {
+			quality = flate.BestCompression
+		}
+		fw, _ := flate.NewWriter(w, quality)
+		return flateBrotliWriter{fw}
+	},
+}
+
+func TestCompressBrotliQuality(t *testing.T) {
+	assert.Equal(t, CompressType(CompressBrotli), CompressBrotliQuality(defaultBrotliQuality))
+	assert.Panics(t, func() { CompressBrotliQuality(MaxBrotliQuality + 1) })
+	for _, c := range []CompressType{CompressBrotliQuality(MinBrotliQuality), CompressBrotli, CompressBrotliQuality(MaxBrotliQuality)} {
+		_, ok := brotliQuality(c)
+		assert.True(t, ok, c)
+	}
+	for _, c := range []CompressType{CompressNone, CompressSnappy, CompressSnappyBlock, CompressBestCompression} {
+		_, ok := brotliQuality(c)
+		assert.False(t, ok, c)
+	}
+
+	SetBrotliCodec(nil)
+	assert.Panics(t, func() { CompressWrapper(CompressBrotli) })
+}
+
+func TestSessionBrotli(t *testing.T) {
+	SetBrotliCodec(flateBrotliCodec)
+	defer SetBrotliCodec(nil)
+
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+	src, dst := newPipeSessions(t)
+	for _, ss := range []*session{src, dst} {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(listener)
+		ss.SetCompressType(CompressBrotli)
+		ss.run()
+		defer ss.Close()
+	}
+
+	large := strings.Repeat("getty ", 100)
+	assert.Nil(t, src.WritePkg(large, 0))
+	assert.Equal(t, large, <-listener.msgs)
+	assert.Nil(t, dst.WritePkg("hello", 0))
+	assert.Equal(t, "hello", <-listener.msgs)
+}
+
+func TestWSSessionBrotli(t *testing.T) {
+	SetBrotliCodec(flateBrotliCodec)
+	defer SetBrotliCodec(nil)
+
+	large := strings.Repeat("getty ", 100)
+	received := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		_, p, err := conn.ReadMessage()
+		assert.Nil(t, err)
+		received <- p
+		// echo the compressed message
+		assert.Nil(t, conn.WriteMessage(websocket.BinaryMessage, p))
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.Nil(t, err)
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+	ss := newWSSession(conn, newClient(WS_CLIENT, WithServerAddress(srv.URL), WithConnectionNumber(1))).(*session)
+	ss.SetPkgHandler(&lineTransferCodec{})
+	ss.SetEventListener(listener)
+	ss.SetCompressType(CompressBrotli)
+	ss.run()
+	defer ss.Close()
+
+	assert.Nil(t, ss.WritePkg(large, 0))
+	p := <-received
+	assert.True(t, len(p) < len(large))
+	decoded, err := brotliDecode(flateBrotliCodec, p)
+	assert.Nil(t, err)
+	assert.Equal(t, large+"\n", string(decoded))
+	select {
+	case pkg := <-listener.msgs:
+		assert.Equal(t, large, pkg)
+	case <-time.After(time.Second):
+		t.Fatal("the pkg is not received")
+	}
+}
+
+func TestBrotliDecodeLimit(t *testing.T) {
+	data, err := brotliEncode(flateBrotliCodec, MaxBrotliQuality, bytes.Repeat([]byte{0}, maxFramedMessageLen+1))
+	assert.Nil(t, err)
+	_, err = brotliDecode(flateBrotliCodec, data)
+	assert.Equal(t, errBrotliMsgTooLarge, err)
+}
@@ -0,0 +1,139 @@
+/******************************************************
+# DESC       : bdp based socket buffer auto tuning
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-25 10:40
+# FILE       : buftune.go
+******************************************************/
+
+package getty
+
+import (
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	defaultBufferTuneMinSize  = 64 * 1024
+	defaultBufferTuneMaxSize  = 8 * 1024 * 1024
+	defaultBufferTuneInterval = time.Second
+)
+
+// BufferTuneConfig is the socket buffer auto tuning config of a session, which is set by
+// (StreamSession)SetBufferAutoTune. The SO_SNDBUF/SO_RCVBUF of the tcp connection are set to
+// twice the bandwidth-delay product, which is the product of the kernel smoothed rtt
+// and the 10 seconds write/read rate of the session.
+type BufferTuneConfig struct {
+	// the buffer sizes are kept in [MinSize, MaxSize]. Their default values are 64KB and 8MB.
+	// The kernel may cap the size further, e.g. by net.core.wmem_max/rmem_max on linux.
+	MinSize int
+	MaxSize int
+	// the tuning interval. Its default value is 1s.
+	Interval time.Duration
+}
+
+func (c BufferTuneConfig) withDefaults() BufferTuneConfig {
+	if c.MinSize <= 0 {
+		c.MinSize = defaultBufferTuneMinSize
+	}
+	if c.MaxSize < c.MinSize {
+		c.MaxSize = defaultBufferTuneMaxSize
+		if c.MaxSize < c.MinSize {
+			c.MaxSize = c.MinSize
+		}
+	}
+	if c.Interval <= 0 {
+		c.Interval = defaultBufferTuneInterval
+	}
+
+	return c
+}
+
+// bufferTuner is only used by the write goroutine of the session.
+type bufferTuner struct {
+	config BufferTuneConfig
+	// the latest buffer sizes set by the tuner
+	sndSize int
+	rcvSize int
+}
+
+func newBufferTuner(config BufferTuneConfig) *bufferTuner {
+	return &bufferTuner{config: config}
+}
+
+// get the buffer size of the traffic of @bytesPerSec on the path of @rtt
+func (t *bufferTuner) size(rtt time.Duration, bytesPerSec float64) int {
+	size := int(2 * bytesPerSec * rtt.Seconds())
+	if size < t.config.MinSize {
+		size = t.config.MinSize
+	}
+	if size > t.config.MaxSize {
+		size = t.config.MaxSize
+	}
+
+	return size
+}
+
+// the buffer is resized only if the size changes by a quarter, so it is not
+// resized on every jitter of the rtt or the rate.
+func (t *bufferTuner) changed(current, size int) bool {
+	diff := size - current
+	if diff < 0 {
+		diff = -diff
+	}
+	return current == 0 || diff >= current>>2
+}
+
+// SetBufferAutoTune enables the socket buffer auto tuning of a tcp/websocket session, and
+// nil @config disables it. It should be invoked before the session runs, e.g. in
+// NewSessionCallback. It has no effect on udp sessions or on a platform without
+// (SessionInspector)TCPInfo. Notice that setting SO_RCVBUF disables the receive buffer auto
+// tuning of the linux kernel on the connection.
+func (s *session) SetBufferAutoTune(config *BufferTuneConfig) {
+	if config == nil {
+		s.bufferTune = nil
+		return
+	}
+
+	s.bufferTune = newBufferTuner(config.withDefaults())
+}
+
+// resize the socket buffers of the session by the bandwidth-delay product
+func (s *session) tuneBuffers() {
+	t := s.bufferTune
+	tcpConn := underlyingTCPConn(s.Conn())
+	if t == nil || tcpConn == nil {
+		return
+	}
+	info := getTCPInfo(tcpConn)
+	if info == nil || info.RTT <= 0 {
+		return
+	}
+
+	rate := s.Rates().Rate10s
+	if size := t.size(info.RTT, rate.WriteBytes); t.changed(t.sndSize, size) {
+		if err := tcpConn.SetWriteBuffer(size); err != nil {
+			log.Warn("%s, [session.tuneBuffers] SetWriteBuffer(%d) = error{%s}",
+				s.sessionToken(), size, jerrors.ErrorStack(err))
+		} else {
+			log.Debug("%s, [session.tuneBuffers] rtt:%s, write rate:%.1f/s, SO_SNDBUF:%d",
+				s.sessionToken(), info.RTT, rate.WriteBytes, size)
+			t.sndSize = size
+		}
+	}
+	if size := t.size(info.RTT, rate.ReadBytes); t.changed(t.rcvSize, size) {
+		if err := tcpConn.SetReadBuffer(size); err != nil {
+			log.Warn("%s, [session.tuneBuffers] SetReadBuffer(%d) = error{%s}",
+				s.sessionToken(), size, jerrors.ErrorStack(err))
+		} else {
+			log.Debug("%s, [session.tuneBuffers] rtt:%s, read rate:%.1f/s, SO_RCVBUF:%d",
+				s.sessionToken(), info.RTT, rate.ReadBytes, size)
+			t.rcvSize = size
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package getty
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferTuner(t *testing.T) {
+	c := BufferTuneConfig{MinSize: 1024, MaxSize: 1 << 20}.withDefaults()
+	assert.Equal(t, defaultBufferTuneInterval, c.Interval)
+	assert.Equal(t, defaultBufferTuneMaxSize, BufferTuneConfig{}.withDefaults().MaxSize)
+
+	tuner := newBufferTuner(c)
+	assert.Equal(t, 1024, tuner.size(time.Millisecond, 0))
+	// 10MB/s on a 100ms path
+	assert.Equal(t, 1<<20, tuner.size(100*time.Millisecond, 10e6))
+	assert.InDelta(t, 200000, tuner.size(10*time.Millisecond, 10e6), 1)
+
+	assert.True(t, tuner.changed(0, 1024))
+	assert.False(t, tuner.changed(200000, 180000))
+	assert.True(t, tuner.changed(200000, 100000))
+}
+
+func TestSessionBufferAutoTune(t *testing.T) {
+	ss, _ := newPipeSessions(t)
+	ss.SetBufferAutoTune(&BufferTuneConfig{})
+	ss.tuneBuffers()
+	assert.Equal(t, 0, ss.bufferTune.sndSize)
+
+	ss = newTCPTestSession(t)
+	ss.SetBufferAutoTune(&BufferTuneConfig{MinSize: 32 * 1024})
+	_, err := ss.Conn().Write([]byte("hello"))
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	ss.tuneBuffers()
+	if runtime.GOOS != "linux" {
+		assert.Equal(t, 0, ss.bufferTune.sndSize)
+		return
+	}
+	assert.Equal(t, 32*1024, ss.bufferTune.sndSize)
+	assert.Equal(t, 32*1024, ss.bufferTune.rcvSize)
+	// linux doubles the value set by setsockopt
+	assert.Equal(t, 64*1024, ss.TCPInfo().SendBuffer)
+
+	ss.SetBufferAutoTune(nil)
+	assert.Nil(t, ss.bufferTune)
+}
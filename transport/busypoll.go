@@ -0,0 +1,60 @@
+/******************************************************
+# DESC       : busy polling read mode of tcp sessions
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-02 15:10
+# FILE       : busypoll.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+const defaultBusyPollSpinTime = 50 * time.Microsecond
+
+// BusyPollConfig is the busy polling read mode of the tcp sessions of an endpoint for the
+// ultra-low-latency deployments, which burn a core per busy session instead of paying the
+// wake-up latency of the netpoller and the goroutine scheduler. It takes precedence over
+// the SpinTime of (StreamSession)SetReadLoop.
+type BusyPollConfig struct {
+	// the read goroutine spins by the non-blocking reads without yielding the processor for
+	// SpinTime before it blocks on the read. Its default value is 50us.
+	SpinTime time.Duration
+	// the SO_BUSY_POLL timeout of the sockets, so the kernel busy polls the device queue for
+	// the blocking reads. 0 means SO_BUSY_POLL is not set. It is ignored on the platforms
+	// without SO_BUSY_POLL, and a value larger than net.core.busy_read needs CAP_NET_ADMIN.
+	KernelBusyPoll time.Duration
+}
+
+func (c BusyPollConfig) withDefaults() BusyPollConfig {
+	if c.SpinTime <= 0 {
+		c.SpinTime = defaultBusyPollSpinTime
+	}
+
+	return c
+}
+
+// set SO_BUSY_POLL of the tcp connection of the session if the busy polling is enabled
+func (s *session) setKernelBusyPoll(conn *gettyTCPConn) {
+	if s.busyPoll == nil || s.busyPoll.KernelBusyPoll <= 0 {
+		return
+	}
+	tcpConn, ok := conn.conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	err := setBusyPoll(tcpConn, s.busyPoll.KernelBusyPoll)
+	if err != nil && err != ErrNotSupported {
+		log.Warn("%s, [session.setKernelBusyPoll] error:%s", s.sessionToken(), jerrors.ErrorStack(err))
+	}
+}
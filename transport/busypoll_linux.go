@@ -0,0 +1,41 @@
+//go:build linux
+// +build linux
+
+/******************************************************
+# DESC       : SO_BUSY_POLL on linux
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-02 15:10
+# FILE       : busypoll_linux.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+	"golang.org/x/sys/unix"
+)
+
+// set SO_BUSY_POLL of @conn to @timeout in microseconds
+func setBusyPoll(conn *net.TCPConn, timeout time.Duration) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return jerrors.Trace(err)
+	}
+
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BUSY_POLL, int(timeout/time.Microsecond))
+	})
+	if err != nil {
+		return jerrors.Trace(err)
+	}
+
+	return jerrors.Annotate(sockErr, "setsockopt(SO_BUSY_POLL)")
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+/******************************************************
+# DESC       : SO_BUSY_POLL stub
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-02 15:10
+# FILE       : busypoll_others.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+	"time"
+)
+
+func setBusyPoll(conn *net.TCPConn, timeout time.Duration) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,47 @@
+package getty
+
+import (
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusyPollOptions(t *testing.T) {
+	var opts ClientOptions
+	WithClientBusyPoll(&BusyPollConfig{})(&opts)
+	assert.Equal(t, BusyPollConfig{SpinTime: defaultBusyPollSpinTime}, *opts.busyPoll)
+	WithClientBusyPoll(nil)(&opts)
+	assert.Nil(t, opts.busyPoll)
+
+	var serverOpts ServerOptions
+	WithServerBusyPoll(&BusyPollConfig{SpinTime: time.Millisecond})(&serverOpts)
+	assert.Equal(t, time.Millisecond, serverOpts.busyPoll.SpinTime)
+}
+
+func TestSessionBusyPoll(t *testing.T) {
+	conn, peer := newTCPPair(t)
+	listener := &lineListener{msgs: make(chan interface{}, 8)}
+
+	clt := newClient(TCP_CLIENT,
+		WithServerAddress("127.0.0.1:0"),
+		WithConnectionNumber(1),
+		WithClientBusyPoll(&BusyPollConfig{SpinTime: 5 * time.Millisecond, KernelBusyPoll: 50 * time.Microsecond}),
+	)
+	ss := newTCPSession(conn, clt).(*session)
+	assert.Equal(t, 5*time.Millisecond, ss.busyPoll.SpinTime)
+	ss.SetPkgHandler(&lineTransferCodec{})
+	ss.SetEventListener(listener)
+	ss.run()
+	defer ss.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err := peer.Write([]byte("hello\nworld\n"))
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", <-listener.msgs)
+		assert.Equal(t, "world", <-listener.msgs)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
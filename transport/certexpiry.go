@@ -0,0 +1,228 @@
+/******************************************************
+# DESC       : certificate expiry monitor of tls endpoints
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-09 10:20
+# FILE       : certexpiry.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	defaultCertCheckInterval = time.Hour
+	defaultCertWarnWithin    = 30 * 24 * time.Hour
+	day                      = 24 * time.Hour
+)
+
+// CertExpiry is the expiry of a certificate loaded by a tls endpoint.
+type CertExpiry struct {
+	Subject  string
+	Issuer   string
+	NotAfter time.Time
+	// the duration until the certificate expires, which is negative if it has expired
+	Remaining time.Duration
+}
+
+// DaysRemaining returns the whole days until the certificate expires.
+func (e CertExpiry) DaysRemaining() int {
+	return int(e.Remaining / day)
+}
+
+func (e CertExpiry) String() string {
+	return fmt.Sprintf("certificate{subject:%s, issuer:%s} expires at %s, %d days remaining",
+		e.Subject, e.Issuer, e.NotAfter.Format(time.RFC3339), e.DaysRemaining())
+}
+
+// CertExpiryConfig is the config of the certificate expiry monitor of a wss server or a wss
+// client, which checks the certificates and their chains loaded by the endpoint periodically.
+type CertExpiryConfig struct {
+	// the interval of the checks. Its default value is 1h.
+	Interval time.Duration
+	// the certificates expiring within it are reported to Handler on every check. Its default
+	// value is 30 days.
+	WarnWithin time.Duration
+	// the endpoint refuses to start by panic if a certificate expires within it. 0 means the
+	// endpoint always starts.
+	RefuseWithin time.Duration
+	// the handler of the certificates expiring within WarnWithin. They are logged if it is nil.
+	Handler func(CertExpiry)
+}
+
+func (c CertExpiryConfig) withDefaults() CertExpiryConfig {
+	if c.Interval <= 0 {
+		c.Interval = defaultCertCheckInterval
+	}
+	if c.WarnWithin <= 0 {
+		c.WarnWithin = defaultCertWarnWithin
+	}
+
+	return c
+}
+
+type certMonitor struct {
+	config  CertExpiryConfig
+	certs   []*x509.Certificate
+	metrics *EndPointMetrics
+}
+
+func newCertMonitor(config *CertExpiryConfig, certs []*x509.Certificate, metrics *EndPointMetrics) *certMonitor {
+	if config == nil || len(certs) == 0 {
+		return nil
+	}
+
+	return &certMonitor{config: config.withDefaults(), certs: certs, metrics: metrics}
+}
+
+// check the certificates at @now. It returns ErrCertExpiring if one of them expires within
+// RefuseWithin.
+func (m *certMonitor) check(now time.Time) error {
+	var (
+		err      error
+		earliest time.Time
+	)
+	for _, cert := range m.certs {
+		e := CertExpiry{
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			NotAfter:  cert.NotAfter,
+			Remaining: cert.NotAfter.Sub(now),
+		}
+		if earliest.IsZero() || e.NotAfter.Before(earliest) {
+			earliest = e.NotAfter
+		}
+		if e.Remaining < m.config.WarnWithin {
+			if m.config.Handler != nil {
+				m.config.Handler(e)
+			} else {
+				log.Warn("[getty cert expiry] %s", e)
+			}
+		}
+		if err == nil && e.Remaining < m.config.RefuseWithin {
+			err = newGettyError(ErrCertExpiring, jerrors.New(e.String()))
+		}
+	}
+	atomic.StoreInt64(&m.metrics.certNotAfter, earliest.Unix())
+
+	return err
+}
+
+func (m *certMonitor) run(done <-chan struct{}) {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			m.check(now)
+		}
+	}
+}
+
+// parse the certificate chains of @config, and the duplicate certificates are skipped
+func tlsConfigCerts(certs []*x509.Certificate, config *tls.Config) []*x509.Certificate {
+	if config == nil {
+		return certs
+	}
+
+	for _, chain := range config.Certificates {
+		for _, der := range chain.Certificate {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				log.Warn("x509.ParseCertificate() = error:%v", err)
+				continue
+			}
+			dup := false
+			for _, c := range certs {
+				if c.Equal(cert) {
+					dup = true
+					break
+				}
+			}
+			if !dup {
+				certs = append(certs, cert)
+			}
+		}
+	}
+
+	return certs
+}
+
+/////////////////////////////////////////
+// endpoint certificate expiry monitor
+/////////////////////////////////////////
+
+// start the certificate expiry monitor of the wss server, which panics if the certificate
+// expires within the refuse window. The certificates provisioned by acme are renewed by the
+// acme manager, and they are not monitored.
+func (s *server) startCertMonitor() {
+	if s.certExpiry == nil || s.endPointType != WSS_SERVER || s.acme != nil {
+		return
+	}
+
+	certificate, err := tls.LoadX509KeyPair(s.cert, s.privateKey)
+	if err != nil {
+		panic(fmt.Sprintf("tls.LoadX509KeyPair(cert{%s}, privateKey{%s}) = err{%s}",
+			s.cert, s.privateKey, jerrors.ErrorStack(err)))
+	}
+	m := newCertMonitor(s.certExpiry, tlsConfigCerts(nil, &tls.Config{Certificates: []tls.Certificate{certificate}}), s.metrics)
+	if m == nil {
+		return
+	}
+	if err = m.check(time.Now()); err != nil {
+		panic(fmt.Sprintf("server{%s} refuses to start: %v", s.addr, err))
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		m.run(s.done)
+	}()
+}
+
+// start the certificate expiry monitor of the wss client, which checks the certificates of
+// the root certificate file, the ws/wss urls and the wss fallback.
+func (c *client) startCertMonitor() {
+	if c.certExpiry == nil {
+		return
+	}
+
+	var certs []*x509.Certificate
+	if c.endPointType == WSS_CLIENT && c.cert != "" {
+		certs = tlsConfigCerts(certs, c.wssTLSConfig())
+	}
+	for _, u := range c.wsURLs {
+		certs = tlsConfigCerts(certs, u.TLSConfig)
+	}
+	if c.fallback != nil {
+		certs = tlsConfigCerts(certs, c.fallback.TLSConfig)
+	}
+	m := newCertMonitor(c.certExpiry, certs, c.metrics)
+	if m == nil {
+		return
+	}
+	if err := m.check(time.Now()); err != nil {
+		panic(fmt.Sprintf("client{%s} refuses to start: %v", c.addr, err))
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		m.run(c.done)
+	}()
+}
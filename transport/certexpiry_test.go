@@ -0,0 +1,137 @@
+package getty
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// generate a self-signed certificate expiring at @notAfter
+func newTestCert(t *testing.T, notAfter time.Time) (tls.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "getty"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+type certExpiryRecorder struct {
+	lock     sync.Mutex
+	expiries []CertExpiry
+}
+
+func (r *certExpiryRecorder) handle(e CertExpiry) {
+	r.lock.Lock()
+	r.expiries = append(r.expiries, e)
+	r.lock.Unlock()
+}
+
+func (r *certExpiryRecorder) len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.expiries)
+}
+
+func TestCertMonitorCheck(t *testing.T) {
+	now := time.Now()
+	soon, _, _ := newTestCert(t, now.Add(10*day))
+	later, _, _ := newTestCert(t, now.Add(100*day))
+	config := &tls.Config{Certificates: []tls.Certificate{soon, later, soon}}
+	certs := tlsConfigCerts(nil, config)
+	assert.Equal(t, 2, len(certs))
+	// the expiry of the certificate is truncated to seconds
+	now = certs[0].NotAfter.Add(-10 * day)
+
+	assert.Nil(t, newCertMonitor(nil, certs, newEndPointMetrics(0)))
+	assert.Nil(t, newCertMonitor(&CertExpiryConfig{}, nil, newEndPointMetrics(0)))
+
+	var recorder certExpiryRecorder
+	metrics := newEndPointMetrics(0)
+	_, ok := metrics.CertDaysRemaining()
+	assert.False(t, ok)
+	m := newCertMonitor(&CertExpiryConfig{RefuseWithin: 20 * day, Handler: recorder.handle}, certs, metrics)
+	err := m.check(now)
+	assert.True(t, errors.Is(err, ErrCertExpiring))
+	assert.Equal(t, 1, recorder.len())
+	assert.Equal(t, 10, recorder.expiries[0].DaysRemaining())
+	days, ok := metrics.CertDaysRemaining()
+	assert.True(t, ok)
+	assert.Equal(t, 9, days)
+
+	m.config.RefuseWithin = 5 * day
+	assert.Nil(t, m.check(now))
+	assert.Nil(t, m.check(now.Add(-100*day)))
+	assert.Equal(t, 2, recorder.len())
+}
+
+func TestServerCertExpiry(t *testing.T) {
+	dir := t.TempDir()
+	_, certPEM, keyPEM := newTestCert(t, time.Now().Add(10*day))
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	assert.Nil(t, os.WriteFile(certFile, certPEM, 0600))
+	assert.Nil(t, os.WriteFile(keyFile, keyPEM, 0600))
+
+	var recorder certExpiryRecorder
+	server := newServer(WSS_SERVER,
+		WithLocalAddress("127.0.0.1:0"),
+		WithWebsocketServerCert(certFile),
+		WithWebsocketServerPrivateKey(keyFile),
+		WithServerCertExpiry(&CertExpiryConfig{RefuseWithin: 20 * day, Handler: recorder.handle}),
+	)
+	defer server.Close()
+	assert.Panics(t, func() { server.RunEventLoop(nil) })
+	assert.Equal(t, 1, recorder.len())
+
+	server.certExpiry.RefuseWithin = 0
+	server.startCertMonitor()
+	assert.Equal(t, 2, recorder.len())
+	days, ok := server.Metrics().CertDaysRemaining()
+	assert.True(t, ok)
+	assert.Equal(t, 9, days)
+}
+
+func TestClientCertExpiry(t *testing.T) {
+	cert, _, _ := newTestCert(t, time.Now().Add(10*day))
+	client := newClient(WSS_CLIENT,
+		WithConnectionNumber(1),
+		WithWebsocketURLs(WebsocketURL{
+			URL:       "wss://127.0.0.1:1/getty",
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		}),
+		WithClientCertExpiry(&CertExpiryConfig{RefuseWithin: 20 * day}),
+	)
+	defer client.Close()
+	assert.Panics(t, client.startCertMonitor)
+
+	client.certExpiry.RefuseWithin = 0
+	client.startCertMonitor()
+	days, ok := client.Metrics().CertDaysRemaining()
+	assert.True(t, ok)
+	assert.Equal(t, 9, days)
+}
@@ -14,6 +14,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"strings"
@@ -39,6 +40,7 @@ const (
 
 var (
 	sessionClientKey   = "session-client-owner"
+	sessionURLKey      = "session-client-url"
 	connectPingPackage = []byte("connect-ping")
 )
 
@@ -63,6 +65,29 @@ type client struct {
 	newSession NewSessionCallback
 	ssMap      map[Session]struct{}
 
+	metrics     *EndPointMetrics
+	watchdog    *handlerWatchdog
+	panicDumper *panicDumper
+	keyLog      io.Writer // for ws/wss client
+
+	// the stream dialer of the custom transport client
+	transportDialer TransportDialer
+
+	// index of the ws/wss url to dial
+	wsURLIndex uint32
+	// the latest transport probe results of the ws/wss urls
+	probeLock    sync.Mutex
+	probeResults []TransportProbeResult
+
+	// pkgs written before a session is connected
+	pendingLock sync.Mutex
+	pending     []*pendingPkg
+	flushing    int // the number of the sessions flushing the pending pkgs
+
+	// the time of the latest (SuspendResumer)Suspend
+	suspendLock sync.Mutex
+	suspendTime time.Time
+
 	sync.Once
 	done chan struct{}
 	wg   sync.WaitGroup
@@ -82,12 +107,29 @@ func newClient(t EndPointType, opts ...ClientOption) *client {
 	}
 
 	c.init(opts...)
+	if c.addr == "" && len(c.wsURLs) > 0 {
+		c.addr = c.wsURLs[0].URL
+	}
 
 	if c.number <= 0 || c.addr == "" {
 		panic(fmt.Sprintf("client type:%s, @connNum:%d, @serverAddr:%s", t, c.number, c.addr))
 	}
+	if err := tlsSettings(c.tlsSettings).validate(); err != nil {
+		panic(fmt.Sprintf("client tls settings error:%s", jerrors.ErrorStack(err)))
+	}
+
+	if c.handshakeTimeout == 0 {
+		c.handshakeTimeout = connectTimeout
+	}
+	if c.pendingQLen == 0 {
+		c.pendingQLen = defaultQLen
+	}
 
 	c.ssMap = make(map[Session]struct{}, c.number)
+	c.metrics = newEndPointMetrics(c.latencySampleRate)
+	c.watchdog = newHandlerWatchdog(c.slowHandlerThreshold, c.metrics)
+	c.panicDumper = newPanicDumper(c.panicDumpPath, c.metrics)
+	c.keyLog = openKeyLog(c.keyLogPath)
 
 	return c
 }
@@ -127,14 +169,62 @@ func NewWSSClient(opts ...ClientOption) Client {
 	return c
 }
 
-func (c client) ID() EndPointID {
+func (c *client) ID() EndPointID {
 	return c.endPointID
 }
 
-func (c client) EndPointType() EndPointType {
+func (c *client) EndPointType() EndPointType {
 	return c.endPointType
 }
 
+func (c *client) Metrics() *EndPointMetrics {
+	return c.metrics
+}
+
+func (c *client) sessionIDGenerator() SessionIDGenerator {
+	return c.idGenerator
+}
+
+func (c *client) natKeepAliveInterval() time.Duration {
+	return c.natKeepAlive
+}
+
+func (c *client) getMemoryBudget() *MemoryBudget {
+	return c.memoryBudget
+}
+
+func (c *client) getJournal() *Journal {
+	return c.journal
+}
+
+func (c *client) getAuditLog() *AuditLog {
+	return c.auditLog
+}
+
+func (c *client) getPanicDumper() *panicDumper {
+	return c.panicDumper
+}
+
+func (c *client) getBusyPoll() *BusyPollConfig {
+	return c.busyPoll
+}
+
+func (c *client) getSessionLogConfig() SessionLogConfig {
+	return c.sessionLog
+}
+
+func (c *client) getPolicy() Policy {
+	return c.policy
+}
+
+func (c *client) handlerWatchdog() *handlerWatchdog {
+	return c.watchdog
+}
+
+func (c *client) writeStarvationThreshold() time.Duration {
+	return c.starvationThreshold
+}
+
 func (c *client) dialTCP() Session {
 	var (
 		err  error
@@ -151,10 +241,15 @@ func (c *client) dialTCP() Session {
 			err = errSelfConnect
 		}
 		if err == nil {
-			return newTCPSession(conn, c)
+			var ss Session
+			if ss, err = newNegotiatedTCPSession(conn, c, c.negotiation, true); err == nil {
+				return ss
+			}
+			conn.Close()
 		}
 
 		log.Info("net.DialTimeout(addr:%s, timeout:%v) = error{%s}", c.addr, jerrors.ErrorStack(err))
+		c.onDialError(c.addr, err)
 		// time.Sleep(connectInterval)
 		<-wheel.After(connectInterval)
 	}
@@ -182,6 +277,14 @@ func (c *client) dialUDP() Session {
 		if c.IsClosed() {
 			return nil
 		}
+		if c.rendezvousToken != "" {
+			if localAddr, peerAddr, err = c.punch(); err != nil {
+				log.Warn("client.punch(rendezvous addr:%s) = error{%s}", c.addr, jerrors.ErrorStack(err))
+				c.onDialError(c.addr, err)
+				<-wheel.After(connectInterval)
+				continue
+			}
+		}
 		conn, err = net.DialUDP("udp", localAddr, peerAddr)
 		if err == nil && gxnet.IsSameAddr(conn.RemoteAddr(), conn.LocalAddr()) {
 			conn.Close()
@@ -189,6 +292,7 @@ func (c *client) dialUDP() Session {
 		}
 		if err != nil {
 			log.Warn("net.DialTimeout(addr:%s, timeout:%v) = error{%s}", c.addr, jerrors.ErrorStack(err))
+			c.onDialError(c.addr, err)
 			// time.Sleep(connectInterval)
 			<-wheel.After(connectInterval)
 			continue
@@ -208,6 +312,11 @@ func (c *client) dialUDP() Session {
 		if netErr, ok := jerrors.Cause(err).(net.Error); ok && netErr.Timeout() {
 			err = nil
 		}
+		if err != nil && c.rendezvousToken != "" {
+			// the punching datagrams of the peer may be refused while it is switching sockets
+			log.Info("conn{%#v}.Read() = {length:%d, err:%s}", conn, length, jerrors.ErrorStack(err))
+			err = nil
+		}
 		if err != nil {
 			log.Info("conn{%#v}.Read() = {length:%d, err:%s}", conn, length, jerrors.ErrorStack(err))
 			conn.Close()
@@ -221,54 +330,91 @@ func (c *client) dialUDP() Session {
 	}
 }
 
+// report the dial error @err of @addr to the dial error handler
+func (c *client) onDialError(addr string, err error) {
+	if isTLSError(err) {
+		c.auditLog.emit(AuditRecord{Event: AuditTLSError, RemoteAddr: addr, Detail: err.Error()})
+	}
+	if c.dialErrorHandler != nil {
+		c.dialErrorHandler(addr, err)
+	}
+}
+
+// convert the timeout error of websocket dialer to ErrHandshakeTimeout
+func handshakeError(err error) error {
+	if netErr, ok := jerrors.Cause(err).(net.Error); ok && netErr.Timeout() {
+		return newGettyError(ErrHandshakeTimeout, err)
+	}
+
+	return err
+}
+
+// get the ws/wss url to dial and its tls config. @config is the default tls config.
+func (c *client) websocketURL(config *tls.Config) (string, *tls.Config) {
+	if len(c.wsURLs) == 0 {
+		return c.addr, withKeyLog(config, c.keyLog)
+	}
+
+	u := c.wsURLs[atomic.LoadUint32(&c.wsURLIndex)%uint32(len(c.wsURLs))]
+	if u.TLSConfig != nil {
+		config = c.userTLSConfig(u.TLSConfig)
+	}
+	return u.URL, withKeyLog(config, c.keyLog)
+}
+
+// switch to the next ws/wss url after failing to dial the current one
+func (c *client) nextWebsocketURL() {
+	if len(c.wsURLs) > 1 {
+		atomic.AddUint32(&c.wsURLIndex, 1)
+	}
+}
+
 func (c *client) dialWS() Session {
 	var (
 		err    error
+		addr   string
 		dialer websocket.Dialer
 		conn   *websocket.Conn
-		ss     Session
 	)
 
 	dialer.EnableCompression = true
+	dialer.HandshakeTimeout = c.handshakeTimeout
 	for {
 		if c.IsClosed() {
 			return nil
 		}
-		conn, _, err = dialer.Dial(c.addr, nil)
-		log.Info("websocket.dialer.Dial(addr:%s) = error:%s", c.addr, jerrors.ErrorStack(err))
+		addr, dialer.TLSClientConfig = c.websocketURL(nil)
+		conn, _, err = dialer.Dial(addr, nil)
+		err = handshakeError(err)
+		log.Info("websocket.dialer.Dial(addr:%s) = error:%s", addr, jerrors.ErrorStack(err))
 		if err == nil && gxnet.IsSameAddr(conn.RemoteAddr(), conn.LocalAddr()) {
 			conn.Close()
 			err = errSelfConnect
 		}
 		if err == nil {
-			ss = newWSSession(conn, c)
-			if ss.(*session).maxMsgLen > 0 {
-				conn.SetReadLimit(int64(ss.(*session).maxMsgLen))
-			}
-
+			ss := newWSSession(conn, c)
+			ss.SetAttribute(sessionURLKey, addr)
 			return ss
 		}
 
-		log.Info("websocket.dialer.Dial(addr:%s) = error:%s", c.addr, jerrors.ErrorStack(err))
+		log.Info("websocket.dialer.Dial(addr:%s) = error:%s", addr, jerrors.ErrorStack(err))
+		c.onDialError(addr, err)
+		c.nextWebsocketURL()
 		// time.Sleep(connectInterval)
 		<-wheel.After(connectInterval)
 	}
 }
 
-func (c *client) dialWSS() Session {
+// build the default tls config of wss client from the root certificate file
+func (c *client) wssTLSConfig() *tls.Config {
 	var (
 		err      error
 		root     *x509.Certificate
 		roots    []*x509.Certificate
 		certPool *x509.CertPool
 		config   *tls.Config
-		dialer   websocket.Dialer
-		conn     *websocket.Conn
-		ss       Session
 	)
 
-	dialer.EnableCompression = true
-
 	config = &tls.Config{
 		InsecureSkipVerify: true,
 	}
@@ -307,28 +453,46 @@ func (c *client) dialWSS() Session {
 	config.InsecureSkipVerify = true
 	config.RootCAs = certPool
 
+	return tlsSettings(c.tlsSettings).apply(config)
+}
+
+func (c *client) dialWSS() Session {
+	var (
+		err    error
+		addr   string
+		config *tls.Config
+		dialer websocket.Dialer
+		conn   *websocket.Conn
+		ss     Session
+	)
+
+	dialer.EnableCompression = true
+	config = c.wssTLSConfig()
+
 	// dialer.EnableCompression = true
-	dialer.TLSClientConfig = config
+	dialer.HandshakeTimeout = c.handshakeTimeout
 	for {
 		if c.IsClosed() {
 			return nil
 		}
-		conn, _, err = dialer.Dial(c.addr, nil)
+		addr, dialer.TLSClientConfig = c.websocketURL(config)
+		conn, _, err = dialer.Dial(addr, nil)
+		err = handshakeError(err)
 		if err == nil && gxnet.IsSameAddr(conn.RemoteAddr(), conn.LocalAddr()) {
 			conn.Close()
 			err = errSelfConnect
 		}
 		if err == nil {
 			ss = newWSSession(conn, c)
-			if ss.(*session).maxMsgLen > 0 {
-				conn.SetReadLimit(int64(ss.(*session).maxMsgLen))
-			}
 			ss.SetName(defaultWSSSessionName)
+			ss.SetAttribute(sessionURLKey, addr)
 
 			return ss
 		}
 
-		log.Info("websocket.dialer.Dial(addr:%s) = error{%s}", c.addr, jerrors.ErrorStack(err))
+		log.Info("websocket.dialer.Dial(addr:%s) = error{%s}", addr, jerrors.ErrorStack(err))
+		c.onDialError(addr, err)
+		c.nextWebsocketURL()
 		// time.Sleep(connectInterval)
 		<-wheel.After(connectInterval)
 	}
@@ -337,6 +501,9 @@ func (c *client) dialWSS() Session {
 func (c *client) dial() Session {
 	switch c.endPointType {
 	case TCP_CLIENT:
+		if c.fallback != nil {
+			return c.dialWithFallback()
+		}
 		return c.dialTCP()
 	case UDP_CLIENT:
 		return c.dialUDP()
@@ -344,6 +511,18 @@ func (c *client) dial() Session {
 		return c.dialWS()
 	case WSS_CLIENT:
 		return c.dialWSS()
+	case SERIAL_CLIENT:
+		opener := c.serialOpener
+		if opener == nil {
+			opener = openSerialFile
+		}
+		return c.dialDevice(opener, defaultSerialSessionName)
+	case BLUETOOTH_CLIENT:
+		return c.dialDevice(openBluetooth, defaultBluetoothSessionName)
+	case UNIX_CLIENT:
+		return c.dialUnix()
+	case TRANSPORT_CLIENT:
+		return c.dialTransport()
 	}
 
 	return nil
@@ -376,22 +555,33 @@ func (c *client) connect() {
 			// client has been closed
 			break
 		}
-		err = c.newSession(ss)
+		err = ss.(*session).admitHandshake(nil)
+		if err == nil {
+			err = c.newSession(ss)
+		}
 		if err == nil {
 			ss.(*session).run()
+			// publish @ss under @c.pendingLock, so that no pkg is written to @ss
+			// directly ahead of the pending ones
+			c.pendingLock.Lock()
 			c.Lock()
 			if c.ssMap == nil {
 				c.Unlock()
+				c.pendingLock.Unlock()
 				break
 			}
 			c.ssMap[ss] = struct{}{}
 			c.Unlock()
+			c.flushing++
+			c.pendingLock.Unlock()
 			ss.SetAttribute(sessionClientKey, c)
+			c.flushPending(ss)
 			break
 		}
 		// don't distinguish between tcp connection and websocket connection. Because
 		// gorilla/websocket/conn.go:(Conn)Close also invoke net.Conn.Close()
 		ss.Conn().Close()
+		ss.(*session).discard()
 	}
 }
 
@@ -406,6 +596,10 @@ func (c *client) RunEventLoop(newSession NewSessionCallback) {
 	c.Lock()
 	c.newSession = newSession
 	c.Unlock()
+	c.startCertMonitor()
+	c.startTransportProbe()
+	c.startSuspendDetector()
+	c.startNetworkMonitor()
 	c.reConnect()
 }
 
@@ -452,6 +646,10 @@ func (c *client) stop() {
 			c.ssMap = nil
 
 			c.Unlock()
+			c.clearPending()
+			if c.watchdog != nil {
+				c.watchdog.close()
+			}
 		})
 	}
 }
@@ -2,10 +2,13 @@ package getty
 
 import (
 	"bytes"
+	"errors"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -13,6 +16,7 @@ import (
 )
 
 import (
+	"github.com/gorilla/websocket"
 	jerrors "github.com/juju/errors"
 	"github.com/stretchr/testify/assert"
 )
@@ -220,6 +224,8 @@ func TestUDPClient(t *testing.T) {
 
 	clt.Close()
 	assert.True(t, clt.IsClosed())
+	// Reset is not goroutine safe, so wait until the session goroutines exit
+	<-ss.(*session).wDone
 	msgHandler.array[0].Reset()
 	assert.Nil(t, msgHandler.array[0].Conn())
 	//ss.WritePkg([]byte("hello"), 0)
@@ -292,6 +298,64 @@ func TestNewWSClient(t *testing.T) {
 	assert.True(t, server.IsClosed())
 }
 
+func TestWSClientRedial(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		assert.Nil(t, err)
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	client := newClient(WS_CLIENT,
+		WithConnectionNumber(1),
+		WithWebsocketURLs(
+			WebsocketURL{URL: "ws://127.0.0.1:1/hello"},
+			WebsocketURL{URL: "ws" + strings.TrimPrefix(srv.URL, "http")},
+		),
+	)
+	ss := client.dialWS()
+	assert.NotNil(t, ss)
+	ss.(*session).Connection.close(0)
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&client.wsURLIndex))
+}
+
+func TestWSClientHandshakeTimeout(t *testing.T) {
+	// the server accepts the tcp connection but never answers the websocket handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	dialErrs := make(chan error, 8)
+	addr := "ws://" + l.Addr().String() + "/hello"
+	client := newClient(WS_CLIENT,
+		WithServerAddress(addr),
+		WithConnectionNumber(1),
+		WithHandshakeTimeout(100*time.Millisecond),
+		WithDialErrorHandler(func(dialAddr string, err error) {
+			assert.Equal(t, addr, dialAddr)
+			dialErrs <- err
+		}),
+	)
+	done := make(chan Session)
+	go func() {
+		done <- client.dialWS()
+	}()
+
+	err = <-dialErrs
+	assert.True(t, errors.Is(err, ErrHandshakeTimeout), "%v", err)
+	client.Close()
+	assert.Nil(t, <-done)
+}
+
 var (
 	WssServerCRT = []byte(`-----BEGIN CERTIFICATE-----
 MIICHjCCAYegAwIBAgIQKpKqamBqmZ0hfp8sYb4uNDANBgkqhkiG9w0BAQsFADAS
@@ -0,0 +1,83 @@
+/******************************************************
+# DESC       : time source of the session active time
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-18 16:40
+# FILE       : clock.go
+******************************************************/
+
+package getty
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source of the active time of the sessions. The active time is recorded
+// by the monotonic time, and it is converted to the wall clock time by GetActive, so the
+// idle duration is not affected by the adjustments of the wall clock.
+type Clock interface {
+	// the monotonic time since an arbitrary origin, which never goes backward
+	Monotonic() time.Duration
+	// the wall clock time
+	Now() time.Time
+}
+
+type monotonicClock struct{}
+
+func (monotonicClock) Monotonic() time.Duration {
+	return time.Since(launchTime)
+}
+
+func (monotonicClock) Now() time.Time {
+	return time.Now()
+}
+
+// MonotonicClock returns the default clock, which is the monotonic clock of the go runtime.
+// It does not advance while the system is suspended on linux, i.e. the suspended time is not
+// counted in the idle duration of the sessions.
+func MonotonicClock() Clock {
+	return monotonicClock{}
+}
+
+// BootTimeClock returns the clock which advances while the system is suspended, i.e.
+// CLOCK_BOOTTIME on linux, so the sessions idle across the suspend are found by their active
+// time after the resume. It is the same as MonotonicClock on the other platforms.
+func BootTimeClock() Clock {
+	return newBootTimeClock()
+}
+
+var (
+	activityClockLock sync.RWMutex
+	activityClock     Clock = monotonicClock{}
+)
+
+// SetActivityClock sets the time source of the active time of the sessions, and nil @clock
+// resets it to MonotonicClock. A session keeps the clock when it is created, for the active
+// times of different clocks can not be compared.
+func SetActivityClock(clock Clock) {
+	if clock == nil {
+		clock = monotonicClock{}
+	}
+
+	activityClockLock.Lock()
+	activityClock = clock
+	activityClockLock.Unlock()
+}
+
+func getActivityClock() Clock {
+	activityClockLock.RLock()
+	defer activityClockLock.RUnlock()
+	return activityClock
+}
+
+// convert the monotonic time @active of @clock to the wall clock time. The result keeps the
+// monotonic reading of time.Now, so time.Since of it is the idle duration of @clock.
+func clockTime(clock Clock, active time.Duration) time.Time {
+	idle := clock.Monotonic() - active
+	if idle < 0 {
+		idle = 0
+	}
+	return clock.Now().Add(-idle)
+}
@@ -0,0 +1,48 @@
+//go:build linux
+// +build linux
+
+/******************************************************
+# DESC       : CLOCK_BOOTTIME on linux
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-18 16:40
+# FILE       : clock_linux.go
+******************************************************/
+
+package getty
+
+import (
+	"time"
+)
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// bootTimeClock counts from the launch of the process, like the default clock
+type bootTimeClock struct {
+	origin time.Duration
+}
+
+func bootTime() time.Duration {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_BOOTTIME, &ts); err != nil {
+		panic("clock_gettime(CLOCK_BOOTTIME) = error:" + err.Error())
+	}
+	return time.Duration(ts.Nano())
+}
+
+var bootTimeOrigin = bootTime() - time.Since(launchTime)
+
+func newBootTimeClock() Clock {
+	return bootTimeClock{origin: bootTimeOrigin}
+}
+
+func (c bootTimeClock) Monotonic() time.Duration {
+	return bootTime() - c.origin
+}
+
+func (bootTimeClock) Now() time.Time {
+	return time.Now()
+}
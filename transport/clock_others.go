@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+/******************************************************
+# DESC       : CLOCK_BOOTTIME stub
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-18 16:40
+# FILE       : clock_others.go
+******************************************************/
+
+package getty
+
+func newBootTimeClock() Clock {
+	return monotonicClock{}
+}
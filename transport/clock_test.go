@@ -0,0 +1,96 @@
+package getty
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is moved by the test, e.g. the wall clock is adjusted or the system is suspended
+type fakeClock struct {
+	lock      sync.Mutex
+	monotonic time.Duration
+	wall      time.Time
+}
+
+func (c *fakeClock) Monotonic() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.monotonic
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.wall
+}
+
+func (c *fakeClock) advance(monotonic, wall time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.monotonic += monotonic
+	c.wall = c.wall.Add(wall)
+}
+
+func TestActivityClock(t *testing.T) {
+	wall := time.Date(2020, 5, 18, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{monotonic: time.Hour, wall: wall}
+	SetActivityClock(clock)
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	conn := newGettyTCPConn(c1)
+	SetActivityClock(nil)
+	assert.Equal(t, MonotonicClock(), getActivityClock())
+	// the conn keeps its clock
+	assert.Equal(t, clock, conn.clock)
+
+	conn.UpdateActive()
+	assert.Equal(t, wall, conn.GetActive())
+
+	// the wall clock goes back by an adjustment
+	clock.advance(time.Minute, -time.Hour)
+	assert.Equal(t, wall.Add(-time.Hour-time.Minute), conn.GetActive())
+	assert.Equal(t, time.Minute, clock.Now().Sub(conn.GetActive()))
+
+	// the wall clock jumps forward
+	clock.advance(time.Minute, 3*time.Hour)
+	assert.Equal(t, 2*time.Minute, clock.Now().Sub(conn.GetActive()))
+
+	// the clock advances while the system is suspended
+	clock.advance(10*time.Minute, 10*time.Minute)
+	assert.Equal(t, 12*time.Minute, clock.Now().Sub(conn.GetActive()))
+
+	conn.UpdateActive()
+	assert.Equal(t, clock.Now(), conn.GetActive())
+}
+
+func TestClockTime(t *testing.T) {
+	// the active time is in the future of a clock whose monotonic time goes backward
+	clock := &fakeClock{monotonic: time.Second, wall: time.Now()}
+	assert.Equal(t, clock.wall, clockTime(clock, 2*time.Second))
+
+	// time.Since of the active time is the idle duration of the clock
+	for _, c := range []Clock{MonotonicClock(), BootTimeClock()} {
+		active := c.Monotonic()
+		idle := time.Since(clockTime(c, active-time.Hour))
+		assert.True(t, time.Hour <= idle && idle < time.Hour+time.Second, idle)
+		assert.True(t, c.Monotonic() <= time.Since(launchTime)+time.Second)
+	}
+}
+
+func TestSessionActiveTime(t *testing.T) {
+	src, dst := newPipeSessions(t)
+	defer src.Close()
+	defer dst.Close()
+
+	// the pipe sessions have not been active
+	assert.True(t, time.Since(src.GetActive()) >= time.Since(launchTime)-time.Second)
+	src.UpdateActive()
+	assert.True(t, time.Since(src.GetActive()) < time.Second)
+}
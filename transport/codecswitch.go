@@ -0,0 +1,175 @@
+/******************************************************
+# DESC       : codec & compression hot swap of session
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-04 15:20
+# FILE       : codecswitch.go
+******************************************************/
+
+package getty
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+var errCompressSwitch = jerrors.New("the stream compression can only be switched from CompressNone")
+
+// CodecSwitch is the new codec of a session after a boundary frame, e.g. the upgrade
+// command of an in-place protocol upgrade. The read side and the write side of a session
+// switch separately, so both peers should switch at the boundary frame of each direction.
+type CodecSwitch struct {
+	// the new package handler. nil keeps the current one.
+	Handler ReadWriter
+	// whether to switch the stream compression of the session to Compress. The compression
+	// of a tcp session can only be switched once from CompressNone, because a compressed
+	// stream can not be cut at a frame boundary. It is supported by tcp & websocket sessions.
+	SetCompress bool
+	Compress    CompressType
+}
+
+// the boundary pkg queued by (HandlerSwitcher)WriteCodecSwitch
+type codecSwitchPkg struct {
+	pkg   interface{}
+	codec CodecSwitch
+}
+
+// SwitchReadCodec switches the codec of the read side of the session. Invoke it in (Reader)Read
+// when the boundary frame is decoded, then the following stream, even that has been read into
+// the read buffer, is decompressed and decoded by the new codec.
+func (s *session) SwitchReadCodec(sw CodecSwitch) error {
+	if sw.SetCompress {
+		switch conn := s.Connection.(type) {
+		case *gettyTCPConn:
+			if conn.readLayered() {
+				return errCompressSwitch
+			}
+			c := sw.Compress
+			s.readCompress = &c
+		case *gettyWSConn:
+			// the following messages are read one by one
+			conn.switchReadCompress(sw.Compress)
+		}
+	}
+	if sw.Handler != nil {
+		s.lock.Lock()
+		s.reader = sw.Handler
+		s.lock.Unlock()
+	}
+
+	return nil
+}
+
+// WriteCodecSwitch queues the boundary @pkg, which is encoded by the current codec of the
+// write side, and the pkgs written after it are compressed and encoded by the new codec.
+// Pls attention that if @timeout is not greater than 0, @pkg is sent at once like WritePkg
+// and it may overtake the queued pkgs.
+func (s *session) WriteCodecSwitch(pkg interface{}, sw CodecSwitch, timeout time.Duration) error {
+	if pkg == nil {
+		return jerrors.New("@pkg is nil")
+	}
+	if sw.SetCompress {
+		if conn, ok := s.Connection.(*gettyTCPConn); ok && conn.writeLayered() {
+			return errCompressSwitch
+		}
+	}
+	if err := s.admitPolicyWrite(pkg); err != nil {
+		return err
+	}
+
+	return s.WritePkg(codecSwitchPkg{pkg: pkg, codec: sw}, timeout)
+}
+
+// encode the boundary pkg by the current writer, and switch to the new writer. The new
+// compression is applied after the boundary pkg is sent.
+func (s *session) encodeCodecSwitch(p codecSwitchPkg) ([]byte, error) {
+	pkgBytes, err := s.encode(p.pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	if p.codec.Handler != nil {
+		s.writer = p.codec.Handler
+	}
+	if p.codec.SetCompress {
+		c := p.codec.Compress
+		s.writeCompress = &c
+	}
+	s.lock.Unlock()
+	return pkgBytes, nil
+}
+
+// check whether a write compression switch is waiting for its boundary pkg being sent
+func (s *session) writeCompressPending() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.writeCompress != nil
+}
+
+// apply the write compression switch after the boundary pkg has been sent
+func (s *session) applyWriteCompress() {
+	s.lock.Lock()
+	c := s.writeCompress
+	s.writeCompress = nil
+	s.lock.Unlock()
+	if c == nil {
+		return
+	}
+
+	switch conn := s.Connection.(type) {
+	case *gettyTCPConn:
+		conn.switchWriteCompress(*c)
+	case *gettyWSConn:
+		conn.switchWriteCompress(*c)
+	}
+}
+
+// apply the read compression switch after the boundary frame has been decoded. @buffered is
+// the stream after the boundary frame in the read buffer.
+func (s *session) applyReadCompress(conn *gettyTCPConn, buffered []byte) {
+	c := s.readCompress
+	if c == nil {
+		return
+	}
+
+	s.readCompress = nil
+	conn.switchReadCompress(*c, buffered)
+}
+
+// switch the read compression to @c, which is put over the stream wrapper chain.
+func (t *gettyTCPConn) switchReadCompress(c CompressType, buffered []byte) {
+	if c == CompressNone && len(buffered) == 0 {
+		t.setReadLayer(nil, &c)
+		return
+	}
+
+	wrapper := CompressWrapper(c)
+	t.setReadLayer(func(base io.Reader) io.Reader {
+		source := base
+		if len(buffered) != 0 {
+			source = io.MultiReader(bytes.NewReader(append([]byte(nil), buffered...)), base)
+		}
+		if c == CompressNone {
+			return source
+		}
+		return wrapper.WrapReader(source)
+	}, &c)
+}
+
+// switch the write compression to @c, which is put over the stream wrapper chain.
+func (t *gettyTCPConn) switchWriteCompress(c CompressType) {
+	if c == CompressNone {
+		t.setWriteLayer(nil, &c)
+		return
+	}
+
+	t.setWriteLayer(CompressWrapper(c).WrapWriter, &c)
+}
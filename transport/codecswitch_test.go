@@ -0,0 +1,77 @@
+package getty
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// upgradeCodec switches the read side to the flate compression after the "upgrade" line
+type upgradeCodec struct {
+	lineTransferCodec
+}
+
+func (c *upgradeCodec) Read(ss Session, data []byte) (interface{}, int, error) {
+	pkg, n, err := c.lineTransferCodec.Read(ss, data)
+	if pkg == "upgrade" {
+		err = ss.(HandlerSwitcher).SwitchReadCodec(CodecSwitch{
+			Handler:     &lineTransferCodec{},
+			SetCompress: true,
+			Compress:    CompressBestSpeed,
+		})
+	}
+
+	return pkg, n, err
+}
+
+func TestSessionCodecSwitch(t *testing.T) {
+	conn, peer := newTCPPair(t)
+	listener := &lineListener{msgs: make(chan interface{}, 8)}
+
+	clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+	ss := newTCPSession(conn, clt).(*session)
+	ss.SetPkgHandler(&upgradeCodec{})
+	ss.SetEventListener(listener)
+	ss.SetWQLen(4)
+	ss.run()
+	defer ss.Close()
+
+	// the compressed stream follows the boundary frame in the same read buffer
+	var compressed bytes.Buffer
+	w, err := flate.NewWriter(&compressed, flate.BestSpeed)
+	assert.Nil(t, err)
+	w.Write([]byte("hello\nworld\n"))
+	w.Flush()
+	_, err = peer.Write(append([]byte("upgrade\n"), compressed.Bytes()...))
+	assert.Nil(t, err)
+	assert.Equal(t, "upgrade", <-listener.msgs)
+	assert.Equal(t, "hello", <-listener.msgs)
+	assert.Equal(t, "world", <-listener.msgs)
+	_, ok := ss.getReader().(*lineTransferCodec)
+	assert.True(t, ok)
+
+	// the pkgs after the boundary pkg are compressed
+	assert.Nil(t, ss.WriteCodecSwitch("ok", CodecSwitch{SetCompress: true, Compress: CompressBestSpeed}, time.Second))
+	assert.Nil(t, ss.WritePkg("compressed", time.Second))
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(peer)
+	line, err := reader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "ok\n", line)
+	line, err = bufio.NewReader(flate.NewReader(reader)).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "compressed\n", line)
+
+	// a compressed stream can not be switched
+	assert.Equal(t, errCompressSwitch, ss.SwitchReadCodec(CodecSwitch{SetCompress: true}))
+	assert.Equal(t, errCompressSwitch,
+		ss.WriteCodecSwitch("again", CodecSwitch{SetCompress: true, Compress: CompressSnappy}, time.Second))
+	assert.True(t, strings.HasPrefix(errCompressSwitch.Error(), "the stream compression"))
+}
@@ -0,0 +1,198 @@
+package getty
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// The stress tests invoke the concurrency safe methods of the running sessions from many
+// goroutines. They are meaningful with the race detector, i.e. go test -race ./transport
+
+const (
+	stressGoroutines = 8
+	stressRounds     = 200
+)
+
+// run @f(i) by stressGoroutines goroutines for stressRounds rounds each
+func stress(f func(i int)) {
+	var wg sync.WaitGroup
+	for g := 0; g < stressGoroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < stressRounds; i++ {
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRefreshDeadline(t *testing.T) {
+	var (
+		last  int64
+		calls int32
+	)
+	setDeadline := func(time.Time) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}
+
+	_, err := refreshDeadline(&last, 0, setDeadline)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), calls)
+
+	// only one of the concurrent refreshers sets the deadline
+	stress(func(int) {
+		refreshDeadline(&last, time.Minute, setDeadline)
+	})
+	assert.Equal(t, int32(1), calls)
+
+	// the last deadline is restored if it fails to be set
+	last = 0
+	_, err = refreshDeadline(&last, time.Minute, func(time.Time) error { return errors.New("closed") })
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(0), last)
+}
+
+func TestSessionConcurrentAccess(t *testing.T) {
+	conn, peer := newTCPPair(t)
+	listener := &lineListener{msgs: make(chan interface{}, stressGoroutines*stressRounds)}
+	clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+	ss := newTCPSession(conn, clt).(*session)
+	ss.SetPkgHandler(&lineTransferCodec{})
+	ss.SetEventListener(listener)
+	ss.SetWQLen(stressGoroutines * stressRounds)
+	ss.run()
+	defer ss.Close()
+
+	peerSS := newTCPSession(peer, clt).(*session)
+	peerSS.SetPkgHandler(&lineTransferCodec{})
+	peerSS.SetEventListener(&MessageHandler{})
+	peerSS.SetWQLen(stressGoroutines * stressRounds)
+	peerSS.run()
+	defer peerSS.Close()
+
+	stress(func(i int) {
+		timeout := time.Duration(0)
+		if i%2 == 0 {
+			timeout = time.Second
+		}
+		assert.Nil(t, peerSS.WritePkg("hello", timeout))
+		peerSS.SetReadTimeout(time.Second + time.Duration(i)*time.Millisecond)
+		peerSS.SetWriteTimeout(time.Second + time.Duration(i)*time.Millisecond)
+		peerSS.SetAttribute(i%4, i)
+		peerSS.GetAttribute(i % 4)
+		peerSS.Stat()
+		peerSS.GetActive()
+		peerSS.Rates()
+		peerSS.IsCongested()
+
+		ss.SetReadTimeout(time.Second)
+		ss.SetEventListener(listener)
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.Stat()
+	})
+	for i := 0; i < stressGoroutines*stressRounds; i++ {
+		select {
+		case pkg := <-listener.msgs:
+			assert.Equal(t, "hello", pkg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d of %d pkgs", i, stressGoroutines*stressRounds)
+		}
+	}
+
+	// close the session concurrently
+	stress(func(int) {
+		peerSS.WritePkg("hello", 0)
+		peerSS.Close()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, peerSS.CloseContext(ctx))
+	assert.Nil(t, ss.CloseContext(ctx))
+	assert.NotNil(t, peerSS.Conn())
+}
+
+func TestSessionConcurrentSetCompressType(t *testing.T) {
+	conn, peer := newTCPPair(t)
+	go io.Copy(ioutil.Discard, peer)
+	clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+	ss := newTCPSession(conn, clt).(*session)
+	ss.SetPkgHandler(&lineTransferCodec{})
+	ss.SetEventListener(&MessageHandler{})
+	ss.SetWQLen(stressGoroutines)
+	ss.run()
+	defer ss.Close()
+
+	compressions := []CompressType{CompressNone, CompressBestSpeed, CompressSnappy}
+	stress(func(i int) {
+		// the session is closed if the stream is corrupted by the switches
+		peer.Write([]byte("hello\n"))
+		ss.SetCompressType(compressions[i%len(compressions)])
+		ss.WritePkg("hello", 0)
+		ss.WriteBytes([]byte("hello\n"))
+		ss.SetReadTimeout(time.Second)
+	})
+}
+
+func TestWSSessionConcurrentWrite(t *testing.T) {
+	listener := &lineListener{msgs: make(chan interface{}, stressGoroutines*stressRounds)}
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		for {
+			_, p, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			listener.msgs <- string(p)
+		}
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.Nil(t, err)
+	clt := newClient(WS_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+	ss := newWSSession(conn, clt).(*session)
+	ss.SetPkgHandler(&lineTransferCodec{})
+	ss.SetEventListener(&MessageHandler{})
+	ss.SetCronPeriod(1)
+	ss.SetWQLen(stressGoroutines * stressRounds)
+	ss.run()
+	defer ss.Close()
+
+	// the writes, the pings of the cron and the compression switches of the websocket session
+	// are serialized
+	stress(func(i int) {
+		ss.WritePkg("hello", time.Duration(i%2)*time.Second)
+		if i%16 == 0 {
+			ss.SetCompressType(CompressBestSpeed)
+		}
+	})
+	for i := 0; i < stressGoroutines*stressRounds; i++ {
+		select {
+		case pkg := <-listener.msgs:
+			assert.Equal(t, "hello\n", pkg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d of %d pkgs", i, stressGoroutines*stressRounds)
+		}
+	}
+}
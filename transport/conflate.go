@@ -0,0 +1,78 @@
+/******************************************************
+# DESC       : conflation of the outbound state frames
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-05 10:20
+# FILE       : conflate.go
+******************************************************/
+
+package getty
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// the queued pkg of a conflation key. Its pkg is replaced by the newer pkgs of the key
+// until it is taken out by the write goroutine.
+type conflatedPkg struct {
+	key interface{}
+	// the size accounted by the memory budget when it is queued
+	size int
+	pkg  interface{}
+}
+
+func (p *conflatedPkg) Size() int {
+	return p.size
+}
+
+// WriteConflated queues @pkg with the conflation @key, which should be comparable. If a pkg
+// of @key is still in the write queue, it is replaced by @pkg in place, i.e. the last value
+// wins and the queue position of @key is kept. It suits the market data or state sync whose
+// slow receivers only care about the latest value. @timeout is the same as that of WritePkg.
+func (s *session) WriteConflated(key interface{}, pkg interface{}, timeout time.Duration) error {
+	if pkg == nil {
+		return fmt.Errorf("@pkg is nil")
+	}
+	if s.IsClosed() {
+		return ErrSessionClosed
+	}
+	// the policy sees the pkg of the user, which may replace the queued one
+	if err := s.admitPolicyWrite(pkg); err != nil {
+		return err
+	}
+
+	s.conflateLock.Lock()
+	if p, ok := s.conflated[key]; ok {
+		p.pkg = pkg
+		s.conflateLock.Unlock()
+		atomic.AddUint64(&s.metrics.conflatedPkgNum, 1)
+		return nil
+	}
+	p := &conflatedPkg{key: key, size: int(queuedPkgSize(pkg)), pkg: pkg}
+	if s.conflated == nil {
+		s.conflated = make(map[interface{}]*conflatedPkg)
+	}
+	s.conflated[key] = p
+	s.conflateLock.Unlock()
+
+	err := s.WritePkg(p, timeout)
+	if err != nil {
+		s.takeConflated(p)
+	}
+	return err
+}
+
+// take the latest pkg of the key out before it is encoded, so the newer pkgs of the key
+// will be queued again.
+func (s *session) takeConflated(p *conflatedPkg) interface{} {
+	s.conflateLock.Lock()
+	defer s.conflateLock.Unlock()
+
+	if s.conflated[p.key] == p {
+		delete(s.conflated, p.key)
+	}
+	return p.pkg
+}
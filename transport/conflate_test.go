@@ -0,0 +1,54 @@
+package getty
+
+import (
+	"bufio"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionWriteConflated(t *testing.T) {
+	ss, _ := newPipeSessions(t)
+	ss.SetWQLen(8)
+
+	assert.Nil(t, ss.WriteConflated("a", "a1", time.Second))
+	assert.Nil(t, ss.WriteConflated("b", "b1", time.Second))
+	assert.Nil(t, ss.WriteConflated("a", "a2", time.Second))
+	assert.Nil(t, ss.WriteConflated("a", "a3", time.Second))
+	assert.Equal(t, 2, len(ss.wQ))
+	assert.Equal(t, uint64(2), ss.metrics.ConflatedPkgNum())
+
+	// the queue position of "a" is kept, and its latest value is sent
+	p := unwrapQueuedPkg(<-ss.wQ).pkg.(*conflatedPkg)
+	assert.Equal(t, "a3", ss.takeConflated(p))
+	// "a" is queued again after it is taken out
+	assert.Nil(t, ss.WriteConflated("a", "a4", time.Second))
+	assert.Equal(t, 2, len(ss.wQ))
+	p = unwrapQueuedPkg(<-ss.wQ).pkg.(*conflatedPkg)
+	assert.Equal(t, "b1", ss.takeConflated(p))
+	p = unwrapQueuedPkg(<-ss.wQ).pkg.(*conflatedPkg)
+	assert.Equal(t, "a4", ss.takeConflated(p))
+	assert.Equal(t, 0, len(ss.conflated))
+}
+
+func TestSessionWriteConflatedSend(t *testing.T) {
+	conn, peer := newTCPPair(t)
+
+	clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+	ss := newTCPSession(conn, clt).(*session)
+	ss.SetPkgHandler(&lineTransferCodec{})
+	ss.SetEventListener(&lineListener{msgs: make(chan interface{}, 4)})
+	ss.SetWQLen(4)
+	ss.run()
+	defer ss.Close()
+
+	assert.Nil(t, ss.WriteConflated("price", "100", time.Second))
+	assert.Nil(t, ss.WriteConflated("price", "101", 0))
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := bufio.NewReader(peer).ReadString('\n')
+	assert.Nil(t, err)
+	assert.True(t, line == "101\n" || line == "100\n", line)
+}
@@ -0,0 +1,102 @@
+/******************************************************
+# DESC       : congestion signal of the session write path
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-24 15:20
+# FILE       : congestion.go
+******************************************************/
+
+package getty
+
+import (
+	"sync/atomic"
+)
+
+const (
+	defaultCongestionHighWater = 0.8
+	defaultCongestionLowWater  = 0.5
+)
+
+// SetCongestionWatermark sets the occupancy watermarks of the congestion signal, which
+// are fractions in (0, 1]. The session turns congested when the occupancy of its write
+// queue or of its kernel send buffer reaches @high, and turns writable again when both
+// of them drop to @low. The defaults are 0.8 and 0.5.
+func (s *session) SetCongestionWatermark(high, low float64) {
+	if high <= 0 || high > 1 || low < 0 || low > high {
+		panic("illegal congestion watermark")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.congestionHigh = high
+	s.congestionLow = low
+}
+
+func (s *session) congestionWatermark() (float64, float64) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.congestionHigh == 0 {
+		return defaultCongestionHighWater, defaultCongestionLowWater
+	}
+	return s.congestionHigh, s.congestionLow
+}
+
+// get the max occupancy of the write queue and the kernel send buffer of a tcp connection
+func (s *session) congestionLevel() float64 {
+	var level float64
+	// session.gc resets @s.wQ under the lock
+	s.lock.RLock()
+	wQ := s.wQ
+	s.lock.RUnlock()
+	if cap(wQ) > 0 {
+		level = float64(len(wQ)) / float64(cap(wQ))
+	}
+	if tcpConn := underlyingTCPConn(s.Conn()); tcpConn != nil {
+		if queued, size := getSendQueue(tcpConn); size > 0 {
+			if l := float64(queued) / float64(size); l > level {
+				level = l
+			}
+		}
+	}
+
+	return level
+}
+
+// IsCongested returns true if the write queue or the kernel send buffer of the session
+// has reached the high watermark, and it keeps true until both of them drop to the low
+// watermark. After it returned true, (EventListenerV2)OnWritable is invoked once the
+// session turns writable, so the application can adapt its sending rate instead of
+// queueing blindly.
+func (s *session) IsCongested() bool {
+	high, low := s.congestionWatermark()
+	level := s.congestionLevel()
+	if atomic.LoadUint32(&s.congested) == 1 {
+		return level > low
+	}
+	if level >= high {
+		atomic.StoreUint32(&s.congested, 1)
+		return true
+	}
+
+	return false
+}
+
+// Writable returns !IsCongested().
+func (s *session) Writable() bool {
+	return !s.IsCongested()
+}
+
+// clear the congested flag if the session has dropped to the low watermark. it returns
+// true if the flag is cleared.
+func (s *session) clearCongested() bool {
+	if atomic.LoadUint32(&s.congested) == 0 {
+		return false
+	}
+	_, low := s.congestionWatermark()
+	if s.congestionLevel() > low {
+		return false
+	}
+
+	return atomic.CompareAndSwapUint32(&s.congested, 1, 0)
+}
@@ -0,0 +1,68 @@
+package getty
+
+import (
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionCongestion(t *testing.T) {
+	var handler batchHandler
+
+	ss, _ := newPipeSessions(t)
+	ss.SetEventListenerV2(&handler)
+	ss.SetWQLen(10)
+	assert.False(t, ss.IsCongested())
+	assert.True(t, ss.Writable())
+
+	for i := 0; i < 8; i++ {
+		ss.wQ <- i
+	}
+	assert.True(t, ss.IsCongested())
+	assert.False(t, ss.Writable())
+
+	// still congested above the low watermark
+	<-ss.wQ
+	<-ss.wQ
+	assert.True(t, ss.IsCongested())
+	ss.notifyWritable()
+	assert.Equal(t, 0, handler.writable)
+
+	for len(ss.wQ) > 5 {
+		<-ss.wQ
+	}
+	ss.notifyWritable()
+	ss.notifyWritable()
+	assert.Equal(t, 1, handler.writable)
+	assert.False(t, ss.IsCongested())
+
+	ss.SetCongestionWatermark(0.5, 0.1)
+	assert.True(t, ss.IsCongested())
+	assert.Panics(t, func() { ss.SetCongestionWatermark(0.5, 0.6) })
+}
+
+func TestSessionCongestionClose(t *testing.T) {
+	ss, peer := newPipeSessions(t)
+	ss.SetPkgHandler(&lineTransferCodec{})
+	ss.SetEventListener(&MessageHandler{})
+	ss.SetWQLen(10)
+	ss.run()
+
+	// the write queue is reset by session.gc while it is read
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for deadline := time.Now().Add(100 * time.Millisecond); time.Now().Before(deadline); {
+			ss.IsCongested()
+			ss.congestionLevel()
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ss.Close()
+	peer.Conn().Close()
+	<-done
+	assert.False(t, ss.IsCongested())
+}
@@ -10,7 +10,6 @@
 package getty
 
 import (
-	"compress/flate"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -18,11 +17,11 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 import (
 	log "github.com/AlexStocks/log4go"
-	"github.com/golang/snappy"
 	"github.com/gorilla/websocket"
 	jerrors "github.com/juju/errors"
 )
@@ -41,22 +40,27 @@ var (
 	connID uint32
 )
 
+// The 64-bit fields accessed atomically are placed first to keep them 64-bit aligned on 32-bit platforms.
+// The timeouts can be set at runtime, and the deadlines are refreshed by the concurrent writers,
+// so all of them are accessed atomically.
 type gettyConn struct {
+	active        int64         // last active, the monotonic time of clock
+	rTimeout      time.Duration // network current limiting
+	wTimeout      time.Duration
+	rLastDeadline int64 // lastest network read time, in unix nanoseconds
+	wLastDeadline int64 // lastest network write time, in unix nanoseconds
 	id            uint32
 	compress      CompressType
 	padding1      uint8
 	padding2      uint16
-	readBytes     uint32        // read bytes
-	writeBytes    uint32        // write bytes
-	readPkgNum    uint32        // send pkg number
-	writePkgNum   uint32        // recv pkg number
-	active        int64         // last active, in milliseconds
-	rTimeout      time.Duration // network current limiting
-	wTimeout      time.Duration
-	rLastDeadline time.Time // lastest network read time
-	wLastDeadline time.Time // lastest network write time
-	local         string    // local address
-	peer          string    // peer address
+	readBytes     uint32 // read bytes
+	writeBytes    uint32 // write bytes
+	readPkgNum    uint32 // send pkg number
+	writePkgNum   uint32 // recv pkg number
+	closed        int32  // the connection is closed if it is 1
+	local         string // local address
+	peer          string // peer address
+	clock         Clock  // the time source of the active time
 	ss            Session
 }
 
@@ -81,11 +85,13 @@ func (c *gettyConn) incWritePkgNum() {
 }
 
 func (c *gettyConn) UpdateActive() {
-	atomic.StoreInt64(&(c.active), int64(time.Since(launchTime)))
+	atomic.StoreInt64(&(c.active), int64(c.clock.Monotonic()))
 }
 
+// GetActive returns the last active time in the current wall clock, and time.Since of it is
+// the idle duration by the monotonic clock, see SetActivityClock.
 func (c *gettyConn) GetActive() time.Time {
-	return launchTime.Add(time.Duration(atomic.LoadInt64(&(c.active))))
+	return clockTime(c.clock, time.Duration(atomic.LoadInt64(&(c.active))))
 }
 
 func (c *gettyConn) send(interface{}) (int, error) {
@@ -94,8 +100,18 @@ func (c *gettyConn) send(interface{}) (int, error) {
 
 func (c *gettyConn) close(int) {}
 
-func (c gettyConn) readTimeout() time.Duration {
-	return c.rTimeout
+// mark the connection closed, and return false if it has been closed. The net connection
+// is not reset when it is closed, for it can be got by (Session)Conn concurrently.
+func (c *gettyConn) markClosed() bool {
+	return atomic.CompareAndSwapInt32(&c.closed, 0, 1)
+}
+
+func (c *gettyConn) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+func (c *gettyConn) readTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&c.rTimeout)))
 }
 
 func (c *gettyConn) setSession(ss Session) {
@@ -111,14 +127,12 @@ func (c *gettyConn) SetReadTimeout(rTimeout time.Duration) {
 		panic("@rTimeout < 1")
 	}
 
-	c.rTimeout = rTimeout
-	if c.wTimeout == 0 {
-		c.wTimeout = rTimeout
-	}
+	atomic.StoreInt64((*int64)(&c.rTimeout), int64(rTimeout))
+	atomic.CompareAndSwapInt64((*int64)(&c.wTimeout), 0, int64(rTimeout))
 }
 
-func (c gettyConn) writeTimeout() time.Duration {
-	return c.wTimeout
+func (c *gettyConn) writeTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&c.wTimeout)))
 }
 
 // Pls do not set write deadline for websocket connection. AlexStocks 20180310
@@ -130,10 +144,45 @@ func (c *gettyConn) SetWriteTimeout(wTimeout time.Duration) {
 		panic("@wTimeout < 1")
 	}
 
-	c.wTimeout = wTimeout
-	if c.rTimeout == 0 {
-		c.rTimeout = wTimeout
+	atomic.StoreInt64((*int64)(&c.wTimeout), int64(wTimeout))
+	atomic.CompareAndSwapInt64((*int64)(&c.rTimeout), 0, int64(wTimeout))
+}
+
+// refresh the read deadline by @setDeadline if the read timeout is set, and return the current
+// time if the timeout is set.
+func (c *gettyConn) refreshReadDeadline(setDeadline func(time.Time) error) (time.Time, error) {
+	return refreshDeadline(&c.rLastDeadline, c.readTimeout(), setDeadline)
+}
+
+// refresh the write deadline by @setDeadline if the write timeout is set, and return the current
+// time if the timeout is set.
+func (c *gettyConn) refreshWriteDeadline(setDeadline func(time.Time) error) (time.Time, error) {
+	return refreshDeadline(&c.wLastDeadline, c.writeTimeout(), setDeadline)
+}
+
+func refreshDeadline(last *int64, timeout time.Duration, setDeadline func(time.Time) error) (time.Time, error) {
+	if timeout <= 0 {
+		return time.Time{}, nil
+	}
+
+	// Optimization: update deadline only if more than 25%
+	// of the last deadline exceeded.
+	// See https://github.com/golang/go/issues/15133 for details.
+	currentTime := time.Now()
+	lastDeadline := atomic.LoadInt64(last)
+	if time.Duration(currentTime.UnixNano()-lastDeadline) <= (timeout >> 2) {
+		return currentTime, nil
+	}
+	// only one of the concurrent writers refreshes the deadline
+	if !atomic.CompareAndSwapInt64(last, lastDeadline, currentTime.UnixNano()) {
+		return currentTime, nil
 	}
+	if err := setDeadline(currentTime.Add(timeout)); err != nil {
+		atomic.CompareAndSwapInt64(last, currentTime.UnixNano(), lastDeadline)
+		return currentTime, jerrors.Trace(err)
+	}
+
+	return currentTime, nil
 }
 
 /////////////////////////////////////////
@@ -142,9 +191,21 @@ func (c *gettyConn) SetWriteTimeout(wTimeout time.Duration) {
 
 type gettyTCPConn struct {
 	gettyConn
+	// @streamLock guards the streams and @compress, which can be swapped by SetCompressType
+	// while the read/write loops are running. @writeLock serializes the writes of the wrapped
+	// writers, which are not goroutine safe unlike the raw connection.
+	streamLock sync.RWMutex
+	writeLock  sync.Mutex
+	// the stream wrapper chain over @conn, see (StreamSession)SetStreamWrappers
+	wrapped    bool
+	baseReader io.Reader
+	baseWriter io.Writer
+	// the compression layer over the wrapper chain. nil means the stream is not compressed.
 	reader io.Reader
 	writer io.Writer
 	conn   net.Conn
+	// per message compression, see msgcompress.go
+	msgCompress *msgCompressor
 }
 
 // create gettyTCPConn
@@ -155,16 +216,16 @@ func newGettyTCPConn(conn net.Conn) *gettyTCPConn {
 	var localAddr, peerAddr string
 	//  check conn.LocalAddr or conn.RemoetAddr is nil to defeat panic on 2016/09/27
 	if conn.LocalAddr() != nil {
-		localAddr = conn.LocalAddr().String()
+		localAddr = formatAddr(conn.LocalAddr())
 	}
 	if conn.RemoteAddr() != nil {
-		peerAddr = conn.RemoteAddr().String()
+		peerAddr = formatAddr(conn.RemoteAddr())
 	}
 
 	return &gettyTCPConn{
-		conn:   conn,
-		reader: io.Reader(conn),
-		writer: io.Writer(conn),
+		conn:       conn,
+		baseReader: io.Reader(conn),
+		baseWriter: io.Writer(conn),
 		gettyConn: gettyConn{
 			id:       atomic.AddUint32(&connID, 1),
 			rTimeout: netIOTimeout,
@@ -172,84 +233,133 @@ func newGettyTCPConn(conn net.Conn) *gettyTCPConn {
 			local:    localAddr,
 			peer:     peerAddr,
 			compress: CompressNone,
+			clock:    getActivityClock(),
 		},
 	}
 }
 
-// for zip compress
-type writeFlusher struct {
-	flusher *flate.Writer
-	lock    sync.Mutex
+// set compress type(tcp: zip/snappy/snappy block, websocket:zip). The compression layer is put over the
+// stream wrapper chain. The flate states of the layer are initialized lazily, and the pooled
+// state of the replaced writer is released. The replaced reader is not, for the read goroutine
+// may be reading it, and it puts its state back when the stream ends.
+func (t *gettyTCPConn) SetCompressType(c CompressType) {
+	wrapper := CompressWrapper(c)
+
+	t.streamLock.Lock()
+	writer := t.writer
+	t.reader, t.writer, t.compress = wrapper.WrapReader(t.baseReader), wrapper.WrapWriter(t.baseWriter), c
+	t.streamLock.Unlock()
+	releaseStream(writer)
 }
 
-func (t *writeFlusher) Write(p []byte) (int, error) {
-	var (
-		n   int
-		err error
-	)
-	t.lock.Lock()
-	defer t.lock.Unlock()
-	n, err = t.flusher.Write(p)
-	if err != nil {
-		return n, jerrors.Trace(err)
+// set the stream wrapper chain over the net connection, and remove the compression layer.
+func (t *gettyTCPConn) setWrappers(wrappers []StreamWrapper) {
+	reader, writer := io.Reader(t.conn), io.Writer(t.conn)
+	for _, wrapper := range wrappers {
+		reader, writer = wrapper.WrapReader(reader), wrapper.WrapWriter(writer)
 	}
-	if err := t.flusher.Flush(); err != nil {
-		return 0, jerrors.Trace(err)
+
+	t.streamLock.Lock()
+	layer := t.writer
+	t.wrapped = len(wrappers) != 0
+	t.baseReader, t.baseWriter = reader, writer
+	t.reader, t.writer, t.compress = nil, nil, CompressNone
+	t.streamLock.Unlock()
+	releaseStream(layer)
+}
+
+// get the reader, and whether the read deadline can be set, i.e. the stream is neither
+// compressed nor wrapped. The reader is owned by the read loop.
+func (t *gettyTCPConn) readStream() (io.Reader, bool) {
+	t.streamLock.RLock()
+	defer t.streamLock.RUnlock()
+
+	deadline := t.compress == CompressNone && !t.wrapped
+	if t.reader != nil {
+		return t.reader, deadline
 	}
+	return t.baseReader, deadline
+}
+
+// get the writer, and whether the write deadline can be set.
+func (t *gettyTCPConn) writeStream() (io.Writer, bool) {
+	t.streamLock.RLock()
+	defer t.streamLock.RUnlock()
 
-	return n, nil
+	deadline := t.compress == CompressNone && !t.wrapped
+	if t.writer != nil {
+		return t.writer, deadline
+	}
+	return t.baseWriter, deadline
 }
 
-// set compress type(tcp: zip/snappy, websocket:zip)
-func (t *gettyTCPConn) SetCompressType(c CompressType) {
-	switch c {
-	case CompressNone, CompressZip, CompressBestSpeed, CompressBestCompression, CompressHuffman:
-		ioReader := io.Reader(t.conn)
-		t.reader = flate.NewReader(ioReader)
+// check whether the read stream has a compression layer.
+func (t *gettyTCPConn) readLayered() bool {
+	t.streamLock.RLock()
+	defer t.streamLock.RUnlock()
 
-		ioWriter := io.Writer(t.conn)
-		w, err := flate.NewWriter(ioWriter, int(c))
-		if err != nil {
-			panic(fmt.Sprintf("flate.NewReader(flate.DefaultCompress) = err(%s)", err))
-		}
-		t.writer = &writeFlusher{flusher: w}
+	return t.reader != nil
+}
 
-	case CompressSnappy:
-		ioReader := io.Reader(t.conn)
-		t.reader = snappy.NewReader(ioReader)
-		ioWriter := io.Writer(t.conn)
-		t.writer = snappy.NewBufferedWriter(ioWriter)
+// check whether the write stream has a compression layer.
+func (t *gettyTCPConn) writeLayered() bool {
+	t.streamLock.RLock()
+	defer t.streamLock.RUnlock()
 
-	default:
-		panic(fmt.Sprintf("illegal comparess type %d", c))
+	return t.writer != nil
+}
+
+// put the compression layer @layer over the read wrapper chain, and set the compress type if
+// @c is not nil. nil @layer removes the layer. The replaced layer is released, so it should be
+// invoked by the read goroutine or before the session runs.
+func (t *gettyTCPConn) setReadLayer(layer func(io.Reader) io.Reader, c *CompressType) {
+	t.streamLock.Lock()
+	defer t.streamLock.Unlock()
+
+	releaseStream(t.reader)
+	t.reader = nil
+	if layer != nil {
+		t.reader = layer(t.baseReader)
+	}
+	if c != nil {
+		t.compress = *c
 	}
-	t.compress = c
+}
+
+// put the compression layer @layer over the write wrapper chain, and set the compress type if
+// @c is not nil. nil @layer removes the layer.
+func (t *gettyTCPConn) setWriteLayer(layer func(io.Writer) io.Writer, c *CompressType) {
+	t.streamLock.Lock()
+	writer := t.writer
+	t.writer = nil
+	if layer != nil {
+		t.writer = layer(t.baseWriter)
+	}
+	if c != nil {
+		t.compress = *c
+	}
+	t.streamLock.Unlock()
+	// the release waits for the write in progress
+	releaseStream(writer)
 }
 
 // tcp connection read
 func (t *gettyTCPConn) recv(p []byte) (int, error) {
 	var (
-		err         error
-		currentTime time.Time
-		length      int
+		err    error
+		length int
 	)
 
 	// set read timeout deadline
-	if t.compress == CompressNone && t.rTimeout > 0 {
-		// Optimization: update read deadline only if more than 25%
-		// of the last read deadline exceeded.
-		// See https://github.com/golang/go/issues/15133 for details.
-		currentTime = time.Now()
-		if currentTime.Sub(t.rLastDeadline) > (t.rTimeout >> 2) {
-			if err = t.conn.SetReadDeadline(currentTime.Add(t.rTimeout)); err != nil {
-				// just a timeout error
-				return 0, jerrors.Trace(err)
-			}
-			t.rLastDeadline = currentTime
+	reader, deadline := t.readStream()
+	if deadline {
+		if _, err = t.refreshReadDeadline(t.conn.SetReadDeadline); err != nil {
+			// just a timeout error
+			return 0, err
 		}
 	}
 
-	length, err = t.reader.Read(p)
+	length, err = reader.Read(p)
 	// log.Debug("now:%s, length:%d, err:%s", currentTime, length, err)
 	atomic.AddUint32(&t.readBytes, uint32(length))
 	return length, jerrors.Trace(err)
@@ -266,16 +376,10 @@ func (t *gettyTCPConn) send(pkg interface{}) (int, error) {
 		length      int
 	)
 
-	if t.compress == CompressNone && t.wTimeout > 0 {
-		// Optimization: update write deadline only if more than 25%
-		// of the last write deadline exceeded.
-		// See https://github.com/golang/go/issues/15133 for details.
-		currentTime = time.Now()
-		if currentTime.Sub(t.wLastDeadline) > (t.wTimeout >> 2) {
-			if err = t.conn.SetWriteDeadline(currentTime.Add(t.wTimeout)); err != nil {
-				return 0, jerrors.Trace(err)
-			}
-			t.wLastDeadline = currentTime
+	writer, deadline := t.writeStream()
+	if deadline {
+		if currentTime, err = t.refreshWriteDeadline(t.conn.SetWriteDeadline); err != nil {
+			return 0, err
 		}
 	}
 	if buffers, ok := pkg.([][]byte); ok {
@@ -290,7 +394,14 @@ func (t *gettyTCPConn) send(pkg interface{}) (int, error) {
 	}
 
 	if p, ok = pkg.([]byte); ok {
-		if length, err = t.writer.Write(p); err == nil {
+		if writer != io.Writer(t.conn) {
+			t.writeLock.Lock()
+			length, err = writer.Write(p)
+			t.writeLock.Unlock()
+		} else {
+			length, err = writer.Write(p)
+		}
+		if err == nil {
 			atomic.AddUint32(&t.writeBytes, (uint32)(len(p)))
 		}
 		log.Debug("localAddr: %s, remoteAddr:%s, now:%s, length:%d, err:%s",
@@ -307,15 +418,16 @@ func (t *gettyTCPConn) close(waitSec int) {
 	// tcpConn.SetLinger(0)
 	// }
 
-	if t.conn != nil {
-		if writer, ok := t.writer.(*snappy.Writer); ok {
-			if err := writer.Close(); err != nil {
-				log.Error("snappy.Writer.Close() = error{%s}", jerrors.ErrorStack(err))
-			}
+	if t.conn != nil && t.markClosed() {
+		if tcpConn, ok := t.conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(waitSec)
 		}
-		t.conn.(*net.TCPConn).SetLinger(waitSec)
 		t.conn.Close()
-		t.conn = nil
+
+		t.streamLock.RLock()
+		writer := t.writer
+		t.streamLock.RUnlock()
+		releaseStream(writer)
 	}
 }
 
@@ -326,6 +438,8 @@ func (t *gettyTCPConn) close(waitSec int) {
 type UDPContext struct {
 	Pkg      interface{}
 	PeerAddr *net.UDPAddr
+	// the kernel timestamps of the received datagram, see (DatagramSession)SetUDPTimestamping
+	Timestamp PacketTimestamp
 }
 
 func (c UDPContext) String() string {
@@ -336,6 +450,21 @@ type gettyUDPConn struct {
 	gettyConn
 	compressType CompressType
 	conn         *net.UDPConn // for server
+	// fragmentation config, nil means disabled
+	fragment   *FragmentConfig
+	fragmentID uint32
+	// fec encoder, nil means disabled
+	fec *fecEncoder
+	// dedup sender, nil means disabled
+	dedup *dedupSender
+	// datagram encryption, nil means disabled
+	sealer *datagramSealer
+	// kernel timestamping, nil means disabled. @rxStamp is the timestamp of the last read
+	// datagram, and @txStamp is the one of the last sent pkg.
+	stamping *UDPTimestampingConfig
+	oob      []byte
+	rxStamp  PacketTimestamp
+	txStamp  PacketTimestamp
 }
 
 // create gettyUDPConn
@@ -346,12 +475,12 @@ func newGettyUDPConn(conn *net.UDPConn) *gettyUDPConn {
 
 	var localAddr, peerAddr string
 	if conn.LocalAddr() != nil {
-		localAddr = conn.LocalAddr().String()
+		localAddr = formatAddr(conn.LocalAddr())
 	}
 
 	if conn.RemoteAddr() != nil {
 		// connected udp
-		peerAddr = conn.RemoteAddr().String()
+		peerAddr = formatAddr(conn.RemoteAddr())
 	}
 
 	return &gettyUDPConn{
@@ -363,13 +492,14 @@ func newGettyUDPConn(conn *net.UDPConn) *gettyUDPConn {
 			local:    localAddr,
 			peer:     peerAddr,
 			compress: CompressNone,
+			clock:    getActivityClock(),
 		},
 	}
 }
 
 func (u *gettyUDPConn) SetCompressType(c CompressType) {
 	switch c {
-	case CompressNone, CompressZip, CompressBestSpeed, CompressBestCompression, CompressHuffman, CompressSnappy:
+	case CompressNone, CompressZip, CompressBestSpeed, CompressBestCompression, CompressHuffman, CompressSnappy, CompressSnappyBlock:
 		u.compressType = c
 
 	default:
@@ -380,26 +510,22 @@ func (u *gettyUDPConn) SetCompressType(c CompressType) {
 // udp connection read
 func (u *gettyUDPConn) recv(p []byte) (int, *net.UDPAddr, error) {
 	var (
-		err         error
-		currentTime time.Time
-		length      int
-		addr        *net.UDPAddr
+		err    error
+		length int
+		addr   *net.UDPAddr
 	)
 
-	if u.rTimeout > 0 {
-		// Optimization: update read deadline only if more than 25%
-		// of the last read deadline exceeded.
-		// See https://github.com/golang/go/issues/15133 for details.
-		currentTime = time.Now()
-		if currentTime.Sub(u.rLastDeadline) > (u.rTimeout >> 2) {
-			if err = u.conn.SetReadDeadline(currentTime.Add(u.rTimeout)); err != nil {
-				return 0, nil, jerrors.Trace(err)
-			}
-			u.rLastDeadline = currentTime
-		}
+	if _, err = u.refreshReadDeadline(u.conn.SetReadDeadline); err != nil {
+		return 0, nil, err
 	}
 
-	length, addr, err = u.conn.ReadFromUDP(p) // connected udp also can get return @addr
+	if u.oob != nil {
+		var oobn int
+		length, oobn, _, addr, err = u.conn.ReadMsgUDP(p, u.oob)
+		u.rxStamp = parsePacketTimestamp(u.oob[:oobn])
+	} else {
+		length, addr, err = u.conn.ReadFromUDP(p) // connected udp also can get return @addr
+	}
 	log.Debug("ReadFromUDP() = {length:%d, peerAddr:%s, error:%s}", length, addr, err)
 	if err == nil {
 		atomic.AddUint32(&u.readBytes, uint32(length))
@@ -412,13 +538,12 @@ func (u *gettyUDPConn) recv(p []byte) (int, *net.UDPAddr, error) {
 // write udp packet, @ctx should be of type UDPContext
 func (u *gettyUDPConn) send(udpCtx interface{}) (int, error) {
 	var (
-		err         error
-		currentTime time.Time
-		length      int
-		ok          bool
-		ctx         UDPContext
-		buf         []byte
-		peerAddr    *net.UDPAddr
+		err      error
+		length   int
+		ok       bool
+		ctx      UDPContext
+		buf      []byte
+		peerAddr *net.UDPAddr
 	)
 
 	if ctx, ok = udpCtx.(UDPContext); !ok {
@@ -434,21 +559,16 @@ func (u *gettyUDPConn) send(udpCtx interface{}) (int, error) {
 		}
 	}
 
-	if u.wTimeout > 0 {
-		// Optimization: update write deadline only if more than 25%
-		// of the last write deadline exceeded.
-		// See https://github.com/golang/go/issues/15133 for details.
-		currentTime = time.Now()
-		if currentTime.Sub(u.wLastDeadline) > (u.wTimeout >> 2) {
-			if err = u.conn.SetWriteDeadline(currentTime.Add(u.wTimeout)); err != nil {
-				return 0, jerrors.Trace(err)
-			}
-			u.wLastDeadline = currentTime
-		}
+	if _, err = u.refreshWriteDeadline(u.conn.SetWriteDeadline); err != nil {
+		return 0, err
 	}
 
+	if u.fragment != nil || u.fec != nil || u.dedup != nil || u.sealer != nil {
+		return u.sendDatagrams(buf, peerAddr)
+	}
 	if length, _, err = u.conn.WriteMsgUDP(buf, nil, peerAddr); err == nil {
 		atomic.AddUint32(&u.writeBytes, (uint32)(len(buf)))
+		u.stampTx(1)
 	}
 	log.Debug("WriteMsgUDP(peerAddr:%s) = {length:%d, error:%s}", peerAddr, length, err)
 
@@ -456,11 +576,57 @@ func (u *gettyUDPConn) send(udpCtx interface{}) (int, error) {
 	//return length, err
 }
 
+// split @buf into fragments, add the fec datagrams, number and encrypt the datagrams if
+// they are enabled, then send the datagrams to @peerAddr
+func (u *gettyUDPConn) sendDatagrams(buf []byte, peerAddr *net.UDPAddr) (int, error) {
+	var (
+		err       error
+		datagrams = [][]byte{buf}
+	)
+
+	if u.fragment != nil {
+		if datagrams, err = u.fragment.fragment(atomic.AddUint32(&u.fragmentID, 1), buf); err != nil {
+			return 0, err
+		}
+	}
+	if u.fec != nil {
+		var peer string
+		if peerAddr != nil {
+			peer = peerAddr.String()
+		}
+		datagrams = u.fec.encode(peer, datagrams, time.Now())
+	}
+	if u.dedup != nil {
+		for i, datagram := range datagrams {
+			datagrams[i] = u.dedup.encode(datagram)
+		}
+	}
+	if u.sealer != nil {
+		now := time.Now()
+		for i, datagram := range datagrams {
+			datagrams[i] = u.sealer.seal(datagram, now)
+		}
+	}
+
+	total := 0
+	for _, datagram := range datagrams {
+		length, _, err := u.conn.WriteMsgUDP(datagram, nil, peerAddr)
+		if err != nil {
+			log.Debug("WriteMsgUDP(peerAddr:%s) = {length:%d, error:%s}", peerAddr, length, err)
+			return total, jerrors.Trace(err)
+		}
+		atomic.AddUint32(&u.writeBytes, uint32(length))
+		total += length
+	}
+	u.stampTx(len(datagrams))
+
+	return total, nil
+}
+
 // close udp connection
 func (u *gettyUDPConn) close(_ int) {
-	if u.conn != nil {
+	if u.conn != nil && u.markClosed() {
 		u.conn.Close()
-		u.conn = nil
 	}
 }
 
@@ -471,6 +637,19 @@ func (u *gettyUDPConn) close(_ int) {
 type gettyWSConn struct {
 	gettyConn
 	conn *websocket.Conn
+	// websocket connection supports only one concurrent writer, so @writeLock serializes
+	// the data, ping & pong frames written by the read/write loops and WritePkg.
+	writeLock sync.Mutex
+
+	closeLock sync.Mutex
+	// the close status sent by CloseWithStatus
+	closeStatus *CloseReason
+	// the close status received from the peer
+	closeReason *CloseReason
+	// the brotliWrapper of the read/written messages, whose nil codec means the messages are
+	// not compressed by brotli
+	readBrotli  atomic.Value
+	writeBrotli atomic.Value
 }
 
 // create websocket connection
@@ -481,10 +660,10 @@ func newGettyWSConn(conn *websocket.Conn) *gettyWSConn {
 	var localAddr, peerAddr string
 	//  check conn.LocalAddr or conn.RemoetAddr is nil to defeat panic on 2016/09/27
 	if conn.LocalAddr() != nil {
-		localAddr = conn.LocalAddr().String()
+		localAddr = formatAddr(conn.LocalAddr())
 	}
 	if conn.RemoteAddr() != nil {
-		peerAddr = conn.RemoteAddr().String()
+		peerAddr = formatAddr(conn.RemoteAddr())
 	}
 
 	gettyWSConn := &gettyWSConn{
@@ -496,6 +675,7 @@ func newGettyWSConn(conn *websocket.Conn) *gettyWSConn {
 			local:    localAddr,
 			peer:     peerAddr,
 			compress: CompressNone,
+			clock:    getActivityClock(),
 		},
 	}
 	conn.EnableWriteCompression(false)
@@ -507,15 +687,45 @@ func newGettyWSConn(conn *websocket.Conn) *gettyWSConn {
 
 // set compress type
 func (w *gettyWSConn) SetCompressType(c CompressType) {
+	w.switchReadCompress(c)
+	w.switchWriteCompress(c)
+}
+
+// switch the decompression of the read messages. The permessage-deflate messages are
+// decompressed whatever the compress type is, and brotli decompresses every message payload.
+func (w *gettyWSConn) switchReadCompress(c CompressType) {
+	var brotli brotliWrapper
+	if quality, ok := brotliQuality(c); ok {
+		brotli = brotliWrapper{codec: getBrotliCodec(), quality: quality}
+	}
+	w.readBrotli.Store(brotli)
+}
+
+// switch the compression of the written messages. brotli compresses every message payload
+// instead of the permessage-deflate extension.
+func (w *gettyWSConn) switchWriteCompress(c CompressType) {
+	if quality, ok := brotliQuality(c); ok {
+		codec := getBrotliCodec()
+		w.writeLock.Lock()
+		w.conn.EnableWriteCompression(false)
+		w.writeBrotli.Store(brotliWrapper{codec: codec, quality: quality})
+		w.compress = c
+		w.writeLock.Unlock()
+		return
+	}
+
 	switch c {
 	case CompressNone, CompressZip, CompressBestSpeed, CompressBestCompression, CompressHuffman:
+		w.writeLock.Lock()
 		w.conn.EnableWriteCompression(true)
 		w.conn.SetCompressionLevel(int(c))
+		w.writeBrotli.Store(brotliWrapper{})
+		w.compress = c
+		w.writeLock.Unlock()
 
 	default:
 		panic(fmt.Sprintf("illegal comparess type %d", c))
 	}
-	w.compress = c
 }
 
 func (w *gettyWSConn) handlePing(message string) error {
@@ -544,36 +754,84 @@ func (w *gettyWSConn) recv() ([]byte, error) {
 	_, b, e := w.conn.ReadMessage() // the first return value is message type.
 	if e == nil {
 		atomic.AddUint32(&w.readBytes, (uint32)(len(b)))
+		if brotli := loadBrotli(&w.readBrotli); brotli.codec != nil {
+			return brotliDecode(brotli.codec, b)
+		}
 	} else {
 		if websocket.IsUnexpectedCloseError(e, websocket.CloseGoingAway) {
 			log.Warn("websocket unexpected close error: %v", e)
 		}
+		w.recordCloseError(e)
+		if e == websocket.ErrReadLimit {
+			return b, newGettyError(ErrMsgTooLarge, e)
+		}
 	}
 
 	return b, jerrors.Trace(e)
 	//return b, e
 }
 
-func (w *gettyWSConn) updateWriteDeadline() error {
-	var (
-		err         error
-		currentTime time.Time
-	)
+// websocket connection streaming read. the returned reader reads the payload of the next
+// message incrementally, and it becomes invalid after the next recv/recvStream invocation.
+func (w *gettyWSConn) recvStream() (*wsStreamReader, error) {
+	_, r, e := w.conn.NextReader() // the first return value is message type.
+	if e != nil {
+		if websocket.IsUnexpectedCloseError(e, websocket.CloseGoingAway) {
+			log.Warn("websocket unexpected close error: %v", e)
+		}
+		w.recordCloseError(e)
+		return nil, jerrors.Trace(e)
+	}
+
+	reader := &wsStreamReader{conn: w, r: r}
+	if brotli := loadBrotli(&w.readBrotli); brotli.codec != nil {
+		reader.decoder = brotli.codec.NewReader(wsRawReader{reader})
+	}
+	return reader, nil
+}
 
-	if w.wTimeout > 0 {
-		// Optimization: update write deadline only if more than 25%
-		// of the last write deadline exceeded.
-		// See https://github.com/golang/go/issues/15133 for details.
-		currentTime = time.Now()
-		if currentTime.Sub(w.wLastDeadline) > (w.wTimeout >> 2) {
-			if err = w.conn.SetWriteDeadline(currentTime.Add(w.wTimeout)); err != nil {
-				return jerrors.Trace(err)
-			}
-			w.wLastDeadline = currentTime
+// wsStreamReader counts the read bytes of a websocket message reader
+type wsStreamReader struct {
+	conn *gettyWSConn
+	r    io.Reader
+	// the brotli reader over the message, nil if the messages are not compressed by brotli
+	decoder io.Reader
+	// the network error got by Read
+	err error
+}
+
+func (r *wsStreamReader) Read(p []byte) (int, error) {
+	if r.decoder != nil {
+		return r.decoder.Read(p)
+	}
+	return r.readRaw(p)
+}
+
+// read the message payload
+func (r *wsStreamReader) readRaw(p []byte) (int, error) {
+	n, e := r.r.Read(p)
+	atomic.AddUint32(&r.conn.readBytes, (uint32)(n))
+	if e != nil && e != io.EOF {
+		if e == websocket.ErrReadLimit {
+			e = newGettyError(ErrMsgTooLarge, e)
 		}
+		r.err = e
 	}
 
-	return nil
+	return n, e
+}
+
+type wsRawReader struct {
+	r *wsStreamReader
+}
+
+func (r wsRawReader) Read(p []byte) (int, error) {
+	return r.r.readRaw(p)
+}
+
+func (w *gettyWSConn) updateWriteDeadline() error {
+	_, err := w.refreshWriteDeadline(w.conn.SetWriteDeadline)
+	return err
 }
 
 // websocket connection write
@@ -587,10 +845,19 @@ func (w *gettyWSConn) send(pkg interface{}) (int, error) {
 	if p, ok = pkg.([]byte); !ok {
 		return 0, jerrors.Errorf("illegal @pkg{%#v} type", pkg)
 	}
+	payload := p
+	if brotli := loadBrotli(&w.writeBrotli); brotli.codec != nil {
+		if payload, err = brotliEncode(brotli.codec, brotli.quality, p); err != nil {
+			return 0, err
+		}
+	}
 
 	w.updateWriteDeadline()
-	if err = w.conn.WriteMessage(websocket.BinaryMessage, p); err == nil {
-		atomic.AddUint32(&w.writeBytes, (uint32)(len(p)))
+	w.writeLock.Lock()
+	err = w.conn.WriteMessage(websocket.BinaryMessage, payload)
+	w.writeLock.Unlock()
+	if err == nil {
+		atomic.AddUint32(&w.writeBytes, (uint32)(len(payload)))
 	}
 	return len(p), jerrors.Trace(err)
 	//return len(p), err
@@ -598,18 +865,89 @@ func (w *gettyWSConn) send(pkg interface{}) (int, error) {
 
 func (w *gettyWSConn) writePing() error {
 	w.updateWriteDeadline()
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
 	return jerrors.Trace(w.conn.WriteMessage(websocket.PingMessage, []byte{}))
 }
 
 func (w *gettyWSConn) writePong(message []byte) error {
 	w.updateWriteDeadline()
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
 	return jerrors.Trace(w.conn.WriteMessage(websocket.PongMessage, message))
 }
 
+// record the close code & reason received from the peer
+func (w *gettyWSConn) recordCloseError(e error) {
+	if ce, ok := e.(*websocket.CloseError); ok {
+		w.closeLock.Lock()
+		if w.closeReason == nil {
+			w.closeReason = &CloseReason{Code: ce.Code, Text: ce.Text}
+		}
+		w.closeLock.Unlock()
+	}
+}
+
+// the payload of a close frame is limited to 125 bytes, 2 of which are taken by the close code
+const maxCloseReasonLen = 123
+
+// check whether @code can be sent in a close frame according to RFC 6455 section 7.4
+func isSendableCloseCode(code int) bool {
+	switch {
+	case websocket.CloseNormalClosure <= code && code <= websocket.CloseUnsupportedData:
+		return true
+	case websocket.CloseInvalidFramePayloadData <= code && code <= websocket.CloseTryAgainLater:
+		return true
+	case 3000 <= code && code <= 4999:
+		return true
+	}
+
+	return false
+}
+
+// set the close status which will be sent in close. an unsendable @code is replaced with
+// CloseNormalClosure, and @reason is truncated to 123 bytes on a utf-8 boundary.
+func (w *gettyWSConn) setCloseStatus(code int, reason string) {
+	if !isSendableCloseCode(code) {
+		log.Warn("websocket close code %d can not be sent, use %d instead", code, websocket.CloseNormalClosure)
+		code = websocket.CloseNormalClosure
+	}
+	if maxCloseReasonLen < len(reason) {
+		n := maxCloseReasonLen
+		for 0 < n && !utf8.RuneStart(reason[n]) {
+			n--
+		}
+		reason = reason[:n]
+	}
+
+	w.closeLock.Lock()
+	w.closeStatus = &CloseReason{Code: code, Text: reason}
+	w.closeLock.Unlock()
+}
+
+// get the received close status, or the sent close status if nothing is received
+func (w *gettyWSConn) getCloseReason() *CloseReason {
+	w.closeLock.Lock()
+	defer w.closeLock.Unlock()
+
+	if w.closeReason != nil {
+		return w.closeReason
+	}
+	return w.closeStatus
+}
+
 // close websocket connection
 func (w *gettyWSConn) close(waitSec int) {
 	w.updateWriteDeadline()
-	w.conn.WriteMessage(websocket.CloseMessage, []byte("bye-bye!!!"))
+	code, text := websocket.CloseNormalClosure, ""
+	w.closeLock.Lock()
+	if w.closeStatus != nil {
+		code, text = w.closeStatus.Code, w.closeStatus.Text
+	}
+	w.closeLock.Unlock()
+	w.writeLock.Lock()
+	w.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+	w.writeLock.Unlock()
 	conn := w.conn.UnderlyingConn()
 	if tcpConn, ok := conn.(*net.TCPConn); ok {
 		tcpConn.SetLinger(waitSec)
@@ -18,36 +18,57 @@ type EndPointID = int32
 type EndPointType int32
 
 const (
-	UDP_ENDPOINT EndPointType = 0
-	UDP_CLIENT   EndPointType = 1
-	TCP_CLIENT   EndPointType = 2
-	WS_CLIENT    EndPointType = 3
-	WSS_CLIENT   EndPointType = 4
-	TCP_SERVER   EndPointType = 7
-	WS_SERVER    EndPointType = 8
-	WSS_SERVER   EndPointType = 9
+	UDP_ENDPOINT     EndPointType = 0
+	UDP_CLIENT       EndPointType = 1
+	TCP_CLIENT       EndPointType = 2
+	WS_CLIENT        EndPointType = 3
+	WSS_CLIENT       EndPointType = 4
+	TCP_SERVER       EndPointType = 7
+	WS_SERVER        EndPointType = 8
+	WSS_SERVER       EndPointType = 9
+	RAW_IP_ENDPOINT  EndPointType = 10
+	SERIAL_CLIENT    EndPointType = 11
+	BLUETOOTH_CLIENT EndPointType = 12
+	UNIX_SERVER      EndPointType = 13
+	UNIX_CLIENT      EndPointType = 14
+	TRANSPORT_CLIENT EndPointType = 15
+	TRANSPORT_SERVER EndPointType = 16
 )
 
 var EndPointType_name = map[int32]string{
-	0: "UDP_ENDPOINT",
-	1: "UDP_CLIENT",
-	2: "TCP_CLIENT",
-	3: "WS_CLIENT",
-	4: "WSS_CLIENT",
-	7: "TCP_SERVER",
-	8: "WS_SERVER",
-	9: "WSS_SERVER",
+	0:  "UDP_ENDPOINT",
+	1:  "UDP_CLIENT",
+	2:  "TCP_CLIENT",
+	3:  "WS_CLIENT",
+	4:  "WSS_CLIENT",
+	7:  "TCP_SERVER",
+	8:  "WS_SERVER",
+	9:  "WSS_SERVER",
+	10: "RAW_IP_ENDPOINT",
+	11: "SERIAL_CLIENT",
+	12: "BLUETOOTH_CLIENT",
+	13: "UNIX_SERVER",
+	14: "UNIX_CLIENT",
+	15: "TRANSPORT_CLIENT",
+	16: "TRANSPORT_SERVER",
 }
 
 var EndPointType_value = map[string]int32{
-	"UDP_ENDPOINT": 0,
-	"UDP_CLIENT":   1,
-	"TCP_CLIENT":   2,
-	"WS_CLIENT":    3,
-	"WSS_CLIENT":   4,
-	"TCP_SERVER":   7,
-	"WS_SERVER":    8,
-	"WSS_SERVER":   9,
+	"UDP_ENDPOINT":     0,
+	"UDP_CLIENT":       1,
+	"TCP_CLIENT":       2,
+	"WS_CLIENT":        3,
+	"WSS_CLIENT":       4,
+	"TCP_SERVER":       7,
+	"WS_SERVER":        8,
+	"WSS_SERVER":       9,
+	"RAW_IP_ENDPOINT":  10,
+	"SERIAL_CLIENT":    11,
+	"BLUETOOTH_CLIENT": 12,
+	"UNIX_SERVER":      13,
+	"UNIX_CLIENT":      14,
+	"TRANSPORT_CLIENT": 15,
+	"TRANSPORT_SERVER": 16,
 }
 
 func (x EndPointType) String() string {
@@ -0,0 +1,183 @@
+/******************************************************
+# DESC       : udp duplicate datagram suppression
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-20 10:40
+# FILE       : dedup.go
+******************************************************/
+
+package getty
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	// magic(1B) + sender epoch(4B) + sequence number(8B)
+	dedupHeaderLen = 13
+	dedupMagic     = 0xF9
+
+	defaultDedupWindow      = 1024
+	defaultDedupPeerTimeout = time.Minute
+)
+
+var (
+	errIllegalDedupDatagram = jerrors.New("illegal udp dedup datagram")
+)
+
+// DedupConfig is the udp duplicate datagram suppression config of a session, which is
+// set by (DatagramSession)SetDedup. The sender numbers every datagram, and the receiver drops
+// the datagram whose sequence number has been received or is older than the window.
+// Both peers should enable the dedup.
+type DedupConfig struct {
+	// the sliding window size in datagrams. It is rounded up to a multiple of 64,
+	// and its default value is 1024.
+	Window int
+	// the window of a peer is dropped if no datagram is received from it in this time.
+	// Its default value is 1 minute.
+	PeerTimeout time.Duration
+}
+
+func (c DedupConfig) withDefaults() DedupConfig {
+	if c.Window <= 0 {
+		c.Window = defaultDedupWindow
+	}
+	c.Window = (c.Window + 63) &^ 63
+	if c.PeerTimeout <= 0 {
+		c.PeerTimeout = defaultDedupPeerTimeout
+	}
+
+	return c
+}
+
+// dedupSender numbers the datagrams sent by a session. A random epoch is chosen for
+// every session, so the receiver resets its window when the sender restarts.
+type dedupSender struct {
+	config DedupConfig
+	epoch  uint32
+	seq    uint64
+}
+
+func newDedupSender(config DedupConfig) *dedupSender {
+	return &dedupSender{
+		config: config,
+		epoch:  rand.Uint32(),
+	}
+}
+
+// add the dedup header to @datagram
+func (d *dedupSender) encode(datagram []byte) []byte {
+	b := make([]byte, dedupHeaderLen+len(datagram))
+	b[0] = dedupMagic
+	binary.BigEndian.PutUint32(b[1:], d.epoch)
+	binary.BigEndian.PutUint64(b[5:], atomic.AddUint64(&d.seq, 1))
+	copy(b[dedupHeaderLen:], datagram)
+	return b
+}
+
+type dedupWindow struct {
+	epoch      uint32
+	max        uint64
+	bitmap     []uint64
+	lastActive time.Time
+}
+
+// dedupReceiver is only used by the read goroutine of the session
+type dedupReceiver struct {
+	config    DedupConfig
+	windows   map[string]*dedupWindow
+	lastSweep time.Time
+}
+
+func newDedupReceiver(config DedupConfig) *dedupReceiver {
+	return &dedupReceiver{
+		config:  config,
+		windows: make(map[string]*dedupWindow),
+	}
+}
+
+// check the datagram @data from @peer. It returns the datagram without the dedup header,
+// or nil if it is a duplicate one.
+func (d *dedupReceiver) add(peer string, data []byte, now time.Time) ([]byte, error) {
+	if len(data) < dedupHeaderLen || data[0] != dedupMagic {
+		return nil, errIllegalDedupDatagram
+	}
+	epoch := binary.BigEndian.Uint32(data[1:])
+	seq := binary.BigEndian.Uint64(data[5:])
+	if seq == 0 {
+		return nil, errIllegalDedupDatagram
+	}
+
+	d.sweep(now)
+	w, ok := d.windows[peer]
+	if !ok || w.epoch != epoch {
+		// new peer or the peer has restarted
+		w = &dedupWindow{epoch: epoch, bitmap: make([]uint64, d.config.Window/64)}
+		d.windows[peer] = w
+	}
+	w.lastActive = now
+	if !w.accept(seq, uint64(d.config.Window)) {
+		return nil, nil
+	}
+
+	return data[dedupHeaderLen:], nil
+}
+
+// mark @seq as received. It returns false if @seq is duplicate or out of the window.
+func (w *dedupWindow) accept(seq uint64, size uint64) bool {
+	switch {
+	case w.max < seq:
+		if size <= seq-w.max {
+			for i := range w.bitmap {
+				w.bitmap[i] = 0
+			}
+		} else {
+			for s := w.max + 1; s < seq; s++ {
+				w.clear(s % size)
+			}
+		}
+		w.max = seq
+
+	case size <= w.max-seq:
+		return false
+
+	case w.isSet(seq % size):
+		return false
+	}
+
+	w.set(seq % size)
+	return true
+}
+
+func (w *dedupWindow) isSet(bit uint64) bool {
+	return w.bitmap[bit/64]&(1<<(bit%64)) != 0
+}
+
+func (w *dedupWindow) set(bit uint64) {
+	w.bitmap[bit/64] |= 1 << (bit % 64)
+}
+
+func (w *dedupWindow) clear(bit uint64) {
+	w.bitmap[bit/64] &^= 1 << (bit % 64)
+}
+
+// drop the windows of the idle peers
+func (d *dedupReceiver) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.config.PeerTimeout>>1 {
+		return
+	}
+	d.lastSweep = now
+	for peer, w := range d.windows {
+		if now.Sub(w.lastActive) > d.config.PeerTimeout {
+			delete(d.windows, peer)
+		}
+	}
+}
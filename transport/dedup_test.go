@@ -0,0 +1,90 @@
+package getty
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupWindow(t *testing.T) {
+	config := DedupConfig{Window: 100}.withDefaults()
+	assert.Equal(t, 128, config.Window)
+
+	now := time.Now()
+	sender := newDedupSender(config)
+	datagrams := make([][]byte, 300)
+	for i := range datagrams {
+		datagrams[i] = sender.encode([]byte{byte(i)})
+	}
+
+	r := newDedupReceiver(config)
+	accept := func(i int) bool {
+		data, err := r.add("peer", datagrams[i], now)
+		assert.Nil(t, err)
+		if data == nil {
+			return false
+		}
+		assert.Equal(t, []byte{byte(i)}, data)
+		return true
+	}
+	assert.True(t, accept(0))
+	assert.False(t, accept(0))
+	// out of order
+	assert.True(t, accept(5))
+	assert.True(t, accept(3))
+	assert.False(t, accept(3))
+	assert.False(t, accept(5))
+	// slide the window
+	assert.True(t, accept(130))
+	assert.True(t, accept(4))
+	assert.False(t, accept(2))
+	assert.True(t, accept(299))
+	assert.False(t, accept(130))
+	assert.False(t, accept(299))
+
+	// the sender restarts
+	sender = newDedupSender(config)
+	sender.epoch = r.windows["peer"].epoch + 1
+	data, err := r.add("peer", sender.encode([]byte("hello")), now)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	_, err = r.add("peer", []byte("hello"), now)
+	assert.Equal(t, errIllegalDedupDatagram, err)
+}
+
+func TestUDPSessionDedup(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer peer.Close()
+	local, err := net.DialUDP("udp", nil, peer.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer local.Close()
+
+	ss := newUDPSession(local, newClient(UDP_CLIENT, WithServerAddress(peer.LocalAddr().String()), WithConnectionNumber(1)))
+	ss.(DatagramSession).SetDedup(&DedupConfig{})
+	size := ss.(DatagramSession).MaxDatagramSize()
+	ss.(DatagramSession).SetDedup(nil)
+	assert.Equal(t, size+dedupHeaderLen, ss.(DatagramSession).MaxDatagramSize())
+	ss.(DatagramSession).SetDedup(&DedupConfig{})
+
+	conn := ss.(*session).Connection.(*gettyUDPConn)
+	_, err = conn.send(UDPContext{Pkg: []byte("hello")})
+	assert.Nil(t, err)
+
+	buf := make([]byte, 1500)
+	n, addr, err := peer.ReadFromUDP(buf)
+	assert.Nil(t, err)
+	r := newDedupReceiver(conn.dedup.config)
+	data, err := r.add(addr.String(), buf[:n], time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), data)
+	// the datagram is duplicated by the network
+	data, err = r.add(addr.String(), buf[:n], time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+}
@@ -0,0 +1,102 @@
+/******************************************************
+# DESC       : protocol desync recovery of stream sessions
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-12 15:10
+# FILE       : desync.go
+******************************************************/
+
+package getty
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ResyncStrategy is the recovery strategy of a stream session whose codec reports a framing
+// error, i.e. the stream is out of sync with the frame boundaries.
+type ResyncStrategy int
+
+const (
+	// close the session, which is the default behavior
+	ResyncClose ResyncStrategy = iota
+	// discard the bytes before the next magic bytes, which begin every frame
+	ResyncSkipToMagic
+	// discard a fixed number of bytes and try to decode again
+	ResyncDropBytes
+)
+
+func (s ResyncStrategy) String() string {
+	switch s {
+	case ResyncClose:
+		return "close"
+	case ResyncSkipToMagic:
+		return "skip to magic"
+	case ResyncDropBytes:
+		return "drop bytes"
+	}
+
+	return fmt.Sprintf("ResyncStrategy(%d)", int(s))
+}
+
+// ResyncConfig is the desync recovery config of a tcp session, which keeps the session for
+// the noisy peers such as the embedded devices on the serial lines. The error of the codec
+// and ErrMsgTooLarge are taken as the framing errors. The framing errors are still reported
+// to the ban list and notified to the EventListener by OnSessionError if it is an ErrorListener.
+type ResyncConfig struct {
+	Strategy ResyncStrategy
+	// the magic bytes of ResyncSkipToMagic. The session is closed if it is empty.
+	Magic []byte
+	// the bytes discarded per framing error by ResyncDropBytes. Its default value is 1.
+	DropBytes int
+	// the session is closed if the bytes discarded since the last decoded pkg exceed it,
+	// which bounds the cost of a garbage stream. 0 means no limit.
+	MaxDiscardBytes int
+}
+
+// the number of the leading bytes of @buf discarded to recover from a framing error. 0 means
+// waiting for more bytes, and -1 means the session should be closed.
+func (c *ResyncConfig) discard(buf []byte) int {
+	switch c.Strategy {
+	case ResyncSkipToMagic:
+		if len(c.Magic) == 0 {
+			return -1
+		}
+		// the frame at the head is broken, so the search starts from the next byte
+		if i := bytes.Index(buf[1:], c.Magic); i >= 0 {
+			return i + 1
+		}
+		// keep the tail which may be the prefix of the magic bytes
+		if n := len(buf) - len(c.Magic) + 1; n > 0 {
+			return n
+		}
+		return 0
+
+	case ResyncDropBytes:
+		n := c.DropBytes
+		if n <= 0 {
+			n = 1
+		}
+		if len(buf) < n {
+			n = len(buf)
+		}
+		return n
+	}
+
+	return -1
+}
+
+// SetResync sets the desync recovery of a tcp session, and nil @config restores the default
+// behavior, i.e. closing the session. It should be invoked before the session runs, e.g. in
+// NewSessionCallback. It has no effect on udp/websocket sessions, whose messages are framed
+// by the transport.
+func (s *session) SetResync(config *ResyncConfig) {
+	if config == nil {
+		s.resync = ResyncConfig{}
+		return
+	}
+
+	s.resync = *config
+	s.resync.Magic = append([]byte(nil), config.Magic...)
+}
@@ -0,0 +1,112 @@
+package getty
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// every frame is "$line\n"
+type magicLineCodec struct{}
+
+func (c magicLineCodec) Read(ss Session, data []byte) (interface{}, int, error) {
+	if data[0] != '$' {
+		return nil, 0, errors.New("no magic")
+	}
+	idx := strings.IndexByte(string(data), '\n')
+	if idx < 0 {
+		return nil, 0, nil
+	}
+
+	return string(data[1:idx]), idx + 1, nil
+}
+
+func (c magicLineCodec) Write(ss Session, pkg interface{}) ([]byte, error) {
+	return []byte("$" + pkg.(string) + "\n"), nil
+}
+
+func TestResyncDiscard(t *testing.T) {
+	var config ResyncConfig
+	assert.Equal(t, -1, config.discard([]byte("xx")))
+
+	config = ResyncConfig{Strategy: ResyncSkipToMagic}
+	assert.Equal(t, -1, config.discard([]byte("xx")))
+	config.Magic = []byte("$$")
+	assert.Equal(t, 3, config.discard([]byte("abc$$d")))
+	// the broken frame at the head is skipped even if it begins with the magic
+	assert.Equal(t, 1, config.discard([]byte("$$$$")))
+	// the tail may be the prefix of the magic
+	assert.Equal(t, 3, config.discard([]byte("abc$")))
+	assert.Equal(t, 0, config.discard([]byte("$")))
+
+	config = ResyncConfig{Strategy: ResyncDropBytes}
+	assert.Equal(t, 1, config.discard([]byte("abc")))
+	config.DropBytes = 4
+	assert.Equal(t, 4, config.discard([]byte("abcdef")))
+	assert.Equal(t, 3, config.discard([]byte("abc")))
+}
+
+func TestSessionResync(t *testing.T) {
+	newResyncSession := func(config *ResyncConfig) (*session, *lineListener, func(string)) {
+		conn, peer := newTCPPair(t)
+		listener := &lineListener{msgs: make(chan interface{}, 8)}
+
+		clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+		ss := newTCPSession(conn, clt).(*session)
+		ss.SetPkgHandler(magicLineCodec{})
+		ss.SetEventListener(listener)
+		ss.SetResync(config)
+		ss.run()
+		t.Cleanup(func() { ss.Close() })
+
+		return ss, listener, func(data string) {
+			_, err := peer.Write([]byte(data))
+			assert.Nil(t, err)
+		}
+	}
+	closed := func(ss *session) bool {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if ss.IsClosed() {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+
+	// skip to magic
+	ss, listener, write := newResyncSession(&ResyncConfig{Strategy: ResyncSkipToMagic, Magic: []byte("$")})
+	write("garbage$hello\n$world\n")
+	assert.Equal(t, "hello", <-listener.msgs)
+	assert.Equal(t, "world", <-listener.msgs)
+	// the garbage without magic is discarded when it arrives
+	write("noise")
+	write("$foo\n")
+	assert.Equal(t, "foo", <-listener.msgs)
+	assert.False(t, ss.IsClosed())
+	assert.Equal(t, uint64(2), ss.metrics.ResyncNum())
+	assert.Equal(t, uint64(12), ss.metrics.ResyncDiscardedBytes())
+
+	// drop bytes
+	ss, listener, write = newResyncSession(&ResyncConfig{Strategy: ResyncDropBytes, DropBytes: 2})
+	write("xyzw$hello\n")
+	assert.Equal(t, "hello", <-listener.msgs)
+	assert.Equal(t, uint64(2), ss.metrics.ResyncNum())
+
+	// the discarded bytes exceed the limit
+	ss, _, write = newResyncSession(&ResyncConfig{Strategy: ResyncSkipToMagic, Magic: []byte("$"),
+		MaxDiscardBytes: 4})
+	write("garbage$hello\n")
+	assert.True(t, closed(ss))
+
+	// close by default
+	ss, _, write = newResyncSession(nil)
+	assert.Equal(t, ResyncConfig{}, ss.resync)
+	write("garbage$hello\n")
+	assert.True(t, closed(ss))
+}
@@ -0,0 +1,175 @@
+/******************************************************
+# DESC       : dtls transport
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-24 10:30
+# FILE       : dtls.go
+******************************************************/
+
+package getty
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+// The sessions over dtls, i.e. the tls of the udp datagrams, run like the ones of the custom
+// transports, so the datagram traffic is encrypted end to end and the peers are authenticated
+// by their certificates. getty does not depend on any dtls implementation, whose tls.Config-like
+// config is set when the conns are dialed or listened. The conns of pion/dtls implement
+// DTLSConn, and its listener is a net.Listener, e.g.
+//
+//	config := &dtls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool}
+//	dialer := getty.DTLSDialer(func(addr string) (net.Conn, error) {
+//		raddr, err := net.ResolveUDPAddr("udp", addr)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return dtls.Dial("udp", raddr, config)
+//	}, getty.DTLSConfig{HandshakeTimeout: 5 * time.Second})
+//	client := getty.NewTransportClient(dialer, getty.WithServerAddress("10.0.0.8:10000"))
+//
+//	listener, _ := dtls.Listen("udp", &net.UDPAddr{Port: 10000}, config)
+//	server := getty.NewTransportServer(getty.DTLSListener(listener, getty.DTLSConfig{}))
+//
+// The datagrams of the udp sessions of NewUDPClient & NewUDPPEndPoint are not encrypted, whose
+// payloads can be sealed by (DatagramSession)SetDatagramCipher.
+
+const (
+	defaultDTLSHandshakeTimeout = 10 * time.Second
+)
+
+// DTLSConn is a dtls conn whose handshake is run explicitly, e.g. the *dtls.Conn of pion/dtls.
+// The conns which do not implement it are regarded as handshaked when they are dialed or
+// accepted, and they are used as they are.
+type DTLSConn interface {
+	net.Conn
+	HandshakeContext(ctx context.Context) error
+}
+
+// DTLSConfig is the handshake parameters of the dtls conns.
+type DTLSConfig struct {
+	// the timeout of the handshake, after which the conn is closed with ErrHandshakeTimeout.
+	// Its default value is 10s.
+	HandshakeTimeout time.Duration
+}
+
+func (c DTLSConfig) withDefaults() DTLSConfig {
+	if c.HandshakeTimeout <= 0 {
+		c.HandshakeTimeout = defaultDTLSHandshakeTimeout
+	}
+
+	return c
+}
+
+// run the handshake of the dtls conn @conn in @timeout
+func handshakeDTLSConn(conn net.Conn, timeout time.Duration) error {
+	dtlsConn, ok := conn.(DTLSConn)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := dtlsConn.HandshakeContext(ctx); err != nil {
+		// the conn may time out by the deadline of @ctx before @ctx is done
+		if netErr, ok := jerrors.Cause(err).(net.Error); ctx.Err() == context.DeadlineExceeded || ok && netErr.Timeout() {
+			return newGettyError(ErrHandshakeTimeout, err)
+		}
+		return jerrors.Annotatef(err, "dtls handshake(peer:%s)", conn.RemoteAddr())
+	}
+
+	return nil
+}
+
+// DTLSDialer returns the TransportDialer which dials the dtls conns by @dial and runs their
+// handshakes by @config before the sessions are created.
+func DTLSDialer(dial TransportDialer, config DTLSConfig) TransportDialer {
+	if dial == nil {
+		panic("DTLSDialer(dial):@dial is nil")
+	}
+
+	config = config.withDefaults()
+	return func(addr string) (net.Conn, error) {
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, jerrors.Annotatef(err, "dtls dial(addr:%s)", addr)
+		}
+		if err = handshakeDTLSConn(conn, config.HandshakeTimeout); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
+
+// dtlsServerConn runs the handshake of the accepted dtls conn on its first read or write
+// like tls.Conn, so that a slow peer does not block the accept loop.
+type dtlsServerConn struct {
+	net.Conn
+	timeout time.Duration
+
+	once sync.Once
+	err  error
+}
+
+func (c *dtlsServerConn) handshake() error {
+	c.once.Do(func() {
+		if c.err = handshakeDTLSConn(c.Conn, c.timeout); c.err != nil {
+			c.Conn.Close()
+		}
+	})
+
+	return c.err
+}
+
+func (c *dtlsServerConn) Read(b []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(b)
+}
+
+func (c *dtlsServerConn) Write(b []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Write(b)
+}
+
+type dtlsListener struct {
+	net.Listener
+	config DTLSConfig
+}
+
+// DTLSListener wraps the dtls listener @listener, whose accepted conns are handshaked by
+// @config, which is served by NewTransportServer.
+func DTLSListener(listener net.Listener, config DTLSConfig) net.Listener {
+	if listener == nil {
+		panic("DTLSListener(listener):@listener is nil")
+	}
+
+	return &dtlsListener{Listener: listener, config: config.withDefaults()}
+}
+
+func (l *dtlsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := conn.(DTLSConn); !ok {
+		return conn, nil
+	}
+
+	return &dtlsServerConn{Conn: conn, timeout: l.config.HandshakeTimeout}, nil
+}
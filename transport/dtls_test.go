@@ -0,0 +1,172 @@
+package getty
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDTLSConn runs a handshake of one hello byte and one reply byte over a tcp conn
+type fakeDTLSConn struct {
+	net.Conn
+
+	client     bool
+	handshaked chan struct{}
+}
+
+func newFakeDTLSConn(conn net.Conn, client bool) *fakeDTLSConn {
+	return &fakeDTLSConn{Conn: conn, client: client, handshaked: make(chan struct{})}
+}
+
+func (c *fakeDTLSConn) HandshakeContext(ctx context.Context) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.Conn.SetDeadline(deadline)
+		defer c.Conn.SetDeadline(time.Time{})
+	}
+
+	send, expected := []byte{'S'}, byte('C')
+	if c.client {
+		send, expected = []byte{'C'}, 'S'
+		if _, err := c.Conn.Write(send); err != nil {
+			return err
+		}
+	}
+	b := make([]byte, 1)
+	if _, err := c.Conn.Read(b); err != nil {
+		return err
+	}
+	if b[0] != expected {
+		return errors.New("bad hello")
+	}
+	if !c.client {
+		if _, err := c.Conn.Write(send); err != nil {
+			return err
+		}
+	}
+	close(c.handshaked)
+
+	return nil
+}
+
+// blockedDTLSConn never finishes its handshake
+type blockedDTLSConn struct {
+	net.Conn
+}
+
+func (c *blockedDTLSConn) HandshakeContext(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+type fakeDTLSListener struct {
+	net.Listener
+
+	conns chan *fakeDTLSConn
+}
+
+func (l *fakeDTLSListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	dtlsConn := newFakeDTLSConn(conn, false)
+	l.conns <- dtlsConn
+	return dtlsConn, nil
+}
+
+func TestDTLSConfig(t *testing.T) {
+	assert.Equal(t, defaultDTLSHandshakeTimeout, DTLSConfig{}.withDefaults().HandshakeTimeout)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	err := handshakeDTLSConn(&blockedDTLSConn{Conn: c1}, 10*time.Millisecond)
+	assert.True(t, errors.Is(err, ErrHandshakeTimeout))
+	// the conns which are not dtls conns are not handshaked
+	assert.Nil(t, handshakeDTLSConn(c1, 10*time.Millisecond))
+
+	// the conn is closed if its handshake fails
+	dialer := DTLSDialer(func(addr string) (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go c2.Write([]byte{'X'})
+		return newFakeDTLSConn(c1, false), nil
+	}, DTLSConfig{})
+	conn, err := dialer("127.0.0.1:1")
+	assert.Nil(t, conn)
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrHandshakeTimeout))
+
+	// the handshake of an accepted conn is run on its first read
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	listener := DTLSListener(&fakeDTLSListener{Listener: l, conns: make(chan *fakeDTLSConn, 1)},
+		DTLSConfig{HandshakeTimeout: 10 * time.Millisecond})
+	client, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+	conn, err = listener.Accept()
+	assert.Nil(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	assert.True(t, errors.Is(err, ErrHandshakeTimeout))
+	_, err = conn.Write([]byte{'S'})
+	assert.True(t, errors.Is(err, ErrHandshakeTimeout))
+}
+
+func TestDTLSTransport(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	accepted := &fakeDTLSListener{Listener: l, conns: make(chan *fakeDTLSConn, 1)}
+	config := DTLSConfig{HandshakeTimeout: 3 * time.Second}
+
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+	server := NewTransportServer(DTLSListener(accepted, config)).(*server)
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(listener)
+		return nil
+	})
+	defer server.Close()
+
+	dialed := make(chan *fakeDTLSConn, 1)
+	dialer := DTLSDialer(func(addr string) (net.Conn, error) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		dtlsConn := newFakeDTLSConn(conn, true)
+		dialed <- dtlsConn
+		return dtlsConn, nil
+	}, config)
+	client := NewTransportClient(dialer, WithServerAddress(l.Addr().String()), WithConnectionNumber(1)).(*client)
+	client.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(&MessageHandler{})
+		return nil
+	})
+	defer client.Close()
+
+	for _, conn := range []*fakeDTLSConn{<-dialed, <-accepted.conns} {
+		select {
+		case <-conn.handshaked:
+		case <-time.After(3 * time.Second):
+			t.Fatal("not handshaked")
+		}
+	}
+
+	ss := client.connectedSession()
+	assert.NotNil(t, ss)
+	assert.Nil(t, ss.WritePkg("hello", 0))
+	select {
+	case msg := <-listener.msgs:
+		assert.Equal(t, "hello", msg)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message")
+	}
+}
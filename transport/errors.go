@@ -0,0 +1,136 @@
+/******************************************************
+# DESC       : getty errors
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-12 11:20
+# FILE       : errors.go
+******************************************************/
+
+package getty
+
+import (
+	"errors"
+	"net"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+// the errors returned by getty can be checked by errors.Is, e.g. errors.Is(err, ErrWriteTimeout).
+var (
+	ErrSessionClosed    = errors.New("session Already Closed")
+	ErrQueueFull        = errors.New("session write queue is full")
+	ErrWriteTimeout     = errors.New("session write timeout")
+	ErrMsgTooLarge      = errors.New("message too large")
+	ErrHandshakeTimeout = errors.New("handshake timeout")
+	ErrNullPeerAddr     = errors.New("peer address is nil")
+	ErrStateTimeout     = errors.New("protocol state timeout")
+	ErrNotSupported     = errors.New("not supported on this platform")
+	// the compression & encryption negotiation of a tcp session failed
+	ErrNegotiationFailed = errors.New("negotiation failed")
+	// the memory budget of the session is exceeded, see MemoryBudget
+	ErrMemoryLimit = errors.New("memory budget exceeded")
+	// the pkg written by (SessionWriter)WritePkgTTL expired in the write queue
+	ErrPkgExpired = errors.New("pkg expired in write queue")
+	// the session limit of the tenant is reached, see (ResourceGroupMember)JoinResourceGroup
+	ErrResourceGroupLimit = errors.New("resource group session limit reached")
+	// the action is denied by the Policy of the endpoint
+	ErrPolicyDenied = errors.New("denied by policy")
+	// a certificate of the tls endpoint expires within the refuse window, see CertExpiryConfig
+	ErrCertExpiring = errors.New("certificate expiring")
+	// the client is not identified by the IdentityProvider of the server
+	ErrUnauthenticated = errors.New("client unauthenticated")
+	// the audit records are not consecutive in the hash chain, see VerifyAuditRecords
+	ErrAuditChainBroken = errors.New("audit chain broken")
+	// the first pkg of the session does not begin with the magic prefix, see WithServerMagic
+	ErrBadMagic = errors.New("bad protocol magic")
+	// the session fails the validation after the system resumes, see SuspendConfig
+	ErrResumeValidation = errors.New("session invalid after resume")
+	// the encrypted udp datagram is refused by the anti-replay check, see DatagramCipherConfig
+	ErrReplayedDatagram = errors.New("replayed datagram")
+	// the stream is reset by the peer or for a flow control violation, see StreamMux
+	ErrStreamReset = errors.New("stream reset")
+
+	// ErrSessionBlocked keeps its original message, and errors.Is(ErrSessionBlocked, ErrQueueFull)
+	// returns true.
+	//
+	// Deprecated: use ErrQueueFull instead.
+	ErrSessionBlocked error = &gettyError{kind: ErrQueueFull, msg: "session Full Blocked"}
+)
+
+// gettyError binds a detailed cause to a getty error kind. errors.Is(err, kind) returns
+// true and errors.Unwrap(err) returns the cause.
+//
+// Pls attention that github.com/juju/errors does not support errors.Unwrap, so do not
+// Trace/Annotate a gettyError which will be returned to the caller. Use traceError instead.
+type gettyError struct {
+	kind  error
+	cause error
+	// the message which replaces the one of @kind
+	msg string
+}
+
+func newGettyError(kind error, cause error) error {
+	return &gettyError{kind: kind, cause: cause}
+}
+
+func (e *gettyError) Error() string {
+	msg := e.msg
+	if msg == "" {
+		msg = e.kind.Error()
+	}
+	if e.cause == nil {
+		return msg
+	}
+
+	return msg + ": " + e.cause.Error()
+}
+
+func (e *gettyError) Is(target error) bool {
+	return e.kind == target
+}
+
+func (e *gettyError) Unwrap() error {
+	return e.cause
+}
+
+func isGettyError(err error) bool {
+	if _, ok := err.(*gettyError); ok {
+		return true
+	}
+	for _, kind := range []error{ErrSessionClosed, ErrQueueFull, ErrWriteTimeout,
+		ErrMsgTooLarge, ErrHandshakeTimeout, ErrNullPeerAddr, ErrStateTimeout, ErrNotSupported,
+		ErrNegotiationFailed, ErrMemoryLimit, ErrPkgExpired, ErrResourceGroupLimit,
+		ErrPolicyDenied, ErrCertExpiring, ErrUnauthenticated, ErrAuditChainBroken,
+		ErrBadMagic, ErrResumeValidation, ErrReplayedDatagram, ErrStreamReset} {
+		if err == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trace @err if it is not a getty error, to keep errors.Is working for getty errors.
+func traceError(err error) error {
+	if err == nil || isGettyError(err) {
+		return err
+	}
+
+	return jerrors.Trace(err)
+}
+
+// convert the network timeout error @err of a write operation to ErrWriteTimeout.
+func writeError(err error) error {
+	cause := jerrors.Cause(err)
+	if netErr, ok := cause.(net.Error); ok && netErr.Timeout() {
+		return newGettyError(ErrWriteTimeout, err)
+	}
+	if isGettyError(cause) {
+		return cause
+	}
+
+	return jerrors.Trace(err)
+}
@@ -0,0 +1,47 @@
+package getty
+
+import (
+	"errors"
+	"testing"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestGettyError(t *testing.T) {
+	cause := errors.New("pkgLen 2048 > session max message len 1024")
+	err := newGettyError(ErrMsgTooLarge, cause)
+	assert.True(t, errors.Is(err, ErrMsgTooLarge))
+	assert.False(t, errors.Is(err, ErrWriteTimeout))
+	assert.Equal(t, cause, errors.Unwrap(err))
+	assert.Equal(t, "message too large: "+cause.Error(), err.Error())
+
+	assert.Nil(t, traceError(nil))
+	assert.Equal(t, err, traceError(err))
+	assert.Equal(t, ErrSessionClosed, traceError(ErrSessionClosed))
+	assert.True(t, errors.Is(ErrSessionBlocked, ErrQueueFull))
+	assert.Equal(t, "session Full Blocked", ErrSessionBlocked.Error())
+	assert.Equal(t, ErrSessionBlocked, traceError(ErrSessionBlocked))
+	assert.Equal(t, cause, jerrors.Cause(traceError(cause)))
+}
+
+func TestWriteError(t *testing.T) {
+	err := writeError(jerrors.Annotatef(timeoutError{}, "s.Connection.Write(pkg len:%d)", 5))
+	assert.True(t, errors.Is(err, ErrWriteTimeout))
+
+	err = writeError(jerrors.Trace(ErrNullPeerAddr))
+	assert.True(t, errors.Is(err, ErrNullPeerAddr))
+
+	cause := errors.New("broken pipe")
+	err = writeError(cause)
+	assert.False(t, errors.Is(err, ErrWriteTimeout))
+	assert.Equal(t, cause, jerrors.Cause(err))
+}
@@ -0,0 +1,154 @@
+/******************************************************
+# DESC       : tcp client falling back to wss
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-03 15:30
+# FILE       : fallback.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	"github.com/dubbogo/gost/net"
+	"github.com/gorilla/websocket"
+	jerrors "github.com/juju/errors"
+)
+
+const defaultFallbackRetryInterval = 10 * time.Minute
+
+// FallbackConfig is the transport fallback config of a tcp client for the restrictive
+// networks. The client tries tcp first and falls back to the wss url if tcp is blocked.
+// The wss server should serve the same codec as the tcp server, and every websocket
+// message carries the whole pkgs written by the codec.
+type FallbackConfig struct {
+	// the wss url of the server, e.g. wss://host:443/path
+	URL string
+	// tls config of the wss url. if it is nil, the default tls config is used.
+	TLSConfig *tls.Config
+	// the tcp connect timeout before falling back. Its default value is 3s.
+	TCPTimeout time.Duration
+	// the interval to try tcp again after falling back to wss. The working transport of a
+	// destination is remembered by the process until then. Its default value is 10m.
+	RetryInterval time.Duration
+}
+
+func (c FallbackConfig) withDefaults() FallbackConfig {
+	if c.TCPTimeout <= 0 {
+		c.TCPTimeout = connectTimeout
+	}
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = defaultFallbackRetryInterval
+	}
+
+	return c
+}
+
+var (
+	fallbackLock sync.Mutex
+	// tcp server address -> the time to try tcp again since its wss fallback works
+	fallbackDestinations = make(map[string]time.Time)
+)
+
+// check whether the tcp dial of @addr should be skipped for its wss fallback works
+func fallbackPreferred(addr string, now time.Time) bool {
+	fallbackLock.Lock()
+	defer fallbackLock.Unlock()
+
+	retry, ok := fallbackDestinations[addr]
+	if ok && !now.Before(retry) {
+		delete(fallbackDestinations, addr)
+		return false
+	}
+	return ok
+}
+
+// remember the working transport of @addr. @retry is zero if tcp works.
+func rememberTransport(addr string, retry time.Time) {
+	fallbackLock.Lock()
+	if retry.IsZero() {
+		delete(fallbackDestinations, addr)
+	} else {
+		fallbackDestinations[addr] = retry
+	}
+	fallbackLock.Unlock()
+}
+
+func (c *client) dialTCPConn(timeout time.Duration) (Session, error) {
+	conn, err := net.DialTimeout("tcp", c.addr, timeout)
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	if gxnet.IsSameAddr(conn.RemoteAddr(), conn.LocalAddr()) {
+		conn.Close()
+		return nil, errSelfConnect
+	}
+
+	ss, err := newNegotiatedTCPSession(conn, c, c.negotiation, true)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ss, nil
+}
+
+func (c *client) dialFallbackWSS(config FallbackConfig) (Session, error) {
+	dialer := websocket.Dialer{
+		EnableCompression: true,
+		HandshakeTimeout:  c.handshakeTimeout,
+		TLSClientConfig:   withKeyLog(c.userTLSConfig(config.TLSConfig), c.keyLog),
+	}
+	conn, _, err := dialer.Dial(config.URL, nil)
+	if err != nil {
+		return nil, handshakeError(err)
+	}
+	if gxnet.IsSameAddr(conn.RemoteAddr(), conn.LocalAddr()) {
+		conn.Close()
+		return nil, errSelfConnect
+	}
+
+	ss := newWSSession(conn, c)
+	ss.SetName(defaultWSSSessionName)
+	return ss, nil
+}
+
+// dial tcp first, and fall back to wss if tcp fails. The wss is dialed at once if it
+// works for the server address before.
+func (c *client) dialWithFallback() Session {
+	config := c.fallback.withDefaults()
+	for {
+		if c.IsClosed() {
+			return nil
+		}
+
+		if !fallbackPreferred(c.addr, time.Now()) {
+			ss, err := c.dialTCPConn(config.TCPTimeout)
+			if err == nil {
+				rememberTransport(c.addr, time.Time{})
+				return ss
+			}
+			log.Info("client.dialTCPConn(addr:%s, timeout:%s) = error{%s}, fall back to %s",
+				c.addr, config.TCPTimeout, jerrors.ErrorStack(err), config.URL)
+			c.onDialError(c.addr, err)
+		}
+
+		ss, err := c.dialFallbackWSS(config)
+		if err == nil {
+			rememberTransport(c.addr, time.Now().Add(config.RetryInterval))
+			return ss
+		}
+		log.Info("client.dialFallbackWSS(url:%s) = error{%s}", config.URL, jerrors.ErrorStack(err))
+		c.onDialError(config.URL, err)
+		// try tcp again for the fallback does not work either
+		rememberTransport(c.addr, time.Time{})
+		<-wheel.After(connectInterval)
+	}
+}
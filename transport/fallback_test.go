@@ -0,0 +1,76 @@
+package getty
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackDestinations(t *testing.T) {
+	addr := "fallback.test:80"
+	now := time.Now()
+	assert.False(t, fallbackPreferred(addr, now))
+
+	rememberTransport(addr, now.Add(time.Minute))
+	assert.True(t, fallbackPreferred(addr, now))
+	// try tcp again after the retry interval
+	assert.False(t, fallbackPreferred(addr, now.Add(time.Minute)))
+	assert.False(t, fallbackPreferred(addr, now))
+
+	rememberTransport(addr, now.Add(time.Minute))
+	rememberTransport(addr, time.Time{})
+	assert.False(t, fallbackPreferred(addr, now))
+}
+
+func TestClientTransportFallback(t *testing.T) {
+	// tcp is blocked
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	defer rememberTransport(addr, time.Time{})
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	var dialErrors []string
+	clt := newClient(TCP_CLIENT,
+		WithServerAddress(addr),
+		WithConnectionNumber(1),
+		WithDialErrorHandler(func(addr string, err error) { dialErrors = append(dialErrors, addr) }),
+		WithTransportFallback(&FallbackConfig{
+			URL:        "wss" + strings.TrimPrefix(srv.URL, "https"),
+			TLSConfig:  &tls.Config{InsecureSkipVerify: true},
+			TCPTimeout: 100 * time.Millisecond,
+		}),
+	)
+	defer clt.Close()
+
+	ss := clt.dial()
+	assert.NotNil(t, ss)
+	_, ok := ss.(*session).Connection.(*gettyWSConn)
+	assert.True(t, ok)
+	assert.Equal(t, []string{addr}, dialErrors)
+	assert.True(t, fallbackPreferred(addr, time.Now()))
+	ss.(*session).Connection.close(0)
+
+	// the working wss is dialed at once
+	ss = clt.dial()
+	assert.NotNil(t, ss)
+	assert.Equal(t, 1, len(dialErrors))
+	ss.(*session).Connection.close(0)
+}
@@ -0,0 +1,478 @@
+/******************************************************
+# DESC       : udp forward error correction
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-19 15:30
+# FILE       : fec.go
+******************************************************/
+
+package getty
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	// magic(1B) + group id(4B) + shard index(1B) + data shard number(1B) + parity shard number(1B)
+	fecHeaderLen = 8
+	fecMagic     = 0xF8
+	// every shard is prefixed with its length(2B) when the parity shards are computed
+	fecShardLenSize = 2
+
+	defaultFECDataShards   = 4
+	defaultFECParityShards = 2
+	defaultFECGroupTimeout = time.Second
+	maxFECShards           = 255
+)
+
+var (
+	errIllegalFECShard = jerrors.New("illegal udp fec shard")
+)
+
+// FECConfig is the udp forward error correction config of a session, which is set by
+// (DatagramSession)SetFEC. Every DataShards datagrams sent to the same peer make up a group,
+// and ParityShards Reed-Solomon parity datagrams are sent after the group, so the
+// receiver can recover the lost datagrams if it gets any DataShards datagrams of the group.
+// Both peers should enable the fec with the same config.
+type FECConfig struct {
+	// the data shard number of a group. Its default value is 4.
+	DataShards int
+	// the parity shard number of a group. Its default value is 2.
+	ParityShards int
+	// the shards of a group are dropped after it. Its default value is 1s.
+	GroupTimeout time.Duration
+}
+
+func (c FECConfig) withDefaults() FECConfig {
+	if c.DataShards <= 0 {
+		c.DataShards = defaultFECDataShards
+	}
+	if c.ParityShards <= 0 {
+		c.ParityShards = defaultFECParityShards
+	}
+	if c.GroupTimeout <= 0 {
+		c.GroupTimeout = defaultFECGroupTimeout
+	}
+	if maxFECShards < c.DataShards+c.ParityShards {
+		panic(fmt.Sprintf("illegal fec shards {data:%d, parity:%d}", c.DataShards, c.ParityShards))
+	}
+
+	return c
+}
+
+/////////////////////////////////////////
+// Reed-Solomon code over GF(2^8)
+/////////////////////////////////////////
+
+var gfExp, gfLog = newGFTables()
+
+func newGFTables() ([512]byte, [256]byte) {
+	var (
+		exp [512]byte
+		log [256]byte
+	)
+
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(exp); i++ {
+		exp[i] = exp[i-255]
+	}
+
+	return exp, log
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// @a should not be 0
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// dst ^= c * src
+func gfMulAdd(dst []byte, c byte, src []byte) {
+	if c == 0 {
+		return
+	}
+	logC := int(gfLog[c])
+	for i, b := range src {
+		if b != 0 {
+			dst[i] ^= gfExp[logC+int(gfLog[b])]
+		}
+	}
+}
+
+// the encoding row of shard @idx. The rows of the data shards make up an identity matrix,
+// and the rows of the parity shards make up a Cauchy matrix, so any @dataShards rows are
+// linearly independent.
+func fecRow(idx int, dataShards int) []byte {
+	row := make([]byte, dataShards)
+	if idx < dataShards {
+		row[idx] = 1
+		return row
+	}
+	for j := range row {
+		row[j] = gfInv(byte(idx) ^ byte(j))
+	}
+	return row
+}
+
+// invert the square matrix @m by Gauss-Jordan elimination. @m is modified.
+func gfInvertMatrix(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	inv := make([][]byte, n)
+	for i := range inv {
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		pivot := c
+		for pivot < n && m[pivot][c] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, jerrors.New("singular fec matrix")
+		}
+		m[c], m[pivot] = m[pivot], m[c]
+		inv[c], inv[pivot] = inv[pivot], inv[c]
+
+		if m[c][c] != 1 {
+			f := gfInv(m[c][c])
+			for j := 0; j < n; j++ {
+				m[c][j] = gfMul(m[c][j], f)
+				inv[c][j] = gfMul(inv[c][j], f)
+			}
+		}
+		for r := 0; r < n; r++ {
+			if r != c && m[r][c] != 0 {
+				f := m[r][c]
+				gfMulAdd(m[r], f, m[c])
+				gfMulAdd(inv[r], f, inv[c])
+			}
+		}
+	}
+
+	return inv, nil
+}
+
+// prefix @datagram with its length and pad it to @size
+func fecShard(datagram []byte, size int) []byte {
+	shard := make([]byte, size)
+	binary.BigEndian.PutUint16(shard, uint16(len(datagram)))
+	copy(shard[fecShardLenSize:], datagram)
+	return shard
+}
+
+func fecEncodeHeader(b []byte, group uint32, idx, dataShards, parityShards int) {
+	b[0] = fecMagic
+	binary.BigEndian.PutUint32(b[1:], group)
+	b[5] = byte(idx)
+	b[6] = byte(dataShards)
+	b[7] = byte(parityShards)
+}
+
+/////////////////////////////////////////
+// fec encoder
+/////////////////////////////////////////
+
+type fecEncoderGroup struct {
+	id         uint32
+	datagrams  [][]byte
+	lastActive time.Time
+}
+
+// fecEncoder groups the datagrams sent to every peer
+type fecEncoder struct {
+	sync.Mutex
+	config    FECConfig
+	groupID   uint32
+	groups    map[string]*fecEncoderGroup
+	lastSweep time.Time
+}
+
+func newFECEncoder(config FECConfig) *fecEncoder {
+	return &fecEncoder{
+		config: config,
+		groups: make(map[string]*fecEncoderGroup),
+	}
+}
+
+// add the fec header to @datagrams, and append the parity datagrams of the completed groups.
+func (e *fecEncoder) encode(peer string, datagrams [][]byte, now time.Time) [][]byte {
+	e.Lock()
+	defer e.Unlock()
+
+	e.sweep(now)
+	g, ok := e.groups[peer]
+	if !ok {
+		e.groupID++
+		g = &fecEncoderGroup{id: e.groupID, datagrams: make([][]byte, 0, e.config.DataShards)}
+		e.groups[peer] = g
+	}
+	g.lastActive = now
+
+	out := make([][]byte, 0, len(datagrams))
+	for _, datagram := range datagrams {
+		b := make([]byte, fecHeaderLen+len(datagram))
+		fecEncodeHeader(b, g.id, len(g.datagrams), e.config.DataShards, e.config.ParityShards)
+		copy(b[fecHeaderLen:], datagram)
+		out = append(out, b)
+		g.datagrams = append(g.datagrams, b[fecHeaderLen:])
+		if len(g.datagrams) < e.config.DataShards {
+			continue
+		}
+
+		out = append(out, e.parity(g)...)
+		e.groupID++
+		g.id = e.groupID
+		g.datagrams = g.datagrams[:0]
+	}
+
+	return out
+}
+
+// compute the parity datagrams of the full group @g
+func (e *fecEncoder) parity(g *fecEncoderGroup) [][]byte {
+	size := 0
+	for _, datagram := range g.datagrams {
+		if size < len(datagram) {
+			size = len(datagram)
+		}
+	}
+	size += fecShardLenSize
+
+	shards := make([][]byte, len(g.datagrams))
+	for i, datagram := range g.datagrams {
+		shards[i] = fecShard(datagram, size)
+	}
+	parities := make([][]byte, e.config.ParityShards)
+	for i := range parities {
+		idx := e.config.DataShards + i
+		b := make([]byte, fecHeaderLen+size)
+		fecEncodeHeader(b, g.id, idx, e.config.DataShards, e.config.ParityShards)
+		row := fecRow(idx, e.config.DataShards)
+		for j, shard := range shards {
+			gfMulAdd(b[fecHeaderLen:], row[j], shard)
+		}
+		parities[i] = b
+	}
+
+	return parities
+}
+
+// drop the partial groups of the idle peers
+func (e *fecEncoder) sweep(now time.Time) {
+	if now.Sub(e.lastSweep) < e.config.GroupTimeout {
+		return
+	}
+	e.lastSweep = now
+	for peer, g := range e.groups {
+		if now.Sub(g.lastActive) > e.config.GroupTimeout {
+			delete(e.groups, peer)
+		}
+	}
+}
+
+/////////////////////////////////////////
+// fec decoder
+/////////////////////////////////////////
+
+type fecGroupKey struct {
+	peer string
+	id   uint32
+}
+
+type fecDecoderGroup struct {
+	dataShards   int
+	parityShards int
+	// data datagrams followed by parity shards
+	shards   [][]byte
+	received int
+	// all the data datagrams have been delivered
+	done     bool
+	deadline time.Time
+}
+
+// fecDecoder is only used by the read goroutine of the session
+type fecDecoder struct {
+	config    FECConfig
+	groups    map[fecGroupKey]*fecDecoderGroup
+	lastSweep time.Time
+}
+
+func newFECDecoder(config FECConfig) *fecDecoder {
+	return &fecDecoder{
+		config: config,
+		groups: make(map[fecGroupKey]*fecDecoderGroup),
+	}
+}
+
+// add the datagram @data from @peer. It returns the data datagrams which are received or
+// recovered for the first time.
+func (d *fecDecoder) add(peer string, data []byte, now time.Time) ([][]byte, error) {
+	if len(data) < fecHeaderLen || data[0] != fecMagic {
+		return nil, errIllegalFECShard
+	}
+	id := binary.BigEndian.Uint32(data[1:])
+	idx := int(data[5])
+	dataShards := int(data[6])
+	parityShards := int(data[7])
+	if dataShards == 0 || dataShards+parityShards <= idx {
+		return nil, errIllegalFECShard
+	}
+	payload := data[fecHeaderLen:]
+
+	d.sweep(now)
+	key := fecGroupKey{peer: peer, id: id}
+	g, ok := d.groups[key]
+	if !ok {
+		g = &fecDecoderGroup{
+			dataShards:   dataShards,
+			parityShards: parityShards,
+			shards:       make([][]byte, dataShards+parityShards),
+			deadline:     now.Add(d.config.GroupTimeout),
+		}
+		d.groups[key] = g
+	}
+	if g.dataShards != dataShards || g.parityShards != parityShards {
+		return nil, errIllegalFECShard
+	}
+	if g.done || g.shards[idx] != nil {
+		// duplicate or late shard
+		return nil, nil
+	}
+
+	var out [][]byte
+	if idx < dataShards {
+		// an empty data datagram is received too, so its shard should not be nil
+		g.shards[idx] = append(make([]byte, 0, len(payload)), payload...)
+		out = append(out, g.shards[idx])
+	} else {
+		if len(payload) < fecShardLenSize {
+			return nil, errIllegalFECShard
+		}
+		for _, shard := range g.shards[dataShards:] {
+			if shard != nil && len(shard) != len(payload) {
+				return nil, errIllegalFECShard
+			}
+		}
+		g.shards[idx] = append([]byte(nil), payload...)
+	}
+	g.received++
+
+	delivered := 0
+	for _, shard := range g.shards[:dataShards] {
+		if shard != nil {
+			delivered++
+		}
+	}
+	switch {
+	case delivered == dataShards:
+		g.finish()
+	case dataShards <= g.received:
+		recovered, err := g.recover()
+		g.finish()
+		if err != nil {
+			return out, err
+		}
+		out = append(out, recovered...)
+	}
+
+	return out, nil
+}
+
+// recover the lost data datagrams of @g by its parity shards
+func (g *fecDecoderGroup) recover() ([][]byte, error) {
+	var size int
+	for _, shard := range g.shards[g.dataShards:] {
+		if shard != nil {
+			size = len(shard)
+			break
+		}
+	}
+	if size < fecShardLenSize {
+		return nil, errIllegalFECShard
+	}
+
+	var (
+		rows   [][]byte
+		shards [][]byte
+	)
+	for idx, shard := range g.shards {
+		if shard == nil {
+			continue
+		}
+		if idx < g.dataShards {
+			if size < len(shard)+fecShardLenSize {
+				return nil, errIllegalFECShard
+			}
+			shard = fecShard(shard, size)
+		}
+		rows = append(rows, fecRow(idx, g.dataShards))
+		shards = append(shards, shard)
+		if len(rows) == g.dataShards {
+			break
+		}
+	}
+
+	inv, err := gfInvertMatrix(rows)
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	var recovered [][]byte
+	for j := 0; j < g.dataShards; j++ {
+		if g.shards[j] != nil {
+			continue
+		}
+		shard := make([]byte, size)
+		for r, src := range shards {
+			gfMulAdd(shard, inv[j][r], src)
+		}
+		length := int(binary.BigEndian.Uint16(shard))
+		if size < fecShardLenSize+length {
+			return recovered, errIllegalFECShard
+		}
+		recovered = append(recovered, shard[fecShardLenSize:fecShardLenSize+length])
+	}
+
+	return recovered, nil
+}
+
+// release the shards of @g, and keep it until its deadline to drop the late shards
+func (g *fecDecoderGroup) finish() {
+	g.done = true
+	g.shards = nil
+}
+
+// drop the groups whose timeout expires
+func (d *fecDecoder) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.config.GroupTimeout>>1 {
+		return
+	}
+	d.lastSweep = now
+	for key, g := range d.groups {
+		if g.deadline.Before(now) {
+			delete(d.groups, key)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package getty
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFECRecover(t *testing.T) {
+	config := FECConfig{DataShards: 4, ParityShards: 2}.withDefaults()
+	assert.Equal(t, defaultFECGroupTimeout, config.GroupTimeout)
+	assert.Panics(t, func() { FECConfig{DataShards: 200, ParityShards: 100}.withDefaults() })
+
+	msgs := make([][]byte, 4)
+	for i := range msgs {
+		msgs[i] = bytes.Repeat([]byte(fmt.Sprintf("%d", i)), 10*(i+1))
+	}
+	now := time.Now()
+	enc := newFECEncoder(config)
+	datagrams := enc.encode("peer", msgs[:3], now)
+	assert.Equal(t, 3, len(datagrams))
+	datagrams = append(datagrams, enc.encode("peer", msgs[3:], now)...)
+	assert.Equal(t, 6, len(datagrams))
+	// next group
+	assert.Equal(t, 1, len(enc.encode("peer", msgs[:1], now)))
+
+	// lose any two datagrams of the group
+	for i := 0; i < len(datagrams); i++ {
+		for j := i + 1; j < len(datagrams); j++ {
+			dec := newFECDecoder(config)
+			var got [][]byte
+			for k, datagram := range datagrams {
+				if k == i || k == j {
+					continue
+				}
+				out, err := dec.add("peer", datagram, now)
+				assert.Nil(t, err)
+				got = append(got, out...)
+				// duplicate
+				out, err = dec.add("peer", datagram, now)
+				assert.Nil(t, err)
+				assert.Nil(t, out)
+			}
+			assert.Equal(t, len(msgs), len(got), "lost %d & %d", i, j)
+			for _, msg := range msgs {
+				assert.Contains(t, got, msg)
+			}
+		}
+	}
+
+	// lose three datagrams
+	dec := newFECDecoder(config)
+	var got [][]byte
+	for _, datagram := range datagrams[3:] {
+		out, err := dec.add("peer", datagram, now)
+		assert.Nil(t, err)
+		got = append(got, out...)
+	}
+	assert.Equal(t, [][]byte{msgs[3]}, got)
+
+	_, err := dec.add("peer", []byte("hello"), now)
+	assert.Equal(t, errIllegalFECShard, err)
+}
+
+func TestUDPSessionFEC(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer peer.Close()
+	local, err := net.DialUDP("udp", nil, peer.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer local.Close()
+
+	ss := newUDPSession(local, newClient(UDP_CLIENT, WithServerAddress(peer.LocalAddr().String()), WithConnectionNumber(1)))
+	ss.(DatagramSession).SetFEC(&FECConfig{DataShards: 2, ParityShards: 1})
+	ss.(DatagramSession).SetFragmentation(&FragmentConfig{MTU: 64})
+	conn := ss.(*session).Connection.(*gettyUDPConn)
+	assert.Equal(t, 64, conn.fragment.MTU)
+	msg := bytes.Repeat([]byte("x"), 200)
+	_, err = conn.send(UDPContext{Pkg: msg})
+	assert.Nil(t, err)
+
+	// 4 fragments & 2 parity datagrams, and the first fragment is lost
+	buf := make([]byte, 1500)
+	dec := newFECDecoder(conn.fec.config)
+	r := newReassembler(*conn.fragment)
+	var got []byte
+	for i := 0; i < 6; i++ {
+		n, addr, err := peer.ReadFromUDP(buf)
+		assert.Nil(t, err)
+		assert.True(t, n <= 64+fecHeaderLen+fecShardLenSize)
+		if i == 0 {
+			continue
+		}
+		datagrams, err := dec.add(addr.String(), buf[:n], time.Now())
+		assert.Nil(t, err)
+		for _, datagram := range datagrams {
+			data, err := r.add(addr.String(), datagram, time.Now())
+			assert.Nil(t, err)
+			if data != nil {
+				got = data
+			}
+		}
+	}
+	assert.Equal(t, msg, got)
+}
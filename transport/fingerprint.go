@@ -0,0 +1,481 @@
+/******************************************************
+# DESC       : JA3/JA4 fingerprints of tls clients
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-11 10:30
+# FILE       : fingerprint.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	// the content type of the handshake record, i.e. the first byte of a tls connection
+	tlsRecordTypeHandshake    = 0x16
+	tlsRecordHeaderLen        = 5
+	tlsHandshakeHeaderLen     = 4
+	tlsHandshakeClientHello   = 1
+	maxClientHelloRecordBytes = 64 * 1024
+
+	tlsExtServerName          = 0x0000
+	tlsExtSupportedGroups     = 0x000a
+	tlsExtECPointFormats      = 0x000b
+	tlsExtSignatureAlgorithms = 0x000d
+	tlsExtALPN                = 0x0010
+	tlsExtSupportedVersions   = 0x002b
+)
+
+var errMalformedClientHello = jerrors.New("malformed tls client hello")
+
+// TLSFingerprint is the fingerprint of the ClientHello of a tls client, which identifies the
+// tls stack of the client, e.g. a browser, a library or a bot, no matter what it claims.
+type TLSFingerprint struct {
+	// the JA3 string, i.e. version,ciphers,extensions,curves,point formats, and its md5 hash
+	JA3String string
+	JA3       string
+	// the JA4 fingerprint, e.g. t13d1516h2_8daaf6152771_b186095e22b6
+	JA4        string
+	ServerName string
+	ALPN       []string
+}
+
+func (f TLSFingerprint) String() string {
+	return fmt.Sprintf("{ja3:%s, ja4:%s, sni:%s}", f.JA3, f.JA4, f.ServerName)
+}
+
+type clientHello struct {
+	version           uint16
+	cipherSuites      []uint16
+	extensions        []uint16
+	curves            []uint16
+	pointFormats      []uint8
+	signatureAlgs     []uint16
+	supportedVersions []uint16
+	serverName        string
+	alpn              []string
+}
+
+// the reserved GREASE values, see rfc 8701
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+type helloReader []byte
+
+func (r *helloReader) bytes(n int) ([]byte, bool) {
+	if n < 0 || len(*r) < n {
+		return nil, false
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b, true
+}
+
+func (r *helloReader) uint8() (uint8, bool) {
+	b, ok := r.bytes(1)
+	if !ok {
+		return 0, false
+	}
+	return b[0], true
+}
+
+func (r *helloReader) uint16() (uint16, bool) {
+	b, ok := r.bytes(2)
+	if !ok {
+		return 0, false
+	}
+	return uint16(b[0])<<8 | uint16(b[1]), true
+}
+
+// read a vector whose length is encoded in @lenBytes bytes
+func (r *helloReader) vector(lenBytes int) (helloReader, bool) {
+	b, ok := r.bytes(lenBytes)
+	if !ok {
+		return nil, false
+	}
+	n := 0
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	v, ok := r.bytes(n)
+	return helloReader(v), ok
+}
+
+func (r *helloReader) uint16s(lenBytes int) ([]uint16, bool) {
+	v, ok := r.vector(lenBytes)
+	if !ok || len(v)%2 != 0 {
+		return nil, false
+	}
+	var s []uint16
+	for len(v) > 0 {
+		u, _ := v.uint16()
+		s = append(s, u)
+	}
+	return s, true
+}
+
+// parse the body of a ClientHello handshake message
+func parseClientHello(body []byte) (*clientHello, error) {
+	var (
+		ok    bool
+		hello clientHello
+		r     = helloReader(body)
+	)
+	if hello.version, ok = r.uint16(); !ok {
+		return nil, errMalformedClientHello
+	}
+	if _, ok = r.bytes(32); !ok { // random
+		return nil, errMalformedClientHello
+	}
+	if _, ok = r.vector(1); !ok { // session id
+		return nil, errMalformedClientHello
+	}
+	if hello.cipherSuites, ok = r.uint16s(2); !ok {
+		return nil, errMalformedClientHello
+	}
+	if _, ok = r.vector(1); !ok { // compression methods
+		return nil, errMalformedClientHello
+	}
+	if len(r) == 0 {
+		return &hello, nil
+	}
+
+	exts, ok := r.vector(2)
+	if !ok {
+		return nil, errMalformedClientHello
+	}
+	for len(exts) > 0 {
+		typ, ok := exts.uint16()
+		if !ok {
+			return nil, errMalformedClientHello
+		}
+		data, ok := exts.vector(2)
+		if !ok {
+			return nil, errMalformedClientHello
+		}
+		hello.extensions = append(hello.extensions, typ)
+
+		switch typ {
+		case tlsExtServerName:
+			names, _ := data.vector(2)
+			for len(names) > 0 {
+				nameType, _ := names.uint8()
+				name, ok := names.vector(2)
+				if !ok {
+					return nil, errMalformedClientHello
+				}
+				if nameType == 0 {
+					hello.serverName = string(name)
+				}
+			}
+		case tlsExtSupportedGroups:
+			hello.curves, ok = data.uint16s(2)
+		case tlsExtECPointFormats:
+			var formats helloReader
+			formats, ok = data.vector(1)
+			hello.pointFormats = formats
+		case tlsExtSignatureAlgorithms:
+			hello.signatureAlgs, ok = data.uint16s(2)
+		case tlsExtALPN:
+			protos, _ := data.vector(2)
+			for len(protos) > 0 {
+				proto, ok := protos.vector(1)
+				if !ok {
+					return nil, errMalformedClientHello
+				}
+				hello.alpn = append(hello.alpn, string(proto))
+			}
+		case tlsExtSupportedVersions:
+			hello.supportedVersions, ok = data.uint16s(1)
+		}
+		if !ok {
+			return nil, errMalformedClientHello
+		}
+	}
+
+	return &hello, nil
+}
+
+func joinUint16s(s []uint16, sep string, format func(uint16) string) string {
+	var parts []string
+	for _, v := range s {
+		if !isGREASE(v) {
+			parts = append(parts, format(v))
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+func decimal(v uint16) string {
+	return strconv.Itoa(int(v))
+}
+
+func hex4(v uint16) string {
+	return fmt.Sprintf("%04x", v)
+}
+
+// the JA3 string of the hello, see https://github.com/salesforce/ja3
+func (h *clientHello) ja3() string {
+	points := make([]uint16, len(h.pointFormats))
+	for i, p := range h.pointFormats {
+		points[i] = uint16(p)
+	}
+
+	return strings.Join([]string{
+		decimal(h.version),
+		joinUint16s(h.cipherSuites, "-", decimal),
+		joinUint16s(h.extensions, "-", decimal),
+		joinUint16s(h.curves, "-", decimal),
+		joinUint16s(points, "-", decimal),
+	}, ",")
+}
+
+func ja4Version(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	}
+	return "00"
+}
+
+func isAlphanumeric(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// the first 12 hex characters of the sha256 hash of @s, or 000000000000 if @s is empty
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func sortedNonGREASE(s []uint16, skip ...uint16) []uint16 {
+	var sorted []uint16
+	for _, v := range s {
+		if isGREASE(v) {
+			continue
+		}
+		skipped := false
+		for _, k := range skip {
+			if v == k {
+				skipped = true
+				break
+			}
+		}
+		if !skipped {
+			sorted = append(sorted, v)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// the JA4 fingerprint of the hello over tcp, see https://github.com/FoxIO-LLC/ja4
+func (h *clientHello) ja4() string {
+	version := h.version
+	for _, v := range h.supportedVersions {
+		if !isGREASE(v) && v > version {
+			version = v
+		}
+	}
+	sni := "i"
+	if h.serverName != "" {
+		sni = "d"
+	}
+	ciphers := sortedNonGREASE(h.cipherSuites)
+	extNum := len(sortedNonGREASE(h.extensions))
+	if extNum > 99 {
+		extNum = 99
+	}
+	cipherNum := len(ciphers)
+	if cipherNum > 99 {
+		cipherNum = 99
+	}
+	alpn := "00"
+	if len(h.alpn) > 0 && h.alpn[0] != "" {
+		p := h.alpn[0]
+		if isAlphanumeric(p[0]) && isAlphanumeric(p[len(p)-1]) {
+			alpn = string([]byte{p[0], p[len(p)-1]})
+		} else {
+			x := hex.EncodeToString([]byte(p))
+			alpn = string([]byte{x[0], x[len(x)-1]})
+		}
+	}
+
+	exts := joinUint16s(sortedNonGREASE(h.extensions, tlsExtServerName, tlsExtALPN), ",", hex4)
+	if exts != "" && len(h.signatureAlgs) > 0 {
+		exts += "_" + joinUint16s(h.signatureAlgs, ",", hex4)
+	}
+
+	return fmt.Sprintf("t%s%s%02d%02d%s_%s_%s", ja4Version(version), sni, cipherNum, extNum, alpn,
+		ja4Hash(joinUint16s(ciphers, ",", hex4)), ja4Hash(exts))
+}
+
+func (h *clientHello) fingerprint() *TLSFingerprint {
+	ja3 := h.ja3()
+	sum := md5.Sum([]byte(ja3))
+	return &TLSFingerprint{
+		JA3String:  ja3,
+		JA3:        hex.EncodeToString(sum[:]),
+		JA4:        h.ja4(),
+		ServerName: h.serverName,
+		ALPN:       h.alpn,
+	}
+}
+
+/////////////////////////////////////////
+// ClientHello capture
+/////////////////////////////////////////
+
+// helloConn captures the ClientHello of the tls connection read through it
+type helloConn struct {
+	net.Conn
+
+	// the bytes of the records & the handshake messages read so far, which are only
+	// accessed by the reading goroutine, i.e. the tls handshake
+	records   []byte
+	handshake []byte
+	captured  bool
+
+	lock sync.Mutex
+	fp   *TLSFingerprint
+}
+
+func (c *helloConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && !c.captured {
+		c.capture(p[:n])
+	}
+
+	return n, err
+}
+
+// reassemble the ClientHello from the handshake records @p
+func (c *helloConn) capture(p []byte) {
+	c.records = append(c.records, p...)
+	for len(c.records) >= tlsRecordHeaderLen {
+		if c.records[0] != tlsRecordTypeHandshake {
+			c.stopCapture(nil)
+			return
+		}
+		n := tlsRecordHeaderLen + (int(c.records[3])<<8 | int(c.records[4]))
+		if len(c.records) < n {
+			break
+		}
+		c.handshake = append(c.handshake, c.records[tlsRecordHeaderLen:n]...)
+		c.records = c.records[n:]
+		if c.parseHandshake() {
+			return
+		}
+	}
+
+	if len(c.records)+len(c.handshake) > maxClientHelloRecordBytes {
+		c.stopCapture(nil)
+	}
+}
+
+// parse the ClientHello if the handshake message is complete. It returns true if the
+// capture is over.
+func (c *helloConn) parseHandshake() bool {
+	if len(c.handshake) < tlsHandshakeHeaderLen {
+		return false
+	}
+	if c.handshake[0] != tlsHandshakeClientHello {
+		c.stopCapture(nil)
+		return true
+	}
+	n := tlsHandshakeHeaderLen + (int(c.handshake[1])<<16 | int(c.handshake[2])<<8 | int(c.handshake[3]))
+	if len(c.handshake) < n {
+		return false
+	}
+
+	hello, err := parseClientHello(c.handshake[tlsHandshakeHeaderLen:n])
+	if err != nil {
+		c.stopCapture(nil)
+		return true
+	}
+	c.stopCapture(hello.fingerprint())
+	return true
+}
+
+func (c *helloConn) stopCapture(fp *TLSFingerprint) {
+	c.captured = true
+	c.records, c.handshake = nil, nil
+	c.lock.Lock()
+	c.fp = fp
+	c.lock.Unlock()
+}
+
+func (c *helloConn) fingerprint() *TLSFingerprint {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.fp
+}
+
+// fingerprintListener captures the ClientHellos of the accepted connections
+type fingerprintListener struct {
+	net.Listener
+}
+
+func (l fingerprintListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &helloConn{Conn: conn}, nil
+}
+
+// TLSFingerprint returns the fingerprint of the ClientHello of the wss session accepted by
+// the server with WithServerTLSFingerprint, or nil.
+func (s *session) TLSFingerprint() *TLSFingerprint {
+	if c, ok := s.Connection.(*gettyWSConn); ok {
+		return connTLSFingerprint(c.conn.UnderlyingConn())
+	}
+
+	return nil
+}
+
+// get the fingerprint captured by the helloConn under @conn, e.g. a tls connection
+func connTLSFingerprint(conn net.Conn) *TLSFingerprint {
+	for conn != nil {
+		if c, ok := conn.(*helloConn); ok {
+			return c.fingerprint()
+		}
+		inner, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		conn = inner.NetConn()
+	}
+
+	return nil
+}
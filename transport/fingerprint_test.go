@@ -0,0 +1,99 @@
+package getty
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientHelloFingerprint(t *testing.T) {
+	hello := &clientHello{
+		version:           0x0303,
+		cipherSuites:      []uint16{0x0a0a, 0x1301, 0xc02b},
+		extensions:        []uint16{0x0a0a, tlsExtServerName, tlsExtALPN, tlsExtSignatureAlgorithms, tlsExtSupportedVersions},
+		signatureAlgs:     []uint16{0x0403, 0x0804},
+		supportedVersions: []uint16{0x1a1a, 0x0304},
+		serverName:        "getty",
+		alpn:              []string{"h2"},
+	}
+	fp := hello.fingerprint()
+	assert.Equal(t, "771,4865-49195,0-16-13-43,,", fp.JA3String)
+	assert.Equal(t, "0ac51d9c541f8ccf42896c3dc29884a1", fp.JA3)
+	assert.Equal(t, "t13d0204h2_777cda164f4b_ef5f37ab036a", fp.JA4)
+
+	hello = &clientHello{version: 0x0303, alpn: []string{"\x00x"}}
+	assert.Equal(t, "t12i000008_000000000000_000000000000", hello.ja4())
+	hello.alpn = nil
+	assert.True(t, strings.HasPrefix(hello.ja4(), "t12i000000_"))
+}
+
+// recordConn records the bytes read through it
+type recordConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *recordConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.buf.Write(p[:n])
+	return n, err
+}
+
+func TestHelloConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	recorder := &recordConn{Conn: server}
+	conn := &helloConn{Conn: recorder}
+	go tls.Client(client, &tls.Config{
+		ServerName:         "getty.test",
+		NextProtos:         []string{"h2", "http/1.1"},
+		InsecureSkipVerify: true,
+	}).Handshake()
+	tlsConn := tls.Server(conn, newTestTLSConfig(t))
+	assert.Nil(t, tlsConn.Handshake())
+
+	fp := connTLSFingerprint(tlsConn)
+	assert.NotNil(t, fp)
+	assert.Equal(t, "getty.test", fp.ServerName)
+	assert.Equal(t, []string{"h2", "http/1.1"}, fp.ALPN)
+	assert.True(t, strings.HasPrefix(fp.JA3String, "771,"), fp.JA3String)
+	sum := md5.Sum([]byte(fp.JA3String))
+	assert.Equal(t, hex.EncodeToString(sum[:]), fp.JA3)
+	assert.True(t, strings.HasPrefix(fp.JA4, "t13d"), fp.JA4)
+	assert.Equal(t, "h2", fp.JA4[8:10])
+
+	// the same fingerprint is captured from the fragmented reads
+	raw := recorder.buf.Bytes()
+	fragmented := &helloConn{}
+	for i := 0; i < len(raw) && !fragmented.captured; i += 7 {
+		end := i + 7
+		if end > len(raw) {
+			end = len(raw)
+		}
+		fragmented.capture(raw[i:end])
+	}
+	assert.Equal(t, fp, fragmented.fingerprint())
+
+	// not a tls connection
+	plain := &helloConn{}
+	plain.capture([]byte("GET / HTTP/1.1\r\n"))
+	assert.True(t, plain.captured)
+	assert.Nil(t, plain.fingerprint())
+}
+
+func TestParseClientHelloMalformed(t *testing.T) {
+	_, err := parseClientHello([]byte{3, 3})
+	assert.NotNil(t, err)
+	_, err = parseClientHello(append(make([]byte, 34), 0, 0, 3))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,181 @@
+/******************************************************
+# DESC       : udp fragmentation & reassembly
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-16 10:15
+# FILE       : fragment.go
+******************************************************/
+
+package getty
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	// magic(1B) + message id(4B) + fragment index(2B) + fragment number(2B)
+	fragmentHeaderLen = 9
+	fragmentMagic     = 0xF7
+
+	defaultFragmentMTU               = 1200
+	defaultFragmentMaxMessageSize    = 1 << 20
+	defaultFragmentReassemblyTimeout = 3 * time.Second
+	maxFragmentNum                   = 1<<16 - 1
+)
+
+var (
+	errIllegalFragment = jerrors.New("illegal udp fragment")
+)
+
+// FragmentConfig is the udp fragmentation config of a session, which is set by
+// (DatagramSession)SetFragmentation. Both peers should enable the fragmentation, because
+// every datagram carries a 9 bytes fragment header once it is enabled.
+type FragmentConfig struct {
+	// the max datagram size including the fragment header. Its default value is the max
+	// datagram size of the session(see (DatagramSession)MaxDatagramSize), which is 1200 if the path
+	// mtu is unknown.
+	MTU int
+	// the max size of the reassembled message. Its default value is 1MB.
+	MaxMessageSize int
+	// the fragments of an incomplete message are dropped after it. Its default value is 3s.
+	ReassemblyTimeout time.Duration
+}
+
+func (c FragmentConfig) withDefaults() FragmentConfig {
+	if c.MTU <= fragmentHeaderLen {
+		c.MTU = defaultFragmentMTU
+	}
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = defaultFragmentMaxMessageSize
+	}
+	if c.ReassemblyTimeout <= 0 {
+		c.ReassemblyTimeout = defaultFragmentReassemblyTimeout
+	}
+
+	return c
+}
+
+// split @msg into datagrams whose size is not greater than @c.MTU
+func (c FragmentConfig) fragment(id uint32, msg []byte) ([][]byte, error) {
+	payload := c.MTU - fragmentHeaderLen
+	num := (len(msg) + payload - 1) / payload
+	if num == 0 {
+		num = 1
+	}
+	if maxFragmentNum < num || c.MaxMessageSize < len(msg) {
+		return nil, newGettyError(ErrMsgTooLarge,
+			jerrors.Errorf("message len %d, fragment mtu %d, max message size %d", len(msg), c.MTU, c.MaxMessageSize))
+	}
+
+	datagrams := make([][]byte, 0, num)
+	for i := 0; i < num; i++ {
+		end := (i + 1) * payload
+		if len(msg) < end {
+			end = len(msg)
+		}
+		datagram := make([]byte, fragmentHeaderLen, fragmentHeaderLen+end-i*payload)
+		datagram[0] = fragmentMagic
+		binary.BigEndian.PutUint32(datagram[1:], id)
+		binary.BigEndian.PutUint16(datagram[5:], uint16(i))
+		binary.BigEndian.PutUint16(datagram[7:], uint16(num))
+		datagrams = append(datagrams, append(datagram, msg[i*payload:end]...))
+	}
+
+	return datagrams, nil
+}
+
+type reassemblyKey struct {
+	peer string
+	id   uint32
+}
+
+type reassemblyEntry struct {
+	fragments [][]byte
+	received  int
+	size      int
+	deadline  time.Time
+}
+
+// reassembler is only used by the read goroutine of the session
+type reassembler struct {
+	config    FragmentConfig
+	entries   map[reassemblyKey]*reassemblyEntry
+	lastSweep time.Time
+}
+
+func newReassembler(config FragmentConfig) *reassembler {
+	return &reassembler{
+		config:  config,
+		entries: make(map[reassemblyKey]*reassemblyEntry),
+	}
+}
+
+// add the datagram @data from @peer. it returns the reassembled message if it is complete.
+func (r *reassembler) add(peer string, data []byte, now time.Time) ([]byte, error) {
+	if len(data) < fragmentHeaderLen || data[0] != fragmentMagic {
+		return nil, errIllegalFragment
+	}
+	id := binary.BigEndian.Uint32(data[1:])
+	idx := int(binary.BigEndian.Uint16(data[5:]))
+	num := int(binary.BigEndian.Uint16(data[7:]))
+	if num == 0 || num <= idx {
+		return nil, errIllegalFragment
+	}
+	payload := data[fragmentHeaderLen:]
+	if num == 1 {
+		return append([]byte(nil), payload...), nil
+	}
+
+	r.sweep(now)
+	key := reassemblyKey{peer: peer, id: id}
+	e, ok := r.entries[key]
+	if !ok {
+		e = &reassemblyEntry{fragments: make([][]byte, num), deadline: now.Add(r.config.ReassemblyTimeout)}
+		r.entries[key] = e
+	}
+	if len(e.fragments) != num {
+		delete(r.entries, key)
+		return nil, errIllegalFragment
+	}
+	if e.fragments[idx] != nil {
+		// duplicate fragment
+		return nil, nil
+	}
+	e.size += len(payload)
+	if r.config.MaxMessageSize < e.size {
+		delete(r.entries, key)
+		return nil, newGettyError(ErrMsgTooLarge,
+			jerrors.Errorf("reassembled message size > max message size %d", r.config.MaxMessageSize))
+	}
+	e.fragments[idx] = append([]byte(nil), payload...)
+	e.received++
+	if e.received < num {
+		return nil, nil
+	}
+
+	delete(r.entries, key)
+	msg := make([]byte, 0, e.size)
+	for _, fragment := range e.fragments {
+		msg = append(msg, fragment...)
+	}
+	return msg, nil
+}
+
+// drop the incomplete messages whose reassembly timeout expires
+func (r *reassembler) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < r.config.ReassemblyTimeout>>1 {
+		return
+	}
+	r.lastSweep = now
+	for key, e := range r.entries {
+		if e.deadline.Before(now) {
+			delete(r.entries, key)
+		}
+	}
+}
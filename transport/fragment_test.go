@@ -0,0 +1,91 @@
+package getty
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFragmentReassemble(t *testing.T) {
+	config := FragmentConfig{MTU: 100, MaxMessageSize: 1000}.withDefaults()
+	assert.Equal(t, defaultFragmentReassemblyTimeout, config.ReassemblyTimeout)
+
+	msg := bytes.Repeat([]byte("0123456789"), 50)
+	datagrams, err := config.fragment(1, msg)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(datagrams))
+	for _, datagram := range datagrams {
+		assert.True(t, len(datagram) <= config.MTU)
+	}
+	_, err = config.fragment(2, make([]byte, 1001))
+	assert.True(t, errors.Is(err, ErrMsgTooLarge))
+
+	now := time.Now()
+	r := newReassembler(config)
+	// out of order & duplicate
+	for i := len(datagrams) - 1; 0 < i; i-- {
+		data, err := r.add("peer", datagrams[i], now)
+		assert.Nil(t, err)
+		assert.Nil(t, data)
+	}
+	data, err := r.add("peer", datagrams[1], now)
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+	data, err = r.add("peer", datagrams[0], now)
+	assert.Nil(t, err)
+	assert.Equal(t, msg, data)
+	assert.Equal(t, 0, len(r.entries))
+
+	// single fragment
+	datagrams, _ = config.fragment(3, []byte("hello"))
+	data, err = r.add("peer", datagrams[0], now)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	// the incomplete message expires
+	datagrams, _ = config.fragment(4, msg)
+	r.add("peer", datagrams[0], now)
+	assert.Equal(t, 1, len(r.entries))
+	r.add("peer", datagrams[1], now.Add(2*config.ReassemblyTimeout))
+	_, ok := r.entries[reassemblyKey{peer: "peer", id: 4}]
+	assert.True(t, ok)
+	assert.Equal(t, 1, r.entries[reassemblyKey{peer: "peer", id: 4}].received)
+
+	_, err = r.add("peer", []byte("hello"), now)
+	assert.Equal(t, errIllegalFragment, err)
+}
+
+func TestUDPConnSendFragments(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer peer.Close()
+	local, err := net.DialUDP("udp", nil, peer.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+
+	ss := newUDPSession(local, newClient(UDP_CLIENT, WithServerAddress(peer.LocalAddr().String()), WithConnectionNumber(1)))
+	ss.(DatagramSession).SetFragmentation(&FragmentConfig{MTU: 64})
+	msg := bytes.Repeat([]byte("x"), 200)
+	n, err := ss.(*session).Connection.send(UDPContext{Pkg: msg})
+	assert.Nil(t, err)
+	assert.Equal(t, 200+4*fragmentHeaderLen, n)
+
+	r := newReassembler(FragmentConfig{}.withDefaults())
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := peer.ReadFromUDP(buf)
+		assert.Nil(t, err)
+		data, err := r.add(addr.String(), buf[:n], time.Now())
+		assert.Nil(t, err)
+		if data != nil {
+			assert.Equal(t, msg, data)
+			break
+		}
+	}
+	local.Close()
+}
@@ -0,0 +1,192 @@
+/******************************************************
+# DESC       : protocol state machine
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-15 15:06
+# FILE       : fsm.go
+******************************************************/
+
+package getty
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+var (
+	sessionStateKey = "session-state-machine"
+)
+
+// State is a step of a multi-step protocol, e.g. AUTH -> SUBSCRIBE -> STREAMING.
+type State struct {
+	Name string
+	// the session will be closed if it stays in the state longer than @Timeout. 0 means no timeout.
+	Timeout time.Duration
+	// invoked when the session enters the state. If the return error is not nil, the session will be closed.
+	OnEnter func(ss Session) error
+	// invoked when the session receives a pkg in the state. It returns the name of the next state,
+	// and the session stays in the state if @next is empty or the name of the state itself.
+	// If the return error is not nil, the session will be closed.
+	// If it is nil, the pkgs are delivered to the (EventListener)OnMessage of the state machine.
+	OnMessage func(ss Session, pkg interface{}) (next string, err error)
+}
+
+// StateMachine drives the sessions through the protocol states declaratively. It is an EventListener
+// which wraps @listener: the pkgs received in the states with OnMessage are handled by the states,
+// and the others are delivered to @listener, so @listener only sees the pkgs of the final state.
+// The errors(including ErrStateTimeout) are reported to (EventListener)OnError of @listener before
+// the session is closed. A StateMachine can be shared by many sessions.
+type StateMachine struct {
+	listener EventListener
+	initial  string
+	states   map[string]State
+}
+
+// per session state
+type sessionState struct {
+	sync.Mutex
+	name  string
+	seq   uint64 // increased on every transition, to ignore stale timers
+	timer *time.Timer
+}
+
+// NewStateMachine creates a StateMachine. The session enters @initial when it is opened.
+func NewStateMachine(listener EventListener, initial string, states ...State) *StateMachine {
+	m := &StateMachine{
+		listener: listener,
+		initial:  initial,
+		states:   make(map[string]State, len(states)),
+	}
+	for _, state := range states {
+		if _, ok := m.states[state.Name]; ok {
+			panic(fmt.Sprintf("duplicate state %q", state.Name))
+		}
+		m.states[state.Name] = state
+	}
+	if _, ok := m.states[initial]; !ok {
+		panic(fmt.Sprintf("unknown initial state %q", initial))
+	}
+
+	return m
+}
+
+func (m *StateMachine) sessionState(ss Session) *sessionState {
+	st, _ := ss.GetAttribute(sessionStateKey).(*sessionState)
+	return st
+}
+
+// State returns the current state name of @ss. It is empty if @ss is not driven by @m.
+// Do not invoke it in the callbacks of State, which are invoked with the state locked.
+func (m *StateMachine) State(ss Session) string {
+	st := m.sessionState(ss)
+	if st == nil {
+		return ""
+	}
+
+	st.Lock()
+	defer st.Unlock()
+	return st.name
+}
+
+// enter the state @name. @st should be locked.
+func (m *StateMachine) enter(ss Session, st *sessionState, name string) error {
+	state, ok := m.states[name]
+	if !ok {
+		return jerrors.Errorf("unknown state %q, current state %q", name, st.name)
+	}
+
+	if st.timer != nil {
+		st.timer.Stop()
+		st.timer = nil
+	}
+	log.Debug("session %s enters state %q from %q", sessionID(ss), name, st.name)
+	st.name = name
+	st.seq++
+	if state.Timeout > 0 {
+		seq := st.seq
+		st.timer = time.AfterFunc(state.Timeout, func() {
+			st.Lock()
+			expired := st.seq == seq
+			st.Unlock()
+			if expired {
+				m.fail(ss, newGettyError(ErrStateTimeout,
+					jerrors.Errorf("state %q timeout %s", name, state.Timeout)))
+			}
+		})
+	}
+	if state.OnEnter != nil {
+		return state.OnEnter(ss)
+	}
+
+	return nil
+}
+
+func (m *StateMachine) fail(ss Session, err error) {
+	log.Warn("session %s, [StateMachine] state %q error:%v", sessionID(ss), m.State(ss), err)
+	m.listener.OnError(ss, err)
+	ss.Close()
+}
+
+func (m *StateMachine) OnOpen(ss Session) error {
+	if err := m.listener.OnOpen(ss); err != nil {
+		return err
+	}
+
+	st := &sessionState{}
+	ss.SetAttribute(sessionStateKey, st)
+	st.Lock()
+	defer st.Unlock()
+	return m.enter(ss, st, m.initial)
+}
+
+func (m *StateMachine) OnClose(ss Session) {
+	if st := m.sessionState(ss); st != nil {
+		st.Lock()
+		if st.timer != nil {
+			st.timer.Stop()
+			st.timer = nil
+		}
+		st.seq++
+		st.Unlock()
+	}
+	m.listener.OnClose(ss)
+}
+
+func (m *StateMachine) OnError(ss Session, err error) {
+	m.listener.OnError(ss, err)
+}
+
+func (m *StateMachine) OnCron(ss Session) {
+	m.listener.OnCron(ss)
+}
+
+func (m *StateMachine) OnMessage(ss Session, pkg interface{}) {
+	st := m.sessionState(ss)
+	if st == nil {
+		// the session has been closed
+		return
+	}
+
+	st.Lock()
+	state := m.states[st.name]
+	if state.OnMessage == nil {
+		st.Unlock()
+		m.listener.OnMessage(ss, pkg)
+		return
+	}
+	next, err := state.OnMessage(ss, pkg)
+	if err == nil && next != "" && next != st.name {
+		err = m.enter(ss, st, next)
+	}
+	st.Unlock()
+	if err != nil {
+		m.fail(ss, err)
+	}
+}
@@ -0,0 +1,114 @@
+package getty
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type fsmHandler struct {
+	MessageHandler
+
+	pkgs []interface{}
+	errs chan error
+}
+
+func (h *fsmHandler) OnMessage(ss Session, pkg interface{}) {
+	h.pkgs = append(h.pkgs, pkg)
+}
+
+func (h *fsmHandler) OnError(ss Session, err error) {
+	h.errs <- err
+}
+
+func newTCPTestSession(t *testing.T) *session {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	peer, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	t.Cleanup(func() { peer.Close() })
+	conn, err := l.Accept()
+	assert.Nil(t, err)
+
+	clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+	return newTCPSession(conn, clt).(*session)
+}
+
+func TestStateMachine(t *testing.T) {
+	var entered []string
+
+	handler := &fsmHandler{errs: make(chan error, 1)}
+	onEnter := func(ss Session) error {
+		entered = append(entered, "SUBSCRIBE")
+		return nil
+	}
+	m := NewStateMachine(handler, "AUTH",
+		State{Name: "AUTH", OnMessage: func(ss Session, pkg interface{}) (string, error) {
+			if pkg != "token" {
+				return "", errors.New("illegal token")
+			}
+			return "SUBSCRIBE", nil
+		}},
+		State{Name: "SUBSCRIBE", OnEnter: onEnter, OnMessage: func(ss Session, pkg interface{}) (string, error) {
+			if pkg == "subscribe" {
+				return "STREAMING", nil
+			}
+			return "", nil
+		}},
+		State{Name: "STREAMING"},
+	)
+	assert.Panics(t, func() { NewStateMachine(handler, "NONE", State{Name: "AUTH"}) })
+
+	ss := newTCPTestSession(t)
+	defer ss.Close()
+	assert.Equal(t, "", m.State(ss))
+	assert.Nil(t, m.OnOpen(ss))
+	assert.Equal(t, "AUTH", m.State(ss))
+	m.OnMessage(ss, "token")
+	assert.Equal(t, "SUBSCRIBE", m.State(ss))
+	assert.Equal(t, []string{"SUBSCRIBE"}, entered)
+	m.OnMessage(ss, "ping")
+	assert.Equal(t, "SUBSCRIBE", m.State(ss))
+	m.OnMessage(ss, "subscribe")
+	assert.Equal(t, "STREAMING", m.State(ss))
+	m.OnMessage(ss, "data")
+	assert.Equal(t, []interface{}{"data"}, handler.pkgs)
+
+	// illegal pkg
+	ss = newTCPTestSession(t)
+	assert.Nil(t, m.OnOpen(ss))
+	m.OnMessage(ss, "bad token")
+	assert.NotNil(t, <-handler.errs)
+	assert.True(t, ss.IsClosed())
+}
+
+func TestStateMachineTimeout(t *testing.T) {
+	handler := &fsmHandler{errs: make(chan error, 1)}
+	m := NewStateMachine(handler, "AUTH",
+		State{Name: "AUTH", Timeout: 50 * time.Millisecond, OnMessage: func(ss Session, pkg interface{}) (string, error) {
+			return "STREAMING", nil
+		}},
+		State{Name: "STREAMING"},
+	)
+
+	// the timer is stopped after the transition
+	ss := newTCPTestSession(t)
+	defer ss.Close()
+	assert.Nil(t, m.OnOpen(ss))
+	m.OnMessage(ss, "token")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, len(handler.errs))
+	assert.False(t, ss.IsClosed())
+
+	ss = newTCPTestSession(t)
+	assert.Nil(t, m.OnOpen(ss))
+	err := <-handler.errs
+	assert.True(t, errors.Is(err, ErrStateTimeout), "%v", err)
+	assert.True(t, ss.IsClosed())
+}
@@ -0,0 +1,219 @@
+package getty
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+)
+
+// The fuzz targets only run their seed corpus by go test. Run one of them continuously by
+// go test -run '^$' -fuzz '^FuzzSessionRead$' ./transport
+
+func FuzzFragmentReassembly(f *testing.F) {
+	config := FragmentConfig{MTU: fragmentHeaderLen + 4, MaxMessageSize: 64}.withDefaults()
+	datagrams, err := config.fragment(1, []byte("hello, fragments"))
+	assert.Nil(f, err)
+	f.Add(bytes.Join(datagrams, nil), uint8(len(datagrams[0])))
+	f.Add([]byte{fragmentMagic, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff}, uint8(9))
+
+	f.Fuzz(func(t *testing.T, data []byte, size uint8) {
+		r := newReassembler(config)
+		now := time.Now()
+		for _, datagram := range splitChunks(data, int(size)) {
+			msg, err := r.add("peer", datagram, now)
+			if err == nil && msg != nil && len(msg) > config.MaxMessageSize {
+				t.Fatalf("reassembled message of %d bytes > max message size", len(msg))
+			}
+		}
+	})
+}
+
+func FuzzFECDecoder(f *testing.F) {
+	config := FECConfig{DataShards: 2, ParityShards: 1}.withDefaults()
+	encoder := newFECEncoder(config)
+	datagrams := encoder.encode("peer", [][]byte{[]byte("hello"), []byte("fec")}, time.Now())
+	f.Add(bytes.Join(datagrams[1:], nil), uint8(len(datagrams[1])))
+	f.Add([]byte{fecMagic, 0, 0, 0, 1, 3, 2, 2, 0, 0}, uint8(10))
+
+	f.Fuzz(func(t *testing.T, data []byte, size uint8) {
+		d := newFECDecoder(config)
+		now := time.Now()
+		for _, datagram := range splitChunks(data, int(size)) {
+			d.add("peer", datagram, now)
+		}
+	})
+}
+
+func FuzzDedupReceiver(f *testing.F) {
+	sender := newDedupSender(DedupConfig{}.withDefaults())
+	f.Add(append(sender.encode([]byte("a")), sender.encode([]byte("b"))...), uint8(dedupHeaderLen+1))
+	f.Add([]byte{dedupMagic, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint8(dedupHeaderLen))
+
+	f.Fuzz(func(t *testing.T, data []byte, size uint8) {
+		d := newDedupReceiver(DedupConfig{Window: 128}.withDefaults())
+		now := time.Now()
+		for _, datagram := range splitChunks(data, int(size)) {
+			payload, err := d.add("peer", datagram, now)
+			if err == nil && payload != nil && len(payload) != len(datagram)-dedupHeaderLen {
+				t.Fatalf("payload of %d bytes from a datagram of %d bytes", len(payload), len(datagram))
+			}
+		}
+	})
+}
+
+func FuzzSTUNDecode(f *testing.F) {
+	var id stunTransactionID
+	f.Add(stunEncodeBindingRequest(id))
+	f.Add(stunTestResponse(stunEncodeBindingRequest(id), &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5}, true))
+	f.Add(stunTestResponse(stunEncodeBindingRequest(id), &net.UDPAddr{IP: net.IPv6loopback, Port: 5}, false))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		stunDecodeBindingResponse(data)
+	})
+}
+
+func FuzzNegotiation(f *testing.F) {
+	config := NegotiationConfig{
+		Compressions: []CompressType{CompressSnappy, CompressNone},
+		Ciphers:      []CipherSuite{CipherAES128GCM},
+	}
+	f.Add(config.encode(make([]byte, negotiationNonceLen)))
+	f.Add([]byte("GNEG"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		peer, frame, err := readNegotiation(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if !bytes.HasPrefix(data, frame) {
+			t.Fatalf("frame %x is not the prefix of the input", frame)
+		}
+		// the frame of the decoded config is decoded to the same config
+		peer2, _, err := readNegotiation(bytes.NewReader(peer.encode(frame[len(negotiationMagic)+1:])))
+		assert.Nil(t, err)
+		assert.Equal(t, peer, peer2)
+	})
+}
+
+func FuzzMessageDecompress(f *testing.F) {
+	for _, typ := range []CompressType{CompressSnappy, CompressBestSpeed} {
+		c := newMsgCompressor(MessageCompressConfig{Type: typ}.withDefaults())
+		raw, _ := c.frame([]byte("raw"), false)
+		compressed, _ := c.frame(bytes.Repeat([]byte("compressed"), 16), true)
+		f.Add(append(raw, compressed...), typ == CompressSnappy)
+	}
+	f.Add([]byte{msgFlagCompressed, 4, 0xff, 0xff, 0xff, 0x7f}, true)
+	f.Add(append([]byte{msgFlagCompressed, 6}, snappy.Encode(nil, []byte("abc"))...), false)
+
+	f.Fuzz(func(t *testing.T, data []byte, isSnappy bool) {
+		config := MessageCompressConfig{Type: CompressBestSpeed}
+		if isSnappy {
+			config.Type = CompressSnappy
+		}
+		r := newMsgDecompressReader(bytes.NewReader(data), config.withDefaults())
+		io.Copy(ioutil.Discard, io.LimitReader(r, 4*maxFramedMessageLen))
+	})
+}
+
+func FuzzResyncDiscard(f *testing.F) {
+	f.Add([]byte("garbage$hello\n"), uint8(ResyncSkipToMagic), []byte("$"), 0)
+	f.Add([]byte("xyzw"), uint8(ResyncDropBytes), []byte(nil), 3)
+	f.Add([]byte("$"), uint8(ResyncSkipToMagic), []byte("$$"), 0)
+
+	f.Fuzz(func(t *testing.T, data []byte, strategy uint8, magic []byte, drop int) {
+		if len(data) == 0 {
+			return
+		}
+		config := ResyncConfig{Strategy: ResyncStrategy(strategy % 3), Magic: magic, DropBytes: drop}
+		n := config.discard(data)
+		if n < -1 || n > len(data) {
+			t.Fatalf("discard %d bytes of %d bytes", n, len(data))
+		}
+	})
+}
+
+// FuzzSessionRead feeds a random byte stream to the read path of a tcp session in random
+// chunks, i.e. the frames are truncated at random positions. Without resync, the session
+// should deliver the same pkgs as decoding the whole stream at once, and then be closed.
+func FuzzSessionRead(f *testing.F) {
+	f.Add([]byte("$hello\n$world\n"), uint8(3), false)
+	f.Add([]byte("$hello\n$wor"), uint8(1), false)
+	f.Add([]byte("$hello\ngarbage$world\n"), uint8(5), true)
+	f.Add([]byte("\n\n$\n"), uint8(0), false)
+
+	f.Fuzz(func(t *testing.T, data []byte, size uint8, resync bool) {
+		conn, peer := net.Pipe()
+		defer peer.Close()
+		listener := &lineListener{msgs: make(chan interface{}, len(data)+1)}
+		clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+		ss := newTCPSession(conn, clt).(*session)
+		ss.SetPkgHandler(magicLineCodec{})
+		ss.SetEventListener(listener)
+		ss.SetReadTimeout(time.Second)
+		ss.SetWaitTime(10 * time.Millisecond)
+		if resync {
+			ss.SetResync(&ResyncConfig{Strategy: ResyncSkipToMagic, Magic: []byte("$")})
+		}
+		ss.run()
+		defer ss.Close()
+
+		for _, chunk := range splitChunks(data, int(size)) {
+			if _, err := peer.Write(chunk); err != nil {
+				// the session has been closed by a framing error
+				break
+			}
+		}
+		peer.Close()
+		for deadline := time.Now().Add(5 * time.Second); !ss.IsClosed(); {
+			if time.Now().After(deadline) {
+				t.Fatal("the session is not closed after its peer is closed")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if resync {
+			return
+		}
+
+		var expected []interface{}
+		for buf := data; len(buf) > 0; {
+			pkg, n, err := magicLineCodec{}.Read(nil, buf)
+			if err != nil || n == 0 {
+				break
+			}
+			expected = append(expected, pkg)
+			buf = buf[n:]
+		}
+		var got []interface{}
+		for len(got) < len(expected) {
+			select {
+			case pkg := <-listener.msgs:
+				got = append(got, pkg)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("got %d pkgs, expected %d pkgs", len(got), len(expected))
+			}
+		}
+		assert.Equal(t, expected, got)
+	})
+}
+
+// split @data into chunks of @size bytes, and 0 @size means a single chunk
+func splitChunks(data []byte, size int) [][]byte {
+	if size <= 0 || size >= len(data) {
+		return [][]byte{data}
+	}
+
+	var chunks [][]byte
+	for len(data) > size {
+		chunks = append(chunks, data[:size])
+		data = data[size:]
+	}
+	return append(chunks, data)
+}
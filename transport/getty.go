@@ -11,8 +11,11 @@ package getty
 
 import (
 	"compress/flate"
-	"errors"
+	"context"
+	"fmt"
+	"io"
 	"net"
+	"strconv"
 	"time"
 )
 
@@ -43,6 +46,18 @@ type Reader interface {
 	Read(Session, []byte) (interface{}, int, error)
 }
 
+// StreamReader is an optional interface of the websocket session Reader. If the Reader implements it,
+// every websocket message will be handed to ReadStream as @r, so giant fragmented messages can be
+// processed incrementally rather than fully buffered. @r is only valid in ReadStream, and the bytes
+// left in it will be discarded. The max message length of the session(SetMaxMsgLen) does not
+// limit the messages read by ReadStream, so the StreamReader should limit them if necessary.
+//
+// If the return error is not nil, the message will be skipped. And if @r failed, the session will be closed.
+// If the return pkg is nil, nothing will be delivered to (EventListener)OnMessage.
+type StreamReader interface {
+	ReadStream(ss Session, r io.Reader) (interface{}, error)
+}
+
 // Writer is used to marshal pkg and write to session
 type Writer interface {
 	// if @Session is udpGettySession, the second parameter is UDPContext.
@@ -84,6 +99,62 @@ type EventListener interface {
 	OnMessage(Session, interface{})
 }
 
+// ErrorDirection indicates whether an error is got when reading from or writing to the network
+type ErrorDirection int32
+
+const (
+	ErrorDirectionRead  ErrorDirection = 1
+	ErrorDirectionWrite ErrorDirection = 2
+)
+
+func (d ErrorDirection) String() string {
+	switch d {
+	case ErrorDirectionRead:
+		return "read"
+	case ErrorDirectionWrite:
+		return "write"
+	}
+
+	return strconv.Itoa(int(d))
+}
+
+// ErrorListener is an optional interface of EventListener. If the EventListener implements it,
+// OnSessionError will be invoked instead of OnError.
+//
+// OnSessionError is invoked on read errors(including codec errors) and write errors. If the error
+// will close the session, it is invoked before the session is closed, so u can classify, count and
+// react to it while the session is still alive.
+type ErrorListener interface {
+	OnSessionError(Session, error, ErrorDirection)
+}
+
+// EventListenerV2 is the second generation event listener, which can be set by
+// (HandlerSwitcher)SetEventListenerV2. An old EventListener can be adapted to it by NewEventListenerV2.
+type EventListenerV2 interface {
+	// invoked when session opened
+	// If the return error is not nil, @Session will be closed.
+	OnOpen(Session) error
+
+	// invoked when session closed.
+	OnClose(Session)
+
+	// invoked on read errors(including codec errors) and write errors. If the error will close
+	// the session, it is invoked before the session is closed.
+	OnError(Session, error, ErrorDirection)
+
+	// invoked periodically, its period can be set by (Session)SetCronPeriod
+	OnCron(Session)
+
+	// invoked when getty received packages. All packages decoded from one tcp read are
+	// delivered in one batch. Its notices are the same as (EventListener)OnMessage.
+	OnMessages(Session, []interface{})
+
+	// invoked when the write queue has been drained after a WritePkg/WritePkgContext failed
+	// for the write queue was full, or when the session turns writable after (FlowController)IsCongested
+	// returned true, so u can resume writing.
+	OnWritable(Session)
+}
+
 /////////////////////////////////////////
 // compress
 /////////////////////////////////////////
@@ -97,6 +168,12 @@ const (
 	CompressBestCompression              = flate.BestCompression    // 9
 	CompressHuffman                      = flate.HuffmanOnly        // -2
 	CompressSnappy                       = 10
+	// block snappy per write frame, whose frame format is the mini-header of the per message
+	// compression, so no stream state is kept across the frames.
+	CompressSnappyBlock = 11
+	// brotli of quality 6, see CompressBrotliQuality for the other qualities. The brotli
+	// implementation should be set by SetBrotliCodec.
+	CompressBrotli = compressBrotliBase + defaultBrotliQuality
 )
 
 /////////////////////////////////////////
@@ -132,12 +209,23 @@ type Connection interface {
 // Session interface
 /////////////////////////////////////////
 
-var (
-	ErrSessionClosed  = errors.New("session Already Closed")
-	ErrSessionBlocked = errors.New("session Full Blocked")
-	ErrNullPeerAddr   = errors.New("peer address is nil")
-)
-
+// Session is safe for concurrent use by its read/write goroutines and the user goroutines,
+// with the exceptions below:
+//   - the config setters, such as SetMaxMsgLen, SetName, SetCronPeriod, SetWQLen, SetWaitTime,
+//     SetTaskPool and the setters of DatagramSession & StreamSession, should be invoked in
+//     NewSessionCallback before the session runs.
+//   - (HandlerSwitcher)SwitchReadCodec should be invoked only in (Reader)Read, i.e. by the read goroutine.
+//   - Reset must not be invoked until the goroutines of the session have exited.
+//
+// The others, including the Write* and Close* methods, the getters such as Stat, IsClosed,
+// GetActive and Rates, the attribute methods, SetEventListener, SetPkgHandler, SetReader,
+// SetWriter, Transfer, SetMirrorSession, SetReadTimeout, SetWriteTimeout and SetCompressType,
+// can be invoked at any time. Pls attention that SetCompressType switches the compression of
+// both sides at once, so the peer can decode the stream only if it switches at the same point.
+//
+// The optional capabilities of the sessions are the small interfaces below, e.g. SessionWriter,
+// DatagramSession & FlowController, which are implemented by all the sessions built by getty and
+// can be got by the type assertions, e.g. ss.(getty.SessionWriter).WritePkgContext(ctx, pkg).
 type Session interface {
 	Connection
 	Reset()
@@ -173,6 +261,157 @@ type Session interface {
 	Close()
 }
 
+// SessionWriter is the optional interface of the sessions for the queued writes with the
+// cancellation, the expiry or the conflation of the pkgs.
+type SessionWriter interface {
+	// put @pkg into the write queue. it blocks until @pkg has been queued or @ctx is done.
+	// @pkg will be dropped if @ctx is done before it is sent.
+	WritePkgContext(ctx context.Context, pkg interface{}) error
+	// put @pkg into the write queue, and drop it if it is not sent in @ttl.
+	WritePkgTTL(pkg interface{}, ttl time.Duration, timeout time.Duration) error
+	// put @pkg into the write queue with the conflation @key. a queued pkg of @key is replaced
+	// by @pkg in place, i.e. the last value wins.
+	WriteConflated(key interface{}, pkg interface{}, timeout time.Duration) error
+}
+
+// SessionCloser is the optional interface of the sessions for the graceful closing.
+type SessionCloser interface {
+	// close the session and wait until its goroutines exit or @ctx is done. the deadline of
+	// @ctx takes precedence over the wait time of the session.
+	CloseContext(ctx context.Context) error
+	// close a websocket session with the close @code and @reason. it is the same as Close
+	// for tcp/udp sessions. an unsendable @code is replaced with 1000(normal closure), and
+	// @reason is truncated to 123 bytes.
+	CloseWithStatus(code int, reason string)
+	// get the close code & reason of a websocket session. it can be invoked in (EventListener)OnClose.
+	// its return value is nil if the session is not a websocket session or no close code is got.
+	CloseReason() *CloseReason
+}
+
+// HandlerSwitcher is the optional interface of the sessions for switching the handlers and the
+// codecs of a running session.
+type HandlerSwitcher interface {
+	SetEventListenerV2(EventListenerV2)
+	// hand the session over to another handler and listener at the frame boundary
+	Transfer(ReadWriter, EventListener)
+	// switch the codec or the compression of the read side at the boundary frame decoded by
+	// (Reader)Read, and of the write side after the boundary pkg, for in-place protocol upgrades.
+	SwitchReadCodec(CodecSwitch) error
+	WriteCodecSwitch(pkg interface{}, codec CodecSwitch, timeout time.Duration) error
+}
+
+// SessionInspector is the optional interface of the sessions for their identities and statistics.
+type SessionInspector interface {
+	// get the session id generated by the SessionIDGenerator of the endpoint. It is the
+	// decimal ID() if the endpoint has no generator.
+	SessionID() string
+	// get the rolling bandwidth and pps of the session
+	Rates() SessionRates
+	// get the rate limited logger of the session, whose logs are prefixed with the session token.
+	Logger() *SessionLogger
+	// get the write queue delay of the session, which is monitored if the starvation threshold
+	// of its endpoint is set.
+	WriteStarvation() WriteStarvationStats
+	// get the kernel statistics of the tcp connection of a tcp/websocket session, such as
+	// retransmits, rtt and cwnd. its return value is nil if it is not supported.
+	TCPInfo() *TCPInfo
+	// get the compression & encryption negotiation result of a tcp session.
+	// its return value is nil if the negotiation is not enabled.
+	Negotiated() *Negotiated
+	// get the JA3/JA4 fingerprint of the tls client of a wss session, which is captured if the
+	// server is built with WithServerTLSFingerprint. its return value is nil otherwise.
+	TLSFingerprint() *TLSFingerprint
+	// get the client identity got by the IdentityProvider of the server, see IdentityConfig.
+	// its return value is nil if the client is not identified.
+	Identity() Identity
+}
+
+// FlowController is the optional interface of the sessions for the congestion signal based on
+// the occupancy of the write queue and the kernel send buffer. (EventListenerV2)OnWritable is
+// invoked when a congested session turns writable.
+type FlowController interface {
+	IsCongested() bool
+	Writable() bool
+	SetCongestionWatermark(high, low float64)
+}
+
+// DatagramSession is the optional interface of the sessions for the udp features. Its setters
+// have no effect on tcp/websocket sessions unless noted otherwise.
+type DatagramSession interface {
+	// enable the fragmentation of a udp session, so it can carry messages larger than the mtu.
+	SetFragmentation(*FragmentConfig)
+	// enable the forward error correction of a udp session, so the receiver can recover
+	// the lost datagrams.
+	SetFEC(*FECConfig)
+	// enable the duplicate datagram suppression of a udp session, so a duplicate datagram
+	// is not delivered twice.
+	SetDedup(*DedupConfig)
+	// enable the encryption & anti-replay check of a udp session, so a captured datagram
+	// can not be re-injected. it returns ErrNotSupported for tcp/websocket sessions.
+	SetDatagramCipher(*DatagramCipherConfig) error
+	// enable the path mtu discovery of a udp session, which sets the DF bit on its datagrams.
+	SetPathMTUDiscovery(bool) error
+	// get the max message size of a udp session which can be sent without ip fragmentation.
+	// it is updated by the path mtu discovery of a connected udp session.
+	MaxDatagramSize() int
+	// enable the kernel/hardware rx & tx timestamps of a udp session, i.e. SO_TIMESTAMPING on linux.
+	// the rx timestamps are carried by UDPContext, and the tx ones are reported by UDPTxTimestampListener.
+	SetUDPTimestamping(*UDPTimestampingConfig) error
+}
+
+// StreamSession is the optional interface of the sessions for the byte stream features. Its
+// setters have no effect on udp sessions, and the ones of the tcp framing have no effect on
+// websocket sessions either.
+type StreamSession interface {
+	// enable the socket buffer auto tuning of a tcp/websocket session, which sets SO_SNDBUF/SO_RCVBUF
+	// by the measured rtt and throughput.
+	SetBufferAutoTune(*BufferTuneConfig)
+	// enable the per message compression of a tcp session, which carries a compress flag of
+	// every message in a mini-header.
+	SetMessageCompression(*MessageCompressConfig)
+	// set the io wrapper chain of the byte stream of a tcp session, e.g. by encryption or metering.
+	// the first wrapper is the innermost one.
+	SetStreamWrappers(...StreamWrapper)
+	// set the read loop knobs of a tcp session, i.e. the frames delivered before the read
+	// goroutine yields and the spin before the read blocks.
+	SetReadLoop(*ReadLoopConfig)
+	// set the desync recovery of a tcp session whose codec reports a framing error, which
+	// closes the session by default.
+	SetResync(*ResyncConfig)
+}
+
+// SessionObserver is the optional interface of the sessions for observing their traffic.
+type SessionObserver interface {
+	// duplicate inbound pkgs to the sink session asynchronously. pkgs will be dropped if the sink is overloaded.
+	SetMirrorSession(Session)
+	// enable the end-to-end latency probing of a tcp/websocket session by the built-in probe frames,
+	// and get the measured latency. the latency of all sessions is summarized by the endpoint metrics.
+	SetLatencyProbe(*LatencyProbeConfig)
+	LatencyProbe() LatencyProbeStats
+	// enable the receive & send timestamps of the frames. the inbound pkgs are delivered as
+	// TimestampedPkg, and the send timestamps are reported by SendTimestampListener.
+	SetFrameTimestamping(bool)
+}
+
+// ResourceGroupMember is the optional interface of the sessions for the tenant resource groups.
+type ResourceGroupMember interface {
+	// put the session into the resource group of a tenant in NewSessionCallback, and return
+	// ErrResourceGroupLimit if the session limit of the tenant is reached.
+	JoinResourceGroup(groups *ResourceGroups, tenant string) error
+}
+
+// CloseReason is the close code and reason of a websocket session, which is received from the
+// peer or set by (SessionCloser)CloseWithStatus.
+type CloseReason struct {
+	// websocket close code, e.g. websocket.CloseNormalClosure
+	Code int
+	Text string
+}
+
+func (r CloseReason) String() string {
+	return fmt.Sprintf("{code:%d, text:%q}", r.Code, r.Text)
+}
+
 /////////////////////////////////////////
 // EndPoint interface
 /////////////////////////////////////////
@@ -188,14 +427,56 @@ type EndPoint interface {
 	IsClosed() bool
 	// close the endpoint and free its resource
 	Close()
+	// get the statistic data of the endpoint
+	Metrics() *EndPointMetrics
 }
 
 type Client interface {
 	EndPoint
 }
 
+// ClientWriter is implemented by the clients built by NewTCPClient/NewUDPClient/NewWSClient/NewWSSClient,
+// e.g. client.(getty.ClientWriter).WritePkgContext(ctx, pkg).
+type ClientWriter interface {
+	// write @pkg through a connected session. If the client is connecting or reconnecting,
+	// @pkg is queued and flushed once a session is connected.
+	WritePkgContext(ctx context.Context, pkg interface{}) error
+}
+
+// TransportProber is implemented by the ws/wss clients, e.g.
+// client.(getty.TransportProber).TransportProbes().
+type TransportProber interface {
+	// get the latest probe results of the urls set by WithWebsocketURLs. its return value
+	// is nil if the transport probe is not enabled by WithTransportProbe.
+	TransportProbes() []TransportProbeResult
+}
+
+// SuspendResumer is implemented by the clients, e.g. client.(getty.SuspendResumer).Resume(),
+// so the application can notify the client of the sleep & wake up events of the system.
+type SuspendResumer interface {
+	// notify the client that the system is going to sleep. It invokes SuspendConfig.OnSuspend.
+	Suspend()
+	// notify the client that the system has woken up, and the client validates its sessions
+	// at once, see SuspendConfig.
+	Resume()
+}
+
+// NetworkChangeNotifier is implemented by the clients, e.g. client.(getty.NetworkChangeNotifier).NetworkChanged(),
+// so the application can notify the client of the network changes reported by the system, e.g.
+// the connectivity callbacks of a mobile platform which restricts the netlink sockets.
+type NetworkChangeNotifier interface {
+	// notify the client that the network has changed, and the client closes and redials the
+	// affected sessions at once, see NetworkChangeConfig.
+	NetworkChanged()
+}
+
 type Server interface {
 	EndPoint
 	// get the network listener
 	Listener() net.Listener
+	// stop accepting new connections and keep the existing sessions alive, so the server can be
+	// removed from the load balancer before it is closed.
+	Drain()
+	// check whether the server is draining
+	IsDraining() bool
 }
@@ -0,0 +1,335 @@
+/******************************************************
+# DESC       : bounded handshake worker pool of server
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-30 15:20
+# FILE       : handshake.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	defaultHandshakeWorkers  = 32
+	defaultHandshakeQueueLen = 256
+)
+
+var (
+	errHandshakeAborted = jerrors.New("handshake aborted for server closed")
+	errHandshakeExpired = jerrors.New("handshake expired in queue")
+)
+
+// HandshakePoolConfig is the config of the handshake worker pool of a server. The accepted
+// connections are queued, and their handshakes, i.e. the negotiation of a tcp server, the tls
+// handshake of a wss server or the upgrade of a ws/wss server, run in the worker goroutines,
+// so that the slow or malicious handshakes can not stall the acceptance of new connections.
+type HandshakePoolConfig struct {
+	// the number of the worker goroutines. Its default value is 32.
+	Workers int
+	// the number of the connections waiting for a worker. A new connection is closed if the
+	// queue is full. Its default value is 256.
+	QueueLen int
+	// the deadline of a handshake since its connection is accepted, including the time waiting
+	// in the queue. Its default value is the timeout set by WithServerHandshakeTimeout, or 3s.
+	Timeout time.Duration
+}
+
+func (c HandshakePoolConfig) withDefaults() HandshakePoolConfig {
+	if c.Workers <= 0 {
+		c.Workers = defaultHandshakeWorkers
+	}
+	if c.QueueLen <= 0 {
+		c.QueueLen = defaultHandshakeQueueLen
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = connectTimeout
+	}
+
+	return c
+}
+
+// HandshakeFailureCause is the cause of a failed handshake of a server.
+type HandshakeFailureCause int
+
+const (
+	// the handshake did not complete before the handshake timeout, e.g. a slowloris client
+	HandshakeFailureTimeout HandshakeFailureCause = iota
+	// the peer closed or reset the connection during the handshake
+	HandshakeFailureClosed
+	// the tls handshake of a wss server failed
+	HandshakeFailureTLS
+	// the websocket upgrade request was rejected
+	HandshakeFailureUpgrade
+	// the compression & encryption negotiation of a tcp server failed
+	HandshakeFailureNegotiation
+
+	handshakeFailureCauseNum
+)
+
+func (c HandshakeFailureCause) String() string {
+	switch c {
+	case HandshakeFailureTimeout:
+		return "timeout"
+	case HandshakeFailureClosed:
+		return "closed"
+	case HandshakeFailureTLS:
+		return "tls"
+	case HandshakeFailureUpgrade:
+		return "upgrade"
+	case HandshakeFailureNegotiation:
+		return "negotiation"
+	}
+
+	return fmt.Sprintf("HandshakeFailureCause(%d)", int(c))
+}
+
+// HandshakeFailureStats is the number of the failed handshakes of a server by cause.
+type HandshakeFailureStats struct {
+	Timeout     uint64
+	Closed      uint64
+	TLS         uint64
+	Upgrade     uint64
+	Negotiation uint64
+}
+
+func (s HandshakeFailureStats) String() string {
+	return fmt.Sprintf("{timeout:%d, closed:%d, tls:%d, upgrade:%d, negotiation:%d}",
+		s.Timeout, s.Closed, s.TLS, s.Upgrade, s.Negotiation)
+}
+
+// get the cause of the handshake error @err. @cause is returned if @err is neither a
+// timeout nor a closed connection.
+func handshakeFailureCause(err error, cause HandshakeFailureCause) HandshakeFailureCause {
+	e := jerrors.Cause(err)
+	if netErr, ok := e.(net.Error); ok && netErr.Timeout() {
+		return HandshakeFailureTimeout
+	}
+	switch {
+	case errors.Is(e, ErrHandshakeTimeout), errors.Is(e, errHandshakeExpired):
+		return HandshakeFailureTimeout
+	case errors.Is(e, io.EOF), errors.Is(e, io.ErrUnexpectedEOF),
+		errors.Is(e, syscall.ECONNRESET), errors.Is(e, syscall.EPIPE), errors.Is(e, net.ErrClosed):
+		return HandshakeFailureClosed
+	}
+
+	return cause
+}
+
+// count the handshake error @err by its cause
+func (m *EndPointMetrics) recordHandshakeFailure(err error, cause HandshakeFailureCause) {
+	atomic.AddUint64(&m.handshakeFailures[handshakeFailureCause(err, cause)], 1)
+}
+
+type handshakeTask struct {
+	deadline time.Time
+	// run the handshake. @drop is invoked if it returns an error.
+	run func(deadline time.Time) error
+	// release the connection if the handshake is not run or fails
+	drop func()
+}
+
+type handshakePool struct {
+	config  HandshakePoolConfig
+	metrics *EndPointMetrics
+	queue   chan handshakeTask
+
+	once sync.Once
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newHandshakePool(config *HandshakePoolConfig, metrics *EndPointMetrics) *handshakePool {
+	if config == nil {
+		return nil
+	}
+
+	c := config.withDefaults()
+	p := &handshakePool{
+		config:  c,
+		metrics: metrics,
+		queue:   make(chan handshakeTask, c.QueueLen),
+		done:    make(chan struct{}),
+	}
+	p.wg.Add(c.Workers)
+	for i := 0; i < c.Workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// put a handshake into the queue. It returns false and drops the handshake if the queue is
+// full or the pool has been closed.
+func (p *handshakePool) submit(run func(deadline time.Time) error, drop func()) bool {
+	task := handshakeTask{deadline: time.Now().Add(p.config.Timeout), run: run, drop: drop}
+	select {
+	case <-p.done:
+	default:
+		select {
+		case p.queue <- task:
+			return true
+		default:
+		}
+	}
+
+	atomic.AddUint64(&p.metrics.rejectedHandshakeNum, 1)
+	drop()
+	return false
+}
+
+func (p *handshakePool) work() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case task := <-p.queue:
+			p.handshake(task)
+		}
+	}
+}
+
+func (p *handshakePool) handshake(task handshakeTask) {
+	var err error
+	if time.Now().Before(task.deadline) {
+		err = task.run(task.deadline)
+	} else {
+		err = errHandshakeExpired
+		p.metrics.recordHandshakeFailure(err, HandshakeFailureTimeout)
+	}
+	if err != nil {
+		atomic.AddUint64(&p.metrics.failedHandshakeNum, 1)
+		log.Warn("[handshakePool.handshake] error:%s", jerrors.ErrorStack(err))
+		task.drop()
+	}
+}
+
+// stop the workers and drop the queued handshakes
+func (p *handshakePool) close() {
+	p.once.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+		for {
+			select {
+			case task := <-p.queue:
+				task.drop()
+			default:
+				return
+			}
+		}
+	})
+}
+
+// handshakeListener is the tls listener of a wss server whose tls handshakes run in the
+// handshake pool, or in a goroutine per connection if the pool is nil. Its Accept returns
+// the connections whose handshakes have completed before the handshake timeout.
+type handshakeListener struct {
+	net.Listener
+	pool    *handshakePool
+	config  *tls.Config
+	timeout time.Duration
+	metrics *EndPointMetrics
+	audit   *AuditLog
+	tarpit  *tarpit
+	ready   chan net.Conn
+	err     chan error
+	done    chan struct{}
+}
+
+func newHandshakeListener(l net.Listener, s *server, config *tls.Config) *handshakeListener {
+	hl := &handshakeListener{
+		Listener: l,
+		pool:     s.handshakes,
+		config:   config,
+		timeout:  s.getHandshakeTimeout(),
+		metrics:  s.metrics,
+		audit:    s.auditLog,
+		tarpit:   s.tarpit,
+		ready:    make(chan net.Conn),
+		err:      make(chan error, 1),
+		done:     s.done,
+	}
+	go hl.accept()
+
+	return hl
+}
+
+func (l *handshakeListener) accept() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				<-wheel.After(5 * time.Millisecond)
+				continue
+			}
+			l.err <- err
+			return
+		}
+
+		if l.pool != nil {
+			l.pool.submit(func(deadline time.Time) error {
+				return l.handshake(conn, deadline)
+			}, func() { l.tarpit.close(conn) })
+			continue
+		}
+		go func() {
+			var deadline time.Time
+			if l.timeout > 0 {
+				deadline = time.Now().Add(l.timeout)
+			}
+			if err := l.handshake(conn, deadline); err != nil {
+				log.Warn("[handshakeListener.handshake] error:%s", jerrors.ErrorStack(err))
+				l.tarpit.close(conn)
+			}
+		}()
+	}
+}
+
+func (l *handshakeListener) handshake(conn net.Conn, deadline time.Time) error {
+	tlsConn := tls.Server(conn, l.config)
+	conn.SetDeadline(deadline)
+	if err := tlsConn.Handshake(); err != nil {
+		l.metrics.recordHandshakeFailure(err, HandshakeFailureTLS)
+		if handshakeFailureCause(err, HandshakeFailureTLS) == HandshakeFailureTLS {
+			l.audit.emit(AuditRecord{Event: AuditTLSError, LocalAddr: conn.LocalAddr().String(),
+				RemoteAddr: conn.RemoteAddr().String(), Detail: err.Error()})
+			l.tarpit.reject(conn, tarpitFailedHandshake)
+		}
+		return jerrors.Annotatef(err, "tls handshake with %s", conn.RemoteAddr())
+	}
+	conn.SetDeadline(time.Time{})
+	select {
+	case l.ready <- tlsConn:
+		return nil
+	case <-l.done:
+		return errHandshakeAborted
+	}
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.ready:
+		return conn, nil
+	case err := <-l.err:
+		l.err <- err
+		return nil, err
+	}
+}
@@ -0,0 +1,194 @@
+package getty
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandshakePool(t *testing.T) {
+	assert.Nil(t, newHandshakePool(nil, nil))
+
+	metrics := newEndPointMetrics(0)
+	p := newHandshakePool(&HandshakePoolConfig{Workers: 1, QueueLen: 1, Timeout: time.Second}, metrics)
+	defer p.close()
+
+	var (
+		block   = make(chan struct{})
+		running = make(chan struct{})
+		ran     = make(chan struct{}, 2)
+		dropped = make(chan int, 4)
+	)
+	assert.True(t, p.submit(func(time.Time) error {
+		close(running)
+		<-block
+		ran <- struct{}{}
+		return nil
+	}, func() { dropped <- 1 }))
+	<-running
+	assert.True(t, p.submit(func(time.Time) error {
+		ran <- struct{}{}
+		return errHandshakeAborted
+	}, func() { dropped <- 2 }))
+	// the only worker is busy and the queue is full
+	assert.False(t, p.submit(func(time.Time) error { return nil }, func() { dropped <- 3 }))
+	assert.Equal(t, 3, <-dropped)
+	assert.Equal(t, uint64(1), metrics.RejectedHandshakeNum())
+
+	close(block)
+	<-ran
+	<-ran
+	// the failed handshake is dropped
+	assert.Equal(t, 2, <-dropped)
+	assert.Equal(t, uint64(1), metrics.FailedHandshakeNum())
+}
+
+func TestHandshakePoolExpired(t *testing.T) {
+	metrics := newEndPointMetrics(0)
+	p := newHandshakePool(&HandshakePoolConfig{Workers: 1, Timeout: 10 * time.Millisecond}, metrics)
+
+	block := make(chan struct{})
+	dropped := make(chan struct{}, 1)
+	p.submit(func(time.Time) error { <-block; return nil }, func() {})
+	p.submit(func(time.Time) error {
+		t.Error("the expired handshake should not run")
+		return nil
+	}, func() { dropped <- struct{}{} })
+	time.Sleep(20 * time.Millisecond)
+	close(block)
+	<-dropped
+	assert.Equal(t, uint64(1), metrics.FailedHandshakeNum())
+
+	p.close()
+	dropped = make(chan struct{}, 1)
+	assert.False(t, p.submit(func(time.Time) error { return nil }, func() { dropped <- struct{}{} }))
+	<-dropped
+}
+
+func TestTCPServerHandshakePool(t *testing.T) {
+	var serverMsgHandler, msgHandler MessageHandler
+
+	config := &NegotiationConfig{Timeout: 5 * time.Second}
+	server := newServer(TCP_SERVER,
+		WithLocalAddress("127.0.0.1:0"),
+		WithServerNegotiation(config),
+		WithServerHandshakePool(&HandshakePoolConfig{Workers: 2, Timeout: 5 * time.Second}),
+	)
+	server.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &serverMsgHandler)
+	})
+	defer server.Close()
+
+	// a silent peer holds one worker, but does not stall the acceptance
+	silent, err := net.Dial("tcp", server.streamListener.Addr().String())
+	assert.Nil(t, err)
+	defer silent.Close()
+
+	clt := newClient(TCP_CLIENT,
+		WithServerAddress(server.streamListener.Addr().String()),
+		WithConnectionNumber(1),
+		WithNegotiation(config),
+	)
+	clt.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &msgHandler)
+	})
+	defer clt.Close()
+
+	assert.Equal(t, 1, msgHandler.SessionNumber())
+	for i := 0; i < 100 && serverMsgHandler.SessionNumber() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, serverMsgHandler.SessionNumber())
+}
+
+func newTestTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "getty"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestHandshakeFailureCause(t *testing.T) {
+	for _, c := range []struct {
+		err   error
+		cause HandshakeFailureCause
+	}{
+		{newGettyError(ErrHandshakeTimeout, nil), HandshakeFailureTimeout},
+		{errHandshakeExpired, HandshakeFailureTimeout},
+		{&net.OpError{Op: "read", Err: timeoutError{}}, HandshakeFailureTimeout},
+		{io.EOF, HandshakeFailureClosed},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, HandshakeFailureClosed},
+		{ErrNegotiationFailed, HandshakeFailureNegotiation},
+	} {
+		assert.Equal(t, c.cause, handshakeFailureCause(c.err, HandshakeFailureNegotiation), c.err.Error())
+	}
+
+	m := newEndPointMetrics(0)
+	m.recordHandshakeFailure(io.EOF, HandshakeFailureTLS)
+	m.recordHandshakeFailure(errIllegalMsgFrame, HandshakeFailureTLS)
+	assert.Equal(t, HandshakeFailureStats{Closed: 1, TLS: 1}, m.HandshakeFailures())
+	assert.Equal(t, "{timeout:0, closed:1, tls:1, upgrade:0, negotiation:0}", m.HandshakeFailures().String())
+}
+
+func TestHandshakeListener(t *testing.T) {
+	s := newServer(WSS_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerHandshakeTimeout(50*time.Millisecond))
+	defer s.stop()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	config := newTestTLSConfig(t)
+	hl := newHandshakeListener(ln, s, config)
+
+	// a slowloris client is closed after the handshake timeout
+	slow, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer slow.Close()
+	slow.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = slow.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	// a plain text client fails the tls handshake
+	plain, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer plain.Close()
+	plain.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+
+	clt, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	assert.Nil(t, err)
+	defer clt.Close()
+	conn, err := hl.Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.True(t, conn.(*tls.Conn).ConnectionState().HandshakeComplete)
+
+	for i := 0; i < 100 && s.metrics.HandshakeFailures().TLS == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, HandshakeFailureStats{Timeout: 1, TLS: 1}, s.metrics.HandshakeFailures())
+
+	ln.Close()
+	_, err = hl.Accept()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,155 @@
+/******************************************************
+# DESC       : session id generators
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-15 10:32
+# FILE       : id.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	snowflakeMaxNode  = 1<<snowflakeNodeBits - 1
+	snowflakeSeqMask  = 1<<snowflakeSeqBits - 1
+)
+
+var (
+	// 2020-01-01 00:00:00 UTC, in milliseconds
+	snowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+)
+
+// SessionIDGenerator generates the session ids, which can be got by (SessionInspector)SessionID.
+// It is set by WithServerSessionIDGenerator/WithClientSessionIDGenerator, and NextID
+// may be invoked by many goroutines concurrently.
+type SessionIDGenerator interface {
+	NextID() string
+}
+
+// the session id of @ss, which is its decimal ID if @ss is not a SessionInspector
+func sessionID(ss Session) string {
+	if inspector, ok := ss.(SessionInspector); ok {
+		return inspector.SessionID()
+	}
+
+	return strconv.FormatUint(uint64(ss.ID()), 10)
+}
+
+// SessionIDGeneratorFunc adapts a func to SessionIDGenerator.
+type SessionIDGeneratorFunc func() string
+
+func (f SessionIDGeneratorFunc) NextID() string {
+	return f()
+}
+
+/////////////////////////////////////////
+// 64-bit sequence
+/////////////////////////////////////////
+
+type sequenceIDGenerator struct {
+	seq uint64
+}
+
+// NewSequenceIDGenerator generates 64-bit sequence ids which start from @start + 1. The ids will
+// not wrap as the uint32 Connection.ID, but they may collide across restarts if @start is fixed.
+func NewSequenceIDGenerator(start uint64) SessionIDGenerator {
+	return &sequenceIDGenerator{seq: start}
+}
+
+func (g *sequenceIDGenerator) NextID() string {
+	return strconv.FormatUint(atomic.AddUint64(&g.seq, 1), 10)
+}
+
+/////////////////////////////////////////
+// snowflake
+/////////////////////////////////////////
+
+type snowflakeIDGenerator struct {
+	lock sync.Mutex
+	node int64
+	last int64 // last timestamp, in milliseconds
+	seq  int64
+}
+
+// NewSnowflakeIDGenerator generates 64-bit snowflake ids, which consist of a 41-bit millisecond
+// timestamp, a 10-bit @node and a 12-bit sequence. @node should be unique among the processes
+// whose session ids may be mixed, and its range is [0, 1023].
+func NewSnowflakeIDGenerator(node int64) (SessionIDGenerator, error) {
+	if node < 0 || snowflakeMaxNode < node {
+		return nil, jerrors.Errorf("snowflake node %d is out of range [0, %d]", node, snowflakeMaxNode)
+	}
+
+	return &snowflakeIDGenerator{node: node}, nil
+}
+
+func (g *snowflakeIDGenerator) NextID() string {
+	g.lock.Lock()
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if now <= g.last {
+		// the clock goes backwards or in the same millisecond
+		now = g.last
+		g.seq = (g.seq + 1) & snowflakeSeqMask
+		if g.seq == 0 {
+			// borrow the next millisecond if the sequence is exhausted
+			now++
+		}
+	} else {
+		g.seq = 0
+	}
+	g.last = now
+	id := (now-snowflakeEpoch)<<(snowflakeNodeBits+snowflakeSeqBits) | g.node<<snowflakeSeqBits | g.seq
+	g.lock.Unlock()
+
+	return strconv.FormatInt(id, 10)
+}
+
+/////////////////////////////////////////
+// uuid
+/////////////////////////////////////////
+
+type uuidGenerator struct{}
+
+// NewUUIDGenerator generates random(version 4) uuids, e.g. "3b241101-e2bb-4255-8caf-4136c566a962".
+func NewUUIDGenerator() SessionIDGenerator {
+	return uuidGenerator{}
+}
+
+func (uuidGenerator) NextID() string {
+	var (
+		u   [16]byte
+		buf [36]byte
+	)
+
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(jerrors.ErrorStack(jerrors.Annotate(err, "crypto/rand.Read")))
+	}
+	u[6] = u[6]&0x0f | 0x40 // version 4
+	u[8] = u[8]&0x3f | 0x80 // variant 10
+
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+
+	return string(buf[:])
+}
@@ -0,0 +1,53 @@
+package getty
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequenceIDGenerator(t *testing.T) {
+	g := NewSequenceIDGenerator(1 << 32)
+	assert.Equal(t, "4294967297", g.NextID())
+	assert.Equal(t, "4294967298", g.NextID())
+}
+
+func TestSnowflakeIDGenerator(t *testing.T) {
+	_, err := NewSnowflakeIDGenerator(snowflakeMaxNode + 1)
+	assert.NotNil(t, err)
+
+	g, err := NewSnowflakeIDGenerator(7)
+	assert.Nil(t, err)
+	var last int64
+	for i := 0; i < 10000; i++ {
+		id, err := strconv.ParseInt(g.NextID(), 10, 64)
+		assert.Nil(t, err)
+		assert.True(t, last < id)
+		assert.Equal(t, int64(7), id>>snowflakeSeqBits&snowflakeMaxNode)
+		last = id
+	}
+}
+
+func TestUUIDGenerator(t *testing.T) {
+	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	g := NewUUIDGenerator()
+	id := g.NextID()
+	assert.Regexp(t, re, id)
+	assert.NotEqual(t, id, g.NextID())
+}
+
+func TestSessionID(t *testing.T) {
+	srv := newServer(TCP_SERVER,
+		WithLocalAddress("127.0.0.1:0"),
+		WithServerSessionIDGenerator(SessionIDGeneratorFunc(func() string { return "ss-1" })),
+	)
+	ss := newSession(srv, &gettyTCPConn{gettyConn: gettyConn{id: 3}})
+	assert.Equal(t, "ss-1", ss.SessionID())
+
+	ss = newSession(nil, &gettyTCPConn{gettyConn: gettyConn{id: 3}})
+	assert.Equal(t, "3", ss.SessionID())
+}
@@ -0,0 +1,160 @@
+/******************************************************
+# DESC       : handshake-time client identity of sessions
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-11 16:20
+# FILE       : identity.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+var errNoIdentity = jerrors.New("no identity in the first pkg")
+
+// Identity is the authenticated identity of the client of a session, which is got by
+// (SessionInspector)Identity in the Policy, the EventListener and the codec.
+type Identity interface {
+	// the authenticated principal, e.g. the subject of the client certificate or the user of the token
+	Principal() string
+	// the tenant of the principal, whose resource group limits the session, see IdentityConfig
+	Tenant() string
+}
+
+type basicIdentity struct {
+	principal string
+	tenant    string
+}
+
+// NewIdentity returns the Identity of @principal in @tenant.
+func NewIdentity(principal, tenant string) Identity {
+	return basicIdentity{principal: principal, tenant: tenant}
+}
+
+func (i basicIdentity) Principal() string {
+	return i.principal
+}
+
+func (i basicIdentity) Tenant() string {
+	return i.tenant
+}
+
+func (i basicIdentity) String() string {
+	return fmt.Sprintf("{principal:%s, tenant:%s}", i.principal, i.tenant)
+}
+
+// IdentityRequest is the credentials of the client of a session.
+type IdentityRequest struct {
+	Session Session
+	// the verified client certificate chains of a wss session whose server requires the
+	// client certificates, see WithWebsocketServerRootCert
+	PeerCertificates []*x509.Certificate
+	// the upgrade request of a ws/wss session, whose header or query carries the token
+	Request *http.Request
+	// the first pkg of the session decoded by its codec, which is only set if the
+	// identification at handshake is deferred
+	FirstPkg interface{}
+}
+
+// IdentityProvider identifies the client of a session.
+type IdentityProvider interface {
+	// identify the client at handshake, before the Handshake of the Policy and NewSessionCallback.
+	// The session is rejected if it returns an error. A nil Identity with a nil error defers
+	// the identification to the first pkg of the session, which is consumed by Identify and
+	// not delivered to the EventListener, and the session is closed if no Identity is got then.
+	Identify(IdentityRequest) (Identity, error)
+}
+
+// IdentityProviderFunc is the IdentityProvider of a function.
+type IdentityProviderFunc func(IdentityRequest) (Identity, error)
+
+func (f IdentityProviderFunc) Identify(req IdentityRequest) (Identity, error) {
+	return f(req)
+}
+
+// IdentityConfig is the client identification config of a server.
+type IdentityConfig struct {
+	Provider IdentityProvider
+	// the identified session joins the resource group of its tenant, whose session limit is
+	// the quota & whose bandwidth limit is the rate limit of the tenant. nil means no group.
+	ResourceGroups *ResourceGroups
+}
+
+// Identity returns the identity of the client of the session, or nil if it is not identified.
+func (s *session) Identity() Identity {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.identity
+}
+
+// identify the client at handshake. @r is the upgrade request of a ws/wss session.
+func (s *session) identify(r *http.Request) error {
+	if s.identityConfig == nil {
+		return nil
+	}
+
+	req := IdentityRequest{Session: s, Request: r}
+	if r != nil && r.TLS != nil {
+		req.PeerCertificates = r.TLS.PeerCertificates
+	}
+	id, err := s.identityConfig.Provider.Identify(req)
+	if err != nil {
+		s.audit(AuditAuthFailure, err.Error())
+		return newGettyError(ErrUnauthenticated, err)
+	}
+	if id == nil {
+		s.identityPending = true
+		return nil
+	}
+
+	return s.setIdentity(id)
+}
+
+// attach @id to the session, which then joins the resource group of its tenant. The identity
+// is attached before joining, so that the quota violation is audited with the principal.
+func (s *session) setIdentity(id Identity) error {
+	s.lock.Lock()
+	s.identity = id
+	s.lock.Unlock()
+	s.audit(AuditAuthSuccess, "")
+
+	if groups := s.identityConfig.ResourceGroups; groups != nil {
+		return s.JoinResourceGroup(groups, id.Tenant())
+	}
+	return nil
+}
+
+// identify the client by the first pkg if the identification is deferred at handshake, and
+// return the pkgs to be delivered. The session is closed if it fails.
+func (s *session) identifyFirstPkg(pkgs []interface{}) []interface{} {
+	if !s.identityPending || len(pkgs) == 0 {
+		return pkgs
+	}
+
+	s.identityPending = false
+	id, err := s.identityConfig.Provider.Identify(IdentityRequest{Session: s, FirstPkg: pkgs[0]})
+	if err == nil && id == nil {
+		err = errNoIdentity
+	}
+	if err != nil {
+		s.audit(AuditAuthFailure, err.Error())
+	} else {
+		err = s.setIdentity(id)
+	}
+	if err != nil {
+		sampledWarn("%s, [session.identifyFirstPkg] error:%s", s.sessionToken(), jerrors.ErrorStack(err))
+		s.stop()
+		return nil
+	}
+
+	return pkgs[1:]
+}
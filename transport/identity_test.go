@@ -0,0 +1,101 @@
+package getty
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// identify the client by the first pkg "login user@tenant", or reject it at handshake
+func newTestIdentityProvider(reject *int32) IdentityProvider {
+	return IdentityProviderFunc(func(req IdentityRequest) (Identity, error) {
+		if req.FirstPkg == nil {
+			if atomic.LoadInt32(reject) != 0 {
+				return nil, errors.New("banned client")
+			}
+			return nil, nil
+		}
+
+		line := req.FirstPkg.(string)
+		if !strings.HasPrefix(line, "login ") {
+			return nil, errors.New("login required")
+		}
+		fields := strings.SplitN(strings.TrimPrefix(line, "login "), "@", 2)
+		return NewIdentity(fields[0], fields[1]), nil
+	})
+}
+
+func TestServerIdentity(t *testing.T) {
+	var (
+		reject   int32
+		groups   = NewResourceGroups(ResourceGroupLimits{MaxSessions: 1})
+		sessions = make(chan Session, 4)
+		listener = &lineListener{msgs: make(chan interface{}, 4)}
+	)
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerIdentity(&IdentityConfig{
+		Provider:       newTestIdentityProvider(&reject),
+		ResourceGroups: groups,
+	}))
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(listener)
+		ss.SetWQLen(4)
+		sessions <- ss
+		return nil
+	})
+	defer server.Close()
+	addr := server.streamListener.Addr().String()
+
+	// the first pkg is consumed by the identity provider
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.Write([]byte("login alice@acme\nhello\n"))
+	assert.Equal(t, "hello", <-listener.msgs)
+	ss := <-sessions
+	assert.Equal(t, "alice", ss.(SessionInspector).Identity().Principal())
+	assert.Equal(t, "acme", ss.(SessionInspector).Identity().Tenant())
+	assert.Equal(t, int64(1), groups.Stats()["acme"].Sessions)
+
+	// the session limit of the tenant is reached
+	reader := func(conn net.Conn) error {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := conn.Read(make([]byte, 1))
+		return err
+	}
+	conn2, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn2.Close()
+	conn2.Write([]byte("login bob@acme\nhello\n"))
+	assert.NotNil(t, reader(conn2))
+	// the identity is attached before the session is rejected
+	assert.Equal(t, "bob", (<-sessions).(SessionInspector).Identity().Principal())
+
+	// no identity in the first pkg
+	conn3, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn3.Close()
+	conn3.Write([]byte("hello\n"))
+	assert.NotNil(t, reader(conn3))
+	<-sessions
+
+	// rejected at handshake
+	atomic.StoreInt32(&reject, 1)
+	conn4, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn4.Close()
+	assert.NotNil(t, reader(conn4))
+	select {
+	case <-sessions:
+		t.Fatal("the rejected client gets a session")
+	default:
+	}
+	assert.Equal(t, 0, len(listener.msgs))
+}
@@ -0,0 +1,172 @@
+/******************************************************
+# DESC       : ipv4/ipv6 stack of the server listeners
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-07 17:30
+# FILE       : ipstack.go
+******************************************************/
+
+package getty
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+// IPStack is the ip version of the listener of a tcp/ws/wss/udp server.
+type IPStack int
+
+const (
+	// the platform behavior of go: a wildcard address, including 0.0.0.0, listens on both
+	// ipv4 & ipv6 if the platform supports the ipv4-mapped ipv6 addresses, otherwise on ipv4.
+	IPStackDefault IPStack = iota
+	// listen on ipv4 only. A wildcard address listens on 0.0.0.0.
+	IPStackIPv4Only
+	// listen on ipv6 only, i.e. IPV6_V6ONLY is set. A wildcard address listens on [::].
+	IPStackIPv6Only
+	// listen on both ipv4 & ipv6 by an ipv6 socket whose IPV6_V6ONLY is cleared, regardless of
+	// the sysctl net.ipv6.bindv6only on linux. The address should be a wildcard one, and the
+	// listen fails instead of falling back to ipv4 if the platform has no ipv6, e.g. in a
+	// container whose ipv6 is disabled. The ipv4 peers are formatted as ipv4 addresses.
+	IPStackDualStack
+)
+
+func (s IPStack) String() string {
+	switch s {
+	case IPStackDefault:
+		return "default"
+	case IPStackIPv4Only:
+		return "ipv4-only"
+	case IPStackIPv6Only:
+		return "ipv6-only"
+	case IPStackDualStack:
+		return "dual-stack"
+	}
+
+	return fmt.Sprintf("IPStack(%d)", int(s))
+}
+
+var (
+	errDualStackAddr        = jerrors.New("dual-stack listener needs a wildcard address")
+	errDualStackUnsupported = jerrors.New("dual-stack listener is not supported by the platform")
+)
+
+// get the network & the address to listen on @addr by @stack. @network is "tcp" or "udp".
+func listenNetwork(stack IPStack, network string, addr string) (string, string, error) {
+	switch stack {
+	case IPStackIPv4Only:
+		return network + "4", addr, nil
+	case IPStackIPv6Only:
+		return network + "6", addr, nil
+	case IPStackDualStack:
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return "", "", jerrors.Trace(err)
+		}
+		if host != "" {
+			if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+				return "", "", jerrors.Annotatef(errDualStackAddr, "addr:%s", addr)
+			}
+		}
+		// the wildcard address of go chooses an ipv6 socket if the platform admits dual-stack
+		return network, net.JoinHostPort("::", port), nil
+	}
+
+	return network, addr, nil
+}
+
+// listen on @addr by @stack. @network is "tcp" or "udp".
+func listenByStack(stack IPStack, network string, addr string) (net.Listener, net.PacketConn, error) {
+	network, addr, err := listenNetwork(stack, network, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := net.ListenConfig{}
+	if stack == IPStackDualStack {
+		config.Control = controlDualStack
+	}
+	var (
+		l     net.Listener
+		conn  net.PacketConn
+		local net.Addr
+	)
+	if network == "udp" || network[:len(network)-1] == "udp" {
+		if conn, err = config.ListenPacket(context.Background(), network, addr); err != nil {
+			return nil, nil, jerrors.Annotatef(err, "ListenPacket(%s, addr:%s)", network, addr)
+		}
+		local = conn.LocalAddr()
+	} else {
+		if l, err = config.Listen(context.Background(), network, addr); err != nil {
+			return nil, nil, jerrors.Annotatef(err, "Listen(%s, addr:%s)", network, addr)
+		}
+		local = l.Addr()
+	}
+	// go falls back to an ipv4 socket if the platform has no ipv4-mapped ipv6 addresses
+	if stack == IPStackDualStack && addrIP(local).To4() != nil {
+		if l != nil {
+			l.Close()
+		} else {
+			conn.Close()
+		}
+		return nil, nil, jerrors.Annotatef(errDualStackUnsupported, "local addr:%s", local)
+	}
+
+	return l, conn, nil
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+
+	return nil
+}
+
+// format the tcp/udp address @addr. The ipv4-mapped ipv6 addresses of the ipv4 peers of a
+// dual-stack listener are formatted as ipv4 addresses, and the ipv6 addresses are bracketed
+// with their zones, e.g. "[fe80::1%eth0]:80", on all platforms.
+func formatAddr(addr net.Addr) string {
+	var (
+		ip   net.IP
+		port int
+		zone string
+	)
+	switch a := addr.(type) {
+	case nil:
+		return ""
+	case *net.TCPAddr:
+		if a == nil {
+			return ""
+		}
+		ip, port, zone = a.IP, a.Port, a.Zone
+	case *net.UDPAddr:
+		if a == nil {
+			return ""
+		}
+		ip, port, zone = a.IP, a.Port, a.Zone
+	default:
+		return addr.String()
+	}
+
+	var host string
+	if ip4 := ip.To4(); ip4 != nil {
+		host = ip4.String()
+	} else if len(ip) > 0 {
+		host = ip.String()
+		if zone != "" {
+			host += "%" + zone
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
@@ -0,0 +1,35 @@
+//go:build linux
+// +build linux
+
+/******************************************************
+# DESC       : ipv4/ipv6 stack of the server listeners on linux
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-07 17:30
+# FILE       : ipstack_linux.go
+******************************************************/
+
+package getty
+
+import (
+	"syscall"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+// clear IPV6_V6ONLY of the listener socket before it binds, regardless of the sysctl
+// net.ipv6.bindv6only
+func controlDualStack(network, address string, c syscall.RawConn) error {
+	var opErr error
+	err := c.Control(func(fd uintptr) {
+		opErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 0)
+	})
+	if err == nil {
+		err = opErr
+	}
+
+	return jerrors.Annotatef(err, "clear IPV6_V6ONLY of %s listener on %s", network, address)
+}
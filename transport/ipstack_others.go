@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+/******************************************************
+# DESC       : ipv4/ipv6 stack of the server listeners on other platforms
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-07 17:30
+# FILE       : ipstack_others.go
+******************************************************/
+
+package getty
+
+import (
+	"syscall"
+)
+
+// go clears IPV6_V6ONLY of the wildcard ipv6 sockets on the platforms which admit dual-stack,
+// and the listener falls back to ipv4, which is rejected by listenByStack, on the others.
+func controlDualStack(network, address string, c syscall.RawConn) error {
+	return nil
+}
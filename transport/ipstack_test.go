@@ -0,0 +1,87 @@
+package getty
+
+import (
+	"net"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenNetwork(t *testing.T) {
+	for _, c := range []struct {
+		stack   IPStack
+		addr    string
+		network string
+		listen  string
+		err     bool
+	}{
+		{IPStackDefault, ":80", "tcp", ":80", false},
+		{IPStackIPv4Only, ":80", "tcp4", ":80", false},
+		{IPStackIPv6Only, "[::]:80", "tcp6", "[::]:80", false},
+		{IPStackDualStack, ":80", "tcp", "[::]:80", false},
+		{IPStackDualStack, "0.0.0.0:80", "tcp", "[::]:80", false},
+		{IPStackDualStack, "127.0.0.1:80", "", "", true},
+	} {
+		network, addr, err := listenNetwork(c.stack, "tcp", c.addr)
+		assert.Equal(t, c.err, err != nil, c.stack)
+		assert.Equal(t, c.network, network, c.stack)
+		assert.Equal(t, c.listen, addr, c.stack)
+	}
+}
+
+func TestFormatAddr(t *testing.T) {
+	assert.Equal(t, "", formatAddr(nil))
+	assert.Equal(t, "", formatAddr((*net.TCPAddr)(nil)))
+	assert.Equal(t, "127.0.0.1:80", formatAddr(&net.TCPAddr{IP: net.ParseIP("::ffff:127.0.0.1"), Port: 80}))
+	assert.Equal(t, "[fe80::1%eth0]:80", formatAddr(&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 80, Zone: "eth0"}))
+	assert.Equal(t, ":80", formatAddr(&net.TCPAddr{Port: 80}))
+}
+
+func hasIPv6Loopback() bool {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+func TestListenByStack(t *testing.T) {
+	l, _, err := listenByStack(IPStackIPv4Only, "tcp", ":0")
+	assert.Nil(t, err)
+	assert.NotNil(t, l.Addr().(*net.TCPAddr).IP.To4())
+	l.Close()
+
+	if !hasIPv6Loopback() {
+		t.Skip("ipv6 is not available")
+	}
+
+	l, _, err = listenByStack(IPStackIPv6Only, "tcp", "[::]:0")
+	assert.Nil(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	_, err = net.Dial("tcp4", (&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}).String())
+	assert.NotNil(t, err)
+	l.Close()
+
+	l, _, err = listenByStack(IPStackDualStack, "tcp", ":0")
+	assert.Nil(t, err)
+	defer l.Close()
+	port = l.Addr().(*net.TCPAddr).Port
+	for _, ip := range []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback} {
+		conn, err := net.Dial("tcp", (&net.TCPAddr{IP: ip, Port: port}).String())
+		assert.Nil(t, err)
+		peer, err := l.Accept()
+		assert.Nil(t, err)
+		tcpConn := newGettyTCPConn(peer)
+		assert.Equal(t, formatAddr(conn.LocalAddr()), tcpConn.RemoteAddr())
+		conn.Close()
+		peer.Close()
+	}
+
+	_, conn, err := listenByStack(IPStackDualStack, "udp", ":0")
+	assert.Nil(t, err)
+	assert.Nil(t, conn.LocalAddr().(*net.UDPAddr).IP.To4())
+	conn.Close()
+}
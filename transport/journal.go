@@ -0,0 +1,314 @@
+/******************************************************
+# DESC       : session event journal for post-mortem analysis
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-29 10:30
+# FILE       : journal.go
+******************************************************/
+
+package getty
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	defaultJournalCapacity   = 1024
+	defaultJournalMaxSize    = 64 * 1024 * 1024
+	defaultJournalMaxBackups = 3
+)
+
+// JournalEvent is the type of a journal record.
+type JournalEvent int32
+
+const (
+	// the session has been opened, i.e. (EventListener)OnOpen returned nil
+	JournalOpen JournalEvent = iota
+	// the session goroutines have exited
+	JournalClose
+	// the session got a read/write error
+	JournalError
+)
+
+func (e JournalEvent) String() string {
+	switch e {
+	case JournalOpen:
+		return "open"
+	case JournalClose:
+		return "close"
+	case JournalError:
+		return "error"
+	}
+
+	return fmt.Sprintf("JournalEvent(%d)", int32(e))
+}
+
+// JournalRecord is a session event appended to a journal.
+type JournalRecord struct {
+	Time  time.Time
+	Event JournalEvent
+	// name:endpoint type:session id
+	Session    string
+	LocalAddr  string
+	RemoteAddr string
+	// the direction & message of a JournalError record
+	Direction ErrorDirection
+	Error     string
+	// the bytes read & written by the session, which are set in a JournalClose record
+	ReadBytes  uint32
+	WriteBytes uint32
+}
+
+// String formats the record as one line of the journal file.
+func (r JournalRecord) String() string {
+	line := fmt.Sprintf("%s %s {%s:%s<->%s}",
+		r.Time.Format(time.RFC3339Nano), r.Event, r.Session, r.LocalAddr, r.RemoteAddr)
+	switch r.Event {
+	case JournalError:
+		line += fmt.Sprintf(" %s error:%q", r.Direction, r.Error)
+	case JournalClose:
+		line += fmt.Sprintf(" read bytes:%d, write bytes:%d", r.ReadBytes, r.WriteBytes)
+	}
+
+	return line
+}
+
+// JournalConfig is the config of a session journal.
+type JournalConfig struct {
+	// the number of the latest records kept in memory. Its default value is 1024.
+	Capacity int
+	// the journal file the records are appended to. Empty Path means the records are only
+	// kept in memory.
+	Path string
+	// the journal file is rotated to Path.1 when it grows beyond MaxSize, Path.1 to Path.2,
+	// and so on. Its default value is 64MB.
+	MaxSize int64
+	// the number of the rotated files which are kept. Its default value is 3.
+	MaxBackups int
+}
+
+func (c JournalConfig) withDefaults() JournalConfig {
+	if c.Capacity <= 0 {
+		c.Capacity = defaultJournalCapacity
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = defaultJournalMaxSize
+	}
+	if c.MaxBackups <= 0 {
+		c.MaxBackups = defaultJournalMaxBackups
+	}
+
+	return c
+}
+
+// Journal records the lifecycle events & the errors of the sessions, so that the failed
+// connections can be reconstructed after a crash. One journal can be shared by all endpoints
+// by WithServerJournal/WithClientJournal.
+type Journal struct {
+	config JournalConfig
+
+	lock    sync.Mutex
+	records []JournalRecord
+	next    int
+	full    bool
+	file    *rotatingFile
+}
+
+// NewJournal builds a journal. If @config.Path is not empty, the journal file is opened
+// in append mode.
+func NewJournal(config JournalConfig) (*Journal, error) {
+	j := &Journal{config: config.withDefaults()}
+	j.records = make([]JournalRecord, j.config.Capacity)
+	if j.config.Path != "" {
+		file, err := openRotatingFile(j.config.Path, j.config.MaxSize, j.config.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		j.file = file
+	}
+
+	return j, nil
+}
+
+// Append appends @r to the journal. It is invoked by the sessions, and the application
+// can append its own records too.
+func (j *Journal) Append(r JournalRecord) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.records[j.next] = r
+	j.next++
+	if j.next == len(j.records) {
+		j.next, j.full = 0, true
+	}
+
+	if j.file == nil {
+		return nil
+	}
+	return j.file.writeLine(r.String())
+}
+
+// Records returns the records kept in memory, the oldest first.
+func (j *Journal) Records() []JournalRecord {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if !j.full {
+		return append([]JournalRecord(nil), j.records[:j.next]...)
+	}
+	records := make([]JournalRecord, 0, len(j.records))
+	records = append(records, j.records[j.next:]...)
+	return append(records, j.records[:j.next]...)
+}
+
+// Close syncs & closes the journal file. The records are still kept in memory.
+func (j *Journal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.close()
+	j.file = nil
+	return err
+}
+
+/////////////////////////////////////////
+// rotating file
+/////////////////////////////////////////
+
+// rotatingFile is a log file which is rotated to path.1 when it grows beyond maxSize, path.1
+// to path.2, and so on. It is shared by the journal & the access log, and is not goroutine safe.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// open @path in append mode
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return jerrors.Annotatef(err, "os.OpenFile(%s)", f.path)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return jerrors.Annotatef(err, "(*os.File)Stat(%s)", f.path)
+	}
+
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// rotate the file: path.{n-1} -> path.{n}, ..., path -> path.1
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+	for i := f.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return jerrors.Trace(err)
+	}
+
+	return f.open()
+}
+
+// append @line and a newline to the file, and rotate it if it is full
+func (f *rotatingFile) writeLine(line string) error {
+	if f.file == nil {
+		// the reopen failed after the last rotation
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.WriteString(line + "\n")
+	f.size += int64(n)
+	if err != nil {
+		return jerrors.Annotatef(err, "write file %s", f.path)
+	}
+	if f.size >= f.maxSize {
+		return f.rotate()
+	}
+
+	return nil
+}
+
+// sync & close the file
+func (f *rotatingFile) close() error {
+	if f.file == nil {
+		return nil
+	}
+	f.file.Sync()
+	err := f.file.Close()
+	f.file = nil
+	return jerrors.Trace(err)
+}
+
+/////////////////////////////////////////
+// session journal
+/////////////////////////////////////////
+
+// append the event of the session to its journal. @err is the error of a JournalError record.
+func (s *session) journalEvent(event JournalEvent, err error, direction ErrorDirection) {
+	j := s.journal
+	if j == nil {
+		return
+	}
+
+	r := JournalRecord{
+		Time:       time.Now(),
+		Event:      event,
+		Session:    s.sessionName(),
+		LocalAddr:  s.LocalAddr(),
+		RemoteAddr: s.RemoteAddr(),
+	}
+	switch event {
+	case JournalError:
+		r.Direction = direction
+		if err != nil {
+			r.Error = err.Error()
+		}
+	case JournalClose:
+		if conn := s.gettyConn(); conn != nil {
+			r.ReadBytes = atomic.LoadUint32(&conn.readBytes)
+			r.WriteBytes = atomic.LoadUint32(&conn.writeBytes)
+		}
+	}
+	if err := j.Append(r); err != nil {
+		log.Warn("%s, [session.journalEvent] append %s record error:%s", s.sessionToken(), event, err)
+	}
+}
+
+// name:endpoint type:session id
+func (s *session) sessionName() string {
+	var endPointType EndPointType
+	if s.endPoint != nil {
+		endPointType = s.endPoint.EndPointType()
+	}
+
+	return fmt.Sprintf("%s:%s:%s", s.name, endPointType, s.sessionID)
+}
@@ -0,0 +1,90 @@
+package getty
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalRing(t *testing.T) {
+	j, err := NewJournal(JournalConfig{Capacity: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(j.Records()))
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, j.Append(JournalRecord{Event: JournalError, Error: string(rune('a' + i))}))
+	}
+	records := j.Records()
+	assert.Equal(t, 3, len(records))
+	for i, r := range records {
+		assert.Equal(t, string(rune('c'+i)), r.Error)
+	}
+	assert.Nil(t, j.Close())
+}
+
+func TestJournalFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getty-journal")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "session.journal")
+	j, err := NewJournal(JournalConfig{Path: path, MaxSize: 200, MaxBackups: 2})
+	assert.Nil(t, err)
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, j.Append(JournalRecord{
+			Time:       time.Now(),
+			Event:      JournalOpen,
+			Session:    "journal:TCP_SERVER:1",
+			LocalAddr:  "127.0.0.1:80",
+			RemoteAddr: "127.0.0.1:1024",
+		}))
+	}
+	assert.Nil(t, j.Close())
+
+	files, err := filepath.Glob(path + "*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{path, path + ".1", path + ".2"}, files)
+	data, err := ioutil.ReadFile(path + ".1")
+	assert.Nil(t, err)
+	assert.True(t, len(data) >= 200)
+	assert.True(t, strings.HasSuffix(strings.Split(string(data), "\n")[0], " open {journal:TCP_SERVER:1:127.0.0.1:80<->127.0.0.1:1024}"))
+}
+
+func TestSessionJournal(t *testing.T) {
+	j, err := NewJournal(JournalConfig{})
+	assert.Nil(t, err)
+
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+	src, dst := newPipeSessions(t)
+	src.journal = j
+	for _, ss := range []*session{src, dst} {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(listener)
+		ss.run()
+	}
+
+	assert.Nil(t, src.WritePkg("hello", 0))
+	assert.Equal(t, "hello", <-listener.msgs)
+	src.notifyError(errors.New("broken"), ErrorDirectionWrite)
+	src.Close()
+	<-src.wDone
+	dst.Close()
+
+	records := j.Records()
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, JournalOpen, records[0].Event)
+	assert.Equal(t, JournalError, records[1].Event)
+	assert.Equal(t, ErrorDirectionWrite, records[1].Direction)
+	assert.Equal(t, "broken", records[1].Error)
+	assert.Equal(t, JournalClose, records[2].Event)
+	assert.Equal(t, uint32(6), records[2].WriteBytes)
+	assert.Equal(t, records[0].Session, records[2].Session)
+}
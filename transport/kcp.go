@@ -0,0 +1,166 @@
+/******************************************************
+# DESC       : kcp transport
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-23 15:00
+# FILE       : kcp.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+// The sessions over kcp, i.e. the reliable udp, run like the ones of the custom transports,
+// so the codecs, the compressions, the counters & the deadlines work as the ones of tcp.
+// getty does not depend on any kcp implementation. The conns of kcp-go implement KCPConn,
+// and its listener is a net.Listener, e.g.
+//
+//	dialer := getty.KCPDialer(func(addr string) (net.Conn, error) {
+//		return kcp.DialWithOptions(addr, block, 10, 3)
+//	}, getty.KCPConfig{NoDelay: true})
+//	client := getty.NewTransportClient(dialer, getty.WithServerAddress("10.0.0.8:10000"))
+//
+//	listener, _ := kcp.ListenWithOptions(":10000", block, 10, 3)
+//	server := getty.NewTransportServer(getty.KCPListener(listener, getty.KCPConfig{NoDelay: true}))
+
+const (
+	defaultKCPInterval   = 10 * time.Millisecond
+	defaultKCPResend     = 2
+	defaultKCPSendWindow = 128
+	defaultKCPRecvWindow = 512
+)
+
+// KCPConn is a kcp conn whose protocol parameters can be tuned, e.g. the *kcp.UDPSession of
+// kcp-go. The conns which do not implement it are used as they are.
+type KCPConn interface {
+	net.Conn
+	SetNoDelay(nodelay, interval, resend, nc int)
+	SetWindowSize(sndwnd, rcvwnd int)
+	SetStreamMode(enable bool)
+	SetACKNoDelay(nodelay bool)
+	SetMtu(mtu int) bool
+}
+
+// KCPConfig is the protocol parameters of the kcp conns.
+type KCPConfig struct {
+	// enable the nodelay mode, whose retransmission timeout is not doubled. It is
+	// recommended on the lossy networks.
+	NoDelay bool
+	// the interval of the internal update of kcp. Its default value is 10ms.
+	Interval time.Duration
+	// the segments are resent after @Resend duplicate acks. Its default value is 2, and
+	// a negative value disables the fast resend.
+	Resend int
+	// disable the congestion control, so the sending is only limited by the windows.
+	NoCongestion bool
+	// the send & receive windows in segments. Their default values are 128 and 512.
+	SendWindow int
+	RecvWindow int
+	// the mtu of the udp datagrams, which is kept as it is if it is 0.
+	MTU int
+	// send the acks at once instead of at the next update.
+	ACKNoDelay bool
+}
+
+func (c KCPConfig) withDefaults() KCPConfig {
+	if c.Interval <= 0 {
+		c.Interval = defaultKCPInterval
+	}
+	if c.Resend < 0 {
+		c.Resend = 0
+	} else if c.Resend == 0 {
+		c.Resend = defaultKCPResend
+	}
+	if c.SendWindow <= 0 {
+		c.SendWindow = defaultKCPSendWindow
+	}
+	if c.RecvWindow <= 0 {
+		c.RecvWindow = defaultKCPRecvWindow
+	}
+
+	return c
+}
+
+// tune the kcp conn @conn by @config
+func tuneKCPConn(conn net.Conn, config KCPConfig) error {
+	kcpConn, ok := conn.(KCPConn)
+	if !ok {
+		return nil
+	}
+
+	var noDelay, noCongestion int
+	if config.NoDelay {
+		noDelay = 1
+	}
+	if config.NoCongestion {
+		noCongestion = 1
+	}
+	kcpConn.SetNoDelay(noDelay, int(config.Interval/time.Millisecond), config.Resend, noCongestion)
+	kcpConn.SetWindowSize(config.SendWindow, config.RecvWindow)
+	// the frames of the codecs are decoded from the byte stream
+	kcpConn.SetStreamMode(true)
+	kcpConn.SetACKNoDelay(config.ACKNoDelay)
+	if config.MTU > 0 && !kcpConn.SetMtu(config.MTU) {
+		return jerrors.Errorf("illegal kcp mtu %d", config.MTU)
+	}
+
+	return nil
+}
+
+// KCPDialer returns the TransportDialer which dials the kcp conns by @dial and tunes them
+// by @config.
+func KCPDialer(dial TransportDialer, config KCPConfig) TransportDialer {
+	if dial == nil {
+		panic("KCPDialer(dial):@dial is nil")
+	}
+
+	config = config.withDefaults()
+	return func(addr string) (net.Conn, error) {
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, jerrors.Annotatef(err, "kcp dial(addr:%s)", addr)
+		}
+		if err = tuneKCPConn(conn, config); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
+
+type kcpListener struct {
+	net.Listener
+	config KCPConfig
+}
+
+// KCPListener wraps the kcp listener @listener, whose accepted conns are tuned by @config,
+// which is served by NewTransportServer.
+func KCPListener(listener net.Listener, config KCPConfig) net.Listener {
+	if listener == nil {
+		panic("KCPListener(listener):@listener is nil")
+	}
+
+	return &kcpListener{Listener: listener, config: config.withDefaults()}
+}
+
+func (l *kcpListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if err = tuneKCPConn(conn, l.config); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,144 @@
+package getty
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKCPConn records the kcp parameters set on a tcp conn
+type fakeKCPConn struct {
+	net.Conn
+
+	lock       sync.Mutex
+	noDelay    [4]int
+	windows    [2]int
+	streamMode bool
+	ackNoDelay bool
+	mtu        int
+}
+
+func (c *fakeKCPConn) SetNoDelay(nodelay, interval, resend, nc int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.noDelay = [4]int{nodelay, interval, resend, nc}
+}
+
+func (c *fakeKCPConn) SetWindowSize(sndwnd, rcvwnd int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.windows = [2]int{sndwnd, rcvwnd}
+}
+
+func (c *fakeKCPConn) SetStreamMode(enable bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.streamMode = enable
+}
+
+func (c *fakeKCPConn) SetACKNoDelay(nodelay bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ackNoDelay = nodelay
+}
+
+func (c *fakeKCPConn) SetMtu(mtu int) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if mtu < 50 {
+		return false
+	}
+	c.mtu = mtu
+	return true
+}
+
+type fakeKCPListener struct {
+	net.Listener
+
+	conns chan *fakeKCPConn
+}
+
+func (l *fakeKCPListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	kcpConn := &fakeKCPConn{Conn: conn}
+	l.conns <- kcpConn
+	return kcpConn, nil
+}
+
+func TestKCPConfig(t *testing.T) {
+	config := KCPConfig{}.withDefaults()
+	assert.Equal(t, defaultKCPInterval, config.Interval)
+	assert.Equal(t, defaultKCPResend, config.Resend)
+	assert.Equal(t, defaultKCPSendWindow, config.SendWindow)
+	assert.Equal(t, defaultKCPRecvWindow, config.RecvWindow)
+	assert.Equal(t, 0, KCPConfig{Resend: -1}.withDefaults().Resend)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	assert.NotNil(t, tuneKCPConn(&fakeKCPConn{Conn: c1}, KCPConfig{MTU: 10}.withDefaults()))
+	// the conns which are not kcp conns are not tuned
+	assert.Nil(t, tuneKCPConn(c1, KCPConfig{MTU: 10}.withDefaults()))
+}
+
+func TestKCPTransport(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	accepted := &fakeKCPListener{Listener: l, conns: make(chan *fakeKCPConn, 1)}
+	config := KCPConfig{NoDelay: true, Interval: 20 * time.Millisecond, NoCongestion: true, MTU: 1200, ACKNoDelay: true}
+
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+	server := NewTransportServer(KCPListener(accepted, config)).(*server)
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(listener)
+		return nil
+	})
+	defer server.Close()
+
+	dialed := make(chan *fakeKCPConn, 1)
+	dialer := KCPDialer(func(addr string) (net.Conn, error) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		kcpConn := &fakeKCPConn{Conn: conn}
+		dialed <- kcpConn
+		return kcpConn, nil
+	}, config)
+	client := NewTransportClient(dialer, WithServerAddress(l.Addr().String()), WithConnectionNumber(1)).(*client)
+	client.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(&MessageHandler{})
+		return nil
+	})
+	defer client.Close()
+
+	for _, conn := range []*fakeKCPConn{<-dialed, <-accepted.conns} {
+		conn.lock.Lock()
+		assert.Equal(t, [4]int{1, 20, defaultKCPResend, 1}, conn.noDelay)
+		assert.Equal(t, [2]int{defaultKCPSendWindow, defaultKCPRecvWindow}, conn.windows)
+		assert.True(t, conn.streamMode)
+		assert.True(t, conn.ackNoDelay)
+		assert.Equal(t, 1200, conn.mtu)
+		conn.lock.Unlock()
+	}
+
+	ss := client.connectedSession()
+	assert.NotNil(t, ss)
+	assert.Nil(t, ss.WritePkg("hello", 0))
+	select {
+	case msg := <-listener.msgs:
+		assert.Equal(t, "hello", msg)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message")
+	}
+}
@@ -0,0 +1,96 @@
+/******************************************************
+# DESC       : tls key log of wss endpoints for debugging
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-08 15:10
+# FILE       : keylog.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/tls"
+	"io"
+	"os"
+	"sync"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+const (
+	// the environment variable of the key log file, which is honored by browsers & wireshark
+	keyLogFileEnv = "SSLKEYLOGFILE"
+)
+
+// keyLogFile is a key log file shared by the endpoints writing to the same path, whose
+// lines written by concurrent handshakes are not interleaved.
+type keyLogFile struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+func (f *keyLogFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.file.Write(p)
+}
+
+var (
+	keyLogFilesLock sync.Mutex
+	// key log file path -> its writer. The files are kept open until the process exits.
+	keyLogFiles = make(map[string]*keyLogFile)
+)
+
+// get the writer of the key log file @path. It returns nil if the key log is not built in,
+// the path is empty or the file can not be opened.
+func openKeyLog(path string) io.Writer {
+	if path == "" {
+		return nil
+	}
+	if !tlsKeyLogBuiltIn {
+		log.Warn("the tls key log %s is ignored for getty is not built with the tag gettykeylog", path)
+		return nil
+	}
+
+	keyLogFilesLock.Lock()
+	defer keyLogFilesLock.Unlock()
+	if f, ok := keyLogFiles[path]; ok {
+		return f
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Error("os.OpenFile(tls key log:%s) = error:%v", path, err)
+		return nil
+	}
+	log.Warn("the tls session keys are written to %s, which decrypts the captured traffic", path)
+	f := &keyLogFile{file: file}
+	keyLogFiles[path] = f
+	return f
+}
+
+// get the copy of @config which writes the tls keys to @w. @config is returned if @w is nil.
+func withKeyLog(config *tls.Config, w io.Writer) *tls.Config {
+	if w == nil {
+		return config
+	}
+
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	config.KeyLogWriter = w
+	return config
+}
+
+// get the key log file path of the option @path
+func keyLogPath(path string) string {
+	if path == "" {
+		return os.Getenv(keyLogFileEnv)
+	}
+
+	return path
+}
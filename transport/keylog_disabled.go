@@ -0,0 +1,15 @@
+//go:build !gettykeylog
+// +build !gettykeylog
+
+/******************************************************
+# DESC       : tls key log disabled in production builds
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-08 15:10
+# FILE       : keylog_disabled.go
+******************************************************/
+
+package getty
+
+const tlsKeyLogBuiltIn = false
@@ -0,0 +1,17 @@
+//go:build gettykeylog
+// +build gettykeylog
+
+/******************************************************
+# DESC       : tls key log built in for debugging
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-08 15:10
+# FILE       : keylog_enabled.go
+******************************************************/
+
+package getty
+
+// the tls key log options take effect only in the debug builds with the tag gettykeylog,
+// e.g. go build -tags gettykeylog, so that a production build never leaks its session keys.
+const tlsKeyLogBuiltIn = true
@@ -0,0 +1,64 @@
+package getty
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithKeyLog(t *testing.T) {
+	config := &tls.Config{ServerName: "getty"}
+	assert.True(t, config == withKeyLog(config, nil))
+	assert.Nil(t, withKeyLog(nil, nil))
+
+	var buf bytes.Buffer
+	c := withKeyLog(config, &buf)
+	assert.True(t, c.KeyLogWriter == &buf)
+	assert.Equal(t, "getty", c.ServerName)
+	assert.Nil(t, config.KeyLogWriter)
+	assert.True(t, withKeyLog(nil, &buf).KeyLogWriter == &buf)
+}
+
+func TestKeyLogPath(t *testing.T) {
+	os.Setenv(keyLogFileEnv, "/tmp/env.keys")
+	defer os.Unsetenv(keyLogFileEnv)
+
+	assert.Equal(t, "/tmp/env.keys", keyLogPath(""))
+	assert.Equal(t, "/tmp/opt.keys", keyLogPath("/tmp/opt.keys"))
+}
+
+func TestOpenKeyLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tls.keys")
+	assert.Nil(t, openKeyLog(""))
+	w := openKeyLog(path)
+	if !tlsKeyLogBuiltIn {
+		assert.Nil(t, w)
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+		return
+	}
+	assert.NotNil(t, w)
+	assert.True(t, w == openKeyLog(path))
+
+	server, client := net.Pipe()
+	serverConfig := withKeyLog(newTestTLSConfig(t), w)
+	done := make(chan error, 1)
+	go func() {
+		done <- tls.Server(server, serverConfig).Handshake()
+	}()
+	assert.Nil(t, tls.Client(client, &tls.Config{InsecureSkipVerify: true}).Handshake())
+	assert.Nil(t, <-done)
+	server.Close()
+	client.Close()
+
+	keys, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Contains(keys, []byte("CLIENT_HANDSHAKE_TRAFFIC_SECRET")), string(keys))
+}
@@ -0,0 +1,289 @@
+/******************************************************
+# DESC       : session leak detector for development
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-01 15:00
+# FILE       : leak.go
+******************************************************/
+
+package getty
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+const (
+	defaultLeakMaxLifetime   = time.Hour
+	defaultLeakCloseTimeout  = 30 * time.Second
+	defaultLeakCheckInterval = 10 * time.Second
+	maxLeakStackDepth        = 32
+)
+
+// LeakKind is the kind of a leaked session.
+type LeakKind int
+
+const (
+	// the session has not been closed within LeakDetectionConfig.MaxLifetime
+	LeakNotClosed LeakKind = iota
+	// the session has been closed, but its (EventListener)OnClose has not fired within
+	// LeakDetectionConfig.CloseTimeout, e.g. its goroutines are blocked by the listener
+	LeakOnCloseNotFired
+)
+
+func (k LeakKind) String() string {
+	switch k {
+	case LeakNotClosed:
+		return "not closed"
+	case LeakOnCloseNotFired:
+		return "OnClose not fired"
+	}
+
+	return fmt.Sprintf("LeakKind(%d)", int(k))
+}
+
+// LeakReport is a leaked session found by the leak detector.
+type LeakReport struct {
+	Kind LeakKind
+	// the session token, which is still available after the session is closed
+	Session string
+	// the duration since the session was created
+	Age time.Duration
+	// the stack where the session was created
+	Stack string
+}
+
+func (r LeakReport) String() string {
+	return fmt.Sprintf("session %s leaked(%s), age:%s, created at:\n%s", r.Session, r.Kind, r.Age, r.Stack)
+}
+
+// LeakDetectionConfig is the config of the session leak detector, which is a debug facility
+// and should not be enabled in production for it records the stack of every new session.
+type LeakDetectionConfig struct {
+	// the expected max lifetime of a session. Its default value is 1h.
+	MaxLifetime time.Duration
+	// the max duration from the close of a session to its OnClose. Its default value is 30s.
+	CloseTimeout time.Duration
+	// the interval of the leak checks. Its default value is 10s.
+	CheckInterval time.Duration
+	// the leaked sessions are reported by it, or logged if it is nil. Every leak is reported once.
+	Report func(LeakReport)
+}
+
+func (c LeakDetectionConfig) withDefaults() LeakDetectionConfig {
+	if c.MaxLifetime <= 0 {
+		c.MaxLifetime = defaultLeakMaxLifetime
+	}
+	if c.CloseTimeout <= 0 {
+		c.CloseTimeout = defaultLeakCloseTimeout
+	}
+	if c.CheckInterval <= 0 {
+		c.CheckInterval = defaultLeakCheckInterval
+	}
+
+	return c
+}
+
+type leakRecord struct {
+	created  time.Time
+	closed   time.Time
+	pcs      []uintptr
+	reported [2]bool
+}
+
+type leakDetector struct {
+	config LeakDetectionConfig
+	done   chan struct{}
+
+	lock     sync.Mutex
+	sessions map[*session]*leakRecord
+}
+
+var (
+	leakDetectorLock sync.RWMutex
+	leakDetectorInst *leakDetector
+)
+
+// SetLeakDetection enables the session leak detector, and nil @config disables it. Only the
+// sessions created after it is enabled are tracked since they run.
+func SetLeakDetection(config *LeakDetectionConfig) {
+	var d *leakDetector
+	if config != nil {
+		d = &leakDetector{
+			config:   config.withDefaults(),
+			done:     make(chan struct{}),
+			sessions: make(map[*session]*leakRecord),
+		}
+	}
+
+	leakDetectorLock.Lock()
+	old := leakDetectorInst
+	leakDetectorInst = d
+	leakDetectorLock.Unlock()
+
+	if old != nil {
+		close(old.done)
+	}
+	if d != nil {
+		go d.run()
+	}
+}
+
+func getLeakDetector() *leakDetector {
+	leakDetectorLock.RLock()
+	defer leakDetectorLock.RUnlock()
+	return leakDetectorInst
+}
+
+// CheckLeaks returns the sessions leaked now, no matter whether they have been reported.
+// It returns nil if the leak detector is disabled.
+func CheckLeaks() []LeakReport {
+	if d := getLeakDetector(); d != nil {
+		return d.check(time.Now(), false)
+	}
+
+	return nil
+}
+
+// record the stack creating a session if the leak detector is enabled
+func newLeakRecord() *leakRecord {
+	if getLeakDetector() == nil {
+		return nil
+	}
+
+	pcs := make([]uintptr, maxLeakStackDepth)
+	// skip runtime.Callers, newLeakRecord & newSession
+	pcs = pcs[:runtime.Callers(3, pcs)]
+	return &leakRecord{created: time.Now(), pcs: pcs}
+}
+
+func (d *leakDetector) track(s *session, r *leakRecord) {
+	d.lock.Lock()
+	d.sessions[s] = r
+	d.lock.Unlock()
+}
+
+func (d *leakDetector) closed(s *session) {
+	d.lock.Lock()
+	if r, ok := d.sessions[s]; ok && r.closed.IsZero() {
+		r.closed = time.Now()
+	}
+	d.lock.Unlock()
+}
+
+func (d *leakDetector) untrack(s *session) {
+	d.lock.Lock()
+	delete(d.sessions, s)
+	d.lock.Unlock()
+}
+
+/////////////////////////////////////////
+// session leak tracking
+/////////////////////////////////////////
+
+// the running session is tracked by the leak detector
+func (s *session) trackLeak() {
+	if s.leak == nil {
+		return
+	}
+	if d := getLeakDetector(); d != nil {
+		d.track(s, s.leak)
+	}
+}
+
+func (s *session) markLeakClosed() {
+	if s.leak == nil {
+		return
+	}
+	if d := getLeakDetector(); d != nil {
+		d.closed(s)
+	}
+}
+
+// OnClose of the session has fired
+func (s *session) untrackLeak() {
+	if s.leak == nil {
+		return
+	}
+	if d := getLeakDetector(); d != nil {
+		d.untrack(s)
+	}
+}
+
+// find the leaked sessions at @now. Only the leaks which have not been reported are
+// returned and marked if @report is true.
+func (d *leakDetector) check(now time.Time, report bool) []LeakReport {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var leaks []LeakReport
+	for s, r := range d.sessions {
+		kind := LeakNotClosed
+		if r.closed.IsZero() {
+			if now.Sub(r.created) < d.config.MaxLifetime {
+				continue
+			}
+		} else {
+			if now.Sub(r.closed) < d.config.CloseTimeout {
+				continue
+			}
+			kind = LeakOnCloseNotFired
+		}
+		if report {
+			if r.reported[kind] {
+				continue
+			}
+			r.reported[kind] = true
+		}
+		leaks = append(leaks, LeakReport{
+			Kind:    kind,
+			Session: s.sessionKey(),
+			Age:     now.Sub(r.created),
+			Stack:   formatLeakStack(r.pcs),
+		})
+	}
+
+	return leaks
+}
+
+func (d *leakDetector) run() {
+	ticker := time.NewTicker(d.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case now := <-ticker.C:
+			for _, leak := range d.check(now, true) {
+				if d.config.Report != nil {
+					d.config.Report(leak)
+				} else {
+					log.Warn("[getty leak detector] %s", leak)
+				}
+			}
+		}
+	}
+}
+
+func formatLeakStack(pcs []uintptr) string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+
+	return b.String()
+}
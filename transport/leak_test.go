@@ -0,0 +1,87 @@
+package getty
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// blockedCloseListener blocks in OnClose until unblock is closed
+type blockedCloseListener struct {
+	lineListener
+
+	unblock chan struct{}
+}
+
+func (l *blockedCloseListener) OnClose(ss Session) {
+	<-l.unblock
+}
+
+func TestLeakDetection(t *testing.T) {
+	reports := make(chan LeakReport, 8)
+	SetLeakDetection(&LeakDetectionConfig{
+		MaxLifetime:   50 * time.Millisecond,
+		CloseTimeout:  50 * time.Millisecond,
+		CheckInterval: 20 * time.Millisecond,
+		Report:        func(r LeakReport) { reports <- r },
+	})
+	defer SetLeakDetection(nil)
+
+	// the sessions are not connected to each other, otherwise @src may be closed by the eof
+	// of closing @dst before its leak is checked
+	src, _ := newPipeSessions(t)
+	_, dst := newPipeSessions(t)
+	src.SetPkgHandler(&lineTransferCodec{})
+	src.SetEventListener(&lineListener{msgs: make(chan interface{}, 4)})
+	src.run()
+	listener := &blockedCloseListener{
+		lineListener: lineListener{msgs: make(chan interface{}, 4)},
+		unblock:      make(chan struct{}),
+	}
+	dst.SetPkgHandler(&lineTransferCodec{})
+	dst.SetEventListener(listener)
+	dst.run()
+
+	// both sessions are reported once as not closed
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-reports:
+			assert.Equal(t, LeakNotClosed, r.Kind)
+			assert.True(t, strings.Contains(r.Stack, "newPipeSessions"), r.Stack)
+		case <-time.After(time.Second):
+			t.Fatal("the leak is not reported")
+		}
+	}
+	assert.Equal(t, 2, len(CheckLeaks()))
+
+	// @src is still open, and its leak is not reported again
+	dst.Close()
+	select {
+	case r := <-reports:
+		assert.Equal(t, LeakOnCloseNotFired, r.Kind)
+		assert.Equal(t, dst.sessionKey(), r.Session)
+	case <-time.After(time.Second):
+		t.Fatal("the leak is not reported")
+	}
+	assert.Equal(t, 2, len(CheckLeaks()))
+
+	close(listener.unblock)
+	<-dst.wDone
+	leaks := CheckLeaks()
+	assert.Equal(t, 1, len(leaks))
+	assert.Equal(t, src.sessionKey(), leaks[0].Session)
+
+	src.Close()
+	<-src.wDone
+	assert.Equal(t, 0, len(CheckLeaks()))
+}
+
+func TestLeakDetectionDisabled(t *testing.T) {
+	src, _ := newPipeSessions(t)
+	assert.Nil(t, src.leak)
+	assert.Nil(t, CheckLeaks())
+}
@@ -0,0 +1,135 @@
+/******************************************************
+# DESC       : event listener adapters
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-13 16:40
+# FILE       : listener.go
+******************************************************/
+
+package getty
+
+import (
+	"time"
+)
+
+// the optional interfaces of the session listener
+type (
+	batchEventListener interface {
+		OnMessages(Session, []interface{})
+	}
+
+	writableEventListener interface {
+		OnWritable(Session)
+	}
+)
+
+/////////////////////////////////////////
+// EventListener -> EventListenerV2
+/////////////////////////////////////////
+
+type eventListenerV2 struct {
+	listener EventListener
+}
+
+// NewEventListenerV2 adapts the old EventListener @listener to EventListenerV2.
+// If @listener implements ErrorListener, its OnSessionError will be invoked on errors.
+func NewEventListenerV2(listener EventListener) EventListenerV2 {
+	return &eventListenerV2{listener: listener}
+}
+
+func (l *eventListenerV2) OnOpen(ss Session) error {
+	return l.listener.OnOpen(ss)
+}
+
+func (l *eventListenerV2) OnClose(ss Session) {
+	l.listener.OnClose(ss)
+}
+
+func (l *eventListenerV2) OnError(ss Session, err error, direction ErrorDirection) {
+	if listener, ok := l.listener.(ErrorListener); ok {
+		listener.OnSessionError(ss, err, direction)
+		return
+	}
+
+	l.listener.OnError(ss, err)
+}
+
+func (l *eventListenerV2) OnCron(ss Session) {
+	l.listener.OnCron(ss)
+}
+
+func (l *eventListenerV2) OnMessages(ss Session, pkgs []interface{}) {
+	for _, pkg := range pkgs {
+		l.listener.OnMessage(ss, pkg)
+	}
+}
+
+func (l *eventListenerV2) OnWritable(ss Session) {
+	if listener, ok := l.listener.(writableEventListener); ok {
+		listener.OnWritable(ss)
+	}
+}
+
+/////////////////////////////////////////
+// EventListenerV2 -> EventListener
+/////////////////////////////////////////
+
+// eventListenerV1 is used by session to hold an EventListenerV2. Besides EventListener,
+// it implements ErrorListener, batchEventListener, writableEventListener, SlowHandlerListener
+// and WriteStarvationListener.
+type eventListenerV1 struct {
+	listener EventListenerV2
+}
+
+func newEventListenerV1(listener EventListenerV2) EventListener {
+	if l, ok := listener.(*eventListenerV2); ok {
+		return l.listener
+	}
+
+	return &eventListenerV1{listener: listener}
+}
+
+func (l *eventListenerV1) OnOpen(ss Session) error {
+	return l.listener.OnOpen(ss)
+}
+
+func (l *eventListenerV1) OnClose(ss Session) {
+	l.listener.OnClose(ss)
+}
+
+func (l *eventListenerV1) OnError(ss Session, err error) {
+	l.listener.OnError(ss, err, ErrorDirectionRead)
+}
+
+func (l *eventListenerV1) OnSessionError(ss Session, err error, direction ErrorDirection) {
+	l.listener.OnError(ss, err, direction)
+}
+
+func (l *eventListenerV1) OnCron(ss Session) {
+	l.listener.OnCron(ss)
+}
+
+func (l *eventListenerV1) OnMessage(ss Session, pkg interface{}) {
+	l.listener.OnMessages(ss, []interface{}{pkg})
+}
+
+func (l *eventListenerV1) OnMessages(ss Session, pkgs []interface{}) {
+	l.listener.OnMessages(ss, pkgs)
+}
+
+func (l *eventListenerV1) OnWritable(ss Session) {
+	l.listener.OnWritable(ss)
+}
+
+func (l *eventListenerV1) OnSlowHandler(ss Session, pkg interface{}, elapsed time.Duration, stack []byte) {
+	if listener, ok := l.listener.(SlowHandlerListener); ok {
+		listener.OnSlowHandler(ss, pkg, elapsed, stack)
+	}
+}
+
+func (l *eventListenerV1) OnWriteStarvation(ss Session, wait time.Duration) {
+	if listener, ok := l.listener.(WriteStarvationListener); ok {
+		listener.OnWriteStarvation(ss, wait)
+	}
+}
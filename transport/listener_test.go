@@ -0,0 +1,70 @@
+package getty
+
+import (
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type batchHandler struct {
+	batches  [][]interface{}
+	errs     []ErrorDirection
+	writable int
+}
+
+func (h *batchHandler) OnOpen(Session) error                         { return nil }
+func (h *batchHandler) OnClose(Session)                              {}
+func (h *batchHandler) OnCron(Session)                               {}
+func (h *batchHandler) OnError(_ Session, _ error, d ErrorDirection) { h.errs = append(h.errs, d) }
+func (h *batchHandler) OnWritable(Session)                           { h.writable++ }
+func (h *batchHandler) OnMessages(_ Session, pkgs []interface{}) {
+	h.batches = append(h.batches, pkgs)
+}
+
+type countHandler struct {
+	MessageHandler
+
+	pkgs []interface{}
+}
+
+func (h *countHandler) OnMessage(session Session, pkg interface{}) {
+	h.pkgs = append(h.pkgs, pkg)
+}
+
+func TestEventListenerV2(t *testing.T) {
+	var (
+		handler    batchHandler
+		v1Handler  countHandler
+		errHandler errorHandler
+	)
+
+	ss, _ := newPipeSessions(t)
+	ss.SetEventListenerV2(&handler)
+	ss.addTasks([]interface{}{1, 2}, ss.sampleTime())
+	ss.addTask(3, ss.sampleTime())
+	assert.Equal(t, [][]interface{}{{1, 2}, {3}}, handler.batches)
+	assert.True(t, ss.notifyError(ErrQueueFull, ErrorDirectionWrite))
+	assert.Equal(t, []ErrorDirection{ErrorDirectionWrite}, handler.errs)
+
+	ss.notifyWritable()
+	assert.Equal(t, 0, handler.writable)
+	ss.wQFull = 1
+	ss.notifyWritable()
+	ss.notifyWritable()
+	assert.Equal(t, 1, handler.writable)
+
+	// the old listener
+	ss.SetEventListenerV2(NewEventListenerV2(&v1Handler))
+	assert.Equal(t, &v1Handler, ss.listener)
+	ss.addTasks([]interface{}{1, 2}, ss.sampleTime())
+	assert.Equal(t, []interface{}{1, 2}, v1Handler.pkgs)
+
+	v2 := NewEventListenerV2(&v1Handler)
+	v2.OnMessages(ss, []interface{}{3})
+	assert.Equal(t, []interface{}{1, 2, 3}, v1Handler.pkgs)
+	v2 = NewEventListenerV2(&errHandler)
+	v2.OnError(ss, ErrQueueFull, ErrorDirectionWrite)
+	assert.Equal(t, []ErrorDirection{ErrorDirectionWrite}, errHandler.directions)
+}
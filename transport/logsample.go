@@ -0,0 +1,189 @@
+/******************************************************
+# DESC       : sampled logging of the noisy error paths
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-30 10:10
+# FILE       : logsample.go
+******************************************************/
+
+package getty
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+const (
+	defaultLogSampleBurst  = 10
+	defaultLogSampleEvery  = 100
+	defaultLogSamplePeriod = 10 * time.Second
+)
+
+// LogSamplingConfig is the sampling config of the repetitive error logs of the sessions, e.g.
+// the read errors of thousands of sessions during a network incident. The logs are sampled
+// per call site, and the suppressed ones are summarized every Period.
+type LogSamplingConfig struct {
+	// the first Burst logs of a call site in a period are all written. Its default value is 10.
+	Burst int
+	// after the burst, one of every Every logs is written. Its default value is 100, and a
+	// negative value means the rest logs of the period are all suppressed.
+	Every int
+	// the period of the summaries. Its default value is 10s.
+	Period time.Duration
+}
+
+func (c LogSamplingConfig) withDefaults() LogSamplingConfig {
+	if c.Burst <= 0 {
+		c.Burst = defaultLogSampleBurst
+	}
+	if c.Every == 0 {
+		c.Every = defaultLogSampleEvery
+	}
+	if c.Period <= 0 {
+		c.Period = defaultLogSamplePeriod
+	}
+
+	return c
+}
+
+type logSite struct {
+	total   int
+	written int
+}
+
+type logSampler struct {
+	config LogSamplingConfig
+	done   chan struct{}
+
+	lock sync.Mutex
+	// format of the log call site -> its counters in the current period
+	sites map[string]*logSite
+}
+
+var (
+	logSamplerLock sync.RWMutex
+	logSamplerInst *logSampler
+)
+
+// SetLogSampling enables the sampling of the repetitive error logs of getty, and nil @config
+// disables it. The logs are not sampled by default.
+func SetLogSampling(config *LogSamplingConfig) {
+	var l *logSampler
+	if config != nil {
+		l = &logSampler{
+			config: config.withDefaults(),
+			done:   make(chan struct{}),
+			sites:  make(map[string]*logSite),
+		}
+	}
+
+	logSamplerLock.Lock()
+	old := logSamplerInst
+	logSamplerInst = l
+	logSamplerLock.Unlock()
+
+	if old != nil {
+		close(old.done)
+		old.summarize()
+	}
+	if l != nil {
+		go l.run()
+	}
+}
+
+func getLogSampler() *logSampler {
+	logSamplerLock.RLock()
+	defer logSamplerLock.RUnlock()
+	return logSamplerInst
+}
+
+// check whether the log of the call site @format should be written
+func (l *logSampler) allow(format string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	site := l.sites[format]
+	if site == nil {
+		site = &logSite{}
+		l.sites[format] = site
+	}
+	site.total++
+	if site.total <= l.config.Burst ||
+		(l.config.Every > 0 && (site.total-l.config.Burst)%l.config.Every == 0) {
+		site.written++
+		return true
+	}
+
+	return false
+}
+
+// get the summaries of the call sites whose logs have been suppressed in the current period,
+// and start a new period.
+func (l *logSampler) summaries() []string {
+	l.lock.Lock()
+	sites := l.sites
+	l.sites = make(map[string]*logSite, len(sites))
+	l.lock.Unlock()
+
+	var summaries []string
+	for format, site := range sites {
+		if site.total > site.written {
+			summaries = append(summaries, fmt.Sprintf("%d of %d logs suppressed, log:%q",
+				site.total-site.written, site.total, format))
+		}
+	}
+	sort.Strings(summaries)
+
+	return summaries
+}
+
+func (l *logSampler) summarize() {
+	for _, summary := range l.summaries() {
+		log.Warn("[getty log sampling] %s", summary)
+	}
+}
+
+func (l *logSampler) run() {
+	ticker := time.NewTicker(l.config.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.summarize()
+		}
+	}
+}
+
+// write the log of @lvl if the sampling allows it. The caller of sampledWarn/sampledError
+// is logged as the source.
+func sampledLog(lvl log.Level, format string, args ...interface{}) {
+	if l := getLogSampler(); l != nil && !l.allow(format) {
+		return
+	}
+
+	var src string
+	if pc, file, line, ok := runtime.Caller(2); ok {
+		src = fmt.Sprintf("%s:%s:%d", filepath.Base(file), runtime.FuncForPC(pc).Name(), line)
+	}
+	log.Log(lvl, src, fmt.Sprintf(format, args...))
+}
+
+func sampledWarn(format string, args ...interface{}) {
+	sampledLog(log.WARNING, format, args...)
+}
+
+func sampledError(format string, args ...interface{}) {
+	sampledLog(log.ERROR, format, args...)
+}
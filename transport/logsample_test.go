@@ -0,0 +1,61 @@
+package getty
+
+import (
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSampler(t *testing.T) {
+	l := &logSampler{
+		config: LogSamplingConfig{Burst: 2, Every: 3}.withDefaults(),
+		sites:  make(map[string]*logSite),
+	}
+	assert.Equal(t, defaultLogSamplePeriod, l.config.Period)
+
+	var written []int
+	for i := 1; i <= 10; i++ {
+		if l.allow("%s, [session.conn.read] = error{%s}") {
+			written = append(written, i)
+		}
+	}
+	// the burst, and then one of every three logs
+	assert.Equal(t, []int{1, 2, 5, 8}, written)
+	assert.True(t, l.allow("%s, [session.handleLoop] = error{%s}"))
+
+	summaries := l.summaries()
+	assert.Equal(t, []string{`6 of 10 logs suppressed, log:"%s, [session.conn.read] = error{%s}"`}, summaries)
+	// a new period starts after the summary
+	assert.Nil(t, l.summaries())
+	assert.True(t, l.allow("%s, [session.conn.read] = error{%s}"))
+
+	l.config.Every = -1
+	l.allow("%s, [session.conn.read] = error{%s}")
+	for i := 0; i < 10; i++ {
+		assert.False(t, l.allow("%s, [session.conn.read] = error{%s}"))
+	}
+}
+
+func TestSetLogSampling(t *testing.T) {
+	assert.Nil(t, getLogSampler())
+
+	SetLogSampling(&LogSamplingConfig{Burst: 1, Every: -1, Period: time.Minute})
+	l := getLogSampler()
+	assert.NotNil(t, l)
+	for i := 0; i < 3; i++ {
+		sampledWarn("[TestSetLogSampling] warn %d", i)
+	}
+	l.lock.Lock()
+	site := l.sites["[TestSetLogSampling] warn %d"]
+	l.lock.Unlock()
+	assert.Equal(t, 3, site.total)
+	assert.Equal(t, 1, site.written)
+
+	SetLogSampling(nil)
+	assert.Nil(t, getLogSampler())
+	_, ok := <-l.done
+	assert.False(t, ok)
+}
@@ -0,0 +1,39 @@
+/******************************************************
+# DESC       : protocol magic prefix validation of the first pkg
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-13 09:50
+# FILE       : magic.go
+******************************************************/
+
+package getty
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+)
+
+// validate the magic prefix of the first pkg of the session, which is kept in @data for the
+// codec. @complete means @data is a whole websocket message. It returns false if more bytes
+// are needed, and ErrBadMagic if @data does not begin with the magic prefix, in which case
+// the session should be closed.
+func (s *session) validateMagic(data []byte, complete bool) (bool, error) {
+	n := len(s.magic)
+	if len(data) < n {
+		n = len(data)
+	}
+	// the mismatch is detected as soon as the first differing byte arrives
+	if !bytes.Equal(data[:n], s.magic[:n]) || (complete && n < len(s.magic)) {
+		atomic.AddUint64(&s.metrics.badMagicNum, 1)
+		s.reportProtocolError()
+		return false, newGettyError(ErrBadMagic, fmt.Errorf("first bytes %q, magic %q", data[:n], s.magic))
+	}
+	if n < len(s.magic) {
+		return false, nil
+	}
+
+	s.magic = nil
+	return true, nil
+}
@@ -0,0 +1,66 @@
+package getty
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateMagic(t *testing.T) {
+	ss, _ := newPipeSessions(t)
+	ss.magic = []byte("GTY1")
+
+	ok, err := ss.validateMagic([]byte("GT"), false)
+	assert.False(t, ok)
+	assert.Nil(t, err)
+	// a whole message shorter than the magic
+	_, err = ss.validateMagic([]byte("GT"), true)
+	assert.True(t, errors.Is(err, ErrBadMagic))
+	// the mismatch is detected before the whole magic arrives
+	_, err = ss.validateMagic([]byte("GE"), false)
+	assert.True(t, errors.Is(err, ErrBadMagic))
+	assert.Equal(t, uint64(2), ss.metrics.BadMagicNum())
+
+	ok, err = ss.validateMagic([]byte("GTY1hello"), false)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Nil(t, ss.magic)
+}
+
+func TestServerMagic(t *testing.T) {
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerMagic([]byte("GTY1")))
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(listener)
+		return nil
+	})
+	defer server.Close()
+	addr := server.streamListener.Addr().String()
+
+	// the magic is kept for the codec
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GT"))
+	time.Sleep(10 * time.Millisecond)
+	conn.Write([]byte("Y1hello\nworld\n"))
+	assert.Equal(t, "GTY1hello", <-listener.msgs)
+	assert.Equal(t, "world", <-listener.msgs)
+
+	// a misdirected client
+	conn2, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn2.Close()
+	conn2.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	conn2.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn2.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	assert.Equal(t, uint64(1), server.Metrics().BadMagicNum())
+	assert.Equal(t, 0, len(listener.msgs))
+}
@@ -0,0 +1,251 @@
+/******************************************************
+# DESC       : memory budget with load shedding
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-27 10:20
+# FILE       : memory.go
+******************************************************/
+
+package getty
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+const (
+	// the interval of checking the budget while the reads are paused
+	memoryPauseInterval = 10 * time.Millisecond
+	// at most one session is closed by ShedCloseWorst in the interval
+	memoryCloseInterval = 100 * time.Millisecond
+)
+
+// ShedPolicy is the load shedding policy applied when a memory budget is exceeded.
+// The policies can be combined, e.g. ShedPauseReads | ShedCloseWorst.
+type ShedPolicy int
+
+const (
+	// the tcp/websocket sessions stop reading until the usage drops below the limit
+	ShedPauseReads ShedPolicy = 1 << iota
+	// (Session)WritePkg/WritePkgContext fail with ErrMemoryLimit
+	ShedRejectWrites
+	// the session which buffers the most data is closed
+	ShedCloseWorst
+)
+
+// MemoryBudget is the memory budget of the buffered inbound/outbound data of the sessions.
+// One budget can be shared by all endpoints by WithServerMemoryBudget/WithClientMemoryBudget,
+// so it caps the memory of the whole process.
+//
+// The inbound data is the stream data which has been read from the tcp connections but not
+// decoded yet. The outbound data is the pkgs in the session write queues, whose size is the
+// length of a []byte/string pkg, or the return value of its Size() int method, so the pkgs
+// of other types are not counted.
+type MemoryBudget struct {
+	limit  int64
+	policy ShedPolicy
+
+	inbound        int64
+	outbound       int64
+	pausedReads    uint64
+	rejectedWrites uint64
+	closedSessions uint64
+
+	lock      sync.Mutex
+	sessions  map[*session]struct{}
+	lastClose time.Time
+}
+
+// NewMemoryBudget builds a memory budget of @limit bytes with the shedding @policy.
+func NewMemoryBudget(limit int64, policy ShedPolicy) *MemoryBudget {
+	if limit <= 0 {
+		panic("@limit <= 0")
+	}
+
+	return &MemoryBudget{
+		limit:    limit,
+		policy:   policy,
+		sessions: make(map[*session]struct{}),
+	}
+}
+
+// MemoryStats is a snapshot of a memory budget.
+type MemoryStats struct {
+	Limit    int64
+	Inbound  int64
+	Outbound int64
+	// the times of the read pauses, the rejected writes and the closed sessions caused by the shedding
+	PausedReads    uint64
+	RejectedWrites uint64
+	ClosedSessions uint64
+}
+
+func (s MemoryStats) String() string {
+	return fmt.Sprintf("{limit:%d, inbound:%d, outbound:%d, paused reads:%d, rejected writes:%d, closed sessions:%d}",
+		s.Limit, s.Inbound, s.Outbound, s.PausedReads, s.RejectedWrites, s.ClosedSessions)
+}
+
+// Stats returns the current usage & the shedding statistics of the budget.
+func (b *MemoryBudget) Stats() MemoryStats {
+	return MemoryStats{
+		Limit:          b.limit,
+		Inbound:        atomic.LoadInt64(&b.inbound),
+		Outbound:       atomic.LoadInt64(&b.outbound),
+		PausedReads:    atomic.LoadUint64(&b.pausedReads),
+		RejectedWrites: atomic.LoadUint64(&b.rejectedWrites),
+		ClosedSessions: atomic.LoadUint64(&b.closedSessions),
+	}
+}
+
+// Used returns the buffered bytes of the budget.
+func (b *MemoryBudget) Used() int64 {
+	return atomic.LoadInt64(&b.inbound) + atomic.LoadInt64(&b.outbound)
+}
+
+func (b *MemoryBudget) exceeded() bool {
+	return b.Used() > b.limit
+}
+
+func (b *MemoryBudget) register(s *session) {
+	b.lock.Lock()
+	b.sessions[s] = struct{}{}
+	b.lock.Unlock()
+}
+
+func (b *MemoryBudget) unregister(s *session) {
+	b.lock.Lock()
+	delete(b.sessions, s)
+	b.lock.Unlock()
+}
+
+// close the session which buffers the most data if the budget is exceeded
+func (b *MemoryBudget) shed() {
+	if b.policy&ShedCloseWorst == 0 || !b.exceeded() {
+		return
+	}
+
+	var (
+		worst *session
+		max   int64
+	)
+	b.lock.Lock()
+	if now := time.Now(); now.Sub(b.lastClose) >= memoryCloseInterval {
+		for s := range b.sessions {
+			if used := s.memoryUsed(); used > max {
+				worst, max = s, used
+			}
+		}
+		if worst != nil {
+			b.lastClose = now
+			delete(b.sessions, worst)
+		}
+	}
+	b.lock.Unlock()
+	if worst == nil {
+		return
+	}
+
+	atomic.AddUint64(&b.closedSessions, 1)
+	log.Warn("%s, memory budget %s exceeded, close the session buffering %d bytes",
+		worst.sessionToken(), b.Stats(), max)
+	worst.Close()
+}
+
+// get the size of the pkg in the write queue
+func queuedPkgSize(pkg interface{}) int64 {
+	switch p := unwrapQueuedPkg(pkg).pkg.(type) {
+	case []byte:
+		return int64(len(p))
+	case string:
+		return int64(len(p))
+	case UDPContext:
+		return queuedPkgSize(p.Pkg)
+	case *UDPContext:
+		return queuedPkgSize(p.Pkg)
+	case interface{ Size() int }:
+		return int64(p.Size())
+	}
+
+	return 0
+}
+
+/////////////////////////////////////////
+// session memory accounting
+/////////////////////////////////////////
+
+func (s *session) memoryUsed() int64 {
+	return atomic.LoadInt64(&s.memInbound) + atomic.LoadInt64(&s.memOutbound)
+}
+
+// account @pkg which is put into(@delta is 1) or taken from(@delta is -1) the write queue
+func (s *session) accountQueuedPkg(pkg interface{}, delta int64) {
+	atomic.AddInt64(&s.metrics.queuedPkgNum, delta)
+	b := s.memBudget
+	if b == nil {
+		return
+	}
+	if size := delta * queuedPkgSize(pkg); size != 0 {
+		atomic.AddInt64(&s.memOutbound, size)
+		atomic.AddInt64(&b.outbound, size)
+		if size > 0 {
+			b.shed()
+		}
+	}
+}
+
+// set the undecoded inbound bytes of the session
+func (s *session) setInbound(n int64) {
+	b := s.memBudget
+	if b == nil {
+		return
+	}
+	if delta := n - atomic.SwapInt64(&s.memInbound, n); delta != 0 {
+		atomic.AddInt64(&b.inbound, delta)
+		if delta > 0 {
+			b.shed()
+		}
+	}
+}
+
+// check whether a pkg can be written to the session
+func (s *session) admitWrite() error {
+	b := s.memBudget
+	if b == nil || b.policy&ShedRejectWrites == 0 || !b.exceeded() {
+		return nil
+	}
+
+	atomic.AddUint64(&b.rejectedWrites, 1)
+	return ErrMemoryLimit
+}
+
+// block the read goroutine until the budget is not exceeded
+func (s *session) waitMemory() {
+	b := s.memBudget
+	if b == nil || b.policy&ShedPauseReads == 0 || !b.exceeded() {
+		return
+	}
+
+	atomic.AddUint64(&b.pausedReads, 1)
+	for b.exceeded() && !s.IsClosed() {
+		<-wheel.After(memoryPauseInterval)
+	}
+}
+
+// release the memory accounted by the session when it is closed
+func (s *session) releaseMemory() {
+	b := s.memBudget
+	if b == nil {
+		return
+	}
+
+	b.unregister(s)
+	atomic.AddInt64(&b.inbound, -atomic.SwapInt64(&s.memInbound, 0))
+	atomic.AddInt64(&b.outbound, -atomic.SwapInt64(&s.memOutbound, 0))
+}
@@ -0,0 +1,85 @@
+package getty
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type sizedPkg struct{}
+
+func (sizedPkg) Size() int { return 7 }
+
+func TestQueuedPkgSize(t *testing.T) {
+	assert.Equal(t, int64(3), queuedPkgSize([]byte("abc")))
+	assert.Equal(t, int64(5), queuedPkgSize(queuedPkg{pkg: "hello"}))
+	assert.Equal(t, int64(7), queuedPkgSize(UDPContext{Pkg: sizedPkg{}}))
+	assert.Equal(t, int64(0), queuedPkgSize(1))
+}
+
+func TestMemoryBudgetRejectWrites(t *testing.T) {
+	b := NewMemoryBudget(10, ShedRejectWrites)
+	ss, _ := newPipeSessions(t)
+	ss.memBudget = b
+	ss.SetWQLen(4)
+
+	assert.Nil(t, ss.WritePkg([]byte("12345678"), time.Second))
+	assert.Nil(t, ss.WritePkg([]byte("12345678"), time.Second))
+	assert.Equal(t, int64(16), b.Stats().Outbound)
+	err := ss.WritePkg([]byte("1"), time.Second)
+	assert.True(t, errors.Is(err, ErrMemoryLimit))
+	assert.Equal(t, uint64(1), b.Stats().RejectedWrites)
+
+	ss.accountQueuedPkg(<-ss.wQ, -1)
+	assert.Equal(t, int64(8), b.Used())
+	assert.Nil(t, ss.WritePkg("1", time.Second))
+	assert.Equal(t, int64(9), b.Used())
+	assert.Equal(t, int64(2), ss.metrics.Budget().QueuedPkgNum)
+
+	ss.setInbound(4)
+	assert.Equal(t, int64(4), b.Stats().Inbound)
+	ss.releaseMemory()
+	assert.Equal(t, int64(0), b.Used())
+}
+
+func TestMemoryBudgetCloseWorst(t *testing.T) {
+	b := NewMemoryBudget(100, ShedCloseWorst|ShedPauseReads)
+	ss1, ss2 := newPipeSessions(t)
+	for _, ss := range []*session{ss1, ss2} {
+		ss.memBudget = b
+		b.register(ss)
+	}
+
+	ss1.setInbound(30)
+	ss2.setInbound(60)
+	assert.False(t, ss1.IsClosed())
+	assert.False(t, ss2.IsClosed())
+
+	// the session buffering the most data is closed
+	ss1.setInbound(50)
+	assert.True(t, ss2.IsClosed())
+	assert.False(t, ss1.IsClosed())
+	assert.Equal(t, uint64(1), b.Stats().ClosedSessions)
+	ss2.releaseMemory()
+	assert.Equal(t, int64(50), b.Used())
+
+	// the reads are paused until the usage drops below the limit
+	ss1.setInbound(200)
+	done := make(chan struct{})
+	go func() {
+		ss1.waitMemory()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("the read is not paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	ss1.setInbound(0)
+	<-done
+	assert.Equal(t, uint64(1), b.Stats().PausedReads)
+}
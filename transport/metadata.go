@@ -0,0 +1,166 @@
+/******************************************************
+# DESC       : per frame metadata header
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-21 15:40
+# FILE       : metadata.go
+******************************************************/
+
+package getty
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	// the max length of the metadata header, excluding its 2 bytes length
+	maxMetadataLen = 1<<16 - 1
+)
+
+var (
+	errIllegalMetadata = jerrors.New("illegal metadata header")
+)
+
+// Metadata is the key-value metadata of a frame, e.g. trace id, tenant or priority.
+// A key is 1 ~ 255 bytes, and the total encoded metadata of a frame is up to 64KB.
+type Metadata map[string]string
+
+// MetadataInterceptor reads & writes the metadata of the frames of the sessions using
+// MetadataCodec, so the cross-cutting metadata is handled once instead of by every codec.
+type MetadataInterceptor interface {
+	// invoked before the frame of @pkg is encoded. the metadata added to @md is sent
+	// with the frame.
+	Outbound(ss Session, pkg interface{}, md Metadata)
+	// invoked after the frame of @pkg is decoded with its metadata @md, and before
+	// @pkg is delivered to the event listener.
+	Inbound(ss Session, pkg interface{}, md Metadata)
+}
+
+// metadataPkg is a pkg with its metadata
+type metadataPkg struct {
+	pkg interface{}
+	md  Metadata
+}
+
+// WithMetadata attaches @md to @pkg which is written to a session using MetadataCodec,
+// e.g. ss.WritePkg(getty.WithMetadata(pkg, getty.Metadata{"tenant": "alice"}), 0).
+// The interceptors can add or override the keys of @md.
+func WithMetadata(pkg interface{}, md Metadata) interface{} {
+	return &metadataPkg{pkg: pkg, md: md}
+}
+
+type metadataCodec struct {
+	inner        ReadWriter
+	interceptors []MetadataInterceptor
+}
+
+// MetadataCodec wraps the codec @inner of the tcp/ws sessions of both peers. Every frame
+// is prefixed by a metadata header:
+// length(2 bytes) | key length(1 byte) | key | value length(2 bytes) | value | ...
+// The pkgs decoded by @inner are delivered as they are, and their metadata can be got by
+// @interceptors.
+func MetadataCodec(inner ReadWriter, interceptors ...MetadataInterceptor) ReadWriter {
+	return &metadataCodec{inner: inner, interceptors: interceptors}
+}
+
+func (c *metadataCodec) Read(ss Session, data []byte) (interface{}, int, error) {
+	if len(data) < 2 {
+		return nil, 0, nil
+	}
+	headerLen := 2 + int(binary.BigEndian.Uint16(data))
+	if len(data) < headerLen {
+		return nil, 0, nil
+	}
+
+	pkg, pkgLen, err := c.inner.Read(ss, data[headerLen:])
+	if err != nil || pkg == nil {
+		return nil, 0, err
+	}
+	md, err := decodeMetadata(data[2:headerLen])
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, interceptor := range c.interceptors {
+		interceptor.Inbound(ss, pkg, md)
+	}
+
+	return pkg, headerLen + pkgLen, nil
+}
+
+func (c *metadataCodec) Write(ss Session, pkg interface{}) ([]byte, error) {
+	md := Metadata{}
+	if p, ok := pkg.(*metadataPkg); ok {
+		for k, v := range p.md {
+			md[k] = v
+		}
+		pkg = p.pkg
+	}
+	for _, interceptor := range c.interceptors {
+		interceptor.Outbound(ss, pkg, md)
+	}
+
+	header, err := encodeMetadata(md)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.inner.Write(ss, pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(header, data...), nil
+}
+
+// encode @md with its length, in the order of the keys
+func encodeMetadata(md Metadata) ([]byte, error) {
+	keys := make([]string, 0, len(md))
+	size := 2
+	for k, v := range md {
+		if len(k) == 0 || 255 < len(k) || maxMetadataLen < len(v) {
+			return nil, jerrors.Errorf("illegal metadata {key:%q, value length:%d}", k, len(v))
+		}
+		keys = append(keys, k)
+		size += 1 + len(k) + 2 + len(v)
+	}
+	if maxMetadataLen < size-2 {
+		return nil, jerrors.Errorf("metadata length %d exceeds %d", size-2, maxMetadataLen)
+	}
+	sort.Strings(keys)
+
+	b := make([]byte, 2, size)
+	binary.BigEndian.PutUint16(b, uint16(size-2))
+	for _, k := range keys {
+		b = append(b, byte(len(k)))
+		b = append(b, k...)
+		b = append(b, byte(len(md[k])>>8), byte(len(md[k])))
+		b = append(b, md[k]...)
+	}
+
+	return b, nil
+}
+
+func decodeMetadata(b []byte) (Metadata, error) {
+	md := Metadata{}
+	for len(b) > 0 {
+		keyLen := int(b[0])
+		if keyLen == 0 || len(b) < 1+keyLen+2 {
+			return nil, errIllegalMetadata
+		}
+		key := string(b[1 : 1+keyLen])
+		b = b[1+keyLen:]
+		valueLen := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+valueLen {
+			return nil, errIllegalMetadata
+		}
+		md[key] = string(b[2 : 2+valueLen])
+		b = b[2+valueLen:]
+	}
+
+	return md, nil
+}
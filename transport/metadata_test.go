@@ -0,0 +1,117 @@
+package getty
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// traceInterceptor sends its trace id with every frame, and records the received ones
+type traceInterceptor struct {
+	id string
+
+	lock     sync.Mutex
+	received []Metadata
+}
+
+func (i *traceInterceptor) Outbound(ss Session, pkg interface{}, md Metadata) {
+	md["trace-id"] = i.id
+}
+
+func (i *traceInterceptor) Inbound(ss Session, pkg interface{}, md Metadata) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.received = append(i.received, md)
+}
+
+func TestMetadataCodec(t *testing.T) {
+	sender := &traceInterceptor{id: "t1"}
+	codec := MetadataCodec(&lineTransferCodec{}, sender)
+	data, err := codec.Write(nil, WithMetadata("hello", Metadata{"tenant": "alice", "trace-id": "t0"}))
+	assert.Nil(t, err)
+	plain, err := codec.Write(nil, "world")
+	assert.Nil(t, err)
+	data = append(data, plain...)
+
+	receiver := &traceInterceptor{}
+	codec = MetadataCodec(&lineTransferCodec{}, receiver)
+	// the incomplete header and the incomplete pkg
+	for _, n := range []int{1, 10, len(data) - len(plain) - 1} {
+		pkg, pkgLen, err := codec.Read(nil, data[:n])
+		assert.Nil(t, pkg)
+		assert.Zero(t, pkgLen)
+		assert.Nil(t, err)
+	}
+	assert.Empty(t, receiver.received)
+
+	pkg, pkgLen, err := codec.Read(nil, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", pkg)
+	pkg, _, err = codec.Read(nil, data[pkgLen:])
+	assert.Nil(t, err)
+	assert.Equal(t, "world", pkg)
+	// the interceptor overrides the attached trace id
+	assert.Equal(t, []Metadata{{"tenant": "alice", "trace-id": "t1"}, {"trace-id": "t1"}}, receiver.received)
+
+	// the codec without interceptors
+	data, err = MetadataCodec(&lineTransferCodec{}).Write(nil, "hello")
+	assert.Nil(t, err)
+	assert.Equal(t, "\x00\x00hello\n", string(data))
+}
+
+func TestMetadataEncoding(t *testing.T) {
+	md := Metadata{"a": "", "priority": "1", "b": strings.Repeat("x", 300)}
+	b, err := encodeMetadata(md)
+	assert.Nil(t, err)
+	decoded, err := decodeMetadata(b[2:])
+	assert.Nil(t, err)
+	assert.Equal(t, md, decoded)
+
+	for _, md := range []Metadata{
+		{"": "empty key"},
+		{strings.Repeat("k", 256): "long key"},
+		{"k": strings.Repeat("v", maxMetadataLen)},
+	} {
+		_, err = encodeMetadata(md)
+		assert.NotNil(t, err)
+	}
+
+	for _, b := range []string{"\x00", "\x05key", "\x03key\x00\x05v"} {
+		_, err = decodeMetadata([]byte(b))
+		assert.Equal(t, errIllegalMetadata, err, b)
+	}
+}
+
+func TestSessionMetadata(t *testing.T) {
+	receiver := &traceInterceptor{}
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(MetadataCodec(&lineTransferCodec{}, receiver))
+		ss.SetEventListener(listener)
+		return nil
+	})
+	defer server.Close()
+
+	client := newClient(TCP_CLIENT, WithServerAddress(server.streamListener.Addr().String()),
+		WithConnectionNumber(1))
+	defer client.Close()
+	handler := &MessageHandler{}
+	client.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(MetadataCodec(&lineTransferCodec{}, &traceInterceptor{id: "t1"}))
+		ss.SetEventListener(handler)
+		return nil
+	})
+	assert.Equal(t, 1, handler.SessionNumber())
+
+	ss := handler.array[0]
+	assert.Nil(t, ss.WritePkg(WithMetadata("hello", Metadata{"tenant": "alice"}), 0))
+	assert.Equal(t, "hello", <-listener.msgs)
+	receiver.lock.Lock()
+	assert.Equal(t, []Metadata{{"tenant": "alice", "trace-id": "t1"}}, receiver.received)
+	receiver.lock.Unlock()
+}
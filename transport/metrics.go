@@ -0,0 +1,389 @@
+/******************************************************
+# DESC       : endpoint metrics
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-10 16:05
+# FILE       : metrics.go
+******************************************************/
+
+package getty
+
+import (
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+/////////////////////////////////////////
+// histogram
+/////////////////////////////////////////
+
+const (
+	// every power of 2 range is divided into 16 sub buckets, so the relative error is less than 1/16.
+	histogramSubBucketBits = 4
+	histogramSubBucketNum  = 1 << histogramSubBucketBits
+	// the max value that can be recorded is about 2^40ns(18 minutes). the greater value will be
+	// recorded into the last bucket.
+	histogramMaxBits   = 40
+	histogramBucketNum = histogramSubBucketNum * (histogramMaxBits - histogramSubBucketBits + 1)
+)
+
+// Histogram is a HDR-style(log-linear) latency histogram. It is lock free and
+// can be updated by multiple goroutines concurrently.
+type Histogram struct {
+	counts [histogramBucketNum]uint64
+	total  uint64
+	sum    uint64
+	max    int64
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+func histogramBucketIndex(v uint64) int {
+	if v < histogramSubBucketNum {
+		return int(v)
+	}
+
+	shift := bits.Len64(v) - histogramSubBucketBits - 1
+	idx := histogramSubBucketNum*(shift+1) + int(v>>uint(shift)) - histogramSubBucketNum
+	if idx >= histogramBucketNum {
+		idx = histogramBucketNum - 1
+	}
+
+	return idx
+}
+
+// the highest value of the bucket @idx
+func histogramBucketValue(idx int) uint64 {
+	if idx < histogramSubBucketNum {
+		return uint64(idx)
+	}
+
+	shift := uint(idx/histogramSubBucketNum - 1)
+	sub := uint64(idx%histogramSubBucketNum + histogramSubBucketNum)
+	return (sub+1)<<shift - 1
+}
+
+// Record adds a sample into the histogram. negative duration will be recorded as 0.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	atomic.AddUint64(&h.counts[histogramBucketIndex(uint64(d))], 1)
+	atomic.AddUint64(&h.total, 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&h.max, max, int64(d)) {
+			break
+		}
+	}
+}
+
+// Count returns the sample number.
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.total)
+}
+
+// Mean returns the average value of all samples.
+func (h *Histogram) Mean() time.Duration {
+	total := atomic.LoadUint64(&h.total)
+	if total == 0 {
+		return 0
+	}
+
+	return time.Duration(atomic.LoadUint64(&h.sum) / total)
+}
+
+// Max returns the max sample value.
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.max))
+}
+
+// Percentile returns the value below which @p percent of samples fall. @p should be in (0, 100].
+func (h *Histogram) Percentile(p float64) time.Duration {
+	var (
+		total uint64
+		count uint64
+		rank  uint64
+	)
+
+	if p <= 0 || 100 < p {
+		panic(fmt.Sprintf("illegal percentile %f", p))
+	}
+
+	for i := 0; i < histogramBucketNum; i++ {
+		total += atomic.LoadUint64(&h.counts[i])
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank = uint64(p / 100 * float64(total))
+	if rank == 0 {
+		rank = 1
+	}
+	for i := 0; i < histogramBucketNum; i++ {
+		count += atomic.LoadUint64(&h.counts[i])
+		if rank <= count {
+			v := time.Duration(histogramBucketValue(i))
+			if max := h.Max(); max < v {
+				v = max
+			}
+			return v
+		}
+	}
+
+	return h.Max()
+}
+
+// Reset clears all samples. Samples recorded concurrently may be lost.
+func (h *Histogram) Reset() {
+	for i := 0; i < histogramBucketNum; i++ {
+		atomic.StoreUint64(&h.counts[i], 0)
+	}
+	atomic.StoreUint64(&h.total, 0)
+	atomic.StoreUint64(&h.sum, 0)
+	atomic.StoreInt64(&h.max, 0)
+}
+
+func (h *Histogram) String() string {
+	return fmt.Sprintf("{count:%d, mean:%s, p50:%s, p90:%s, p99:%s, p999:%s, max:%s}",
+		h.Count(), h.Mean(), h.Percentile(50), h.Percentile(90), h.Percentile(99), h.Percentile(99.9), h.Max())
+}
+
+/////////////////////////////////////////
+// endpoint metrics
+/////////////////////////////////////////
+
+// EndPointMetrics contains the statistic data of all sessions of an endpoint.
+type EndPointMetrics struct {
+	// number of OnMessage invocations which exceed the slow handler threshold.
+	// keep 64-bit counters at the head of the struct for atomic alignment on 32-bit platforms.
+	slowHandlerNum uint64
+	// number of the connections dropped by the handshake pool of a server for its queue is full,
+	// and number of the handshakes which failed or expired in the queue
+	rejectedHandshakeNum uint64
+	failedHandshakeNum   uint64
+	// number of the queued pkgs replaced by the newer pkgs of the same conflation key
+	conflatedPkgNum uint64
+	// number of the queued pkgs dropped for their ttl expired
+	expiredPkgNum uint64
+	// number of the pkgs which wait in the write queues longer than the starvation threshold
+	starvedPkgNum uint64
+	// number of the failed tls handshakes, ws upgrades & tcp negotiations of a server by cause
+	handshakeFailures [handshakeFailureCauseNum]uint64
+	// number of the actions denied & limited by the policy by stage
+	policyDenials [policyStageNum]uint64
+	policyLimits  [policyStageNum]uint64
+	// the earliest expiry of the certificates checked by the cert expiry monitor in unix seconds
+	certNotAfter int64
+	// number of the framing errors recovered by the resync of the tcp sessions, and number of
+	// the bytes discarded by the resync
+	resyncNum            uint64
+	resyncDiscardedBytes uint64
+	// number of the sessions closed for their first pkgs do not begin with the magic prefix
+	badMagicNum uint64
+	// number of the rejected connections which have been tarpitted
+	tarpittedNum uint64
+	// number of the connections held by the tarpit
+	tarpitConns int64
+
+	// resource budget
+	sessionNum        int64
+	readGoroutineNum  int64
+	writeGoroutineNum int64
+	pendingTaskNum    int64
+	runningTaskNum    int64
+	readBufferBytes   int64
+	queuedPkgNum      int64
+
+	// one of every @sampleRate read/write operations will be sampled. 0 means disabled.
+	sampleRate uint32
+
+	// duration from frame arrival to (EventListener)OnMessage completion
+	ReadLatency *Histogram
+	// duration from (Session)WritePkg to socket flush
+	WriteLatency *Histogram
+	// end-to-end latency measured by the probe frames, see (SessionObserver)SetLatencyProbe
+	ProbeLatency *Histogram
+	// duration of the pkgs in the write queues, which is recorded if the starvation
+	// threshold of the endpoint is set
+	QueueDelay *Histogram
+}
+
+func newEndPointMetrics(sampleRate int) *EndPointMetrics {
+	if sampleRate < 0 {
+		sampleRate = 0
+	}
+
+	return &EndPointMetrics{
+		sampleRate:   uint32(sampleRate),
+		ReadLatency:  NewHistogram(),
+		WriteLatency: NewHistogram(),
+		ProbeLatency: NewHistogram(),
+		QueueDelay:   NewHistogram(),
+	}
+}
+
+// SampleRate returns the latency sample rate. 0 means latency sampling is disabled.
+func (m *EndPointMetrics) SampleRate() int {
+	return int(atomic.LoadUint32(&m.sampleRate))
+}
+
+// SetSampleRate sets the latency sample rate. one of every @rate read/write operations will be sampled.
+func (m *EndPointMetrics) SetSampleRate(rate int) {
+	if rate < 0 {
+		rate = 0
+	}
+	atomic.StoreUint32(&m.sampleRate, uint32(rate))
+}
+
+// SlowHandlerNum returns the number of OnMessage invocations flagged by the slow handler watchdog.
+func (m *EndPointMetrics) SlowHandlerNum() uint64 {
+	return atomic.LoadUint64(&m.slowHandlerNum)
+}
+
+// RejectedHandshakeNum returns the number of the connections closed by the handshake pool
+// of the server for its queue is full.
+func (m *EndPointMetrics) RejectedHandshakeNum() uint64 {
+	return atomic.LoadUint64(&m.rejectedHandshakeNum)
+}
+
+// FailedHandshakeNum returns the number of the handshakes in the handshake pool of the server
+// which failed or timed out.
+func (m *EndPointMetrics) FailedHandshakeNum() uint64 {
+	return atomic.LoadUint64(&m.failedHandshakeNum)
+}
+
+// ConflatedPkgNum returns the number of the queued pkgs replaced by the newer pkgs of the
+// same conflation key, see (SessionWriter)WriteConflated.
+func (m *EndPointMetrics) ConflatedPkgNum() uint64 {
+	return atomic.LoadUint64(&m.conflatedPkgNum)
+}
+
+// ExpiredPkgNum returns the number of the pkgs dropped for their ttl expired in the write
+// queue, see (SessionWriter)WritePkgTTL.
+func (m *EndPointMetrics) ExpiredPkgNum() uint64 {
+	return atomic.LoadUint64(&m.expiredPkgNum)
+}
+
+// PolicyStats returns the number of the actions denied & limited by the policy by stage.
+func (m *EndPointMetrics) PolicyStats() PolicyStats {
+	return PolicyStats{
+		AcceptDenied:     atomic.LoadUint64(&m.policyDenials[PolicyStageAccept]),
+		AcceptLimited:    atomic.LoadUint64(&m.policyLimits[PolicyStageAccept]),
+		HandshakeDenied:  atomic.LoadUint64(&m.policyDenials[PolicyStageHandshake]),
+		HandshakeLimited: atomic.LoadUint64(&m.policyLimits[PolicyStageHandshake]),
+		WriteDenied:      atomic.LoadUint64(&m.policyDenials[PolicyStageWrite]),
+		WriteLimited:     atomic.LoadUint64(&m.policyLimits[PolicyStageWrite]),
+	}
+}
+
+// CertDaysRemaining returns the whole days until the earliest expiry of the certificates of
+// the tls endpoint, and false if they are not monitored, see CertExpiryConfig.
+func (m *EndPointMetrics) CertDaysRemaining() (int, bool) {
+	notAfter := atomic.LoadInt64(&m.certNotAfter)
+	if notAfter == 0 {
+		return 0, false
+	}
+
+	return int(time.Until(time.Unix(notAfter, 0)) / day), true
+}
+
+// ResyncNum returns the number of the framing errors recovered by the resync of the tcp
+// sessions, see ResyncConfig.
+func (m *EndPointMetrics) ResyncNum() uint64 {
+	return atomic.LoadUint64(&m.resyncNum)
+}
+
+// ResyncDiscardedBytes returns the number of the bytes discarded by the resync of the tcp sessions.
+func (m *EndPointMetrics) ResyncDiscardedBytes() uint64 {
+	return atomic.LoadUint64(&m.resyncDiscardedBytes)
+}
+
+// BadMagicNum returns the number of the sessions closed for their first pkgs do not begin
+// with the magic prefix, see WithServerMagic.
+func (m *EndPointMetrics) BadMagicNum() uint64 {
+	return atomic.LoadUint64(&m.badMagicNum)
+}
+
+// TarpittedNum returns the number of the rejected connections which have been tarpitted,
+// see TarpitConfig.
+func (m *EndPointMetrics) TarpittedNum() uint64 {
+	return atomic.LoadUint64(&m.tarpittedNum)
+}
+
+// TarpitConns returns the number of the connections held by the tarpit now.
+func (m *EndPointMetrics) TarpitConns() int64 {
+	return atomic.LoadInt64(&m.tarpitConns)
+}
+
+// HandshakeFailures returns the number of the failed handshakes of the server by cause.
+func (m *EndPointMetrics) HandshakeFailures() HandshakeFailureStats {
+	return HandshakeFailureStats{
+		Timeout:     atomic.LoadUint64(&m.handshakeFailures[HandshakeFailureTimeout]),
+		Closed:      atomic.LoadUint64(&m.handshakeFailures[HandshakeFailureClosed]),
+		TLS:         atomic.LoadUint64(&m.handshakeFailures[HandshakeFailureTLS]),
+		Upgrade:     atomic.LoadUint64(&m.handshakeFailures[HandshakeFailureUpgrade]),
+		Negotiation: atomic.LoadUint64(&m.handshakeFailures[HandshakeFailureNegotiation]),
+	}
+}
+
+// EndPointBudget is a snapshot of the resources used by all sessions of an endpoint.
+type EndPointBudget struct {
+	// number of running sessions
+	SessionNum int64
+	// number of session read goroutines(session.handlePackage)
+	ReadGoroutineNum int64
+	// number of session write goroutines(session.handleLoop)
+	WriteGoroutineNum int64
+	// number of OnMessage tasks which have been put into task pool but not started yet
+	PendingTaskNum int64
+	// number of running OnMessage tasks, no matter whether they run in task pool or not
+	RunningTaskNum int64
+	// bytes of tcp/udp read buffers held by sessions. websocket read buffers are
+	// held by gorilla/websocket and are not included.
+	ReadBufferBytes int64
+	// number of pkgs in session write queues
+	QueuedPkgNum int64
+}
+
+func (b EndPointBudget) String() string {
+	return fmt.Sprintf("{session:%d, read gr:%d, write gr:%d, pending task:%d, running task:%d, "+
+		"read buffer bytes:%d, queued pkg:%d}", b.SessionNum, b.ReadGoroutineNum, b.WriteGoroutineNum,
+		b.PendingTaskNum, b.RunningTaskNum, b.ReadBufferBytes, b.QueuedPkgNum)
+}
+
+// Budget returns the current resource usage of the endpoint.
+func (m *EndPointMetrics) Budget() EndPointBudget {
+	return EndPointBudget{
+		SessionNum:        atomic.LoadInt64(&m.sessionNum),
+		ReadGoroutineNum:  atomic.LoadInt64(&m.readGoroutineNum),
+		WriteGoroutineNum: atomic.LoadInt64(&m.writeGoroutineNum),
+		PendingTaskNum:    atomic.LoadInt64(&m.pendingTaskNum),
+		RunningTaskNum:    atomic.LoadInt64(&m.runningTaskNum),
+		ReadBufferBytes:   atomic.LoadInt64(&m.readBufferBytes),
+		QueuedPkgNum:      atomic.LoadInt64(&m.queuedPkgNum),
+	}
+}
+
+// @seq is the sample sequence of a session
+func (m *EndPointMetrics) sample(seq *uint32) bool {
+	rate := atomic.LoadUint32(&m.sampleRate)
+	if rate == 0 {
+		return false
+	}
+
+	return atomic.AddUint32(seq, 1)%rate == 0
+}
+
+func (m *EndPointMetrics) String() string {
+	return fmt.Sprintf("{read latency:%s, write latency:%s, probe latency:%s, slow handler num:%d, budget:%s}",
+		m.ReadLatency, m.WriteLatency, m.ProbeLatency, m.SlowHandlerNum(), m.Budget())
+}
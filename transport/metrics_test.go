@@ -0,0 +1,58 @@
+package getty
+
+import (
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramBucket(t *testing.T) {
+	for _, v := range []uint64{0, 1, 15, 16, 17, 31, 32, 33, 1000, 123456789, 1 << 39} {
+		idx := histogramBucketIndex(v)
+		assert.True(t, v <= histogramBucketValue(idx), "v:%d, idx:%d", v, idx)
+		if 0 < idx {
+			assert.True(t, histogramBucketValue(idx-1) < v, "v:%d, idx:%d", v, idx)
+		}
+	}
+	assert.Equal(t, histogramBucketNum-1, histogramBucketIndex(1<<63))
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram()
+	assert.Equal(t, time.Duration(0), h.Percentile(99))
+
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	assert.Equal(t, uint64(1000), h.Count())
+	assert.Equal(t, 1000*time.Microsecond, h.Max())
+	assert.InDelta(t, float64(500500*time.Nanosecond), float64(h.Mean()), float64(time.Microsecond))
+	assert.InEpsilon(t, float64(500*time.Microsecond), float64(h.Percentile(50)), 1.0/16)
+	assert.InEpsilon(t, float64(990*time.Microsecond), float64(h.Percentile(99)), 1.0/16)
+	assert.Equal(t, h.Max(), h.Percentile(100))
+	assert.Panics(t, func() { h.Percentile(0) })
+
+	h.Reset()
+	assert.Equal(t, uint64(0), h.Count())
+	assert.Equal(t, time.Duration(0), h.Max())
+}
+
+func TestEndPointMetricsSample(t *testing.T) {
+	var seq uint32
+
+	m := newEndPointMetrics(0)
+	assert.False(t, m.sample(&seq))
+
+	m.SetSampleRate(4)
+	assert.Equal(t, 4, m.SampleRate())
+	num := 0
+	for i := 0; i < 100; i++ {
+		if m.sample(&seq) {
+			num++
+		}
+	}
+	assert.Equal(t, 25, num)
+}
@@ -0,0 +1,339 @@
+/******************************************************
+# DESC       : runtime/metrics style & OpenMetrics export of endpoint metrics
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-18 11:00
+# FILE       : metricsexport.go
+******************************************************/
+
+package getty
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime/metrics"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MetricKind is the kind of a metric value, which is the same as metrics.ValueKind of
+// runtime/metrics.
+type MetricKind int
+
+const (
+	MetricKindBad MetricKind = iota
+	MetricKindUint64
+	MetricKindFloat64
+	MetricKindFloat64Histogram
+)
+
+// MetricDescription describes a metric of EndPointMetrics, whose name is in the form of the
+// runtime/metrics names, i.e. "/getty/<path>:<unit>".
+type MetricDescription struct {
+	Name        string
+	Description string
+	Kind        MetricKind
+	// Cumulative is true if the metric is a counter, otherwise it is a gauge
+	Cumulative bool
+}
+
+// MetricValue is the value of a metric, whose accessors panic if the kind mismatches like
+// metrics.Value of runtime/metrics.
+type MetricValue struct {
+	kind      MetricKind
+	uint64    uint64
+	float64   float64
+	histogram *metrics.Float64Histogram
+}
+
+func (v MetricValue) Kind() MetricKind {
+	return v.kind
+}
+
+func (v MetricValue) Uint64() uint64 {
+	if v.kind != MetricKindUint64 {
+		panic("called Uint64 on non-uint64 metric value")
+	}
+	return v.uint64
+}
+
+func (v MetricValue) Float64() float64 {
+	if v.kind != MetricKindFloat64 {
+		panic("called Float64 on non-float64 metric value")
+	}
+	return v.float64
+}
+
+// Float64Histogram returns the histogram in seconds. Its last bucket is unbounded.
+func (v MetricValue) Float64Histogram() *metrics.Float64Histogram {
+	if v.kind != MetricKindFloat64Histogram {
+		panic("called Float64Histogram on non-histogram metric value")
+	}
+	return v.histogram
+}
+
+// MetricSample is a metric read by (EndPointMetrics)ReadMetrics.
+type MetricSample struct {
+	Name  string
+	Value MetricValue
+}
+
+type endPointMetric struct {
+	MetricDescription
+	value func(*EndPointMetrics) MetricValue
+	// the histogram of the histogram metric
+	histogram func(*EndPointMetrics) *Histogram
+}
+
+func counterMetric(name, desc string, counter func(*EndPointMetrics) *uint64) endPointMetric {
+	return endPointMetric{
+		MetricDescription: MetricDescription{Name: name, Description: desc, Kind: MetricKindUint64, Cumulative: true},
+		value: func(m *EndPointMetrics) MetricValue {
+			return MetricValue{kind: MetricKindUint64, uint64: atomic.LoadUint64(counter(m))}
+		},
+	}
+}
+
+func gaugeMetric(name, desc string, gauge func(*EndPointMetrics) *int64) endPointMetric {
+	return endPointMetric{
+		MetricDescription: MetricDescription{Name: name, Description: desc, Kind: MetricKindUint64},
+		value: func(m *EndPointMetrics) MetricValue {
+			v := atomic.LoadInt64(gauge(m))
+			if v < 0 {
+				v = 0
+			}
+			return MetricValue{kind: MetricKindUint64, uint64: uint64(v)}
+		},
+	}
+}
+
+func histogramMetric(name, desc string, histogram func(*EndPointMetrics) *Histogram) endPointMetric {
+	return endPointMetric{
+		MetricDescription: MetricDescription{Name: name, Description: desc, Kind: MetricKindFloat64Histogram, Cumulative: true},
+		value: func(m *EndPointMetrics) MetricValue {
+			return MetricValue{kind: MetricKindFloat64Histogram, histogram: histogram(m).float64Histogram()}
+		},
+		histogram: histogram,
+	}
+}
+
+// the bucket boundaries of the histograms in seconds
+var histogramBuckets = func() []float64 {
+	buckets := make([]float64, histogramBucketNum+1)
+	for i := 0; i < histogramBucketNum-1; i++ {
+		buckets[i+1] = float64(histogramBucketValue(i)+1) / 1e9
+	}
+	// the last bucket records the greater values as well
+	buckets[histogramBucketNum] = math.Inf(1)
+	return buckets
+}()
+
+// the snapshot of the histogram in the form of runtime/metrics
+func (h *Histogram) float64Histogram() *metrics.Float64Histogram {
+	counts := make([]uint64, histogramBucketNum)
+	for i := range counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return &metrics.Float64Histogram{Counts: counts, Buckets: histogramBuckets}
+}
+
+var endPointMetricTable = func() []endPointMetric {
+	table := []endPointMetric{
+		gaugeMetric("/getty/sessions:sessions", "Number of the running sessions.",
+			func(m *EndPointMetrics) *int64 { return &m.sessionNum }),
+		gaugeMetric("/getty/goroutines/read:goroutines", "Number of the session read goroutines.",
+			func(m *EndPointMetrics) *int64 { return &m.readGoroutineNum }),
+		gaugeMetric("/getty/goroutines/write:goroutines", "Number of the session write goroutines.",
+			func(m *EndPointMetrics) *int64 { return &m.writeGoroutineNum }),
+		gaugeMetric("/getty/tasks/pending:tasks", "Number of the OnMessage tasks queued in the task pool.",
+			func(m *EndPointMetrics) *int64 { return &m.pendingTaskNum }),
+		gaugeMetric("/getty/tasks/running:tasks", "Number of the running OnMessage tasks.",
+			func(m *EndPointMetrics) *int64 { return &m.runningTaskNum }),
+		gaugeMetric("/getty/buffers/read:bytes", "Bytes of the tcp/udp read buffers held by the sessions.",
+			func(m *EndPointMetrics) *int64 { return &m.readBufferBytes }),
+		gaugeMetric("/getty/queue/pkgs:pkgs", "Number of the pkgs in the session write queues.",
+			func(m *EndPointMetrics) *int64 { return &m.queuedPkgNum }),
+		gaugeMetric("/getty/tarpit/conns:connections", "Number of the connections held by the tarpit.",
+			func(m *EndPointMetrics) *int64 { return &m.tarpitConns }),
+		counterMetric("/getty/handler/slow:calls", "Number of the OnMessage invocations exceeding the slow handler threshold.",
+			func(m *EndPointMetrics) *uint64 { return &m.slowHandlerNum }),
+		counterMetric("/getty/handshake/rejected:connections", "Number of the connections rejected by the full handshake queue.",
+			func(m *EndPointMetrics) *uint64 { return &m.rejectedHandshakeNum }),
+		counterMetric("/getty/handshake/failed:handshakes", "Number of the handshakes failed or expired in the handshake queue.",
+			func(m *EndPointMetrics) *uint64 { return &m.failedHandshakeNum }),
+		counterMetric("/getty/pkgs/conflated:pkgs", "Number of the queued pkgs replaced by the newer pkgs of the same conflation key.",
+			func(m *EndPointMetrics) *uint64 { return &m.conflatedPkgNum }),
+		counterMetric("/getty/pkgs/expired:pkgs", "Number of the queued pkgs dropped for their ttl expired.",
+			func(m *EndPointMetrics) *uint64 { return &m.expiredPkgNum }),
+		counterMetric("/getty/pkgs/starved:pkgs", "Number of the pkgs which wait in the write queues longer than the starvation threshold.",
+			func(m *EndPointMetrics) *uint64 { return &m.starvedPkgNum }),
+		counterMetric("/getty/resync/errors:errors", "Number of the framing errors recovered by the resync.",
+			func(m *EndPointMetrics) *uint64 { return &m.resyncNum }),
+		counterMetric("/getty/resync/discarded:bytes", "Bytes discarded by the resync.",
+			func(m *EndPointMetrics) *uint64 { return &m.resyncDiscardedBytes }),
+		counterMetric("/getty/magic/bad:sessions", "Number of the sessions closed for the bad magic prefix.",
+			func(m *EndPointMetrics) *uint64 { return &m.badMagicNum }),
+		counterMetric("/getty/tarpit/tarpitted:connections", "Number of the rejected connections which have been tarpitted.",
+			func(m *EndPointMetrics) *uint64 { return &m.tarpittedNum }),
+	}
+
+	for c := HandshakeFailureCause(0); c < handshakeFailureCauseNum; c++ {
+		cause := c
+		table = append(table, counterMetric("/getty/handshake/failures/"+cause.String()+":handshakes",
+			"Number of the failed handshakes of the cause "+cause.String()+".",
+			func(m *EndPointMetrics) *uint64 { return &m.handshakeFailures[cause] }))
+	}
+	for s := PolicyStage(0); s < policyStageNum; s++ {
+		stage := s
+		table = append(table,
+			counterMetric("/getty/policy/denied/"+stage.String()+":actions",
+				"Number of the actions denied by the policy at the stage "+stage.String()+".",
+				func(m *EndPointMetrics) *uint64 { return &m.policyDenials[stage] }),
+			counterMetric("/getty/policy/limited/"+stage.String()+":actions",
+				"Number of the actions limited by the policy at the stage "+stage.String()+".",
+				func(m *EndPointMetrics) *uint64 { return &m.policyLimits[stage] }))
+	}
+
+	return append(table,
+		endPointMetric{
+			MetricDescription: MetricDescription{
+				Name:        "/getty/cert/remaining:seconds",
+				Description: "Seconds until the earliest expiry of the monitored certificates, NaN if they are not monitored.",
+				Kind:        MetricKindFloat64,
+			},
+			value: func(m *EndPointMetrics) MetricValue {
+				remaining := math.NaN()
+				if notAfter := atomic.LoadInt64(&m.certNotAfter); notAfter != 0 {
+					remaining = time.Until(time.Unix(notAfter, 0)).Seconds()
+				}
+				return MetricValue{kind: MetricKindFloat64, float64: remaining}
+			},
+		},
+		histogramMetric("/getty/latency/read:seconds", "Duration from the frame arrival to the OnMessage completion.",
+			func(m *EndPointMetrics) *Histogram { return m.ReadLatency }),
+		histogramMetric("/getty/latency/write:seconds", "Duration from WritePkg to the socket flush.",
+			func(m *EndPointMetrics) *Histogram { return m.WriteLatency }),
+		histogramMetric("/getty/latency/probe:seconds", "End-to-end latency measured by the probe frames.",
+			func(m *EndPointMetrics) *Histogram { return m.ProbeLatency }),
+		histogramMetric("/getty/latency/queue:seconds", "Duration of the pkgs in the write queues.",
+			func(m *EndPointMetrics) *Histogram { return m.QueueDelay }),
+	)
+}()
+
+var endPointMetricIndex = func() map[string]int {
+	index := make(map[string]int, len(endPointMetricTable))
+	for i, metric := range endPointMetricTable {
+		index[metric.Name] = i
+	}
+	return index
+}()
+
+// AllEndPointMetrics returns the descriptions of the metrics of EndPointMetrics, which can be
+// read by (EndPointMetrics)ReadMetrics, like metrics.All of runtime/metrics.
+func AllEndPointMetrics() []MetricDescription {
+	descs := make([]MetricDescription, len(endPointMetricTable))
+	for i, metric := range endPointMetricTable {
+		descs[i] = metric.MetricDescription
+	}
+	return descs
+}
+
+// ReadMetrics fills the values of @samples by their names like metrics.Read of runtime/metrics,
+// so the collection agents of runtime/metrics can read the endpoint metrics as well. The value
+// of an unknown name is of MetricKindBad.
+func (m *EndPointMetrics) ReadMetrics(samples []MetricSample) {
+	for i := range samples {
+		idx, ok := endPointMetricIndex[samples[i].Name]
+		if !ok {
+			samples[i].Value = MetricValue{}
+			continue
+		}
+		samples[i].Value = endPointMetricTable[idx].value(m)
+	}
+}
+
+/////////////////////////////////////////
+// OpenMetrics
+/////////////////////////////////////////
+
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// the OpenMetrics name of the runtime/metrics style name, e.g. "/getty/sessions:sessions" is
+// "getty_sessions_sessions", which is the way of the go collector of prometheus.
+func openMetricsName(name string) string {
+	return strings.NewReplacer("/", "_", ":", "_").Replace(strings.TrimPrefix(name, "/"))
+}
+
+// WriteOpenMetrics writes the metrics of @endpoints in the OpenMetrics text format, whose
+// samples are labeled by the endpoint type & id. The histograms are exposed by the buckets
+// of the power of 2 nanoseconds.
+func WriteOpenMetrics(w io.Writer, endpoints ...EndPoint) error {
+	bw := bufio.NewWriter(w)
+	samples := make([]MetricSample, 1)
+	for _, metric := range endPointMetricTable {
+		name := openMetricsName(metric.Name)
+		typ := "gauge"
+		switch {
+		case metric.Kind == MetricKindFloat64Histogram:
+			typ = "histogram"
+		case metric.Cumulative:
+			typ = "counter"
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n# HELP %s %s\n", name, typ, name, metric.Description)
+
+		for _, endpoint := range endpoints {
+			labels := fmt.Sprintf(`endpoint_type="%s",endpoint_id="%d"`, endpoint.EndPointType(), endpoint.ID())
+			if metric.histogram != nil {
+				writeOpenMetricsHistogram(bw, name, labels, metric.histogram(endpoint.Metrics()))
+				continue
+			}
+
+			samples[0].Name = metric.Name
+			endpoint.Metrics().ReadMetrics(samples)
+			value := samples[0].Value
+			switch value.Kind() {
+			case MetricKindUint64:
+				if metric.Cumulative {
+					fmt.Fprintf(bw, "%s_total{%s} %d\n", name, labels, value.Uint64())
+				} else {
+					fmt.Fprintf(bw, "%s{%s} %d\n", name, labels, value.Uint64())
+				}
+			case MetricKindFloat64:
+				if f := value.Float64(); !math.IsNaN(f) {
+					fmt.Fprintf(bw, "%s{%s} %g\n", name, labels, f)
+				}
+			}
+		}
+	}
+	bw.WriteString("# EOF\n")
+
+	return bw.Flush()
+}
+
+func writeOpenMetricsHistogram(w io.Writer, name, labels string, h *Histogram) {
+	var count uint64
+	for i := 0; i < histogramBucketNum; i++ {
+		count += atomic.LoadUint64(&h.counts[i])
+		// one bucket every power of 2
+		if (i+1)%histogramSubBucketNum == 0 && i+1 < histogramBucketNum {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, histogramBuckets[i+1], count)
+		}
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, count)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, count)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, float64(atomic.LoadUint64(&h.sum))/1e9)
+}
+
+// OpenMetricsHandler returns the http handler which serves the metrics of @endpoints in the
+// OpenMetrics text format, e.g. http.Handle("/metrics", getty.OpenMetricsHandler(server)).
+func OpenMetricsHandler(endpoints ...EndPoint) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", openMetricsContentType)
+		WriteOpenMetrics(w, endpoints...)
+	})
+}
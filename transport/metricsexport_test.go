@@ -0,0 +1,100 @@
+package getty
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndPointMetricsReadMetrics(t *testing.T) {
+	m := newEndPointMetrics(1)
+	m.sessionNum = 3
+	m.slowHandlerNum = 2
+	m.handshakeFailures[HandshakeFailureTLS] = 5
+	m.policyDenials[PolicyStageWrite] = 7
+	m.ReadLatency.Record(time.Millisecond)
+	m.ReadLatency.Record(time.Hour)
+
+	descs := AllEndPointMetrics()
+	samples := make([]MetricSample, len(descs)+1)
+	names := make(map[string]MetricDescription)
+	for i, desc := range descs {
+		assert.True(t, strings.HasPrefix(desc.Name, "/getty/"), desc.Name)
+		assert.NotContains(t, names, desc.Name)
+		names[desc.Name] = desc
+		samples[i].Name = desc.Name
+	}
+	samples[len(descs)].Name = "/getty/unknown:bytes"
+	m.ReadMetrics(samples)
+
+	values := make(map[string]MetricValue)
+	for i, sample := range samples[:len(descs)] {
+		assert.Equal(t, descs[i].Kind, sample.Value.Kind(), sample.Name)
+		values[sample.Name] = sample.Value
+	}
+	assert.Equal(t, MetricKindBad, samples[len(descs)].Value.Kind())
+	assert.Equal(t, uint64(3), values["/getty/sessions:sessions"].Uint64())
+	assert.False(t, names["/getty/sessions:sessions"].Cumulative)
+	assert.Equal(t, uint64(2), values["/getty/handler/slow:calls"].Uint64())
+	assert.True(t, names["/getty/handler/slow:calls"].Cumulative)
+	assert.Equal(t, uint64(5), values["/getty/handshake/failures/tls:handshakes"].Uint64())
+	assert.Equal(t, uint64(7), values["/getty/policy/denied/write:actions"].Uint64())
+	assert.True(t, math.IsNaN(values["/getty/cert/remaining:seconds"].Float64()))
+	assert.Panics(t, func() { values["/getty/sessions:sessions"].Float64() })
+
+	h := values["/getty/latency/read:seconds"].Float64Histogram()
+	assert.Equal(t, len(h.Counts)+1, len(h.Buckets))
+	assert.True(t, math.IsInf(h.Buckets[len(h.Buckets)-1], 1))
+	var count uint64
+	for i, c := range h.Counts {
+		if c == 0 {
+			continue
+		}
+		count += c
+		if count == 1 {
+			// the bucket of 1ms
+			assert.True(t, h.Buckets[i] <= 0.001 && 0.001 < h.Buckets[i+1])
+		}
+	}
+	assert.Equal(t, uint64(2), count)
+}
+
+func TestOpenMetrics(t *testing.T) {
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+	client := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+	server.Metrics().sessionNum = 2
+	client.Metrics().expiredPkgNum = 4
+	client.Metrics().WriteLatency.Record(1500 * time.Microsecond)
+	server.Metrics().certNotAfter = time.Now().Add(time.Hour).Unix()
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteOpenMetrics(&buf, server, client))
+	text := buf.String()
+	serverLabels := `{endpoint_type="TCP_SERVER",endpoint_id="` + strconv.Itoa(int(server.ID())) + `"}`
+	clientLabels := `{endpoint_type="TCP_CLIENT",endpoint_id="` + strconv.Itoa(int(client.ID())) + `"}`
+	assert.Contains(t, text, "# TYPE getty_sessions_sessions gauge\n")
+	assert.Contains(t, text, "getty_sessions_sessions"+serverLabels+" 2\n")
+	assert.Contains(t, text, "# TYPE getty_pkgs_expired_pkgs counter\n")
+	assert.Contains(t, text, "getty_pkgs_expired_pkgs_total"+clientLabels+" 4\n")
+	assert.Contains(t, text, "# TYPE getty_latency_write_seconds histogram\n")
+	assert.Contains(t, text, `getty_latency_write_seconds_bucket{endpoint_type="TCP_CLIENT",endpoint_id="`+strconv.Itoa(int(client.ID()))+`",le="+Inf"} 1`+"\n")
+	assert.Contains(t, text, "getty_latency_write_seconds_count"+clientLabels+" 1\n")
+	assert.Contains(t, text, "getty_latency_write_seconds_sum"+clientLabels+" 0.0015\n")
+	// the cert expiry of the client is not monitored
+	assert.Contains(t, text, "getty_cert_remaining_seconds"+serverLabels+" ")
+	assert.NotContains(t, text, "getty_cert_remaining_seconds"+clientLabels)
+	assert.True(t, strings.HasSuffix(text, "# EOF\n"))
+
+	rec := httptest.NewRecorder()
+	OpenMetricsHandler(server, client).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, openMetricsContentType, rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "getty_sessions_sessions"+serverLabels+" 2\n")
+}
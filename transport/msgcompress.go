@@ -0,0 +1,226 @@
+/******************************************************
+# DESC       : per message compression of tcp session
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-26 11:15
+# FILE       : msgcompress.go
+******************************************************/
+
+package getty
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+import (
+	"github.com/golang/snappy"
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	msgFlagRaw        byte = 0
+	msgFlagCompressed byte = 1
+
+	defaultMsgCompressThreshold = 512
+	// the max size of a framed message, which defends the reader against a bogus length
+	maxFramedMessageLen = 64 * 1024 * 1024
+)
+
+var (
+	errIllegalMsgFrame = jerrors.New("illegal message frame")
+)
+
+// MessageCompressConfig is the per message compression config of a tcp session, which is
+// set by (StreamSession)SetMessageCompression. Once it is enabled, every message written by the
+// session carries a mini-header:
+// flag(1 byte, 1 means compressed) | payload length(uvarint)
+// so both peers should enable it with the same compress type.
+type MessageCompressConfig struct {
+	// CompressSnappy, or one of the flate levels such as CompressBestSpeed. Its default
+	// value is CompressSnappy. The messages are compressed by block snappy, so CompressSnappyBlock
+	// is the same as CompressSnappy, and the session interops with the peer whose stream
+	// compression is CompressSnappyBlock.
+	Type CompressType
+	// the messages whose size is not less than it are compressed, unless the writer of the
+	// session implements CompressMarker. Its default value is 512.
+	Threshold int
+}
+
+func (c MessageCompressConfig) withDefaults() MessageCompressConfig {
+	if c.Type == CompressNone {
+		c.Type = CompressSnappy
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = defaultMsgCompressThreshold
+	}
+
+	return c
+}
+
+// whether the messages are compressed by block snappy
+func (c MessageCompressConfig) snappy() bool {
+	return c.Type == CompressSnappy || c.Type == CompressSnappyBlock
+}
+
+// CompressMarker is an optional interface of the Writer of a session with the per message
+// compression, which marks every encoded message as compress/no-compress, e.g. a large
+// payload is compressed while a tiny control frame skips the cost.
+type CompressMarker interface {
+	// @data is the encoding result of @pkg
+	ShouldCompress(ss Session, pkg interface{}, data []byte) bool
+}
+
+type msgCompressor struct {
+	config MessageCompressConfig
+}
+
+func newMsgCompressor(config MessageCompressConfig) *msgCompressor {
+	if !config.snappy() && (config.Type < flate.HuffmanOnly || flate.BestCompression < config.Type) {
+		panic(fmt.Sprintf("illegal comparess type %d", config.Type))
+	}
+
+	return &msgCompressor{config: config}
+}
+
+func (c *msgCompressor) compress(data []byte) ([]byte, error) {
+	if c.config.snappy() {
+		return snappy.Encode(nil, data), nil
+	}
+
+	var buf bytes.Buffer
+	fw := getFlateWriter(&buf, int(c.config.Type))
+	defer putFlateWriter(fw, int(c.config.Type))
+	if _, err := fw.Write(data); err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	if err := fw.Close(); err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// frame @data with the mini-header, and compress it if @compress is true and it
+// gets smaller.
+func (c *msgCompressor) frame(data []byte, compress bool) ([]byte, error) {
+	flag, payload := msgFlagRaw, data
+	if compress {
+		compressed, err := c.compress(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(data) {
+			flag, payload = msgFlagCompressed, compressed
+		}
+	}
+
+	b := make([]byte, 1+binary.MaxVarintLen64, 1+binary.MaxVarintLen64+len(payload))
+	b[0] = flag
+	n := binary.PutUvarint(b[1:], uint64(len(payload)))
+	return append(b[:1+n], payload...), nil
+}
+
+// msgDecompressReader strips the mini-headers of the framed messages, and delivers
+// the decompressed stream to the session reader.
+type msgDecompressReader struct {
+	config  MessageCompressConfig
+	r       *bufio.Reader
+	pending []byte
+}
+
+func newMsgDecompressReader(r io.Reader, config MessageCompressConfig) *msgDecompressReader {
+	return &msgDecompressReader{config: config, r: bufio.NewReader(r)}
+}
+
+func (r *msgDecompressReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *msgDecompressReader) next() error {
+	flag, err := r.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	length, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return err
+	}
+	if (flag != msgFlagRaw && flag != msgFlagCompressed) || length > maxFramedMessageLen {
+		return jerrors.Annotatef(errIllegalMsgFrame, "flag:%d, length:%d", flag, length)
+	}
+	payload := make([]byte, length)
+	if _, err = io.ReadFull(r.r, payload); err != nil {
+		return err
+	}
+
+	if flag == msgFlagRaw {
+		r.pending = payload
+		return nil
+	}
+	if r.config.snappy() {
+		size, err := snappy.DecodedLen(payload)
+		if err != nil || size > maxFramedMessageLen {
+			return jerrors.Annotatef(errIllegalMsgFrame, "snappy decoded length:%d, error:%v", size, err)
+		}
+		r.pending, err = snappy.Decode(nil, payload)
+		return jerrors.Trace(err)
+	}
+	fr := getFlateReader(bytes.NewReader(payload))
+	defer putFlateReader(fr)
+	r.pending, err = ioutil.ReadAll(io.LimitReader(fr, maxFramedMessageLen))
+	return jerrors.Trace(err)
+}
+
+// SetMessageCompression enables the per message compression of a tcp session, and nil
+// @config disables it. It should be invoked before the session runs, e.g. in NewSessionCallback,
+// and it should not be used together with the stream compression set by SetCompressType.
+// The bytes written by WriteBytes/WriteBytesArray are not framed, so pls write pkgs by WritePkg.
+// It has no effect on udp/websocket sessions.
+func (s *session) SetMessageCompression(config *MessageCompressConfig) {
+	conn, ok := s.Connection.(*gettyTCPConn)
+	if !ok {
+		return
+	}
+	if config == nil {
+		conn.msgCompress = nil
+		conn.setReadLayer(nil, nil)
+		return
+	}
+
+	c := config.withDefaults()
+	conn.msgCompress = newMsgCompressor(c)
+	conn.setReadLayer(func(base io.Reader) io.Reader {
+		return newMsgDecompressReader(base, c)
+	}, nil)
+}
+
+// frame the encoding result @data of @pkg if the per message compression is enabled.
+func (s *session) frameMessage(pkg interface{}, data []byte) ([]byte, error) {
+	conn, ok := s.Connection.(*gettyTCPConn)
+	if !ok || conn.msgCompress == nil {
+		return data, nil
+	}
+
+	var compress bool
+	if marker, ok := s.getWriter().(CompressMarker); ok {
+		compress = marker.ShouldCompress(s, pkg, data)
+	} else {
+		compress = len(data) >= conn.msgCompress.config.Threshold
+	}
+
+	return conn.msgCompress.frame(data, compress)
+}
@@ -0,0 +1,119 @@
+package getty
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// compressLineCodec only compresses the lines longer than 100 bytes
+type compressLineCodec struct {
+	lineTransferCodec
+}
+
+func (c *compressLineCodec) ShouldCompress(ss Session, pkg interface{}, data []byte) bool {
+	return len(data) > 100
+}
+
+func TestMessageCompressFrame(t *testing.T) {
+	for _, typ := range []CompressType{CompressSnappy, CompressBestSpeed, CompressZip} {
+		config := MessageCompressConfig{Type: typ}.withDefaults()
+		c := newMsgCompressor(config)
+
+		large := []byte(strings.Repeat("getty ", 200))
+		small := []byte("ping")
+		var stream bytes.Buffer
+		for _, msg := range []struct {
+			data     []byte
+			compress bool
+			flag     byte
+		}{
+			{large, true, msgFlagCompressed},
+			{small, true, msgFlagRaw}, // not smaller after compressed
+			{large, false, msgFlagRaw},
+		} {
+			framed, err := c.frame(msg.data, msg.compress)
+			assert.Nil(t, err)
+			assert.Equal(t, msg.flag, framed[0])
+			stream.Write(framed)
+		}
+
+		r := newMsgDecompressReader(&stream, config)
+		got, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, string(large)+string(small)+string(large), string(got))
+	}
+
+	r := newMsgDecompressReader(bytes.NewReader([]byte{7, 1, 0}), MessageCompressConfig{}.withDefaults())
+	_, err := r.Read(make([]byte, 8))
+	assert.NotNil(t, err)
+}
+
+func TestSessionMessageCompression(t *testing.T) {
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+
+	src, dst := newPipeSessions(t)
+	for _, ss := range []*session{src, dst} {
+		ss.SetPkgHandler(&compressLineCodec{})
+		ss.SetEventListener(listener)
+		ss.SetMessageCompression(&MessageCompressConfig{})
+		ss.run()
+		defer ss.Close()
+	}
+
+	large := strings.Repeat("getty ", 100)
+	assert.Nil(t, src.WritePkg("hello", 0))
+	assert.Nil(t, src.WritePkg(large, 0))
+	assert.Nil(t, src.WritePkg("world", 0))
+	assert.Equal(t, "hello", <-listener.msgs)
+	assert.Equal(t, large, <-listener.msgs)
+	assert.Equal(t, "world", <-listener.msgs)
+	// the large line is compressed
+	assert.True(t, int(atomic.LoadUint32(&src.gettyConn().writeBytes)) < len(large))
+}
+
+func TestSnappyBlockWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := CompressWrapper(CompressSnappyBlock).WrapWriter(&buf)
+	large := []byte(strings.Repeat("getty ", 200))
+	n, err := w.Write(large)
+	assert.Nil(t, err)
+	assert.Equal(t, len(large), n)
+	// one frame per write, with no stream header
+	assert.Equal(t, msgFlagCompressed, buf.Bytes()[0])
+	assert.True(t, buf.Len() < len(large))
+
+	_, err = w.Write([]byte("ping"))
+	assert.Nil(t, err)
+	got, err := io.ReadAll(CompressWrapper(CompressSnappyBlock).WrapReader(&buf))
+	assert.Nil(t, err)
+	assert.Equal(t, string(large)+"ping", string(got))
+}
+
+func TestSessionSnappyBlockInterop(t *testing.T) {
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+
+	// the stream compression of CompressSnappyBlock talks with the per message compression
+	src, dst := newPipeSessions(t)
+	src.SetCompressType(CompressSnappyBlock)
+	dst.SetMessageCompression(&MessageCompressConfig{Type: CompressSnappyBlock, Threshold: 1})
+	for _, ss := range []*session{src, dst} {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(listener)
+		ss.run()
+		defer ss.Close()
+	}
+
+	large := strings.Repeat("getty ", 100)
+	assert.Nil(t, src.WritePkg(large, 0))
+	assert.Equal(t, large, <-listener.msgs)
+	assert.Nil(t, dst.WritePkg(large, 0))
+	assert.Equal(t, large, <-listener.msgs)
+	assert.True(t, int(atomic.LoadUint32(&dst.gettyConn().writeBytes)) < len(large))
+}
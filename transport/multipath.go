@@ -0,0 +1,282 @@
+/******************************************************
+# DESC       : experimental multipath client
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-20 10:30
+# FILE       : multipath.go
+******************************************************/
+
+package getty
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	// magic(1B) + bond id(8B) + sequence number(8B)
+	multipathHeaderLen = 17
+	multipathMagic     = 0xFA
+)
+
+var (
+	errIllegalMultipathFrame = jerrors.New("illegal multipath frame")
+)
+
+// MultipathMode is the way a MultipathClient sends pkgs over its paths
+type MultipathMode int
+
+const (
+	// every pkg is sent over all connected paths, and the first copy wins at the receiver
+	MultipathDuplicate MultipathMode = iota
+	// pkgs are sent over the paths in turn, and a pkg fails over to the next path if
+	// its path is down
+	MultipathStripe
+)
+
+// MultipathConfig is the config of a MultipathClient and its receiver listener
+type MultipathConfig struct {
+	Mode MultipathMode
+	// the dedup window of the receiver in pkgs. It is rounded up to a multiple of 64,
+	// and its default value is 1024.
+	Window int
+	// the window of a bond is dropped if no pkg is received from it in this time.
+	// Its default value is 1 minute.
+	PeerTimeout time.Duration
+}
+
+func (c MultipathConfig) withDefaults() MultipathConfig {
+	dedup := DedupConfig{Window: c.Window, PeerTimeout: c.PeerTimeout}.withDefaults()
+	c.Window, c.PeerTimeout = dedup.Window, dedup.PeerTimeout
+	return c
+}
+
+// multipathPkg is a pkg numbered in a bond
+type multipathPkg struct {
+	bond uint64
+	seq  uint64
+	pkg  interface{}
+}
+
+/////////////////////////////////////////
+// codec
+/////////////////////////////////////////
+
+type multipathCodec struct {
+	inner ReadWriter
+}
+
+// MultipathCodec wraps the codec @inner of the tcp/ws sessions of both the MultipathClient
+// paths and their peers. Every frame is prefixed by the bond id and sequence number of
+// its pkg, and the pkgs which are not sent by a MultipathClient, e.g. the responses of
+// the server, are prefixed by a zero header.
+func MultipathCodec(inner ReadWriter) ReadWriter {
+	return &multipathCodec{inner: inner}
+}
+
+func (c *multipathCodec) Read(ss Session, data []byte) (interface{}, int, error) {
+	if len(data) < multipathHeaderLen {
+		return nil, 0, nil
+	}
+	if data[0] != multipathMagic {
+		return nil, 0, errIllegalMultipathFrame
+	}
+
+	pkg, pkgLen, err := c.inner.Read(ss, data[multipathHeaderLen:])
+	if err != nil || pkg == nil {
+		return nil, 0, err
+	}
+
+	return &multipathPkg{
+		bond: binary.BigEndian.Uint64(data[1:]),
+		seq:  binary.BigEndian.Uint64(data[9:]),
+		pkg:  pkg,
+	}, multipathHeaderLen + pkgLen, nil
+}
+
+func (c *multipathCodec) Write(ss Session, pkg interface{}) ([]byte, error) {
+	var bond, seq uint64
+	if p, ok := pkg.(*multipathPkg); ok {
+		bond, seq, pkg = p.bond, p.seq, p.pkg
+	}
+	data, err := c.inner.Write(ss, pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, multipathHeaderLen+len(data))
+	b[0] = multipathMagic
+	binary.BigEndian.PutUint64(b[1:], bond)
+	binary.BigEndian.PutUint64(b[9:], seq)
+	copy(b[multipathHeaderLen:], data)
+	return b, nil
+}
+
+/////////////////////////////////////////
+// receiver
+/////////////////////////////////////////
+
+type multipathListener struct {
+	EventListener
+
+	config    MultipathConfig
+	lock      sync.Mutex
+	windows   map[uint64]*dedupWindow
+	lastSweep time.Time
+}
+
+// NewMultipathListener wraps @listener of the sessions which receive the pkgs of
+// MultipathClients. The same listener should be set to all sessions of a server,
+// so a pkg received over several paths is delivered to @listener once, by the
+// session which receives it first. The pkgs not sent by a MultipathClient are
+// delivered as they are.
+func NewMultipathListener(listener EventListener, config MultipathConfig) EventListener {
+	return &multipathListener{
+		EventListener: listener,
+		config:        config.withDefaults(),
+		windows:       make(map[uint64]*dedupWindow),
+	}
+}
+
+func (l *multipathListener) OnMessage(ss Session, pkg interface{}) {
+	p, ok := pkg.(*multipathPkg)
+	if !ok {
+		l.EventListener.OnMessage(ss, pkg)
+		return
+	}
+	if p.seq == 0 || l.accept(p.bond, p.seq, time.Now()) {
+		l.EventListener.OnMessage(ss, p.pkg)
+	}
+}
+
+// mark @seq of @bond as received. It returns false if @seq is duplicate or out of the window.
+func (l *multipathListener) accept(bond, seq uint64, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.sweep(now)
+	w, ok := l.windows[bond]
+	if !ok {
+		w = &dedupWindow{bitmap: make([]uint64, l.config.Window/64)}
+		l.windows[bond] = w
+	}
+	w.lastActive = now
+	return w.accept(seq, uint64(l.config.Window))
+}
+
+// drop the windows of the idle bonds
+func (l *multipathListener) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.config.PeerTimeout>>1 {
+		return
+	}
+	l.lastSweep = now
+	for bond, w := range l.windows {
+		if now.Sub(w.lastActive) > l.config.PeerTimeout {
+			delete(l.windows, bond)
+		}
+	}
+}
+
+/////////////////////////////////////////
+// client
+/////////////////////////////////////////
+
+// MultipathClient bonds several clients which connect to the same server over different
+// paths, e.g. wifi and LTE, or two ISPs. It is experimental.
+//
+// The sessions of the paths and the server should use MultipathCodec, and the server
+// sessions should share a listener built by NewMultipathListener, e.g.
+//
+//	dialer := func(local net.Addr) getty.TransportDialer {
+//		d := &net.Dialer{LocalAddr: local}
+//		return func(addr string) (net.Conn, error) { return d.Dial("tcp", addr) }
+//	}
+//	wifi := getty.NewTransportClient(dialer(wifiAddr), getty.WithServerAddress(addr),
+//		getty.WithConnectionNumber(1))
+//	lte := getty.NewTransportClient(dialer(lteAddr), getty.WithServerAddress(addr),
+//		getty.WithConnectionNumber(1))
+//	mp := getty.NewMultipathClient(getty.MultipathConfig{}, wifi, lte)
+//	wifi.RunEventLoop(newSession)
+//	lte.RunEventLoop(newSession)
+//	mp.WritePkgContext(ctx, pkg)
+type MultipathClient struct {
+	seq    uint64 // the first word for the atomic operations on 32 bits platforms
+	bond   uint64
+	next   uint32
+	config MultipathConfig
+	paths  []*client
+}
+
+// NewMultipathClient bonds @paths which should be built by NewTCPClient/NewWSClient/NewWSSClient/
+// NewTransportClient. The event loops of @paths are run by the caller.
+func NewMultipathClient(config MultipathConfig, paths ...Client) *MultipathClient {
+	if len(paths) == 0 {
+		panic("@paths is empty")
+	}
+
+	c := &MultipathClient{config: config.withDefaults()}
+	for _, path := range paths {
+		p, ok := path.(*client)
+		if !ok {
+			panic("@paths should be built by NewTCPClient/NewWSClient/NewWSSClient/NewTransportClient")
+		}
+		c.paths = append(c.paths, p)
+	}
+	var b [8]byte
+	for c.bond == 0 {
+		rand.Read(b[:])
+		c.bond = binary.BigEndian.Uint64(b[:])
+	}
+
+	return c
+}
+
+// WritePkgContext sends @pkg over the paths as the config mode. If no path is connected,
+// @pkg is queued by a path and flushed once it is connected.
+func (c *MultipathClient) WritePkgContext(ctx context.Context, pkg interface{}) error {
+	p := &multipathPkg{bond: c.bond, seq: atomic.AddUint64(&c.seq, 1), pkg: pkg}
+	start := int(atomic.AddUint32(&c.next, 1)-1) % len(c.paths)
+
+	var (
+		err  error
+		sent bool
+	)
+	for i := range c.paths {
+		ss := c.paths[(start+i)%len(c.paths)].connectedSession()
+		if ss == nil {
+			continue
+		}
+		if e := ss.(SessionWriter).WritePkgContext(ctx, p); e != nil {
+			err = e
+			continue
+		}
+		if c.config.Mode == MultipathStripe {
+			return nil
+		}
+		sent = true
+	}
+	if sent {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.paths[start].WritePkgContext(ctx, p)
+}
+
+// Close closes all paths
+func (c *MultipathClient) Close() {
+	for _, p := range c.paths {
+		p.Close()
+	}
+}
@@ -0,0 +1,169 @@
+package getty
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultipathCodec(t *testing.T) {
+	codec := MultipathCodec(&lineTransferCodec{})
+	data, err := codec.Write(nil, &multipathPkg{bond: 7, seq: 3, pkg: "hello"})
+	assert.Nil(t, err)
+	plain, err := codec.Write(nil, "world")
+	assert.Nil(t, err)
+	data = append(data, plain...)
+
+	// the incomplete header and the incomplete pkg
+	for _, n := range []int{multipathHeaderLen - 1, multipathHeaderLen + 2} {
+		pkg, pkgLen, err := codec.Read(nil, data[:n])
+		assert.Nil(t, pkg)
+		assert.Zero(t, pkgLen)
+		assert.Nil(t, err)
+	}
+
+	pkg, pkgLen, err := codec.Read(nil, data)
+	assert.Nil(t, err)
+	assert.Equal(t, &multipathPkg{bond: 7, seq: 3, pkg: "hello"}, pkg)
+	pkg, _, err = codec.Read(nil, data[pkgLen:])
+	assert.Nil(t, err)
+	assert.Equal(t, &multipathPkg{pkg: "world"}, pkg)
+
+	_, _, err = codec.Read(nil, []byte("hello, world\nhello, world\n"))
+	assert.Equal(t, errIllegalMultipathFrame, err)
+}
+
+func TestMultipathListener(t *testing.T) {
+	msgs := make(chan interface{}, 8)
+	listener := NewMultipathListener(&lineListener{msgs: msgs}, MultipathConfig{Window: 64})
+	for _, pkg := range []interface{}{
+		&multipathPkg{bond: 1, seq: 1, pkg: "a"},
+		&multipathPkg{bond: 1, seq: 1, pkg: "a"},
+		&multipathPkg{bond: 2, seq: 1, pkg: "b"},
+		&multipathPkg{bond: 1, seq: 100, pkg: "c"},
+		// out of the window
+		&multipathPkg{bond: 1, seq: 2, pkg: "d"},
+		&multipathPkg{bond: 1, pkg: "e"},
+		"f",
+	} {
+		listener.OnMessage(nil, pkg)
+	}
+	close(msgs)
+
+	var got []interface{}
+	for msg := range msgs {
+		got = append(got, msg)
+	}
+	assert.Equal(t, []interface{}{"a", "b", "c", "e", "f"}, got)
+}
+
+type pathMessage struct {
+	ss  Session
+	pkg interface{}
+}
+
+type pathListener struct {
+	MessageHandler
+
+	msgs chan pathMessage
+}
+
+func (l *pathListener) OnMessage(ss Session, pkg interface{}) {
+	l.msgs <- pathMessage{ss, pkg}
+}
+
+// run a tcp server whose sessions share a multipath listener, and two paths to it
+func runMultipath(t *testing.T, mode MultipathMode) (*server, *MultipathClient, *pathListener) {
+	listener := &pathListener{msgs: make(chan pathMessage, 16)}
+	mpListener := NewMultipathListener(listener, MultipathConfig{})
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(MultipathCodec(&lineTransferCodec{}))
+		ss.SetEventListener(mpListener)
+		return nil
+	})
+
+	var paths []Client
+	for i := 0; i < 2; i++ {
+		path := newClient(TCP_CLIENT, WithServerAddress(server.streamListener.Addr().String()),
+			WithConnectionNumber(1))
+		path.RunEventLoop(func(ss Session) error {
+			ss.SetPkgHandler(MultipathCodec(&lineTransferCodec{}))
+			ss.SetEventListener(&MessageHandler{})
+			return nil
+		})
+		paths = append(paths, path)
+	}
+	assert.Equal(t, 2, listener.SessionNumber())
+
+	return server, NewMultipathClient(MultipathConfig{Mode: mode}, paths...), listener
+}
+
+func receivePathMessage(t *testing.T, listener *pathListener) pathMessage {
+	select {
+	case msg := <-listener.msgs:
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message is received")
+	}
+	return pathMessage{}
+}
+
+func assertNoPathMessage(t *testing.T, listener *pathListener) {
+	select {
+	case msg := <-listener.msgs:
+		t.Fatalf("unexpected message %v", msg.pkg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMultipathDuplicate(t *testing.T) {
+	server, client, listener := runMultipath(t, MultipathDuplicate)
+	defer server.Close()
+	defer client.Close()
+
+	ctx := context.Background()
+	for _, pkg := range []string{"a", "b", "c"} {
+		assert.Nil(t, client.WritePkgContext(ctx, pkg))
+		assert.Equal(t, pkg, receivePathMessage(t, listener).pkg)
+	}
+	// the copies over the other path are dropped
+	assertNoPathMessage(t, listener)
+
+	// the pkgs go on over the other path
+	client.paths[0].Close()
+	assert.Nil(t, client.WritePkgContext(ctx, "d"))
+	assert.Equal(t, "d", receivePathMessage(t, listener).pkg)
+	assertNoPathMessage(t, listener)
+}
+
+func TestMultipathStripe(t *testing.T) {
+	server, client, listener := runMultipath(t, MultipathStripe)
+	defer server.Close()
+	defer client.Close()
+
+	ctx := context.Background()
+	var sessions []Session
+	for _, pkg := range []string{"a", "b", "c", "d"} {
+		assert.Nil(t, client.WritePkgContext(ctx, pkg))
+		msg := receivePathMessage(t, listener)
+		assert.Equal(t, pkg, msg.pkg)
+		sessions = append(sessions, msg.ss)
+	}
+	assertNoPathMessage(t, listener)
+	// the pkgs are sent over the paths in turn
+	assert.NotEqual(t, sessions[0], sessions[1])
+	assert.Equal(t, sessions[0], sessions[2])
+	assert.Equal(t, sessions[1], sessions[3])
+
+	// the pkgs of the closed path fail over to the other path
+	client.paths[0].Close()
+	for _, pkg := range []string{"e", "f"} {
+		assert.Nil(t, client.WritePkgContext(ctx, pkg))
+		assert.Equal(t, pkg, receivePathMessage(t, listener).pkg)
+	}
+}
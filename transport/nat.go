@@ -0,0 +1,186 @@
+/******************************************************
+# DESC       : udp nat traversal helpers
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-21 14:10
+# FILE       : nat.go
+******************************************************/
+
+package getty
+
+import (
+	"bytes"
+	"net"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	// the registration of a rendezvous token expires after it
+	rendezvousTimeout = 30 * time.Second
+	// the interval of the registration datagrams sent by a rendezvous client
+	rendezvousRegisterInterval = time.Second
+	// the interval of the punching datagrams
+	punchInterval = 100 * time.Millisecond
+)
+
+var (
+	rendezvousRegisterPrefix = []byte("getty-rendezvous-register:")
+	rendezvousPeerPrefix     = []byte("getty-rendezvous-peer:")
+
+	errPunchTimeout = jerrors.New("udp hole punching timeout")
+)
+
+/////////////////////////////////////////
+// rendezvous server
+/////////////////////////////////////////
+
+type rendezvousEntry struct {
+	addr     *net.UDPAddr
+	deadline time.Time
+}
+
+// rendezvous pairs the udp clients which register the same token, and tells every
+// one of them the address of the other one observed by the server.
+// It is only used by the read goroutine of the udp endpoint session.
+type rendezvous struct {
+	entries   map[string]rendezvousEntry
+	lastSweep time.Time
+}
+
+func newRendezvous() *rendezvous {
+	return &rendezvous{entries: make(map[string]rendezvousEntry)}
+}
+
+// register @token from @addr. It returns the address of the peer if another client
+// has registered @token.
+func (r *rendezvous) register(token string, addr *net.UDPAddr, now time.Time) *net.UDPAddr {
+	r.sweep(now)
+	e, ok := r.entries[token]
+	if ok && e.addr.String() != addr.String() {
+		delete(r.entries, token)
+		return e.addr
+	}
+
+	r.entries[token] = rendezvousEntry{addr: addr, deadline: now.Add(rendezvousTimeout)}
+	return nil
+}
+
+func (r *rendezvous) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < rendezvousTimeout>>1 {
+		return
+	}
+	r.lastSweep = now
+	for token, e := range r.entries {
+		if e.deadline.Before(now) {
+			delete(r.entries, token)
+		}
+	}
+}
+
+// handle the rendezvous registration datagram @data from @addr
+func (s *session) handleRendezvous(conn *gettyUDPConn, data []byte, addr *net.UDPAddr) {
+	token := string(data[len(rendezvousRegisterPrefix):])
+	peer := s.rendezvous.register(token, addr, time.Now())
+	if peer == nil {
+		return
+	}
+
+	log.Info("%s, rendezvous token %s pairs %s and %s", s.sessionToken(), token, addr, peer)
+	for _, pair := range [][2]*net.UDPAddr{{addr, peer}, {peer, addr}} {
+		msg := append(append([]byte(nil), rendezvousPeerPrefix...), pair[1].String()...)
+		if _, err := conn.conn.WriteToUDP(msg, pair[0]); err != nil {
+			log.Warn("%s, send rendezvous peer to %s, error{%s}", s.sessionToken(), pair[0], jerrors.ErrorStack(err))
+		}
+	}
+}
+
+/////////////////////////////////////////
+// rendezvous client
+/////////////////////////////////////////
+
+// send a keepalive datagram to the peer of the connected udp session to keep its nat
+// mapping alive. The receiver drops it silently.
+func (u *gettyUDPConn) writeKeepAlive() error {
+	if u.conn == nil || u.isClosed() || u.conn.RemoteAddr() == nil {
+		return nil
+	}
+	if wTimeout := u.writeTimeout(); wTimeout > 0 {
+		u.conn.SetWriteDeadline(time.Now().Add(wTimeout))
+	}
+	_, err := u.conn.Write(connectPingPackage)
+	return jerrors.Trace(err)
+}
+
+// register the rendezvous token to the rendezvous server @c.addr until the server returns
+// the peer address, then punch a hole to the peer by sending datagrams to each other.
+// It returns the local address of the hole and the peer address.
+func (c *client) punch() (*net.UDPAddr, *net.UDPAddr, error) {
+	serverAddr, err := net.ResolveUDPAddr("udp", c.addr)
+	if err != nil {
+		return nil, nil, jerrors.Trace(err)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		return nil, nil, jerrors.Trace(err)
+	}
+	defer conn.Close()
+
+	var (
+		peerAddr *net.UDPAddr
+		length   int
+		addr     *net.UDPAddr
+		buf      = make([]byte, 128)
+		register = append(append([]byte(nil), rendezvousRegisterPrefix...), c.rendezvousToken...)
+	)
+
+	for peerAddr == nil {
+		if c.IsClosed() {
+			return nil, nil, ErrSessionClosed
+		}
+		if _, err = conn.WriteToUDP(register, serverAddr); err != nil {
+			return nil, nil, jerrors.Trace(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(rendezvousRegisterInterval))
+		for {
+			length, addr, err = conn.ReadFromUDP(buf)
+			if err != nil {
+				break
+			}
+			if addr.String() == serverAddr.String() && bytes.HasPrefix(buf[:length], rendezvousPeerPrefix) {
+				if peerAddr, err = net.ResolveUDPAddr("udp", string(buf[len(rendezvousPeerPrefix):length])); err != nil {
+					return nil, nil, jerrors.Trace(err)
+				}
+				break
+			}
+		}
+		if netErr, ok := err.(net.Error); err != nil && !(ok && netErr.Timeout()) {
+			return nil, nil, jerrors.Trace(err)
+		}
+	}
+
+	deadline := time.Now().Add(connectTimeout)
+	for time.Now().Before(deadline) {
+		if _, err = conn.WriteToUDP(connectPingPackage, peerAddr); err != nil {
+			return nil, nil, jerrors.Trace(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(punchInterval))
+		for {
+			length, addr, err = conn.ReadFromUDP(buf)
+			if err != nil {
+				break
+			}
+			if addr.String() == peerAddr.String() && bytes.Equal(buf[:length], connectPingPackage) {
+				localAddr := conn.LocalAddr().(*net.UDPAddr)
+				return &net.UDPAddr{IP: net.IPv4zero, Port: localAddr.Port}, peerAddr, nil
+			}
+		}
+	}
+
+	return nil, nil, jerrors.Annotatef(errPunchTimeout, "peer %s", peerAddr)
+}
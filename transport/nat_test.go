@@ -0,0 +1,87 @@
+package getty
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRendezvousRegister(t *testing.T) {
+	now := time.Now()
+	r := newRendezvous()
+	a := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+	b := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 2000}
+
+	assert.Nil(t, r.register("room", a, now))
+	// re-register
+	assert.Nil(t, r.register("room", a, now))
+	assert.Equal(t, a, r.register("room", b, now))
+	assert.Equal(t, 0, len(r.entries))
+
+	// the registration expires
+	assert.Nil(t, r.register("room", a, now))
+	assert.Nil(t, r.register("other", b, now.Add(2*rendezvousTimeout)))
+	assert.Equal(t, 1, len(r.entries))
+}
+
+func TestUDPRendezvous(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	server := NewUDPPEndPoint(WithLocalAddress(addr), WithServerRendezvous())
+	var serverHandler MessageHandler
+	go server.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &serverHandler)
+	})
+	defer server.Close()
+
+	var handlers [2]MessageHandler
+	for i := range handlers {
+		handler := &handlers[i]
+		clt := NewUDPClient(
+			WithServerAddress(addr),
+			WithConnectionNumber(1),
+			WithRendezvous("room"),
+			WithNATKeepAlive(50*time.Millisecond),
+		)
+		// RunEventLoop blocks until the peer registers
+		go clt.RunEventLoop(func(session Session) error {
+			return newSessionCallback(session, handler)
+		})
+		defer clt.Close()
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for handlers[0].SessionNumber() == 0 || handlers[1].SessionNumber() == 0 {
+		if deadline.Before(time.Now()) {
+			t.Fatal("udp rendezvous timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	port := func(addr string) int {
+		_, p, err := net.SplitHostPort(addr)
+		assert.Nil(t, err)
+		n, _ := strconv.Atoi(p)
+		return n
+	}
+	ss0, ss1 := handlers[0].array[0].(*session), handlers[1].array[0].(*session)
+	assert.Equal(t, port(ss0.RemoteAddr()), port(ss1.LocalAddr()))
+	assert.Equal(t, port(ss1.RemoteAddr()), port(ss0.LocalAddr()))
+	assert.Equal(t, 1, serverHandler.SessionNumber())
+
+	// the keepalive datagrams of the peer are received
+	readBytes := atomic.LoadUint32(&ss1.gettyConn().readBytes)
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, readBytes < atomic.LoadUint32(&ss1.gettyConn().readBytes))
+	assert.False(t, ss0.IsClosed())
+	assert.False(t, ss1.IsClosed())
+}
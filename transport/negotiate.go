@@ -0,0 +1,445 @@
+/******************************************************
+# DESC       : compression & encryption negotiation of tcp session
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-25 16:30
+# FILE       : negotiate.go
+******************************************************/
+
+package getty
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	negotiationVersion        = 1
+	negotiationNonceLen       = 16
+	defaultNegotiationTimeout = 3 * time.Second
+	// the max plaintext size of an encrypted record
+	cipherRecordSize = 16 * 1024
+)
+
+var (
+	negotiationMagic = []byte("GNEG")
+
+	errIllegalNegotiation = jerrors.New("illegal negotiation frame")
+)
+
+// CipherSuite is the encryption algorithm of a negotiated tcp session.
+type CipherSuite uint8
+
+const (
+	CipherNone CipherSuite = iota
+	CipherAES128GCM
+	CipherAES256GCM
+)
+
+func (c CipherSuite) String() string {
+	switch c {
+	case CipherNone:
+		return "none"
+	case CipherAES128GCM:
+		return "aes-128-gcm"
+	case CipherAES256GCM:
+		return "aes-256-gcm"
+	}
+
+	return fmt.Sprintf("cipher-%d", uint8(c))
+}
+
+func (c CipherSuite) keyLen() int {
+	if c == CipherAES128GCM {
+		return 16
+	}
+	return 32
+}
+
+// NegotiationConfig is the capability list of one side of the negotiation, which is
+// set by WithServerNegotiation/WithNegotiation. Both sides exchange their lists once the
+// tcp connection is established, and agree on the first compress type & cipher suite of
+// the client lists which are supported by the server too.
+type NegotiationConfig struct {
+	// the supported compress types in preference order. Its default value is {CompressNone}.
+	Compressions []CompressType
+	// the supported cipher suites in preference order. Its default value is {CipherNone}.
+	Ciphers []CipherSuite
+	// the pre-shared key from which the session keys are derived. It is required if
+	// Ciphers contains any cipher suite other than CipherNone.
+	PSK []byte
+	// the timeout of the frame exchange. Its default value is 3s.
+	Timeout time.Duration
+	// the interval of the write key rotation of an encrypted session. Once its write key
+	// has been used for this time, a side derives the next key and announces it by a
+	// rekey frame. Both sides should support the rekey frame. Its default value 0
+	// disables the rotation.
+	RekeyInterval time.Duration
+	// the overlap window of a rekey. The records sealed by the old key are accepted
+	// for this time after the rekey frame is received, and the rekey frame is sent
+	// again if it is not acknowledged in this time. Its default value is 10s.
+	RekeyOverlap time.Duration
+}
+
+func (c NegotiationConfig) withDefaults() NegotiationConfig {
+	if len(c.Compressions) == 0 {
+		c.Compressions = []CompressType{CompressNone}
+	}
+	if len(c.Ciphers) == 0 {
+		c.Ciphers = []CipherSuite{CipherNone}
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultNegotiationTimeout
+	}
+	if c.RekeyOverlap <= 0 {
+		c.RekeyOverlap = defaultRekeyOverlap
+	}
+
+	return c
+}
+
+// Negotiated is the result of the negotiation of a tcp session.
+type Negotiated struct {
+	Compress CompressType
+	Cipher   CipherSuite
+}
+
+func (n Negotiated) String() string {
+	return fmt.Sprintf("{compress:%d, cipher:%s}", n.Compress, n.Cipher)
+}
+
+// the negotiation frame:
+// magic(4 bytes) | version(1 byte) | nonce(16 bytes) | compress num(1 byte) | compress types |
+// cipher num(1 byte) | cipher suites
+func (c NegotiationConfig) encode(nonce []byte) []byte {
+	b := make([]byte, 0, len(negotiationMagic)+1+negotiationNonceLen+2+len(c.Compressions)+len(c.Ciphers))
+	b = append(b, negotiationMagic...)
+	b = append(b, negotiationVersion)
+	b = append(b, nonce...)
+	b = append(b, byte(len(c.Compressions)))
+	for _, compress := range c.Compressions {
+		b = append(b, byte(int8(compress)))
+	}
+	b = append(b, byte(len(c.Ciphers)))
+	for _, suite := range c.Ciphers {
+		b = append(b, byte(suite))
+	}
+
+	return b
+}
+
+// read the negotiation frame of the peer. It returns the raw frame too, which is
+// used to derive the session keys.
+func readNegotiation(r io.Reader) (*NegotiationConfig, []byte, error) {
+	header := make([]byte, len(negotiationMagic)+1+negotiationNonceLen+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, jerrors.Trace(err)
+	}
+	if !bytes.Equal(header[:len(negotiationMagic)], negotiationMagic) || header[len(negotiationMagic)] != negotiationVersion {
+		return nil, nil, errIllegalNegotiation
+	}
+
+	var peer NegotiationConfig
+	// the compressions are followed by the cipher count, and 255 compressions overflow a byte
+	compressions := make([]byte, int(header[len(header)-1])+1)
+	if _, err := io.ReadFull(r, compressions); err != nil {
+		return nil, nil, jerrors.Trace(err)
+	}
+	for _, compress := range compressions[:len(compressions)-1] {
+		peer.Compressions = append(peer.Compressions, CompressType(int8(compress)))
+	}
+	ciphers := make([]byte, compressions[len(compressions)-1])
+	if _, err := io.ReadFull(r, ciphers); err != nil {
+		return nil, nil, jerrors.Trace(err)
+	}
+	for _, suite := range ciphers {
+		peer.Ciphers = append(peer.Ciphers, CipherSuite(suite))
+	}
+
+	frame := append(append(header, compressions...), ciphers...)
+	return &peer, frame, nil
+}
+
+// agree on the first items of the client lists which are supported by the server.
+func agree(client, server *NegotiationConfig) (*Negotiated, error) {
+	var (
+		n                   Negotiated
+		compressOK, suiteOK bool
+	)
+
+LOOP_COMPRESS:
+	for _, c := range client.Compressions {
+		for _, s := range server.Compressions {
+			if c == s {
+				n.Compress, compressOK = c, true
+				break LOOP_COMPRESS
+			}
+		}
+	}
+LOOP_CIPHER:
+	for _, c := range client.Ciphers {
+		for _, s := range server.Ciphers {
+			if c == s {
+				n.Cipher, suiteOK = c, true
+				break LOOP_CIPHER
+			}
+		}
+	}
+	if !compressOK {
+		return nil, newGettyError(ErrNegotiationFailed,
+			jerrors.Errorf("no common compress type, client %v, server %v", client.Compressions, server.Compressions))
+	}
+	if !suiteOK {
+		return nil, newGettyError(ErrNegotiationFailed,
+			jerrors.Errorf("no common cipher suite, client %v, server %v", client.Ciphers, server.Ciphers))
+	}
+
+	return &n, nil
+}
+
+// negotiate with the peer of @conn. The client sends its frame first, and the server
+// replies after it has got the client frame, so it works on synchronous pipes too.
+// If a cipher suite is agreed, the returned conn encrypts the traffic.
+func negotiate(conn net.Conn, config *NegotiationConfig, isClient bool) (net.Conn, *Negotiated, error) {
+	local := config.withDefaults()
+	for _, suite := range local.Ciphers {
+		if suite != CipherNone && len(local.PSK) == 0 {
+			return nil, nil, newGettyError(ErrNegotiationFailed, jerrors.Errorf("cipher %s without psk", suite))
+		}
+	}
+
+	nonce := make([]byte, negotiationNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, jerrors.Trace(err)
+	}
+	localFrame := local.encode(nonce)
+
+	conn.SetDeadline(time.Now().Add(local.Timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	var (
+		err       error
+		peer      *NegotiationConfig
+		peerFrame []byte
+	)
+	if isClient {
+		if _, err = conn.Write(localFrame); err == nil {
+			peer, peerFrame, err = readNegotiation(conn)
+		}
+	} else {
+		if peer, peerFrame, err = readNegotiation(conn); err == nil {
+			_, err = conn.Write(localFrame)
+		}
+	}
+	if err != nil {
+		if netErr, ok := jerrors.Cause(err).(net.Error); ok && netErr.Timeout() {
+			return nil, nil, newGettyError(ErrHandshakeTimeout, err)
+		}
+		return nil, nil, newGettyError(ErrNegotiationFailed, err)
+	}
+
+	clientConfig, serverConfig, clientFrame, serverFrame := &local, peer, localFrame, peerFrame
+	if !isClient {
+		clientConfig, serverConfig, clientFrame, serverFrame = peer, &local, peerFrame, localFrame
+	}
+	n, err := agree(clientConfig, serverConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n.Cipher == CipherNone {
+		return conn, n, nil
+	}
+
+	// the keys are bound to both frames, so a tampered frame fails the decryption
+	c2s := deriveKey(local.PSK, "getty c2s", clientFrame, serverFrame, n.Cipher.keyLen())
+	s2c := deriveKey(local.PSK, "getty s2c", clientFrame, serverFrame, n.Cipher.keyLen())
+	if !isClient {
+		c2s, s2c = s2c, c2s
+	}
+	cc, err := newCipherConn(conn, c2s, s2c, &local)
+	if err != nil {
+		return nil, nil, newGettyError(ErrNegotiationFailed, err)
+	}
+
+	return cc, n, nil
+}
+
+func deriveKey(psk []byte, label string, clientFrame, serverFrame []byte, keyLen int) []byte {
+	mac := hmac.New(sha256.New, psk)
+	mac.Write([]byte(label))
+	mac.Write(clientFrame)
+	mac.Write(serverFrame)
+	return mac.Sum(nil)[:keyLen]
+}
+
+/////////////////////////////////////////
+// encrypted connection
+/////////////////////////////////////////
+
+// cipherConn seals the written data into records:
+// flag & length(4 bytes) | aead sealed data
+// The nonce of a record is its sequence number under its key. The records whose
+// length has the flag cipherControlFlag carry the rekey frames, see rekey.go.
+type cipherConn struct {
+	net.Conn
+	rekeyInterval time.Duration
+	rekeyOverlap  time.Duration
+
+	wLock sync.Mutex
+	w     *cipherKey
+	// the time since when the write key is used
+	wTime time.Time
+	// the next write key which waits for the ack of the peer
+	wNext *cipherKey
+	// the time when the rekey frame of wNext is sent
+	wRekeyTime time.Time
+
+	r *cipherKey
+	// the next read key announced by the peer, and the deadline of the overlap
+	// window in which the records sealed by r are accepted too
+	rNext     *cipherKey
+	rDeadline time.Time
+	rBuf      []byte
+}
+
+func newCipherConn(conn net.Conn, writeKey, readKey []byte, config *NegotiationConfig) (*cipherConn, error) {
+	w, err := newCipherKey(writeKey, 0)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newCipherKey(readKey, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cipherConn{
+		Conn:          conn,
+		rekeyInterval: config.RekeyInterval,
+		rekeyOverlap:  config.RekeyOverlap,
+		w:             w,
+		wTime:         time.Now(),
+		r:             r,
+	}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, jerrors.Trace(err)
+}
+
+func recordNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+func (c *cipherConn) Write(p []byte) (int, error) {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+
+	if err := c.rekey(time.Now()); err != nil {
+		return 0, err
+	}
+
+	var n int
+	for len(p) > 0 {
+		size := len(p)
+		if size > cipherRecordSize {
+			size = cipherRecordSize
+		}
+		if err := c.writeRecord(0, p[:size]); err != nil {
+			return n, err
+		}
+		n += size
+		p = p[size:]
+	}
+
+	return n, nil
+}
+
+// seal @plain by the write key and write it. It is invoked with wLock held.
+func (c *cipherConn) writeRecord(flag uint32, plain []byte) error {
+	record := make([]byte, 4, 4+len(plain)+c.w.aead.Overhead())
+	record = c.w.seal(record, plain)
+	binary.BigEndian.PutUint32(record, flag|uint32(len(record)-4))
+	_, err := c.Conn.Write(record)
+	return err
+}
+
+// Read is only invoked by the read goroutine of the session.
+func (c *cipherConn) Read(p []byte) (int, error) {
+	for len(c.rBuf) == 0 {
+		var header [4]byte
+		if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+			return 0, err
+		}
+		flag := binary.BigEndian.Uint32(header[:]) & cipherControlFlag
+		length := binary.BigEndian.Uint32(header[:]) &^ cipherControlFlag
+		if length > uint32(cipherRecordSize+c.r.aead.Overhead()) {
+			return 0, jerrors.Errorf("illegal encrypted record length %d", length)
+		}
+		record := make([]byte, length)
+		if _, err := io.ReadFull(c.Conn, record); err != nil {
+			return 0, err
+		}
+		plain, err := c.open(record, time.Now())
+		if err != nil {
+			return 0, jerrors.Annotate(err, "decrypt record")
+		}
+		if flag != 0 {
+			if err = c.handleControl(plain, time.Now()); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		c.rBuf = plain
+	}
+
+	n := copy(p, c.rBuf)
+	c.rBuf = c.rBuf[n:]
+	return n, nil
+}
+
+// Negotiated returns the negotiation result of a tcp session. It is nil if the negotiation
+// is not enabled.
+func (s *session) Negotiated() *Negotiated {
+	return s.negotiated
+}
+
+// negotiate with the peer of the new tcp connection @conn, and build the session.
+func newNegotiatedTCPSession(conn net.Conn, endPoint EndPoint, config *NegotiationConfig, isClient bool) (Session, error) {
+	if config == nil {
+		return newTCPSession(conn, endPoint), nil
+	}
+
+	nc, n, err := negotiate(conn, config, isClient)
+	if err != nil {
+		return nil, err
+	}
+	ss := newTCPSession(nc, endPoint).(*session)
+	ss.negotiated = n
+	if n.Compress != CompressNone {
+		ss.SetCompressType(n.Compress)
+	}
+
+	return ss, nil
+}
@@ -0,0 +1,217 @@
+package getty
+
+import (
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type negotiateResult struct {
+	conn net.Conn
+	n    *Negotiated
+	err  error
+}
+
+func negotiatePipe(t *testing.T, client, server *NegotiationConfig) (negotiateResult, negotiateResult) {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+
+	ch := make(chan negotiateResult, 1)
+	go func() {
+		conn, n, err := negotiate(c2, server, false)
+		if err != nil {
+			c2.Close()
+		}
+		ch <- negotiateResult{conn, n, err}
+	}()
+	conn, n, err := negotiate(c1, client, true)
+	if err != nil {
+		c1.Close()
+	}
+
+	return negotiateResult{conn, n, err}, <-ch
+}
+
+func TestNegotiateAgree(t *testing.T) {
+	clt, srv := negotiatePipe(t,
+		&NegotiationConfig{Compressions: []CompressType{CompressSnappy, CompressBestSpeed, CompressNone}},
+		&NegotiationConfig{Compressions: []CompressType{CompressNone, CompressBestSpeed}},
+	)
+	assert.Nil(t, clt.err)
+	assert.Nil(t, srv.err)
+	assert.Equal(t, Negotiated{Compress: CompressBestSpeed, Cipher: CipherNone}, *clt.n)
+	assert.Equal(t, *clt.n, *srv.n)
+	_, ok := clt.conn.(*cipherConn)
+	assert.False(t, ok)
+
+	clt, srv = negotiatePipe(t,
+		&NegotiationConfig{Ciphers: []CipherSuite{CipherAES256GCM}, PSK: []byte("secret")},
+		&NegotiationConfig{Ciphers: []CipherSuite{CipherNone}},
+	)
+	assert.True(t, errors.Is(clt.err, ErrNegotiationFailed))
+	assert.True(t, errors.Is(srv.err, ErrNegotiationFailed))
+
+	_, _, err := negotiate(nil, &NegotiationConfig{Ciphers: []CipherSuite{CipherAES128GCM}}, true)
+	assert.True(t, errors.Is(err, ErrNegotiationFailed))
+}
+
+func TestNegotiateCipher(t *testing.T) {
+	psk := []byte("secret")
+	clt, srv := negotiatePipe(t,
+		&NegotiationConfig{Ciphers: []CipherSuite{CipherAES128GCM, CipherNone}, PSK: psk},
+		&NegotiationConfig{Ciphers: []CipherSuite{CipherNone, CipherAES256GCM, CipherAES128GCM}, PSK: psk},
+	)
+	assert.Nil(t, clt.err)
+	assert.Nil(t, srv.err)
+	assert.Equal(t, CipherAES128GCM, clt.n.Cipher)
+	assert.Equal(t, CipherAES128GCM, srv.n.Cipher)
+
+	msg := make([]byte, cipherRecordSize*2+100)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	go func() {
+		clt.conn.Write(msg)
+		clt.conn.Write([]byte("bye"))
+	}()
+	buf := make([]byte, len(msg)+3)
+	_, err := io.ReadFull(srv.conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, msg, buf[:len(msg)])
+	assert.Equal(t, "bye", string(buf[len(msg):]))
+
+	// the other direction uses another key
+	go srv.conn.Write([]byte("hello"))
+	_, err = io.ReadFull(clt.conn, buf[:5])
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:5]))
+
+	// different psks
+	clt, srv = negotiatePipe(t,
+		&NegotiationConfig{Ciphers: []CipherSuite{CipherAES256GCM}, PSK: psk},
+		&NegotiationConfig{Ciphers: []CipherSuite{CipherAES256GCM}, PSK: []byte("other")},
+	)
+	assert.Nil(t, clt.err)
+	assert.Nil(t, srv.err)
+	go clt.conn.Write([]byte("hello"))
+	_, err = srv.conn.Read(buf)
+	assert.NotNil(t, err)
+}
+
+func TestTCPNegotiation(t *testing.T) {
+	var serverMsgHandler, msgHandler MessageHandler
+
+	config := &NegotiationConfig{
+		Compressions: []CompressType{CompressSnappy, CompressNone},
+		Ciphers:      []CipherSuite{CipherAES256GCM},
+		PSK:          []byte("secret"),
+		Timeout:      time.Second,
+	}
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerNegotiation(config))
+	server.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &serverMsgHandler)
+	})
+	defer server.Close()
+
+	clt := newClient(TCP_CLIENT,
+		WithServerAddress(server.streamListener.Addr().String()),
+		WithConnectionNumber(1),
+		WithNegotiation(config),
+	)
+	clt.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &msgHandler)
+	})
+	defer clt.Close()
+
+	assert.Equal(t, 1, msgHandler.SessionNumber())
+	ss := msgHandler.array[0]
+	assert.Equal(t, &Negotiated{Compress: CompressSnappy, Cipher: CipherAES256GCM}, ss.(SessionInspector).Negotiated())
+	if runtime.GOOS == "linux" {
+		assert.NotNil(t, ss.(SessionInspector).TCPInfo())
+	}
+	for i := 0; i < 100 && serverMsgHandler.SessionNumber() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, serverMsgHandler.SessionNumber())
+	assert.Equal(t, ss.(SessionInspector).Negotiated(), serverMsgHandler.array[0].(SessionInspector).Negotiated())
+}
+
+func newCipherPipe(t *testing.T, config *NegotiationConfig) (*cipherConn, *cipherConn) {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+	k1, k2 := []byte("0123456789abcdef"), []byte("fedcba9876543210")
+	local := config.withDefaults()
+	a, err := newCipherConn(c1, k1, k2, &local)
+	assert.Nil(t, err)
+	peer := NegotiationConfig{}.withDefaults()
+	b, err := newCipherConn(c2, k2, k1, &peer)
+	assert.Nil(t, err)
+	return a, b
+}
+
+func TestCipherConnRekey(t *testing.T) {
+	a, b := newCipherPipe(t, &NegotiationConfig{RekeyInterval: time.Millisecond})
+	// the acks of b are read by this goroutine
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			if _, err := a.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 5)
+	for i := 0; i < 10; i++ {
+		time.Sleep(2 * time.Millisecond)
+		go a.Write([]byte("hello"))
+		_, err := io.ReadFull(b, buf)
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", string(buf))
+	}
+
+	a.wLock.Lock()
+	epoch := a.w.epoch
+	a.wLock.Unlock()
+	assert.True(t, epoch > 0, epoch)
+	assert.True(t, b.r.epoch+1 >= epoch, b.r.epoch)
+	// the write key of b is not rotated
+	assert.Equal(t, uint32(0), b.w.epoch)
+}
+
+func TestCipherConnRekeyOverlap(t *testing.T) {
+	a, b := newCipherPipe(t, &NegotiationConfig{})
+	b.rekeyOverlap = 10 * time.Millisecond
+	// the ack of b is not read
+	a.wLock.Lock()
+	a.rekeyInterval = time.Nanosecond
+	a.wLock.Unlock()
+
+	buf := make([]byte, 5)
+	go a.Write([]byte("hello"))
+	_, err := io.ReadFull(b, buf)
+	assert.Nil(t, err)
+	assert.NotNil(t, b.rNext)
+
+	// a still seals the records by the old key, which are refused after the overlap window
+	time.Sleep(20 * time.Millisecond)
+	go a.Write([]byte("hello"))
+	_, err = b.Read(buf)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, errIllegalRekeyFrame, b.handleControl(encodeRekeyFrame(rekeyFrame, 5), time.Now()))
+	assert.Equal(t, errIllegalRekeyFrame, b.handleControl([]byte{rekeyFrame}, time.Now()))
+}
@@ -0,0 +1,207 @@
+/******************************************************
+# DESC       : network change aware reconnect of client
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-19 16:20
+# FILE       : netmonitor.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+const (
+	defaultNetworkPollInterval = 2 * time.Second
+	defaultNetworkDebounce     = 100 * time.Millisecond
+)
+
+// NetworkChangeConfig is the network change detection of a client, e.g. on a mobile device
+// which switches between wifi & cellular. The client watches the interface & address changes
+// by the netlink route messages on linux, or polls the interface addresses on the other
+// platforms, and the sessions whose local addresses are removed or whose routes to the peers
+// go through other local addresses now are closed and redialed at once.
+type NetworkChangeConfig struct {
+	// the interval of the address polls if the netlink is not available. Its default value is 2s.
+	PollInterval time.Duration
+	// the changes within it are handled once. Its default value is 100ms.
+	Debounce time.Duration
+	// OnChange is invoked when the network changes, before the sessions are checked.
+	OnChange func(Client)
+}
+
+func (c NetworkChangeConfig) withDefaults() NetworkChangeConfig {
+	if c.PollInterval <= 0 {
+		c.PollInterval = defaultNetworkPollInterval
+	}
+	if c.Debounce <= 0 {
+		c.Debounce = defaultNetworkDebounce
+	}
+
+	return c
+}
+
+// the current interface addresses, or nil if they are not available
+func interfaceIPs() map[string]struct{} {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	ips := make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips[ipNet.IP.String()] = struct{}{}
+		}
+	}
+	return ips
+}
+
+func sameIPs(a, b map[string]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for ip := range a {
+		if _, ok := b[ip]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// check whether the connection from @local to @peer is affected by the network change, i.e.
+// @local is not an interface address in @ips any more, or the route to @peer goes through
+// another local address now. The route is looked up by a connected udp socket, which sends
+// nothing.
+func routeChanged(local, peer net.Addr, ips map[string]struct{}) bool {
+	localIP, peerIP := addrIP(local), addrIP(peer)
+	if localIP == nil || peerIP == nil || localIP.IsUnspecified() {
+		return false
+	}
+	if ips != nil {
+		if _, ok := ips[localIP.String()]; !ok {
+			return true
+		}
+	}
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: peerIP, Port: 9})
+	if err != nil {
+		// no route to the peer now
+		return true
+	}
+	defer conn.Close()
+
+	return !conn.LocalAddr().(*net.UDPAddr).IP.Equal(localIP)
+}
+
+// watch the network changes by netlink or the address polls
+func (c *client) startNetworkMonitor() {
+	if c.networkChange == nil {
+		return
+	}
+
+	config := c.networkChange.withDefaults()
+	events, err := watchNetwork(c.done)
+	if err != nil {
+		log.Info("client{peer:%s} polls the interface addresses, for watchNetwork() = error:%s", c.addr, err)
+		events = pollNetwork(c.done, config.PollInterval)
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			select {
+			case <-c.done:
+				return
+			case _, ok := <-events:
+				if !ok {
+					return
+				}
+			}
+
+			// merge the burst of the route messages
+			debounce := wheel.After(config.Debounce)
+		merge:
+			for {
+				select {
+				case <-c.done:
+					return
+				case <-events:
+				case <-debounce:
+					break merge
+				}
+			}
+			c.onNetworkChange(config)
+		}
+	}()
+}
+
+// send an event whenever the interface addresses change
+func pollNetwork(done chan struct{}, interval time.Duration) <-chan struct{} {
+	events := make(chan struct{}, 1)
+	go func() {
+		last := interfaceIPs()
+		for {
+			select {
+			case <-done:
+				return
+			case <-wheel.After(interval):
+			}
+
+			ips := interfaceIPs()
+			if !sameIPs(last, ips) {
+				last = ips
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return events
+}
+
+func (c *client) NetworkChanged() {
+	var config NetworkChangeConfig
+	if c.networkChange != nil {
+		config = *c.networkChange
+	}
+	c.onNetworkChange(config)
+}
+
+// close the sessions affected by the network change, and so they are redialed at once
+func (c *client) onNetworkChange(config NetworkChangeConfig) {
+	if c.IsClosed() {
+		return
+	}
+	if config.OnChange != nil {
+		config.OnChange(c)
+	}
+
+	c.Lock()
+	sessions := make([]Session, 0, len(c.ssMap))
+	for ss := range c.ssMap {
+		sessions = append(sessions, ss)
+	}
+	c.Unlock()
+
+	ips := interfaceIPs()
+	for _, ss := range sessions {
+		conn := ss.Conn()
+		if conn == nil || ss.IsClosed() {
+			continue
+		}
+		if routeChanged(conn.LocalAddr(), conn.RemoteAddr(), ips) {
+			log.Info("%s, the network changes, close it to redial", ss.Stat())
+			ss.Close()
+		}
+	}
+}
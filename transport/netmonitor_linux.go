@@ -0,0 +1,75 @@
+//go:build linux
+// +build linux
+
+/******************************************************
+# DESC       : netlink route monitor on linux
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-19 16:20
+# FILE       : netmonitor_linux.go
+******************************************************/
+
+package getty
+
+import (
+	"os"
+	"syscall"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+	"golang.org/x/sys/unix"
+)
+
+// watch the link & address changes by the netlink route messages. The socket is closed when
+// @done is closed.
+func watchNetwork(done chan struct{}) (<-chan struct{}, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	addr := &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR |
+			unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE,
+	}
+	if err = unix.Bind(fd, addr); err != nil {
+		unix.Close(fd)
+		return nil, jerrors.Trace(err)
+	}
+	// the nonblocking file is closed by the poller, which wakes up the blocked read
+	file := os.NewFile(uintptr(fd), "netlink-route")
+
+	events := make(chan struct{}, 1)
+	go func() {
+		<-done
+		file.Close()
+	}()
+	go func() {
+		defer close(events)
+		buf := make([]byte, os.Getpagesize())
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, msg := range msgs {
+				switch msg.Header.Type {
+				case unix.RTM_NEWLINK, unix.RTM_DELLINK, unix.RTM_NEWADDR, unix.RTM_DELADDR,
+					unix.RTM_NEWROUTE, unix.RTM_DELROUTE:
+					select {
+					case events <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+/******************************************************
+# DESC       : netlink route monitor stub
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-19 16:20
+# FILE       : netmonitor_others.go
+******************************************************/
+
+package getty
+
+func watchNetwork(done chan struct{}) (<-chan struct{}, error) {
+	return nil, ErrNotSupported
+}
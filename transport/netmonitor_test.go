@@ -0,0 +1,112 @@
+package getty
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteChanged(t *testing.T) {
+	loopback := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10000}
+	stale := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 10000}
+	ips := interfaceIPs()
+	assert.Contains(t, ips, "127.0.0.1")
+
+	assert.False(t, routeChanged(loopback, loopback, ips))
+	assert.False(t, routeChanged(loopback, loopback, nil))
+	// the local address is removed
+	assert.True(t, routeChanged(stale, loopback, ips))
+	// the route goes through another local address
+	assert.True(t, routeChanged(stale, loopback, nil))
+	// the addresses of the other networks are not checked
+	assert.False(t, routeChanged(transportAddr{network: "pipe"}, loopback, ips))
+	assert.False(t, routeChanged(&net.TCPAddr{IP: net.IPv4zero}, loopback, ips))
+}
+
+func TestSameIPs(t *testing.T) {
+	a := map[string]struct{}{"10.0.0.1": {}, "127.0.0.1": {}}
+	assert.True(t, sameIPs(a, map[string]struct{}{"127.0.0.1": {}, "10.0.0.1": {}}))
+	assert.False(t, sameIPs(a, map[string]struct{}{"127.0.0.1": {}, "10.0.0.2": {}}))
+	assert.False(t, sameIPs(a, map[string]struct{}{"127.0.0.1": {}}))
+	assert.True(t, sameIPs(nil, nil))
+}
+
+func TestWatchNetwork(t *testing.T) {
+	done := make(chan struct{})
+	events, err := watchNetwork(done)
+	if err != nil {
+		t.Skipf("watchNetwork() = error:%s", err)
+	}
+
+	// the watcher exits when done is closed
+	close(done)
+	select {
+	case _, ok := <-events:
+		for ok {
+			_, ok = <-events
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the watcher does not exit")
+	}
+}
+
+// staleLocalConn reports a local address which has been removed
+type staleLocalConn struct {
+	net.Conn
+}
+
+func (c staleLocalConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 10000}
+}
+
+func TestClientNetworkChange(t *testing.T) {
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(&MessageHandler{})
+		return nil
+	})
+	defer server.Close()
+
+	// the first conn is on the network which is gone
+	var dials, changes int32
+	dialer := func(addr string) (net.Conn, error) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		if atomic.AddInt32(&dials, 1) == 1 {
+			return staleLocalConn{conn}, nil
+		}
+		return conn, nil
+	}
+	client := NewTransportClient(dialer, WithServerAddress(server.streamListener.Addr().String()),
+		WithConnectionNumber(1), WithNetworkChangeDetection(&NetworkChangeConfig{
+			OnChange: func(Client) { atomic.AddInt32(&changes, 1) },
+		})).(*client)
+	defer client.Close()
+	handler := &MessageHandler{}
+	client.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(handler)
+		return nil
+	})
+	assert.Equal(t, 1, handler.SessionNumber())
+
+	var notifier NetworkChangeNotifier = client
+	notifier.NetworkChanged()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&changes))
+	assert.Equal(t, 2, handler.SessionNumber())
+	assert.Equal(t, 1, client.sessionNum())
+
+	// the session on the current network is kept
+	notifier.NetworkChanged()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&changes))
+	assert.Equal(t, 2, handler.SessionNumber())
+	assert.Equal(t, 1, client.sessionNum())
+}
@@ -0,0 +1,406 @@
+/******************************************************
+# DESC       : ocsp stapling of wss server
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-10 11:00
+# FILE       : ocsp.go
+******************************************************/
+
+package getty
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	defaultOCSPTimeout         = 10 * time.Second
+	defaultOCSPRefreshInterval = time.Hour
+	defaultOCSPRetryInterval   = time.Minute
+	maxOCSPResponseSize        = 1 << 20
+)
+
+var (
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+	// the signature algorithms of the ocsp responses
+	ocspSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+		"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+		"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+		"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+		"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+		"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+		"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+		"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+		"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+		"1.3.101.112":           x509.PureEd25519,
+	}
+)
+
+// OCSPConfig is the ocsp stapling config of a wss server. The ocsp response of the server
+// certificate is fetched from the responder of its issuer, and stapled to the tls handshakes,
+// so that the clients need not query the responder. The certificate file should contain the
+// issuer certificate after the server certificate.
+type OCSPConfig struct {
+	// the url of the ocsp responder. Its default value is the first ocsp server of the certificate.
+	ResponderURL string
+	// the timeout of a fetch. Its default value is 10s.
+	Timeout time.Duration
+	// the max interval of the refreshes. The response is refreshed at the half of its validity
+	// period if it is earlier. Its default value is 1h.
+	RefreshInterval time.Duration
+	// the interval of the retries after a failed fetch. Its default value is 1m.
+	RetryInterval time.Duration
+	// the file the latest response is cached in, which is stapled at once after the server
+	// restarts if it is still valid. Empty means no file cache.
+	CacheFile string
+}
+
+func (c OCSPConfig) withDefaults() OCSPConfig {
+	if c.Timeout <= 0 {
+		c.Timeout = defaultOCSPTimeout
+	}
+	if c.RefreshInterval <= 0 {
+		c.RefreshInterval = defaultOCSPRefreshInterval
+	}
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = defaultOCSPRetryInterval
+	}
+
+	return c
+}
+
+/////////////////////////////////////////
+// ocsp messages, see rfc 6960
+/////////////////////////////////////////
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspSingleRequest struct {
+	Cert ocspCertID
+}
+
+type ocspTBSRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []ocspSingleRequest
+}
+
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID           ocspCertID
+	Good             asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked          ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown          asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspResponseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []ocspSingleResponse
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+// the id of @cert in the ocsp messages
+func newOCSPCertID(cert, issuer *x509.Certificate) (ocspCertID, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return ocspCertID{}, jerrors.Trace(err)
+	}
+
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.RawValue{Tag: asn1.TagNull}},
+		NameHash:      nameHash[:],
+		IssuerKeyHash: keyHash[:],
+		SerialNumber:  cert.SerialNumber,
+	}, nil
+}
+
+func (id ocspCertID) equal(other ocspCertID) bool {
+	return id.HashAlgorithm.Algorithm.Equal(other.HashAlgorithm.Algorithm) &&
+		bytes.Equal(id.NameHash, other.NameHash) && bytes.Equal(id.IssuerKeyHash, other.IssuerKeyHash) &&
+		id.SerialNumber.Cmp(other.SerialNumber) == 0
+}
+
+// the revocation status of a certificate in an ocsp response
+const (
+	ocspGood = iota
+	ocspRevoked
+	ocspUnknown
+)
+
+// ocspStatus is the parsed ocsp response of a certificate
+type ocspStatus struct {
+	raw        []byte
+	status     int
+	thisUpdate time.Time
+	nextUpdate time.Time
+}
+
+// parse the ocsp response @raw of @id, and verify its signature by @issuer or by the
+// responder certificate issued by @issuer.
+func parseOCSPResponse(raw []byte, id ocspCertID, issuer *x509.Certificate) (*ocspStatus, error) {
+	var rsp ocspResponse
+	if rest, err := asn1.Unmarshal(raw, &rsp); err != nil || len(rest) != 0 {
+		return nil, jerrors.Errorf("malformed ocsp response, error:%v", err)
+	}
+	if rsp.Status != 0 {
+		return nil, jerrors.Errorf("ocsp response status %d", rsp.Status)
+	}
+	if !rsp.Response.ResponseType.Equal(oidOCSPBasicResponse) {
+		return nil, jerrors.Errorf("unsupported ocsp response type %s", rsp.Response.ResponseType)
+	}
+
+	var basic ocspBasicResponse
+	if rest, err := asn1.Unmarshal(rsp.Response.Response, &basic); err != nil || len(rest) != 0 {
+		return nil, jerrors.Errorf("malformed ocsp basic response, error:%v", err)
+	}
+	algo, ok := ocspSignatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, jerrors.Errorf("unsupported ocsp signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		responder, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return nil, jerrors.Trace(err)
+		}
+		if err = responder.CheckSignatureFrom(issuer); err != nil {
+			return nil, jerrors.Annotatef(err, "ocsp responder certificate")
+		}
+		signer = responder
+	}
+	if err := signer.CheckSignature(algo, basic.TBSResponseData.Raw, basic.Signature.RightAlign()); err != nil {
+		return nil, jerrors.Annotatef(err, "ocsp response signature")
+	}
+
+	for _, r := range basic.TBSResponseData.Responses {
+		if !r.CertID.equal(id) {
+			continue
+		}
+		s := &ocspStatus{raw: raw, status: ocspUnknown, thisUpdate: r.ThisUpdate, nextUpdate: r.NextUpdate}
+		switch {
+		case bool(r.Good):
+			s.status = ocspGood
+		case !r.Revoked.RevocationTime.IsZero():
+			s.status = ocspRevoked
+		}
+		return s, nil
+	}
+
+	return nil, jerrors.New("no ocsp response of the certificate")
+}
+
+/////////////////////////////////////////
+// ocsp stapler
+/////////////////////////////////////////
+
+type ocspStapler struct {
+	config OCSPConfig
+	cert   tls.Certificate
+	issuer *x509.Certificate
+	id     ocspCertID
+	client *http.Client
+
+	lock    sync.RWMutex
+	status  *ocspStatus
+	stapled *tls.Certificate
+}
+
+func newOCSPStapler(config *OCSPConfig, cert tls.Certificate) (*ocspStapler, error) {
+	if len(cert.Certificate) < 2 {
+		return nil, jerrors.New("the issuer certificate is not found after the server certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+
+	c := config.withDefaults()
+	if c.ResponderURL == "" {
+		if len(leaf.OCSPServer) == 0 {
+			return nil, jerrors.New("the certificate has no ocsp server")
+		}
+		c.ResponderURL = leaf.OCSPServer[0]
+	}
+	id, err := newOCSPCertID(leaf, issuer)
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+
+	s := &ocspStapler{
+		config:  c,
+		cert:    cert,
+		issuer:  issuer,
+		id:      id,
+		client:  &http.Client{Timeout: c.Timeout},
+		stapled: &cert,
+	}
+	s.loadCache()
+	return s, nil
+}
+
+// the tls.Config.GetCertificate of the server
+func (s *ocspStapler) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.stapled, nil
+}
+
+// staple the response @status if it is newer and valid at @now
+func (s *ocspStapler) staple(status *ocspStatus, now time.Time) bool {
+	if status.status == ocspUnknown || (!status.nextUpdate.IsZero() && !now.Before(status.nextUpdate)) {
+		return false
+	}
+	if status.status == ocspRevoked {
+		log.Error("[ocsp stapler] the server certificate %s is revoked", s.id.SerialNumber)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.status != nil && !status.thisUpdate.After(s.status.thisUpdate) {
+		return false
+	}
+	cert := s.cert
+	cert.OCSPStaple = status.raw
+	s.status = status
+	s.stapled = &cert
+	return true
+}
+
+func (s *ocspStapler) loadCache() {
+	if s.config.CacheFile == "" {
+		return
+	}
+
+	raw, err := ioutil.ReadFile(s.config.CacheFile)
+	if err != nil {
+		return
+	}
+	status, err := parseOCSPResponse(raw, s.id, s.issuer)
+	if err != nil {
+		log.Warn("[ocsp stapler] drop the cache %s, error:%s", s.config.CacheFile, jerrors.ErrorStack(err))
+		return
+	}
+	s.staple(status, time.Now())
+}
+
+func (s *ocspStapler) fetch() (*ocspStatus, error) {
+	req, err := asn1.Marshal(ocspRequest{TBSRequest: ocspTBSRequest{RequestList: []ocspSingleRequest{{Cert: s.id}}}})
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	rsp, err := s.client.Post(s.config.ResponderURL, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, jerrors.Errorf("ocsp responder %s returns %s", s.config.ResponderURL, rsp.Status)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(rsp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+
+	return parseOCSPResponse(raw, s.id, s.issuer)
+}
+
+// fetch & staple the response, and get the delay of the next refresh
+func (s *ocspStapler) refresh() time.Duration {
+	status, err := s.fetch()
+	if err != nil {
+		log.Warn("[ocsp stapler] fetch from %s error:%s", s.config.ResponderURL, jerrors.ErrorStack(err))
+		return s.config.RetryInterval
+	}
+
+	now := time.Now()
+	if s.staple(status, now) && s.config.CacheFile != "" {
+		if err = ioutil.WriteFile(s.config.CacheFile, status.raw, 0600); err != nil {
+			log.Warn("[ocsp stapler] write the cache %s error:%v", s.config.CacheFile, err)
+		}
+	}
+	delay := s.config.RefreshInterval
+	if !status.nextUpdate.IsZero() {
+		if half := status.nextUpdate.Sub(now) / 2; half < delay {
+			delay = half
+		}
+	}
+	if delay < s.config.RetryInterval {
+		delay = s.config.RetryInterval
+	}
+	return delay
+}
+
+func (s *ocspStapler) run(done <-chan struct{}) {
+	timer := time.NewTimer(s.refresh())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+			timer.Reset(s.refresh())
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package getty
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// build the ocsp response of the request @req signed by @key
+func newTestOCSPResponse(t *testing.T, req []byte, key *ecdsa.PrivateKey, good bool) []byte {
+	var r ocspRequest
+	_, err := asn1.Unmarshal(req, &r)
+	assert.Nil(t, err)
+
+	single := ocspSingleResponse{
+		CertID:     r.TBSRequest.RequestList[0].Cert,
+		ThisUpdate: time.Now().Add(-time.Minute).UTC(),
+		NextUpdate: time.Now().Add(time.Hour).UTC(),
+	}
+	if good {
+		single.Good = true
+	} else {
+		single.Revoked.RevocationTime = time.Now().Add(-time.Hour).UTC()
+	}
+	tbs, err := asn1.Marshal(ocspResponseData{
+		RawResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: []byte{4, 0}},
+		ProducedAt:     time.Now().UTC(),
+		Responses:      []ocspSingleResponse{single},
+	})
+	assert.Nil(t, err)
+	digest := sha256.Sum256(tbs)
+	sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Nil(t, err)
+	basic, err := asn1.Marshal(ocspBasicResponse{
+		TBSResponseData:    ocspResponseData{Raw: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	})
+	assert.Nil(t, err)
+	rsp, err := asn1.Marshal(ocspResponse{Response: ocspResponseBytes{ResponseType: oidOCSPBasicResponse, Response: basic}})
+	assert.Nil(t, err)
+
+	return rsp
+}
+
+// generate a server certificate whose chain contains its issuer
+func newTestOCSPCert(t *testing.T, responder string) (tls.Certificate, *ecdsa.PrivateKey) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "getty ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	assert.Nil(t, err)
+	ca, err = x509.ParseCertificate(caDER)
+	assert.Nil(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "getty"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{responder},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	assert.Nil(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der, caDER}, PrivateKey: key}, caKey
+}
+
+func TestOCSPStapler(t *testing.T) {
+	var (
+		fetches int32
+		caKey   *ecdsa.PrivateKey
+	)
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		req, _ := ioutil.ReadAll(r.Body)
+		w.Write(newTestOCSPResponse(t, req, caKey, true))
+	}))
+	defer responder.Close()
+	cert, key := newTestOCSPCert(t, responder.URL)
+	caKey = key
+
+	_, err := newOCSPStapler(&OCSPConfig{}, tls.Certificate{Certificate: cert.Certificate[:1]})
+	assert.NotNil(t, err)
+
+	cacheFile := filepath.Join(t.TempDir(), "ocsp.der")
+	stapler, err := newOCSPStapler(&OCSPConfig{CacheFile: cacheFile}, cert)
+	assert.Nil(t, err)
+	c, _ := stapler.getCertificate(nil)
+	assert.Nil(t, c.OCSPStaple)
+
+	delay := stapler.refresh()
+	assert.True(t, delay > 0 && delay <= 30*time.Minute, delay)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	c, _ = stapler.getCertificate(nil)
+	assert.NotNil(t, c.OCSPStaple)
+
+	// the staple is received by the tls client
+	server, client := net.Pipe()
+	go tls.Server(server, &tls.Config{GetCertificate: stapler.getCertificate}).Handshake()
+	tlsConn := tls.Client(client, &tls.Config{InsecureSkipVerify: true})
+	assert.Nil(t, tlsConn.Handshake())
+	assert.Equal(t, c.OCSPStaple, tlsConn.ConnectionState().OCSPResponse)
+	server.Close()
+	client.Close()
+
+	// the restarted server staples the cached response at once
+	stapler, err = newOCSPStapler(&OCSPConfig{CacheFile: cacheFile}, cert)
+	assert.Nil(t, err)
+	cached, _ := stapler.getCertificate(nil)
+	assert.Equal(t, c.OCSPStaple, cached.OCSPStaple)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+}
+
+func TestParseOCSPResponse(t *testing.T) {
+	cert, caKey := newTestOCSPCert(t, "http://127.0.0.1/ocsp")
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	issuer, _ := x509.ParseCertificate(cert.Certificate[1])
+	id, err := newOCSPCertID(leaf, issuer)
+	assert.Nil(t, err)
+	req, err := asn1.Marshal(ocspRequest{TBSRequest: ocspTBSRequest{RequestList: []ocspSingleRequest{{Cert: id}}}})
+	assert.Nil(t, err)
+
+	status, err := parseOCSPResponse(newTestOCSPResponse(t, req, caKey, false), id, issuer)
+	assert.Nil(t, err)
+	assert.Equal(t, ocspRevoked, status.status)
+
+	// a response signed by another key
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	_, err = parseOCSPResponse(newTestOCSPResponse(t, req, otherKey, true), id, issuer)
+	assert.NotNil(t, err)
+
+	_, err = parseOCSPResponse([]byte("garbage"), id, issuer)
+	assert.NotNil(t, err)
+}
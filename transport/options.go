@@ -10,6 +10,16 @@
 
 package getty
 
+import (
+	"crypto/tls"
+	"io"
+	"time"
+)
+
+import (
+	"github.com/gorilla/websocket"
+)
+
 /////////////////////////////////////////
 // Server Options
 /////////////////////////////////////////
@@ -24,6 +34,93 @@ type ServerOptions struct {
 	cert       string
 	privateKey string
 	caCert     string
+	upgrader   *websocket.Upgrader
+	origins    []string
+	healthPath string
+	readyPath  string
+
+	// remote ip ban list
+	banList *BanList
+	// session id generator
+	idGenerator SessionIDGenerator
+
+	// metrics
+	latencySampleRate    int
+	slowHandlerThreshold time.Duration
+	// the max delay of the pkgs in the write queues, see starvation.go
+	starvationThreshold time.Duration
+
+	// pair the udp clients which register the same rendezvous token
+	rendezvous bool
+
+	// compression & encryption negotiation of tcp sessions
+	negotiation *NegotiationConfig
+
+	// memory budget of the buffered data of sessions
+	memoryBudget *MemoryBudget
+
+	// journal of the session events
+	journal *Journal
+
+	// the file the active sessions are dumped to when a session goroutine panics
+	panicDumpPath string
+
+	// busy polling read mode of tcp sessions
+	busyPoll *BusyPollConfig
+
+	// rate limit of the session loggers
+	sessionLog SessionLogConfig
+
+	// admission & qos policy
+	policy Policy
+
+	// ip version of the listener
+	ipStack IPStack
+
+	// socket file of the unix server
+	unixSocket *UnixSocketConfig
+
+	// tls key log file of the wss server
+	keyLogPath string
+
+	// certificate expiry monitor of the wss server
+	certExpiry *CertExpiryConfig
+
+	// acme certificate provisioning of the wss server
+	acme ACMEManager
+
+	// ocsp stapling of the wss server
+	ocsp *OCSPConfig
+
+	// tls versions, cipher suites & curves of the wss server
+	tlsSettings *TLSSettings
+
+	// capture the tls fingerprints of the wss clients
+	tlsFingerprint bool
+
+	// client identification
+	identity *IdentityConfig
+
+	// audit log of the security-relevant events
+	auditLog *AuditLog
+
+	// protocol magic prefix of the first pkg of the tcp/ws/wss sessions
+	magic []byte
+
+	// tarpit of the rejected connections
+	tarpitConfig *TarpitConfig
+
+	// access log of the sessions & frames
+	accessLog *AccessLog
+
+	// handshake worker pool of the tcp/ws/wss server
+	handshakePoolConfig *HandshakePoolConfig
+	// timeout of the tls handshakes & the websocket upgrades
+	handshakeTimeout time.Duration
+
+	// the goroutine number & the deadline of closing the sessions when the server is closed
+	closeWorkers int
+	closeTimeout time.Duration
 }
 
 // @addr server listen address.
@@ -61,6 +158,292 @@ func WithWebsocketServerRootCert(cert string) ServerOption {
 	}
 }
 
+// @upgrader: the websocket upgrader config, such as buffer sizes, error handler and handshake timeout.
+// Pls attention that if its CheckOrigin is nil, the upgrader will reject cross-origin requests.
+// In default, the server accepts requests from any origin.
+func WithWebsocketServerUpgrader(upgrader websocket.Upgrader) ServerOption {
+	return func(o *ServerOptions) {
+		o.upgrader = &upgrader
+	}
+}
+
+// @origins: the hosts(e.g. "example.com", "example.com:8080") allowed in the Origin header of
+// websocket requests. Requests without Origin header are always allowed. It overrides the
+// CheckOrigin of the upgrader.
+func WithWebsocketServerOrigins(origins ...string) ServerOption {
+	return func(o *ServerOptions) {
+		o.origins = append(o.origins, origins...)
+	}
+}
+
+// @healthPath/@readyPath: url paths of the health and readiness probes of websocket server,
+// e.g. "/healthz" and "/readyz". An empty path disables the related probe.
+func WithWebsocketServerProbes(healthPath, readyPath string) ServerOption {
+	return func(o *ServerOptions) {
+		o.healthPath = healthPath
+		o.readyPath = readyPath
+	}
+}
+
+// @banList: connections from the banned remote ips will be rejected by tcp/ws/wss server,
+// and the session protocol errors will be reported to it as BanTriggerProtocolError.
+func WithServerBanList(banList *BanList) ServerOption {
+	return func(o *ServerOptions) {
+		o.banList = banList
+	}
+}
+
+// the udp endpoint works as a rendezvous server, which pairs the udp clients built with
+// WithRendezvous. The rendezvous datagrams are not delivered to the listener.
+func WithServerRendezvous() ServerOption {
+	return func(o *ServerOptions) {
+		o.rendezvous = true
+	}
+}
+
+// @generator: the session ids of the server will be generated by it.
+func WithServerSessionIDGenerator(generator SessionIDGenerator) ServerOption {
+	return func(o *ServerOptions) {
+		o.idGenerator = generator
+	}
+}
+
+// @rate: one of every @rate read/write operations will be sampled into the latency
+// histograms of the server. 0 means disabled.
+func WithServerLatencySampling(rate int) ServerOption {
+	return func(o *ServerOptions) {
+		if 0 <= rate {
+			o.latencySampleRate = rate
+		}
+	}
+}
+
+// @threshold: OnMessage invocations which take longer than @threshold will be flagged by
+// the slow handler watchdog of the server. 0 means disabled.
+func WithServerSlowHandlerThreshold(threshold time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		if 0 <= threshold {
+			o.slowHandlerThreshold = threshold
+		}
+	}
+}
+
+// @threshold: the pkgs which wait in the write queue of a session longer than @threshold are
+// counted as starved, and the WriteStarvationListener of the session is notified. 0 means disabled.
+func WithServerStarvationThreshold(threshold time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		if 0 <= threshold {
+			o.starvationThreshold = threshold
+		}
+	}
+}
+
+// @config: the capability list of the tcp server. Every accepted tcp connection negotiates
+// the compress type & cipher suite with the client before its session is built, and the
+// client should enable the negotiation by WithNegotiation too.
+func WithServerNegotiation(config *NegotiationConfig) ServerOption {
+	return func(o *ServerOptions) {
+		o.negotiation = config
+	}
+}
+
+// @budget: the memory budget of the buffered data of the server sessions. It can be shared
+// with other endpoints to cap the memory of the process.
+func WithServerMemoryBudget(budget *MemoryBudget) ServerOption {
+	return func(o *ServerOptions) {
+		o.memoryBudget = budget
+	}
+}
+
+// @journal: the journal which the lifecycle events & the errors of the server sessions are
+// appended to. It can be shared with other endpoints.
+func WithServerJournal(journal *Journal) ServerOption {
+	return func(o *ServerOptions) {
+		o.journal = journal
+	}
+}
+
+// @path: the file which the summary of the active server sessions is dumped to when a panic
+// is not recovered in a session goroutine, which re-panics after the dump. The panic is only
+// logged by default.
+func WithServerPanicDump(path string) ServerOption {
+	return func(o *ServerOptions) {
+		o.panicDumpPath = path
+	}
+}
+
+// @config: the busy polling read mode of the tcp server sessions for the ultra-low-latency
+// deployments. nil means the read goroutines block on the reads, which is the default.
+func WithServerBusyPoll(config *BusyPollConfig) ServerOption {
+	return func(o *ServerOptions) {
+		o.busyPoll = nil
+		if config != nil {
+			c := config.withDefaults()
+			o.busyPoll = &c
+		}
+	}
+}
+
+// @config: the rate limit of the loggers of the server sessions got by (SessionInspector)Logger.
+func WithServerSessionLog(config SessionLogConfig) ServerOption {
+	return func(o *ServerOptions) {
+		o.sessionLog = config
+	}
+}
+
+// @stack: the ip version of the listener of the tcp/ws/wss/udp server, see IPStack.
+func WithServerIPStack(stack IPStack) ServerOption {
+	return func(o *ServerOptions) {
+		o.ipStack = stack
+	}
+}
+
+// @config: the mode & the owner of the socket file of the unix server.
+func WithServerUnixSocket(config *UnixSocketConfig) ServerOption {
+	return func(o *ServerOptions) {
+		o.unixSocket = config
+	}
+}
+
+// @path: the file the tls session keys of the wss server are appended to in the NSS key log
+// format, so that wireshark can decrypt the captured traffic. The environment variable
+// SSLKEYLOGFILE is used if it is empty. It takes effect only if getty is built with the tag
+// gettykeylog, and it is ignored by the production builds.
+func WithServerTLSKeyLog(path string) ServerOption {
+	return func(o *ServerOptions) {
+		o.keyLogPath = keyLogPath(path)
+	}
+}
+
+// @manager: the acme manager which provisions & renews the certificates of the wss server,
+// e.g. *autocert.Manager. The certificate & private key files are not used if it is set.
+// The TLS-ALPN-01 challenges are answered on the wss listener, so pls listen on 443 or
+// forward 443 to the listener, see ACMEManager.
+func WithServerACME(manager ACMEManager) ServerOption {
+	return func(o *ServerOptions) {
+		o.acme = manager
+	}
+}
+
+// @config: the ocsp stapling of the wss server, which is ignored if the certificates are
+// provisioned by acme.
+func WithServerOCSPStapling(config *OCSPConfig) ServerOption {
+	return func(o *ServerOptions) {
+		o.ocsp = config
+	}
+}
+
+// @settings: the tls versions, cipher suites, curves of the wss server. The secure defaults
+// are used if it is nil, see TLSSettings.
+func WithServerTLSSettings(settings *TLSSettings) ServerOption {
+	return func(o *ServerOptions) {
+		o.tlsSettings = settings
+	}
+}
+
+// capture the ClientHellos of the wss clients, whose JA3/JA4 fingerprints are got by
+// (SessionInspector)TLSFingerprint, e.g. in the Handshake of the Policy or in NewSessionCallback.
+func WithServerTLSFingerprint() ServerOption {
+	return func(o *ServerOptions) {
+		o.tlsFingerprint = true
+	}
+}
+
+// @config: the certificate expiry monitor of the wss server.
+func WithServerCertExpiry(config *CertExpiryConfig) ServerOption {
+	return func(o *ServerOptions) {
+		o.certExpiry = config
+	}
+}
+
+// @config: the client identification of the tcp/ws/wss server, whose Identity is attached
+// to the session at handshake or by its first pkg.
+func WithServerIdentity(config *IdentityConfig) ServerOption {
+	return func(o *ServerOptions) {
+		o.identity = config
+	}
+}
+
+// @auditLog: the audit log which the auth results, the bans, the quota violations and the tls
+// errors of the server are emitted to. It can be shared with other endpoints.
+func WithServerAuditLog(auditLog *AuditLog) ServerOption {
+	return func(o *ServerOptions) {
+		o.auditLog = auditLog
+	}
+}
+
+// @magic: the protocol magic prefix which the first pkg of every tcp/ws/wss session must begin
+// with. The session is closed with ErrBadMagic on mismatch before its codec runs, which sheds
+// the port scanners and the misdirected clients. The prefix is kept for the codec. It has no
+// effect on the websocket sessions whose codec is a StreamReader.
+func WithServerMagic(magic []byte) ServerOption {
+	return func(o *ServerOptions) {
+		o.magic = append([]byte(nil), magic...)
+	}
+}
+
+// @config: the tarpit which holds the connections from the banned remote ips or failing their
+// handshakes open instead of closing them, to slow down the scanners.
+func WithServerTarpit(config *TarpitConfig) ServerOption {
+	return func(o *ServerOptions) {
+		o.tarpitConfig = config
+	}
+}
+
+// @accessLog: the access log which logs a record per session of the server, and a record per
+// frame of the ws/wss sessions and the tcp sessions whose codec is an AccessLogRequester if
+// its frame logging is enabled. It can be shared with other servers.
+func WithServerAccessLog(accessLog *AccessLog) ServerOption {
+	return func(o *ServerOptions) {
+		o.accessLog = accessLog
+	}
+}
+
+// @policy: the policy consulted when the server accepts a connection, when a session completes
+// its handshake and when a pkg is written.
+func WithServerPolicy(policy Policy) ServerOption {
+	return func(o *ServerOptions) {
+		o.policy = policy
+	}
+}
+
+// @config: the handshake worker pool of the tcp/ws/wss server. The handshakes of the accepted
+// connections run in the pool instead of the accept goroutine. The accepted connections are
+// handled one by one in the accept goroutine by default.
+func WithServerHandshakePool(config *HandshakePoolConfig) ServerOption {
+	return func(o *ServerOptions) {
+		o.handshakePoolConfig = config
+	}
+}
+
+// @timeout: the timeout of the tls handshake of a wss server and the upgrade of a ws/wss server,
+// which is separate from the read timeout of the sessions. A client which does not complete
+// its handshake in time is closed, and the failures are counted by (EndPointMetrics)HandshakeFailures.
+// Its default value is the timeout of the handshake pool, or no timeout if there is no pool.
+func WithServerHandshakeTimeout(timeout time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		if 0 < timeout {
+			o.handshakeTimeout = timeout
+		}
+	}
+}
+
+// @workers: the number of the goroutines which close the running sessions concurrently when
+// the server is closed. Its default value is 64.
+// @timeout: the deadline of closing the sessions. The sessions are waited to send out their
+// write queues and exit before it, and the sessions left are closed without waiting after it.
+// Its default value is 5s.
+func WithServerShutdown(workers int, timeout time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		if 0 < workers {
+			o.closeWorkers = workers
+		}
+		if 0 < timeout {
+			o.closeTimeout = timeout
+		}
+	}
+}
+
 /////////////////////////////////////////
 // Client Options
 /////////////////////////////////////////
@@ -76,6 +459,74 @@ type ClientOptions struct {
 	// duration, the hash alg, the len of the private key.
 	// wss client will use it.
 	cert string
+	// ws/wss server urls which will be dialed in turn
+	wsURLs []WebsocketURL
+	// websocket handshake timeout
+	handshakeTimeout time.Duration
+	// invoked on every failed dial
+	dialErrorHandler DialErrorHandler
+	// max number of the pkgs written before a session is connected
+	pendingQLen int
+	// session id generator
+	idGenerator SessionIDGenerator
+
+	// serial device opener
+	serialOpener SerialOpener
+
+	// udp nat traversal
+	rendezvousToken string
+	natKeepAlive    time.Duration
+
+	// compression & encryption negotiation of tcp sessions
+	negotiation *NegotiationConfig
+
+	// memory budget of the buffered data of sessions
+	memoryBudget *MemoryBudget
+
+	// journal of the session events
+	journal *Journal
+
+	// the file the active sessions are dumped to when a session goroutine panics
+	panicDumpPath string
+
+	// busy polling read mode of tcp sessions
+	busyPoll *BusyPollConfig
+
+	// rate limit of the session loggers
+	sessionLog SessionLogConfig
+
+	// admission & qos policy
+	policy Policy
+
+	// wss fallback of tcp client
+	fallback *FallbackConfig
+
+	// probe of the ws/wss urls
+	transportProbe *TransportProbeConfig
+
+	// tls key log file of the wss client
+	keyLogPath string
+
+	// certificate expiry monitor of the wss client
+	certExpiry *CertExpiryConfig
+
+	// tls versions, cipher suites & curves of the wss client
+	tlsSettings *TLSSettings
+
+	// audit log of the security-relevant events
+	auditLog *AuditLog
+
+	// suspend & resume handling
+	suspend *SuspendConfig
+
+	// network change detection
+	networkChange *NetworkChangeConfig
+
+	// metrics
+	latencySampleRate    int
+	slowHandlerThreshold time.Duration
+	// the max delay of the pkgs in the write queues, see starvation.go
+	starvationThreshold time.Duration
 }
 
 // @addr is server address.
@@ -109,3 +560,255 @@ func WithRootCertificateFile(cert string) ClientOption {
 		o.cert = cert
 	}
 }
+
+// WebsocketURL is a ws/wss server url and the tls config used to dial it.
+type WebsocketURL struct {
+	URL string
+	// tls config of a wss url. if it is nil, the config built from the root certificate file is used.
+	TLSConfig *tls.Config
+}
+
+// @urls are ws/wss server urls. ws/wss client dials them in turn, and it switches to the
+// next one when it fails to dial the current one. The server address will be the first
+// url if it is empty.
+func WithWebsocketURLs(urls ...WebsocketURL) ClientOption {
+	return func(o *ClientOptions) {
+		o.wsURLs = append(o.wsURLs, urls...)
+	}
+}
+
+// @config: the wss fallback of a tcp client, which dials the wss url if it fails to dial
+// the tcp server address. It has no effect on other clients.
+func WithTransportFallback(config *FallbackConfig) ClientOption {
+	return func(o *ClientOptions) {
+		o.fallback = config
+	}
+}
+
+// @config: the transport probe of a ws/wss client with multiple urls set by WithWebsocketURLs.
+// The client probes the urls periodically, and its new sessions dial the best one. The urls
+// are dialed in turn by default.
+func WithTransportProbe(config *TransportProbeConfig) ClientOption {
+	return func(o *ClientOptions) {
+		o.transportProbe = config
+	}
+}
+
+// @generator: the session ids of the client will be generated by it.
+func WithClientSessionIDGenerator(generator SessionIDGenerator) ClientOption {
+	return func(o *ClientOptions) {
+		o.idGenerator = generator
+	}
+}
+
+// @timeout: the opening handshake timeout of ws/wss client, which includes the tcp connect
+// and tls handshake. Its default value is 3s, the same as the tcp connect timeout. When it
+// expires, the dial fails with ErrHandshakeTimeout.
+func WithHandshakeTimeout(timeout time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		if 0 < timeout {
+			o.handshakeTimeout = timeout
+		}
+	}
+}
+
+// DialErrorHandler is invoked with the server address and the error when the client fails
+// to dial the server, before it redials. The getty errors such as ErrHandshakeTimeout can
+// be checked by errors.Is.
+type DialErrorHandler func(addr string, err error)
+
+// @handler: it will be invoked on every failed dial of the client.
+func WithDialErrorHandler(handler DialErrorHandler) ClientOption {
+	return func(o *ClientOptions) {
+		o.dialErrorHandler = handler
+	}
+}
+
+// @qLen: the max number of the pkgs written by (Client)WritePkgContext while the client is
+// connecting or reconnecting. Its default value is 1024.
+func WithClientPendingQueueLen(qLen int) ClientOption {
+	return func(o *ClientOptions) {
+		if 0 < qLen {
+			o.pendingQLen = qLen
+		}
+	}
+}
+
+// @rate: one of every @rate read/write operations will be sampled into the latency
+// histograms of the client. 0 means disabled.
+func WithClientLatencySampling(rate int) ClientOption {
+	return func(o *ClientOptions) {
+		if 0 <= rate {
+			o.latencySampleRate = rate
+		}
+	}
+}
+
+// @threshold: OnMessage invocations which take longer than @threshold will be flagged by
+// the slow handler watchdog of the client. 0 means disabled.
+func WithClientSlowHandlerThreshold(threshold time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		if 0 <= threshold {
+			o.slowHandlerThreshold = threshold
+		}
+	}
+}
+
+// @threshold: the pkgs which wait in the write queue of a session longer than @threshold are
+// counted as starved, and the WriteStarvationListener of the session is notified. 0 means disabled.
+func WithClientStarvationThreshold(threshold time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		if 0 <= threshold {
+			o.starvationThreshold = threshold
+		}
+	}
+}
+
+// @token: the udp client registers @token to the rendezvous server whose address is set by
+// WithServerAddress, and the server returns the address of another client which registers
+// the same token. Then the clients punch a hole through their nats by sending datagrams to
+// each other, and the session of the client connects to the peer client instead of the server.
+// The server should be a udp endpoint built with WithServerRendezvous. Pls attention that
+// (Client)RunEventLoop blocks until the peer client registers the token.
+func WithRendezvous(token string) ClientOption {
+	return func(o *ClientOptions) {
+		o.rendezvousToken = token
+	}
+}
+
+// @interval: the udp client session sends a small keepalive datagram every @interval to keep
+// its nat mapping alive. 0 means disabled.
+func WithNATKeepAlive(interval time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		if 0 <= interval {
+			o.natKeepAlive = interval
+		}
+	}
+}
+
+// SerialOpener opens the serial device @name, which is the server address of the serial
+// client, and configures its baud rate, parity, etc. e.g.
+//
+//	func(name string) (io.ReadWriteCloser, error) {
+//		return serial.Open(&serial.Config{Name: name, Baud: 115200})
+//	}
+type SerialOpener func(name string) (io.ReadWriteCloser, error)
+
+// @opener: the serial client opens its device by @opener. The device is opened as a file
+// with its current tty settings if it is not set.
+func WithSerialOpener(opener SerialOpener) ClientOption {
+	return func(o *ClientOptions) {
+		o.serialOpener = opener
+	}
+}
+
+// @config: the capability list of the tcp client. Every tcp connection negotiates the compress
+// type & cipher suite with the server, which should enable the negotiation by WithServerNegotiation,
+// before its session is built. The result can be got by (SessionInspector)Negotiated.
+func WithNegotiation(config *NegotiationConfig) ClientOption {
+	return func(o *ClientOptions) {
+		o.negotiation = config
+	}
+}
+
+// @budget: the memory budget of the buffered data of the client sessions. It can be shared
+// with other endpoints to cap the memory of the process.
+func WithClientMemoryBudget(budget *MemoryBudget) ClientOption {
+	return func(o *ClientOptions) {
+		o.memoryBudget = budget
+	}
+}
+
+// @journal: the journal which the lifecycle events & the errors of the client sessions are
+// appended to. It can be shared with other endpoints.
+func WithClientJournal(journal *Journal) ClientOption {
+	return func(o *ClientOptions) {
+		o.journal = journal
+	}
+}
+
+// @path: the file which the summary of the active client sessions is dumped to when a panic
+// is not recovered in a session goroutine, which re-panics after the dump. The panic is only
+// logged by default.
+func WithClientPanicDump(path string) ClientOption {
+	return func(o *ClientOptions) {
+		o.panicDumpPath = path
+	}
+}
+
+// @config: the busy polling read mode of the tcp client sessions for the ultra-low-latency
+// deployments. nil means the read goroutines block on the reads, which is the default.
+func WithClientBusyPoll(config *BusyPollConfig) ClientOption {
+	return func(o *ClientOptions) {
+		o.busyPoll = nil
+		if config != nil {
+			c := config.withDefaults()
+			o.busyPoll = &c
+		}
+	}
+}
+
+// @config: the rate limit of the loggers of the client sessions got by (SessionInspector)Logger.
+func WithClientSessionLog(config SessionLogConfig) ClientOption {
+	return func(o *ClientOptions) {
+		o.sessionLog = config
+	}
+}
+
+// @path: the file the tls session keys of the wss client, including its wss fallback, are
+// appended to in the NSS key log format. The environment variable SSLKEYLOGFILE is used if
+// it is empty. It takes effect only if getty is built with the tag gettykeylog.
+func WithClientTLSKeyLog(path string) ClientOption {
+	return func(o *ClientOptions) {
+		o.keyLogPath = keyLogPath(path)
+	}
+}
+
+// @settings: the tls versions, cipher suites, curves & renegotiation of the wss client. The
+// secure defaults are used by the tls config built from the root certificate file if it is
+// nil, and the tls configs of the ws/wss urls & the wss fallback are kept as they are set.
+func WithClientTLSSettings(settings *TLSSettings) ClientOption {
+	return func(o *ClientOptions) {
+		o.tlsSettings = settings
+	}
+}
+
+// @config: the certificate expiry monitor of the wss client.
+func WithClientCertExpiry(config *CertExpiryConfig) ClientOption {
+	return func(o *ClientOptions) {
+		o.certExpiry = config
+	}
+}
+
+// @auditLog: the audit log which the tls errors of the wss client are emitted to. It can be
+// shared with other endpoints.
+func WithClientAuditLog(auditLog *AuditLog) ClientOption {
+	return func(o *ClientOptions) {
+		o.auditLog = auditLog
+	}
+}
+
+// @policy: the policy consulted when a session connects to the server and when a pkg is written.
+func WithClientPolicy(policy Policy) ClientOption {
+	return func(o *ClientOptions) {
+		o.policy = policy
+	}
+}
+
+// @config: the suspend & resume handling of the client, which detects the resume of the
+// system and validates the sessions at once, so the dead sessions are redialed without
+// waiting for the read deadlines.
+func WithSuspendDetection(config *SuspendConfig) ClientOption {
+	return func(o *ClientOptions) {
+		o.suspend = config
+	}
+}
+
+// @config: the network change detection of the client, which closes and redials the sessions
+// affected by the interface & address changes at once, e.g. when a mobile device switches
+// between wifi & cellular.
+func WithNetworkChangeDetection(config *NetworkChangeConfig) ClientOption {
+	return func(o *ClientOptions) {
+		o.networkChange = config
+	}
+}
@@ -1,6 +1,7 @@
 package getty
 
 import (
+	"crypto/tls"
 	"testing"
 )
 
@@ -50,3 +51,26 @@ func TestServerOptions(t *testing.T) {
 	assert.Equal(t, srv.privateKey, key)
 	assert.Equal(t, srv.caCert, cert)
 }
+
+func TestClientWebsocketURLs(t *testing.T) {
+	config := &tls.Config{ServerName: "example.com"}
+	clt := newClient(WSS_CLIENT,
+		WithConnectionNumber(1),
+		WithWebsocketURLs(
+			WebsocketURL{URL: "wss://127.0.0.1:10000/hello"},
+			WebsocketURL{URL: "wss://127.0.0.1:10001/hello", TLSConfig: config},
+		),
+	)
+	assert.Equal(t, "wss://127.0.0.1:10000/hello", clt.addr)
+
+	addr, cfg := clt.websocketURL(nil)
+	assert.Equal(t, "wss://127.0.0.1:10000/hello", addr)
+	assert.Nil(t, cfg)
+	clt.nextWebsocketURL()
+	addr, cfg = clt.websocketURL(nil)
+	assert.Equal(t, "wss://127.0.0.1:10001/hello", addr)
+	assert.Equal(t, config, cfg)
+	clt.nextWebsocketURL()
+	addr, _ = clt.websocketURL(nil)
+	assert.Equal(t, "wss://127.0.0.1:10000/hello", addr)
+}
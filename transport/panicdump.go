@@ -0,0 +1,136 @@
+/******************************************************
+# DESC       : panic dump of the active sessions
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-29 15:40
+# FILE       : panicdump.go
+******************************************************/
+
+package getty
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+// panicDumper tracks the active sessions of an endpoint, and dumps their summary to a file
+// when a session goroutine panics.
+type panicDumper struct {
+	path    string
+	metrics *EndPointMetrics
+
+	lock sync.Mutex
+	// active session -> its last error
+	sessions map[*session]string
+	dumped   uint32
+}
+
+func newPanicDumper(path string, metrics *EndPointMetrics) *panicDumper {
+	if path == "" {
+		return nil
+	}
+
+	return &panicDumper{
+		path:     path,
+		metrics:  metrics,
+		sessions: make(map[*session]string),
+	}
+}
+
+func (d *panicDumper) register(s *session) {
+	d.lock.Lock()
+	d.sessions[s] = ""
+	d.lock.Unlock()
+}
+
+func (d *panicDumper) unregister(s *session) {
+	d.lock.Lock()
+	delete(d.sessions, s)
+	d.lock.Unlock()
+}
+
+func (d *panicDumper) setLastError(s *session, err error) {
+	d.lock.Lock()
+	if _, ok := d.sessions[s]; ok {
+		d.sessions[s] = err.Error()
+	}
+	d.lock.Unlock()
+}
+
+// write the panic @r of @ss and the summary of the active sessions to the dump file.
+// Only the first panic is dumped.
+func (d *panicDumper) dump(ss *session, r interface{}, stack []byte) error {
+	if !atomic.CompareAndSwapUint32(&d.dumped, 0, 1) {
+		return nil
+	}
+
+	file, err := os.Create(d.path)
+	if err != nil {
+		return jerrors.Annotatef(err, "os.Create(%s)", d.path)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	fmt.Fprintf(w, "getty panic dump at %s\n", time.Now().Format(time.RFC3339Nano))
+	fmt.Fprintf(w, "panic in session %s: %v\n%s\n", ss.sessionKey(), r, stack)
+	fmt.Fprintf(w, "endpoint metrics: %s\n", d.metrics)
+
+	d.lock.Lock()
+	fmt.Fprintf(w, "active sessions: %d\n", len(d.sessions))
+	for s, lastErr := range d.sessions {
+		fmt.Fprintf(w, "%s\n", s.panicSummary(lastErr))
+	}
+	d.lock.Unlock()
+
+	if err = w.Flush(); err != nil {
+		return jerrors.Trace(err)
+	}
+	return jerrors.Trace(file.Sync())
+}
+
+// the session token which is still available after the session is closed
+func (s *session) sessionKey() string {
+	return fmt.Sprintf("{%s:%s<->%s}", s.sessionName(), s.LocalAddr(), s.RemoteAddr())
+}
+
+func (s *session) panicSummary(lastErr string) string {
+	summary := fmt.Sprintf("%s closed:%t, active:%s, wQ:%d/%d, memory:%d",
+		s.sessionKey(), s.IsClosed(), s.GetActive().Format(time.RFC3339Nano),
+		len(s.wQ), cap(s.wQ), s.memoryUsed())
+	if conn := s.gettyConn(); conn != nil {
+		summary += fmt.Sprintf(", read bytes:%d, write bytes:%d, read pkgs:%d, write pkgs:%d",
+			atomic.LoadUint32(&conn.readBytes), atomic.LoadUint32(&conn.writeBytes),
+			atomic.LoadUint32(&conn.readPkgNum), atomic.LoadUint32(&conn.writePkgNum))
+	}
+	if lastErr != "" {
+		summary += fmt.Sprintf(", last error:%q", lastErr)
+	}
+
+	return summary
+}
+
+// dump the panic @r of a session goroutine and re-panic if the panic dump of the endpoint
+// is enabled. Otherwise the panic is only logged by the caller.
+func (s *session) dumpPanic(r interface{}, stack []byte) {
+	d := s.panicDump
+	if d == nil {
+		return
+	}
+
+	if err := d.dump(s, r, stack); err != nil {
+		log.Error("%s, [session.dumpPanic] dump to %s error:%s", s.sessionKey(), d.path, jerrors.ErrorStack(err))
+	} else {
+		log.Error("%s, [session.dumpPanic] the active sessions have been dumped to %s", s.sessionKey(), d.path)
+	}
+	panic(r)
+}
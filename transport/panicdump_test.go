@@ -0,0 +1,53 @@
+package getty
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPanicDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getty-panic")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "panic.dump")
+	assert.Nil(t, newPanicDumper("", nil))
+	d := newPanicDumper(path, newEndPointMetrics(0))
+
+	src, dst := newPipeSessions(t)
+	for _, ss := range []*session{src, dst} {
+		ss.panicDump = d
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(&lineListener{msgs: make(chan interface{}, 4)})
+		ss.run()
+		defer ss.Close()
+	}
+	dst.notifyError(errors.New("broken pipe"), ErrorDirectionWrite)
+
+	// the panic is not dumped if the panic dump is disabled
+	ss, _ := newPipeSessions(t)
+	assert.NotPanics(t, func() { ss.dumpPanic("boom", nil) })
+
+	assert.PanicsWithValue(t, "boom", func() { src.dumpPanic("boom", []byte("goroutine 1 [running]")) })
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	dump := string(data)
+	assert.True(t, strings.Contains(dump, "panic in session "+src.sessionKey()+": boom\ngoroutine 1 [running]"))
+	assert.True(t, strings.Contains(dump, "active sessions: 2\n"))
+	assert.True(t, strings.Contains(dump, dst.sessionKey()+" closed:false"))
+	assert.True(t, strings.Contains(dump, `last error:"broken pipe"`))
+
+	// only the first panic is dumped
+	assert.Nil(t, os.Remove(path))
+	assert.Panics(t, func() { dst.dumpPanic("boom again", nil) })
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,126 @@
+/******************************************************
+# DESC       : client pending write queue
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-15 17:20
+# FILE       : pending.go
+******************************************************/
+
+package getty
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+// a pkg written while the client has no connected session
+type pendingPkg struct {
+	ctx      context.Context
+	pkg      interface{}
+	canceled int32
+	done     chan error
+}
+
+// get a connected session of the client. it is invoked with @c.pendingLock locked, and a
+// session is published into @c.ssMap with @c.pendingLock locked too, so that a pkg is either
+// written to a connected session or flushed by the session connected later.
+func (c *client) connectedSession() Session {
+	c.Lock()
+	defer c.Unlock()
+
+	for ss := range c.ssMap {
+		if !ss.IsClosed() {
+			return ss
+		}
+	}
+
+	return nil
+}
+
+// WritePkgContext writes @pkg through a connected session of the client. If the client is
+// connecting or reconnecting, or the pending pkgs are being flushed, @pkg is put into the
+// bounded pending queue and it will be flushed in order once a session is connected. It blocks until @pkg has been put into the write
+// queue of a session, or @ctx is done, or the client is closed.
+//
+// It returns ErrQueueFull if the pending queue is full, and ErrSessionClosed if the client
+// is closed before a session is connected.
+func (c *client) WritePkgContext(ctx context.Context, pkg interface{}) error {
+	if c.IsClosed() {
+		return ErrSessionClosed
+	}
+
+	c.pendingLock.Lock()
+	if c.flushing == 0 && len(c.pending) == 0 {
+		if ss := c.connectedSession(); ss != nil {
+			c.pendingLock.Unlock()
+			return ss.(SessionWriter).WritePkgContext(ctx, pkg)
+		}
+	}
+	if c.pendingQLen <= len(c.pending) {
+		c.pendingLock.Unlock()
+		return ErrQueueFull
+	}
+	p := &pendingPkg{ctx: ctx, pkg: pkg, done: make(chan error, 1)}
+	c.pending = append(c.pending, p)
+	c.pendingLock.Unlock()
+
+	select {
+	case err := <-p.done:
+		return err
+	case <-ctx.Done():
+		atomic.StoreInt32(&p.canceled, 1)
+		return ctx.Err()
+	case <-c.done:
+		return ErrSessionClosed
+	}
+}
+
+// flush the pending pkgs into the write queue of the connected session @ss in order, until
+// the pending queue is empty. the pkgs written meanwhile are put into the pending queue too.
+// the pkgs are put back into the pending queue if @ss is closed before they are flushed.
+func (c *client) flushPending(ss Session) {
+	for {
+		c.pendingLock.Lock()
+		pending := c.pending
+		c.pending = nil
+		if len(pending) == 0 {
+			c.flushing--
+			c.pendingLock.Unlock()
+			return
+		}
+		c.pendingLock.Unlock()
+
+		for i, p := range pending {
+			if atomic.LoadInt32(&p.canceled) == 1 {
+				continue
+			}
+			err := ss.(SessionWriter).WritePkgContext(p.ctx, p.pkg)
+			if err == ErrSessionClosed && !c.IsClosed() {
+				log.Warn("%s is closed while flushing pending pkgs, left pkg num:%d", ss.Stat(), len(pending)-i)
+				c.pendingLock.Lock()
+				c.pending = append(pending[i:], c.pending...)
+				c.flushing--
+				c.pendingLock.Unlock()
+				return
+			}
+			p.done <- err
+		}
+	}
+}
+
+// fail the pending pkgs when the client is closed
+func (c *client) clearPending() {
+	c.pendingLock.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.pendingLock.Unlock()
+
+	for _, p := range pending {
+		p.done <- ErrSessionClosed
+	}
+}
@@ -0,0 +1,147 @@
+package getty
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientPendingWrite(t *testing.T) {
+	// get a free port, and listen on it after the client starts connecting
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	var msgHandler MessageHandler
+	client := newClient(TCP_CLIENT,
+		WithServerAddress(addr),
+		WithConnectionNumber(1),
+		WithClientPendingQueueLen(2),
+	)
+	go client.RunEventLoop(func(ss Session) error {
+		err := newSessionCallback(ss, &msgHandler)
+		ss.SetPkgHandler(&lineTransferCodec{})
+		return err
+	})
+	defer client.Close()
+
+	errs := make(chan error, 3)
+	for _, pkg := range []string{"hello", "world", "overflow"} {
+		pkg := pkg
+		go func() {
+			errs <- client.WritePkgContext(context.Background(), pkg)
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, ErrQueueFull, <-errs)
+
+	l, err = net.Listen("tcp", addr)
+	assert.Nil(t, err)
+	defer l.Close()
+	conn, err := l.Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Nil(t, <-errs)
+	assert.Nil(t, <-errs)
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\n", line)
+	line, err = r.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "world\n", line)
+
+	// written through the connected session directly
+	assert.Nil(t, client.WritePkgContext(context.Background(), "direct"))
+	line, err = r.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "direct\n", line)
+}
+
+// a line codec whose Write blocks until @gate is closed
+type gatedLineCodec struct {
+	lineTransferCodec
+	gate chan struct{}
+}
+
+func (c *gatedLineCodec) Write(ss Session, pkg interface{}) ([]byte, error) {
+	<-c.gate
+	return c.lineTransferCodec.Write(ss, pkg)
+}
+
+func TestClientPendingWriteOrder(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	var msgHandler MessageHandler
+	codec := &gatedLineCodec{gate: make(chan struct{})}
+	client := newClient(TCP_CLIENT,
+		WithServerAddress(addr),
+		WithConnectionNumber(1),
+	)
+	go client.RunEventLoop(func(ss Session) error {
+		err := newSessionCallback(ss, &msgHandler)
+		ss.SetPkgHandler(codec)
+		ss.SetWQLen(1)
+		return err
+	})
+	defer client.Close()
+
+	pkgs := []string{"p0", "p1", "p2", "p3"}
+	errs := make(chan error, len(pkgs)+1)
+	for _, pkg := range pkgs {
+		pkg := pkg
+		go func() {
+			errs <- client.WritePkgContext(context.Background(), pkg)
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	l, err = net.Listen("tcp", addr)
+	assert.Nil(t, err)
+	defer l.Close()
+	conn, err := l.Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// the flush blocks on the full write queue, and a pkg written meanwhile
+	// must not go ahead of the pending ones
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		errs <- client.WritePkgContext(context.Background(), "direct")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(codec.gate)
+	for i := 0; i < len(pkgs)+1; i++ {
+		assert.Nil(t, <-errs)
+	}
+
+	r := bufio.NewReader(conn)
+	for _, pkg := range append(pkgs, "direct") {
+		line, err := r.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, pkg+"\n", line)
+	}
+}
+
+func TestClientPendingWriteClosed(t *testing.T) {
+	client := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:1"), WithConnectionNumber(1))
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.WritePkgContext(context.Background(), "hello")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	client.Close()
+	assert.Equal(t, ErrSessionClosed, <-errs)
+	assert.Equal(t, ErrSessionClosed, client.WritePkgContext(context.Background(), "hello"))
+}
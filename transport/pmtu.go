@@ -0,0 +1,46 @@
+/******************************************************
+# DESC       : udp path mtu discovery
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-18 11:20
+# FILE       : pmtu.go
+******************************************************/
+
+package getty
+
+const (
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	udpHeaderLen  = 8
+
+	// the udp payload size which is safe for most paths when the path mtu is unknown.
+	defaultMaxDatagramSize = 1200
+)
+
+// get the max udp payload size of @u which will not be fragmented by ip.
+// The path mtu can be got only by a connected udp socket with a kernel support,
+// otherwise defaultMaxDatagramSize is returned.
+func (u *gettyUDPConn) maxDatagramSize() int {
+	size := defaultMaxDatagramSize
+	if u.conn != nil && !u.isClosed() && u.conn.RemoteAddr() != nil {
+		if mtu, ipHeaderLen, err := pathMTU(u.conn); err == nil && ipHeaderLen+udpHeaderLen < mtu {
+			size = mtu - ipHeaderLen - udpHeaderLen
+		}
+	}
+	if u.sealer != nil {
+		size -= cipherDatagramOverhead
+	}
+	if u.dedup != nil {
+		size -= dedupHeaderLen
+	}
+	if u.fec != nil {
+		// the parity shard is larger than the data shards
+		size -= fecHeaderLen + fecShardLenSize
+	}
+	if u.fragment != nil {
+		size -= fragmentHeaderLen
+	}
+
+	return size
+}
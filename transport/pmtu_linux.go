@@ -0,0 +1,85 @@
+//go:build linux
+// +build linux
+
+/******************************************************
+# DESC       : udp path mtu discovery on linux
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-18 11:20
+# FILE       : pmtu_linux.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+	"syscall"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+// set the DF bit on the datagrams of @conn, so the kernel tracks the path mtu
+// by the icmp "fragmentation needed"/"packet too big" messages.
+func setPathMTUDiscovery(conn *net.UDPConn, enable bool) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return jerrors.Trace(err)
+	}
+
+	var opErr error
+	err = rawConn.Control(func(fd uintptr) {
+		var domain int
+		if domain, opErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_DOMAIN); opErr != nil {
+			return
+		}
+		if domain == syscall.AF_INET6 {
+			mode := syscall.IPV6_PMTUDISC_DONT
+			if enable {
+				mode = syscall.IPV6_PMTUDISC_DO
+			}
+			opErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, mode)
+			return
+		}
+		mode := syscall.IP_PMTUDISC_DONT
+		if enable {
+			mode = syscall.IP_PMTUDISC_DO
+		}
+		opErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, mode)
+	})
+	if err == nil {
+		err = opErr
+	}
+
+	return jerrors.Trace(err)
+}
+
+// get the path mtu & the ip header length of a connected udp socket.
+func pathMTU(conn *net.UDPConn) (mtu int, ipHeaderLen int, err error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, jerrors.Trace(err)
+	}
+
+	var opErr error
+	err = rawConn.Control(func(fd uintptr) {
+		var domain int
+		if domain, opErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_DOMAIN); opErr != nil {
+			return
+		}
+		if domain == syscall.AF_INET6 {
+			ipHeaderLen = ipv6HeaderLen
+			mtu, opErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU)
+			return
+		}
+		ipHeaderLen = ipv4HeaderLen
+		mtu, opErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU)
+	})
+	if err == nil {
+		err = opErr
+	}
+
+	return mtu, ipHeaderLen, jerrors.Trace(err)
+}
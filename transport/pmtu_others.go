@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+/******************************************************
+# DESC       : udp path mtu discovery stub
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-18 11:20
+# FILE       : pmtu_others.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+)
+
+func setPathMTUDiscovery(conn *net.UDPConn, enable bool) error {
+	return ErrNotSupported
+}
+
+func pathMTU(conn *net.UDPConn) (int, int, error) {
+	return 0, 0, ErrNotSupported
+}
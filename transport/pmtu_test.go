@@ -0,0 +1,53 @@
+package getty
+
+import (
+	"net"
+	"runtime"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionMaxDatagramSize(t *testing.T) {
+	ss, _ := newPipeSessions(t)
+	assert.Equal(t, 0, ss.MaxDatagramSize())
+	assert.Equal(t, ErrNotSupported, ss.SetPathMTUDiscovery(true))
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer peer.Close()
+	clt := newClient(UDP_CLIENT, WithServerAddress(peer.LocalAddr().String()), WithConnectionNumber(1))
+
+	// non-connected udp session
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer conn.Close()
+	ss = newUDPSession(conn, clt).(*session)
+	assert.Equal(t, defaultMaxDatagramSize, ss.MaxDatagramSize())
+
+	// connected udp session
+	conn, err = net.DialUDP("udp", nil, peer.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer conn.Close()
+	ss = newUDPSession(conn, clt).(*session)
+	if runtime.GOOS != "linux" {
+		assert.Equal(t, ErrNotSupported, ss.SetPathMTUDiscovery(true))
+		assert.Equal(t, defaultMaxDatagramSize, ss.MaxDatagramSize())
+		return
+	}
+
+	assert.Nil(t, ss.SetPathMTUDiscovery(true))
+	size := ss.MaxDatagramSize()
+	// the mtu of loopback is much larger than the default size
+	assert.True(t, defaultMaxDatagramSize < size)
+	_, err = conn.Write(make([]byte, size+1))
+	assert.NotNil(t, err)
+	_, err = conn.Write(make([]byte, size))
+	assert.Nil(t, err)
+
+	ss.SetFragmentation(&FragmentConfig{})
+	assert.Equal(t, size-fragmentHeaderLen, ss.MaxDatagramSize())
+	assert.Equal(t, size, ss.Connection.(*gettyUDPConn).fragment.MTU)
+}
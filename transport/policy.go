@@ -0,0 +1,186 @@
+/******************************************************
+# DESC       : pluggable policy hooks of admission & qos
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-06 10:20
+# FILE       : policy.go
+******************************************************/
+
+package getty
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// PolicyVerdict is the verdict of a Policy on an action.
+type PolicyVerdict int
+
+const (
+	// the action goes on
+	PolicyAllow PolicyVerdict = iota
+	// the action is rejected
+	PolicyDeny
+	// the action goes on after PolicyDecision.Delay
+	PolicyLimit
+)
+
+func (v PolicyVerdict) String() string {
+	switch v {
+	case PolicyAllow:
+		return "allow"
+	case PolicyDeny:
+		return "deny"
+	case PolicyLimit:
+		return "limit"
+	}
+
+	return fmt.Sprintf("PolicyVerdict(%d)", int(v))
+}
+
+// PolicyStage is the stage at which a Policy is consulted.
+type PolicyStage int
+
+const (
+	// a tcp/ws/wss server accepts a connection, before its handshake
+	PolicyStageAccept PolicyStage = iota
+	// a session has completed its handshake, before NewSessionCallback
+	PolicyStageHandshake
+	// a pkg is written by (Session)WritePkg and its variants, e.g. the ones of SessionWriter
+	PolicyStageWrite
+
+	policyStageNum
+)
+
+func (s PolicyStage) String() string {
+	switch s {
+	case PolicyStageAccept:
+		return "accept"
+	case PolicyStageHandshake:
+		return "handshake"
+	case PolicyStageWrite:
+		return "write"
+	}
+
+	return fmt.Sprintf("PolicyStage(%d)", int(s))
+}
+
+// PolicyDecision is the decision of a Policy. Its zero value allows the action.
+type PolicyDecision struct {
+	Verdict PolicyVerdict
+	// the reason of a denial or a limit, which is logged and carried by the error of a denial
+	Reason string
+	// the delay of PolicyLimit. At the accept stage it pauses the accept goroutine of a tcp
+	// server or the request goroutine of a ws/wss server, i.e. it throttles the accept rate.
+	// At the handshake stage it postpones NewSessionCallback, and at the write stage it pauses
+	// the writer before the pkg is queued.
+	Delay time.Duration
+}
+
+// Policy centralizes the admission & qos rules of the endpoints, e.g. geo blocks or tenant
+// quotas. Its methods are invoked concurrently and should not block.
+type Policy interface {
+	// consulted when a tcp/ws/wss server accepts a connection from @remoteAddr
+	Accept(remoteAddr string) PolicyDecision
+	// consulted when @session completes its handshake. The negotiation result and the
+	// attributes of the session are available.
+	Handshake(session Session) PolicyDecision
+	// consulted when @pkg is written to @session
+	Write(session Session, pkg interface{}) PolicyDecision
+}
+
+// PolicyStats is the number of the actions denied & limited by the policy of an endpoint by stage.
+type PolicyStats struct {
+	AcceptDenied     uint64
+	AcceptLimited    uint64
+	HandshakeDenied  uint64
+	HandshakeLimited uint64
+	WriteDenied      uint64
+	WriteLimited     uint64
+}
+
+func (s PolicyStats) String() string {
+	return fmt.Sprintf("{accept denied:%d, accept limited:%d, handshake denied:%d, "+
+		"handshake limited:%d, write denied:%d, write limited:%d}",
+		s.AcceptDenied, s.AcceptLimited, s.HandshakeDenied, s.HandshakeLimited, s.WriteDenied, s.WriteLimited)
+}
+
+// apply the decision @d of @stage. It returns ErrPolicyDenied with the reason if the action is
+// denied, or waits for the delay if it is limited. @done aborts the wait.
+func (m *EndPointMetrics) applyPolicy(stage PolicyStage, d PolicyDecision, done <-chan struct{}) error {
+	switch d.Verdict {
+	case PolicyDeny:
+		atomic.AddUint64(&m.policyDenials[stage], 1)
+		if d.Reason == "" {
+			return ErrPolicyDenied
+		}
+		return newGettyError(ErrPolicyDenied, errors.New(d.Reason))
+
+	case PolicyLimit:
+		atomic.AddUint64(&m.policyLimits[stage], 1)
+		if d.Delay > 0 {
+			timer := time.NewTimer(d.Delay)
+			defer timer.Stop()
+			select {
+			case <-done:
+			case <-timer.C:
+			}
+		}
+	}
+
+	return nil
+}
+
+// consult the policy of the server on the connection from @remoteAddr
+func (s *server) admitConn(remoteAddr string) error {
+	if s.policy == nil {
+		return nil
+	}
+
+	err := s.metrics.applyPolicy(PolicyStageAccept, s.policy.Accept(remoteAddr), s.done)
+	if err != nil {
+		sampledWarn("server{%s} rejects remote addr %s by policy, error:%s", s.addr, remoteAddr, err)
+	}
+	return err
+}
+
+// consult the policy of the endpoint on the handshaked session
+func (s *session) admitHandshake(r *http.Request) error {
+	if err := s.identify(r); err != nil {
+		sampledWarn("%s, [session.admitHandshake] identification failed, error:%s", s.sessionToken(), err)
+		return err
+	}
+	if s.policy == nil {
+		return nil
+	}
+
+	err := s.metrics.applyPolicy(PolicyStageHandshake, s.policy.Handshake(s), s.done)
+	if err != nil {
+		sampledWarn("%s, [session.admitHandshake] rejected by policy, error:%s", s.sessionToken(), err)
+	}
+	return err
+}
+
+// consult the policy of the endpoint on the written pkg
+func (s *session) admitPolicyWrite(pkg interface{}) error {
+	if s.policy == nil {
+		return nil
+	}
+	switch pkg.(type) {
+	case *conflatedPkg, codecSwitchPkg:
+		// the pkgs of the users are checked before they are wrapped
+		return nil
+	}
+
+	if err := s.metrics.applyPolicy(PolicyStageWrite, s.policy.Write(s, pkg), s.done); err != nil {
+		return err
+	}
+	if s.IsClosed() {
+		return ErrSessionClosed
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package getty
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type testPolicy struct {
+	lock                     sync.Mutex
+	accept, handshake, write PolicyDecision
+}
+
+func (p *testPolicy) Accept(remoteAddr string) PolicyDecision {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.accept
+}
+
+func (p *testPolicy) Handshake(session Session) PolicyDecision {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.handshake
+}
+
+func (p *testPolicy) Write(session Session, pkg interface{}) PolicyDecision {
+	if s, ok := pkg.(string); ok && s == "forbidden" {
+		return p.write
+	}
+	return PolicyDecision{}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	m := newEndPointMetrics(0)
+	done := make(chan struct{})
+
+	assert.Nil(t, m.applyPolicy(PolicyStageAccept, PolicyDecision{}, done))
+	assert.Equal(t, ErrPolicyDenied, m.applyPolicy(PolicyStageAccept, PolicyDecision{Verdict: PolicyDeny}, done))
+	err := m.applyPolicy(PolicyStageWrite, PolicyDecision{Verdict: PolicyDeny, Reason: "geo block"}, done)
+	assert.True(t, errors.Is(err, ErrPolicyDenied))
+	assert.Equal(t, "denied by policy: geo block", err.Error())
+
+	close(done)
+	assert.Nil(t, m.applyPolicy(PolicyStageHandshake, PolicyDecision{Verdict: PolicyLimit, Delay: time.Hour}, done))
+	assert.Equal(t, PolicyStats{AcceptDenied: 1, HandshakeLimited: 1, WriteDenied: 1}, m.PolicyStats())
+}
+
+func TestPolicyWrite(t *testing.T) {
+	src, _ := newPipeSessions(t)
+	src.policy = &testPolicy{write: PolicyDecision{Verdict: PolicyDeny, Reason: "quota"}}
+	src.SetWQLen(1)
+
+	err := src.WritePkg("forbidden", time.Second)
+	assert.True(t, errors.Is(err, ErrPolicyDenied))
+	assert.Nil(t, src.WritePkg("allowed", time.Second))
+	assert.Equal(t, uint64(1), src.metrics.PolicyStats().WriteDenied)
+
+	// the variants are consulted with the pkgs of the users rather than the internal wrappers
+	err = src.WritePkgContext(context.Background(), "forbidden")
+	assert.True(t, errors.Is(err, ErrPolicyDenied))
+	err = src.WriteConflated("key", "forbidden", time.Second)
+	assert.True(t, errors.Is(err, ErrPolicyDenied))
+	err = src.WriteCodecSwitch("forbidden", CodecSwitch{}, time.Second)
+	assert.True(t, errors.Is(err, ErrPolicyDenied))
+	assert.Equal(t, uint64(4), src.metrics.PolicyStats().WriteDenied)
+	assert.Equal(t, 1, len(src.wQ))
+	assert.Empty(t, src.conflated)
+
+	<-src.wQ
+	assert.Nil(t, src.WriteConflated("key", "allowed", time.Second))
+	// the queued pkg of the key can not be replaced by a denied one
+	err = src.WriteConflated("key", "forbidden", time.Second)
+	assert.True(t, errors.Is(err, ErrPolicyDenied))
+	assert.Equal(t, "allowed", src.takeConflated((<-src.wQ).(*conflatedPkg)))
+}
+
+func TestClientPendingPolicyWrite(t *testing.T) {
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(&MessageHandler{})
+		return nil
+	})
+	defer server.Close()
+
+	policy := &testPolicy{write: PolicyDecision{Verdict: PolicyDeny}}
+	clt := newClient(TCP_CLIENT, WithServerAddress(server.streamListener.Addr().String()),
+		WithConnectionNumber(1), WithClientPolicy(policy))
+	defer clt.Close()
+
+	// the pending pkgs are consulted when they are flushed into the connected session
+	done := make(chan error, 1)
+	go func() {
+		done <- clt.WritePkgContext(context.Background(), "forbidden")
+	}()
+	for {
+		clt.pendingLock.Lock()
+		n := len(clt.pending)
+		clt.pendingLock.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clt.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(&MessageHandler{})
+		return nil
+	})
+	select {
+	case err := <-done:
+		assert.True(t, errors.Is(err, ErrPolicyDenied))
+	case <-time.After(3 * time.Second):
+		t.Fatal("the pending pkg is not flushed")
+	}
+}
+
+func TestTCPServerPolicy(t *testing.T) {
+	var (
+		msgHandler MessageHandler
+		sessions   int32
+		policy     = &testPolicy{accept: PolicyDecision{Verdict: PolicyDeny, Reason: "geo block"}}
+	)
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerPolicy(policy))
+	server.RunEventLoop(func(session Session) error {
+		atomic.AddInt32(&sessions, 1)
+		return newSessionCallback(session, &msgHandler)
+	})
+	defer server.Close()
+
+	// the denied connection is closed at once
+	dial := func() {
+		conn, err := net.Dial("tcp", server.streamListener.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		assert.NotNil(t, err)
+	}
+	dial()
+	assert.Equal(t, uint64(1), server.Metrics().PolicyStats().AcceptDenied)
+
+	policy.lock.Lock()
+	policy.accept = PolicyDecision{}
+	policy.handshake = PolicyDecision{Verdict: PolicyDeny, Reason: "tenant quota"}
+	policy.lock.Unlock()
+	dial()
+	assert.Equal(t, uint64(1), server.Metrics().PolicyStats().HandshakeDenied)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&sessions))
+}
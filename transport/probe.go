@@ -0,0 +1,186 @@
+/******************************************************
+# DESC       : end-to-end latency probing of session
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-28 11:05
+# FILE       : probe.go
+******************************************************/
+
+package getty
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+const (
+	probeRequest byte = 1
+	probeReply   byte = 2
+	// magic(8 bytes) | type(1 byte) | send time of the prober in unix nanoseconds(8 bytes)
+	probeFrameLen = 17
+	// the weight of the new sample of the smoothed latency, the same as the tcp srtt
+	probeSmoothWeight = 0.125
+)
+
+var (
+	probeMagic = []byte{0xff, 'G', 'P', 'R', 'O', 'B', 'E', 0x00}
+)
+
+// probeFrame is the raw probe frame in the write queue, which is not encoded by the codec.
+type probeFrame []byte
+
+func newProbeFrame(typ byte, sendTime int64) probeFrame {
+	b := make([]byte, probeFrameLen)
+	copy(b, probeMagic)
+	b[len(probeMagic)] = typ
+	binary.BigEndian.PutUint64(b[len(probeMagic)+1:], uint64(sendTime))
+	return b
+}
+
+// LatencyProbeConfig is the latency probing config of a session, which is set by
+// (SessionObserver)SetLatencyProbe. Both peers should enable it, because the probe frames are
+// carried in band with the codec messages. A probe frame starts with the 8 bytes magic
+// "\xffGPROBE\x00", so the codec messages should never start with it.
+type LatencyProbeConfig struct {
+	// the interval of the probe frames. 0 means the session does not probe its peer but
+	// only replies the probe frames of the peer.
+	Interval time.Duration
+}
+
+// LatencyProbeStats is the end-to-end latency measured by the probe frames of a session.
+// Unlike the heartbeat, the probe frames are handled by getty, so the latency includes the
+// network round trip and the queueing in both sessions, but not the OnMessage handlers.
+type LatencyProbeStats struct {
+	Samples uint64
+	Last    time.Duration
+	Min     time.Duration
+	Max     time.Duration
+	// exponentially weighted moving average of the samples
+	Smoothed time.Duration
+}
+
+func (s LatencyProbeStats) String() string {
+	return fmt.Sprintf("{samples:%d, last:%s, min:%s, max:%s, smoothed:%s}",
+		s.Samples, s.Last, s.Min, s.Max, s.Smoothed)
+}
+
+type latencyProber struct {
+	config LatencyProbeConfig
+
+	lock  sync.Mutex
+	stats LatencyProbeStats
+	// closed when the next probe reply is received
+	replied chan struct{}
+}
+
+func (p *latencyProber) record(rtt time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	st := &p.stats
+	if st.Samples == 0 || rtt < st.Min {
+		st.Min = rtt
+	}
+	if rtt > st.Max {
+		st.Max = rtt
+	}
+	if st.Samples == 0 {
+		st.Smoothed = rtt
+	} else {
+		st.Smoothed += time.Duration(probeSmoothWeight * float64(rtt-st.Smoothed))
+	}
+	st.Last = rtt
+	st.Samples++
+	if p.replied != nil {
+		close(p.replied)
+		p.replied = nil
+	}
+}
+
+// the channel closed when the next probe reply is received
+func (p *latencyProber) waitReply() <-chan struct{} {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.replied == nil {
+		p.replied = make(chan struct{})
+	}
+	return p.replied
+}
+
+func (p *latencyProber) getStats() LatencyProbeStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.stats
+}
+
+// SetLatencyProbe enables the latency probing of a tcp/websocket session, and nil @config
+// disables it. It should be invoked before the session runs, e.g. in NewSessionCallback.
+func (s *session) SetLatencyProbe(config *LatencyProbeConfig) {
+	if config == nil {
+		s.prober = nil
+		return
+	}
+
+	s.prober = &latencyProber{config: *config}
+}
+
+// LatencyProbe returns the latency measured by the probe frames of the session. The latency
+// of all sessions of an endpoint is summarized by (EndPointMetrics)ProbeLatency.
+func (s *session) LatencyProbe() LatencyProbeStats {
+	if p := s.prober; p != nil {
+		return p.getStats()
+	}
+
+	return LatencyProbeStats{}
+}
+
+// send a probe request to the peer
+func (s *session) sendProbe() {
+	if !s.offerPkg(newProbeFrame(probeRequest, time.Now().UnixNano())) {
+		log.Debug("%s, [session.sendProbe] write queue is full", s.sessionToken())
+	}
+}
+
+// handle the probe frame at the head of @data. It returns the length of the probe frame,
+// 0 if @data does not start with a probe frame, or -1 if the probe frame is incomplete.
+func (s *session) handleProbe(data []byte) int {
+	p := s.prober
+	if p == nil {
+		return 0
+	}
+	if len(data) < probeFrameLen {
+		if len(data) < len(probeMagic) && bytes.HasPrefix(probeMagic, data) ||
+			bytes.HasPrefix(data, probeMagic) {
+			return -1
+		}
+		return 0
+	}
+	if !bytes.HasPrefix(data, probeMagic) {
+		return 0
+	}
+
+	typ := data[len(probeMagic)]
+	sendTime := int64(binary.BigEndian.Uint64(data[len(probeMagic)+1:]))
+	switch typ {
+	case probeRequest:
+		if !s.offerPkg(newProbeFrame(probeReply, sendTime)) {
+			log.Debug("%s, [session.handleProbe] write queue is full", s.sessionToken())
+		}
+	case probeReply:
+		rtt := time.Duration(time.Now().UnixNano() - sendTime)
+		if rtt >= 0 {
+			p.record(rtt)
+			s.metrics.ProbeLatency.Record(rtt)
+		}
+	}
+
+	return probeFrameLen
+}
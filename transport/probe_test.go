@@ -0,0 +1,74 @@
+package getty
+
+import (
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyProber(t *testing.T) {
+	var p latencyProber
+	p.record(8 * time.Millisecond)
+	p.record(16 * time.Millisecond)
+	p.record(4 * time.Millisecond)
+
+	st := p.getStats()
+	assert.Equal(t, uint64(3), st.Samples)
+	assert.Equal(t, 4*time.Millisecond, st.Last)
+	assert.Equal(t, 4*time.Millisecond, st.Min)
+	assert.Equal(t, 16*time.Millisecond, st.Max)
+	assert.Equal(t, 8375*time.Microsecond, st.Smoothed)
+}
+
+func TestHandleProbe(t *testing.T) {
+	ss, _ := newPipeSessions(t)
+	ss.SetWQLen(4)
+	frame := newProbeFrame(probeReply, time.Now().Add(-time.Millisecond).UnixNano())
+
+	// disabled
+	assert.Equal(t, 0, ss.handleProbe(frame))
+
+	ss.SetLatencyProbe(&LatencyProbeConfig{})
+	assert.Equal(t, 0, ss.handleProbe([]byte("hello\n")))
+	assert.Equal(t, -1, ss.handleProbe(frame[:3]))
+	assert.Equal(t, -1, ss.handleProbe(frame[:10]))
+	assert.Equal(t, probeFrameLen, ss.handleProbe(append(frame, "hello\n"...)))
+	assert.Equal(t, uint64(1), ss.LatencyProbe().Samples)
+	assert.True(t, ss.LatencyProbe().Last >= time.Millisecond)
+
+	// the request is replied
+	assert.Equal(t, probeFrameLen, ss.handleProbe(newProbeFrame(probeRequest, 1)))
+	assert.Equal(t, 1, len(ss.wQ))
+
+	ss.SetLatencyProbe(nil)
+	assert.Equal(t, LatencyProbeStats{}, ss.LatencyProbe())
+}
+
+func TestSessionLatencyProbe(t *testing.T) {
+	listener := &lineListener{msgs: make(chan interface{}, 64)}
+
+	src, dst := newPipeSessions(t)
+	src.SetLatencyProbe(&LatencyProbeConfig{Interval: 10 * time.Millisecond})
+	dst.SetLatencyProbe(&LatencyProbeConfig{})
+	for _, ss := range []*session{src, dst} {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(listener)
+		ss.run()
+		defer ss.Close()
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for src.LatencyProbe().Samples < 3 && time.Now().Before(deadline) {
+		assert.Nil(t, src.WritePkg("hello", 0))
+		assert.Equal(t, "hello", <-listener.msgs)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.True(t, src.LatencyProbe().Samples >= 3)
+	assert.True(t, src.metrics.ProbeLatency.Count() >= 3)
+	// the passive peer only replies
+	assert.Equal(t, uint64(0), dst.LatencyProbe().Samples)
+}
@@ -0,0 +1,270 @@
+/******************************************************
+# DESC       : quic stream transport
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-22 15:20
+# FILE       : quic.go
+******************************************************/
+
+package getty
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+// The sessions over quic run like the ones of the custom transports, i.e. every session
+// is a bidirectional stream of a quic connection, which is adapted by NewTransportConn.
+// So the sessions of a client share one quic connection without head of line blocking,
+// and the stream wrappers, the compressions, the counters & the deadlines work as the
+// ones of tcp. getty does not depend on any quic implementation, which is adapted to
+// QUICConnection & QUICListener, e.g. by quic-go:
+//
+//	type quicConn struct {
+//		quic.Connection
+//	}
+//
+//	func (c quicConn) OpenStream(ctx context.Context) (io.ReadWriteCloser, error) {
+//		s, err := c.Connection.OpenStreamSync(ctx)
+//		return quicStream{s}, err
+//	}
+//
+//	func (c quicConn) AcceptStream(ctx context.Context) (io.ReadWriteCloser, error) {
+//		s, err := c.Connection.AcceptStream(ctx)
+//		return quicStream{s}, err
+//	}
+//
+//	func (c quicConn) Close() error {
+//		return c.CloseWithError(0, "")
+//	}
+//
+//	// the Close of a quic-go stream only closes its send side
+//	type quicStream struct {
+//		quic.Stream
+//	}
+//
+//	func (s quicStream) Close() error {
+//		s.CancelRead(0)
+//		return s.Stream.Close()
+//	}
+
+// QUICConnection is a quic connection. The returned streams should support the deadlines,
+// and their Close should close both sides.
+type QUICConnection interface {
+	OpenStream(ctx context.Context) (io.ReadWriteCloser, error)
+	AcceptStream(ctx context.Context) (io.ReadWriteCloser, error)
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+// QUICConnect connects the quic server @addr within @ctx, e.g. by the 0-RTT of quic-go:
+//
+//	func(ctx context.Context, addr string) (getty.QUICConnection, error) {
+//		conn, err := quic.DialAddrEarly(ctx, addr, tlsConfig, &quic.Config{KeepAlivePeriod: 15 * time.Second})
+//		if err != nil {
+//			return nil, err
+//		}
+//		return quicConn{conn}, nil
+//	}
+type QUICConnect func(ctx context.Context, addr string) (QUICConnection, error)
+
+// QUICDialer opens the streams of a client over one quic connection per server address.
+// The connection is rebuilt at the next Dial if a stream fails to be opened.
+type QUICDialer struct {
+	connect QUICConnect
+	timeout time.Duration
+
+	lock  sync.Mutex
+	conns map[string]QUICConnection
+}
+
+// NewQUICDialer builds the quic dialer which connects by @connect within @timeout, e.g.
+//
+//	dialer := getty.NewQUICDialer(3*time.Second, connect)
+//	client := getty.NewTransportClient(dialer.Dial, getty.WithServerAddress("10.0.0.8:10000"),
+//		getty.WithConnectionNumber(4))
+//
+// Pls close the dialer after the client is closed, for the quic connections are not closed
+// by the client.
+func NewQUICDialer(timeout time.Duration, connect QUICConnect) *QUICDialer {
+	if connect == nil {
+		panic("NewQUICDialer(connect):@connect is nil")
+	}
+	if timeout <= 0 {
+		timeout = connectTimeout
+	}
+
+	return &QUICDialer{connect: connect, timeout: timeout, conns: make(map[string]QUICConnection)}
+}
+
+// Dial opens a stream to @addr, which is a TransportDialer.
+func (d *QUICDialer) Dial(addr string) (net.Conn, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	conn := d.conns[addr]
+	if conn == nil {
+		var err error
+		if conn, err = d.connect(ctx, addr); err != nil {
+			return nil, jerrors.Annotatef(err, "quic connect(addr:%s)", addr)
+		}
+		d.conns[addr] = conn
+	}
+
+	stream, err := conn.OpenStream(ctx)
+	if err != nil {
+		// the connection may be broken, e.g. the server restarts
+		conn.Close()
+		delete(d.conns, addr)
+		return nil, jerrors.Annotatef(err, "quic open stream(addr:%s)", addr)
+	}
+
+	return NewTransportConn("quic", conn.LocalAddr().String(), addr, stream), nil
+}
+
+// Close closes the quic connections, and so the streams over them.
+func (d *QUICDialer) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for addr, conn := range d.conns {
+		conn.Close()
+		delete(d.conns, addr)
+	}
+	return nil
+}
+
+// QUICListener is a quic listener, e.g. adapted from the *quic.Listener of quic-go.
+type QUICListener interface {
+	Accept(ctx context.Context) (QUICConnection, error)
+	Addr() net.Addr
+	Close() error
+}
+
+type quicStreamListener struct {
+	listener QUICListener
+	ctx      context.Context
+	cancel   context.CancelFunc
+	streams  chan net.Conn
+
+	lock  sync.Mutex
+	conns map[QUICConnection]struct{}
+	once  sync.Once
+	done  chan struct{}
+	err   error
+}
+
+// NewQUICStreamListener adapts @listener to the listener of the streams of its connections,
+// which is served by NewTransportServer, e.g.
+//
+//	server := getty.NewTransportServer(getty.NewQUICStreamListener(quicListener{l}))
+//
+// The connections and @listener are closed when the returned listener is closed.
+func NewQUICStreamListener(listener QUICListener) net.Listener {
+	if listener == nil {
+		panic("NewQUICStreamListener(listener):@listener is nil")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &quicStreamListener{
+		listener: listener,
+		ctx:      ctx,
+		cancel:   cancel,
+		streams:  make(chan net.Conn),
+		conns:    make(map[QUICConnection]struct{}),
+		done:     make(chan struct{}),
+	}
+	go l.acceptConns()
+
+	return l
+}
+
+func (l *quicStreamListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.streams:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+func (l *quicStreamListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *quicStreamListener) Close() error {
+	l.stop(net.ErrClosed)
+	return nil
+}
+
+// stop the listener with @err, which is returned by the following Accept
+func (l *quicStreamListener) stop(err error) {
+	l.once.Do(func() {
+		l.err = err
+		close(l.done)
+		l.cancel()
+		l.listener.Close()
+
+		l.lock.Lock()
+		for conn := range l.conns {
+			conn.Close()
+		}
+		l.conns = nil
+		l.lock.Unlock()
+	})
+}
+
+func (l *quicStreamListener) acceptConns() {
+	for {
+		conn, err := l.listener.Accept(l.ctx)
+		if err != nil {
+			l.stop(jerrors.Annotate(err, "quic accept"))
+			return
+		}
+
+		l.lock.Lock()
+		if l.conns == nil {
+			l.lock.Unlock()
+			conn.Close()
+			return
+		}
+		l.conns[conn] = struct{}{}
+		l.lock.Unlock()
+		go l.acceptStreams(conn)
+	}
+}
+
+func (l *quicStreamListener) acceptStreams(conn QUICConnection) {
+	defer func() {
+		conn.Close()
+		l.lock.Lock()
+		delete(l.conns, conn)
+		l.lock.Unlock()
+	}()
+
+	for {
+		stream, err := conn.AcceptStream(l.ctx)
+		if err != nil {
+			return
+		}
+
+		select {
+		case l.streams <- NewTransportConn("quic", conn.LocalAddr().String(), conn.RemoteAddr().String(), stream):
+		case <-l.done:
+			stream.Close()
+			return
+		}
+	}
+}
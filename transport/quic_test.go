@@ -0,0 +1,156 @@
+package getty
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeQUICConn opens the streams by net.Pipe, whose peer ends are accepted by its peer
+type fakeQUICConn struct {
+	local, remote net.Addr
+	peer          *fakeQUICConn
+	accepted      chan io.ReadWriteCloser
+	closeOnce     sync.Once
+	closed        chan struct{}
+}
+
+func newFakeQUICConns() (*fakeQUICConn, *fakeQUICConn) {
+	c1 := &fakeQUICConn{
+		local:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40001},
+		remote:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 443},
+		accepted: make(chan io.ReadWriteCloser, 8),
+		closed:   make(chan struct{}),
+	}
+	c2 := &fakeQUICConn{
+		local:    c1.remote,
+		remote:   c1.local,
+		accepted: make(chan io.ReadWriteCloser, 8),
+		closed:   make(chan struct{}),
+	}
+	c1.peer, c2.peer = c2, c1
+	return c1, c2
+}
+
+func (c *fakeQUICConn) OpenStream(ctx context.Context) (io.ReadWriteCloser, error) {
+	s1, s2 := net.Pipe()
+	select {
+	case c.peer.accepted <- s2:
+		return s1, nil
+	case <-c.closed:
+		return nil, errors.New("connection closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *fakeQUICConn) AcceptStream(ctx context.Context) (io.ReadWriteCloser, error) {
+	select {
+	case s := <-c.accepted:
+		return s, nil
+	case <-c.closed:
+		return nil, errors.New("connection closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *fakeQUICConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *fakeQUICConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *fakeQUICConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+type fakeQUICListener struct {
+	conns  chan QUICConnection
+	closed chan struct{}
+}
+
+func (l *fakeQUICListener) Accept(ctx context.Context) (QUICConnection, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *fakeQUICListener) Addr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 443}
+}
+
+func (l *fakeQUICListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func TestQUICTransport(t *testing.T) {
+	quicListener := &fakeQUICListener{conns: make(chan QUICConnection, 4), closed: make(chan struct{})}
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+	server := NewTransportServer(NewQUICStreamListener(quicListener)).(*server)
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(listener)
+		return nil
+	})
+
+	var (
+		lock     sync.Mutex
+		connects int
+	)
+	dialer := NewQUICDialer(time.Second, func(ctx context.Context, addr string) (QUICConnection, error) {
+		lock.Lock()
+		connects++
+		lock.Unlock()
+		c1, c2 := newFakeQUICConns()
+		quicListener.conns <- c2
+		return c1, nil
+	})
+	client := NewTransportClient(dialer.Dial, WithServerAddress("127.0.0.1:443"),
+		WithConnectionNumber(2)).(*client)
+	client.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(&MessageHandler{})
+		return nil
+	})
+	assert.Equal(t, 2, listener.SessionNumber())
+
+	// the sessions share one quic connection
+	lock.Lock()
+	assert.Equal(t, 1, connects)
+	lock.Unlock()
+	ss := client.connectedSession()
+	assert.NotNil(t, ss)
+	assert.Equal(t, "quic", ss.Conn().LocalAddr().Network())
+	assert.Nil(t, ss.WritePkg("hello", 0))
+	select {
+	case msg := <-listener.msgs:
+		assert.Equal(t, "hello", msg)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message")
+	}
+
+	client.Close()
+	dialer.Close()
+	server.Close()
+	select {
+	case <-quicListener.closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the quic listener is not closed")
+	}
+}
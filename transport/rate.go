@@ -0,0 +1,148 @@
+/******************************************************
+# DESC       : session rolling rates
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-14 11:10
+# FILE       : rate.go
+******************************************************/
+
+package getty
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// the longest rolling window is 1 minute
+	rateSampleNum = 61
+)
+
+// TrafficRate is the traffic rate of a session in a rolling window.
+type TrafficRate struct {
+	// bytes per second
+	ReadBytes  float64
+	WriteBytes float64
+	// packets per second
+	ReadPkgs  float64
+	WritePkgs float64
+}
+
+func (r TrafficRate) String() string {
+	return fmt.Sprintf("{read bytes:%.1f/s, write bytes:%.1f/s, read pkgs:%.1f/s, write pkgs:%.1f/s}",
+		r.ReadBytes, r.WriteBytes, r.ReadPkgs, r.WritePkgs)
+}
+
+// SessionRates is the rolling rates of a session. They are computed from the per second
+// samples of the session statistic counters, so they are approximate in a second.
+type SessionRates struct {
+	Rate1s  TrafficRate
+	Rate10s TrafficRate
+	Rate1m  TrafficRate
+}
+
+func (r SessionRates) String() string {
+	return fmt.Sprintf("{1s:%s, 10s:%s, 1m:%s}", r.Rate1s, r.Rate10s, r.Rate1m)
+}
+
+// the values of gettyConn{readBytes, writeBytes, readPkgNum, writePkgNum}
+type rateCounters [4]uint32
+
+type rateSample struct {
+	sec    int64
+	counts rateCounters
+}
+
+// rateMeter samples the statistic counters of a gettyConn once per second, and it is
+// driven by the session read/write loops. A second without any sample means the counters
+// do not change in it, so the gap will be filled by the previous sample.
+type rateMeter struct {
+	conn *gettyConn
+	// unix second of the latest sample
+	last int64
+
+	lock    sync.Mutex
+	start   int64
+	samples [rateSampleNum]rateSample
+}
+
+func newRateMeter(conn *gettyConn) *rateMeter {
+	now := time.Now().Unix()
+	m := &rateMeter{conn: conn, last: now, start: now}
+	m.samples[now%rateSampleNum] = rateSample{sec: now, counts: m.counts()}
+	return m
+}
+
+func (m *rateMeter) counts() rateCounters {
+	return rateCounters{
+		atomic.LoadUint32(&m.conn.readBytes),
+		atomic.LoadUint32(&m.conn.writeBytes),
+		atomic.LoadUint32(&m.conn.readPkgNum),
+		atomic.LoadUint32(&m.conn.writePkgNum),
+	}
+}
+
+// sample the counters if no sample has been taken in this second.
+func (m *rateMeter) tick() {
+	if now := time.Now().Unix(); now != atomic.LoadInt64(&m.last) {
+		m.lock.Lock()
+		m.sample(now)
+		m.lock.Unlock()
+	}
+}
+
+func (m *rateMeter) sample(now int64) {
+	last := atomic.LoadInt64(&m.last)
+	if now <= last {
+		return
+	}
+
+	prev := m.samples[last%rateSampleNum].counts
+	sec := last + 1
+	if sec < now-rateSampleNum {
+		sec = now - rateSampleNum
+	}
+	for ; sec < now; sec++ {
+		m.samples[sec%rateSampleNum] = rateSample{sec: sec, counts: prev}
+	}
+	m.samples[now%rateSampleNum] = rateSample{sec: now, counts: m.counts()}
+	atomic.StoreInt64(&m.last, now)
+}
+
+// the rate in the last @window seconds
+func (m *rateMeter) rate(now int64, counts rateCounters, window int64) TrafficRate {
+	sec := now - window
+	if sec < m.start {
+		sec = m.start
+	}
+	base := m.samples[sec%rateSampleNum]
+	elapsed := float64(now - base.sec)
+	if elapsed < 1 {
+		elapsed = 1
+	}
+
+	// the counters may wrap, so do subtraction on uint32
+	return TrafficRate{
+		ReadBytes:  float64(counts[0]-base.counts[0]) / elapsed,
+		WriteBytes: float64(counts[1]-base.counts[1]) / elapsed,
+		ReadPkgs:   float64(counts[2]-base.counts[2]) / elapsed,
+		WritePkgs:  float64(counts[3]-base.counts[3]) / elapsed,
+	}
+}
+
+func (m *rateMeter) rates() SessionRates {
+	now := time.Now().Unix()
+	counts := m.counts()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.sample(now)
+	return SessionRates{
+		Rate1s:  m.rate(now, counts, 1),
+		Rate10s: m.rate(now, counts, 10),
+		Rate1m:  m.rate(now, counts, 60),
+	}
+}
@@ -0,0 +1,56 @@
+package getty
+
+import (
+	"math"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateMeter(t *testing.T) {
+	var conn gettyConn
+
+	const start = 1000
+	m := &rateMeter{conn: &conn, last: start, start: start}
+	m.samples[start%rateSampleNum] = rateSample{sec: start}
+
+	// 100 bytes & 1 pkg per second in the first 30 seconds, then quiet
+	for sec := int64(start + 1); sec <= start+30; sec++ {
+		conn.readBytes += 100
+		conn.readPkgNum++
+		m.sample(sec)
+	}
+	rate := m.rate(start+30, m.counts(), 10)
+	assert.Equal(t, float64(100), rate.ReadBytes)
+	assert.Equal(t, float64(1), rate.ReadPkgs)
+	assert.Equal(t, float64(0), rate.WriteBytes)
+	// the session is younger than 1 minute
+	rate = m.rate(start+30, m.counts(), 60)
+	assert.Equal(t, float64(100), rate.ReadBytes)
+
+	// the gap is filled by the previous sample
+	m.sample(start + 50)
+	assert.Equal(t, float64(0), m.rate(start+50, m.counts(), 10).ReadBytes)
+	assert.Equal(t, float64(60), m.rate(start+50, m.counts(), 60).ReadBytes)
+
+	// a long gap
+	m.sample(start + 500)
+	assert.Equal(t, float64(0), m.rate(start+500, m.counts(), 60).ReadBytes)
+
+	// the counters wrap
+	conn.writeBytes = math.MaxUint32 - 9
+	m.sample(start + 501)
+	conn.writeBytes += 20
+	assert.Equal(t, float64(20), m.rate(start+502, m.counts(), 1).WriteBytes)
+}
+
+func TestSessionRates(t *testing.T) {
+	ss, _ := newPipeSessions(t)
+	ss.incWritePkgNum()
+	ss.incWritePkgNum()
+	rates := ss.Rates()
+	assert.True(t, 0 < rates.Rate1s.WritePkgs)
+	assert.Equal(t, float64(0), rates.Rate1m.ReadPkgs)
+}
@@ -0,0 +1,308 @@
+/******************************************************
+# DESC       : raw ip endpoint
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-23 10:05
+# FILE       : rawip.go
+******************************************************/
+
+package getty
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	icmpHeaderLen      = 8
+	icmpv4EchoRequest  = 8
+	icmpv4EchoReply    = 0
+	defaultRawIPBufLen = 64 * 1024
+)
+
+var (
+	errIllegalICMPEcho = jerrors.New("illegal icmp echo message")
+)
+
+// IPContext is the package of a raw ip session. The package received by the session
+// is delivered to (EventListener)OnMessage as IPContext, and the package written by
+// (Session)WritePkg should be IPContext or *IPContext.
+type IPContext struct {
+	Pkg      interface{}
+	PeerAddr *net.IPAddr
+}
+
+func (c IPContext) String() string {
+	return fmt.Sprintf("{pkg:%#v, peer addr:%s}", c.Pkg, c.PeerAddr)
+}
+
+/////////////////////////////////////////
+// getty raw ip connection
+/////////////////////////////////////////
+
+type gettyIPConn struct {
+	gettyConn
+	conn *net.IPConn
+}
+
+// create gettyIPConn
+func newGettyIPConn(conn *net.IPConn) *gettyIPConn {
+	if conn == nil {
+		panic("newGettyIPConn(conn):@conn is nil")
+	}
+
+	var localAddr string
+	if conn.LocalAddr() != nil {
+		localAddr = conn.LocalAddr().String()
+	}
+
+	return &gettyIPConn{
+		conn: conn,
+		gettyConn: gettyConn{
+			id:       atomic.AddUint32(&connID, 1),
+			rTimeout: netIOTimeout,
+			wTimeout: netIOTimeout,
+			local:    localAddr,
+			compress: CompressNone,
+			clock:    getActivityClock(),
+		},
+	}
+}
+
+// the payload of a raw ip session is not compressed
+func (i *gettyIPConn) SetCompressType(c CompressType) {}
+
+// raw ip connection read. The ipv4 header is stripped.
+func (i *gettyIPConn) recv(p []byte) (int, *net.IPAddr, error) {
+	if _, err := i.refreshReadDeadline(i.conn.SetReadDeadline); err != nil {
+		return 0, nil, err
+	}
+
+	length, addr, err := i.conn.ReadFromIP(p)
+	log.Debug("ReadFromIP() = {length:%d, peerAddr:%s, error:%s}", length, addr, err)
+	if err == nil {
+		atomic.AddUint32(&i.readBytes, uint32(length))
+	}
+
+	return length, addr, jerrors.Trace(err)
+}
+
+// write raw ip packet, @ipCtx should be of type IPContext
+func (i *gettyIPConn) send(ipCtx interface{}) (int, error) {
+	ctx, ok := ipCtx.(IPContext)
+	if !ok {
+		return 0, jerrors.Errorf("illegal @ipCtx{%s} type, @ipCtx type:%T", ipCtx, ipCtx)
+	}
+	buf, ok := ctx.Pkg.([]byte)
+	if !ok {
+		return 0, jerrors.Errorf("illegal @ipCtx.Pkg{%#v} type", ipCtx)
+	}
+	if ctx.PeerAddr == nil {
+		return 0, ErrNullPeerAddr
+	}
+
+	if _, err := i.refreshWriteDeadline(i.conn.SetWriteDeadline); err != nil {
+		return 0, err
+	}
+
+	length, err := i.conn.WriteToIP(buf, ctx.PeerAddr)
+	if err == nil {
+		atomic.AddUint32(&i.writeBytes, uint32(length))
+	}
+	log.Debug("WriteToIP(peerAddr:%s) = {length:%d, error:%s}", ctx.PeerAddr, length, err)
+
+	return length, jerrors.Trace(err)
+}
+
+// close raw ip connection
+func (i *gettyIPConn) close(_ int) {
+	if i.conn != nil && i.markClosed() {
+		i.conn.Close()
+	}
+}
+
+/////////////////////////////////////////
+// raw ip session
+/////////////////////////////////////////
+
+func newRawIPSession(conn *net.IPConn, endPoint EndPoint) Session {
+	c := newGettyIPConn(conn)
+	session := newSession(endPoint, c)
+	session.name = defaultRawIPSessionName
+
+	return session
+}
+
+// get package from raw ip socket
+func (s *session) handleIPPackage() error {
+	var (
+		ok       bool
+		err      error
+		netError net.Error
+		conn     *gettyIPConn
+		bufLen   int
+		buf      []byte
+		addr     *net.IPAddr
+		pkgLen   int
+		pkg      interface{}
+		readTime time.Time
+	)
+
+	conn = s.Connection.(*gettyIPConn)
+	buf = make([]byte, defaultRawIPBufLen)
+	for {
+		if s.IsClosed() {
+			break
+		}
+
+		bufLen, addr, err = conn.recv(buf)
+		if netError, ok = jerrors.Cause(err).(net.Error); ok && netError.Timeout() {
+			continue
+		}
+		if err != nil {
+			log.Error("%s, [session.handleIPPackage] = len{%d}, error{%s}",
+				s.sessionToken(), bufLen, jerrors.ErrorStack(err))
+			err = jerrors.Annotatef(err, "conn.read()")
+			break
+		}
+		if bufLen == 0 {
+			continue
+		}
+		readTime = s.sampleTime()
+		s.stampRecv()
+
+		pkg, pkgLen, err = s.getReader().Read(s, buf[:bufLen])
+		if err == nil && s.maxMsgLen > 0 && bufLen > int(s.maxMsgLen) {
+			err = newGettyError(ErrMsgTooLarge,
+				jerrors.Errorf("Message Too Long, bufLen %d, session max message len %d", bufLen, s.maxMsgLen))
+		}
+		if err != nil {
+			log.Warn("%s, [session.handleIPPackage] = len{%d}, error{%s}",
+				s.sessionToken(), pkgLen, jerrors.ErrorStack(err))
+			s.notifyError(err, ErrorDirectionRead)
+			err = nil
+			continue
+		}
+		if pkgLen == 0 {
+			continue
+		}
+
+		s.UpdateActive()
+		s.addTask(IPContext{Pkg: pkg, PeerAddr: addr}, readTime)
+	}
+
+	return traceError(err)
+}
+
+/////////////////////////////////////////
+// raw ip endpoint
+/////////////////////////////////////////
+
+// NewRawIPEndPoint builds a raw ip endpoint of @network, such as "ip4:icmp" or "ip4:89".
+// The local address set by WithLocalAddress is an ip address without port. It needs the
+// privilege to open raw sockets, e.g. root or CAP_NET_RAW on linux.
+func NewRawIPEndPoint(network string, opts ...ServerOption) Server {
+	s := newServer(RAW_IP_ENDPOINT, opts...)
+	s.rawNetwork = network
+
+	return s
+}
+
+func (s *server) listenRawIP() error {
+	localAddr, err := net.ResolveIPAddr(s.rawNetwork, s.addr)
+	if err != nil {
+		return jerrors.Annotatef(err, "net.ResolveIPAddr(%s, addr:%s)", s.rawNetwork, s.addr)
+	}
+	pktListener, err := net.ListenIP(s.rawNetwork, localAddr)
+	if err != nil {
+		return jerrors.Annotatef(err, "net.ListenIP((%s, localAddr:%#v)", s.rawNetwork, localAddr)
+	}
+
+	s.pktListener = pktListener
+
+	return nil
+}
+
+func (s *server) runRawIPEventLoop(newSession NewSessionCallback) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		conn := s.pktListener.(*net.IPConn)
+		ss := newRawIPSession(conn, s)
+		if err := newSession(ss); err != nil {
+			conn.Close()
+			panic(err.Error())
+		}
+		ss.(*session).run()
+	}()
+}
+
+/////////////////////////////////////////
+// icmp echo
+/////////////////////////////////////////
+
+// ICMPEcho is an icmpv4 echo request or reply message.
+type ICMPEcho struct {
+	Reply bool
+	ID    uint16
+	Seq   uint16
+	Data  []byte
+}
+
+// Marshal encodes the echo message with its checksum.
+func (e ICMPEcho) Marshal() []byte {
+	b := make([]byte, icmpHeaderLen+len(e.Data))
+	b[0] = icmpv4EchoRequest
+	if e.Reply {
+		b[0] = icmpv4EchoReply
+	}
+	binary.BigEndian.PutUint16(b[4:], e.ID)
+	binary.BigEndian.PutUint16(b[6:], e.Seq)
+	copy(b[icmpHeaderLen:], e.Data)
+	binary.BigEndian.PutUint16(b[2:], icmpChecksum(b))
+
+	return b
+}
+
+// ParseICMPEcho decodes the icmpv4 echo request or reply message @b.
+func ParseICMPEcho(b []byte) (*ICMPEcho, error) {
+	if len(b) < icmpHeaderLen || (b[0] != icmpv4EchoRequest && b[0] != icmpv4EchoReply) || b[1] != 0 {
+		return nil, errIllegalICMPEcho
+	}
+	if icmpChecksum(b) != 0 {
+		return nil, jerrors.Annotate(errIllegalICMPEcho, "checksum mismatch")
+	}
+
+	return &ICMPEcho{
+		Reply: b[0] == icmpv4EchoReply,
+		ID:    binary.BigEndian.Uint16(b[4:]),
+		Seq:   binary.BigEndian.Uint16(b[6:]),
+		Data:  append([]byte(nil), b[icmpHeaderLen:]...),
+	}, nil
+}
+
+// RFC 1071 internet checksum
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,107 @@
+package getty
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type rawIPPkgHandler struct{}
+
+func (h *rawIPPkgHandler) Read(ss Session, data []byte) (interface{}, int, error) {
+	echo, err := ParseICMPEcho(data)
+	if err != nil {
+		// ignore other icmp messages
+		return nil, len(data), nil
+	}
+	return echo, len(data), nil
+}
+
+func (h *rawIPPkgHandler) Write(ss Session, pkg interface{}) ([]byte, error) {
+	return pkg.(IPContext).Pkg.(*ICMPEcho).Marshal(), nil
+}
+
+type rawIPListener struct {
+	MessageHandler
+	lock    sync.Mutex
+	replies []*ICMPEcho
+}
+
+func (l *rawIPListener) OnMessage(session Session, pkg interface{}) {
+	ctx := pkg.(IPContext)
+	if echo, ok := ctx.Pkg.(*ICMPEcho); ok && echo.Reply {
+		l.lock.Lock()
+		l.replies = append(l.replies, echo)
+		l.lock.Unlock()
+	}
+}
+
+func TestICMPEcho(t *testing.T) {
+	echo := ICMPEcho{ID: 0x1234, Seq: 7, Data: []byte("hello")}
+	b := echo.Marshal()
+	assert.Equal(t, uint16(0), icmpChecksum(b))
+	got, err := ParseICMPEcho(b)
+	assert.Nil(t, err)
+	assert.Equal(t, echo, *got)
+
+	b[len(b)-1]++
+	_, err = ParseICMPEcho(b)
+	assert.NotNil(t, err)
+	_, err = ParseICMPEcho([]byte{3, 1, 0, 0})
+	assert.Equal(t, errIllegalICMPEcho, err)
+}
+
+func TestRawIPEndPointPing(t *testing.T) {
+	conn, err := net.ListenIP("ip4:icmp", &net.IPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("raw socket is not permitted: %v", err)
+	}
+	conn.Close()
+
+	server := NewRawIPEndPoint("ip4:icmp", WithLocalAddress("127.0.0.1"))
+	assert.Equal(t, RAW_IP_ENDPOINT, server.EndPointType())
+	listener := &rawIPListener{}
+	go server.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&rawIPPkgHandler{})
+		session.SetEventListener(listener)
+		session.SetRQLen(4)
+		session.SetWQLen(4)
+		session.SetReadTimeout(time.Second)
+		return listener.OnOpen(session)
+	})
+	defer server.Close()
+	for listener.SessionNumber() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ss := listener.array[0]
+	assert.Equal(t, "raw-ip-session", ss.(*session).name)
+	err = ss.WritePkg(IPContext{
+		Pkg:      &ICMPEcho{ID: 0x4321, Seq: 1, Data: []byte("getty")},
+		PeerAddr: &net.IPAddr{IP: net.IPv4(127, 0, 0, 1)},
+	}, time.Second)
+	assert.Nil(t, err)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		listener.lock.Lock()
+		n := len(listener.replies)
+		listener.lock.Unlock()
+		if n > 0 || deadline.Before(time.Now()) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	listener.lock.Lock()
+	defer listener.lock.Unlock()
+	assert.Equal(t, 1, len(listener.replies))
+	if len(listener.replies) > 0 {
+		assert.Equal(t, uint16(1), listener.replies[0].Seq)
+		assert.Equal(t, []byte("getty"), listener.replies[0].Data)
+	}
+}
@@ -0,0 +1,80 @@
+/******************************************************
+# DESC       : read loop knobs of latency vs throughput
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-02 10:30
+# FILE       : readloop.go
+******************************************************/
+
+package getty
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+var errWouldBlock = jerrors.New("read would block")
+
+// ReadLoopConfig is the config of the read loop of a tcp session, which trades off the
+// latency against the throughput. The zero value is the default behavior, i.e. all frames
+// of a read are delivered in a batch and the read blocks at once if no data arrives.
+type ReadLoopConfig struct {
+	// the max number of the frames decoded from the read buffer before they are delivered
+	// and the read goroutine yields the processor. 0 means no limit, which favors the
+	// throughput of the relays, and a small value such as 1 favors the latency.
+	BatchSize int
+	// the read goroutine polls the connection by non-blocking reads for SpinTime before it
+	// blocks on the read, which saves the wake-up latency at the cost of cpu. 0 disables
+	// the spin. It only works for the tcp connections without compression on linux.
+	SpinTime time.Duration
+}
+
+// SetReadLoop sets the read loop knobs of a tcp session, and nil @config restores the
+// default behavior. It should be invoked before the session runs, e.g. in NewSessionCallback.
+// It has no effect on udp/websocket sessions.
+func (s *session) SetReadLoop(config *ReadLoopConfig) {
+	if config == nil {
+		s.readLoop = ReadLoopConfig{}
+		return
+	}
+
+	s.readLoop = *config
+}
+
+// poll the connection by the non-blocking reads for @spin before the blocking recv, and
+// yield the processor between the polls if @yield is true. The read deadline is not
+// refreshed by the successful polls, which is fine for it only guards the blocking read.
+func (t *gettyTCPConn) spinRecv(p []byte, spin time.Duration, yield bool) (int, error) {
+	tcpConn, ok := t.conn.(*net.TCPConn)
+	if reader, deadline := t.readStream(); !ok || !deadline || reader != io.Reader(t.conn) {
+		return t.recv(p)
+	}
+
+	deadline := time.Now().Add(spin)
+	for {
+		n, err := pollRecv(tcpConn, p)
+		if err == ErrNotSupported {
+			break
+		}
+		if err != errWouldBlock {
+			atomic.AddUint32(&t.readBytes, uint32(n))
+			return n, jerrors.Trace(err)
+		}
+		if !time.Now().Before(deadline) {
+			break
+		}
+		if yield {
+			runtime.Gosched()
+		}
+	}
+
+	return t.recv(p)
+}
@@ -0,0 +1,52 @@
+//go:build linux
+// +build linux
+
+/******************************************************
+# DESC       : non-blocking tcp read on linux
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-02 10:30
+# FILE       : readloop_linux.go
+******************************************************/
+
+package getty
+
+import (
+	"io"
+	"net"
+)
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// read @conn without waiting for the netpoller. It returns errWouldBlock if no data arrives.
+func pollRecv(conn *net.TCPConn, p []byte) (int, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		n       int
+		readErr error
+	)
+	err = rawConn.Read(func(fd uintptr) bool {
+		n, readErr = unix.Read(int(fd), p)
+		// do not wait for the readiness of @fd
+		return true
+	})
+	switch {
+	case err != nil:
+		return 0, err
+	case readErr == unix.EAGAIN || readErr == unix.EINTR:
+		return 0, errWouldBlock
+	case readErr != nil:
+		return 0, readErr
+	case n == 0 && len(p) > 0:
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
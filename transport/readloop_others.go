@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+/******************************************************
+# DESC       : non-blocking tcp read stub
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-02 10:30
+# FILE       : readloop_others.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+)
+
+func pollRecv(conn *net.TCPConn, p []byte) (int, error) {
+	return 0, ErrNotSupported
+}
@@ -0,0 +1,83 @@
+package getty
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func newTCPPair(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	peer, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	conn, err := l.Accept()
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		peer.Close()
+		conn.Close()
+	})
+
+	return conn, peer
+}
+
+func TestPollRecv(t *testing.T) {
+	conn, peer := newTCPPair(t)
+	buf := make([]byte, 16)
+
+	n, err := pollRecv(conn.(*net.TCPConn), buf)
+	if runtime.GOOS != "linux" {
+		assert.Equal(t, ErrNotSupported, err)
+		return
+	}
+	assert.Equal(t, errWouldBlock, err)
+
+	_, err = peer.Write([]byte("hello"))
+	assert.Nil(t, err)
+	for err = errWouldBlock; err == errWouldBlock; {
+		n, err = pollRecv(conn.(*net.TCPConn), buf)
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+
+	peer.Close()
+	for err = errWouldBlock; err == errWouldBlock; {
+		_, err = pollRecv(conn.(*net.TCPConn), buf)
+	}
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestSessionReadLoop(t *testing.T) {
+	conn, peer := newTCPPair(t)
+	listener := &lineListener{msgs: make(chan interface{}, 8)}
+
+	clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+	ss := newTCPSession(conn, clt).(*session)
+	ss.SetPkgHandler(&lineTransferCodec{})
+	ss.SetEventListener(listener)
+	ss.SetReadLoop(&ReadLoopConfig{BatchSize: 1, SpinTime: 10 * time.Millisecond})
+	ss.run()
+	defer ss.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err := peer.Write([]byte("hello\nworld\nfoo\n"))
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", <-listener.msgs)
+		assert.Equal(t, "world", <-listener.msgs)
+		assert.Equal(t, "foo", <-listener.msgs)
+		// the read goroutine blocks after the spin
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, uint32(32), atomic.LoadUint32(&ss.gettyConn().readBytes))
+
+	ss.SetReadLoop(nil)
+	assert.Equal(t, ReadLoopConfig{}, ss.readLoop)
+}
@@ -0,0 +1,194 @@
+/******************************************************
+# DESC       : write key rotation of encrypted tcp session
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-20 16:10
+# FILE       : rekey.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+// A side of an encrypted session rotates its write key as follows:
+//  1. it derives the next key from the current one, and sends a rekey frame with the
+//     epoch of the next key, which is sealed by the current key;
+//  2. the peer derives the same key, replies an ack frame, and accepts the records
+//     sealed by both keys in the overlap window;
+//  3. it seals the records by the next key once it gets the ack.
+// The keys are derived one way, so the old traffic can not be decrypted by a new key.
+// Both directions rotate their keys independently.
+
+const (
+	// the flag of the length of the records which carry the rekey frames
+	cipherControlFlag   = 1 << 31
+	defaultRekeyOverlap = 10 * time.Second
+
+	// the rekey frame: type(1 byte) | epoch(4 bytes)
+	rekeyFrameLen = 5
+	rekeyFrame    = 1
+	rekeyAckFrame = 2
+)
+
+var (
+	errIllegalRekeyFrame = jerrors.New("illegal rekey frame")
+)
+
+// cipherKey is a key of a direction of an encrypted session
+type cipherKey struct {
+	epoch uint32
+	key   []byte
+	aead  cipher.AEAD
+	// the sequence number of the next record sealed by the key
+	seq uint64
+}
+
+func newCipherKey(key []byte, epoch uint32) (*cipherKey, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cipherKey{epoch: epoch, key: key, aead: aead}, nil
+}
+
+// derive the key of the next epoch
+func (k *cipherKey) next() (*cipherKey, error) {
+	var epoch [4]byte
+	binary.BigEndian.PutUint32(epoch[:], k.epoch+1)
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write([]byte("getty rekey"))
+	mac.Write(epoch[:])
+	return newCipherKey(mac.Sum(nil)[:len(k.key)], k.epoch+1)
+}
+
+func (k *cipherKey) seal(dst, plain []byte) []byte {
+	dst = k.aead.Seal(dst, recordNonce(k.aead, k.seq), plain, nil)
+	k.seq++
+	return dst
+}
+
+func (k *cipherKey) open(dst, record []byte) ([]byte, error) {
+	plain, err := k.aead.Open(dst, recordNonce(k.aead, k.seq), record, nil)
+	if err != nil {
+		return nil, err
+	}
+	k.seq++
+	return plain, nil
+}
+
+func encodeRekeyFrame(typ byte, epoch uint32) []byte {
+	b := make([]byte, rekeyFrameLen)
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:], epoch)
+	return b
+}
+
+// start or retry the rotation of the write key if it is due. It is invoked with wLock held.
+func (c *cipherConn) rekey(now time.Time) error {
+	if c.rekeyInterval <= 0 {
+		return nil
+	}
+
+	switch {
+	case c.wNext == nil && c.rekeyInterval <= now.Sub(c.wTime):
+		next, err := c.w.next()
+		if err != nil {
+			return err
+		}
+		c.wNext = next
+	case c.wNext != nil && c.rekeyOverlap <= now.Sub(c.wRekeyTime):
+		// the rekey frame has not been acknowledged
+	default:
+		return nil
+	}
+
+	c.wRekeyTime = now
+	return c.writeRecord(cipherControlFlag, encodeRekeyFrame(rekeyFrame, c.wNext.epoch))
+}
+
+// switch to the next write key acknowledged by the peer
+func (c *cipherConn) switchWriteKey(epoch uint32) {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+
+	if c.wNext != nil && c.wNext.epoch == epoch {
+		c.w, c.wNext, c.wTime = c.wNext, nil, time.Now()
+	}
+}
+
+// write the ack frame of @epoch. The write error is returned by the next Write.
+func (c *cipherConn) ackRekey(epoch uint32) {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+
+	c.writeRecord(cipherControlFlag, encodeRekeyFrame(rekeyAckFrame, epoch))
+}
+
+// decrypt @record by the read key, or the next read key in the overlap window.
+// It is only invoked by the read goroutine of the session.
+func (c *cipherConn) open(record []byte, now time.Time) ([]byte, error) {
+	if c.rNext != nil && now.After(c.rDeadline) {
+		c.r, c.rNext = c.rNext, nil
+	}
+	if c.rNext == nil {
+		return c.r.open(record[:0], record)
+	}
+
+	// a failed Open wipes its output, so @record is not decrypted in place
+	plain, err := c.r.open(nil, record)
+	if err == nil {
+		return plain, nil
+	}
+	if plain, err = c.rNext.open(nil, record); err != nil {
+		return nil, err
+	}
+	c.r, c.rNext = c.rNext, nil
+	return plain, nil
+}
+
+// handle the rekey frame @frame. It is only invoked by the read goroutine of the session.
+// The replies are written by other goroutines, so the read goroutine is not blocked by
+// a writer which waits for the peer to read.
+func (c *cipherConn) handleControl(frame []byte, now time.Time) error {
+	if len(frame) != rekeyFrameLen {
+		return errIllegalRekeyFrame
+	}
+
+	epoch := binary.BigEndian.Uint32(frame[1:])
+	switch frame[0] {
+	case rekeyFrame:
+		switch {
+		case c.rNext == nil && epoch == c.r.epoch+1:
+			next, err := c.r.next()
+			if err != nil {
+				return err
+			}
+			c.rNext, c.rDeadline = next, now.Add(c.rekeyOverlap)
+		case c.rNext != nil && epoch == c.rNext.epoch, c.rNext == nil && epoch == c.r.epoch:
+			// the rekey frame is sent again for the ack is late
+		default:
+			return errIllegalRekeyFrame
+		}
+		go c.ackRekey(epoch)
+
+	case rekeyAckFrame:
+		go c.switchWriteKey(epoch)
+
+	default:
+		return errIllegalRekeyFrame
+	}
+
+	return nil
+}
@@ -0,0 +1,254 @@
+/******************************************************
+# DESC       : udp datagram encryption & anti-replay
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-21 10:20
+# FILE       : replay.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	// magic(1B) + sender salt(8B) + sequence number(8B) + unix time in seconds(4B)
+	cipherDatagramHeaderLen = 21
+	cipherDatagramMagic     = 0xFB
+
+	defaultReplayWindow    = 1024
+	defaultDatagramMaxAge  = time.Minute
+	cipherDatagramOverhead = cipherDatagramHeaderLen + 16 // the gcm tag is 16 bytes
+)
+
+var (
+	errIllegalCipherDatagram = jerrors.New("illegal encrypted udp datagram")
+)
+
+/////////////////////////////////////////
+// replay window
+/////////////////////////////////////////
+
+// ReplayWindow is the sliding window anti-replay check of RFC 4303, which can be used by
+// the custom encryption layers, e.g. a stream wrapper which seals its records with explicit
+// sequence numbers. The sequence number of a record should be checked by Check before the
+// record is authenticated, and marked by Accept after. It is not safe for concurrent use.
+type ReplayWindow struct {
+	size   uint64
+	window dedupWindow
+}
+
+// NewReplayWindow builds a window of @size sequence numbers. @size is rounded up to
+// a multiple of 64, and its default value is 1024.
+func NewReplayWindow(size int) *ReplayWindow {
+	if size <= 0 {
+		size = defaultReplayWindow
+	}
+	size = (size + 63) &^ 63
+
+	return &ReplayWindow{
+		size:   uint64(size),
+		window: dedupWindow{bitmap: make([]uint64, size/64)},
+	}
+}
+
+// Check returns false if @seq has been accepted or is older than the window.
+func (w *ReplayWindow) Check(seq uint64) bool {
+	return !w.window.seen(seq, w.size)
+}
+
+// Accept marks @seq as received and slides the window. It returns false if @seq
+// has been accepted or is older than the window.
+func (w *ReplayWindow) Accept(seq uint64) bool {
+	return w.window.accept(seq, w.size)
+}
+
+// seen returns true if @seq is duplicate or out of the window.
+func (w *dedupWindow) seen(seq uint64, size uint64) bool {
+	switch {
+	case w.max < seq:
+		return false
+	case size <= w.max-seq:
+		return true
+	}
+	return w.isSet(seq % size)
+}
+
+/////////////////////////////////////////
+// datagram encryption
+/////////////////////////////////////////
+
+// DatagramCipherConfig is the encryption config of a udp session, which is set by
+// (DatagramSession)SetDatagramCipher. Every datagram is sealed by a key derived from the psk
+// and a random salt of the sender, with its sequence number & send time authenticated.
+// The receiver refuses a datagram which has been received, is older than the replay
+// window, or whose send time is more than MaxAge away from its clock, so a captured
+// datagram can not be re-injected. Both peers should enable the encryption.
+type DatagramCipherConfig struct {
+	// the cipher suite of the datagrams, CipherAES128GCM or CipherAES256GCM. Its default
+	// value is CipherAES256GCM.
+	Cipher CipherSuite
+	// the pre-shared key from which the datagram keys are derived. It is required.
+	PSK []byte
+	// the replay window size in datagrams. It is rounded up to a multiple of 64,
+	// and its default value is 1024.
+	Window int
+	// the max difference between the send time of a datagram and the clock of its
+	// receiver, which should be larger than the clock skew of the peers. The replay
+	// window of a sender is kept for 3 times of it after its last datagram, so a
+	// datagram is refused by its window or its send time. Its default value is 1 minute.
+	MaxAge time.Duration
+}
+
+func (c DatagramCipherConfig) withDefaults() DatagramCipherConfig {
+	if c.Cipher == CipherNone {
+		c.Cipher = CipherAES256GCM
+	}
+	if c.Window <= 0 {
+		c.Window = defaultReplayWindow
+	}
+	c.Window = (c.Window + 63) &^ 63
+	if c.MaxAge <= 0 {
+		c.MaxAge = defaultDatagramMaxAge
+	}
+
+	return c
+}
+
+func (c DatagramCipherConfig) validate() error {
+	if c.Cipher != CipherAES128GCM && c.Cipher != CipherAES256GCM {
+		return jerrors.Errorf("illegal datagram cipher %s", c.Cipher)
+	}
+	if len(c.PSK) == 0 {
+		return jerrors.New("datagram cipher without psk")
+	}
+
+	return nil
+}
+
+// the key of the datagrams sent with @salt
+func datagramKey(config DatagramCipherConfig, salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, config.PSK)
+	mac.Write([]byte("getty datagram"))
+	mac.Write(salt)
+	return newGCM(mac.Sum(nil)[:config.Cipher.keyLen()])
+}
+
+// datagramSealer seals the datagrams sent by a session. A random salt is chosen for
+// every session, so the nonces of different senders never collide under one key.
+type datagramSealer struct {
+	seq    uint64 // the first word for the atomic operations on 32 bits platforms
+	config DatagramCipherConfig
+	salt   [8]byte
+	aead   cipher.AEAD
+}
+
+func newDatagramSealer(config DatagramCipherConfig) (*datagramSealer, error) {
+	s := &datagramSealer{config: config}
+	if _, err := rand.Read(s.salt[:]); err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	aead, err := datagramKey(config, s.salt[:])
+	if err != nil {
+		return nil, err
+	}
+	s.aead = aead
+
+	return s, nil
+}
+
+// seal @datagram, whose header is authenticated as the additional data
+func (s *datagramSealer) seal(datagram []byte, now time.Time) []byte {
+	seq := atomic.AddUint64(&s.seq, 1)
+	b := make([]byte, cipherDatagramHeaderLen, cipherDatagramHeaderLen+len(datagram)+s.aead.Overhead())
+	b[0] = cipherDatagramMagic
+	copy(b[1:], s.salt[:])
+	binary.BigEndian.PutUint64(b[9:], seq)
+	binary.BigEndian.PutUint32(b[17:], uint32(now.Unix()))
+	return s.aead.Seal(b, recordNonce(s.aead, seq), datagram, b)
+}
+
+type datagramSender struct {
+	aead       cipher.AEAD
+	window     dedupWindow
+	lastActive time.Time
+}
+
+// datagramOpener is only used by the read goroutine of the session. The replay windows
+// are kept by the salts instead of the peer addresses, which can be spoofed.
+type datagramOpener struct {
+	config    DatagramCipherConfig
+	senders   map[[8]byte]*datagramSender
+	lastSweep time.Time
+}
+
+func newDatagramOpener(config DatagramCipherConfig) *datagramOpener {
+	return &datagramOpener{
+		config:  config,
+		senders: make(map[[8]byte]*datagramSender),
+	}
+}
+
+// authenticate & decrypt @data. It returns ErrReplayedDatagram if @data is refused by
+// the anti-replay check.
+func (o *datagramOpener) open(data []byte, now time.Time) ([]byte, error) {
+	if len(data) < cipherDatagramOverhead || data[0] != cipherDatagramMagic {
+		return nil, errIllegalCipherDatagram
+	}
+	var salt [8]byte
+	copy(salt[:], data[1:])
+	seq := binary.BigEndian.Uint64(data[9:])
+	sent := time.Unix(int64(binary.BigEndian.Uint32(data[17:])), 0)
+	if age := now.Sub(sent); o.config.MaxAge < age || age < -o.config.MaxAge {
+		return nil, newGettyError(ErrReplayedDatagram, jerrors.Errorf("the datagram was sent at %s", sent))
+	}
+
+	o.sweep(now)
+	sender, ok := o.senders[salt]
+	if !ok {
+		aead, err := datagramKey(o.config, salt[:])
+		if err != nil {
+			return nil, err
+		}
+		sender = &datagramSender{aead: aead, window: dedupWindow{bitmap: make([]uint64, o.config.Window/64)}}
+	}
+	if sender.window.seen(seq, uint64(o.config.Window)) {
+		return nil, newGettyError(ErrReplayedDatagram, jerrors.Errorf("the datagram %d has been received", seq))
+	}
+	plain, err := sender.aead.Open(nil, recordNonce(sender.aead, seq), data[cipherDatagramHeaderLen:],
+		data[:cipherDatagramHeaderLen])
+	if err != nil {
+		return nil, jerrors.Annotate(errIllegalCipherDatagram, err.Error())
+	}
+
+	// the sender is kept only after its datagram is authenticated
+	sender.window.accept(seq, uint64(o.config.Window))
+	sender.lastActive = now
+	o.senders[salt] = sender
+	return plain, nil
+}
+
+// drop the windows of the idle senders
+func (o *datagramOpener) sweep(now time.Time) {
+	if now.Sub(o.lastSweep) < o.config.MaxAge {
+		return
+	}
+	o.lastSweep = now
+	for salt, sender := range o.senders {
+		if now.Sub(sender.lastActive) > 3*o.config.MaxAge {
+			delete(o.senders, salt)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package getty
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayWindow(t *testing.T) {
+	w := NewReplayWindow(100)
+	assert.Equal(t, uint64(128), w.size)
+
+	assert.True(t, w.Check(1))
+	// Check does not mark the sequence number
+	assert.True(t, w.Check(1))
+	assert.True(t, w.Accept(1))
+	assert.False(t, w.Check(1))
+	assert.False(t, w.Accept(1))
+
+	assert.True(t, w.Accept(200))
+	assert.True(t, w.Check(100))
+	assert.True(t, w.Accept(100))
+	// out of the window
+	assert.False(t, w.Check(72))
+	assert.False(t, w.Accept(72))
+	assert.True(t, w.Check(73))
+}
+
+func TestDatagramCipher(t *testing.T) {
+	config := DatagramCipherConfig{PSK: []byte("secret"), Window: 64}.withDefaults()
+	assert.Nil(t, config.validate())
+	assert.NotNil(t, DatagramCipherConfig{}.withDefaults().validate())
+	sealer, err := newDatagramSealer(config)
+	assert.Nil(t, err)
+	opener := newDatagramOpener(config)
+
+	now := time.Now()
+	datagrams := make([][]byte, 100)
+	for i := range datagrams {
+		datagrams[i] = sealer.seal([]byte{byte(i)}, now)
+	}
+	open := func(i int, now time.Time) error {
+		data, err := opener.open(datagrams[i], now)
+		if err == nil {
+			assert.Equal(t, []byte{byte(i)}, data)
+		}
+		return err
+	}
+	assert.Nil(t, open(0, now))
+	assert.True(t, errors.Is(open(0, now), ErrReplayedDatagram))
+	assert.Nil(t, open(99, now))
+	assert.Nil(t, open(50, now))
+	// out of the window
+	assert.True(t, errors.Is(open(10, now), ErrReplayedDatagram))
+	// the datagram is replayed after its sender has been dropped
+	assert.True(t, errors.Is(open(60, now.Add(time.Hour)), ErrReplayedDatagram))
+	assert.True(t, errors.Is(open(60, now.Add(-time.Hour)), ErrReplayedDatagram))
+
+	// the tampered datagram is not authenticated, and does not pollute the window
+	tampered := append([]byte(nil), datagrams[70]...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = opener.open(tampered, now)
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrReplayedDatagram))
+	assert.Nil(t, open(70, now))
+
+	// another psk
+	other, err := newDatagramSealer(DatagramCipherConfig{PSK: []byte("other")}.withDefaults())
+	assert.Nil(t, err)
+	_, err = opener.open(other.seal([]byte("hello"), now), now)
+	assert.NotNil(t, err)
+	assert.Len(t, opener.senders, 1)
+
+	_, err = opener.open([]byte("hello"), now)
+	assert.Equal(t, errIllegalCipherDatagram, err)
+}
+
+func TestUDPSessionDatagramCipher(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer peer.Close()
+	local, err := net.DialUDP("udp", nil, peer.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer local.Close()
+
+	ss := newUDPSession(local, newClient(UDP_CLIENT, WithServerAddress(peer.LocalAddr().String()), WithConnectionNumber(1)))
+	size := ss.(DatagramSession).MaxDatagramSize()
+	assert.NotNil(t, ss.(DatagramSession).SetDatagramCipher(&DatagramCipherConfig{}))
+	config := &DatagramCipherConfig{Cipher: CipherAES128GCM, PSK: []byte("secret")}
+	assert.Nil(t, ss.(DatagramSession).SetDatagramCipher(config))
+	assert.Equal(t, size-cipherDatagramOverhead, ss.(DatagramSession).MaxDatagramSize())
+
+	conn := ss.(*session).Connection.(*gettyUDPConn)
+	_, err = conn.send(UDPContext{Pkg: []byte("hello")})
+	assert.Nil(t, err)
+
+	buf := make([]byte, 1500)
+	n, _, err := peer.ReadFromUDP(buf)
+	assert.Nil(t, err)
+	opener := newDatagramOpener(config.withDefaults())
+	data, err := opener.open(buf[:n], time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), data)
+	// the captured datagram is re-injected
+	_, err = opener.open(buf[:n], time.Now())
+	assert.True(t, errors.Is(err, ErrReplayedDatagram))
+
+	src, dst := newPipeSessions(t)
+	defer src.Close()
+	defer dst.Close()
+	assert.Equal(t, ErrNotSupported, src.SetDatagramCipher(config))
+}
@@ -0,0 +1,323 @@
+/******************************************************
+# DESC       : per tenant resource isolation groups
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-05 15:30
+# FILE       : resgroup.go
+******************************************************/
+
+package getty
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ResourceGroupLimits is the resource limits of a tenant, which are shared by all sessions of
+// the tenant no matter which endpoints they belong to.
+type ResourceGroupLimits struct {
+	// the max number of the sessions. 0 means no limit.
+	MaxSessions int
+	// the max inbound & outbound bytes per second of the sessions, which are
+	// enforced by pausing the read & write goroutines. 0 means no limit.
+	ReadBandwidth  int64
+	WriteBandwidth int64
+	// the max number of the OnMessage tasks pending or running in the task pools. The tasks
+	// beyond it run in the read goroutines of the sessions, so a busy tenant can not occupy the
+	// shared task pool, and its reads slow down instead. 0 means no limit.
+	MaxTasks int
+}
+
+// ResourceGroupStats is the resource usage of a tenant.
+type ResourceGroupStats struct {
+	Sessions int64
+	// number of the sessions rejected for MaxSessions
+	RejectedSessions uint64
+	ReadBytes        uint64
+	WriteBytes       uint64
+	// the duration which the read & write goroutines are paused for the bandwidth limits
+	ReadThrottled  time.Duration
+	WriteThrottled time.Duration
+	// number of the OnMessage tasks in the task pools
+	Tasks int64
+	// number of the OnMessage tasks run in the read goroutines for MaxTasks
+	InlineTasks uint64
+}
+
+func (s ResourceGroupStats) String() string {
+	return fmt.Sprintf("{sessions:%d, rejected sessions:%d, read bytes:%d, write bytes:%d, "+
+		"read throttled:%s, write throttled:%s, tasks:%d, inline tasks:%d}",
+		s.Sessions, s.RejectedSessions, s.ReadBytes, s.WriteBytes,
+		s.ReadThrottled, s.WriteThrottled, s.Tasks, s.InlineTasks)
+}
+
+// bandwidthLimiter is a token bucket of bytes whose burst is the bytes of one second. A
+// read or write consumes its bytes at once and the caller pauses for the debt.
+type bandwidthLimiter struct {
+	lock   sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// consume @n bytes at @now and get the duration to pause
+func (l *bandwidthLimiter) reserve(n int, now time.Time) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+	if l.last.IsZero() {
+		l.tokens = float64(l.rate)
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+		if l.tokens > float64(l.rate) {
+			l.tokens = float64(l.rate)
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+}
+
+func (l *bandwidthLimiter) setRate(rate int64) {
+	l.lock.Lock()
+	l.rate = rate
+	l.lock.Unlock()
+}
+
+type resourceGroup struct {
+	// keep 64-bit counters at the head of the struct for atomic alignment on 32-bit platforms
+	sessions         int64
+	tasks            int64
+	rejectedSessions uint64
+	readBytes        uint64
+	writeBytes       uint64
+	readThrottled    int64
+	writeThrottled   int64
+	inlineTasks      uint64
+
+	// limits are guarded by the lock of ResourceGroups
+	maxSessions int64
+	maxTasks    int64
+	read        bandwidthLimiter
+	write       bandwidthLimiter
+}
+
+func (g *resourceGroup) setLimits(limits ResourceGroupLimits) {
+	atomic.StoreInt64(&g.maxSessions, int64(limits.MaxSessions))
+	atomic.StoreInt64(&g.maxTasks, int64(limits.MaxTasks))
+	g.read.setRate(limits.ReadBandwidth)
+	g.write.setRate(limits.WriteBandwidth)
+}
+
+func (g *resourceGroup) acquireSession() bool {
+	n := atomic.AddInt64(&g.sessions, 1)
+	if max := atomic.LoadInt64(&g.maxSessions); max > 0 && n > max {
+		atomic.AddInt64(&g.sessions, -1)
+		atomic.AddUint64(&g.rejectedSessions, 1)
+		return false
+	}
+
+	return true
+}
+
+func (g *resourceGroup) acquireTask() bool {
+	n := atomic.AddInt64(&g.tasks, 1)
+	if max := atomic.LoadInt64(&g.maxTasks); max > 0 && n > max {
+		atomic.AddInt64(&g.tasks, -1)
+		atomic.AddUint64(&g.inlineTasks, 1)
+		return false
+	}
+
+	return true
+}
+
+func (g *resourceGroup) stats() ResourceGroupStats {
+	return ResourceGroupStats{
+		Sessions:         atomic.LoadInt64(&g.sessions),
+		RejectedSessions: atomic.LoadUint64(&g.rejectedSessions),
+		ReadBytes:        atomic.LoadUint64(&g.readBytes),
+		WriteBytes:       atomic.LoadUint64(&g.writeBytes),
+		ReadThrottled:    time.Duration(atomic.LoadInt64(&g.readThrottled)),
+		WriteThrottled:   time.Duration(atomic.LoadInt64(&g.writeThrottled)),
+		Tasks:            atomic.LoadInt64(&g.tasks),
+		InlineTasks:      atomic.LoadUint64(&g.inlineTasks),
+	}
+}
+
+// ResourceGroups is the resource isolation groups of the tenants of a multi-tenant gateway.
+// A session joins the group of its tenant by (ResourceGroupMember)JoinResourceGroup in NewSessionCallback,
+// i.e. after it is accepted and its handshake completes. It can be shared by the endpoints.
+type ResourceGroups struct {
+	lock     sync.Mutex
+	defaults ResourceGroupLimits
+	groups   map[string]*resourceGroup
+}
+
+// NewResourceGroups creates the resource groups. The groups of the tenants whose limits are
+// not set by SetLimits use @defaults.
+func NewResourceGroups(defaults ResourceGroupLimits) *ResourceGroups {
+	return &ResourceGroups{
+		defaults: defaults,
+		groups:   make(map[string]*resourceGroup),
+	}
+}
+
+// SetLimits sets the limits of @tenant, which take effect on its running sessions at once.
+// The running sessions beyond the new MaxSessions are not closed.
+func (r *ResourceGroups) SetLimits(tenant string, limits ResourceGroupLimits) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	g, ok := r.groups[tenant]
+	if !ok {
+		g = &resourceGroup{}
+		r.groups[tenant] = g
+	}
+	g.setLimits(limits)
+}
+
+func (r *ResourceGroups) group(tenant string) *resourceGroup {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	g, ok := r.groups[tenant]
+	if !ok {
+		g = &resourceGroup{}
+		g.setLimits(r.defaults)
+		r.groups[tenant] = g
+	}
+	return g
+}
+
+// Stats returns the resource usage of the tenants which have joined or been set.
+func (r *ResourceGroups) Stats() map[string]ResourceGroupStats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	stats := make(map[string]ResourceGroupStats, len(r.groups))
+	for tenant, g := range r.groups {
+		stats[tenant] = g.stats()
+	}
+	return stats
+}
+
+// Tenants returns the sorted tenants which have joined or been set.
+func (r *ResourceGroups) Tenants() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	tenants := make([]string, 0, len(r.groups))
+	for tenant := range r.groups {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+/////////////////////////////////////////
+// session resource group
+/////////////////////////////////////////
+
+// JoinResourceGroup puts the session into the resource group of @tenant. Invoke it in
+// NewSessionCallback, and return its error to reject the session if the session limit of
+// the tenant is reached. A session can only join one group, and it leaves the group when it
+// exits or it is rejected by NewSessionCallback.
+func (s *session) JoinResourceGroup(groups *ResourceGroups, tenant string) error {
+	s.lock.Lock()
+	if s.resGroup != nil {
+		s.lock.Unlock()
+		return fmt.Errorf("session %s has joined a resource group", s.sessionKey())
+	}
+
+	g := groups.group(tenant)
+	if !g.acquireSession() {
+		s.lock.Unlock()
+		s.audit(AuditQuotaViolation, "tenant:"+tenant)
+		return ErrResourceGroupLimit
+	}
+	s.resGroup = g
+	s.lock.Unlock()
+	return nil
+}
+
+// the group may be joined by the read goroutine, e.g. after the identity is got from the
+// first pkg, so it is read under the lock.
+func (s *session) resourceGroup() *resourceGroup {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.resGroup
+}
+
+func (s *session) leaveResourceGroup() {
+	if g := s.resourceGroup(); g != nil && atomic.CompareAndSwapInt32(&s.resGroupLeft, 0, 1) {
+		atomic.AddInt64(&g.sessions, -1)
+	}
+}
+
+// count the read bytes of the group and pause for its bandwidth limit
+func (s *session) throttleRead(n int) {
+	g := s.resourceGroup()
+	if g == nil {
+		return
+	}
+
+	atomic.AddUint64(&g.readBytes, uint64(n))
+	if d := g.read.reserve(n, time.Now()); d > 0 {
+		atomic.AddInt64(&g.readThrottled, int64(d))
+		s.pause(d)
+	}
+}
+
+// count the written bytes of the group and pause for its bandwidth limit
+func (s *session) throttleWrite(n int) {
+	g := s.resourceGroup()
+	if g == nil {
+		return
+	}
+
+	atomic.AddUint64(&g.writeBytes, uint64(n))
+	if d := g.write.reserve(n, time.Now()); d > 0 {
+		atomic.AddInt64(&g.writeThrottled, int64(d))
+		s.pause(d)
+	}
+}
+
+// sleep @d unless the session is closed. The debt of a large write may be beyond the span
+// of the timing wheel, so a timer is used.
+func (s *session) pause(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-s.done:
+	case <-timer.C:
+	}
+}
+
+// wrap the OnMessage task @f which runs in the task pool. It returns false if the task
+// share of the group is exhausted, and then @f should run in the read goroutine.
+func (s *session) groupTask(f func()) (func(), bool) {
+	g := s.resourceGroup()
+	if g == nil {
+		return f, true
+	}
+	if !g.acquireTask() {
+		return f, false
+	}
+
+	return func() {
+		defer atomic.AddInt64(&g.tasks, -1)
+		f()
+	}, true
+}
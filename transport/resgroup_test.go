@@ -0,0 +1,89 @@
+package getty
+
+import (
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceGroupSessionLimit(t *testing.T) {
+	groups := NewResourceGroups(ResourceGroupLimits{MaxSessions: 1})
+	ss1, ss2 := newPipeSessions(t)
+
+	assert.Nil(t, ss1.JoinResourceGroup(groups, "tenant-a"))
+	assert.NotNil(t, ss1.JoinResourceGroup(groups, "tenant-b"))
+	assert.Equal(t, ErrResourceGroupLimit, ss2.JoinResourceGroup(groups, "tenant-a"))
+	ss2.discard()
+
+	stats := groups.Stats()["tenant-a"]
+	assert.Equal(t, int64(1), stats.Sessions)
+	assert.Equal(t, uint64(1), stats.RejectedSessions)
+
+	// the slot is released once however the session exits
+	ss1.discard()
+	ss1.discard()
+	assert.Equal(t, int64(0), groups.Stats()["tenant-a"].Sessions)
+	assert.Nil(t, ss2.JoinResourceGroup(groups, "tenant-a"))
+
+	groups.SetLimits("tenant-c", ResourceGroupLimits{})
+	assert.Equal(t, []string{"tenant-a", "tenant-c"}, groups.Tenants())
+}
+
+func TestResourceGroupTaskShare(t *testing.T) {
+	groups := NewResourceGroups(ResourceGroupLimits{})
+	groups.SetLimits("tenant", ResourceGroupLimits{MaxTasks: 1})
+	ss, _ := newPipeSessions(t)
+	assert.Nil(t, ss.JoinResourceGroup(groups, "tenant"))
+
+	var ran int
+	f1, ok := ss.groupTask(func() { ran++ })
+	assert.True(t, ok)
+	_, ok = ss.groupTask(func() { ran++ })
+	assert.False(t, ok)
+	stats := groups.Stats()["tenant"]
+	assert.Equal(t, int64(1), stats.Tasks)
+	assert.Equal(t, uint64(1), stats.InlineTasks)
+
+	f1()
+	assert.Equal(t, 1, ran)
+	assert.Equal(t, int64(0), groups.Stats()["tenant"].Tasks)
+	_, ok = ss.groupTask(func() {})
+	assert.True(t, ok)
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	var (
+		l   = bandwidthLimiter{rate: 1000}
+		now = time.Now()
+	)
+
+	// the burst of one second is free
+	assert.Equal(t, time.Duration(0), l.reserve(1000, now))
+	assert.Equal(t, 500*time.Millisecond, l.reserve(500, now))
+	// the debt is paid back in time
+	assert.Equal(t, time.Duration(0), l.reserve(0, now.Add(500*time.Millisecond)))
+	assert.Equal(t, 100*time.Millisecond, l.reserve(100, now.Add(500*time.Millisecond)))
+
+	l.setRate(0)
+	assert.Equal(t, time.Duration(0), l.reserve(1<<20, now))
+}
+
+func TestResourceGroupThrottle(t *testing.T) {
+	groups := NewResourceGroups(ResourceGroupLimits{ReadBandwidth: 100})
+	ss, _ := newPipeSessions(t)
+	assert.Nil(t, ss.JoinResourceGroup(groups, "tenant"))
+
+	start := time.Now()
+	ss.throttleRead(120)
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	ss.throttleWrite(1 << 20)
+
+	stats := groups.Stats()["tenant"]
+	assert.Equal(t, uint64(120), stats.ReadBytes)
+	assert.Equal(t, uint64(1<<20), stats.WriteBytes)
+	assert.Equal(t, 200*time.Millisecond, stats.ReadThrottled.Round(10*time.Millisecond))
+	assert.Equal(t, time.Duration(0), stats.WriteThrottled)
+}
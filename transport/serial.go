@@ -0,0 +1,63 @@
+/******************************************************
+# DESC       : serial port transport
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-24 09:30
+# FILE       : serial.go
+******************************************************/
+
+package getty
+
+import (
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+// adapt a serial or bluetooth device to net.Conn, whose addresses are the device name @name.
+func newSerialConn(name string, device io.ReadWriteCloser) net.Conn {
+	return NewTransportConn("serial", name, name, device)
+}
+
+// open the serial device @name as a non-blocking file, so its deadlines work.
+func openSerialFile(name string) (io.ReadWriteCloser, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	return f, nil
+}
+
+// NewSerialClient builds a serial client whose server address is the serial device name,
+// e.g. NewSerialClient(WithServerAddress("/dev/ttyUSB0"), WithConnectionNumber(1),
+// WithSerialOpener(opener)). Its session reads & writes the device as a tcp stream, and the
+// client reopens the device when the session is closed.
+func NewSerialClient(opts ...ClientOption) Client {
+	return newClient(SERIAL_CLIENT, opts...)
+}
+
+// open the device @c.addr by @opener until it succeeds or the client is closed
+func (c *client) dialDevice(opener SerialOpener, name string) Session {
+	for {
+		if c.IsClosed() {
+			return nil
+		}
+		device, err := opener(c.addr)
+		if err == nil {
+			ss := newTCPSession(newSerialConn(c.addr, device), c)
+			ss.SetName(name)
+			return ss
+		}
+
+		log.Info("open device(%s) = error{%s}", c.addr, jerrors.ErrorStack(err))
+		c.onDialError(c.addr, err)
+		<-wheel.After(connectInterval)
+	}
+}
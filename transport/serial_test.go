@@ -0,0 +1,71 @@
+package getty
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSerialClient(t *testing.T) {
+	var (
+		opened = make(chan net.Conn, 2)
+		failed = true
+	)
+	opener := func(name string) (io.ReadWriteCloser, error) {
+		assert.Equal(t, "/dev/ttyTEST", name)
+		if failed {
+			// the device is unplugged
+			failed = false
+			return nil, errors.New("no such device")
+		}
+		device, peer := net.Pipe()
+		opened <- peer
+		// hide the net.Conn methods of the pipe like a real serial device
+		return struct{ io.ReadWriteCloser }{device}, nil
+	}
+
+	var dialErrs int
+	clt := NewSerialClient(
+		WithServerAddress("/dev/ttyTEST"),
+		WithConnectionNumber(1),
+		WithSerialOpener(opener),
+		WithDialErrorHandler(func(addr string, err error) { dialErrs++ }),
+	)
+	assert.Equal(t, SERIAL_CLIENT, clt.EndPointType())
+
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+	clt.RunEventLoop(func(session Session) error {
+		session.SetPkgHandler(&lineTransferCodec{})
+		session.SetEventListener(listener)
+		return listener.OnOpen(session)
+	})
+	defer clt.Close()
+	assert.Equal(t, 1, dialErrs)
+	peer := <-opened
+	defer peer.Close()
+
+	ss := listener.array[0]
+	assert.Equal(t, "serial-session", ss.(*session).name)
+	assert.Equal(t, "/dev/ttyTEST", ss.RemoteAddr())
+
+	_, err := peer.Write([]byte("hello\n"))
+	assert.Nil(t, err)
+	select {
+	case msg := <-listener.msgs:
+		assert.Equal(t, "hello", msg)
+	case <-time.After(3 * time.Second):
+		t.Fatal("read serial device timeout")
+	}
+
+	assert.Nil(t, ss.WritePkg("world", time.Second))
+	line, err := bufio.NewReader(peer).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "world\n", line)
+}
@@ -14,9 +14,12 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -31,6 +34,8 @@ import (
 
 var (
 	errSelfConnect        = jerrors.New("connect self!")
+	errServerDraining     = jerrors.New("server is draining")
+	errRemoteBanned       = jerrors.New("remote ip is banned")
 	serverFastFailTimeout = time.Second * 1
 	serverID              = EndPointID(0)
 )
@@ -47,6 +52,19 @@ type server struct {
 	lock           sync.Mutex // for server
 	endPointType   EndPointType
 	server         *http.Server // for ws or wss server
+	metrics        *EndPointMetrics
+	watchdog       *handlerWatchdog
+	panicDumper    *panicDumper
+	handshakes     *handshakePool // for tcp, ws or wss server
+	draining       int32
+	stun           *stunAgent // for udp endpoint
+	rawNetwork     string     // for raw ip endpoint
+	keyLog         io.Writer  // for wss server
+	tarpit         *tarpit    // for tcp, ws or wss server
+
+	// running sessions which are closed when the server is closed, see shutdown.go
+	sessionLock sync.Mutex
+	sessions    map[*session]struct{}
 
 	sync.Once
 	done chan struct{}
@@ -71,6 +89,18 @@ func newServer(t EndPointType, opts ...ServerOption) *server {
 	if s.addr == "" {
 		panic(fmt.Sprintf("@addr:%s", s.addr))
 	}
+	if err := tlsSettings(s.tlsSettings).validate(); err != nil {
+		panic(fmt.Sprintf("server tls settings error:%s", jerrors.ErrorStack(err)))
+	}
+
+	s.metrics = newEndPointMetrics(s.latencySampleRate)
+	s.watchdog = newHandlerWatchdog(s.slowHandlerThreshold, s.metrics)
+	s.panicDumper = newPanicDumper(s.panicDumpPath, s.metrics)
+	s.keyLog = openKeyLog(s.keyLogPath)
+	s.tarpit = newTarpit(s.tarpitConfig, s.metrics)
+	if t == UDP_ENDPOINT {
+		s.stun = newSTUNAgent()
+	}
 
 	return s
 }
@@ -102,14 +132,94 @@ func NewWSSServer(opts ...ServerOption) Server {
 	return s
 }
 
-func (s server) ID() int32 {
+func (s *server) ID() int32 {
 	return s.endPointID
 }
 
-func (s server) EndPointType() EndPointType {
+func (s *server) EndPointType() EndPointType {
 	return s.endPointType
 }
 
+func (s *server) Metrics() *EndPointMetrics {
+	return s.metrics
+}
+
+func (s *server) handlerWatchdog() *handlerWatchdog {
+	return s.watchdog
+}
+
+func (s *server) writeStarvationThreshold() time.Duration {
+	return s.starvationThreshold
+}
+
+func (s *server) sessionIDGenerator() SessionIDGenerator {
+	return s.idGenerator
+}
+
+func (s *server) getBanList() *BanList {
+	return s.banList
+}
+
+func (s *server) getMemoryBudget() *MemoryBudget {
+	return s.memoryBudget
+}
+
+func (s *server) getJournal() *Journal {
+	return s.journal
+}
+
+func (s *server) getAuditLog() *AuditLog {
+	return s.auditLog
+}
+
+func (s *server) getAccessLog() *AccessLog {
+	return s.accessLog
+}
+
+func (s *server) getMagic() []byte {
+	return s.magic
+}
+
+func (s *server) getPanicDumper() *panicDumper {
+	return s.panicDumper
+}
+
+func (s *server) getBusyPoll() *BusyPollConfig {
+	return s.busyPoll
+}
+
+func (s *server) getSessionLogConfig() SessionLogConfig {
+	return s.sessionLog
+}
+
+func (s *server) getIdentityConfig() *IdentityConfig {
+	return s.identity
+}
+
+func (s *server) getPolicy() Policy {
+	return s.policy
+}
+
+// the timeout of the tls handshakes & the websocket upgrades. 0 means no timeout.
+func (s *server) getHandshakeTimeout() time.Duration {
+	if s.handshakeTimeout > 0 {
+		return s.handshakeTimeout
+	}
+	if s.handshakes != nil {
+		return s.handshakes.config.Timeout
+	}
+
+	return 0
+}
+
+func (s *server) rendezvousEnabled() bool {
+	return s.rendezvous
+}
+
+func (s *server) getSTUNAgent() *stunAgent {
+	return s.stun
+}
+
 func (s *server) stop() {
 	var (
 		err error
@@ -133,19 +243,36 @@ func (s *server) stop() {
 			}
 			s.server = nil
 			s.lock.Unlock()
+			// the listeners are not reset, for the accept loop reads them concurrently
 			if s.streamListener != nil {
 				// let the server exit asap when got error from RunEventLoop.
 				s.streamListener.Close()
-				s.streamListener = nil
 			}
 			if s.pktListener != nil {
 				s.pktListener.Close()
-				s.pktListener = nil
 			}
+			if s.watchdog != nil {
+				s.watchdog.close()
+			}
+			if s.handshakes != nil {
+				s.handshakes.close()
+			}
+			s.closeSessions()
 		})
 	}
 }
 
+// Drain stops accepting new connections and keeps the existing sessions alive.
+func (s *server) Drain() {
+	if atomic.CompareAndSwapInt32(&s.draining, 0, 1) {
+		log.Info("server{%s} is draining", s.addr)
+	}
+}
+
+func (s *server) IsDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
 func (s *server) IsClosed() bool {
 	select {
 	case <-s.done:
@@ -164,9 +291,9 @@ func (s *server) listenTCP() error {
 		streamListener net.Listener
 	)
 
-	streamListener, err = net.Listen("tcp", s.addr)
+	streamListener, _, err = listenByStack(s.ipStack, "tcp", s.addr)
 	if err != nil {
-		return jerrors.Annotatef(err, "net.Listen(tcp, addr:%s))", s.addr)
+		return jerrors.Annotatef(err, "listen(tcp, addr:%s, ip stack:%s)", s.addr, s.ipStack)
 	}
 
 	s.streamListener = streamListener
@@ -177,17 +304,12 @@ func (s *server) listenTCP() error {
 func (s *server) listenUDP() error {
 	var (
 		err         error
-		localAddr   *net.UDPAddr
-		pktListener *net.UDPConn
+		pktListener net.PacketConn
 	)
 
-	localAddr, err = net.ResolveUDPAddr("udp", s.addr)
-	if err != nil {
-		return jerrors.Annotatef(err, "net.ResolveUDPAddr(udp, addr:%s)", s.addr)
-	}
-	pktListener, err = net.ListenUDP("udp", localAddr)
+	_, pktListener, err = listenByStack(s.ipStack, "udp", s.addr)
 	if err != nil {
-		return jerrors.Annotatef(err, "net.ListenUDP((udp, localAddr:%#v)", localAddr)
+		return jerrors.Annotatef(err, "listen(udp, addr:%s, ip stack:%s)", s.addr, s.ipStack)
 	}
 
 	s.pktListener = pktListener
@@ -200,27 +322,76 @@ func (s *server) listen() error {
 	switch s.endPointType {
 	case TCP_SERVER, WS_SERVER, WSS_SERVER:
 		return jerrors.Trace(s.listenTCP())
+	case UNIX_SERVER:
+		return jerrors.Trace(s.listenUnix())
 	case UDP_ENDPOINT:
 		return jerrors.Trace(s.listenUDP())
+	case RAW_IP_ENDPOINT:
+		return jerrors.Trace(s.listenRawIP())
 	}
 
 	return nil
 }
 
 func (s *server) accept(newSession NewSessionCallback) (Session, error) {
+	conn, err := s.acceptConn()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.handshake(conn, newSession)
+}
+
+// accept a tcp connection which is neither banned nor self connected
+func (s *server) acceptConn() (net.Conn, error) {
 	conn, err := s.streamListener.Accept()
 	if err != nil {
 		return nil, jerrors.Trace(err)
 	}
-	if gxnet.IsSameAddr(conn.RemoteAddr(), conn.LocalAddr()) {
+	if s.IsDraining() {
+		conn.Close()
+		return nil, jerrors.Trace(errServerDraining)
+	}
+	if s.banList != nil && s.banList.IsBanned(conn.RemoteAddr().String()) {
+		s.audit(AuditBanReject, conn.RemoteAddr().String(), "")
+		s.tarpit.reject(conn, tarpitBanned)
+		return nil, jerrors.Annotatef(errRemoteBanned, "remote addr:%s", conn.RemoteAddr())
+	}
+	if err = s.admitConn(conn.RemoteAddr().String()); err != nil {
+		conn.Close()
+		return nil, jerrors.Annotatef(err, "remote addr:%s", conn.RemoteAddr())
+	}
+	// the addresses of the custom transport streams may be the same, e.g. a device name
+	if s.endPointType != TRANSPORT_SERVER && gxnet.IsSameAddr(conn.RemoteAddr(), conn.LocalAddr()) {
 		log.Warn("conn.localAddr{%s} == conn.RemoteAddr", conn.LocalAddr().String(), conn.RemoteAddr().String())
 		return nil, jerrors.Trace(errSelfConnect)
 	}
 
-	ss := newTCPSession(conn, s)
-	err = newSession(ss)
+	return conn, nil
+}
+
+// negotiate with the peer of @conn, and build its session
+func (s *server) handshake(conn net.Conn, newSession NewSessionCallback) (Session, error) {
+	ss, err := newNegotiatedTCPSession(conn, s, s.negotiation, false)
 	if err != nil {
+		s.metrics.recordHandshakeFailure(err, HandshakeFailureNegotiation)
+		s.tarpit.reject(conn, tarpitFailedHandshake)
+		return nil, jerrors.Annotatef(err, "negotiate with %s", conn.RemoteAddr())
+	}
+	switch s.endPointType {
+	case UNIX_SERVER:
+		ss.SetName(defaultUnixSessionName)
+	case TRANSPORT_SERVER:
+		ss.SetName(defaultTransportSessionName)
+	}
+	if err = ss.(*session).admitHandshake(nil); err != nil {
+		s.tarpit.reject(conn, tarpitFailedHandshake)
+		ss.(*session).discard()
+		return nil, jerrors.Trace(err)
+	}
+	if err = newSession(ss); err != nil {
 		conn.Close()
+		ss.(*session).discard()
 		return nil, jerrors.Trace(err)
 	}
 
@@ -245,7 +416,11 @@ func (s *server) runTcpEventLoop(newSession NewSessionCallback) {
 				// time.Sleep(delay)
 				<-wheel.After(delay)
 			}
-			client, err = s.accept(newSession)
+			if s.handshakes != nil {
+				err = s.acceptToPool(newSession)
+			} else {
+				client, err = s.accept(newSession)
+			}
 			if err != nil {
 				if netErr, ok := jerrors.Cause(err).(net.Error); ok && netErr.Temporary() {
 					if delay == 0 {
@@ -262,11 +437,34 @@ func (s *server) runTcpEventLoop(newSession NewSessionCallback) {
 				continue
 			}
 			delay = 0
-			client.(*session).run()
+			if client != nil {
+				client.(*session).run()
+			}
 		}
 	}()
 }
 
+// accept a tcp connection, and put its handshake into the handshake pool
+func (s *server) acceptToPool(newSession NewSessionCallback) error {
+	conn, err := s.acceptConn()
+	if err != nil {
+		return err
+	}
+
+	s.handshakes.submit(func(deadline time.Time) error {
+		conn.SetDeadline(deadline)
+		ss, err := s.handshake(conn, newSession)
+		if err != nil {
+			return err
+		}
+		conn.SetDeadline(time.Time{})
+		ss.(*session).run()
+		return nil
+	}, func() { s.tarpit.close(conn) })
+
+	return nil
+}
+
 func (s *server) runUDPEventLoop(newSession NewSessionCallback) {
 	s.wg.Add(1)
 	go func() {
@@ -295,15 +493,79 @@ type wsHandler struct {
 }
 
 func newWSHandler(server *server, newSession NewSessionCallback) *wsHandler {
-	return &wsHandler{
+	upgrader := websocket.Upgrader{
+		// in default, ReadBufferSize & WriteBufferSize is 4k
+		// HandshakeTimeout: server.HTTPTimeout,
+		CheckOrigin:       func(_ *http.Request) bool { return true }, // allow connections from any origin
+		EnableCompression: true,
+	}
+	if server.upgrader != nil {
+		upgrader = *server.upgrader
+	}
+	if len(server.origins) > 0 {
+		upgrader.CheckOrigin = newOriginChecker(server.origins)
+	}
+	if upgrader.HandshakeTimeout == 0 {
+		upgrader.HandshakeTimeout = server.getHandshakeTimeout()
+	}
+
+	handler := &wsHandler{
 		server:     server,
 		newSession: newSession,
-		upgrader: websocket.Upgrader{
-			// in default, ReadBufferSize & WriteBufferSize is 4k
-			// HandshakeTimeout: server.HTTPTimeout,
-			CheckOrigin:       func(_ *http.Request) bool { return true }, // allow connections from any origin
-			EnableCompression: true,
-		},
+		upgrader:   upgrader,
+	}
+	if server.healthPath != "" {
+		handler.HandleFunc(server.healthPath, handler.serveHealth)
+	}
+	if server.readyPath != "" {
+		handler.HandleFunc(server.readyPath, handler.serveReady)
+	}
+
+	return handler
+}
+
+// the server is healthy until it is closed
+func (s *wsHandler) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if s.server.IsClosed() {
+		http.Error(w, "closed", http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprint(w, "ok")
+}
+
+// the server is ready if it is neither closed nor draining
+func (s *wsHandler) serveReady(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	if s.server.IsClosed() || s.server.IsDraining() {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"closed":%t,"draining":%t,"sessions":%d}`,
+		s.server.IsClosed(), s.server.IsDraining(), s.server.metrics.Budget().SessionNum)
+}
+
+// the returned func allows requests whose Origin host is in @origins or which have no Origin header
+func newOriginChecker(origins []string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		for _, host := range origins {
+			if strings.EqualFold(u.Host, host) {
+				return true
+			}
+		}
+
+		log.Warn("websocket request origin{%s} is not allowed, remote addr:%s", origin, r.RemoteAddr)
+		return false
 	}
 }
 
@@ -319,9 +581,46 @@ func (s *wsHandler) serveWSRequest(w http.ResponseWriter, r *http.Request) {
 		log.Warn("server{%s} stop acceptting client connect request.", s.server.addr)
 		return
 	}
+	if s.server.IsDraining() {
+		http.Error(w, "HTTP server is draining(code:503).", http.StatusServiceUnavailable)
+		return
+	}
+	if s.server.banList != nil && s.server.banList.IsBanned(r.RemoteAddr) {
+		s.server.audit(AuditBanReject, r.RemoteAddr, "")
+		if s.server.tarpitBanned(w) {
+			return
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		log.Warn("server{%s} rejects banned remote addr %s", s.server.addr, r.RemoteAddr)
+		return
+	}
+	if s.server.admitConn(r.RemoteAddr) != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if pool := s.server.handshakes; pool != nil {
+		done := make(chan struct{})
+		pool.submit(func(deadline time.Time) error {
+			defer close(done)
+			s.upgrade(w, r)
+			return nil
+		}, func() {
+			defer close(done)
+			http.Error(w, "HTTP server is busy(code:503).", http.StatusServiceUnavailable)
+		})
+		<-done
+		return
+	}
 
+	s.upgrade(w, r)
+}
+
+// upgrade the websocket request, and build its session
+func (s *wsHandler) upgrade(w http.ResponseWriter, r *http.Request) {
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		s.server.metrics.recordHandshakeFailure(err, HandshakeFailureUpgrade)
 		log.Warn("upgrader.Upgrader(http.Request{%#v}) = error{%s}", r, err)
 		return
 	}
@@ -331,15 +630,17 @@ func (s *wsHandler) serveWSRequest(w http.ResponseWriter, r *http.Request) {
 	}
 	// conn.SetReadLimit(int64(handler.maxMsgLen))
 	ss := newWSSession(conn, s.server)
-	err = s.newSession(ss)
+	ss.(*session).setAccessRequest(r)
+	err = ss.(*session).admitHandshake(r)
+	if err == nil {
+		err = s.newSession(ss)
+	}
 	if err != nil {
 		conn.Close()
+		ss.(*session).discard()
 		log.Warn("server{%s}.newSession(ss{%#v}) = err {%s}", s.server.addr, ss, err)
 		return
 	}
-	if ss.(*session).maxMsgLen > 0 {
-		conn.SetReadLimit(int64(ss.(*session).maxMsgLen))
-	}
 	ss.(*session).run()
 }
 
@@ -362,6 +663,8 @@ func (s *server) runWSEventLoop(newSession NewSessionCallback) {
 			// ReadTimeout:    server.HTTPTimeout,
 			// WriteTimeout:   server.HTTPTimeout,
 		}
+		// the slow requests can not hold the connections before their upgrades
+		server.ReadHeaderTimeout = s.getHandshakeTimeout()
 		s.lock.Lock()
 		s.server = server
 		s.lock.Unlock()
@@ -389,15 +692,32 @@ func (s *server) runWSSEventLoop(newSession NewSessionCallback) {
 		)
 		defer s.wg.Done()
 
-		if certificate, err = tls.LoadX509KeyPair(s.cert, s.privateKey); err != nil {
-			panic(fmt.Sprintf("tls.LoadX509KeyPair(cert{%s}, privateKey{%s}) = err{%s}",
-				s.cert, s.privateKey, jerrors.ErrorStack(err)))
-		}
-		config = &tls.Config{
-			InsecureSkipVerify: true, // do not verify peer cert
-			ClientAuth:         tls.NoClientCert,
-			NextProtos:         []string{"http/1.1"},
-			Certificates:       []tls.Certificate{certificate},
+		if s.acme != nil {
+			config = acmeTLSConfig(s.acme)
+		} else {
+			if certificate, err = tls.LoadX509KeyPair(s.cert, s.privateKey); err != nil {
+				panic(fmt.Sprintf("tls.LoadX509KeyPair(cert{%s}, privateKey{%s}) = err{%s}",
+					s.cert, s.privateKey, jerrors.ErrorStack(err)))
+			}
+			config = &tls.Config{
+				InsecureSkipVerify: true, // do not verify peer cert
+				ClientAuth:         tls.NoClientCert,
+				NextProtos:         []string{"http/1.1"},
+				Certificates:       []tls.Certificate{certificate},
+			}
+			if s.ocsp != nil {
+				stapler, err := newOCSPStapler(s.ocsp, certificate)
+				if err != nil {
+					panic(fmt.Sprintf("newOCSPStapler(cert{%s}) = err{%s}", s.cert, jerrors.ErrorStack(err)))
+				}
+				config.Certificates = nil
+				config.GetCertificate = stapler.getCertificate
+				s.wg.Add(1)
+				go func() {
+					defer s.wg.Done()
+					stapler.run(s.done)
+				}()
+			}
 		}
 
 		if s.caCert != "" {
@@ -413,6 +733,8 @@ func (s *server) runWSSEventLoop(newSession NewSessionCallback) {
 			config.ClientAuth = tls.RequireAndVerifyClientCert
 			config.InsecureSkipVerify = false
 		}
+		config = tlsSettings(s.tlsSettings).apply(config)
+		config = withKeyLog(config, s.keyLog)
 
 		handler = newWSHandler(s, newSession)
 		handler.HandleFunc(s.path, handler.serveWSRequest)
@@ -422,12 +744,24 @@ func (s *server) runWSSEventLoop(newSession NewSessionCallback) {
 			// ReadTimeout:    server.HTTPTimeout,
 			// WriteTimeout:   server.HTTPTimeout,
 		}
+		// the slow requests can not hold the connections before their upgrades
+		server.ReadHeaderTimeout = s.getHandshakeTimeout()
 		server.SetKeepAlivesEnabled(true)
 		s.lock.Lock()
 		s.server = server
 		s.lock.Unlock()
-		err = server.Serve(tls.NewListener(s.streamListener, config))
-		if err != nil {
+		listener := s.streamListener
+		if s.tlsFingerprint {
+			listener = fingerprintListener{listener}
+		}
+		// the tls errors are audited & tarpitted by the handshake listener
+		if s.handshakes != nil || s.handshakeTimeout > 0 || s.auditLog != nil || s.tarpit != nil {
+			err = server.Serve(newHandshakeListener(listener, s, config))
+		} else {
+			err = server.Serve(tls.NewListener(listener, config))
+		}
+		// the server is closed by (server)Close
+		if err != nil && err != http.ErrServerClosed {
 			log.Error("http.server.Serve(addr{%s}) = err{%s}", s.addr, jerrors.ErrorStack(err))
 			panic(err)
 		}
@@ -437,15 +771,35 @@ func (s *server) runWSSEventLoop(newSession NewSessionCallback) {
 // RunEventLoop serves client request.
 // @newSession: new connection callback
 func (s *server) RunEventLoop(newSession NewSessionCallback) {
+	s.startCertMonitor()
 	if err := s.listen(); err != nil {
 		panic(fmt.Errorf("server.listen() = error:%s", jerrors.ErrorStack(err)))
 	}
 
 	switch s.endPointType {
-	case TCP_SERVER:
+	case TCP_SERVER, UNIX_SERVER, TRANSPORT_SERVER, WS_SERVER, WSS_SERVER:
+		if s.tarpit != nil {
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.tarpit.run(s.done)
+			}()
+		}
+		if config := s.handshakePoolConfig; config != nil && config.Timeout == 0 {
+			c := *config
+			c.Timeout = s.handshakeTimeout
+			s.handshakePoolConfig = &c
+		}
+		s.handshakes = newHandshakePool(s.handshakePoolConfig, s.metrics)
+	}
+
+	switch s.endPointType {
+	case TCP_SERVER, UNIX_SERVER, TRANSPORT_SERVER:
 		s.runTcpEventLoop(newSession)
 	case UDP_ENDPOINT:
 		s.runUDPEventLoop(newSession)
+	case RAW_IP_ENDPOINT:
+		s.runRawIPEventLoop(newSession)
 	case WS_SERVER:
 		s.runWSEventLoop(newSession)
 	case WSS_SERVER:
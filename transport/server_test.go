@@ -1,11 +1,13 @@
 package getty
 
 import (
+	"net/http/httptest"
 	"testing"
 	"time"
 )
 
 import (
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -51,6 +53,12 @@ func TestTCPServer(t *testing.T) {
 	time.Sleep(1e9)
 
 	assert.Equal(t, 1, msgHandler.SessionNumber())
+	budget := server.Metrics().Budget()
+	assert.Equal(t, int64(1), budget.SessionNum)
+	assert.Equal(t, int64(1), budget.ReadGoroutineNum)
+	assert.Equal(t, int64(1), budget.WriteGoroutineNum)
+	assert.True(t, budget.ReadBufferBytes >= maxReadBufLen)
+	assert.Equal(t, int64(1), clt.Metrics().Budget().SessionNum)
 	clt.Close()
 	assert.True(t, clt.IsClosed())
 
@@ -106,3 +114,70 @@ func TestUDPServer(t *testing.T) {
 	//server.Close()
 	//assert.True(t, server.IsClosed())
 }
+
+func TestWSServerUpgrader(t *testing.T) {
+	s := newServer(WS_SERVER, WithLocalAddress("127.0.0.1:0"))
+	handler := newWSHandler(s, nil)
+	assert.True(t, handler.upgrader.EnableCompression)
+	assert.True(t, handler.upgrader.CheckOrigin(httptest.NewRequest("GET", "/", nil)))
+
+	s = newServer(WS_SERVER,
+		WithLocalAddress("127.0.0.1:0"),
+		WithWebsocketServerUpgrader(websocket.Upgrader{ReadBufferSize: 1024, HandshakeTimeout: time.Second}),
+		WithWebsocketServerOrigins("example.com", "example.com:8080"),
+	)
+	handler = newWSHandler(s, nil)
+	assert.Equal(t, 1024, handler.upgrader.ReadBufferSize)
+	assert.Equal(t, time.Second, handler.upgrader.HandshakeTimeout)
+
+	for origin, allowed := range map[string]bool{
+		"":                         true,
+		"https://Example.com":      true,
+		"http://example.com:8080":  true,
+		"http://example.com:8081":  false,
+		"https://evil.example.com": false,
+		"%zz":                      false,
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		assert.Equal(t, allowed, handler.upgrader.CheckOrigin(r), origin)
+	}
+}
+
+func TestWSServerProbes(t *testing.T) {
+	s := newServer(WS_SERVER,
+		WithLocalAddress("127.0.0.1:0"),
+		WithWebsocketServerProbes("/healthz", "/readyz"),
+	)
+	handler := newWSHandler(s, nil)
+	probe := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	code, body := probe("/healthz")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "ok", body)
+	code, body = probe("/readyz")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, `{"closed":false,"draining":false,"sessions":0}`, body)
+
+	s.Drain()
+	assert.True(t, s.IsDraining())
+	code, _ = probe("/healthz")
+	assert.Equal(t, 200, code)
+	code, body = probe("/readyz")
+	assert.Equal(t, 503, code)
+	assert.Equal(t, `{"closed":false,"draining":true,"sessions":0}`, body)
+	w := httptest.NewRecorder()
+	handler.serveWSRequest(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, 503, w.Code)
+
+	s = newServer(WS_SERVER, WithLocalAddress("127.0.0.1:0"))
+	handler = newWSHandler(s, nil)
+	code, _ = probe("/healthz")
+	assert.Equal(t, 404, code)
+}
@@ -11,10 +11,12 @@ package getty
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"runtime"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -40,12 +42,17 @@ const (
 	maxIovecNum      = 10
 	MaxWheelTimeSpan = 900e9 // 900s, 15 minute
 
-	defaultSessionName    = "session"
-	defaultTCPSessionName = "tcp-session"
-	defaultUDPSessionName = "udp-session"
-	defaultWSSessionName  = "ws-session"
-	defaultWSSSessionName = "wss-session"
-	outputFormat          = "session %s, Read Bytes: %d, Write Bytes: %d, Read Pkgs: %d, Write Pkgs: %d"
+	defaultSessionName          = "session"
+	defaultTCPSessionName       = "tcp-session"
+	defaultUDPSessionName       = "udp-session"
+	defaultWSSessionName        = "ws-session"
+	defaultWSSSessionName       = "wss-session"
+	defaultRawIPSessionName     = "raw-ip-session"
+	defaultSerialSessionName    = "serial-session"
+	defaultBluetoothSessionName = "bluetooth-session"
+	defaultUnixSessionName      = "unix-session"
+	defaultTransportSessionName = "transport-session"
+	outputFormat                = "session %s, Read Bytes: %d, Write Bytes: %d, Read Pkgs: %d, Write Pkgs: %d"
 )
 
 /////////////////////////////////////////
@@ -81,12 +88,97 @@ type session struct {
 
 	// read & write
 	wQ chan interface{}
+	// it is set when WritePkg failed for @wQ was full
+	wQFull uint32
+	// it is set when IsCongested returned true, see congestion.go
+	congested      uint32
+	congestionHigh float64
+	congestionLow  float64
 
 	// handle logic
 	maxMsgLen int32
 	// task queue
 	tPool *gxsync.TaskPool
 
+	// shadow traffic
+	mirror     *session
+	mirrorDrop uint32
+
+	// endpoint statistics
+	metrics   *EndPointMetrics
+	sampleSeq uint32
+	// slow OnMessage watchdog of the endpoint
+	watchdog *handlerWatchdog
+	// write queue starvation monitor, see starvation.go
+	starvationThreshold time.Duration
+	starvation          *sessionStarvation
+	// remote ip ban list of the server
+	banList *BanList
+	// rendezvous registry of the udp endpoint
+	rendezvous *rendezvous
+	// stun binding client of the udp endpoint
+	stun *stunAgent
+	// nat keepalive interval of the udp client session
+	natKeepAlive time.Duration
+	// memory budget & the bytes accounted by the session, see memory.go
+	memBudget   *MemoryBudget
+	memInbound  int64
+	memOutbound int64
+	// journal of the session events, see journal.go
+	journal *Journal
+	// audit log of the security-relevant events, see audit.go
+	auditLog *AuditLog
+	// access log of the server session, see accesslog.go
+	accessLog     *AccessLog
+	accessStart   time.Time
+	accessRequest string
+	userAgent     string
+	// active session registry of the endpoint dumped on panic, see panicdump.go
+	panicDump *panicDumper
+	// the creation of the session recorded by the leak detector, see leak.go
+	leak *leakRecord
+	// the negotiation result of a tcp session
+	negotiated *Negotiated
+	// latency probing, see probe.go
+	prober *latencyProber
+	// read loop knobs of a tcp session, see readloop.go
+	readLoop ReadLoopConfig
+	// desync recovery of a tcp session, see desync.go
+	resync ResyncConfig
+	// the magic prefix of the first pkg, which is nil after it is validated, see magic.go
+	magic []byte
+	// busy polling read mode of the endpoint, see busypoll.go
+	busyPoll *BusyPollConfig
+	// rate limited logger of the application handlers, see sessionlog.go
+	logger *SessionLogger
+	// the compression switched at the boundary frames, see codecswitch.go
+	readCompress  *CompressType
+	writeCompress *CompressType
+	// the queued pkgs by conflation key, see conflate.go
+	conflateLock sync.Mutex
+	conflated    map[interface{}]*conflatedPkg
+	// socket buffer auto tuning, see buftune.go
+	bufferTune *bufferTuner
+	// the resource group of the tenant, see resgroup.go
+	resGroup     *resourceGroup
+	resGroupLeft int32
+	// admission & qos policy of the endpoint, see policy.go
+	policy Policy
+	// client identity, see identity.go. identityPending is only accessed by the read goroutine
+	// after the handshake.
+	identityConfig  *IdentityConfig
+	identity        Identity
+	identityPending bool
+	// frame timestamping, see timestamp.go. recvTime is the time of the current read.
+	stampFrames bool
+	recvTime    time.Time
+	// increased on every listener swap
+	listenerSeq uint32
+	// unique id for logs and tracing
+	sessionID string
+	// rolling rates of the connection statistic counters
+	rates *rateMeter
+
 	// heartbeat
 	period time.Duration
 
@@ -99,10 +191,15 @@ type session struct {
 	attrs *gxcontext.ValuesContext
 
 	// goroutines sync
-	grNum int32
+	grNum   int32
+	running int32
 	// read goroutines done signal
 	rDone chan struct{}
-	lock  sync.RWMutex
+	// write goroutines done signal
+	wDone chan struct{}
+	// unix nano deadline of CloseContext
+	closeDeadline int64
+	lock          sync.RWMutex
 }
 
 func newSession(endPoint EndPoint, conn Connection) *session {
@@ -121,11 +218,84 @@ func newSession(endPoint EndPoint, conn Connection) *session {
 		wait:  pendingDuration,
 		attrs: gxcontext.NewValuesContext(nil),
 		rDone: make(chan struct{}),
+		wDone: make(chan struct{}),
+		leak:  newLeakRecord(),
+
+		starvation: &sessionStarvation{},
+	}
+
+	if endPoint != nil {
+		ss.metrics = endPoint.Metrics()
+	}
+	if ss.metrics == nil {
+		// the metrics are updated without nil check, so a session without endpoint has its own
+		ss.metrics = newEndPointMetrics(0)
+	}
+	if owner, ok := endPoint.(interface{ handlerWatchdog() *handlerWatchdog }); ok {
+		ss.watchdog = owner.handlerWatchdog()
+	}
+	if owner, ok := endPoint.(interface{ writeStarvationThreshold() time.Duration }); ok {
+		ss.starvationThreshold = owner.writeStarvationThreshold()
+	}
+	if owner, ok := endPoint.(interface{ getBanList() *BanList }); ok {
+		ss.banList = owner.getBanList()
+	}
+	if owner, ok := endPoint.(interface{ getMemoryBudget() *MemoryBudget }); ok {
+		ss.memBudget = owner.getMemoryBudget()
+	}
+	if owner, ok := endPoint.(interface{ getJournal() *Journal }); ok {
+		ss.journal = owner.getJournal()
+	}
+	if owner, ok := endPoint.(interface{ getAuditLog() *AuditLog }); ok {
+		ss.auditLog = owner.getAuditLog()
+	}
+	if owner, ok := endPoint.(interface{ getAccessLog() *AccessLog }); ok {
+		ss.accessLog = owner.getAccessLog()
+	}
+	if owner, ok := endPoint.(interface{ getMagic() []byte }); ok && len(owner.getMagic()) > 0 {
+		ss.magic = owner.getMagic()
+	}
+	if owner, ok := endPoint.(interface{ getPanicDumper() *panicDumper }); ok {
+		ss.panicDump = owner.getPanicDumper()
+	}
+	if owner, ok := endPoint.(interface{ getBusyPoll() *BusyPollConfig }); ok {
+		ss.busyPoll = owner.getBusyPoll()
+	}
+	var logConfig SessionLogConfig
+	if owner, ok := endPoint.(interface{ getSessionLogConfig() SessionLogConfig }); ok {
+		logConfig = owner.getSessionLogConfig()
+	}
+	ss.logger = newSessionLogger(ss, logConfig)
+	if owner, ok := endPoint.(interface{ getPolicy() Policy }); ok {
+		ss.policy = owner.getPolicy()
+	}
+	if owner, ok := endPoint.(interface{ getIdentityConfig() *IdentityConfig }); ok {
+		ss.identityConfig = owner.getIdentityConfig()
+	}
+	if owner, ok := endPoint.(interface{ rendezvousEnabled() bool }); ok && owner.rendezvousEnabled() {
+		ss.rendezvous = newRendezvous()
+	}
+	if owner, ok := endPoint.(interface{ getSTUNAgent() *stunAgent }); ok {
+		ss.stun = owner.getSTUNAgent()
+	}
+	if owner, ok := endPoint.(interface{ natKeepAliveInterval() time.Duration }); ok {
+		ss.natKeepAlive = owner.natKeepAliveInterval()
+	}
+	if owner, ok := endPoint.(interface{ sessionIDGenerator() SessionIDGenerator }); ok {
+		if generator := owner.sessionIDGenerator(); generator != nil {
+			ss.sessionID = generator.NextID()
+		}
+	}
+	if ss.sessionID == "" {
+		ss.sessionID = strconv.FormatUint(uint64(conn.ID()), 10)
 	}
 
 	ss.Connection.setSession(ss)
 	ss.SetWriteTimeout(netIOTimeout)
 	ss.SetReadTimeout(netIOTimeout)
+	if conn := ss.gettyConn(); conn != nil {
+		ss.rates = newRateMeter(conn)
+	}
 
 	return ss
 }
@@ -156,13 +326,18 @@ func newWSSession(conn *websocket.Conn, endPoint EndPoint) Session {
 
 func (s *session) Reset() {
 	*s = session{
-		name:   defaultSessionName,
-		once:   &sync.Once{},
-		done:   make(chan struct{}),
-		period: period,
-		wait:   pendingDuration,
-		attrs:  gxcontext.NewValuesContext(nil),
-		rDone:  make(chan struct{}),
+		// the endpoint is reset, so do not update its metrics any more
+		metrics: newEndPointMetrics(0),
+		name:    defaultSessionName,
+		once:    &sync.Once{},
+		done:    make(chan struct{}),
+		period:  period,
+		wait:    pendingDuration,
+		attrs:   gxcontext.NewValuesContext(nil),
+		rDone:   make(chan struct{}),
+		wDone:   make(chan struct{}),
+
+		starvation: &sessionStarvation{},
 	}
 }
 
@@ -180,6 +355,10 @@ func (s *session) Conn() net.Conn {
 		return wc.conn.UnderlyingConn()
 	}
 
+	if ic, ok := s.Connection.(*gettyIPConn); ok {
+		return ic.conn
+	}
+
 	return nil
 }
 
@@ -187,6 +366,10 @@ func (s *session) EndPoint() EndPoint {
 	return s.endPoint
 }
 
+func (s *session) SessionID() string {
+	return s.sessionID
+}
+
 func (s *session) gettyConn() *gettyConn {
 	if tc, ok := s.Connection.(*gettyTCPConn); ok {
 		return &(tc.gettyConn)
@@ -200,6 +383,10 @@ func (s *session) gettyConn() *gettyConn {
 		return &(wc.gettyConn)
 	}
 
+	if ic, ok := s.Connection.(*gettyIPConn); ok {
+		return &(ic.gettyConn)
+	}
+
 	return nil
 }
 
@@ -219,6 +406,30 @@ func (s *session) Stat() string {
 	)
 }
 
+// get the rolling rates of the session
+func (s *session) Rates() SessionRates {
+	if s.rates == nil {
+		return SessionRates{}
+	}
+
+	return s.rates.rates()
+}
+
+// the rolling rates will be sampled when the packet counters are updated
+func (s *session) incReadPkgNum() {
+	s.Connection.incReadPkgNum()
+	if s.rates != nil {
+		s.rates.tick()
+	}
+}
+
+func (s *session) incWritePkgNum() {
+	s.Connection.incWritePkgNum()
+	if s.rates != nil {
+		s.rates.tick()
+	}
+}
+
 // check whether the session has been closed.
 func (s *session) IsClosed() bool {
 	select {
@@ -246,15 +457,38 @@ func (s *session) SetName(name string) {
 	s.name = name
 }
 
-// set EventListener
+// set EventListener. It can be invoked at runtime, e.g. in OnMessage of the handshake listener,
+// and the new listener will receive the pkgs decoded after the swap.
 func (s *session) SetEventListener(listener EventListener) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
 	s.listener = listener
+	s.listenerSeq++
+}
+
+// set EventListenerV2
+func (s *session) SetEventListenerV2(listener EventListenerV2) {
+	s.SetEventListener(newEventListenerV1(listener))
+}
+
+func (s *session) getListener() EventListener {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.listener
 }
 
-// set package handler
+// get the listener and its sequence, which is increased on every listener swap.
+func (s *session) listenerState() (EventListener, uint32) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.listener, s.listenerSeq
+}
+
+// set package handler. It can be invoked at runtime, and the swap takes effect at the frame boundary:
+// if it is invoked in (Reader)Read, the next frame will be decoded by the new handler.
 func (s *session) SetPkgHandler(handler ReadWriter) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -263,7 +497,7 @@ func (s *session) SetPkgHandler(handler ReadWriter) {
 	s.writer = handler
 }
 
-// set Reader
+// set Reader. Its swap semantics are the same as SetPkgHandler.
 func (s *session) SetReader(reader Reader) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -279,6 +513,35 @@ func (s *session) SetWriter(writer Writer) {
 	s.writer = writer
 }
 
+// Transfer hands the session over to @handler and @listener atomically, e.g. from the handshake
+// handler to a protocol-specific handler after negotiation. Invoke it in (Reader)Read when the last
+// handshake frame is decoded, then the following frames, even those in the same read buffer, will be
+// decoded by @handler and delivered to @listener. The pkgs decoded before will still be delivered
+// to the old listener. The OnOpen of @listener will not be invoked.
+func (s *session) Transfer(handler ReadWriter, listener EventListener) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.reader = handler
+	s.writer = handler
+	s.listener = listener
+	s.listenerSeq++
+}
+
+func (s *session) getReader() Reader {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.reader
+}
+
+func (s *session) getWriter() Writer {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.writer
+}
+
 // period is in millisecond. Websocket session will send ping frame automatically every peroid.
 func (s *session) SetCronPeriod(period int) {
 	if period < 1 {
@@ -324,6 +587,62 @@ func (s *session) SetTaskPool(p *gxsync.TaskPool) {
 	s.tPool = p
 }
 
+// set mirror session. every inbound pkg of @s will be put into the write queue of @sink
+// without blocking, and it will be dropped if the write queue of @sink is full.
+// the pkg will be encoded by the Writer of @sink. set @sink as nil to stop mirroring.
+func (s *session) SetMirrorSession(sink Session) {
+	var mirror *session
+	if sink != nil {
+		ss, ok := sink.(*session)
+		if !ok {
+			panic(fmt.Sprintf("illegal mirror session type %T", sink))
+		}
+		if ss == s {
+			panic("can not mirror session to itself")
+		}
+		mirror = ss
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.mirror = mirror
+}
+
+// put @pkg into the write queue without blocking. it returns false if the
+// session has been closed or its write queue is full.
+func (s *session) offerPkg(pkg interface{}) bool {
+	if s.IsClosed() {
+		return false
+	}
+
+	// session.gc resets @s.wQ under the lock before closing it, so the send will never
+	// hit a closed channel while the read lock is held.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.wQ == nil {
+		return false
+	}
+	select {
+	case s.wQ <- pkg:
+		s.accountQueuedPkg(pkg, 1)
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *session) mirrorPkg(mirror *session, pkg interface{}) {
+	if mirror.offerPkg(pkg) {
+		return
+	}
+
+	if num := atomic.AddUint32(&s.mirrorDrop, 1); num&(num-1) == 0 {
+		// only log when the drop number is a power of 2, in case of log flood
+		log.Warn("%s, [session.mirrorPkg] mirror session %s is overloaded, drop pkg num:%d",
+			s.sessionToken(), mirror.sessionToken(), num)
+	}
+}
+
 // set attribute of key @session:key
 func (s *session) GetAttribute(key interface{}) interface{} {
 	s.lock.RLock()
@@ -364,11 +683,92 @@ func (s *session) sessionToken() string {
 		return "session-closed"
 	}
 
-	return fmt.Sprintf("{%s:%s:%d:%s<->%s}",
-		s.name, s.EndPoint().EndPointType(), s.ID(), s.LocalAddr(), s.RemoteAddr())
+	return fmt.Sprintf("{%s:%s:%s:%s<->%s}",
+		s.name, s.EndPoint().EndPointType(), s.sessionID, s.LocalAddr(), s.RemoteAddr())
+}
+
+// return current time if the latency of current read/write operation should be sampled.
+func (s *session) sampleTime() time.Time {
+	if !s.metrics.sample(&s.sampleSeq) {
+		return time.Time{}
+	}
+
+	return time.Now()
+}
+
+func (s *session) recordReadLatency(start time.Time) {
+	if !start.IsZero() {
+		s.metrics.ReadLatency.Record(time.Since(start))
+	}
+}
+
+func (s *session) recordWriteLatency(start time.Time) {
+	if !start.IsZero() {
+		s.metrics.WriteLatency.Record(time.Since(start))
+	}
+}
+
+// queuedPkg carries the metadata of a pkg in the session write queue
+type queuedPkg struct {
+	pkg interface{}
+	// WritePkg time of a latency sampled pkg
+	start time.Time
+	// the time when the pkg is queued if the starvation is monitored
+	queued time.Time
+	// the pkg will be dropped if @ctx is done before it is sent
+	ctx context.Context
+	// the pkg will be dropped if it is not sent before @expire
+	expire time.Time
+}
+
+func unwrapQueuedPkg(pkg interface{}) queuedPkg {
+	if p, ok := pkg.(queuedPkg); ok {
+		return p
+	}
+
+	return queuedPkg{pkg: pkg}
+}
+
+// the reason why the pkg should not be sent anymore
+func (p queuedPkg) dropReason() error {
+	if p.ctx != nil {
+		if err := p.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if !p.expire.IsZero() && !time.Now().Before(p.expire) {
+		return ErrPkgExpired
+	}
+
+	return nil
+}
+
+// count the queued pkg dropped for @reason
+func (s *session) countDroppedPkg(reason error) {
+	if reason == ErrPkgExpired {
+		atomic.AddUint64(&s.metrics.expiredPkgNum, 1)
+	}
 }
 
 func (s *session) WritePkg(pkg interface{}, timeout time.Duration) error {
+	return s.queuePkg(pkg, time.Time{}, timeout)
+}
+
+// WritePkgTTL puts @pkg into the write queue like WritePkg, and @pkg is dropped instead of
+// being sent late if it is still in the queue after @ttl, e.g. a real-time position update
+// which is useless after 200ms. The expired pkgs are counted by (EndPointMetrics)ExpiredPkgNum.
+// @pkg never expires if @ttl is not greater than 0, and it is sent at once without expiry if
+// @timeout is not greater than 0.
+func (s *session) WritePkgTTL(pkg interface{}, ttl time.Duration, timeout time.Duration) error {
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+
+	return s.queuePkg(pkg, expire, timeout)
+}
+
+func (s *session) queuePkg(pkg interface{}, expire time.Time, timeout time.Duration) error {
 	if pkg == nil {
 		return fmt.Errorf("@pkg is nil")
 	}
@@ -385,45 +785,157 @@ func (s *session) WritePkg(pkg interface{}, timeout time.Duration) error {
 		}
 	}()
 
-	if timeout <= 0 {
-		pkgBytes, err := s.writer.Write(s, pkg)
-		if err != nil {
-			log.Warn("%s, [session.WritePkg] session.writer.Write(@pkg:%#v) = error:%v", s.Stat(), pkg, err)
-			return jerrors.Trace(err)
-		}
+	if err := s.admitWrite(); err != nil {
+		return err
+	}
+	if err := s.admitPolicyWrite(pkg); err != nil {
+		return err
+	}
 
-		var udpCtxPtr *UDPContext
-		if udpCtx, ok := pkg.(UDPContext); ok {
-			udpCtxPtr = &udpCtx
-		} else if udpCtxP, ok := pkg.(*UDPContext); ok {
-			udpCtxPtr = udpCtxP
-		}
-		if udpCtxPtr != nil {
-			udpCtxPtr.Pkg = pkgBytes
-			pkg = *udpCtxPtr
-		} else {
-			pkg = pkgBytes
-		}
-		_, err = s.Connection.send(pkg)
-		if err != nil {
-			log.Warn("%s, [session.WritePkg] @s.Connection.Write(pkg:%#v) = err:%v", s.Stat(), pkg, err)
-			return jerrors.Trace(err)
+	start := s.sampleTime()
+	if timeout <= 0 {
+		err := s.writePkg(pkg)
+		if err == nil {
+			s.recordWriteLatency(start)
 		}
-		s.incWritePkgNum()
-		return nil
+		return err
+	}
+	if queued := s.queueTime(); !start.IsZero() || !expire.IsZero() || !queued.IsZero() {
+		pkg = queuedPkg{pkg: pkg, start: start, expire: expire, queued: queued}
 	}
 	select {
 	case s.wQ <- pkg:
+		s.accountQueuedPkg(pkg, 1)
 		break // for possible gen a new pkg
 
 	case <-wheel.After(timeout):
-		log.Warn("%s, [session.WritePkg] wQ{len:%d, cap:%d}", s.Stat(), len(s.wQ), cap(s.wQ))
-		return ErrSessionBlocked
+		sampledWarn("%s, [session.WritePkg] wQ{len:%d, cap:%d}", s.Stat(), len(s.wQ), cap(s.wQ))
+		atomic.StoreUint32(&s.wQFull, 1)
+		return ErrQueueFull
 	}
 
 	return nil
 }
 
+// WritePkgContext puts @pkg into the write queue. It blocks until @pkg has been queued
+// or @ctx is done, and @pkg will be dropped if @ctx is done before it is sent.
+func (s *session) WritePkgContext(ctx context.Context, pkg interface{}) (err error) {
+	if pkg == nil {
+		return fmt.Errorf("@pkg is nil")
+	}
+	if s.IsClosed() {
+		return ErrSessionClosed
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = s.admitWrite(); err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			const size = 64 << 10
+			rBuf := make([]byte, size)
+			rBuf = rBuf[:runtime.Stack(rBuf, false)]
+			log.Error("[session.WritePkgContext] panic session %s: err=%s\n%s", s.sessionToken(), r, rBuf)
+			err = ErrSessionClosed
+		}
+	}()
+	if err = s.admitPolicyWrite(pkg); err != nil {
+		return err
+	}
+
+	select {
+	case s.wQ <- queuedPkg{pkg: pkg, start: s.sampleTime(), ctx: ctx, queued: s.queueTime()}:
+		s.accountQueuedPkg(pkg, 1)
+		return nil
+
+	case <-s.done:
+		return ErrSessionClosed
+
+	case <-ctx.Done():
+		sampledWarn("%s, [session.WritePkgContext] wQ{len:%d, cap:%d}, ctx error:%v",
+			s.Stat(), len(s.wQ), cap(s.wQ), ctx.Err())
+		atomic.StoreUint32(&s.wQFull, 1)
+		return ctx.Err()
+	}
+}
+
+// encode @pkg by the writer of the session. the probe frames are not encoded.
+func (s *session) encode(pkg interface{}) ([]byte, error) {
+	if p, ok := pkg.(*conflatedPkg); ok {
+		pkg = s.takeConflated(p)
+	}
+	if p, ok := pkg.(probeFrame); ok {
+		return s.frameMessage(pkg, p)
+	}
+	if p, ok := pkg.(codecSwitchPkg); ok {
+		return s.encodeCodecSwitch(p)
+	}
+
+	pkgBytes, err := s.getWriter().Write(s, pkg)
+	if err != nil {
+		return nil, err
+	}
+	return s.frameMessage(pkg, pkgBytes)
+}
+
+// encode @pkg and send it out immediately.
+func (s *session) writePkg(pkg interface{}) error {
+	defer func() {
+		if r := recover(); r != nil {
+			const size = 64 << 10
+			rBuf := make([]byte, size)
+			rBuf = rBuf[:runtime.Stack(rBuf, false)]
+			log.Error("[session.writePkg] panic session %s: err=%s\n%s", s.sessionToken(), r, rBuf)
+		}
+	}()
+
+	if p, ok := pkg.(*conflatedPkg); ok {
+		pkg = s.takeConflated(p)
+	}
+	sentPkg := pkg
+	pkgBytes, err := s.encode(pkg)
+	if err != nil {
+		sampledWarn("%s, [session.WritePkg] session.writer.Write(@pkg:%#v) = error:%v", s.Stat(), pkg, err)
+		return jerrors.Trace(err)
+	}
+
+	var udpCtxPtr *UDPContext
+	if udpCtx, ok := pkg.(UDPContext); ok {
+		udpCtxPtr = &udpCtx
+	} else if udpCtxP, ok := pkg.(*UDPContext); ok {
+		udpCtxPtr = udpCtxP
+	}
+	var ipCtxPtr *IPContext
+	if ipCtx, ok := pkg.(IPContext); ok {
+		ipCtxPtr = &ipCtx
+	} else if ipCtxP, ok := pkg.(*IPContext); ok {
+		ipCtxPtr = ipCtxP
+	}
+	if udpCtxPtr != nil {
+		udpCtxPtr.Pkg = pkgBytes
+		pkg = *udpCtxPtr
+	} else if ipCtxPtr != nil {
+		ipCtxPtr.Pkg = pkgBytes
+		pkg = *ipCtxPtr
+	} else {
+		pkg = pkgBytes
+	}
+	_, err = s.Connection.send(pkg)
+	if err != nil {
+		sampledWarn("%s, [session.WritePkg] @s.Connection.Write(pkg:%#v) = err:%v", s.Stat(), pkg, err)
+		return writeError(err)
+	}
+	s.incWritePkgNum()
+	s.applyWriteCompress()
+	s.stampSent(sentPkg)
+	s.reportTxTimestamp(sentPkg)
+	s.throttleWrite(len(pkgBytes))
+	return nil
+}
+
 // for codecs
 func (s *session) WriteBytes(pkg []byte) error {
 	if s.IsClosed() {
@@ -432,7 +944,7 @@ func (s *session) WriteBytes(pkg []byte) error {
 
 	// s.conn.SetWriteTimeout(time.Now().Add(s.wTimeout))
 	if _, err := s.Connection.send(pkg); err != nil {
-		return jerrors.Annotatef(err, "s.Connection.Write(pkg len:%d)", len(pkg))
+		return writeError(jerrors.Annotatef(err, "s.Connection.Write(pkg len:%d)", len(pkg)))
 	}
 
 	s.incWritePkgNum()
@@ -454,7 +966,7 @@ func (s *session) WriteBytesArray(pkgs ...[]byte) error {
 	// reduce syscall and memcopy for multiple packages
 	if _, ok := s.Connection.(*gettyTCPConn); ok {
 		if _, err := s.Connection.send(pkgs); err != nil {
-			return jerrors.Annotatef(err, "s.Connection.Write(pkgs num:%d)", len(pkgs))
+			return writeError(jerrors.Annotatef(err, "s.Connection.Write(pkgs num:%d)", len(pkgs)))
 		}
 	}
 
@@ -484,7 +996,7 @@ func (s *session) WriteBytesArray(pkgs ...[]byte) error {
 	}
 
 	if err = s.WriteBytes(arr); err != nil {
-		return jerrors.Trace(err)
+		return traceError(err)
 	}
 
 	num := len(pkgs) - 1
@@ -495,6 +1007,11 @@ func (s *session) WriteBytesArray(pkgs ...[]byte) error {
 	return nil
 }
 
+// release the resources acquired in NewSessionCallback of a rejected session
+func (s *session) discard() {
+	s.leaveResourceGroup()
+}
+
 // func (s *session) RunEventLoop() {
 func (s *session) run() {
 	if s.Connection == nil || s.listener == nil || s.writer == nil {
@@ -513,11 +1030,29 @@ func (s *session) run() {
 	if err := s.listener.OnOpen(s); err != nil {
 		log.Error("[OnOpen] session %s, error: %#v", s.Stat(), err)
 		s.Close()
+		s.discard()
 		return
 	}
 
+	if s.memBudget != nil {
+		s.memBudget.register(s)
+	}
+	s.journalEvent(JournalOpen, nil, 0)
+	s.accessStart = time.Now()
+	if s.panicDump != nil {
+		s.panicDump.register(s)
+	}
+	if tracker, ok := s.endPoint.(sessionTracker); ok {
+		tracker.addSession(s)
+	}
+	s.trackLeak()
+
 	// start read/write gr
+	atomic.AddInt64(&s.metrics.sessionNum, 1)
+	atomic.AddInt64(&s.metrics.readGoroutineNum, 1)
+	atomic.AddInt64(&s.metrics.writeGoroutineNum, 1)
 	atomic.AddInt32(&(s.grNum), 2)
+	atomic.StoreInt32(&s.running, 1)
 	go s.handleLoop()
 	go s.handlePackage()
 }
@@ -529,12 +1064,20 @@ func (s *session) handleLoop() {
 		flag     bool
 		wsFlag   bool
 		udpFlag  bool
+		ipFlag   bool
 		loopFlag bool
 		wsConn   *gettyWSConn
+		udpConn  *gettyUDPConn
 		counter  gxtime.CountWatch
+		keepCh   <-chan struct{}
+		tuneCh   <-chan struct{}
+		probeCh  <-chan struct{}
 		outPkg   interface{}
 		pkgBytes []byte
 		iovec    [][]byte
+		qPkg     queuedPkg
+		starts   []time.Time
+		sent     []interface{}
 	)
 
 	defer func() {
@@ -543,18 +1086,38 @@ func (s *session) handleLoop() {
 			rBuf := make([]byte, size)
 			rBuf = rBuf[:runtime.Stack(rBuf, false)]
 			log.Error("[session.handleLoop] panic session %s: err=%s\n%s", s.sessionToken(), r, rBuf)
+			s.dumpPanic(r, rBuf)
 		}
 
 		grNum := atomic.AddInt32(&(s.grNum), -1)
-		s.listener.OnClose(s)
+		atomic.AddInt64(&s.metrics.writeGoroutineNum, -1)
+		atomic.AddInt64(&s.metrics.sessionNum, -1)
+		s.getListener().OnClose(s)
+		s.untrackLeak()
+		s.journalEvent(JournalClose, nil, 0)
+		s.logSessionAccess()
 		log.Info("%s, [session.handleLoop] goroutine exit now, left gr num %d", s.Stat(), grNum)
 		s.gc()
+		close(s.wDone)
 	}()
 
 	flag = true // do not do any read/Write/cron operation while got Write error
 	wsConn, wsFlag = s.Connection.(*gettyWSConn)
-	_, udpFlag = s.Connection.(*gettyUDPConn)
+	udpConn, udpFlag = s.Connection.(*gettyUDPConn)
+	_, ipFlag = s.Connection.(*gettyIPConn)
 	iovec = make([][]byte, 0, maxIovecNum)
+	// unlike the cron timer, the tuning timer is not reset by writes, for a busy session
+	// needs the tuning most.
+	if s.bufferTune != nil {
+		tuneCh = wheel.After(s.bufferTune.config.Interval)
+	}
+	if s.prober != nil && s.prober.config.Interval > 0 {
+		probeCh = wheel.After(s.prober.config.Interval)
+	}
+	// the keepalive datagram is sent every interval, whose timer is re-armed only when it fires
+	if udpFlag && s.natKeepAlive > 0 {
+		keepCh = wheel.After(s.natKeepAlive)
+	}
 LOOP:
 	for {
 		// A select blocks until one of its cases is ready to run.
@@ -569,7 +1132,7 @@ LOOP:
 				break LOOP
 			}
 			counter.Start()
-			if counter.Count() > s.wait.Nanoseconds() {
+			if counter.Count() > s.wait.Nanoseconds() || s.closeDeadlineExceeded() {
 				log.Info("%s, [session.handleLoop] got done signal ", s.Stat())
 				break LOOP
 			}
@@ -578,34 +1141,68 @@ LOOP:
 			if !ok {
 				continue
 			}
+			s.accountQueuedPkg(outPkg, -1)
 			if !flag {
 				log.Warn("[session.handleLoop] drop write out package %#v", outPkg)
 				continue
 			}
 
-			if udpFlag || wsFlag {
-				err = s.WritePkg(outPkg, 0)
+			if udpFlag || wsFlag || ipFlag {
+				qPkg = unwrapQueuedPkg(outPkg)
+				s.recordQueueDelay(qPkg)
+				if err = qPkg.dropReason(); err != nil {
+					sampledWarn("%s, [session.handleLoop] drop write out package %#v, reason:%v",
+						s.sessionToken(), qPkg.pkg, err)
+					s.countDroppedPkg(err)
+					continue
+				}
+				err = s.writePkg(qPkg.pkg)
 				if err != nil {
-					log.Error("%s, [session.handleLoop] = error{%s}", s.sessionToken(), jerrors.ErrorStack(err))
+					sampledError("%s, [session.handleLoop] = error{%s}", s.sessionToken(), jerrors.ErrorStack(err))
+					s.notifyError(err, ErrorDirectionWrite)
 					s.stop()
 					// break LOOP
 					flag = false
+				} else {
+					s.recordWriteLatency(qPkg.start)
+					s.notifyWritable()
 				}
 
 				continue
 			}
 
 			iovec = iovec[:0]
+			starts = starts[:0]
+			sent = sent[:0]
 			for idx := 0; idx < maxIovecNum; idx++ {
-				pkgBytes, err = s.writer.Write(s, outPkg)
-				if err != nil {
-					log.Error("%s, [session.handleLoop] = error{%s}", s.sessionToken(), jerrors.ErrorStack(err))
-					s.stop()
-					// break LOOP
-					flag = false
-					break
+				qPkg = unwrapQueuedPkg(outPkg)
+				s.recordQueueDelay(qPkg)
+				if err = qPkg.dropReason(); err != nil {
+					sampledWarn("%s, [session.handleLoop] drop write out package %#v, reason:%v",
+						s.sessionToken(), qPkg.pkg, err)
+					s.countDroppedPkg(err)
+				} else {
+					if !qPkg.start.IsZero() {
+						starts = append(starts, qPkg.start)
+					}
+					pkgBytes, err = s.encode(qPkg.pkg)
+					if err != nil {
+						sampledError("%s, [session.handleLoop] = error{%s}", s.sessionToken(), jerrors.ErrorStack(err))
+						s.notifyError(err, ErrorDirectionWrite)
+						s.stop()
+						// break LOOP
+						flag = false
+						break
+					}
+					iovec = append(iovec, pkgBytes)
+					if s.stampFrames {
+						sent = append(sent, qPkg.pkg)
+					}
+					if s.writeCompressPending() {
+						// send the boundary pkg before the pkgs compressed by the new compression
+						break
+					}
 				}
-				iovec = append(iovec, pkgBytes)
 
 				if idx < maxIovecNum-1 {
 					loopFlag = true
@@ -613,6 +1210,8 @@ LOOP:
 					case outPkg, ok = <-s.wQ:
 						if !ok {
 							loopFlag = false
+						} else {
+							s.accountQueuedPkg(outPkg, -1)
 						}
 
 					default:
@@ -624,13 +1223,25 @@ LOOP:
 					}
 				}
 			}
+			if len(iovec) == 0 {
+				continue
+			}
 			err = s.WriteBytesArray(iovec[:]...)
 			if err != nil {
-				log.Error("%s, [session.handleLoop]s.WriteBytesArray(iovec len:%d) = error{%s}",
+				sampledError("%s, [session.handleLoop]s.WriteBytesArray(iovec len:%d) = error{%s}",
 					s.sessionToken(), len(iovec), jerrors.ErrorStack(err))
+				s.notifyError(err, ErrorDirectionWrite)
 				s.stop()
 				// break LOOP
 				flag = false
+			} else {
+				s.applyWriteCompress()
+				s.stampSent(sent...)
+				s.throttleWrite(iovecLen(iovec))
+				for _, start := range starts {
+					s.recordWriteLatency(start)
+				}
+				s.notifyWritable()
 			}
 
 		case <-wheel.After(s.period):
@@ -638,23 +1249,159 @@ LOOP:
 				if wsFlag {
 					err := wsConn.writePing()
 					if err != nil {
-						log.Warn("wsConn.writePing() = error{%s}", err)
+						sampledWarn("wsConn.writePing() = error{%s}", err)
 					}
 				}
-				s.listener.OnCron(s)
+				s.getListener().OnCron(s)
+				// the kernel send buffer may drain without any write of the session
+				s.notifyWritable()
 			}
+
+		case <-tuneCh:
+			tuneCh = nil
+			if t := s.bufferTune; t != nil {
+				if flag {
+					s.tuneBuffers()
+				}
+				tuneCh = wheel.After(t.config.Interval)
+			}
+
+		case <-probeCh:
+			probeCh = nil
+			if p := s.prober; p != nil && p.config.Interval > 0 {
+				if flag {
+					s.sendProbe()
+				}
+				probeCh = wheel.After(p.config.Interval)
+			}
+
+		case <-keepCh:
+			if flag {
+				if err := udpConn.writeKeepAlive(); err != nil {
+					sampledWarn("%s, udpConn.writeKeepAlive() = error{%s}", s.sessionToken(), jerrors.ErrorStack(err))
+				}
+			}
+			keepCh = wheel.After(s.natKeepAlive)
 		}
 	}
 }
 
-func (s *session) addTask(pkg interface{}) {
-	f := func() {
-		s.listener.OnMessage(s, pkg)
-		s.incReadPkgNum()
+// the total length of the buffers of @iovec
+func iovecLen(iovec [][]byte) int {
+	var n int
+	for _, b := range iovec {
+		n += len(b)
+	}
+	return n
+}
+
+// notify the listener that @s got @err. it returns false if the listener does not implement ErrorListener.
+func (s *session) notifyError(err error, direction ErrorDirection) bool {
+	s.journalEvent(JournalError, err, direction)
+	if s.panicDump != nil {
+		s.panicDump.setLastError(s, err)
+	}
+	listener, ok := s.getListener().(ErrorListener)
+	if !ok {
+		return false
+	}
+
+	listener.OnSessionError(s, err, direction)
+	return true
+}
+
+// report the codec error of the peer to the ban list of the server.
+func (s *session) reportProtocolError() {
+	if s.banList != nil && s.banList.Report(s.RemoteAddr(), BanTriggerProtocolError) {
+		s.audit(AuditBan, "trigger:"+BanTriggerProtocolError.String())
+	}
+}
+
+// notify the listener that @wQ has been drained after a WritePkg failed for it was full,
+// or that the session has turned writable after IsCongested returned true.
+func (s *session) notifyWritable() {
+	drained := len(s.wQ) == 0 && atomic.CompareAndSwapUint32(&s.wQFull, 1, 0)
+	if !s.clearCongested() && !drained {
+		return
+	}
+
+	if listener, ok := s.getListener().(writableEventListener); ok {
+		listener.OnWritable(s)
 	}
+}
+
+// @readTime is the arrival time of @pkg if its latency should be sampled, otherwise it is zero.
+func (s *session) addTask(pkg interface{}, readTime time.Time) {
+	s.addTasks([]interface{}{pkg}, readTime)
+}
+
+// deliver @pkgs decoded from one read in one task, so the task pool and the endpoint statistics
+// are touched once per read instead of once per pkg. @pkgs are delivered in one batch if the
+// listener supports it, otherwise they are delivered one by one in order.
+func (s *session) addTasks(pkgs []interface{}, readTime time.Time) {
+	s.deliverTasks(s.getListener(), pkgs, readTime)
+}
+
+// deliver @pkgs to @listener in one task.
+func (s *session) deliverTasks(listener EventListener, pkgs []interface{}, readTime time.Time) {
+	if pkgs = s.identifyFirstPkg(pkgs); len(pkgs) == 0 {
+		return
+	}
+
+	s.lock.RLock()
+	mirror := s.mirror
+	s.lock.RUnlock()
+	if mirror != nil {
+		for _, pkg := range pkgs {
+			s.mirrorPkg(mirror, pkg)
+		}
+	}
+	pkgs = s.stampPkgs(pkgs)
+
+	batchListener, batch := listener.(batchEventListener)
+	s.runTask(func() {
+		atomic.AddInt64(&s.metrics.runningTaskNum, 1)
+		defer atomic.AddInt64(&s.metrics.runningTaskNum, -1)
+
+		if batch {
+			if s.watchdog != nil {
+				s.watchdog.watch(s, listener, pkgs, func() { batchListener.OnMessages(s, pkgs) })
+			} else {
+				batchListener.OnMessages(s, pkgs)
+			}
+			for range pkgs {
+				s.incReadPkgNum()
+				s.recordReadLatency(readTime)
+			}
+			return
+		}
+
+		for _, pkg := range pkgs {
+			if s.watchdog != nil {
+				pkg := pkg
+				s.watchdog.watch(s, listener, pkg, func() { listener.OnMessage(s, pkg) })
+			} else {
+				listener.OnMessage(s, pkg)
+			}
+			s.incReadPkgNum()
+			s.recordReadLatency(readTime)
+		}
+	})
+}
 
+// run @f in the task pool if it exists
+func (s *session) runTask(f func()) {
 	if s.tPool != nil {
-		s.tPool.AddTask(f)
+		var ok bool
+		if f, ok = s.groupTask(f); !ok {
+			f()
+			return
+		}
+		atomic.AddInt64(&s.metrics.pendingTaskNum, 1)
+		s.tPool.AddTask(func() {
+			atomic.AddInt64(&s.metrics.pendingTaskNum, -1)
+			f()
+		})
 		return
 	}
 
@@ -672,23 +1419,29 @@ func (s *session) handlePackage() {
 			rBuf := make([]byte, size)
 			rBuf = rBuf[:runtime.Stack(rBuf, false)]
 			log.Error("[session.handlePackage] panic session %s: err=%s\n%s", s.sessionToken(), r, rBuf)
+			s.dumpPanic(r, rBuf)
 		}
 
 		close(s.rDone)
 		grNum := atomic.AddInt32(&(s.grNum), -1)
+		atomic.AddInt64(&s.metrics.readGoroutineNum, -1)
 		log.Info("%s, [session.handlePackage] gr will exit now, left gr num %d", s.sessionToken(), grNum)
-		s.stop()
+		notified := false
 		if err != nil {
-			log.Error("%s, [session.handlePackage] error{%s}", s.sessionToken(), jerrors.ErrorStack(err))
-			if s != nil || s.listener != nil {
-				s.listener.OnError(s, err)
+			sampledError("%s, [session.handlePackage] error{%s}", s.sessionToken(), jerrors.ErrorStack(err))
+			notified = s.notifyError(err, ErrorDirectionRead)
+		}
+		s.stop()
+		if err != nil && !notified {
+			if listener := s.getListener(); listener != nil {
+				listener.OnError(s, err)
 			}
 		}
 	}()
 
 	if _, ok := s.Connection.(*gettyTCPConn); ok {
-		if s.reader == nil {
-			errStr := fmt.Sprintf("session{name:%s, conn:%#v, reader:%#v}", s.name, s.Connection, s.reader)
+		if reader := s.getReader(); reader == nil {
+			errStr := fmt.Sprintf("session{name:%s, conn:%#v, reader:%#v}", s.name, s.Connection, reader)
 			log.Error(errStr)
 			panic(errStr)
 		}
@@ -698,6 +1451,8 @@ func (s *session) handlePackage() {
 		err = s.handleWSPackage()
 	} else if _, ok := s.Connection.(*gettyUDPConn); ok {
 		err = s.handleUDPPackage()
+	} else if _, ok := s.Connection.(*gettyIPConn); ok {
+		err = s.handleIPPackage()
 	} else {
 		fmt.Printf("session Type %T\n", s.Connection)
 		panic(fmt.Sprintf("unknown type session{%#v}", s))
@@ -718,8 +1473,24 @@ func (s *session) handleTCPPackage() error {
 		buf      []byte
 		pktBuf   *bytes.Buffer
 		pkg      interface{}
+		pkgs     []interface{}
+		readTime time.Time
+		// the listener of @pkgs, and the listener when the current pkg is decoded
+		pkgsListener EventListener
+		listener     EventListener
+		pkgsSeq      uint32
+		seq          uint32
+		bufBytes     int64
+		discarded    int // the bytes discarded by the resync since the last decoded pkg
+		batchSize    = s.readLoop.BatchSize
+		spinTime     = s.readLoop.SpinTime
+		spinYield    = true
 	)
 
+	if s.busyPoll != nil {
+		spinTime, spinYield = s.busyPoll.SpinTime, false
+	}
+
 	// buf = make([]byte, maxReadBufLen)
 	bufp = gxbytes.GetBytes(maxReadBufLen)
 	buf = *bufp
@@ -727,12 +1498,16 @@ func (s *session) handleTCPPackage() error {
 	// pktBuf = new(bytes.Buffer)
 	pktBuf = gxbytes.GetBytesBuffer()
 
+	bufBytes = int64(cap(buf) + pktBuf.Cap())
+	atomic.AddInt64(&s.metrics.readBufferBytes, bufBytes)
 	defer func() {
+		atomic.AddInt64(&s.metrics.readBufferBytes, -bufBytes)
 		gxbytes.PutBytes(bufp)
 		gxbytes.PutBytesBuffer(pktBuf)
 	}()
 
 	conn = s.Connection.(*gettyTCPConn)
+	s.setKernelBusyPoll(conn)
 	for {
 		if s.IsClosed() {
 			err = nil
@@ -741,11 +1516,16 @@ func (s *session) handleTCPPackage() error {
 			break
 		}
 
+		s.waitMemory()
 		bufLen = 0
 		for {
 			// for clause for the network timeout condition check
 			// s.conn.SetReadTimeout(time.Now().Add(s.rTimeout))
-			bufLen, err = conn.recv(buf)
+			if spinTime > 0 {
+				bufLen, err = conn.spinRecv(buf, spinTime, spinYield)
+			} else {
+				bufLen, err = conn.recv(buf)
+			}
 			if err != nil {
 				if netError, ok = jerrors.Cause(err).(net.Error); ok && netError.Timeout() {
 					break
@@ -756,7 +1536,7 @@ func (s *session) handleTCPPackage() error {
 					exit = true
 					break
 				}
-				log.Error("%s, [session.conn.read] = error{%s}", s.sessionToken(), jerrors.ErrorStack(err))
+				sampledError("%s, [session.conn.read] = error{%s}", s.sessionToken(), jerrors.ErrorStack(err))
 				exit = true
 			}
 			break
@@ -767,65 +1547,184 @@ func (s *session) handleTCPPackage() error {
 		if 0 == bufLen {
 			continue // just continue if session can not read no more stream bytes.
 		}
+		readTime = s.sampleTime()
+		s.stampRecv()
+		s.throttleRead(bufLen)
 		pktBuf.Write(buf[:bufLen])
+		if size := int64(cap(buf) + pktBuf.Cap()); size != bufBytes {
+			atomic.AddInt64(&s.metrics.readBufferBytes, size-bufBytes)
+			bufBytes = size
+		}
+		pkgs = nil
 		for {
 			if pktBuf.Len() <= 0 {
 				break
 			}
-			pkg, pkgLen, err = s.reader.Read(s, pktBuf.Bytes())
+			if n := s.handleProbe(pktBuf.Bytes()); n != 0 {
+				if n < 0 {
+					break
+				}
+				pktBuf.Next(n)
+				continue
+			}
+			if s.magic != nil {
+				if ok, err = s.validateMagic(pktBuf.Bytes(), false); err != nil {
+					exit = true
+					break
+				}
+				if !ok {
+					break
+				}
+			}
+			listener, seq = s.listenerState()
+			pkg, pkgLen, err = s.getReader().Read(s, pktBuf.Bytes())
 			// for case 3/case 4
 			if err == nil && s.maxMsgLen > 0 && pkgLen > int(s.maxMsgLen) {
-				err = jerrors.Errorf("pkgLen %d > session max message len %d", pkgLen, s.maxMsgLen)
+				err = newGettyError(ErrMsgTooLarge,
+					jerrors.Errorf("pkgLen %d > session max message len %d", pkgLen, s.maxMsgLen))
 			}
 			// handle case 1
 			if err != nil {
-				log.Warn("%s, [session.handleTCPPackage] = len{%d}, error{%s}",
+				sampledWarn("%s, [session.handleTCPPackage] = len{%d}, error{%s}",
 					s.sessionToken(), pkgLen, jerrors.ErrorStack(err))
-				exit = true
-				break
+				s.reportProtocolError()
+				n := s.resync.discard(pktBuf.Bytes())
+				if n < 0 || (s.resync.MaxDiscardBytes > 0 && discarded+n > s.resync.MaxDiscardBytes) {
+					exit = true
+					break
+				}
+				// resync the stream by discarding the leading bytes
+				s.notifyError(err, ErrorDirectionRead)
+				atomic.AddUint64(&s.metrics.resyncNum, 1)
+				atomic.AddUint64(&s.metrics.resyncDiscardedBytes, uint64(n))
+				discarded += n
+				pktBuf.Next(n)
+				err = nil
+				if n == 0 {
+					break
+				}
+				continue
 			}
 			// handle case 2/case 3
 			if pkg == nil {
 				break
 			}
 			// handle case 4
-			s.UpdateActive()
-			s.addTask(pkg)
+			if len(pkgs) != 0 && seq != pkgsSeq {
+				// the listener has been swapped, flush the pkgs decoded before to the old listener
+				s.UpdateActive()
+				s.deliverTasks(pkgsListener, pkgs, readTime)
+				pkgs = nil
+			}
+			pkgsListener, pkgsSeq = listener, seq
+			pkgs = append(pkgs, pkg)
+			s.logFrameAccess(pkg, pkgLen, false)
 			pktBuf.Next(pkgLen)
+			discarded = 0
+			if s.readCompress != nil {
+				// the left stream is decompressed by the new compression
+				s.applyReadCompress(conn, pktBuf.Bytes())
+				pktBuf.Reset()
+			}
+			if batchSize > 0 && len(pkgs) >= batchSize {
+				// deliver the batch and yield the processor before decoding the left stream
+				s.UpdateActive()
+				s.deliverTasks(pkgsListener, pkgs, readTime)
+				pkgs = nil
+				runtime.Gosched()
+			}
 			// continue to handle case 5
 		}
+		s.setInbound(int64(pktBuf.Len()))
+		if len(pkgs) != 0 {
+			s.UpdateActive()
+			s.deliverTasks(pkgsListener, pkgs, readTime)
+		}
 		if exit {
 			break
 		}
 	}
 
-	return jerrors.Trace(err)
+	return traceError(err)
+}
+
+// handle a websocket message by @reader. it returns error only if the connection is broken.
+func (s *session) handleWSStream(conn *gettyWSConn, reader StreamReader) error {
+	r, err := conn.recvStream()
+	if netError, ok := jerrors.Cause(err).(net.Error); ok && netError.Timeout() {
+		return nil
+	}
+	if err != nil {
+		sampledWarn("%s, [session.handleWSStream] = error{%s}", s.sessionToken(), jerrors.ErrorStack(err))
+		return err
+	}
+	s.UpdateActive()
+	readTime := s.sampleTime()
+	s.stampRecv()
+
+	pkg, err := reader.ReadStream(s, r)
+	if r.err != nil {
+		sampledWarn("%s, [session.handleWSStream] read stream error{%s}", s.sessionToken(), jerrors.ErrorStack(r.err))
+		return r.err
+	}
+	if err != nil {
+		sampledWarn("%s, [session.handleWSStream] = error{%s}", s.sessionToken(), jerrors.ErrorStack(err))
+		s.reportProtocolError()
+		s.notifyError(err, ErrorDirectionRead)
+		return nil
+	}
+	if pkg != nil {
+		s.addTask(pkg, readTime)
+	}
+
+	return nil
 }
 
 // get package from udp packet
 func (s *session) handleUDPPackage() error {
 	var (
-		ok       bool
-		err      error
-		netError net.Error
-		conn     *gettyUDPConn
-		bufLen   int
-		bufp     *[]byte
-		buf      []byte
-		addr     *net.UDPAddr
-		pkgLen   int
-		pkg      interface{}
+		ok        bool
+		err       error
+		netError  net.Error
+		conn      *gettyUDPConn
+		bufLen    int
+		bufp      *[]byte
+		buf       []byte
+		addr      *net.UDPAddr
+		readTime  time.Time
+		data      []byte
+		datagrams [][]byte
+		reasm     *reassembler
+		fecDec    *fecDecoder
+		dedup     *dedupReceiver
+		opener    *datagramOpener
 	)
 
 	conn = s.Connection.(*gettyUDPConn)
+	if conn.fragment != nil {
+		reasm = newReassembler(*conn.fragment)
+	}
+	if conn.fec != nil {
+		fecDec = newFECDecoder(conn.fec.config)
+	}
+	if conn.dedup != nil {
+		dedup = newDedupReceiver(conn.dedup.config)
+	}
+	if conn.sealer != nil {
+		opener = newDatagramOpener(conn.sealer.config)
+	}
 	bufLen = int(s.maxMsgLen + maxReadBufLen)
 	if int(s.maxMsgLen<<1) < bufLen {
 		bufLen = int(s.maxMsgLen << 1)
 	}
 	// buf = make([]byte, bufLen)
 	bufp = gxbytes.GetBytes(bufLen) //make([]byte, maxBufLen)
-	defer gxbytes.PutBytes(bufp)
 	buf = *bufp
+	atomic.AddInt64(&s.metrics.readBufferBytes, int64(cap(buf)))
+	defer func() {
+		atomic.AddInt64(&s.metrics.readBufferBytes, -int64(cap(buf)))
+		gxbytes.PutBytes(bufp)
+	}()
 	for {
 		if s.IsClosed() {
 			break
@@ -837,42 +1736,117 @@ func (s *session) handleUDPPackage() error {
 			continue
 		}
 		if err != nil {
-			log.Error("%s, [session.handleUDPPackage] = len{%d}, error{%s}",
+			sampledError("%s, [session.handleUDPPackage] = len{%d}, error{%s}",
 				s.sessionToken(), bufLen, jerrors.ErrorStack(err))
 			err = jerrors.Annotatef(err, "conn.read()")
 			break
 		}
 
 		if bufLen == 0 {
-			log.Error("conn.read() = bufLen:%d, addr:%s, err:%s", bufLen, addr, jerrors.ErrorStack(err))
+			sampledError("conn.read() = bufLen:%d, addr:%s, err:%s", bufLen, addr, jerrors.ErrorStack(err))
 			continue
 		}
+		readTime = s.sampleTime()
+		s.stampRecv()
+		s.throttleRead(bufLen)
 
 		if bufLen == len(connectPingPackage) && bytes.Equal(connectPingPackage, buf[:bufLen]) {
 			log.Info("got %s connectPingPackage", addr)
 			continue
 		}
-
-		pkg, pkgLen, err = s.reader.Read(s, buf[:bufLen])
-		log.Debug("s.reader.Read() = pkg:%#v, pkgLen:%d, err:%s", pkg, pkgLen, jerrors.ErrorStack(err))
-		if err == nil && s.maxMsgLen > 0 && bufLen > int(s.maxMsgLen) {
-			err = jerrors.Errorf("Message Too Long, bufLen %d, session max message len %d", bufLen, s.maxMsgLen)
+		if s.stun != nil && s.stun.handle(buf[:bufLen]) {
+			continue
 		}
-		if err != nil {
-			log.Warn("%s, [session.handleUDPPackage] = len{%d}, error{%s}",
-				s.sessionToken(), pkgLen, jerrors.ErrorStack(err))
+		if s.rendezvous != nil && bytes.HasPrefix(buf[:bufLen], rendezvousRegisterPrefix) {
+			s.handleRendezvous(conn, buf[:bufLen], addr)
 			continue
 		}
-		if pkgLen == 0 {
-			log.Error("s.reader.Read() = pkg:%#v, pkgLen:%d, err:%s", pkg, pkgLen, jerrors.ErrorStack(err))
+
+		data = buf[:bufLen]
+		if opener != nil {
+			if data, err = opener.open(data, time.Now()); err != nil {
+				sampledWarn("%s, [session.handleUDPPackage] open encrypted datagram from %s, error{%s}",
+					s.sessionToken(), addr, jerrors.ErrorStack(err))
+				s.notifyError(err, ErrorDirectionRead)
+				err = nil
+				continue
+			}
+		}
+		if dedup != nil {
+			if data, err = dedup.add(addr.String(), data, time.Now()); err != nil {
+				sampledWarn("%s, [session.handleUDPPackage] check dedup datagram from %s, error{%s}",
+					s.sessionToken(), addr, jerrors.ErrorStack(err))
+				s.notifyError(err, ErrorDirectionRead)
+				err = nil
+				continue
+			}
+			if data == nil {
+				log.Debug("%s, drop duplicate datagram from %s", s.sessionToken(), addr)
+				continue
+			}
+		}
+		if fecDec == nil {
+			s.handleUDPDatagram(reasm, data, addr, readTime)
 			continue
 		}
+		if datagrams, err = fecDec.add(addr.String(), data, time.Now()); err != nil {
+			sampledWarn("%s, [session.handleUDPPackage] decode fec datagram from %s, error{%s}",
+				s.sessionToken(), addr, jerrors.ErrorStack(err))
+			s.notifyError(err, ErrorDirectionRead)
+			err = nil
+		}
+		for _, datagram := range datagrams {
+			s.handleUDPDatagram(reasm, datagram, addr, readTime)
+		}
+	}
 
-		s.UpdateActive()
-		s.addTask(UDPContext{Pkg: pkg, PeerAddr: addr})
+	return traceError(err)
+}
+
+// reassemble & unmarshal the datagram @data from @addr, then deliver the package
+func (s *session) handleUDPDatagram(reasm *reassembler, data []byte, addr *net.UDPAddr, readTime time.Time) {
+	var (
+		err    error
+		pkg    interface{}
+		pkgLen int
+	)
+
+	if reasm != nil {
+		if data, err = reasm.add(addr.String(), data, time.Now()); err != nil {
+			sampledWarn("%s, [session.handleUDPPackage] reassemble datagram from %s, error{%s}",
+				s.sessionToken(), addr, jerrors.ErrorStack(err))
+			s.notifyError(err, ErrorDirectionRead)
+			return
+		}
+		if data == nil {
+			// the message is incomplete
+			return
+		}
+	}
+
+	pkg, pkgLen, err = s.getReader().Read(s, data)
+	log.Debug("s.reader.Read() = pkg:%#v, pkgLen:%d, err:%s", pkg, pkgLen, jerrors.ErrorStack(err))
+	if err == nil && s.maxMsgLen > 0 && reasm == nil && len(data) > int(s.maxMsgLen) {
+		err = newGettyError(ErrMsgTooLarge,
+			jerrors.Errorf("Message Too Long, bufLen %d, session max message len %d", len(data), s.maxMsgLen))
+	}
+	if err != nil {
+		sampledWarn("%s, [session.handleUDPPackage] = len{%d}, error{%s}",
+			s.sessionToken(), pkgLen, jerrors.ErrorStack(err))
+		s.notifyError(err, ErrorDirectionRead)
+		return
+	}
+	if pkgLen == 0 {
+		sampledError("s.reader.Read() = pkg:%#v, pkgLen:%d, err:%s", pkg, pkgLen, jerrors.ErrorStack(err))
+		return
 	}
 
-	return jerrors.Trace(err)
+	s.UpdateActive()
+	var stamp PacketTimestamp
+	if conn, ok := s.Connection.(*gettyUDPConn); ok {
+		stamp = conn.rxStamp
+	}
+	s.addTask(UDPContext{Pkg: pkg, PeerAddr: addr, Timestamp: stamp}, readTime)
 }
 
 // get package from websocket stream
@@ -885,37 +1859,79 @@ func (s *session) handleWSPackage() error {
 		conn         *gettyWSConn
 		pkg          []byte
 		unmarshalPkg interface{}
+		readTime     time.Time
+		reader       Reader
+		streamReader StreamReader
+		readLimit    int64
+		limit        int64
 	)
 
 	conn = s.Connection.(*gettyWSConn)
+	readLimit = -1
 	for {
 		if s.IsClosed() {
 			break
 		}
+		s.waitMemory()
+		reader = s.getReader()
+		streamReader, ok = reader.(StreamReader)
+		// the max message length limits every websocket message unless it is read as a stream,
+		// in which case the StreamReader takes charge of the giant message. the reader may be
+		// swapped at runtime, so the limit is checked for every message.
+		limit = 0
+		if !ok && s.maxMsgLen > 0 {
+			limit = int64(s.maxMsgLen)
+		}
+		if limit != readLimit {
+			conn.conn.SetReadLimit(limit)
+			readLimit = limit
+		}
+		if ok {
+			if err = s.handleWSStream(conn, streamReader); err != nil {
+				return traceError(err)
+			}
+			continue
+		}
 		pkg, err = conn.recv()
 		if netError, ok = jerrors.Cause(err).(net.Error); ok && netError.Timeout() {
 			continue
 		}
 		if err != nil {
-			log.Warn("%s, [session.handleWSPackage] = error{%s}",
+			sampledWarn("%s, [session.handleWSPackage] = error{%s}",
 				s.sessionToken(), jerrors.ErrorStack(err))
-			return jerrors.Trace(err)
+			return traceError(err)
 		}
 		s.UpdateActive()
-		if s.reader != nil {
-			unmarshalPkg, length, err = s.reader.Read(s, pkg)
+		s.throttleRead(len(pkg))
+		if s.handleProbe(pkg) > 0 {
+			continue
+		}
+		if s.magic != nil {
+			if _, err = s.validateMagic(pkg, true); err != nil {
+				return err
+			}
+		}
+		readTime = s.sampleTime()
+		s.stampRecv()
+		if reader != nil {
+			unmarshalPkg, length, err = reader.Read(s, pkg)
 			if err == nil && s.maxMsgLen > 0 && length > int(s.maxMsgLen) {
-				err = jerrors.Errorf("Message Too Long, length %d, session max message len %d", length, s.maxMsgLen)
+				err = newGettyError(ErrMsgTooLarge,
+					jerrors.Errorf("Message Too Long, length %d, session max message len %d", length, s.maxMsgLen))
 			}
 			if err != nil {
-				log.Warn("%s, [session.handleWSPackage] = len{%d}, error{%s}",
+				sampledWarn("%s, [session.handleWSPackage] = len{%d}, error{%s}",
 					s.sessionToken(), length, jerrors.ErrorStack(err))
+				s.reportProtocolError()
+				s.notifyError(err, ErrorDirectionRead)
 				continue
 			}
 
-			s.addTask(unmarshalPkg)
+			s.logFrameAccess(unmarshalPkg, length, true)
+			s.addTask(unmarshalPkg, readTime)
 		} else {
-			s.addTask(pkg)
+			s.logFrameAccess(pkg, len(pkg), true)
+			s.addTask(pkg, readTime)
 		}
 	}
 
@@ -934,8 +1950,12 @@ func (s *session) stop() {
 			if conn := s.Conn(); conn != nil {
 				conn.SetReadDeadline(now.Add(s.readTimeout()))
 				conn.SetWriteDeadline(now.Add(s.writeTimeout()))
+				if tc, ok := conn.(*transportConn); ok {
+					tc.closeAfter(s.readTimeout())
+				}
 			}
 			close(s.done)
+			s.markLeakClosed()
 			c := s.GetAttribute(sessionClientKey)
 			if clt, ok := c.(*client); ok {
 				clt.reConnect()
@@ -959,16 +1979,176 @@ func (s *session) gc() {
 		}
 		conn = s.Connection
 	}
+	// the closing goroutine does not touch the session, which may be Reset meanwhile
+	wait, metrics := s.wait, s.metrics
 	s.lock.Unlock()
+	s.releaseMemory()
+	s.leaveResourceGroup()
+	if s.panicDump != nil {
+		s.panicDump.unregister(s)
+	}
+	if tracker, ok := s.endPoint.(sessionTracker); ok {
+		tracker.removeSession(s)
+	}
 
 	go func() {
 		if wQ != nil {
-			conn.close((int)((int64)(s.wait)))
+			conn.close((int)((int64)(wait)))
 			close(wQ)
+			// the left pkgs will never be sent
+			atomic.AddInt64(&metrics.queuedPkgNum, -int64(len(wQ)))
 		}
 	}()
 }
 
+func (s *session) closeDeadlineExceeded() bool {
+	deadline := atomic.LoadInt64(&s.closeDeadline)
+	return deadline != 0 && deadline < time.Now().UnixNano()
+}
+
+// CloseContext closes the session and waits until its goroutines have exited or @ctx is done.
+// If @ctx has a deadline, the session will not wait for sending out the packages left in its
+// write queue after the deadline, no matter what its wait time is.
+func (s *session) CloseContext(ctx context.Context) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		atomic.StoreInt64(&s.closeDeadline, deadline.UnixNano())
+	}
+	s.Close()
+
+	if atomic.LoadInt32(&s.running) == 0 {
+		return nil
+	}
+	select {
+	case <-s.wDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CloseWithStatus closes a websocket session with the close @code and @reason.
+func (s *session) CloseWithStatus(code int, reason string) {
+	if conn, ok := s.Connection.(*gettyWSConn); ok {
+		conn.setCloseStatus(code, reason)
+	}
+	s.Close()
+}
+
+// SetFragmentation enables the fragmentation of a udp session, and nil @config disables it.
+// It should be invoked before the session runs, e.g. in NewSessionCallback.
+func (s *session) SetFragmentation(config *FragmentConfig) {
+	if conn, ok := s.Connection.(*gettyUDPConn); ok {
+		if config == nil {
+			conn.fragment = nil
+			return
+		}
+		c := *config
+		if c.MTU <= 0 {
+			conn.fragment = nil
+			c.MTU = conn.maxDatagramSize()
+		}
+		c = c.withDefaults()
+		conn.fragment = &c
+	}
+}
+
+// SetFEC enables the forward error correction of a udp session, and nil @config disables it.
+// It should be invoked before the session runs, e.g. in NewSessionCallback. If the
+// fragmentation is enabled too, the fec applies to the fragments, so pls invoke SetFEC
+// before SetFragmentation to get the right default fragment mtu.
+func (s *session) SetFEC(config *FECConfig) {
+	if conn, ok := s.Connection.(*gettyUDPConn); ok {
+		if config == nil {
+			conn.fec = nil
+			return
+		}
+		conn.fec = newFECEncoder(config.withDefaults())
+	}
+}
+
+// SetDedup enables the duplicate datagram suppression of a udp session, and nil @config
+// disables it. It should be invoked before the session runs, e.g. in NewSessionCallback,
+// and before SetFragmentation to get the right default fragment mtu.
+func (s *session) SetDedup(config *DedupConfig) {
+	if conn, ok := s.Connection.(*gettyUDPConn); ok {
+		if config == nil {
+			conn.dedup = nil
+			return
+		}
+		conn.dedup = newDedupSender(config.withDefaults())
+	}
+}
+
+// SetDatagramCipher enables the encryption & anti-replay check of a udp session, and nil
+// @config disables it. It returns ErrNotSupported for a tcp/websocket session.
+func (s *session) SetDatagramCipher(config *DatagramCipherConfig) error {
+	conn, ok := s.Connection.(*gettyUDPConn)
+	if !ok {
+		return ErrNotSupported
+	}
+	if config == nil {
+		conn.sealer = nil
+		return nil
+	}
+
+	c := config.withDefaults()
+	if err := c.validate(); err != nil {
+		return err
+	}
+	sealer, err := newDatagramSealer(c)
+	if err != nil {
+		return err
+	}
+	conn.sealer = sealer
+	return nil
+}
+
+// SetPathMTUDiscovery sets the DF bit on the datagrams of a udp session, so the kernel
+// discovers the path mtu and a datagram larger than it fails to be sent instead of being
+// fragmented by ip. It returns ErrNotSupported if the platform does not support it.
+func (s *session) SetPathMTUDiscovery(enable bool) error {
+	conn, ok := s.Connection.(*gettyUDPConn)
+	if !ok || conn.conn == nil || conn.isClosed() {
+		return ErrNotSupported
+	}
+
+	return setPathMTUDiscovery(conn.conn, enable)
+}
+
+// MaxDatagramSize returns the max message size which can be sent in one datagram without
+// ip fragmentation, and the fragment header is excluded if the fragmentation is enabled.
+// The size is got from the kernel path mtu of a connected udp session, and it is 1200
+// for a non-connected udp session or if the path mtu is unknown. It returns 0 for a
+// tcp/websocket session.
+func (s *session) MaxDatagramSize() int {
+	if conn, ok := s.Connection.(*gettyUDPConn); ok {
+		return conn.maxDatagramSize()
+	}
+
+	return 0
+}
+
+// TCPInfo returns the statistics of the tcp connection of a tcp/websocket session got
+// by getsockopt(TCP_INFO). It returns nil for a udp session or on a platform other
+// than linux.
+func (s *session) TCPInfo() *TCPInfo {
+	tcpConn := underlyingTCPConn(s.Conn())
+	if tcpConn == nil {
+		return nil
+	}
+
+	return getTCPInfo(tcpConn)
+}
+
+// CloseReason returns the close code & reason of a websocket session.
+func (s *session) CloseReason() *CloseReason {
+	if conn, ok := s.Connection.(*gettyWSConn); ok {
+		return conn.getCloseReason()
+	}
+
+	return nil
+}
+
 // Close will be invoked by NewSessionCallback(if return error is not nil)
 // or (session)handleLoop automatically. It's thread safe.
 func (s *session) Close() {
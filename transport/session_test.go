@@ -0,0 +1,422 @@
+package getty
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPipeSessions(t testing.TB) (*session, *session) {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+
+	clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+	ss1 := newTCPSession(c1, clt).(*session)
+	ss2 := newTCPSession(c2, clt).(*session)
+
+	return ss1, ss2
+}
+
+func TestSessionOptionalInterfaces(t *testing.T) {
+	var ss Session
+	ss, _ = newPipeSessions(t)
+
+	_, ok := ss.(SessionWriter)
+	assert.True(t, ok)
+	_, ok = ss.(SessionCloser)
+	assert.True(t, ok)
+	_, ok = ss.(HandlerSwitcher)
+	assert.True(t, ok)
+	_, ok = ss.(SessionInspector)
+	assert.True(t, ok)
+	_, ok = ss.(FlowController)
+	assert.True(t, ok)
+	_, ok = ss.(DatagramSession)
+	assert.True(t, ok)
+	_, ok = ss.(StreamSession)
+	assert.True(t, ok)
+	_, ok = ss.(SessionObserver)
+	assert.True(t, ok)
+	_, ok = ss.(ResourceGroupMember)
+	assert.True(t, ok)
+	assert.Equal(t, ss.(SessionInspector).SessionID(), sessionID(ss))
+}
+
+func TestSessionMirror(t *testing.T) {
+	var msgHandler MessageHandler
+
+	src, sink := newPipeSessions(t)
+	src.SetEventListener(&msgHandler)
+	sink.SetWQLen(1)
+
+	assert.Panics(t, func() { src.SetMirrorSession(src) })
+	src.SetMirrorSession(sink)
+	src.addTask([]byte("hello"), time.Time{})
+	src.addTask([]byte("world"), time.Time{})
+	assert.Equal(t, 1, len(sink.wQ))
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&src.mirrorDrop))
+	assert.Equal(t, int64(1), sink.metrics.Budget().QueuedPkgNum)
+	assert.Equal(t, []byte("hello"), (<-sink.wQ).([]byte))
+
+	src.SetMirrorSession(nil)
+	src.addTask([]byte("hello"), time.Time{})
+	assert.Equal(t, 0, len(sink.wQ))
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&src.mirrorDrop))
+}
+
+type errorHandler struct {
+	MessageHandler
+
+	errs       []error
+	directions []ErrorDirection
+}
+
+func (h *errorHandler) OnSessionError(ss Session, err error, direction ErrorDirection) {
+	h.errs = append(h.errs, err)
+	h.directions = append(h.directions, direction)
+}
+
+func TestSessionNotifyError(t *testing.T) {
+	var (
+		msgHandler MessageHandler
+		errHandler errorHandler
+	)
+
+	ss, _ := newPipeSessions(t)
+	ss.SetEventListener(&msgHandler)
+	assert.False(t, ss.notifyError(ErrSessionClosed, ErrorDirectionRead))
+
+	ss.SetEventListener(&errHandler)
+	assert.True(t, ss.notifyError(ErrSessionClosed, ErrorDirectionRead))
+	assert.True(t, ss.notifyError(ErrSessionBlocked, ErrorDirectionWrite))
+	assert.Equal(t, []error{ErrSessionClosed, ErrSessionBlocked}, errHandler.errs)
+	assert.Equal(t, []ErrorDirection{ErrorDirectionRead, ErrorDirectionWrite}, errHandler.directions)
+	assert.Equal(t, "write", ErrorDirectionWrite.String())
+}
+
+func TestSessionWritePkgContext(t *testing.T) {
+	ss, _ := newPipeSessions(t)
+	ss.SetWQLen(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, ss.WritePkgContext(ctx, "canceled"))
+
+	assert.Nil(t, ss.WritePkgContext(context.Background(), "queued"))
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, ss.WritePkgContext(ctx, "blocked"))
+	assert.Equal(t, int64(1), ss.metrics.Budget().QueuedPkgNum)
+
+	qPkg := unwrapQueuedPkg(<-ss.wQ)
+	assert.Equal(t, "queued", qPkg.pkg)
+	assert.Nil(t, qPkg.dropReason())
+	qPkg = queuedPkg{pkg: "expired", ctx: ctx}
+	assert.Equal(t, context.DeadlineExceeded, qPkg.dropReason())
+}
+
+func TestSessionCloseContext(t *testing.T) {
+	ss, _ := newPipeSessions(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, ss.CloseContext(ctx))
+	assert.True(t, ss.IsClosed())
+	assert.False(t, ss.closeDeadlineExceeded())
+
+	deadline, _ := ctx.Deadline()
+	assert.Equal(t, deadline.UnixNano(), ss.closeDeadline)
+}
+
+func benchmarkSessionAddTasks(b *testing.B, batch int) {
+	var handler MessageHandler
+
+	ss, _ := newPipeSessions(b)
+	ss.SetEventListener(&handler)
+
+	pkgs := make([]interface{}, batch)
+	for i := range pkgs {
+		pkgs[i] = i
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batch {
+		ss.addTasks(pkgs, time.Time{})
+	}
+}
+
+func BenchmarkSessionAddTask(b *testing.B)    { benchmarkSessionAddTasks(b, 1) }
+func BenchmarkSessionAddTasks16(b *testing.B) { benchmarkSessionAddTasks(b, 16) }
+
+// count the bytes of a websocket message incrementally
+type streamCodec struct {
+	PackageHandler
+}
+
+func (streamCodec) ReadStream(ss Session, r io.Reader) (interface{}, error) {
+	n, err := io.Copy(ioutil.Discard, r)
+	return n, err
+}
+
+func TestSessionWSStream(t *testing.T) {
+	var (
+		handler countHandler
+		codec   streamCodec
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		// 64KB message will be fragmented into several frames
+		writer, err := conn.NextWriter(websocket.BinaryMessage)
+		assert.Nil(t, err)
+		for i := 0; i < 16; i++ {
+			_, err = writer.Write(make([]byte, 4096))
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, writer.Close())
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	ss := newWSSession(conn, newClient(WS_CLIENT, WithServerAddress(srv.URL), WithConnectionNumber(1))).(*session)
+	ss.SetEventListener(&handler)
+	ss.SetReader(&codec)
+	wsConn := ss.Connection.(*gettyWSConn)
+	assert.Nil(t, ss.handleWSStream(wsConn, &codec))
+	assert.Equal(t, []interface{}{int64(64 << 10)}, handler.pkgs)
+	assert.Equal(t, uint32(64<<10), atomic.LoadUint32(&wsConn.readBytes))
+	assert.NotNil(t, ss.handleWSStream(wsConn, &codec))
+}
+
+func TestSessionWSCloseReason(t *testing.T) {
+	closeErr := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		if r.URL.Path == "/kick" {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "kicked"))
+		}
+		_, _, err = conn.ReadMessage()
+		closeErr <- err
+	}))
+	defer srv.Close()
+
+	dial := func(path string) *session {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path, nil)
+		assert.Nil(t, err)
+		return newWSSession(conn, newClient(WS_CLIENT, WithServerAddress(srv.URL), WithConnectionNumber(1))).(*session)
+	}
+
+	// closed by the peer
+	ss := dial("/kick")
+	assert.Nil(t, ss.CloseReason())
+	_, err := ss.Connection.(*gettyWSConn).recv()
+	assert.NotNil(t, err)
+	assert.Equal(t, &CloseReason{Code: 4001, Text: "kicked"}, ss.CloseReason())
+	<-closeErr
+
+	// closed by the session
+	ss = dial("/")
+	ss.CloseWithStatus(4002, "bye")
+	assert.True(t, ss.IsClosed())
+	assert.Equal(t, &CloseReason{Code: 4002, Text: "bye"}, ss.CloseReason())
+	ss.Connection.close(0)
+	err = <-closeErr
+	assert.True(t, websocket.IsCloseError(err, 4002), "%v", err)
+	assert.Equal(t, "bye", err.(*websocket.CloseError).Text)
+
+	// unsendable close code & too long reason
+	ss = dial("/")
+	ss.CloseWithStatus(websocket.CloseAbnormalClosure, strings.Repeat("再", 50))
+	ss.Connection.close(0)
+	err = <-closeErr
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "%v", err)
+	assert.Equal(t, strings.Repeat("再", 41), err.(*websocket.CloseError).Text)
+
+	ss, _ = newPipeSessions(t)
+	assert.Nil(t, ss.CloseReason())
+}
+
+// lineTransferCodec decodes lines, and hands the session over to @next after the first line
+type lineTransferCodec struct {
+	next     *lineTransferCodec
+	listener EventListener
+}
+
+func (c *lineTransferCodec) Read(ss Session, data []byte) (interface{}, int, error) {
+	idx := strings.IndexByte(string(data), '\n')
+	if idx < 0 {
+		return nil, 0, nil
+	}
+	if c.next != nil {
+		ss.(HandlerSwitcher).Transfer(c.next, c.listener)
+	}
+
+	return string(data[:idx]), idx + 1, nil
+}
+
+func (c *lineTransferCodec) Write(ss Session, pkg interface{}) ([]byte, error) {
+	return []byte(pkg.(string) + "\n"), nil
+}
+
+type lineListener struct {
+	MessageHandler
+
+	msgs chan interface{}
+}
+
+func (l *lineListener) OnMessage(ss Session, pkg interface{}) {
+	l.msgs <- pkg
+}
+
+func TestSessionTransfer(t *testing.T) {
+	handshake := &lineListener{msgs: make(chan interface{}, 4)}
+	protocol := &lineListener{msgs: make(chan interface{}, 4)}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	peer, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer peer.Close()
+	conn, err := l.Accept()
+	assert.Nil(t, err)
+
+	clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+	ss := newTCPSession(conn, clt).(*session)
+	ss.SetPkgHandler(&lineTransferCodec{next: &lineTransferCodec{}, listener: protocol})
+	ss.SetEventListener(handshake)
+	ss.run()
+	defer ss.Close()
+
+	// the frames after the handshake frame are in the same read buffer
+	_, err = peer.Write([]byte("hello\nworld\nfoo\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", <-handshake.msgs)
+	assert.Equal(t, "world", <-protocol.msgs)
+	assert.Equal(t, "foo", <-protocol.msgs)
+	assert.Equal(t, 0, len(handshake.msgs))
+}
+
+func TestSessionWithoutEndPoint(t *testing.T) {
+	var msgHandler MessageHandler
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	ss := newTCPSession(c1, nil).(*session)
+	ss.SetEventListener(&msgHandler)
+	ss.wQ = make(chan interface{}, 1)
+	assert.True(t, ss.offerPkg([]byte("hello")))
+	ss.addTask([]byte("hello"), ss.sampleTime())
+
+	assert.Equal(t, int64(1), ss.metrics.Budget().QueuedPkgNum)
+
+	ss.Reset()
+	assert.NotNil(t, ss.metrics)
+	assert.Equal(t, int64(0), ss.metrics.Budget().QueuedPkgNum)
+}
+
+type streamCountHandler struct {
+	MessageHandler
+
+	sizes chan interface{}
+}
+
+func (h *streamCountHandler) OnMessage(ss Session, pkg interface{}) {
+	h.sizes <- pkg
+}
+
+func TestSessionWSStreamReadLimit(t *testing.T) {
+	var (
+		codec         streamCodec
+		serverHandler = &streamCountHandler{sizes: make(chan interface{}, 1)}
+		clientHandler MessageHandler
+	)
+
+	server := NewWSServer(WithLocalAddress("127.0.0.1:0"), WithWebsocketServerPath("/stream"))
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetMaxMsgLen(1024)
+		ss.SetPkgHandler(&codec)
+		ss.SetEventListener(serverHandler)
+		return nil
+	})
+	defer server.Close()
+
+	client := NewWSClient(
+		WithServerAddress("ws://"+server.Listener().Addr().String()+"/stream"),
+		WithConnectionNumber(1),
+	)
+	client.RunEventLoop(func(ss Session) error {
+		return newSessionCallback(ss, &clientHandler)
+	})
+	defer client.Close()
+
+	// the message is much larger than the max message length of the sessions
+	assert.Equal(t, 1, clientHandler.SessionNumber())
+	ss := clientHandler.array[0]
+	assert.Nil(t, ss.WriteBytes(make([]byte, 64<<10)))
+	select {
+	case size := <-serverHandler.sizes:
+		assert.Equal(t, int64(64<<10), size)
+	case <-time.After(3 * time.Second):
+		t.Fatal("the stream message is not received")
+	}
+}
+
+func TestSessionWritePkgTTL(t *testing.T) {
+	src, dst := newPipeSessions(t)
+	src.SetPkgHandler(&lineTransferCodec{})
+	src.SetEventListener(&lineListener{msgs: make(chan interface{}, 4)})
+	src.SetWQLen(4)
+	src.run()
+	defer src.Close()
+
+	// the write goroutine is blocked by "first" until the peer reads
+	assert.Nil(t, src.WritePkg("first", time.Second))
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, src.WritePkgTTL("late", 50*time.Millisecond, time.Second))
+	assert.Nil(t, src.WritePkgTTL("fresh", time.Minute, time.Second))
+	time.Sleep(100 * time.Millisecond)
+
+	reader := bufio.NewReader(dst.Conn())
+	line, err := reader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "first\n", line)
+	line, err = reader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "fresh\n", line)
+	assert.Equal(t, uint64(1), src.metrics.ExpiredPkgNum())
+}
+
+func TestQueuedPkgExpire(t *testing.T) {
+	p := queuedPkg{pkg: "hello", expire: time.Now().Add(time.Minute)}
+	assert.Nil(t, p.dropReason())
+	p.expire = time.Now().Add(-time.Millisecond)
+	assert.Equal(t, ErrPkgExpired, p.dropReason())
+	assert.True(t, isGettyError(ErrPkgExpired))
+}
@@ -0,0 +1,136 @@
+/******************************************************
+# DESC       : rate limited session logger of apps
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-03 10:20
+# FILE       : sessionlog.go
+******************************************************/
+
+package getty
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+const (
+	defaultSessionLogRate  = 10
+	defaultSessionLogBurst = 20
+)
+
+// SessionLogConfig is the rate limit of the session loggers of an endpoint.
+type SessionLogConfig struct {
+	// the max number of logs written by a session logger per second. Its default value is 10.
+	Rate int
+	// the max number of logs written by a session logger at once. Its default value is 20.
+	Burst int
+}
+
+func (c SessionLogConfig) withDefaults() SessionLogConfig {
+	if c.Rate <= 0 {
+		c.Rate = defaultSessionLogRate
+	}
+	if c.Burst <= 0 {
+		c.Burst = defaultSessionLogBurst
+	}
+
+	return c
+}
+
+// SessionLogger is the logger of a session for the application handlers, which prefixes
+// the logs with the session token, i.e. {name:type:id:local<->remote}, so the logs of a
+// session are correlated with the logs of getty. The logs beyond the rate limit are dropped,
+// and the number of the dropped logs is appended to the next written log.
+type SessionLogger struct {
+	session *session
+	config  SessionLogConfig
+
+	lock       sync.Mutex
+	tokens     float64
+	last       time.Time
+	suppressed int
+}
+
+func newSessionLogger(s *session, config SessionLogConfig) *SessionLogger {
+	c := config.withDefaults()
+	return &SessionLogger{
+		session: s,
+		config:  c,
+		tokens:  float64(c.Burst),
+	}
+}
+
+// check whether a log can be written at @now by the token bucket. It returns the number of
+// the logs suppressed before if it can.
+func (l *SessionLogger) allow(now time.Time) (bool, int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.config.Rate)
+		if l.tokens > float64(l.config.Burst) {
+			l.tokens = float64(l.config.Burst)
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		l.suppressed++
+		return false, 0
+	}
+
+	l.tokens--
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return true, suppressed
+}
+
+func (l *SessionLogger) message(suppressed int, format string, args ...interface{}) string {
+	msg := fmt.Sprintf("%s, %s", l.session.sessionKey(), fmt.Sprintf(format, args...))
+	if suppressed > 0 {
+		msg += fmt.Sprintf(" (%d logs suppressed)", suppressed)
+	}
+
+	return msg
+}
+
+func (l *SessionLogger) log(lvl log.Level, format string, args ...interface{}) {
+	ok, suppressed := l.allow(time.Now())
+	if !ok {
+		return
+	}
+
+	var src string
+	if pc, file, line, ok := runtime.Caller(2); ok {
+		src = fmt.Sprintf("%s:%s:%d", filepath.Base(file), runtime.FuncForPC(pc).Name(), line)
+	}
+	log.Log(lvl, src, l.message(suppressed, format, args...))
+}
+
+func (l *SessionLogger) Debug(format string, args ...interface{}) {
+	l.log(log.DEBUG, format, args...)
+}
+
+func (l *SessionLogger) Info(format string, args ...interface{}) {
+	l.log(log.INFO, format, args...)
+}
+
+func (l *SessionLogger) Warn(format string, args ...interface{}) {
+	l.log(log.WARNING, format, args...)
+}
+
+func (l *SessionLogger) Error(format string, args ...interface{}) {
+	l.log(log.ERROR, format, args...)
+}
+
+// Logger returns the rate limited logger of the session.
+func (s *session) Logger() *SessionLogger {
+	return s.logger
+}
@@ -0,0 +1,60 @@
+package getty
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionLoggerRateLimit(t *testing.T) {
+	ss, _ := newPipeSessions(t)
+	l := newSessionLogger(ss, SessionLogConfig{Rate: 2, Burst: 3})
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		ok, suppressed := l.allow(now)
+		assert.True(t, ok)
+		assert.Equal(t, 0, suppressed)
+	}
+	for i := 0; i < 4; i++ {
+		ok, _ := l.allow(now)
+		assert.False(t, ok)
+	}
+
+	// a token is refilled every 500ms
+	ok, suppressed := l.allow(now.Add(500 * time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, 4, suppressed)
+	ok, _ = l.allow(now.Add(500 * time.Millisecond))
+	assert.False(t, ok)
+
+	// the tokens are capped by the burst
+	for i := 0; i < 3; i++ {
+		ok, _ = l.allow(now.Add(time.Minute))
+		assert.True(t, ok)
+	}
+	ok, _ = l.allow(now.Add(time.Minute))
+	assert.False(t, ok)
+}
+
+func TestSessionLoggerMessage(t *testing.T) {
+	clt := newClient(TCP_CLIENT,
+		WithServerAddress("127.0.0.1:0"),
+		WithConnectionNumber(1),
+		WithClientSessionLog(SessionLogConfig{Rate: 1}),
+	)
+	ss, _ := newPipeSessions(t)
+	ss.endPoint = clt
+	ss.logger = newSessionLogger(ss, clt.getSessionLogConfig())
+	assert.Equal(t, SessionLogConfig{Rate: 1, Burst: defaultSessionLogBurst}, ss.Logger().config)
+
+	msg := ss.Logger().message(2, "order %d rejected", 7)
+	assert.True(t, strings.HasPrefix(msg, ss.sessionKey()+", "), msg)
+	assert.True(t, strings.HasSuffix(msg, "order 7 rejected (2 logs suppressed)"), msg)
+
+	ss.Logger().Info("hello %s", "world")
+}
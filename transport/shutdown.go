@@ -0,0 +1,126 @@
+/******************************************************
+# DESC       : concurrent session close of server shutdown
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-01 10:40
+# FILE       : shutdown.go
+******************************************************/
+
+package getty
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+const (
+	defaultCloseWorkers = 64
+	defaultCloseTimeout = 5 * time.Second
+)
+
+// the endpoint which tracks its running sessions
+type sessionTracker interface {
+	addSession(*session)
+	removeSession(*session)
+}
+
+func (s *server) addSession(ss *session) {
+	s.sessionLock.Lock()
+	if s.sessions == nil {
+		s.sessions = make(map[*session]struct{})
+	}
+	s.sessions[ss] = struct{}{}
+	s.sessionLock.Unlock()
+}
+
+func (s *server) removeSession(ss *session) {
+	s.sessionLock.Lock()
+	delete(s.sessions, ss)
+	s.sessionLock.Unlock()
+}
+
+// close all running sessions of the server concurrently before the close timeout
+func (s *server) closeSessions() {
+	s.sessionLock.Lock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for ss := range s.sessions {
+		sessions = append(sessions, ss)
+	}
+	s.sessionLock.Unlock()
+	if len(sessions) == 0 {
+		return
+	}
+
+	workers, timeout := s.closeWorkers, s.closeTimeout
+	if workers <= 0 {
+		workers = defaultCloseWorkers
+	}
+	if timeout <= 0 {
+		timeout = defaultCloseTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	waited := closeSessions(ctx, sessions, workers)
+	log.Info("server{%s} closed %d sessions in %s, %d of them exited before the deadline",
+		s.addr, len(sessions), time.Since(start), waited)
+}
+
+// close @ss and wake up its read goroutine at once, which would block until the read timeout.
+// The write goroutine still sends out the write queue before @ctx is done.
+func closeSession(ctx context.Context, ss *session) error {
+	ss.Close()
+	if conn := ss.Conn(); conn != nil {
+		conn.SetReadDeadline(time.Now())
+	}
+
+	return ss.CloseContext(ctx)
+}
+
+// close @sessions by @workers goroutines. A session is waited until its goroutines exit or
+// @ctx is done, and the sessions left after @ctx is done are closed without waiting. It
+// returns the number of the sessions which have exited in time.
+func closeSessions(ctx context.Context, sessions []*session, workers int) int {
+	if workers > len(sessions) {
+		workers = len(sessions)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		waited int
+		queue  = make(chan *session)
+	)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for ss := range queue {
+				if closeSession(ctx, ss) == nil {
+					lock.Lock()
+					waited++
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, ss := range sessions {
+		select {
+		case queue <- ss:
+		case <-ctx.Done():
+			closeSession(ctx, ss)
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	return waited
+}
@@ -0,0 +1,82 @@
+package getty
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloseSessions(t *testing.T) {
+	var sessions []*session
+	for i := 0; i < 100; i++ {
+		src, dst := newPipeSessions(t)
+		for _, ss := range []*session{src, dst} {
+			ss.SetPkgHandler(&lineTransferCodec{})
+			ss.SetEventListener(&lineListener{msgs: make(chan interface{}, 4)})
+			ss.run()
+			sessions = append(sessions, ss)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Equal(t, len(sessions), closeSessions(ctx, sessions, 8))
+	for _, ss := range sessions {
+		assert.True(t, ss.IsClosed())
+	}
+}
+
+func TestCloseSessionsDeadline(t *testing.T) {
+	// the peer of @src never reads, so @src can not send out its write queue
+	src, _ := newPipeSessions(t)
+	src.SetPkgHandler(&lineTransferCodec{})
+	src.SetEventListener(&lineListener{msgs: make(chan interface{}, 4)})
+	src.SetWQLen(16)
+	src.SetWaitTime(time.Minute)
+	src.run()
+	for i := 0; i < 8; i++ {
+		assert.Nil(t, src.WritePkg("hello", time.Second))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	closeSessions(ctx, []*session{src}, 1)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.True(t, src.IsClosed())
+}
+
+func TestServerCloseSessions(t *testing.T) {
+	var serverMsgHandler, msgHandler MessageHandler
+
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerShutdown(4, time.Second))
+	server.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &serverMsgHandler)
+	})
+
+	clt := newClient(TCP_CLIENT,
+		WithServerAddress(server.streamListener.Addr().String()),
+		WithConnectionNumber(2),
+	)
+	clt.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &msgHandler)
+	})
+	defer clt.Close()
+
+	for i := 0; i < 100 && serverMsgHandler.SessionNumber() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 2, serverMsgHandler.SessionNumber())
+
+	server.Close()
+	for _, ss := range serverMsgHandler.array {
+		assert.True(t, ss.IsClosed())
+	}
+	server.sessionLock.Lock()
+	assert.Equal(t, 0, len(server.sessions))
+	server.sessionLock.Unlock()
+}
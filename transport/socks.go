@@ -0,0 +1,90 @@
+/******************************************************
+# DESC       : socks5 transport of tor
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-17 15:20
+# FILE       : socks.go
+******************************************************/
+
+package getty
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// DefaultTorSOCKSAddress is the socks5 address of a local tor client
+	DefaultTorSOCKSAddress = "127.0.0.1:9050"
+	// building a tor circuit takes seconds
+	defaultSOCKSTimeout = 30e9
+)
+
+// SOCKSConfig is the config of the socks5 proxy, e.g. a tor client, of SOCKSDialer.
+type SOCKSConfig struct {
+	// the proxy address, DefaultTorSOCKSAddress if it is empty
+	Addr string
+	// the username/password authentication, no authentication if User is empty
+	User     string
+	Password string
+	// IsolateStreams authenticates every stream by a random username, so tor carries the
+	// streams by different circuits (IsolateSOCKSAuth). User & Password are ignored then.
+	IsolateStreams bool
+	// the timeout of the proxy connection and the socks5 handshake, 30s if it is not set
+	Timeout time.Duration
+}
+
+// SOCKSDialer returns the TransportDialer which connects the server address through the socks5
+// proxy of @config, e.g. a tor client. The server address is resolved by the proxy, so it can be
+// an onion address, e.g.
+//
+//	client := getty.NewTransportClient(getty.SOCKSDialer(getty.SOCKSConfig{IsolateStreams: true}),
+//		getty.WithServerAddress("xxxxxxxx.onion:10000"))
+//
+// The streams can be obfuscated by WrapTransportDialer like the pluggable transports of tor.
+func SOCKSDialer(config SOCKSConfig) TransportDialer {
+	if config.Addr == "" {
+		config.Addr = DefaultTorSOCKSAddress
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultSOCKSTimeout
+	}
+
+	return func(addr string) (net.Conn, error) {
+		var auth *proxy.Auth
+		switch {
+		case config.IsolateStreams:
+			auth = &proxy.Auth{User: randomSOCKSUser(), Password: "getty"}
+		case config.User != "":
+			auth = &proxy.Auth{User: config.User, Password: config.Password}
+		}
+
+		dialer, err := proxy.SOCKS5("tcp", config.Addr, auth, &net.Dialer{Timeout: config.Timeout})
+		if err != nil {
+			return nil, jerrors.Trace(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+		defer cancel()
+		conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, jerrors.Annotatef(err, "socks5 dial(proxy:%s, addr:%s)", config.Addr, addr)
+		}
+
+		return NewTransportConn("socks5", conn.LocalAddr().String(), addr, conn), nil
+	}
+}
+
+func randomSOCKSUser() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
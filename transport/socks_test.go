@@ -0,0 +1,206 @@
+package getty
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSOCKSProxy is a socks5 proxy of the CONNECT command, which records the usernames
+type fakeSOCKSProxy struct {
+	net.Listener
+	lock  sync.Mutex
+	users []string
+	addrs []string
+}
+
+func newFakeSOCKSProxy(t *testing.T) *fakeSOCKSProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	p := &fakeSOCKSProxy{Listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *fakeSOCKSProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	readBytes := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil
+		}
+		return b
+	}
+
+	// greeting: ver, nmethods, methods
+	head := readBytes(2)
+	if head == nil {
+		return
+	}
+	methods := readBytes(int(head[1]))
+	method := byte(0)
+	for _, m := range methods {
+		if m == 2 {
+			method = 2
+		}
+	}
+	conn.Write([]byte{5, method})
+	var user string
+	if method == 2 {
+		// ver, ulen, user, plen, password
+		b := readBytes(2)
+		user = string(readBytes(int(b[1])))
+		readBytes(int(readBytes(1)[0]))
+		conn.Write([]byte{1, 0})
+	}
+
+	// request: ver, cmd, rsv, atyp, addr, port
+	req := readBytes(4)
+	var host string
+	switch req[3] {
+	case 1:
+		host = net.IP(readBytes(4)).String()
+	case 3:
+		host = string(readBytes(int(readBytes(1)[0])))
+	default:
+		return
+	}
+	port := binary.BigEndian.Uint16(readBytes(2))
+	addr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	p.lock.Lock()
+	p.users = append(p.users, user)
+	p.addrs = append(p.addrs, addr)
+	p.lock.Unlock()
+
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	go io.Copy(target, r)
+	io.Copy(conn, target)
+}
+
+func TestSOCKSDialer(t *testing.T) {
+	socks := newFakeSOCKSProxy(t)
+	defer socks.Close()
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer target.Close()
+	_, port, _ := net.SplitHostPort(target.Addr().String())
+	addr := net.JoinHostPort("localhost", port)
+
+	// the hostname is resolved by the proxy
+	dial := SOCKSDialer(SOCKSConfig{Addr: socks.Addr().String(), User: "alice", Password: "secret"})
+	conn, err := dial(addr)
+	assert.Nil(t, err)
+	assert.Equal(t, "socks5", conn.RemoteAddr().Network())
+	assert.Equal(t, addr, conn.RemoteAddr().String())
+	peer, err := target.Accept()
+	assert.Nil(t, err)
+	_, err = conn.Write([]byte("hello"))
+	assert.Nil(t, err)
+	data := make([]byte, 5)
+	_, err = io.ReadFull(peer, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+	conn.Close()
+	peer.Close()
+
+	// every stream gets its own username
+	dial = SOCKSDialer(SOCKSConfig{Addr: socks.Addr().String(), IsolateStreams: true})
+	for i := 0; i < 2; i++ {
+		conn, err = dial(addr)
+		assert.Nil(t, err)
+		conn.Close()
+	}
+	socks.lock.Lock()
+	assert.Equal(t, []string{addr, addr, addr}, socks.addrs)
+	assert.Equal(t, "alice", socks.users[0])
+	assert.Len(t, socks.users[1], 16)
+	assert.NotEqual(t, socks.users[1], socks.users[2])
+	socks.lock.Unlock()
+
+	_, err = dial("127.0.0.1:1")
+	assert.NotNil(t, err)
+}
+
+func TestSOCKSObfuscatedClientServer(t *testing.T) {
+	socks := newFakeSOCKSProxy(t)
+	defer socks.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	server := NewTransportServer(WrapListener(l, xorWrapper(0x5a)))
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(&echoLineListener{})
+		return nil
+	})
+	defer server.Close()
+
+	dialer := WrapTransportDialer(SOCKSDialer(SOCKSConfig{Addr: socks.Addr().String()}), xorWrapper(0x5a))
+	client := NewTransportClient(dialer, WithServerAddress(l.Addr().String()), WithConnectionNumber(1))
+	defer client.Close()
+	clientHandler := &lineListener{msgs: make(chan interface{}, 4)}
+	client.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(clientHandler)
+		return nil
+	})
+	assert.Equal(t, 1, clientHandler.SessionNumber())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	assert.Nil(t, client.(ClientWriter).WritePkgContext(ctx, "hello"))
+	select {
+	case msg := <-clientHandler.msgs:
+		assert.Equal(t, "hello", msg)
+	case <-ctx.Done():
+		t.Fatal("the echo is not received")
+	}
+}
+
+func TestWrapConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	assert.Equal(t, c1, WrapConn(c1))
+
+	// the obfuscated bytes are seen on the wire
+	conn := WrapConn(c1, xorWrapper(0x5a))
+	go conn.Write([]byte("hello"))
+	data := make([]byte, 5)
+	_, err := io.ReadFull(c2, data)
+	assert.Nil(t, err)
+	_, err = io.ReadFull(xorReader{r: bytes.NewReader(data), key: 0x5a}, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	go xorWriter{w: c2, key: 0x5a}.Write([]byte("world"))
+	_, err = io.ReadFull(conn, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "world", string(data))
+}
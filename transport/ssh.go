@@ -0,0 +1,164 @@
+/******************************************************
+# DESC       : ssh channel transport
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-17 10:10
+# FILE       : ssh.go
+******************************************************/
+
+package getty
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+// SSHClient is the ssh client connection of a hop, e.g. the *ssh.Client of
+// golang.org/x/crypto/ssh. Dial opens a direct-tcpip channel to @addr through the hop.
+type SSHClient interface {
+	Dial(network, addr string) (net.Conn, error)
+	Close() error
+}
+
+// SSHHandshake runs the ssh client handshake over @conn to the hop @addr, which verifies the
+// host key and authenticates the user, e.g. by the keys of golang.org/x/crypto/ssh:
+//
+//	func(conn net.Conn, addr string) (getty.SSHClient, error) {
+//		c, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+//			User:            "ops",
+//			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+//			HostKeyCallback: ssh.FixedHostKey(hostKey),
+//		})
+//		if err != nil {
+//			return nil, err
+//		}
+//		return ssh.NewClient(c, chans, reqs), nil
+//	}
+type SSHHandshake func(conn net.Conn, addr string) (SSHClient, error)
+
+// SSHHop is a ssh server of the jump chain, i.e. a bastion.
+type SSHHop struct {
+	Addr      string
+	Handshake SSHHandshake
+}
+
+// SSHDialer dials the getty server by the ssh channels through a chain of jump hosts. The
+// first hop is dialed by tcp, every next hop is reached by a channel of the previous one, and
+// the server is reached by a channel of the last hop. The chain is shared by the streams of
+// the dialer, and it is rebuilt at the next Dial if a channel fails to be opened.
+type SSHDialer struct {
+	hops    []SSHHop
+	timeout time.Duration
+
+	lock    sync.Mutex
+	clients []SSHClient
+}
+
+// NewSSHDialer builds the ssh dialer through the jump chain @hops, whose first hop is dialed
+// within @timeout, e.g.
+//
+//	dialer := getty.NewSSHDialer(3*time.Second, bastion, innerBastion)
+//	client := getty.NewTransportClient(dialer.Dial, getty.WithServerAddress("10.0.0.8:10000"))
+//
+// Pls close the dialer after the client is closed, for the chain is not closed by the client.
+func NewSSHDialer(timeout time.Duration, hops ...SSHHop) *SSHDialer {
+	if len(hops) == 0 {
+		panic("NewSSHDialer(hops):@hops is empty")
+	}
+	for _, hop := range hops {
+		if hop.Handshake == nil {
+			panic("NewSSHDialer(hops):the handshake of hop " + hop.Addr + " is nil")
+		}
+	}
+	if timeout <= 0 {
+		timeout = connectTimeout
+	}
+
+	return &SSHDialer{hops: hops, timeout: timeout}
+}
+
+// Dial opens a ssh channel to @addr through the jump chain, which is a TransportDialer. The
+// channels do not support the deadlines, so the streams are closed by the sessions after the
+// read timeout once they stop.
+func (d *SSHDialer) Dial(addr string) (net.Conn, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.clients == nil {
+		clients, err := d.connect()
+		if err != nil {
+			return nil, jerrors.Trace(err)
+		}
+		d.clients = clients
+	}
+
+	conn, err := d.clients[len(d.clients)-1].Dial("tcp", addr)
+	if err != nil {
+		// the chain may be broken, e.g. a bastion restarts
+		closeSSHClients(d.clients)
+		d.clients = nil
+		return nil, jerrors.Annotatef(err, "ssh dial(addr:%s)", addr)
+	}
+
+	return NewTransportConn("ssh", conn.LocalAddr().String(), addr, sshChannel{conn}), nil
+}
+
+// Close closes the jump chain, and so the channels over it.
+func (d *SSHDialer) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	closeSSHClients(d.clients)
+	d.clients = nil
+	return nil
+}
+
+// connect the hops one by one
+func (d *SSHDialer) connect() ([]SSHClient, error) {
+	var (
+		err     error
+		conn    net.Conn
+		client  SSHClient
+		clients []SSHClient
+	)
+
+	for i, hop := range d.hops {
+		if i == 0 {
+			conn, err = net.DialTimeout("tcp", hop.Addr, d.timeout)
+		} else {
+			conn, err = clients[i-1].Dial("tcp", hop.Addr)
+		}
+		if err != nil {
+			closeSSHClients(clients)
+			return nil, jerrors.Annotatef(err, "dial ssh hop(addr:%s)", hop.Addr)
+		}
+
+		if client, err = hop.Handshake(conn, hop.Addr); err != nil {
+			conn.Close()
+			closeSSHClients(clients)
+			return nil, jerrors.Annotatef(err, "ssh handshake(addr:%s)", hop.Addr)
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, nil
+}
+
+// close the clients of the chain from the last hop
+func closeSSHClients(clients []SSHClient) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
+
+// sshChannel hides the deadline methods of the channel conn, which fail as not supported.
+type sshChannel struct {
+	io.ReadWriteCloser
+}
@@ -0,0 +1,170 @@
+package getty
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBastion forwards a conn to the address of its first line, like a direct-tcpip channel
+func fakeBastion(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				addr, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				target, err := net.Dial("tcp", addr[:len(addr)-1])
+				if err != nil {
+					return
+				}
+				defer target.Close()
+				go io.Copy(target, r)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return l
+}
+
+// fakeSSHClient opens one channel over its conn by the fake bastion
+type fakeSSHClient struct {
+	conn   net.Conn
+	lock   sync.Mutex
+	used   bool
+	closed bool
+}
+
+func (c *fakeSSHClient) Dial(network, addr string) (net.Conn, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.used || c.closed {
+		return nil, errors.New("channel open failed")
+	}
+	c.used = true
+	if _, err := c.conn.Write([]byte(addr + "\n")); err != nil {
+		return nil, err
+	}
+	return c.conn, nil
+}
+
+func (c *fakeSSHClient) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	return nil
+}
+
+type fakeSSHHandshakes struct {
+	lock    sync.Mutex
+	addrs   []string
+	clients []*fakeSSHClient
+}
+
+func (h *fakeSSHHandshakes) handshake(conn net.Conn, addr string) (SSHClient, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	client := &fakeSSHClient{conn: conn}
+	h.addrs = append(h.addrs, addr)
+	h.clients = append(h.clients, client)
+	return client, nil
+}
+
+func TestNewSSHDialer(t *testing.T) {
+	assert.Panics(t, func() { NewSSHDialer(0) })
+	assert.Panics(t, func() { NewSSHDialer(0, SSHHop{Addr: "bastion:22"}) })
+	assert.Equal(t, time.Duration(connectTimeout), NewSSHDialer(0, SSHHop{Addr: "bastion:22", Handshake: (&fakeSSHHandshakes{}).handshake}).timeout)
+}
+
+func TestSSHDialerJumpChain(t *testing.T) {
+	b1, b2 := fakeBastion(t), fakeBastion(t)
+	defer b1.Close()
+	defer b2.Close()
+
+	var handshakes fakeSSHHandshakes
+	dialer := NewSSHDialer(time.Second,
+		SSHHop{Addr: b1.Addr().String(), Handshake: handshakes.handshake},
+		SSHHop{Addr: b2.Addr().String(), Handshake: handshakes.handshake})
+
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(&echoLineListener{})
+		return nil
+	})
+	defer server.Close()
+	addr := server.streamListener.Addr().String()
+
+	client := NewTransportClient(dialer.Dial, WithServerAddress(addr), WithConnectionNumber(1))
+	clientHandler := &lineListener{msgs: make(chan interface{}, 4)}
+	client.RunEventLoop(func(ss Session) error {
+		assert.Equal(t, "ssh", ss.Conn().RemoteAddr().Network())
+		assert.Equal(t, addr, ss.RemoteAddr())
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(clientHandler)
+		return nil
+	})
+	assert.Equal(t, 1, clientHandler.SessionNumber())
+	assert.Equal(t, []string{b1.Addr().String(), b2.Addr().String()}, handshakes.addrs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	assert.Nil(t, client.(ClientWriter).WritePkgContext(ctx, "hello"))
+	select {
+	case msg := <-clientHandler.msgs:
+		assert.Equal(t, "hello", msg)
+	case <-ctx.Done():
+		t.Fatal("the echo is not received")
+	}
+	client.Close()
+
+	// the fake chain opens one channel only, so the chain is closed and rebuilt
+	_, err := dialer.Dial(addr)
+	assert.NotNil(t, err)
+	assert.Nil(t, dialer.clients)
+	for _, c := range handshakes.clients {
+		assert.True(t, c.closed)
+	}
+	conn, err := dialer.Dial(addr)
+	assert.Nil(t, err)
+	assert.Len(t, handshakes.addrs, 4)
+	conn.Close()
+
+	assert.Nil(t, dialer.Close())
+	assert.Nil(t, dialer.clients)
+	assert.True(t, handshakes.clients[3].closed)
+}
+
+func TestSSHDialerHandshakeError(t *testing.T) {
+	b1 := fakeBastion(t)
+	defer b1.Close()
+
+	var handshakes fakeSSHHandshakes
+	dialer := NewSSHDialer(time.Second,
+		SSHHop{Addr: b1.Addr().String(), Handshake: handshakes.handshake},
+		SSHHop{Addr: "127.0.0.1:1", Handshake: func(net.Conn, string) (SSHClient, error) {
+			return nil, errors.New("unable to authenticate")
+		}})
+	_, err := dialer.Dial("127.0.0.1:2")
+	assert.NotNil(t, err)
+	assert.Nil(t, dialer.clients)
+	assert.True(t, handshakes.clients[0].closed)
+}
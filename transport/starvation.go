@@ -0,0 +1,97 @@
+/******************************************************
+# DESC       : write queue starvation monitor
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-23 10:30
+# FILE       : starvation.go
+******************************************************/
+
+package getty
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WriteStarvationListener can be implemented by an EventListener which wants to be notified
+// when a pkg of its session waits in the write queue longer than the starvation threshold of
+// the endpoint, e.g. the writer of the session is blocked by a slow peer, or the session is
+// starved by the others. OnWriteStarvation is invoked by the write goroutine of the session
+// at most once per threshold, and @wait is the queue delay of the starved pkg.
+type WriteStarvationListener interface {
+	OnWriteStarvation(ss Session, wait time.Duration)
+}
+
+// WriteStarvationStats is the write queue delay of a session, which is monitored if the
+// starvation threshold of its endpoint is set.
+type WriteStarvationStats struct {
+	// the queue delay of the last sent pkg
+	LastDelay time.Duration
+	// the max queue delay of the sent pkgs
+	MaxDelay time.Duration
+	// number of the pkgs whose queue delay exceeds the threshold
+	StarvedPkgNum uint64
+}
+
+// StarvedPkgNum returns the number of the pkgs which wait in the write queues longer than
+// the starvation threshold, see WithServerStarvationThreshold.
+func (m *EndPointMetrics) StarvedPkgNum() uint64 {
+	return atomic.LoadUint64(&m.starvedPkgNum)
+}
+
+// the time when a pkg is queued, if the starvation is monitored
+func (s *session) queueTime() time.Time {
+	if s.starvationThreshold <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now()
+}
+
+// record the queue delay of @p, which is invoked by the write goroutine when @p is dequeued
+func (s *session) recordQueueDelay(p queuedPkg) {
+	if p.queued.IsZero() {
+		return
+	}
+
+	now := time.Now()
+	wait := now.Sub(p.queued)
+	s.metrics.QueueDelay.Record(wait)
+	atomic.StoreInt64(&s.starvation.lastDelay, int64(wait))
+	if int64(wait) > atomic.LoadInt64(&s.starvation.maxDelay) {
+		atomic.StoreInt64(&s.starvation.maxDelay, int64(wait))
+	}
+	if wait <= s.starvationThreshold {
+		return
+	}
+
+	atomic.AddUint64(&s.starvation.starvedPkgNum, 1)
+	atomic.AddUint64(&s.metrics.starvedPkgNum, 1)
+	if now.Sub(s.starvation.lastAlarm) < s.starvationThreshold {
+		return
+	}
+	s.starvation.lastAlarm = now
+	if listener, ok := s.getListener().(WriteStarvationListener); ok {
+		listener.OnWriteStarvation(s, wait)
+	}
+}
+
+// WriteStarvation returns the write queue delay of the session.
+func (s *session) WriteStarvation() WriteStarvationStats {
+	return WriteStarvationStats{
+		LastDelay:     time.Duration(atomic.LoadInt64(&s.starvation.lastDelay)),
+		MaxDelay:      time.Duration(atomic.LoadInt64(&s.starvation.maxDelay)),
+		StarvedPkgNum: atomic.LoadUint64(&s.starvation.starvedPkgNum),
+	}
+}
+
+// the write queue delay of a session. the 64-bit fields are kept at the head for the atomic
+// operations on 32-bit platforms.
+type sessionStarvation struct {
+	starvedPkgNum uint64
+	lastDelay     int64
+	maxDelay      int64
+	// the time of the last alarm, which is only used by the write goroutine
+	lastAlarm time.Time
+}
@@ -0,0 +1,63 @@
+package getty
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type starvationListener struct {
+	MessageHandler
+
+	waits []time.Duration
+}
+
+func (l *starvationListener) OnWriteStarvation(ss Session, wait time.Duration) {
+	l.waits = append(l.waits, wait)
+}
+
+func TestSessionWriteStarvation(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1),
+		WithClientStarvationThreshold(20*time.Millisecond))
+	ss := newTCPSession(c1, clt).(*session)
+	ss.SetWQLen(4)
+	listener := &starvationListener{}
+	ss.SetEventListener(listener)
+
+	assert.Nil(t, ss.WritePkg("fresh", time.Second))
+	ss.recordQueueDelay(unwrapQueuedPkg(<-ss.wQ))
+	assert.Equal(t, uint64(0), ss.WriteStarvation().StarvedPkgNum)
+	assert.Empty(t, listener.waits)
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, ss.WritePkg("starved", time.Second))
+	}
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		ss.recordQueueDelay(unwrapQueuedPkg(<-ss.wQ))
+	}
+
+	// the alarm is raised at most once per threshold
+	assert.Equal(t, 1, len(listener.waits))
+	assert.True(t, 30*time.Millisecond <= listener.waits[0])
+	stats := ss.WriteStarvation()
+	assert.Equal(t, uint64(2), stats.StarvedPkgNum)
+	assert.True(t, 30*time.Millisecond <= stats.LastDelay)
+	assert.True(t, stats.LastDelay <= stats.MaxDelay)
+	assert.Equal(t, uint64(2), clt.Metrics().StarvedPkgNum())
+	assert.Equal(t, uint64(3), clt.Metrics().QueueDelay.Count())
+
+	// the pkgs of the sessions without the threshold are not stamped
+	ss, _ = newPipeSessions(t)
+	ss.SetWQLen(1)
+	assert.Nil(t, ss.WritePkg("pkg", time.Second))
+	assert.True(t, unwrapQueuedPkg(<-ss.wQ).queued.IsZero())
+}
@@ -0,0 +1,555 @@
+/******************************************************
+# DESC       : stream multiplexing with flow control
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-22 11:00
+# FILE       : streammux.go
+******************************************************/
+
+package getty
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	muxFrameOpen   = 1 // the window of the opener(4B)
+	muxFrameData   = 2 // the pkg encoded by the inner codec
+	muxFrameCredit = 3 // the granted credit(4B)
+	muxFrameFin    = 4
+	muxFrameReset  = 5 // the reason length(2B) | reason
+
+	// type(1B) + stream id(4B)
+	muxHeaderLen = 5
+
+	defaultStreamWindow     = 64
+	defaultMaxStreams       = 256
+	maxStreamResetReasonLen = 1<<16 - 1
+
+	// the reset reasons
+	streamRefusedReason  = "too many streams"
+	streamNoAcceptReason = "streams are not accepted"
+	flowViolationReason  = "flow control violation"
+)
+
+var (
+	errIllegalMuxFrame  = jerrors.New("illegal stream mux frame")
+	errStreamClosed     = jerrors.New("stream closed")
+	errStreamSendClosed = jerrors.New("stream send side closed")
+)
+
+// StreamMuxConfig is the config of a StreamMux
+type StreamMuxConfig struct {
+	// the receive window of a stream in pkgs, i.e. the max number of the pkgs which the peer
+	// can send before they are received by (*Stream)Recv. Its default value is 64.
+	Window int
+	// the max number of the concurrent streams opened by the peer. Its default value is 256.
+	MaxStreams int
+	// invoked in a new goroutine when the peer opens a stream. The streams opened by the
+	// peer are reset if it is nil.
+	OnStream func(*Stream)
+}
+
+func (c StreamMuxConfig) withDefaults() StreamMuxConfig {
+	if c.Window <= 0 {
+		c.Window = defaultStreamWindow
+	}
+	if c.MaxStreams <= 0 {
+		c.MaxStreams = defaultMaxStreams
+	}
+
+	return c
+}
+
+type muxFrame struct {
+	typ    byte
+	id     uint32
+	pkg    interface{}
+	credit uint32
+	reason string
+}
+
+/////////////////////////////////////////
+// codec
+/////////////////////////////////////////
+
+type muxCodec struct {
+	inner ReadWriter
+}
+
+func (c *muxCodec) Read(ss Session, data []byte) (interface{}, int, error) {
+	if len(data) < muxHeaderLen {
+		return nil, 0, nil
+	}
+
+	f := &muxFrame{typ: data[0], id: binary.BigEndian.Uint32(data[1:])}
+	switch f.typ {
+	case muxFrameFin:
+		return f, muxHeaderLen, nil
+
+	case muxFrameOpen, muxFrameCredit:
+		if len(data) < muxHeaderLen+4 {
+			return nil, 0, nil
+		}
+		f.credit = binary.BigEndian.Uint32(data[muxHeaderLen:])
+		return f, muxHeaderLen + 4, nil
+
+	case muxFrameReset:
+		if len(data) < muxHeaderLen+2 {
+			return nil, 0, nil
+		}
+		reasonLen := int(binary.BigEndian.Uint16(data[muxHeaderLen:]))
+		if len(data) < muxHeaderLen+2+reasonLen {
+			return nil, 0, nil
+		}
+		f.reason = string(data[muxHeaderLen+2 : muxHeaderLen+2+reasonLen])
+		return f, muxHeaderLen + 2 + reasonLen, nil
+
+	case muxFrameData:
+		pkg, pkgLen, err := c.inner.Read(ss, data[muxHeaderLen:])
+		if err != nil || pkg == nil {
+			return nil, 0, err
+		}
+		f.pkg = pkg
+		return f, muxHeaderLen + pkgLen, nil
+	}
+
+	return nil, 0, errIllegalMuxFrame
+}
+
+func (c *muxCodec) Write(ss Session, pkg interface{}) ([]byte, error) {
+	f, ok := pkg.(*muxFrame)
+	if !ok {
+		// the pkgs written to the session directly are carried by the stream 0
+		f = &muxFrame{typ: muxFrameData, pkg: pkg}
+	}
+
+	b := make([]byte, muxHeaderLen, muxHeaderLen+4)
+	b[0] = f.typ
+	binary.BigEndian.PutUint32(b[1:], f.id)
+	switch f.typ {
+	case muxFrameOpen, muxFrameCredit:
+		b = append(b, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[muxHeaderLen:], f.credit)
+
+	case muxFrameReset:
+		reason := f.reason
+		if maxStreamResetReasonLen < len(reason) {
+			reason = reason[:maxStreamResetReasonLen]
+		}
+		b = append(b, byte(len(reason)>>8), byte(len(reason)))
+		b = append(b, reason...)
+
+	case muxFrameData:
+		data, err := c.inner.Write(ss, f.pkg)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, data...)
+	}
+
+	return b, nil
+}
+
+/////////////////////////////////////////
+// listener
+/////////////////////////////////////////
+
+type muxListener struct {
+	EventListener
+
+	mux *StreamMux
+}
+
+func (l *muxListener) OnMessage(ss Session, pkg interface{}) {
+	f, ok := pkg.(*muxFrame)
+	if !ok {
+		l.EventListener.OnMessage(ss, pkg)
+		return
+	}
+	if f.id == 0 && f.typ == muxFrameData {
+		l.EventListener.OnMessage(ss, f.pkg)
+		return
+	}
+	l.mux.handle(f)
+}
+
+func (l *muxListener) OnClose(ss Session) {
+	l.mux.close()
+	l.EventListener.OnClose(ss)
+}
+
+/////////////////////////////////////////
+// stream mux
+/////////////////////////////////////////
+
+// StreamMux multiplexes the bidirectional streams over a tcp/ws session. Every stream has
+// a credit based flow control, so a slow receiver only blocks the senders of its stream.
+// Both peers of the session should build their StreamMux.
+type StreamMux struct {
+	session Session
+	writer  SessionWriter
+	config  StreamMuxConfig
+	lock    sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	closed  bool
+}
+
+// NewStreamMux sets the codec & event listener of @ss in NewSessionCallback, e.g.
+//
+//	func newSession(ss getty.Session) error {
+//		getty.NewStreamMux(ss, codec, listener, getty.StreamMuxConfig{OnStream: serve})
+//		return nil
+//	}
+//
+// The pkgs are encoded by @inner. The pkgs written to @ss directly are delivered to
+// @listener as before. The frames of a stream should be handled in order, so @ss should
+// not use a task pool.
+func NewStreamMux(ss Session, inner ReadWriter, listener EventListener, config StreamMuxConfig) *StreamMux {
+	writer, ok := ss.(SessionWriter)
+	if !ok {
+		panic("NewStreamMux(ss):@ss is not a SessionWriter")
+	}
+
+	m := &StreamMux{
+		session: ss,
+		writer:  writer,
+		config:  config.withDefaults(),
+		streams: make(map[uint32]*Stream),
+		nextID:  1,
+	}
+	// the streams opened by the servers have even ids
+	if isServerEndPoint(ss.EndPoint()) {
+		m.nextID = 2
+	}
+	ss.SetPkgHandler(&muxCodec{inner: inner})
+	ss.SetEventListener(&muxListener{EventListener: listener, mux: m})
+
+	return m
+}
+
+func isServerEndPoint(endPoint EndPoint) bool {
+	if endPoint == nil {
+		return false
+	}
+	switch endPoint.EndPointType() {
+	case TCP_SERVER, WS_SERVER, WSS_SERVER, UNIX_SERVER, TRANSPORT_SERVER:
+		return true
+	}
+
+	return false
+}
+
+// OpenStream opens a new stream. Its pkgs can be sent once the peer grants the credit.
+func (m *StreamMux) OpenStream() (*Stream, error) {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return nil, ErrSessionClosed
+	}
+	s := newStream(m, m.nextID, 0)
+	m.nextID += 2
+	m.streams[s.id] = s
+	m.lock.Unlock()
+
+	if err := m.write(&muxFrame{typ: muxFrameOpen, id: s.id, credit: uint32(m.config.Window)}); err != nil {
+		m.remove(s.id)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// StreamNum returns the number of the open streams.
+func (m *StreamMux) StreamNum() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.streams)
+}
+
+// write @f to the session. The frames are never dropped from the write queue, for a lost
+// frame breaks the credits or the state of its stream.
+func (m *StreamMux) write(f *muxFrame) error {
+	return m.writer.WritePkgContext(context.Background(), f)
+}
+
+func (m *StreamMux) get(id uint32) *Stream {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.streams[id]
+}
+
+func (m *StreamMux) remove(id uint32) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.streams, id)
+}
+
+// handle the frame @f from the peer. It is invoked by the read goroutine of the session.
+func (m *StreamMux) handle(f *muxFrame) {
+	if f.typ == muxFrameOpen {
+		m.accept(f)
+		return
+	}
+
+	s := m.get(f.id)
+	if s == nil {
+		// the stream has been closed
+		return
+	}
+	switch f.typ {
+	case muxFrameData:
+		if !s.push(f.pkg) {
+			s.reset(flowViolationReason)
+		}
+	case muxFrameCredit:
+		s.grant(f.credit)
+	case muxFrameFin:
+		s.finish()
+	case muxFrameReset:
+		s.fail(newGettyError(ErrStreamReset, jerrors.New(f.reason)))
+		m.remove(s.id)
+	}
+}
+
+func (m *StreamMux) accept(f *muxFrame) {
+	var reason string
+	m.lock.Lock()
+	switch {
+	case m.closed:
+		m.lock.Unlock()
+		return
+	case m.config.OnStream == nil:
+		reason = streamNoAcceptReason
+	case m.config.MaxStreams <= len(m.streams):
+		reason = streamRefusedReason
+	case m.streams[f.id] != nil:
+		reason = errIllegalMuxFrame.Error()
+	}
+	if reason != "" {
+		m.lock.Unlock()
+		m.write(&muxFrame{typ: muxFrameReset, id: f.id, reason: reason})
+		return
+	}
+	s := newStream(m, f.id, f.credit)
+	m.streams[s.id] = s
+	m.lock.Unlock()
+
+	m.write(&muxFrame{typ: muxFrameCredit, id: s.id, credit: uint32(m.config.Window)})
+	go m.config.OnStream(s)
+}
+
+// fail all streams after the session is closed
+func (m *StreamMux) close() {
+	m.lock.Lock()
+	streams := m.streams
+	m.streams = make(map[uint32]*Stream)
+	m.closed = true
+	m.lock.Unlock()
+
+	for _, s := range streams {
+		s.fail(ErrSessionClosed)
+	}
+}
+
+/////////////////////////////////////////
+// stream
+/////////////////////////////////////////
+
+// Stream is a bidirectional stream of a StreamMux. Send and Recv can be invoked concurrently
+// with each other, but neither of them should be invoked concurrently with itself.
+type Stream struct {
+	mux *StreamMux
+	id  uint32
+
+	lock sync.Mutex
+	// the number of the pkgs which can be sent
+	credit     uint32
+	sendSignal chan struct{}
+	sendClosed bool
+	// the received pkgs
+	queue      []interface{}
+	recvSignal chan struct{}
+	// the number of the pkgs received by Recv whose credit has not been granted
+	consumed uint32
+	// the peer has closed its send side
+	finished bool
+	err      error
+}
+
+func newStream(mux *StreamMux, id uint32, credit uint32) *Stream {
+	return &Stream{
+		mux:        mux,
+		id:         id,
+		credit:     credit,
+		sendSignal: make(chan struct{}, 1),
+		recvSignal: make(chan struct{}, 1),
+	}
+}
+
+// ID returns the stream id, which is odd for the streams opened by the clients.
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+// Send sends @pkg after the peer grants the credit. @ctx bounds the wait for the credit.
+// It returns ErrStreamReset if the stream is reset by the peer.
+func (s *Stream) Send(ctx context.Context, pkg interface{}) error {
+	for {
+		s.lock.Lock()
+		switch {
+		case s.err != nil:
+			s.lock.Unlock()
+			return s.err
+		case s.sendClosed:
+			s.lock.Unlock()
+			return errStreamSendClosed
+		case 0 < s.credit:
+			s.credit--
+			s.lock.Unlock()
+			return s.mux.write(&muxFrame{typ: muxFrameData, id: s.id, pkg: pkg})
+		}
+		s.lock.Unlock()
+
+		select {
+		case <-s.sendSignal:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Recv returns the next pkg from the peer. It returns io.EOF after the peer closes its send
+// side, and ErrStreamReset if the stream is reset by the peer.
+func (s *Stream) Recv(ctx context.Context) (interface{}, error) {
+	for {
+		s.lock.Lock()
+		switch {
+		case s.err != nil:
+			s.lock.Unlock()
+			return nil, s.err
+		case 0 < len(s.queue):
+			pkg := s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			// grant the credit in batches
+			var credit uint32
+			if s.consumed++; uint32(s.mux.config.Window+1)/2 <= s.consumed && !s.finished {
+				credit, s.consumed = s.consumed, 0
+			}
+			s.lock.Unlock()
+			if credit > 0 {
+				s.mux.write(&muxFrame{typ: muxFrameCredit, id: s.id, credit: credit})
+			}
+			return pkg, nil
+		case s.finished:
+			s.lock.Unlock()
+			return nil, io.EOF
+		}
+		s.lock.Unlock()
+
+		select {
+		case <-s.recvSignal:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// CloseSend closes the send side, and the peer gets io.EOF after the sent pkgs.
+func (s *Stream) CloseSend() error {
+	s.lock.Lock()
+	if s.err != nil || s.sendClosed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.sendClosed = true
+	finished := s.finished
+	s.lock.Unlock()
+
+	if finished {
+		s.mux.remove(s.id)
+	}
+	return s.mux.write(&muxFrame{typ: muxFrameFin, id: s.id})
+}
+
+// Close resets the stream if any side of it is not closed.
+func (s *Stream) Close() {
+	s.lock.Lock()
+	done := s.err != nil || (s.sendClosed && s.finished)
+	s.lock.Unlock()
+
+	s.fail(errStreamClosed)
+	s.mux.remove(s.id)
+	if !done {
+		s.mux.write(&muxFrame{typ: muxFrameReset, id: s.id, reason: errStreamClosed.Error()})
+	}
+}
+
+// reset the stream and notify the peer
+func (s *Stream) reset(reason string) {
+	s.fail(newGettyError(ErrStreamReset, jerrors.New(reason)))
+	s.mux.remove(s.id)
+	s.mux.write(&muxFrame{typ: muxFrameReset, id: s.id, reason: reason})
+}
+
+// push the pkg received from the peer. It returns false if the peer exceeds its credit.
+func (s *Stream) push(pkg interface{}) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if uint32(s.mux.config.Window) <= uint32(len(s.queue))+s.consumed {
+		return false
+	}
+	if s.err == nil {
+		s.queue = append(s.queue, pkg)
+		notify(s.recvSignal)
+	}
+	return true
+}
+
+func (s *Stream) grant(credit uint32) {
+	s.lock.Lock()
+	s.credit += credit
+	s.lock.Unlock()
+	notify(s.sendSignal)
+}
+
+func (s *Stream) finish() {
+	s.lock.Lock()
+	s.finished = true
+	sendClosed := s.sendClosed
+	s.lock.Unlock()
+	notify(s.recvSignal)
+
+	if sendClosed {
+		s.mux.remove(s.id)
+	}
+}
+
+func (s *Stream) fail(err error) {
+	s.lock.Lock()
+	if s.err == nil {
+		s.err = err
+		s.queue = nil
+	}
+	s.lock.Unlock()
+	notify(s.sendSignal)
+	notify(s.recvSignal)
+}
+
+func notify(signal chan struct{}) {
+	select {
+	case signal <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,170 @@
+package getty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// run a server whose streams are served by @serve, and return the StreamMux of a client
+func runStreamMux(t *testing.T, config StreamMuxConfig, serve func(*Stream)) (*server, *client, *StreamMux, *lineListener) {
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+	server.RunEventLoop(func(ss Session) error {
+		NewStreamMux(ss, &lineTransferCodec{}, listener, StreamMuxConfig{Window: config.Window, OnStream: serve})
+		return nil
+	})
+
+	muxes := make(chan *StreamMux, 1)
+	clt := newClient(TCP_CLIENT, WithServerAddress(server.streamListener.Addr().String()),
+		WithConnectionNumber(1))
+	clt.RunEventLoop(func(ss Session) error {
+		muxes <- NewStreamMux(ss, &lineTransferCodec{}, &MessageHandler{}, config)
+		return nil
+	})
+
+	return server, clt, <-muxes, listener
+}
+
+func TestStreamMuxEcho(t *testing.T) {
+	echo := func(s *Stream) {
+		defer s.Close()
+		for {
+			pkg, err := s.Recv(context.Background())
+			if err == io.EOF {
+				s.CloseSend()
+				return
+			}
+			if err != nil || s.Send(context.Background(), pkg) != nil {
+				return
+			}
+		}
+	}
+	server, clt, mux, listener := runStreamMux(t, StreamMuxConfig{Window: 4}, echo)
+	defer server.Close()
+	defer clt.Close()
+
+	const count = 100
+	streams := make([]*Stream, 2)
+	for i := range streams {
+		s, err := mux.OpenStream()
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(2*i+1), s.ID())
+		streams[i] = s
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, s := range streams {
+		go func(i int, s *Stream) {
+			for j := 0; j < count; j++ {
+				assert.Nil(t, s.Send(ctx, fmt.Sprintf("%d-%d", i, j)))
+			}
+			s.CloseSend()
+		}(i, s)
+	}
+	for i, s := range streams {
+		for j := 0; j < count; j++ {
+			pkg, err := s.Recv(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, fmt.Sprintf("%d-%d", i, j), pkg)
+		}
+		_, err := s.Recv(ctx)
+		assert.Equal(t, io.EOF, err)
+	}
+	assert.Equal(t, 0, mux.StreamNum())
+
+	// the pkgs written to the session directly bypass the streams
+	ss := clt.connectedSession()
+	assert.NotNil(t, ss)
+	assert.Nil(t, ss.WritePkg("hello", 0))
+	select {
+	case msg := <-listener.msgs:
+		assert.Equal(t, "hello", msg)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message")
+	}
+}
+
+func TestStreamMuxFlowControl(t *testing.T) {
+	streams := make(chan *Stream, 1)
+	server, clt, mux, _ := runStreamMux(t, StreamMuxConfig{Window: 2}, func(s *Stream) {
+		streams <- s
+	})
+	defer server.Close()
+	defer clt.Close()
+
+	s, err := mux.OpenStream()
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	assert.Nil(t, s.Send(ctx, "1"))
+	assert.Nil(t, s.Send(ctx, "2"))
+
+	// the window of the receiver is full
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer timeoutCancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Send(timeoutCtx, "3"))
+
+	// the credit is granted after the receiver consumes the pkgs
+	peer := <-streams
+	pkg, err := peer.Recv(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", pkg)
+	assert.Nil(t, s.Send(ctx, "3"))
+}
+
+func TestStreamMuxReset(t *testing.T) {
+	streams := make(chan *Stream, 1)
+	server, clt, mux, _ := runStreamMux(t, StreamMuxConfig{}, func(s *Stream) {
+		streams <- s
+	})
+	defer server.Close()
+	defer clt.Close()
+
+	s, err := mux.OpenStream()
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	assert.Nil(t, s.Send(ctx, "1"))
+	peer := <-streams
+	peer.Close()
+
+	_, err = s.Recv(ctx)
+	assert.True(t, errors.Is(err, ErrStreamReset))
+	assert.True(t, errors.Is(s.Send(ctx, "2"), ErrStreamReset))
+	_, err = peer.Recv(ctx)
+	assert.Equal(t, errStreamClosed, err)
+
+	// the session is closed
+	clt.Close()
+	_, err = mux.OpenStream()
+	assert.Equal(t, ErrSessionClosed, err)
+}
+
+func TestStreamMuxRefused(t *testing.T) {
+	server, clt, _, _ := runStreamMux(t, StreamMuxConfig{}, func(s *Stream) {})
+	defer server.Close()
+	defer clt.Close()
+
+	// the client does not accept streams
+	var serverMux *StreamMux
+	server.sessionLock.Lock()
+	for ss := range server.sessions {
+		serverMux = ss.getListener().(*muxListener).mux
+	}
+	server.sessionLock.Unlock()
+	s, err := serverMux.OpenStream()
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), s.ID())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err = s.Recv(ctx)
+	assert.True(t, errors.Is(err, ErrStreamReset))
+}
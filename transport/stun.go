@@ -0,0 +1,246 @@
+/******************************************************
+# DESC       : stun binding client of udp endpoint
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-22 16:45
+# FILE       : stun.go
+******************************************************/
+
+package getty
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+)
+
+// RFC 5389 Session Traversal Utilities for NAT
+const (
+	stunHeaderLen             = 20
+	stunMagicCookie           = 0x2112A442
+	stunBindingRequest        = 0x0001
+	stunBindingSuccess        = 0x0101
+	stunAttrMappedAddress     = 0x0001
+	stunAttrXORMappedAddress  = 0x0020
+	stunFamilyIPv4            = 0x01
+	stunFamilyIPv6            = 0x02
+	stunRetransmitInterval    = 500 * time.Millisecond
+	stunTransactionIDLen      = 12
+	stunDefaultResolveTimeout = 3 * time.Second
+)
+
+var (
+	errIllegalSTUNMessage = jerrors.New("illegal stun message")
+)
+
+type stunTransactionID [stunTransactionIDLen]byte
+
+// STUNResolver is implemented by the udp endpoint built by NewUDPPEndPoint,
+// e.g. server.(getty.STUNResolver).ResolvePublicAddr(ctx, "stun.l.google.com:19302").
+type STUNResolver interface {
+	// send stun binding requests to @stunServer through the udp endpoint socket, and return
+	// the server-reflexive address of the endpoint. If @ctx has no deadline, it times out in 3s.
+	ResolvePublicAddr(ctx context.Context, stunServer string) (*net.UDPAddr, error)
+	// get the server-reflexive address got by the last successful ResolvePublicAddr.
+	PublicAddr() *net.UDPAddr
+}
+
+// stunAgent matches the stun responses received by the udp endpoint session with the
+// pending binding requests.
+type stunAgent struct {
+	lock       sync.Mutex
+	pending    map[stunTransactionID]chan *net.UDPAddr
+	publicAddr *net.UDPAddr
+}
+
+func newSTUNAgent() *stunAgent {
+	return &stunAgent{pending: make(map[stunTransactionID]chan *net.UDPAddr)}
+}
+
+func (a *stunAgent) getPublicAddr() *net.UDPAddr {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.publicAddr
+}
+
+// resolve the server-reflexive address of @conn by @server
+func (a *stunAgent) resolve(ctx context.Context, conn *net.UDPConn, server *net.UDPAddr) (*net.UDPAddr, error) {
+	var id stunTransactionID
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, jerrors.Trace(err)
+	}
+	ch := make(chan *net.UDPAddr, 1)
+	a.lock.Lock()
+	a.pending[id] = ch
+	a.lock.Unlock()
+	defer func() {
+		a.lock.Lock()
+		delete(a.pending, id)
+		a.lock.Unlock()
+	}()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, stunDefaultResolveTimeout)
+		defer cancel()
+	}
+	req := stunEncodeBindingRequest(id)
+	ticker := time.NewTicker(stunRetransmitInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := conn.WriteToUDP(req, server); err != nil {
+			return nil, jerrors.Trace(err)
+		}
+		select {
+		case addr := <-ch:
+			a.lock.Lock()
+			a.publicAddr = addr
+			a.lock.Unlock()
+			return addr, nil
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, jerrors.Annotatef(ctx.Err(), "stun server %s", server)
+		}
+	}
+}
+
+// handle the datagram @data received by the udp endpoint session. It returns true if
+// @data is the response of a pending binding request.
+func (a *stunAgent) handle(data []byte) bool {
+	if len(data) < stunHeaderLen || data[0]&0xC0 != 0 ||
+		binary.BigEndian.Uint32(data[4:]) != stunMagicCookie {
+		return false
+	}
+	var id stunTransactionID
+	copy(id[:], data[8:stunHeaderLen])
+	a.lock.Lock()
+	ch, ok := a.pending[id]
+	a.lock.Unlock()
+	if !ok {
+		return false
+	}
+
+	if addr, err := stunDecodeBindingResponse(data); err == nil {
+		select {
+		case ch <- addr:
+		default:
+		}
+	}
+	return true
+}
+
+func stunEncodeBindingRequest(id stunTransactionID) []byte {
+	b := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(b[0:], stunBindingRequest)
+	binary.BigEndian.PutUint16(b[2:], 0)
+	binary.BigEndian.PutUint32(b[4:], stunMagicCookie)
+	copy(b[8:], id[:])
+	return b
+}
+
+// get the (xor) mapped address of the binding success response @data
+func stunDecodeBindingResponse(data []byte) (*net.UDPAddr, error) {
+	if len(data) < stunHeaderLen || binary.BigEndian.Uint16(data[0:]) != stunBindingSuccess {
+		return nil, errIllegalSTUNMessage
+	}
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	if len(data) < stunHeaderLen+length {
+		return nil, errIllegalSTUNMessage
+	}
+
+	var mapped *net.UDPAddr
+	attrs := data[stunHeaderLen : stunHeaderLen+length]
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:]))
+		if len(attrs) < 4+attrLen {
+			return nil, errIllegalSTUNMessage
+		}
+		value := attrs[4 : 4+attrLen]
+		switch typ {
+		case stunAttrXORMappedAddress:
+			return stunDecodeAddress(value, data[4:stunHeaderLen])
+		case stunAttrMappedAddress:
+			mapped, _ = stunDecodeAddress(value, nil)
+		}
+		// attributes are padded to a multiple of 4 bytes
+		attrLen = (attrLen + 3) &^ 3
+		if len(attrs) < 4+attrLen {
+			break
+		}
+		attrs = attrs[4+attrLen:]
+	}
+	if mapped == nil {
+		return nil, errIllegalSTUNMessage
+	}
+
+	return mapped, nil
+}
+
+// decode the address attribute @value. If @xor is not nil, which is the magic cookie and
+// the transaction id, the address is xor-ed with it.
+func stunDecodeAddress(value []byte, xor []byte) (*net.UDPAddr, error) {
+	if len(value) < 4 {
+		return nil, errIllegalSTUNMessage
+	}
+	var ipLen int
+	switch value[1] {
+	case stunFamilyIPv4:
+		ipLen = net.IPv4len
+	case stunFamilyIPv6:
+		ipLen = net.IPv6len
+	default:
+		return nil, errIllegalSTUNMessage
+	}
+	if len(value) < 4+ipLen {
+		return nil, errIllegalSTUNMessage
+	}
+
+	port := binary.BigEndian.Uint16(value[2:])
+	ip := make(net.IP, ipLen)
+	copy(ip, value[4:4+ipLen])
+	if xor != nil {
+		port ^= uint16(stunMagicCookie >> 16)
+		for i := range ip {
+			ip[i] ^= xor[i]
+		}
+	}
+
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// ResolvePublicAddr resolves the server-reflexive address of the udp endpoint by the stun
+// server @stunServer. The endpoint should be running.
+func (s *server) ResolvePublicAddr(ctx context.Context, stunServer string) (*net.UDPAddr, error) {
+	if s.endPointType != UDP_ENDPOINT {
+		return nil, jerrors.Errorf("stun is not supported by %s", s.endPointType)
+	}
+	conn, _ := s.pktListener.(*net.UDPConn)
+	if conn == nil {
+		return nil, jerrors.New("udp endpoint is not running")
+	}
+	serverAddr, err := net.ResolveUDPAddr("udp", stunServer)
+	if err != nil {
+		return nil, jerrors.Trace(err)
+	}
+
+	return s.stun.resolve(ctx, conn, serverAddr)
+}
+
+// PublicAddr returns the server-reflexive address of the udp endpoint got by the last
+// successful ResolvePublicAddr. It is nil if the address has not been resolved.
+func (s *server) PublicAddr() *net.UDPAddr {
+	if s.stun == nil {
+		return nil
+	}
+	return s.stun.getPublicAddr()
+}
@@ -0,0 +1,112 @@
+package getty
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// build a binding success response of @req which carries @addr
+func stunTestResponse(req []byte, addr *net.UDPAddr, xor bool) []byte {
+	ip := addr.IP.To4()
+	family := byte(stunFamilyIPv4)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = stunFamilyIPv6
+	}
+	port := uint16(addr.Port)
+	attrType := uint16(stunAttrMappedAddress)
+	value := make([]byte, 4+len(ip))
+	value[1] = family
+	copy(value[4:], ip)
+	if xor {
+		attrType = stunAttrXORMappedAddress
+		port ^= uint16(stunMagicCookie >> 16)
+		for i := range ip {
+			value[4+i] ^= req[4+i]
+		}
+	}
+	binary.BigEndian.PutUint16(value[2:], port)
+
+	rsp := make([]byte, stunHeaderLen+4+len(value))
+	binary.BigEndian.PutUint16(rsp[0:], stunBindingSuccess)
+	binary.BigEndian.PutUint16(rsp[2:], uint16(4+len(value)))
+	copy(rsp[4:], req[4:stunHeaderLen])
+	binary.BigEndian.PutUint16(rsp[stunHeaderLen:], attrType)
+	binary.BigEndian.PutUint16(rsp[stunHeaderLen+2:], uint16(len(value)))
+	copy(rsp[stunHeaderLen+4:], value)
+	return rsp
+}
+
+func TestSTUNDecodeBindingResponse(t *testing.T) {
+	var id stunTransactionID
+	copy(id[:], "0123456789ab")
+	req := stunEncodeBindingRequest(id)
+	assert.Equal(t, stunHeaderLen, len(req))
+
+	for _, addr := range []*net.UDPAddr{
+		{IP: net.IPv4(203, 0, 113, 7).To4(), Port: 40000},
+		{IP: net.ParseIP("2001:db8::1"), Port: 3478},
+	} {
+		for _, xor := range []bool{true, false} {
+			got, err := stunDecodeBindingResponse(stunTestResponse(req, addr, xor))
+			assert.Nil(t, err)
+			assert.Equal(t, addr.String(), got.String())
+		}
+	}
+
+	_, err := stunDecodeBindingResponse(req)
+	assert.Equal(t, errIllegalSTUNMessage, err)
+}
+
+func TestUDPEndPointResolvePublicAddr(t *testing.T) {
+	stunServer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer stunServer.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := stunServer.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n == stunHeaderLen {
+				stunServer.WriteToUDP(stunTestResponse(buf[:n], addr, true), addr)
+			}
+		}
+	}()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	server := NewUDPPEndPoint(WithLocalAddress(addr))
+	resolver := server.(STUNResolver)
+	assert.Nil(t, resolver.PublicAddr())
+	_, err = resolver.ResolvePublicAddr(context.Background(), stunServer.LocalAddr().String())
+	assert.NotNil(t, err)
+
+	var handler MessageHandler
+	go server.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &handler)
+	})
+	defer server.Close()
+	for handler.SessionNumber() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	publicAddr, err := resolver.ResolvePublicAddr(ctx, stunServer.LocalAddr().String())
+	assert.Nil(t, err)
+	assert.Equal(t, addr, publicAddr.String())
+	assert.Equal(t, publicAddr, resolver.PublicAddr())
+	assert.Nil(t, NewTCPServer(WithLocalAddress(addr)).(STUNResolver).PublicAddr())
+}
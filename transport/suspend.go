@@ -0,0 +1,198 @@
+/******************************************************
+# DESC       : suspend & resume of client
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-19 10:30
+# FILE       : suspend.go
+******************************************************/
+
+package getty
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+const (
+	defaultSuspendCheckInterval = time.Second
+	defaultSuspendThreshold     = 5 * time.Second
+)
+
+// SuspendConfig is the suspend & resume handling of a client on a laptop or mobile device.
+// The sessions of a suspended system are usually dead after the resume, e.g. the nat mappings
+// expire or the network changes, but they are found only at the read deadline or the tcp
+// timeout. So the client validates its sessions at once when the resume is detected or it is
+// notified by (SuspendResumer)Resume, and the invalid sessions are closed and redialed.
+type SuspendConfig struct {
+	// the interval of the resume checks. Its default value is 1s.
+	CheckInterval time.Duration
+	// a resume is detected if a check is later than expected by more than it by the monotonic
+	// or the wall clock. Its default value is 5s.
+	Threshold time.Duration
+	// the timeout of the validation of a session. Its default value is the handshake timeout
+	// of the client.
+	ValidateTimeout time.Duration
+	// Validate validates a session after the resume, e.g. by a request of the application
+	// protocol. If it is nil, a session is validated by a latency probe frame if it enables
+	// the latency probe by (SessionObserver)SetLatencyProbe, otherwise it is redialed.
+	Validate func(Session) error
+	// OnSuspend is invoked by (SuspendResumer)Suspend, i.e. when the application is notified
+	// of the coming sleep by the system.
+	OnSuspend func(Client)
+	// OnResume is invoked with the suspended duration when the resume is detected or notified,
+	// before the sessions are validated. The duration is 0 if it is unknown.
+	OnResume func(c Client, suspended time.Duration)
+}
+
+func (c SuspendConfig) withDefaults(handshakeTimeout time.Duration) SuspendConfig {
+	if c.CheckInterval <= 0 {
+		c.CheckInterval = defaultSuspendCheckInterval
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = defaultSuspendThreshold
+	}
+	if c.ValidateTimeout <= 0 {
+		c.ValidateTimeout = handshakeTimeout
+	}
+
+	return c
+}
+
+// the suspended duration between two checks, whose expected interval is @interval, by the
+// elapsed monotonic & wall clock time. The monotonic clock stops while the system is
+// suspended on linux, and the wall clock goes on.
+func suspendedTime(monotonic, wall, interval time.Duration) time.Duration {
+	elapsed := monotonic
+	if elapsed < wall {
+		elapsed = wall
+	}
+	if elapsed < interval {
+		return 0
+	}
+	return elapsed - interval
+}
+
+func (c *client) suspendConfig() SuspendConfig {
+	return c.suspend.withDefaults(c.handshakeTimeout)
+}
+
+// detect the resume by the late checks
+func (c *client) startSuspendDetector() {
+	if c.suspend == nil {
+		return
+	}
+
+	config := c.suspendConfig()
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		last := time.Now()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-wheel.After(config.CheckInterval):
+			}
+
+			now := time.Now()
+			// time without the monotonic reading is compared by the wall clock
+			suspended := suspendedTime(now.Sub(last), now.Round(0).Sub(last.Round(0)), config.CheckInterval)
+			last = now
+			if config.Threshold < suspended {
+				log.Info("client{peer:%s} resumes after suspended for %s", c.addr, suspended)
+				c.resume(config, suspended)
+			}
+		}
+	}()
+}
+
+func (c *client) Suspend() {
+	c.suspendLock.Lock()
+	c.suspendTime = time.Now()
+	c.suspendLock.Unlock()
+
+	if c.suspend != nil && c.suspend.OnSuspend != nil {
+		c.suspend.OnSuspend(c)
+	}
+}
+
+func (c *client) Resume() {
+	var suspended time.Duration
+	c.suspendLock.Lock()
+	if !c.suspendTime.IsZero() {
+		// the wall clock counts the suspended time
+		suspended = time.Now().Round(0).Sub(c.suspendTime.Round(0))
+		c.suspendTime = time.Time{}
+	}
+	c.suspendLock.Unlock()
+
+	var config SuspendConfig
+	if c.suspend != nil {
+		config = c.suspendConfig()
+	} else {
+		config = SuspendConfig{}.withDefaults(c.handshakeTimeout)
+	}
+	c.resume(config, suspended)
+}
+
+// invoke the resume hook, and validate the sessions concurrently. The invalid sessions are
+// closed, and so they are redialed at once.
+func (c *client) resume(config SuspendConfig, suspended time.Duration) {
+	if c.IsClosed() {
+		return
+	}
+	if config.OnResume != nil {
+		config.OnResume(c, suspended)
+	}
+
+	c.Lock()
+	sessions := make([]*session, 0, len(c.ssMap))
+	for ss := range c.ssMap {
+		sessions = append(sessions, ss.(*session))
+	}
+	c.Unlock()
+
+	var wg sync.WaitGroup
+	for _, ss := range sessions {
+		wg.Add(1)
+		go func(ss *session) {
+			defer wg.Done()
+			if err := ss.validate(config); err != nil {
+				log.Warn("%s, [session.validate] = error:%s, close it after resume", ss.sessionToken(), err)
+				ss.Close()
+			}
+		}(ss)
+	}
+	wg.Wait()
+}
+
+// validate the session by config.Validate or a latency probe
+func (s *session) validate(config SuspendConfig) error {
+	if s.IsClosed() {
+		return nil
+	}
+	if config.Validate != nil {
+		return config.Validate(s)
+	}
+
+	p := s.prober
+	if p == nil {
+		return ErrResumeValidation
+	}
+	replied := p.waitReply()
+	s.sendProbe()
+	select {
+	case <-replied:
+		return nil
+	case <-s.done:
+		return nil
+	case <-wheel.After(config.ValidateTimeout):
+		return newGettyError(ErrResumeValidation, fmt.Errorf("probe timeout %s", config.ValidateTimeout))
+	}
+}
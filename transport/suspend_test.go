@@ -0,0 +1,146 @@
+package getty
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuspendedTime(t *testing.T) {
+	for _, c := range []struct {
+		monotonic, wall, suspended time.Duration
+	}{
+		{time.Second, time.Second, 0},
+		{900 * time.Millisecond, time.Second, 0},
+		// the monotonic clock stops while the system is suspended
+		{time.Second, time.Minute, time.Minute - time.Second},
+		// the monotonic clock counts the suspended time, and the wall clock goes back
+		{time.Minute, -time.Hour, time.Minute - time.Second},
+	} {
+		assert.Equal(t, c.suspended, suspendedTime(c.monotonic, c.wall, time.Second), c)
+	}
+}
+
+// run a tcp server whose sessions enable the passive latency probe
+func runSuspendServer(t *testing.T, handler *MessageHandler) *server {
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"))
+	server.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(handler)
+		ss.(SessionObserver).SetLatencyProbe(&LatencyProbeConfig{})
+		return nil
+	})
+	return server
+}
+
+func TestClientResume(t *testing.T) {
+	var serverHandler MessageHandler
+	server := runSuspendServer(t, &serverHandler)
+	defer server.Close()
+
+	var (
+		lock      sync.Mutex
+		suspends  int
+		suspended []time.Duration
+		validate  error
+	)
+	config := &SuspendConfig{
+		OnSuspend: func(Client) {
+			lock.Lock()
+			suspends++
+			lock.Unlock()
+		},
+		OnResume: func(c Client, d time.Duration) {
+			lock.Lock()
+			suspended = append(suspended, d)
+			lock.Unlock()
+		},
+		Validate: func(Session) error { return validate },
+	}
+	client := newClient(TCP_CLIENT, WithServerAddress(server.streamListener.Addr().String()),
+		WithConnectionNumber(1), WithSuspendDetection(config))
+	defer client.Close()
+	clientHandler := &MessageHandler{}
+	client.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(clientHandler)
+		return nil
+	})
+	assert.Equal(t, 1, clientHandler.SessionNumber())
+
+	// the valid session is kept
+	var resumer SuspendResumer = client
+	resumer.Suspend()
+	time.Sleep(10 * time.Millisecond)
+	resumer.Resume()
+	assert.Equal(t, 1, client.sessionNum())
+	lock.Lock()
+	assert.Equal(t, 1, suspends)
+	assert.Len(t, suspended, 1)
+	assert.True(t, suspended[0] >= 10*time.Millisecond)
+	lock.Unlock()
+
+	// the invalid session is redialed at once
+	validate = errors.New("no response")
+	resumer.Resume()
+	assert.Equal(t, 2, clientHandler.SessionNumber())
+	assert.Equal(t, 1, client.sessionNum())
+	lock.Lock()
+	assert.Equal(t, time.Duration(0), suspended[1])
+	lock.Unlock()
+}
+
+func TestClientResumeProbe(t *testing.T) {
+	var serverHandler MessageHandler
+	server := runSuspendServer(t, &serverHandler)
+	defer server.Close()
+
+	client := newClient(TCP_CLIENT, WithServerAddress(server.streamListener.Addr().String()),
+		WithConnectionNumber(2))
+	defer client.Close()
+	clientHandler := &MessageHandler{}
+	client.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(clientHandler)
+		if clientHandler.SessionNumber() == 0 {
+			ss.(SessionObserver).SetLatencyProbe(&LatencyProbeConfig{})
+		}
+		return nil
+	})
+	assert.Equal(t, 2, clientHandler.SessionNumber())
+
+	// the session with the latency probe is validated by a probe frame, and the other one
+	// is redialed
+	client.Resume()
+	assert.Equal(t, 3, clientHandler.SessionNumber())
+	assert.Equal(t, 2, client.sessionNum())
+	clientHandler.lock.Lock()
+	for _, ss := range clientHandler.array {
+		if ss.(*session).prober != nil {
+			assert.False(t, ss.IsClosed())
+			assert.Equal(t, uint64(1), ss.(*session).LatencyProbe().Samples)
+		}
+	}
+	clientHandler.lock.Unlock()
+}
+
+func TestSessionValidateTimeout(t *testing.T) {
+	src, dst := newPipeSessions(t)
+	defer dst.Close()
+	// the session without the latency probe can not be validated
+	assert.True(t, errors.Is(dst.validate(SuspendConfig{}), ErrResumeValidation))
+
+	src.SetPkgHandler(&lineTransferCodec{})
+	src.SetEventListener(&MessageHandler{})
+	src.SetLatencyProbe(&LatencyProbeConfig{})
+	src.run()
+	defer src.Close()
+	// the probe frame is not replied by the peer which does not run
+	err := src.validate(SuspendConfig{ValidateTimeout: 10 * time.Millisecond})
+	assert.True(t, errors.Is(err, ErrResumeValidation))
+}
@@ -0,0 +1,204 @@
+/******************************************************
+# DESC       : tarpit of the rejected connections
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-13 14:30
+# FILE       : tarpit.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+const (
+	defaultTarpitMaxConns = 1024
+	defaultTarpitHold     = 10 * time.Minute
+	tarpitSweepInterval   = time.Second
+	// the socket buffers of a tarpitted connection, which are rounded up to the minimum by the kernel
+	tarpitBufferSize = 1
+)
+
+// TarpitConfig is the tarpit config of a tcp/ws/wss server. The rejected connections are held
+// open without reply instead of being closed, which slows down the scanners at minimal cost:
+// their socket buffers are shrunk, and no goroutine reads them, i.e. they are only parked in
+// the netpoller of the go runtime until they expire.
+type TarpitConfig struct {
+	// tarpit the connections from the banned remote ips, see WithServerBanList
+	Banned bool
+	// tarpit the connections which fail their handshakes, i.e. the tls handshake of a wss
+	// server, the negotiation, the identification or the Handshake Policy of a tcp server
+	FailedHandshake bool
+	// the max number of the tarpitted connections, beyond which the rejected connections are
+	// closed at once. Its default value is 1024.
+	MaxConns int
+	// the duration a tarpitted connection is held before it is closed. Its default value is 10m.
+	Hold time.Duration
+}
+
+func (c TarpitConfig) withDefaults() TarpitConfig {
+	if c.MaxConns <= 0 {
+		c.MaxConns = defaultTarpitMaxConns
+	}
+	if c.Hold <= 0 {
+		c.Hold = defaultTarpitHold
+	}
+
+	return c
+}
+
+type tarpitReason int
+
+const (
+	tarpitBanned tarpitReason = iota
+	tarpitFailedHandshake
+)
+
+type tarpit struct {
+	config  TarpitConfig
+	metrics *EndPointMetrics
+
+	lock sync.Mutex
+	// tarpitted connection -> the time to close it
+	conns map[net.Conn]time.Time
+}
+
+func newTarpit(config *TarpitConfig, metrics *EndPointMetrics) *tarpit {
+	if config == nil {
+		return nil
+	}
+
+	return &tarpit{
+		config:  config.withDefaults(),
+		metrics: metrics,
+		conns:   make(map[net.Conn]time.Time),
+	}
+}
+
+// hold the rejected @conn if the tarpit is enabled for @reason and it is not full, or else close it.
+func (t *tarpit) reject(conn net.Conn, reason tarpitReason) {
+	if t == nil || !t.enabled(reason) {
+		conn.Close()
+		return
+	}
+
+	t.lock.Lock()
+	if len(t.conns) >= t.config.MaxConns {
+		t.lock.Unlock()
+		conn.Close()
+		return
+	}
+	t.conns[conn] = time.Now().Add(t.config.Hold)
+	t.lock.Unlock()
+
+	conn.SetDeadline(time.Time{})
+	raw := conn
+	if tlsConn, ok := raw.(interface{ NetConn() net.Conn }); ok {
+		raw = tlsConn.NetConn()
+	}
+	if bufConn, ok := raw.(interface {
+		SetReadBuffer(int) error
+		SetWriteBuffer(int) error
+	}); ok {
+		bufConn.SetReadBuffer(tarpitBufferSize)
+		bufConn.SetWriteBuffer(tarpitBufferSize)
+	}
+	atomic.AddUint64(&t.metrics.tarpittedNum, 1)
+	atomic.AddInt64(&t.metrics.tarpitConns, 1)
+	log.Debug("tarpit connection from %s, reason:%d", conn.RemoteAddr(), reason)
+}
+
+// close @conn unless it is held by the tarpit, which is the release of the connection whose
+// handshake may have failed and tarpitted it.
+func (t *tarpit) close(conn net.Conn) {
+	if t != nil {
+		t.lock.Lock()
+		_, held := t.conns[conn]
+		t.lock.Unlock()
+		if held {
+			return
+		}
+	}
+
+	conn.Close()
+}
+
+func (t *tarpit) enabled(reason tarpitReason) bool {
+	switch reason {
+	case tarpitBanned:
+		return t.config.Banned
+	case tarpitFailedHandshake:
+		return t.config.FailedHandshake
+	}
+
+	return false
+}
+
+// close the expired connections, and all connections when @done is closed
+func (t *tarpit) run(done <-chan struct{}) {
+	ticker := time.NewTicker(tarpitSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			t.sweep(time.Time{})
+			return
+
+		case now := <-ticker.C:
+			t.sweep(now)
+		}
+	}
+}
+
+// close the connections which expire before @now. zero @now means all.
+func (t *tarpit) sweep(now time.Time) {
+	var expired []net.Conn
+	t.lock.Lock()
+	for conn, until := range t.conns {
+		if now.IsZero() || !now.Before(until) {
+			expired = append(expired, conn)
+			delete(t.conns, conn)
+		}
+	}
+	t.lock.Unlock()
+
+	for _, conn := range expired {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			// reset the tcp connection, which leaves no TIME_WAIT socket
+			tcpConn.SetLinger(0)
+		}
+		conn.Close()
+	}
+	atomic.AddInt64(&t.metrics.tarpitConns, -int64(len(expired)))
+}
+
+// tarpit the connection of the ws/wss request from a banned remote ip. It returns false if
+// the connection is not tarpitted, and then the request should be answered.
+func (s *server) tarpitBanned(w http.ResponseWriter) bool {
+	if s.tarpit == nil || !s.tarpit.enabled(tarpitBanned) {
+		return false
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return false
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		log.Warn("server{%s} hijack the connection to tarpit, error:%s", s.addr, err)
+		return false
+	}
+
+	s.tarpit.reject(conn, tarpitBanned)
+	return true
+}
@@ -0,0 +1,94 @@
+package getty
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTarpit(t *testing.T) {
+	assert.Nil(t, newTarpit(nil, newEndPointMetrics(0)))
+
+	metrics := newEndPointMetrics(0)
+	tp := newTarpit(&TarpitConfig{Banned: true, MaxConns: 1, Hold: time.Minute}, metrics)
+	assert.Equal(t, TarpitConfig{Banned: true, MaxConns: 1, Hold: time.Minute}, tp.config)
+
+	c1, p1 := net.Pipe()
+	c2, p2 := net.Pipe()
+	c3, p3 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	defer p3.Close()
+	closed := func(peer net.Conn) bool {
+		peer.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, err := peer.Read(make([]byte, 1))
+		netErr, ok := err.(net.Error)
+		return !(ok && netErr.Timeout())
+	}
+
+	// not enabled for the reason
+	tp.reject(c1, tarpitFailedHandshake)
+	assert.True(t, closed(p1))
+	// held
+	tp.reject(c2, tarpitBanned)
+	assert.False(t, closed(p2))
+	tp.close(c2)
+	assert.False(t, closed(p2))
+	// the tarpit is full
+	tp.reject(c3, tarpitBanned)
+	assert.True(t, closed(p3))
+	assert.Equal(t, uint64(1), metrics.TarpittedNum())
+	assert.Equal(t, int64(1), metrics.TarpitConns())
+
+	tp.sweep(time.Now())
+	assert.False(t, closed(p2))
+	tp.sweep(time.Now().Add(time.Minute))
+	assert.True(t, closed(p2))
+	assert.Equal(t, int64(0), metrics.TarpitConns())
+}
+
+func TestServerTarpit(t *testing.T) {
+	reject := int32(1)
+	banList := NewBanList(nil)
+	defer banList.Close()
+	server := newServer(TCP_SERVER, WithLocalAddress("127.0.0.1:0"), WithServerBanList(banList),
+		WithServerTarpit(&TarpitConfig{Banned: true, FailedHandshake: true}),
+		WithServerIdentity(&IdentityConfig{Provider: newTestIdentityProvider(&reject)}))
+	server.RunEventLoop(func(ss Session) error { return nil })
+	addr := server.streamListener.Addr().String()
+
+	read := func(conn net.Conn, timeout time.Duration) error {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		_, err := conn.Read(make([]byte, 1))
+		return err
+	}
+	isTimeout := func(err error) bool {
+		netErr, ok := err.(net.Error)
+		return ok && netErr.Timeout()
+	}
+
+	// rejected by the identity provider
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.True(t, isTimeout(read(conn, 100*time.Millisecond)))
+
+	// banned
+	banList.Ban("127.0.0.1", time.Minute)
+	conn2, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn2.Close()
+	assert.True(t, isTimeout(read(conn2, 100*time.Millisecond)))
+	assert.Equal(t, uint64(2), server.Metrics().TarpittedNum())
+	assert.Equal(t, int64(2), server.Metrics().TarpitConns())
+
+	// the tarpitted connections are closed with the server
+	server.Close()
+	assert.False(t, isTimeout(read(conn, 5*time.Second)))
+	assert.False(t, isTimeout(read(conn2, 5*time.Second)))
+	assert.Equal(t, int64(0), server.Metrics().TarpitConns())
+}
@@ -0,0 +1,52 @@
+/******************************************************
+# DESC       : tcp connection statistics of the kernel
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-24 10:30
+# FILE       : tcpinfo.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TCPInfo is the statistics of a tcp connection got from the kernel.
+type TCPInfo struct {
+	// total retransmitted segments of the connection
+	Retransmits uint32
+	// smoothed round trip time & its mean deviation
+	RTT    time.Duration
+	RTTVar time.Duration
+	// congestion window & slow start threshold in segments
+	Cwnd     uint32
+	SSThresh uint32
+	// sender maximum segment size in bytes
+	SendMSS uint32
+	// the bytes in the send buffer which have not been acknowledged by the peer,
+	// and the size of the send buffer
+	SendQueue  int
+	SendBuffer int
+}
+
+func (i TCPInfo) String() string {
+	return fmt.Sprintf("{retransmits:%d, rtt:%s, rttvar:%s, cwnd:%d, ssthresh:%d, mss:%d, send queue:%d/%d}",
+		i.Retransmits, i.RTT, i.RTTVar, i.Cwnd, i.SSThresh, i.SendMSS, i.SendQueue, i.SendBuffer)
+}
+
+// get the tcp connection under @conn, which may be a tls connection.
+func underlyingTCPConn(conn net.Conn) *net.TCPConn {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if cc, ok := conn.(*cipherConn); ok {
+		conn = cc.Conn
+	}
+	tcpConn, _ := conn.(*net.TCPConn)
+	return tcpConn
+}
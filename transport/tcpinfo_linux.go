@@ -0,0 +1,68 @@
+//go:build linux
+// +build linux
+
+/******************************************************
+# DESC       : tcp connection statistics on linux
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-24 10:30
+# FILE       : tcpinfo_linux.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+	"time"
+)
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// get the statistics of @conn by getsockopt(TCP_INFO). It returns nil on failure.
+func getTCPInfo(conn *net.TCPConn) *TCPInfo {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var info *TCPInfo
+	rawConn.Control(func(fd uintptr) {
+		ti, err := unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+		if err != nil {
+			return
+		}
+		info = &TCPInfo{
+			Retransmits: ti.Total_retrans,
+			RTT:         time.Duration(ti.Rtt) * time.Microsecond,
+			RTTVar:      time.Duration(ti.Rttvar) * time.Microsecond,
+			Cwnd:        ti.Snd_cwnd,
+			SSThresh:    ti.Snd_ssthresh,
+			SendMSS:     ti.Snd_mss,
+		}
+		info.SendQueue, info.SendBuffer = fdSendQueue(int(fd))
+	})
+
+	return info
+}
+
+// get the unacknowledged bytes in the send buffer of @conn and the size of the send buffer.
+func getSendQueue(conn *net.TCPConn) (queued int, size int) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0
+	}
+
+	rawConn.Control(func(fd uintptr) {
+		queued, size = fdSendQueue(int(fd))
+	})
+	return queued, size
+}
+
+func fdSendQueue(fd int) (int, int) {
+	queued, _ := unix.IoctlGetInt(fd, unix.SIOCOUTQ)
+	size, _ := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF)
+	return queued, size
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+/******************************************************
+# DESC       : tcp connection statistics stub
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-24 10:30
+# FILE       : tcpinfo_others.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+)
+
+func getTCPInfo(conn *net.TCPConn) *TCPInfo {
+	return nil
+}
+
+func getSendQueue(conn *net.TCPConn) (int, int) {
+	return 0, 0
+}
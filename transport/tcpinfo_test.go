@@ -0,0 +1,29 @@
+package getty
+
+import (
+	"runtime"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionTCPInfo(t *testing.T) {
+	ss, _ := newPipeSessions(t)
+	assert.Nil(t, ss.TCPInfo())
+
+	ss = newTCPTestSession(t)
+	_, err := ss.Conn().Write([]byte("hello"))
+	assert.Nil(t, err)
+	info := ss.TCPInfo()
+	if runtime.GOOS != "linux" {
+		assert.Nil(t, info)
+		return
+	}
+	assert.NotNil(t, info)
+	assert.True(t, info.Cwnd > 0)
+	assert.True(t, info.SendMSS > 0)
+	assert.True(t, info.SendBuffer > 0)
+	assert.True(t, info.SendQueue >= 0)
+}
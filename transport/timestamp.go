@@ -0,0 +1,86 @@
+/******************************************************
+# DESC       : receive & send timestamps of frames
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-06 16:40
+# FILE       : timestamp.go
+******************************************************/
+
+package getty
+
+import (
+	"time"
+)
+
+// TimestampedPkg is the pkg delivered to (EventListener)OnMessage by a session whose frame
+// timestamping is enabled, see (SessionObserver)SetFrameTimestamping.
+type TimestampedPkg struct {
+	Pkg interface{}
+	// the time the frame was read from the connection, i.e. the time the read returned the
+	// last bytes of the frame. It carries both the wall clock and the monotonic clock, so
+	// Received.Sub(t) is immune to the wall clock steps for the local times, and
+	// Received.UnixNano() can be compared with the send times of the synchronized peers.
+	Received time.Time
+}
+
+// SendTimestampListener is implemented by the EventListener which wants the send timestamps
+// of the pkgs written to a session whose frame timestamping is enabled. OnSent is invoked in
+// the write goroutine, so it should not block.
+type SendTimestampListener interface {
+	// @sent is the time the write of @pkg returned, i.e. the pkg has been handed to the kernel.
+	// The pkgs sent in one batch share the same time.
+	OnSent(session Session, pkg interface{}, sent time.Time)
+}
+
+// SetFrameTimestamping enables the receive & send timestamps of the frames of the session.
+// It should be invoked before the session runs, e.g. in NewSessionCallback. The inbound pkgs
+// are delivered as TimestampedPkg, and the send timestamps are reported to the listener if
+// it implements SendTimestampListener. It is disabled by default.
+func (s *session) SetFrameTimestamping(enable bool) {
+	s.stampFrames = enable
+}
+
+// stamp the frames got by the current read. It is invoked in the read goroutine.
+func (s *session) stampRecv() {
+	if s.stampFrames {
+		s.recvTime = time.Now()
+	}
+}
+
+// wrap @pkgs by the receive time of the current read
+func (s *session) stampPkgs(pkgs []interface{}) []interface{} {
+	if !s.stampFrames {
+		return pkgs
+	}
+
+	stamped := make([]interface{}, len(pkgs))
+	for i, pkg := range pkgs {
+		stamped[i] = TimestampedPkg{Pkg: pkg, Received: s.recvTime}
+	}
+	return stamped
+}
+
+// report the send time of @pkgs which have been written out
+func (s *session) stampSent(pkgs ...interface{}) {
+	if !s.stampFrames {
+		return
+	}
+	listener, ok := s.getListener().(SendTimestampListener)
+	if !ok {
+		return
+	}
+
+	sent := time.Now()
+	for _, pkg := range pkgs {
+		switch p := pkg.(type) {
+		case probeFrame:
+			continue
+		case *conflatedPkg:
+			pkg = p.pkg
+		case codecSwitchPkg:
+			pkg = p.pkg
+		}
+		listener.OnSent(s, pkg, sent)
+	}
+}
@@ -0,0 +1,80 @@
+package getty
+
+import (
+	"bufio"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type sentListener struct {
+	lineListener
+
+	sent chan interface{}
+}
+
+func (l *sentListener) OnSent(ss Session, pkg interface{}, sent time.Time) {
+	l.sent <- pkg
+}
+
+func TestSessionFrameTimestamping(t *testing.T) {
+	conn, peer := newTCPPair(t)
+
+	clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+	ss := newTCPSession(conn, clt).(*session)
+	listener := &sentListener{
+		lineListener: lineListener{msgs: make(chan interface{}, 4)},
+		sent:         make(chan interface{}, 4),
+	}
+	ss.SetPkgHandler(&lineTransferCodec{})
+	ss.SetEventListener(listener)
+	ss.SetWQLen(4)
+	ss.SetFrameTimestamping(true)
+	ss.run()
+	defer ss.Close()
+
+	before := time.Now()
+	_, err := peer.Write([]byte("hello\n"))
+	assert.Nil(t, err)
+	select {
+	case pkg := <-listener.msgs:
+		stamped, ok := pkg.(TimestampedPkg)
+		assert.True(t, ok)
+		assert.Equal(t, "hello", stamped.Pkg)
+		assert.False(t, stamped.Received.Before(before))
+		assert.True(t, time.Since(stamped.Received) < time.Second)
+	case <-time.After(time.Second):
+		t.Fatal("the pkg is not delivered")
+	}
+
+	// the queued & the direct writes are both stamped
+	r := bufio.NewReader(peer)
+	for _, write := range []struct {
+		pkg     string
+		timeout time.Duration
+	}{{"queued", time.Second}, {"direct", 0}} {
+		assert.Nil(t, ss.WritePkg(write.pkg, write.timeout))
+		_, err = r.ReadString('\n')
+		assert.Nil(t, err)
+		select {
+		case pkg := <-listener.sent:
+			assert.Equal(t, write.pkg, pkg)
+		case <-time.After(time.Second):
+			t.Fatal("the send timestamp is not reported")
+		}
+	}
+}
+
+func TestSessionFrameTimestampingDisabled(t *testing.T) {
+	ss, _ := newPipeSessions(t)
+	pkgs := []interface{}{1, 2}
+	assert.Equal(t, pkgs, ss.stampPkgs(pkgs))
+
+	ss.SetFrameTimestamping(true)
+	ss.stampRecv()
+	stamped := ss.stampPkgs(pkgs)
+	assert.Equal(t, TimestampedPkg{Pkg: 2, Received: ss.recvTime}, stamped[1])
+}
@@ -0,0 +1,125 @@
+/******************************************************
+# DESC       : tls versions, cipher suites & curves of tls endpoints
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-10 16:30
+# FILE       : tlssettings.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/tls"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+// CurveX25519MLKEM768 is the post-quantum hybrid key exchange of X25519 and ML-KEM-768, which
+// is supported since go 1.24. It is enabled by the default curves of go 1.24+, and it can be
+// put in TLSSettings.CurvePreferences explicitly only if getty is built by go 1.24+.
+const CurveX25519MLKEM768 tls.CurveID = 4588
+
+// the forward secret aead suites of tls 1.2. The suites of tls 1.3 are always secure and
+// can not be configured.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// TLSSettings is the tls versions, cipher suites, curves & renegotiation of a wss server or a
+// wss client. Its zero fields take the secure defaults.
+type TLSSettings struct {
+	// the min tls version. Its default value is tls.VersionTLS12.
+	MinVersion uint16
+	// the max tls version. 0 means the max version supported by go, i.e. tls 1.3.
+	MaxVersion uint16
+	// the cipher suites of tls 1.2 and below. Their default value is the ECDHE AEAD suites.
+	CipherSuites []uint16
+	// the curves in preference order. nil means the default curves of go, which include the
+	// post-quantum hybrid CurveX25519MLKEM768 since go 1.24.
+	CurvePreferences []tls.CurveID
+	// the renegotiation of a wss client, which is only supported by tls 1.2. Its default value
+	// is tls.RenegotiateNever. It is ignored by a wss server which never renegotiates.
+	Renegotiation tls.RenegotiationSupport
+}
+
+func (t TLSSettings) withDefaults() TLSSettings {
+	if t.MinVersion == 0 {
+		t.MinVersion = tls.VersionTLS12
+	}
+	if t.CipherSuites == nil {
+		t.CipherSuites = defaultCipherSuites
+	}
+
+	return t
+}
+
+// check the settings, and warn of the insecure cipher suites
+func (t TLSSettings) validate() error {
+	if t.MaxVersion != 0 && t.MinVersion > t.MaxVersion {
+		return jerrors.Errorf("tls min version %s > max version %s",
+			tls.VersionName(t.MinVersion), tls.VersionName(t.MaxVersion))
+	}
+
+	suites := make(map[uint16]bool)
+	for _, s := range tls.CipherSuites() {
+		suites[s.ID] = true
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		suites[s.ID] = false
+	}
+	for _, id := range t.CipherSuites {
+		secure, ok := suites[id]
+		if !ok {
+			return jerrors.Errorf("unknown tls cipher suite %#04x", id)
+		}
+		if !secure {
+			log.Warn("the tls cipher suite %s is insecure", tls.CipherSuiteName(id))
+		}
+	}
+
+	return nil
+}
+
+// get the copy of @config which takes the settings
+func (t TLSSettings) apply(config *tls.Config) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+
+	config.MinVersion = t.MinVersion
+	config.MaxVersion = t.MaxVersion
+	config.CipherSuites = t.CipherSuites
+	config.CurvePreferences = t.CurvePreferences
+	config.Renegotiation = t.Renegotiation
+	return config
+}
+
+// get the settings of the option @t
+func tlsSettings(t *TLSSettings) TLSSettings {
+	if t == nil {
+		return TLSSettings{}.withDefaults()
+	}
+
+	return t.withDefaults()
+}
+
+// apply the tls settings of the client to the tls config @config set by the user, which is
+// kept if the settings are not set.
+func (c *client) userTLSConfig(config *tls.Config) *tls.Config {
+	if c.tlsSettings == nil {
+		return config
+	}
+
+	return c.tlsSettings.withDefaults().apply(config)
+}
@@ -0,0 +1,61 @@
+package getty
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSSettings(t *testing.T) {
+	settings := tlsSettings(nil)
+	assert.Equal(t, uint16(tls.VersionTLS12), settings.MinVersion)
+	assert.Equal(t, defaultCipherSuites, settings.CipherSuites)
+	assert.Nil(t, settings.validate())
+
+	assert.NotNil(t, TLSSettings{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS12}.validate())
+	assert.NotNil(t, TLSSettings{CipherSuites: []uint16{0xffff}}.validate())
+	assert.Nil(t, TLSSettings{CipherSuites: []uint16{tls.TLS_RSA_WITH_RC4_128_SHA}}.validate())
+
+	config := &tls.Config{ServerName: "getty"}
+	c := tlsSettings(&TLSSettings{MaxVersion: tls.VersionTLS12, CurvePreferences: []tls.CurveID{tls.X25519}}).apply(config)
+	assert.Equal(t, "getty", c.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS12), c.MaxVersion)
+	assert.Equal(t, []tls.CurveID{tls.X25519}, c.CurvePreferences)
+	assert.Equal(t, uint16(0), config.MinVersion)
+
+	assert.Panics(t, func() {
+		newServer(WSS_SERVER, WithLocalAddress("127.0.0.1:0"),
+			WithServerTLSSettings(&TLSSettings{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS12}))
+	})
+}
+
+func TestClientUserTLSConfig(t *testing.T) {
+	config := &tls.Config{ServerName: "getty"}
+	client := newClient(WSS_CLIENT, WithServerAddress("wss://127.0.0.1:1/getty"), WithConnectionNumber(1))
+	defer client.Close()
+	assert.True(t, config == client.userTLSConfig(config))
+	assert.Equal(t, uint16(tls.VersionTLS12), client.wssTLSConfig().MinVersion)
+
+	client.tlsSettings = &TLSSettings{MinVersion: tls.VersionTLS13}
+	assert.Equal(t, uint16(tls.VersionTLS13), client.userTLSConfig(config).MinVersion)
+}
+
+func TestTLSSettingsHandshake(t *testing.T) {
+	suite := tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305
+	serverConfig := tlsSettings(&TLSSettings{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{suite}}).apply(newTestTLSConfig(t))
+	clientConfig := tlsSettings(nil).apply(&tls.Config{InsecureSkipVerify: true})
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	go tls.Server(server, serverConfig).Handshake()
+	conn := tls.Client(client, clientConfig)
+	assert.Nil(t, conn.Handshake())
+	state := conn.ConnectionState()
+	assert.Equal(t, uint16(tls.VersionTLS12), state.Version)
+	assert.Equal(t, suite, state.CipherSuite)
+}
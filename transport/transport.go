@@ -0,0 +1,172 @@
+/******************************************************
+# DESC       : custom stream transport
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-16 15:30
+# FILE       : transport.go
+******************************************************/
+
+package getty
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+// transportAddr is the address of a custom transport stream
+type transportAddr struct {
+	network string
+	addr    string
+}
+
+func (a transportAddr) Network() string {
+	return a.network
+}
+
+func (a transportAddr) String() string {
+	return a.addr
+}
+
+// transportConn adapts the stream of a custom transport, or a serial/bluetooth device, to
+// net.Conn, so its session can reuse the stream codec of tcp session.
+type transportConn struct {
+	io.ReadWriteCloser
+	local     transportAddr
+	peer      transportAddr
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewTransportConn adapts the stream of a custom transport, e.g. a ssh channel, to net.Conn,
+// whose network name is @network, e.g. "ssh", and whose addresses are @local & @peer. The
+// session of the returned conn runs like a tcp session, i.e. the stream wrappers, the
+// compressions, the negotiation and the codec switches work as well.
+//
+// The deadlines are forwarded to @stream if it implements SetDeadline/SetReadDeadline/
+// SetWriteDeadline, otherwise they are ignored, and @stream is closed by the session after
+// its read timeout once it stops, so the blocked Read returns.
+func NewTransportConn(network, local, peer string, stream io.ReadWriteCloser) net.Conn {
+	return &transportConn{
+		ReadWriteCloser: stream,
+		local:           transportAddr{network: network, addr: local},
+		peer:            transportAddr{network: network, addr: peer},
+	}
+}
+
+func (c *transportConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *transportConn) RemoteAddr() net.Addr {
+	return c.peer
+}
+
+// Close closes the stream once, for it is closed by the session and maybe by the stop timer.
+func (c *transportConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.ReadWriteCloser.Close()
+	})
+	return c.closeErr
+}
+
+func (c *transportConn) SetDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetDeadline(time.Time) error }); ok {
+		return d.SetDeadline(t)
+	}
+	return nil
+}
+
+func (c *transportConn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *transportConn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+// close the stream after @timeout if it does not support the read deadline, which is
+// invoked when the session stops.
+func (c *transportConn) closeAfter(timeout time.Duration) {
+	if _, ok := c.ReadWriteCloser.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return
+	}
+	time.AfterFunc(timeout, func() { c.Close() })
+}
+
+// TransportDialer dials a stream of a custom transport to @addr, which is the server address
+// of the transport client, e.g. opens a ssh channel by
+//
+//	func(addr string) (net.Conn, error) {
+//		channel, requests, err := sshConn.OpenChannel("getty", []byte(addr))
+//		if err != nil {
+//			return nil, err
+//		}
+//		go ssh.DiscardRequests(requests)
+//		return getty.NewTransportConn("ssh", local, addr, channel), nil
+//	}
+type TransportDialer func(addr string) (net.Conn, error)
+
+// NewTransportClient builds the client of a custom transport whose streams are dialed by
+// @dialer, and the server address is passed to @dialer. Its sessions are the same as the
+// ones of a tcp client, and the client redials when a session is closed.
+func NewTransportClient(dialer TransportDialer, opts ...ClientOption) Client {
+	if dialer == nil {
+		panic("NewTransportClient(dialer):@dialer is nil")
+	}
+
+	c := newClient(TRANSPORT_CLIENT, opts...)
+	c.transportDialer = dialer
+	return c
+}
+
+// NewTransportServer builds the server of a custom transport whose streams are accepted from
+// @listener, which returns the streams adapted by NewTransportConn, e.g. the ssh channels.
+// Its sessions are the same as the ones of a tcp server, and @listener is closed when the
+// server is closed. The local address is the one of @listener if it is not set by
+// WithLocalAddress.
+func NewTransportServer(listener net.Listener, opts ...ServerOption) Server {
+	if listener == nil {
+		panic("NewTransportServer(listener):@listener is nil")
+	}
+
+	s := newServer(TRANSPORT_SERVER, append([]ServerOption{WithLocalAddress(listener.Addr().String())}, opts...)...)
+	s.streamListener = listener
+	return s
+}
+
+// dial a stream by the transport dialer until it succeeds or the client is closed
+func (c *client) dialTransport() Session {
+	for {
+		if c.IsClosed() {
+			return nil
+		}
+
+		conn, err := c.transportDialer(c.addr)
+		if err == nil {
+			var ss Session
+			if ss, err = newNegotiatedTCPSession(conn, c, c.negotiation, true); err == nil {
+				ss.SetName(defaultTransportSessionName)
+				return ss
+			}
+			conn.Close()
+		}
+
+		log.Info("transport dial(addr:%s) = error{%s}", c.addr, jerrors.ErrorStack(err))
+		c.onDialError(c.addr, err)
+		<-wheel.After(connectInterval)
+	}
+}
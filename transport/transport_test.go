@@ -0,0 +1,131 @@
+package getty
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// pipeStream hides the net.Conn methods of a pipe like a ssh channel
+type pipeStream struct {
+	io.ReadWriteCloser
+}
+
+// pipeListener accepts the pipe streams dialed by its dial
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn, 4), done: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return transportAddr{network: "pipe", addr: "pipe-server"}
+}
+
+func (l *pipeListener) dial(addr string) (net.Conn, error) {
+	c1, c2 := net.Pipe()
+	l.conns <- NewTransportConn("pipe", addr, "pipe-client", pipeStream{c2})
+	return NewTransportConn("pipe", "pipe-client", addr, pipeStream{c1}), nil
+}
+
+type echoLineListener struct {
+	MessageHandler
+}
+
+func (l *echoLineListener) OnMessage(ss Session, pkg interface{}) {
+	ss.WritePkg(pkg, 0)
+}
+
+func TestTransportConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	conn := NewTransportConn("pipe", "a", "b", c1)
+	assert.Equal(t, "pipe", conn.LocalAddr().Network())
+	assert.Equal(t, "a", conn.LocalAddr().String())
+	assert.Equal(t, "b", conn.RemoteAddr().String())
+
+	// the deadline is forwarded to the pipe
+	assert.Nil(t, conn.SetReadDeadline(time.Now()))
+	_, err := conn.Read(make([]byte, 1))
+	assert.True(t, err.(net.Error).Timeout())
+	assert.Nil(t, conn.Close())
+	assert.Nil(t, conn.Close())
+
+	// the stream without deadlines is closed after the timeout
+	c1, c2 = net.Pipe()
+	defer c2.Close()
+	conn = NewTransportConn("pipe", "a", "b", pipeStream{c1})
+	assert.Nil(t, conn.SetDeadline(time.Now()))
+	conn.(*transportConn).closeAfter(10 * time.Millisecond)
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.ErrClosedPipe, err)
+}
+
+func TestTransportClientServer(t *testing.T) {
+	listener := newPipeListener()
+	server := NewTransportServer(listener)
+	assert.Equal(t, TRANSPORT_SERVER, server.EndPointType())
+	var serverHandler echoLineListener
+	server.RunEventLoop(func(ss Session) error {
+		assert.Equal(t, defaultTransportSessionName, ss.(*session).name)
+		assert.Equal(t, "pipe-client", ss.RemoteAddr())
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(&serverHandler)
+		ss.SetCompressType(CompressBestSpeed)
+		return nil
+	})
+
+	client := NewTransportClient(listener.dial, WithServerAddress("pipe-server"), WithConnectionNumber(1))
+	assert.Equal(t, TRANSPORT_CLIENT, client.EndPointType())
+	clientHandler := &lineListener{msgs: make(chan interface{}, 4)}
+	client.RunEventLoop(func(ss Session) error {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(clientHandler)
+		ss.SetCompressType(CompressBestSpeed)
+		return nil
+	})
+	assert.Equal(t, 1, clientHandler.SessionNumber())
+
+	for i := 0; i < 100 && serverHandler.SessionNumber() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, serverHandler.SessionNumber())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	assert.Nil(t, client.(ClientWriter).WritePkgContext(ctx, "hello"))
+	select {
+	case msg := <-clientHandler.msgs:
+		assert.Equal(t, "hello", msg)
+	case <-ctx.Done():
+		t.Fatal("the echo is not received")
+	}
+
+	client.Close()
+	server.Close()
+}
@@ -0,0 +1,179 @@
+/******************************************************
+# DESC       : probe based transport selection of client
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-04 10:40
+# FILE       : transportprobe.go
+******************************************************/
+
+package getty
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	"github.com/gorilla/websocket"
+	jerrors "github.com/juju/errors"
+)
+
+const defaultTransportProbeInterval = time.Minute
+
+// TransportProbeConfig is the transport probe config of a ws/wss client with multiple urls.
+// A probe dials every url and measures its rtt by the opening handshake, which includes
+// the tcp connect, the tls handshake and the websocket upgrade. The reachable url with the
+// lowest rtt is selected, and only the new sessions migrate to it.
+type TransportProbeConfig struct {
+	// the interval of the probes. Its default value is 1m.
+	Interval time.Duration
+	// the handshake timeout of a probe. Its default value is the handshake timeout of the client.
+	Timeout time.Duration
+}
+
+func (c TransportProbeConfig) withDefaults(handshakeTimeout time.Duration) TransportProbeConfig {
+	if c.Interval <= 0 {
+		c.Interval = defaultTransportProbeInterval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = handshakeTimeout
+	}
+
+	return c
+}
+
+// TransportProbeResult is the latest probe result of a ws/wss url.
+type TransportProbeResult struct {
+	URL string
+	// the handshake time of the probe. It is zero if the url is unreachable.
+	RTT time.Duration
+	// the dial error of the probe
+	Err error
+	// the observed bytes per second of the 10s rolling rates of the sessions dialed to the url
+	Throughput float64
+	// the number of the sessions dialed to the url
+	SessionNum int
+	ProbedAt   time.Time
+}
+
+func (r TransportProbeResult) String() string {
+	return fmt.Sprintf("{url:%s, rtt:%s, err:%v, throughput:%.1f/s, sessions:%d, probed at:%s}",
+		r.URL, r.RTT, r.Err, r.Throughput, r.SessionNum, r.ProbedAt.Format(time.RFC3339))
+}
+
+// probe the urls once before the first sessions are dialed, and then periodically
+func (c *client) startTransportProbe() {
+	if c.transportProbe == nil || len(c.wsURLs) < 2 ||
+		(c.endPointType != WS_CLIENT && c.endPointType != WSS_CLIENT) {
+		return
+	}
+
+	config := c.transportProbe.withDefaults(c.handshakeTimeout)
+	c.probeTransports(config)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-wheel.After(config.Interval):
+				c.probeTransports(config)
+			}
+		}
+	}()
+}
+
+// probe all urls concurrently and select the best one
+func (c *client) probeTransports(config TransportProbeConfig) {
+	var defaultTLSConfig *tls.Config
+	if c.endPointType == WSS_CLIENT {
+		defaultTLSConfig = c.wssTLSConfig()
+	}
+
+	var wg sync.WaitGroup
+	results := make([]TransportProbeResult, len(c.wsURLs))
+	for i := range c.wsURLs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = probeTransport(c.wsURLs[i], defaultTLSConfig, config.Timeout)
+		}(i)
+	}
+	wg.Wait()
+	c.observeThroughput(results)
+
+	best := -1
+	for i, r := range results {
+		if r.Err == nil && (best < 0 || r.RTT < results[best].RTT) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		if old := atomic.SwapUint32(&c.wsURLIndex, uint32(best)); int(old)%len(c.wsURLs) != best {
+			log.Info("client{%s} migrates the new sessions to the url %s", c.addr, results[best])
+		}
+	}
+
+	c.probeLock.Lock()
+	c.probeResults = results
+	c.probeLock.Unlock()
+}
+
+func probeTransport(u WebsocketURL, config *tls.Config, timeout time.Duration) TransportProbeResult {
+	if u.TLSConfig != nil {
+		config = u.TLSConfig
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: timeout, TLSClientConfig: config}
+
+	result := TransportProbeResult{URL: u.URL, ProbedAt: time.Now()}
+	conn, _, err := dialer.Dial(u.URL, nil)
+	if err != nil {
+		result.Err = jerrors.Annotatef(handshakeError(err), "probe %s", u.URL)
+		return result
+	}
+	result.RTT = time.Since(result.ProbedAt)
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "probe"), time.Now().Add(timeout))
+	conn.Close()
+
+	return result
+}
+
+// sum the rates of the running sessions of the client by their urls
+func (c *client) observeThroughput(results []TransportProbeResult) {
+	c.Lock()
+	sessions := make([]Session, 0, len(c.ssMap))
+	for ss := range c.ssMap {
+		sessions = append(sessions, ss)
+	}
+	c.Unlock()
+
+	for _, ss := range sessions {
+		url, _ := ss.GetAttribute(sessionURLKey).(string)
+		for i := range results {
+			if results[i].URL == url {
+				rate := ss.(SessionInspector).Rates().Rate10s
+				results[i].Throughput += rate.ReadBytes + rate.WriteBytes
+				results[i].SessionNum++
+				break
+			}
+		}
+	}
+}
+
+// TransportProbes returns the latest probe results of the ws/wss urls of the client.
+func (c *client) TransportProbes() []TransportProbeResult {
+	c.probeLock.Lock()
+	defer c.probeLock.Unlock()
+
+	if c.probeResults == nil {
+		return nil
+	}
+	return append([]TransportProbeResult(nil), c.probeResults...)
+}
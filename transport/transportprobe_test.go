@@ -0,0 +1,67 @@
+package getty
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func newProbeWSServer(t *testing.T, delay time.Duration) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestClientTransportProbe(t *testing.T) {
+	slow := newProbeWSServer(t, 100*time.Millisecond)
+	fast := newProbeWSServer(t, 0)
+
+	clt := newClient(WS_CLIENT,
+		WithConnectionNumber(1),
+		WithWebsocketURLs(
+			WebsocketURL{URL: slow},
+			WebsocketURL{URL: "ws://127.0.0.1:1/hello"},
+			WebsocketURL{URL: fast},
+		),
+		WithTransportProbe(&TransportProbeConfig{Timeout: time.Second}),
+	)
+	defer clt.Close()
+	assert.Nil(t, clt.TransportProbes())
+
+	clt.probeTransports(clt.transportProbe.withDefaults(clt.handshakeTimeout))
+	assert.Equal(t, uint32(2), atomic.LoadUint32(&clt.wsURLIndex))
+	results := clt.TransportProbes()
+	assert.Equal(t, 3, len(results))
+	assert.Nil(t, results[0].Err)
+	assert.True(t, results[0].RTT >= 100*time.Millisecond)
+	assert.NotNil(t, results[1].Err)
+	assert.Nil(t, results[2].Err)
+	assert.True(t, results[2].RTT < results[0].RTT)
+
+	// the new sessions dial the best url
+	ss := clt.dialWS()
+	assert.NotNil(t, ss)
+	assert.Equal(t, fast, ss.GetAttribute(sessionURLKey))
+	clt.Lock()
+	clt.ssMap[ss] = struct{}{}
+	clt.Unlock()
+	clt.observeThroughput(results)
+	assert.Equal(t, 1, results[2].SessionNum)
+	assert.Equal(t, 0, results[0].SessionNum)
+	ss.(*session).Connection.close(0)
+}
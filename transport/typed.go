@@ -0,0 +1,148 @@
+/******************************************************
+# DESC       : generic typed session
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-13 10:30
+# FILE       : typed.go
+******************************************************/
+
+package getty
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+// TypedReader is used to unmarshal a complete pkg of type T from buffer.
+// Its cases are the same as Reader's except that @ok should be true only in case 4,
+// that is to say, the return value is (pkg, pkgLen, true, nil) as case 4.
+type TypedReader[T any] interface {
+	Read(ss Session, data []byte) (pkg T, pkgLen int, ok bool, err error)
+}
+
+// TypedWriter is used to marshal pkg of type T and write to session
+type TypedWriter[T any] interface {
+	Write(Session, T) ([]byte, error)
+}
+
+// TypedReadWriter is the typed package handler interface
+type TypedReadWriter[T any] interface {
+	TypedReader[T]
+	TypedWriter[T]
+}
+
+// TypedEventListener is used to process pkg of type T that received from remote session.
+// Its callbacks are the same as EventListener's.
+type TypedEventListener[T any] interface {
+	OnOpen(*TypedSession[T]) error
+	OnClose(*TypedSession[T])
+	OnError(*TypedSession[T], error)
+	OnCron(*TypedSession[T])
+	OnMessage(*TypedSession[T], T)
+}
+
+// TypedSession wraps a tcp or websocket Session whose codec produces/consumes T.
+// Udp sessions are not supported because their pkgs are wrapped in UDPContext.
+type TypedSession[T any] struct {
+	Session
+}
+
+// NewTypedSession wraps @ss. It is used in NewSessionCallback generally, e.g.
+//
+//	func newSession(ss getty.Session) error {
+//		ts := getty.NewTypedSession[*Message](ss)
+//		ts.SetPkgHandler(messageCodec{})
+//		ts.SetEventListener(messageHandler{})
+//		return nil
+//	}
+func NewTypedSession[T any](ss Session) *TypedSession[T] {
+	return &TypedSession[T]{Session: ss}
+}
+
+// set the typed package handler
+func (s *TypedSession[T]) SetPkgHandler(handler TypedReadWriter[T]) {
+	s.Session.SetPkgHandler(typedReadWriter[T]{handler: handler})
+}
+
+// set the typed event listener
+func (s *TypedSession[T]) SetEventListener(listener TypedEventListener[T]) {
+	s.Session.SetEventListener(&typedEventListener[T]{session: s, listener: listener})
+}
+
+// WritePkg is the same as (Session)WritePkg except that @pkg is of type T.
+func (s *TypedSession[T]) WritePkg(pkg T, timeout time.Duration) error {
+	return s.Session.WritePkg(pkg, timeout)
+}
+
+// WritePkgContext is the same as (SessionWriter)WritePkgContext except that @pkg is of type T.
+func (s *TypedSession[T]) WritePkgContext(ctx context.Context, pkg T) error {
+	writer, ok := s.Session.(SessionWriter)
+	if !ok {
+		return jerrors.Errorf("%T is not a SessionWriter", s.Session)
+	}
+	return writer.WritePkgContext(ctx, pkg)
+}
+
+/////////////////////////////////////////
+// adapters
+/////////////////////////////////////////
+
+type typedReadWriter[T any] struct {
+	handler TypedReadWriter[T]
+}
+
+func (rw typedReadWriter[T]) Read(ss Session, data []byte) (interface{}, int, error) {
+	pkg, pkgLen, ok, err := rw.handler.Read(ss, data)
+	if err != nil || !ok {
+		return nil, pkgLen, err
+	}
+
+	return pkg, pkgLen, nil
+}
+
+func (rw typedReadWriter[T]) Write(ss Session, pkg interface{}) ([]byte, error) {
+	p, ok := pkg.(T)
+	if !ok {
+		return nil, fmt.Errorf("illegal pkg:%#v, its type is %T", pkg, pkg)
+	}
+
+	return rw.handler.Write(ss, p)
+}
+
+type typedEventListener[T any] struct {
+	session  *TypedSession[T]
+	listener TypedEventListener[T]
+}
+
+func (l *typedEventListener[T]) OnOpen(Session) error {
+	return l.listener.OnOpen(l.session)
+}
+
+func (l *typedEventListener[T]) OnClose(Session) {
+	l.listener.OnClose(l.session)
+}
+
+func (l *typedEventListener[T]) OnError(_ Session, err error) {
+	l.listener.OnError(l.session, err)
+}
+
+func (l *typedEventListener[T]) OnCron(Session) {
+	l.listener.OnCron(l.session)
+}
+
+func (l *typedEventListener[T]) OnMessage(ss Session, pkg interface{}) {
+	p, ok := pkg.(T)
+	if !ok {
+		log.Error("%s, [TypedSession.OnMessage] illegal pkg:%#v, its type is %T", ss.Stat(), pkg, pkg)
+		return
+	}
+
+	l.listener.OnMessage(l.session, p)
+}
@@ -0,0 +1,69 @@
+package getty
+
+import (
+	"testing"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+// codec of "\n" terminated line
+type lineCodec struct{}
+
+func (lineCodec) Read(ss Session, data []byte) (string, int, bool, error) {
+	for i, b := range data {
+		if b == '\n' {
+			return string(data[:i]), i + 1, true, nil
+		}
+	}
+
+	return "", 0, false, nil
+}
+
+func (lineCodec) Write(ss Session, pkg string) ([]byte, error) {
+	return []byte(pkg + "\n"), nil
+}
+
+type lineHandler struct {
+	opened bool
+	msgs   []string
+}
+
+func (h *lineHandler) OnOpen(*TypedSession[string]) error   { h.opened = true; return nil }
+func (h *lineHandler) OnClose(*TypedSession[string])        {}
+func (h *lineHandler) OnError(*TypedSession[string], error) {}
+func (h *lineHandler) OnCron(*TypedSession[string])         {}
+func (h *lineHandler) OnMessage(ts *TypedSession[string], pkg string) {
+	h.msgs = append(h.msgs, pkg)
+}
+
+func TestTypedSession(t *testing.T) {
+	var handler lineHandler
+
+	ss, _ := newPipeSessions(t)
+	ts := NewTypedSession[string](ss)
+	ts.SetPkgHandler(lineCodec{})
+	ts.SetEventListener(&handler)
+
+	pkg, pkgLen, err := ss.reader.Read(ss, []byte("hello"))
+	assert.Nil(t, err)
+	assert.Nil(t, pkg)
+	assert.Equal(t, 0, pkgLen)
+	pkg, pkgLen, err = ss.reader.Read(ss, []byte("hello\nworld"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", pkg)
+	assert.Equal(t, 6, pkgLen)
+
+	data, err := ss.writer.Write(ss, "hello")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello\n"), data)
+	_, err = ss.writer.Write(ss, 1)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ss.listener.OnOpen(ss))
+	assert.True(t, handler.opened)
+	ss.listener.OnMessage(ss, "hello")
+	ss.listener.OnMessage(ss, 1)
+	assert.Equal(t, []string{"hello"}, handler.msgs)
+}
@@ -0,0 +1,141 @@
+/******************************************************
+# DESC       : kernel & hardware timestamps of udp datagrams
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-07 11:10
+# FILE       : udpstamp.go
+******************************************************/
+
+package getty
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultUDPTxTimestampTimeout = 10 * time.Millisecond
+	// the size of the control messages carrying the timestamps
+	udpTimestampOOBLen = 512
+)
+
+// UDPTimestampingConfig is the config of the kernel timestamps of a udp session, i.e.
+// SO_TIMESTAMPING on linux, which are taken by the kernel or the NIC instead of the
+// application, for the accurate time sync measurements.
+type UDPTimestampingConfig struct {
+	// stamp the received datagrams. The timestamps are carried by UDPContext.Timestamp. The
+	// kernel may enable the rx timestamping asynchronously, so the first datagrams after it
+	// is enabled may be unstamped.
+	RX bool
+	// stamp the sent datagrams. The timestamps are reported by UDPTxTimestampListener.
+	TX bool
+	// get the raw hardware timestamps of the NIC besides the kernel software ones. The
+	// hardware timestamping of the NIC should be enabled by its driver in advance, e.g. by
+	// hwstamp_ctl, which needs CAP_NET_ADMIN.
+	Hardware bool
+	// the max wait for the tx timestamps after a pkg is sent. The write goroutine waits for
+	// them, so the tx timestamping fits the measurement sessions of low rate. Its default
+	// value is 10ms.
+	TxTimeout time.Duration
+}
+
+func (c UDPTimestampingConfig) withDefaults() UDPTimestampingConfig {
+	if c.TxTimeout <= 0 {
+		c.TxTimeout = defaultUDPTxTimestampTimeout
+	}
+
+	return c
+}
+
+// the number of the tx timestamps of a datagram
+func (c *UDPTimestampingConfig) txStampsPerDatagram() int {
+	if c.Hardware {
+		return 2
+	}
+	return 1
+}
+
+// PacketTimestamp is the timestamps of a datagram. A zero time means the timestamp is
+// not available, e.g. the NIC does not support the hardware timestamping.
+type PacketTimestamp struct {
+	// taken by the kernel when the datagram was received from or handed to the driver
+	Software time.Time
+	// taken by the NIC in its own clock, e.g. the ptp hardware clock
+	Hardware time.Time
+}
+
+func (t PacketTimestamp) IsZero() bool {
+	return t.Software.IsZero() && t.Hardware.IsZero()
+}
+
+func (t PacketTimestamp) String() string {
+	return fmt.Sprintf("{software:%s, hardware:%s}", t.Software, t.Hardware)
+}
+
+// merge the timestamps of @o into @t. The later timestamps win, so the timestamps of a
+// pkg split into several datagrams are the ones of its last datagram.
+func (t *PacketTimestamp) merge(o PacketTimestamp) {
+	if !o.Software.IsZero() {
+		t.Software = o.Software
+	}
+	if !o.Hardware.IsZero() {
+		t.Hardware = o.Hardware
+	}
+}
+
+// UDPTxTimestampListener is implemented by the EventListener which wants the tx timestamps
+// of the pkgs written to a udp session whose tx timestamping is enabled. OnTxTimestamp is
+// invoked in the write goroutine, and @ts is zero if no timestamp arrived in time.
+type UDPTxTimestampListener interface {
+	OnTxTimestamp(session Session, pkg interface{}, ts PacketTimestamp)
+}
+
+// SetUDPTimestamping enables the kernel timestamps of a udp session, and nil @config disables
+// them. It should be invoked before the session runs, e.g. in NewSessionCallback. It returns
+// ErrNotSupported if SO_TIMESTAMPING is not supported by the platform, and has no effect on
+// tcp/websocket sessions.
+func (s *session) SetUDPTimestamping(config *UDPTimestampingConfig) error {
+	conn, ok := s.Connection.(*gettyUDPConn)
+	if !ok {
+		return nil
+	}
+
+	if err := setUDPTimestamping(conn.conn, config); err != nil {
+		return err
+	}
+	conn.stamping, conn.oob = nil, nil
+	if config == nil {
+		return nil
+	}
+	c := config.withDefaults()
+	conn.stamping = &c
+	if c.RX {
+		conn.oob = make([]byte, udpTimestampOOBLen)
+	}
+	return nil
+}
+
+// collect the tx timestamps of the datagrams sent by the current send
+func (u *gettyUDPConn) stampTx(datagrams int) {
+	if u.stamping == nil || !u.stamping.TX || datagrams == 0 {
+		return
+	}
+
+	var err error
+	u.txStamp, err = readTxTimestamps(u.conn, datagrams*u.stamping.txStampsPerDatagram(), u.stamping.TxTimeout)
+	if err != nil {
+		sampledWarn("[gettyUDPConn.stampTx] local:%s, peer:%s, error:%v", u.local, u.peer, err)
+	}
+}
+
+// report the tx timestamps of @pkg which has been sent
+func (s *session) reportTxTimestamp(pkg interface{}) {
+	conn, ok := s.Connection.(*gettyUDPConn)
+	if !ok || conn.stamping == nil || !conn.stamping.TX {
+		return
+	}
+	if listener, ok := s.getListener().(UDPTxTimestampListener); ok {
+		listener.OnTxTimestamp(s, pkg, conn.txStamp)
+	}
+}
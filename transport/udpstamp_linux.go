@@ -0,0 +1,137 @@
+//go:build linux
+// +build linux
+
+/******************************************************
+# DESC       : kernel & hardware timestamps of udp datagrams on linux
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-07 11:10
+# FILE       : udpstamp_linux.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+	"time"
+	"unsafe"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+	"golang.org/x/sys/unix"
+)
+
+// the interval of polling the error queue for the tx timestamps
+const udpTxTimestampPollInterval = 20 * time.Microsecond
+
+// enable SO_TIMESTAMPING of @conn by @config, and nil @config disables it
+func setUDPTimestamping(conn *net.UDPConn, config *UDPTimestampingConfig) error {
+	var flags int
+	if config != nil {
+		if config.RX {
+			flags |= unix.SOF_TIMESTAMPING_RX_SOFTWARE
+			if config.Hardware {
+				flags |= unix.SOF_TIMESTAMPING_RX_HARDWARE
+			}
+		}
+		if config.TX {
+			// the datagrams are not looped back with the tx timestamps
+			flags |= unix.SOF_TIMESTAMPING_TX_SOFTWARE | unix.SOF_TIMESTAMPING_OPT_TSONLY
+			if config.Hardware {
+				flags |= unix.SOF_TIMESTAMPING_TX_HARDWARE
+			}
+		}
+		if flags != 0 {
+			flags |= unix.SOF_TIMESTAMPING_SOFTWARE
+			if config.Hardware {
+				flags |= unix.SOF_TIMESTAMPING_RAW_HARDWARE
+			}
+		}
+	}
+
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return jerrors.Trace(err)
+	}
+	var opErr error
+	err = rawConn.Control(func(fd uintptr) {
+		opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_TIMESTAMPING, flags)
+	})
+	if err == nil {
+		err = opErr
+	}
+
+	return jerrors.Trace(err)
+}
+
+// get the timestamps from the control messages @oob
+func parsePacketTimestamp(oob []byte) PacketTimestamp {
+	var ts PacketTimestamp
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return ts
+	}
+
+	for _, msg := range msgs {
+		if msg.Header.Level != unix.SOL_SOCKET || msg.Header.Type != unix.SCM_TIMESTAMPING {
+			continue
+		}
+		// struct scm_timestamping {struct timespec ts[3];}, ts[1] is deprecated
+		var stamps [3]unix.Timespec
+		if len(msg.Data) < int(unsafe.Sizeof(stamps)) {
+			continue
+		}
+		copy((*[unsafe.Sizeof(stamps)]byte)(unsafe.Pointer(&stamps))[:], msg.Data)
+		if stamps[0].Sec != 0 || stamps[0].Nsec != 0 {
+			ts.Software = time.Unix(stamps[0].Unix())
+		}
+		if stamps[2].Sec != 0 || stamps[2].Nsec != 0 {
+			ts.Hardware = time.Unix(stamps[2].Unix())
+		}
+	}
+
+	return ts
+}
+
+// read @n tx timestamps from the error queue of @conn within @timeout. The error queue is
+// read without the read lock of @conn, so the blocked read goroutine does not delay it.
+func readTxTimestamps(conn *net.UDPConn, n int, timeout time.Duration) (PacketTimestamp, error) {
+	var ts PacketTimestamp
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return ts, jerrors.Trace(err)
+	}
+
+	var (
+		oob      = make([]byte, udpTimestampOOBLen)
+		deadline = time.Now().Add(timeout)
+	)
+	for got := 0; got < n; {
+		var (
+			oobn  int
+			opErr error
+		)
+		err = rawConn.Control(func(fd uintptr) {
+			_, oobn, _, _, opErr = unix.Recvmsg(int(fd), nil, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+		})
+		if err == nil {
+			err = opErr
+		}
+		if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+			if time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(udpTxTimestampPollInterval)
+			continue
+		}
+		if err != nil {
+			return ts, jerrors.Trace(err)
+		}
+		ts.merge(parsePacketTimestamp(oob[:oobn]))
+		got++
+	}
+
+	return ts, nil
+}
@@ -0,0 +1,33 @@
+//go:build !linux
+// +build !linux
+
+/******************************************************
+# DESC       : kernel & hardware timestamps of udp datagrams stub
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-07 11:10
+# FILE       : udpstamp_others.go
+******************************************************/
+
+package getty
+
+import (
+	"net"
+	"time"
+)
+
+func setUDPTimestamping(conn *net.UDPConn, config *UDPTimestampingConfig) error {
+	if config == nil {
+		return nil
+	}
+	return ErrNotSupported
+}
+
+func parsePacketTimestamp(oob []byte) PacketTimestamp {
+	return PacketTimestamp{}
+}
+
+func readTxTimestamps(conn *net.UDPConn, n int, timeout time.Duration) (PacketTimestamp, error) {
+	return PacketTimestamp{}, ErrNotSupported
+}
@@ -0,0 +1,86 @@
+package getty
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type txStampListener struct {
+	MessageHandler
+
+	stamps chan PacketTimestamp
+}
+
+func (l *txStampListener) OnTxTimestamp(ss Session, pkg interface{}, ts PacketTimestamp) {
+	l.stamps <- ts
+}
+
+func TestUDPSessionTimestamping(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer peer.Close()
+	local, err := net.DialUDP("udp", nil, peer.LocalAddr().(*net.UDPAddr))
+	assert.Nil(t, err)
+	defer local.Close()
+
+	ss := newUDPSession(local, newClient(UDP_CLIENT, WithServerAddress(peer.LocalAddr().String()), WithConnectionNumber(1)))
+	err = ss.(DatagramSession).SetUDPTimestamping(&UDPTimestampingConfig{RX: true, TX: true, TxTimeout: time.Second})
+	if runtime.GOOS != "linux" {
+		assert.Equal(t, ErrNotSupported, err)
+		return
+	}
+	assert.Nil(t, err)
+	listener := &txStampListener{stamps: make(chan PacketTimestamp, 1)}
+	ss.SetEventListener(listener)
+
+	// the loopback device stamps the sent datagrams by software
+	before := time.Now()
+	conn := ss.(*session).Connection.(*gettyUDPConn)
+	_, err = conn.send(UDPContext{Pkg: []byte("ping")})
+	assert.Nil(t, err)
+	ss.(*session).reportTxTimestamp([]byte("ping"))
+	ts := <-listener.stamps
+	assert.False(t, ts.Software.IsZero())
+	assert.True(t, ts.Hardware.IsZero())
+	assert.True(t, ts.Software.Sub(before) > -time.Second && time.Since(ts.Software) < time.Second, ts)
+
+	buf := make([]byte, 64)
+	_, addr, err := peer.ReadFromUDP(buf)
+	assert.Nil(t, err)
+	// the kernel enables the rx timestamping asynchronously, so the first datagrams may be
+	// received before it takes effect
+	before = time.Now()
+	for i := 0; i < 100 && conn.rxStamp.IsZero(); i++ {
+		_, err = peer.WriteToUDP([]byte("pong"), addr)
+		assert.Nil(t, err)
+		n, _, err := conn.recv(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, "pong", string(buf[:n]))
+		if conn.rxStamp.IsZero() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	assert.False(t, conn.rxStamp.Software.IsZero())
+	assert.True(t, conn.rxStamp.Software.Sub(before) > -time.Second && time.Since(conn.rxStamp.Software) < time.Second)
+
+	assert.Nil(t, ss.(DatagramSession).SetUDPTimestamping(nil))
+	assert.Nil(t, conn.stamping)
+}
+
+func TestPacketTimestampMerge(t *testing.T) {
+	var (
+		ts  PacketTimestamp
+		now = time.Now()
+	)
+	assert.True(t, ts.IsZero())
+	ts.merge(PacketTimestamp{Software: now})
+	ts.merge(PacketTimestamp{Hardware: now.Add(time.Second)})
+	assert.Equal(t, PacketTimestamp{Software: now, Hardware: now.Add(time.Second)}, ts)
+	assert.Equal(t, PacketTimestamp{}, parsePacketTimestamp(nil))
+}
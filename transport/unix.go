@@ -0,0 +1,198 @@
+/******************************************************
+# DESC       : unix domain socket transport
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-08 10:30
+# FILE       : unix.go
+******************************************************/
+
+package getty
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+	jerrors "github.com/juju/errors"
+)
+
+const (
+	// the timeout of probing whether an existing socket file has a live listener
+	unixStaleProbeTimeout = time.Second
+)
+
+var errUnixSocketInUse = jerrors.New("unix socket is in use")
+
+// UnixSocketConfig is the config of the socket file of a unix server. It has no effect on
+// the abstract namespace addresses, e.g. "@getty", which have no file.
+type UnixSocketConfig struct {
+	// the permission bits of the socket file, e.g. 0660. 0 keeps the ones decided by the umask.
+	// The socket file is accessible by the umask permissions between the bind and the chmod,
+	// so pls restrict the umask or the directory if it matters.
+	Mode os.FileMode
+	// the owner & the group of the socket file by name or numeric id. Empty keeps the current
+	// ones. Changing the owner needs the privilege.
+	User  string
+	Group string
+}
+
+// abstract namespace address, which is only supported on linux
+func isAbstractUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, "@")
+}
+
+// NewUnixServer builds a unix domain stream socket server whose address is a socket file
+// path, e.g. "/var/run/app.sock", or an abstract namespace address on linux, e.g. "@app".
+// Its sessions are the same as the ones of a tcp server. A stale socket file left by a
+// crashed server is removed before the bind, and the socket file is removed on close.
+func NewUnixServer(opts ...ServerOption) Server {
+	return newServer(UNIX_SERVER, opts...)
+}
+
+// NewUnixClient builds a unix domain stream socket client whose server address is a socket
+// file path or an abstract namespace address on linux.
+func NewUnixClient(opts ...ClientOption) Client {
+	return newClient(UNIX_CLIENT, opts...)
+}
+
+func (s *server) listenUnix() error {
+	if isAbstractUnixAddr(s.addr) && !abstractUnixSocketSupported {
+		return jerrors.Annotatef(ErrNotSupported, "abstract unix socket %s", s.addr)
+	}
+	if !isAbstractUnixAddr(s.addr) {
+		if err := removeStaleUnixSocket(s.addr); err != nil {
+			return jerrors.Trace(err)
+		}
+	}
+
+	streamListener, err := net.Listen("unix", s.addr)
+	if err != nil {
+		return jerrors.Annotatef(err, "net.Listen(unix, addr:%s)", s.addr)
+	}
+	if !isAbstractUnixAddr(s.addr) && s.unixSocket != nil {
+		if err = setUnixSocketFile(s.addr, s.unixSocket); err != nil {
+			streamListener.Close()
+			return jerrors.Trace(err)
+		}
+	}
+
+	s.streamListener = streamListener
+	return nil
+}
+
+// remove the socket file @path if no listener answers on it. It fails if @path is a live
+// socket or not a socket.
+func removeStaleUnixSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return jerrors.Trace(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return jerrors.Errorf("%s exists and is not a unix socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, unixStaleProbeTimeout)
+	if err == nil {
+		conn.Close()
+		return jerrors.Annotatef(errUnixSocketInUse, "path:%s", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return jerrors.Annotatef(err, "probe unix socket %s", path)
+	}
+
+	log.Info("remove the stale unix socket %s", path)
+	return jerrors.Trace(os.Remove(path))
+}
+
+// set the mode & the owner of the socket file @path by @config
+func setUnixSocketFile(path string, config *UnixSocketConfig) error {
+	if config.Mode != 0 {
+		if err := os.Chmod(path, config.Mode); err != nil {
+			return jerrors.Trace(err)
+		}
+	}
+	if config.User == "" && config.Group == "" {
+		return nil
+	}
+
+	uid, gid := -1, -1
+	if config.User != "" {
+		id, err := lookupID(config.User, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return jerrors.Annotatef(err, "lookup user %s", config.User)
+		}
+		uid = id
+	}
+	if config.Group != "" {
+		id, err := lookupID(config.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return jerrors.Annotatef(err, "lookup group %s", config.Group)
+		}
+		gid = id
+	}
+
+	return jerrors.Trace(os.Lchown(path, uid, gid))
+}
+
+// get the numeric id of @name, which is a numeric id or a name resolved by @lookup
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+func (c *client) dialUnix() Session {
+	for {
+		if c.IsClosed() {
+			return nil
+		}
+
+		var conn net.Conn
+		err := jerrors.Annotatef(ErrNotSupported, "abstract unix socket %s", c.addr)
+		if !isAbstractUnixAddr(c.addr) || abstractUnixSocketSupported {
+			conn, err = net.DialTimeout("unix", c.addr, connectTimeout)
+		}
+		if err == nil {
+			var ss Session
+			if ss, err = newNegotiatedTCPSession(conn, c, c.negotiation, true); err == nil {
+				ss.SetName(defaultUnixSessionName)
+				return ss
+			}
+			conn.Close()
+		}
+
+		log.Info("net.DialTimeout(unix, addr:%s, timeout:%v) = error{%s}", c.addr, connectTimeout, jerrors.ErrorStack(err))
+		c.onDialError(c.addr, err)
+		<-wheel.After(connectInterval)
+	}
+}
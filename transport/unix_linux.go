@@ -0,0 +1,16 @@
+//go:build linux
+// +build linux
+
+/******************************************************
+# DESC       : unix domain socket transport on linux
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-08 10:30
+# FILE       : unix_linux.go
+******************************************************/
+
+package getty
+
+// the addresses beginning with @ are in the abstract namespace, see unix(7)
+const abstractUnixSocketSupported = true
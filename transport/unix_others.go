@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+/******************************************************
+# DESC       : unix domain socket transport on other platforms
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-08 10:30
+# FILE       : unix_others.go
+******************************************************/
+
+package getty
+
+const abstractUnixSocketSupported = false
@@ -0,0 +1,107 @@
+package getty
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+import (
+	jerrors "github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoveStaleUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.sock")
+	assert.Nil(t, removeStaleUnixSocket(path))
+
+	// a live socket is kept
+	l, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	assert.Equal(t, errUnixSocketInUse, jerrors.Cause(removeStaleUnixSocket(path)))
+
+	// the socket file left by a crashed server is removed
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	_, err = os.Lstat(path)
+	assert.Nil(t, err)
+	assert.Nil(t, removeStaleUnixSocket(path))
+	_, err = os.Lstat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// a regular file is kept
+	assert.Nil(t, os.WriteFile(path, nil, 0600))
+	assert.NotNil(t, removeStaleUnixSocket(path))
+	_, err = os.Lstat(path)
+	assert.Nil(t, err)
+}
+
+func TestLookupID(t *testing.T) {
+	id, err := lookupID("1000", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, id)
+
+	id, err = lookupID("root", func(string) (string, error) { return "0", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 0, id)
+}
+
+func TestUnixServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "getty.sock")
+	// a stale socket file
+	l, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	var (
+		serverHandler MessageHandler
+		clientHandler MessageHandler
+	)
+	server := NewUnixServer(
+		WithLocalAddress(path),
+		WithServerUnixSocket(&UnixSocketConfig{Mode: 0600, User: strconv.Itoa(os.Getuid())}),
+	)
+	server.RunEventLoop(func(ss Session) error {
+		assert.Equal(t, defaultUnixSessionName, ss.(*session).name)
+		return newSessionCallback(ss, &serverHandler)
+	})
+	fi, err := os.Lstat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	client := NewUnixClient(WithServerAddress(path), WithConnectionNumber(1))
+	client.RunEventLoop(func(session Session) error {
+		return newSessionCallback(session, &clientHandler)
+	})
+	for i := 0; i < 100 && serverHandler.SessionNumber() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, serverHandler.SessionNumber())
+	assert.Equal(t, 1, clientHandler.SessionNumber())
+
+	client.Close()
+	server.Close()
+	_, err = os.Lstat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAbstractUnixServer(t *testing.T) {
+	addr := "@getty-test-" + strconv.Itoa(os.Getpid())
+	server := newServer(UNIX_SERVER, WithLocalAddress(addr))
+	err := server.listen()
+	if runtime.GOOS != "linux" {
+		assert.Equal(t, ErrNotSupported, jerrors.Cause(err))
+		return
+	}
+	assert.Nil(t, err)
+	defer server.Close()
+
+	conn, err := net.Dial("unix", addr)
+	assert.Nil(t, err)
+	conn.Close()
+}
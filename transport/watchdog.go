@@ -0,0 +1,201 @@
+/******************************************************
+# DESC       : slow handler watchdog
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-04-11 10:32
+# FILE       : watchdog.go
+******************************************************/
+
+package getty
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	log "github.com/AlexStocks/log4go"
+)
+
+const (
+	// pprof label key of the OnMessage invocation
+	handlerTaskLabel       = "getty_handler_task"
+	minWatchdogScanPeriod  = 10 * time.Millisecond
+	maxWatchdogStackLength = 64 << 10
+	// the tasks are sharded by task id, so concurrent OnMessage invocations seldom contend
+	watchdogShardNum = 32
+)
+
+// SlowHandlerListener can be implemented by an EventListener which wants to be notified
+// when its OnMessage invocation exceeds the slow handler threshold of the endpoint.
+// OnSlowHandler is invoked in the watchdog goroutine while OnMessage is still running,
+// and @stack is the goroutine stack of the OnMessage invocation. It may be nil
+// if the stack can not be captured.
+type SlowHandlerListener interface {
+	OnSlowHandler(ss Session, pkg interface{}, elapsed time.Duration, stack []byte)
+}
+
+type handlerTask struct {
+	ss *session
+	// the listener which runs the task, it is fixed when the task is registered
+	listener EventListener
+	pkg      interface{}
+	start    time.Time
+	flagged  bool
+}
+
+// handlerWatchdog flags OnMessage invocations which exceed @threshold.
+type handlerWatchdog struct {
+	threshold time.Duration
+	metrics   *EndPointMetrics
+
+	seq    uint64
+	shards [watchdogShardNum]watchdogShard
+
+	start sync.Once
+	stop  sync.Once
+	done  chan struct{}
+}
+
+type watchdogShard struct {
+	lock  sync.Mutex
+	tasks map[uint64]*handlerTask
+}
+
+func newHandlerWatchdog(threshold time.Duration, metrics *EndPointMetrics) *handlerWatchdog {
+	if threshold <= 0 {
+		return nil
+	}
+
+	w := &handlerWatchdog{
+		threshold: threshold,
+		metrics:   metrics,
+		done:      make(chan struct{}),
+	}
+	for i := range w.shards {
+		w.shards[i].tasks = make(map[uint64]*handlerTask)
+	}
+
+	return w
+}
+
+// invoke @f(OnMessage of @listener) under watch. the goroutine running @f is labeled
+// with its task id so that its stack can be found in the goroutine profile.
+func (w *handlerWatchdog) watch(ss *session, listener EventListener, pkg interface{}, f func()) {
+	w.start.Do(func() {
+		go w.run()
+	})
+
+	id := atomic.AddUint64(&w.seq, 1)
+	shard := &w.shards[id%watchdogShardNum]
+	shard.lock.Lock()
+	shard.tasks[id] = &handlerTask{ss: ss, listener: listener, pkg: pkg, start: time.Now()}
+	shard.lock.Unlock()
+
+	defer func() {
+		shard.lock.Lock()
+		task := shard.tasks[id]
+		delete(shard.tasks, id)
+		shard.lock.Unlock()
+		if task != nil && task.flagged {
+			log.Warn("%s, [handlerWatchdog] slow OnMessage returned after %s", ss.sessionToken(), time.Since(task.start))
+		}
+	}()
+
+	labels := pprof.Labels(handlerTaskLabel, strconv.FormatUint(id, 10))
+	pprof.Do(context.Background(), labels, func(context.Context) {
+		f()
+	})
+}
+
+func (w *handlerWatchdog) run() {
+	period := w.threshold >> 1
+	if period < minWatchdogScanPeriod {
+		period = minWatchdogScanPeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.scan()
+		}
+	}
+}
+
+func (w *handlerWatchdog) scan() {
+	var (
+		ids   []uint64
+		tasks []*handlerTask
+	)
+
+	now := time.Now()
+	for i := range w.shards {
+		shard := &w.shards[i]
+		shard.lock.Lock()
+		for id, task := range shard.tasks {
+			if !task.flagged && w.threshold < now.Sub(task.start) {
+				task.flagged = true
+				ids = append(ids, id)
+				tasks = append(tasks, task)
+			}
+		}
+		shard.lock.Unlock()
+	}
+	if len(tasks) == 0 {
+		return
+	}
+
+	profile := goroutineProfile()
+	for i, task := range tasks {
+		elapsed := now.Sub(task.start)
+		stack := lookupLabeledStack(profile, handlerTaskLabel, strconv.FormatUint(ids[i], 10))
+		atomic.AddUint64(&w.metrics.slowHandlerNum, 1)
+		log.Warn("%s, [handlerWatchdog] OnMessage(pkg:%#v) has run for %s, threshold:%s, stack:\n%s",
+			task.ss.sessionToken(), task.pkg, elapsed, w.threshold, stack)
+		if listener, ok := task.listener.(SlowHandlerListener); ok {
+			listener.OnSlowHandler(task.ss, task.pkg, elapsed, stack)
+		}
+	}
+}
+
+func (w *handlerWatchdog) close() {
+	w.stop.Do(func() {
+		close(w.done)
+	})
+}
+
+func goroutineProfile() []byte {
+	var buf bytes.Buffer
+
+	if p := pprof.Lookup("goroutine"); p != nil {
+		p.WriteTo(&buf, 1)
+	}
+
+	return buf.Bytes()
+}
+
+// the goroutine profile of debug level 1 consists of records separated by blank lines,
+// and the record of a labeled goroutine contains a line like `# labels: {"key":"value"}`.
+func lookupLabeledStack(profile []byte, key, value string) []byte {
+	label := []byte(strconv.Quote(key) + ":" + strconv.Quote(value))
+	for _, record := range bytes.Split(profile, []byte("\n\n")) {
+		if bytes.Contains(record, label) {
+			if maxWatchdogStackLength < len(record) {
+				record = record[:maxWatchdogStackLength]
+			}
+			return record
+		}
+	}
+
+	return nil
+}
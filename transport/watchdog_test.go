@@ -0,0 +1,54 @@
+package getty
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type slowHandler struct {
+	MessageHandler
+
+	lock    sync.Mutex
+	elapsed time.Duration
+	stack   []byte
+}
+
+func (h *slowHandler) OnSlowHandler(ss Session, pkg interface{}, elapsed time.Duration, stack []byte) {
+	h.lock.Lock()
+	h.elapsed = elapsed
+	h.stack = stack
+	h.lock.Unlock()
+}
+
+func TestHandlerWatchdog(t *testing.T) {
+	var handler slowHandler
+
+	assert.Nil(t, newHandlerWatchdog(0, nil))
+
+	metrics := newEndPointMetrics(0)
+	w := newHandlerWatchdog(20*time.Millisecond, metrics)
+	defer w.close()
+
+	ss, _ := newPipeSessions(t)
+	w.watch(ss, &handler, "fast", func() {})
+	assert.Equal(t, uint64(0), metrics.SlowHandlerNum())
+
+	w.watch(ss, &handler, "slow", func() { time.Sleep(200 * time.Millisecond) })
+	assert.Equal(t, uint64(1), metrics.SlowHandlerNum())
+	for i := range w.shards {
+		w.shards[i].lock.Lock()
+		assert.Equal(t, 0, len(w.shards[i].tasks))
+		w.shards[i].lock.Unlock()
+	}
+
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	assert.True(t, 20*time.Millisecond < handler.elapsed)
+	assert.True(t, bytes.Contains(handler.stack, []byte("TestHandlerWatchdog")), string(handler.stack))
+}
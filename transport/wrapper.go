@@ -0,0 +1,406 @@
+/******************************************************
+# DESC       : io wrapper chain of tcp connection
+# MAINTAINER : Alex Stocks
+# LICENCE    : Apache License 2.0
+# EMAIL      : alexstocks@foxmail.com
+# MOD        : 2020-05-15 10:40
+# FILE       : wrapper.go
+******************************************************/
+
+package getty
+
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+import (
+	"github.com/golang/snappy"
+	jerrors "github.com/juju/errors"
+)
+
+// StreamWrapper wraps the byte stream of a tcp connection, e.g. by compression, encryption,
+// metering or throttling. WrapReader & WrapWriter get the inner reader & writer, which are
+// the net connection or the ones returned by the previous wrapper of the chain.
+//
+// The wrappers are invoked per connection, so the returned reader & writer can keep the
+// stream state. The reader is read by the read goroutine only, and the writes of the writer
+// are serialized by the connection.
+type StreamWrapper interface {
+	WrapReader(io.Reader) io.Reader
+	WrapWriter(io.Writer) io.Writer
+}
+
+// StreamWrapperFuncs is a StreamWrapper composed of two functions. nil Reader or Writer keeps
+// the inner reader or writer.
+type StreamWrapperFuncs struct {
+	Reader func(io.Reader) io.Reader
+	Writer func(io.Writer) io.Writer
+}
+
+func (f StreamWrapperFuncs) WrapReader(r io.Reader) io.Reader {
+	if f.Reader == nil {
+		return r
+	}
+	return f.Reader(r)
+}
+
+func (f StreamWrapperFuncs) WrapWriter(w io.Writer) io.Writer {
+	if f.Writer == nil {
+		return w
+	}
+	return f.Writer(w)
+}
+
+// SetStreamWrappers sets the wrapper chain of the byte stream of a tcp session. The first
+// wrapper is the innermost one, i.e. the written bytes are wrapped by the last wrapper first
+// and reach the network through the first one, and the read bytes go the other way round.
+// The compression of SetCompressType, SetMessageCompression and the codec switches is put
+// over the chain, so the compressed bytes are wrapped by the chain.
+//
+// Pls invoke it in NewSessionCallback before the compression is set, for the compression
+// layer is removed by it. It has no effect on udp/websocket sessions.
+func (s *session) SetStreamWrappers(wrappers ...StreamWrapper) {
+	conn, ok := s.Connection.(*gettyTCPConn)
+	if !ok {
+		return
+	}
+
+	conn.setWrappers(wrappers)
+}
+
+/////////////////////////////////////////
+// connection wrapping
+/////////////////////////////////////////
+
+// wrappedConn is the net.Conn whose byte stream passes the wrapper chain
+type wrappedConn struct {
+	net.Conn
+	reader io.Reader
+	writer io.Writer
+}
+
+// WrapConn wraps the byte stream of @conn by the wrapper chain @wrappers in the order of
+// SetStreamWrappers. Unlike SetStreamWrappers, all the bytes of the session are wrapped, i.e.
+// the negotiation and the handshake as well, which is the obfuscation of the pluggable
+// transports, and the writes of the returned conn must be serialized.
+func WrapConn(conn net.Conn, wrappers ...StreamWrapper) net.Conn {
+	if len(wrappers) == 0 {
+		return conn
+	}
+
+	c := &wrappedConn{Conn: conn, reader: conn, writer: conn}
+	for _, w := range wrappers {
+		c.reader = w.WrapReader(c.reader)
+		c.writer = w.WrapWriter(c.writer)
+	}
+	return c
+}
+
+func (c *wrappedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *wrappedConn) Write(p []byte) (int, error) {
+	return c.writer.Write(p)
+}
+
+// WrapTransportDialer wraps the streams dialed by @dialer by WrapConn, e.g. obfuscates the
+// streams of SOCKSDialer.
+func WrapTransportDialer(dialer TransportDialer, wrappers ...StreamWrapper) TransportDialer {
+	return func(addr string) (net.Conn, error) {
+		conn, err := dialer(addr)
+		if err != nil {
+			return nil, err
+		}
+		return WrapConn(conn, wrappers...), nil
+	}
+}
+
+type wrappedListener struct {
+	net.Listener
+	wrappers []StreamWrapper
+}
+
+// WrapListener wraps the conns accepted from @listener by WrapConn, which is the server side
+// of WrapTransportDialer, e.g. NewTransportServer(WrapListener(listener, obfuscator)).
+func WrapListener(listener net.Listener, wrappers ...StreamWrapper) net.Listener {
+	return &wrappedListener{Listener: listener, wrappers: wrappers}
+}
+
+func (l *wrappedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return WrapConn(conn, l.wrappers...), nil
+}
+
+/////////////////////////////////////////
+// compression
+/////////////////////////////////////////
+
+type compressWrapper struct {
+	compress CompressType
+}
+
+// CompressWrapper returns the StreamWrapper of the stream compression @c, which is the one
+// set by (Session)SetCompressType. Pls attention that CompressNone is the deflate stream
+// without compression rather than the raw stream.
+func CompressWrapper(c CompressType) StreamWrapper {
+	if quality, ok := brotliQuality(c); ok {
+		return brotliWrapper{codec: getBrotliCodec(), quality: quality}
+	}
+
+	switch c {
+	case CompressNone, CompressZip, CompressBestSpeed, CompressBestCompression, CompressHuffman, CompressSnappy, CompressSnappyBlock:
+		return compressWrapper{compress: c}
+	}
+
+	panic(fmt.Sprintf("illegal comparess type %d", c))
+}
+
+func (w compressWrapper) WrapReader(r io.Reader) io.Reader {
+	switch w.compress {
+	case CompressSnappy:
+		return snappy.NewReader(r)
+	case CompressSnappyBlock:
+		return newMsgDecompressReader(r, MessageCompressConfig{Type: CompressSnappyBlock})
+	}
+	return &flateReader{reader: r}
+}
+
+func (w compressWrapper) WrapWriter(writer io.Writer) io.Writer {
+	switch w.compress {
+	case CompressSnappy:
+		return &snappyFlusher{writer: snappy.NewBufferedWriter(writer)}
+	case CompressSnappyBlock:
+		return &snappyBlockWriter{writer: writer, compressor: newMsgCompressor(MessageCompressConfig{Type: CompressSnappyBlock})}
+	}
+	return &writeFlusher{writer: writer, level: int(w.compress)}
+}
+
+// The flate writers take ~1MB each, so they are pooled by level, and the compression layers
+// take them lazily at the first write/read, which saves the memory of the idle connections.
+var (
+	flateWriterPools [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+	flateReaderPool  sync.Pool
+)
+
+// get a flate writer of @level from the pool, which is reset to write to @w
+func getFlateWriter(w io.Writer, level int) *flate.Writer {
+	if fw, ok := flateWriterPools[level-flate.HuffmanOnly].Get().(*flate.Writer); ok {
+		fw.Reset(w)
+		return fw
+	}
+
+	fw, err := flate.NewWriter(w, level)
+	if err != nil {
+		panic(fmt.Sprintf("flate.NewWriter(level:%d) = err(%s)", level, err))
+	}
+	return fw
+}
+
+func putFlateWriter(fw *flate.Writer, level int) {
+	flateWriterPools[level-flate.HuffmanOnly].Put(fw)
+}
+
+// get a flate reader from the pool, which is reset to read from @r
+func getFlateReader(r io.Reader) io.ReadCloser {
+	if fr, ok := flateReaderPool.Get().(io.ReadCloser); ok {
+		if err := fr.(flate.Resetter).Reset(r, nil); err == nil {
+			return fr
+		}
+	}
+
+	return flate.NewReader(r)
+}
+
+func putFlateReader(fr io.ReadCloser) {
+	flateReaderPool.Put(fr)
+}
+
+// streamReleaser is the compression layer which puts its pooled state back when the
+// layer is discarded.
+type streamReleaser interface {
+	release()
+}
+
+func releaseStream(stream interface{}) {
+	if r, ok := stream.(streamReleaser); ok {
+		r.release()
+	}
+}
+
+// for zip compress. The flate writer is taken from the pool at the first write, and it can be
+// released by another goroutine, for the writes are serialized by @lock. A write after the
+// release takes a new flate writer, which goes on with the deflate stream after the flushed
+// blocks.
+type writeFlusher struct {
+	writer  io.Writer
+	level   int
+	flusher *flate.Writer
+	lock    sync.Mutex
+}
+
+func (t *writeFlusher) Write(p []byte) (int, error) {
+	var (
+		n   int
+		err error
+	)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.flusher == nil {
+		t.flusher = getFlateWriter(t.writer, t.level)
+	}
+	n, err = t.flusher.Write(p)
+	if err != nil {
+		return n, jerrors.Trace(err)
+	}
+	if err := t.flusher.Flush(); err != nil {
+		return 0, jerrors.Trace(err)
+	}
+
+	return n, nil
+}
+
+func (t *writeFlusher) release() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.flusher != nil {
+		putFlateWriter(t.flusher, t.level)
+		t.flusher = nil
+	}
+}
+
+// flateReader takes the flate reader from the pool at the first read, and puts it back when
+// the stream ends or the layer is released. It is owned by the read goroutine, so it is
+// released by the read goroutine or before the session runs.
+type flateReader struct {
+	reader io.Reader
+	flate  io.ReadCloser
+	err    error
+}
+
+func (r *flateReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.flate == nil {
+		r.flate = getFlateReader(r.reader)
+	}
+
+	n, err := r.flate.Read(p)
+	if err != nil {
+		r.err = err
+		r.release()
+	}
+	return n, err
+}
+
+func (r *flateReader) release() {
+	if r.flate != nil {
+		putFlateReader(r.flate)
+		r.flate = nil
+	}
+}
+
+// snappyFlusher flushes every pkg written, so the peer can decode it at once
+type snappyFlusher struct {
+	writer *snappy.Writer
+}
+
+func (f *snappyFlusher) Write(p []byte) (int, error) {
+	n, err := f.writer.Write(p)
+	if err != nil {
+		return n, jerrors.Trace(err)
+	}
+	if err = f.writer.Flush(); err != nil {
+		return 0, jerrors.Trace(err)
+	}
+
+	return n, nil
+}
+
+// snappyBlockWriter compresses every write into block snappy frames with the mini-header of
+// the per message compression. A frame keeps the raw bytes if they do not get smaller.
+type snappyBlockWriter struct {
+	writer     io.Writer
+	compressor *msgCompressor
+}
+
+func (w *snappyBlockWriter) Write(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		// the peer rejects the frames longer than maxFramedMessageLen
+		size := len(p) - n
+		if size > maxFramedMessageLen {
+			size = maxFramedMessageLen
+		}
+		frame, err := w.compressor.frame(p[n:n+size], true)
+		if err != nil {
+			return n, jerrors.Trace(err)
+		}
+		if _, err = w.writer.Write(frame); err != nil {
+			return n, jerrors.Trace(err)
+		}
+		n += size
+	}
+
+	return n, nil
+}
+
+/////////////////////////////////////////
+// metering
+/////////////////////////////////////////
+
+// StreamMeter is a StreamWrapper which counts the bytes passing its position of the chain,
+// e.g. the bytes before or after the encryption. It can be shared by sessions.
+type StreamMeter struct {
+	readBytes  uint64
+	writeBytes uint64
+}
+
+func (m *StreamMeter) WrapReader(r io.Reader) io.Reader {
+	return &meterReader{meter: m, r: r}
+}
+
+func (m *StreamMeter) WrapWriter(w io.Writer) io.Writer {
+	return &meterWriter{meter: m, w: w}
+}
+
+// get the bytes read through the meter
+func (m *StreamMeter) ReadBytes() uint64 {
+	return atomic.LoadUint64(&m.readBytes)
+}
+
+// get the bytes written through the meter
+func (m *StreamMeter) WriteBytes() uint64 {
+	return atomic.LoadUint64(&m.writeBytes)
+}
+
+type meterReader struct {
+	meter *StreamMeter
+	r     io.Reader
+}
+
+func (r *meterReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddUint64(&r.meter.readBytes, uint64(n))
+	return n, err
+}
+
+type meterWriter struct {
+	meter *StreamMeter
+	w     io.Writer
+}
+
+func (w *meterWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	atomic.AddUint64(&w.meter.writeBytes, uint64(n))
+	return n, err
+}
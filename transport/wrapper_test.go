@@ -0,0 +1,201 @@
+package getty
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/stretchr/testify/assert"
+)
+
+type xorReader struct {
+	r   io.Reader
+	key byte
+}
+
+func (x xorReader) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= x.key
+	}
+	return n, err
+}
+
+type xorWriter struct {
+	w   io.Writer
+	key byte
+}
+
+func (x xorWriter) Write(p []byte) (int, error) {
+	b := make([]byte, len(p))
+	for i := range p {
+		b[i] = p[i] ^ x.key
+	}
+	return x.w.Write(b)
+}
+
+// a toy cipher of the stream
+func xorWrapper(key byte) StreamWrapper {
+	return StreamWrapperFuncs{
+		Reader: func(r io.Reader) io.Reader { return xorReader{r: r, key: key} },
+		Writer: func(w io.Writer) io.Writer { return xorWriter{w: w, key: key} },
+	}
+}
+
+func TestStreamWrapperFuncs(t *testing.T) {
+	var (
+		r io.Reader = bytes.NewReader(nil)
+		w io.Writer = ioutil.Discard
+	)
+	assert.Equal(t, r, StreamWrapperFuncs{}.WrapReader(r))
+	assert.Equal(t, w, StreamWrapperFuncs{}.WrapWriter(w))
+}
+
+func TestCompressWrapper(t *testing.T) {
+	assert.Panics(t, func() { CompressWrapper(CompressType(100)) })
+
+	for _, c := range []CompressType{CompressNone, CompressZip, CompressBestSpeed, CompressHuffman, CompressSnappy, CompressSnappyBlock} {
+		var buf bytes.Buffer
+		wrapper := CompressWrapper(c)
+		w := wrapper.WrapWriter(&buf)
+		_, err := w.Write([]byte("hello"))
+		assert.Nil(t, err)
+		// every write is flushed
+		data := make([]byte, 5)
+		_, err = io.ReadFull(wrapper.WrapReader(&buf), data)
+		assert.Nil(t, err, c)
+		assert.Equal(t, "hello", string(data))
+	}
+}
+
+func TestFlateLazyPooled(t *testing.T) {
+	var buf bytes.Buffer
+	wrapper := CompressWrapper(CompressBestSpeed)
+	w := wrapper.WrapWriter(&buf).(*writeFlusher)
+	r := wrapper.WrapReader(&buf).(*flateReader)
+	// no flate state before the first write/read
+	assert.Nil(t, w.flusher)
+	assert.Nil(t, r.flate)
+
+	data := make([]byte, 5)
+	for _, msg := range []string{"hello", "world"} {
+		_, err := w.Write([]byte(msg))
+		assert.Nil(t, err)
+		assert.NotNil(t, w.flusher)
+		// the stream goes on with another flate writer after the release
+		w.release()
+		assert.Nil(t, w.flusher)
+
+		_, err = io.ReadFull(r, data)
+		assert.Nil(t, err)
+		assert.Equal(t, msg, string(data))
+		assert.NotNil(t, r.flate)
+	}
+
+	// the flate reader is put back at the end of the stream
+	_, err := r.Read(data)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Nil(t, r.flate)
+	_, err = r.Read(data)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestSessionFlateRelease(t *testing.T) {
+	src, dst := newPipeSessions(t)
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+	for _, ss := range []*session{src, dst} {
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(listener)
+		ss.SetCompressType(CompressZip)
+		ss.run()
+	}
+	defer dst.Close()
+
+	assert.Nil(t, src.WritePkg("hello", 0))
+	assert.Equal(t, "hello", <-listener.msgs)
+	conn := src.Connection.(*gettyTCPConn)
+	writer, _ := conn.writeStream()
+	flusher := writer.(*writeFlusher)
+	flusher.lock.Lock()
+	assert.NotNil(t, flusher.flusher)
+	flusher.lock.Unlock()
+	// the flate writer is put back when the connection is closed, i.e. after the read goroutine
+	// exits at the read deadline
+	src.Close()
+	assert.Eventually(t, func() bool {
+		flusher.lock.Lock()
+		defer flusher.lock.Unlock()
+		return flusher.flusher == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSessionStreamWrappers(t *testing.T) {
+	conn, peer := newTCPPair(t)
+	listener := &lineListener{msgs: make(chan interface{}, 4)}
+	clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+	ss := newTCPSession(conn, clt).(*session)
+	ss.SetPkgHandler(&lineTransferCodec{})
+	ss.SetEventListener(listener)
+	inner, outer := &StreamMeter{}, &StreamMeter{}
+	ss.SetStreamWrappers(inner, xorWrapper(0x5a), outer)
+	ss.run()
+	defer ss.Close()
+
+	// the peer sees the ciphertext
+	assert.Nil(t, ss.WritePkg("hello", 0))
+	data := make([]byte, 6)
+	_, err := io.ReadFull(peer, data)
+	assert.Nil(t, err)
+	_, err = io.ReadFull(xorReader{r: bytes.NewReader(data), key: 0x5a}, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\n", string(data))
+
+	_, err = xorWriter{w: peer, key: 0x5a}.Write([]byte("world\n"))
+	assert.Nil(t, err)
+	select {
+	case pkg := <-listener.msgs:
+		assert.Equal(t, "world", pkg)
+	case <-time.After(time.Second):
+		t.Fatal("the pkg is not received")
+	}
+	assert.Equal(t, uint64(6), inner.WriteBytes())
+	assert.Equal(t, uint64(6), outer.WriteBytes())
+	assert.Equal(t, uint64(6), inner.ReadBytes())
+	assert.Equal(t, uint64(6), outer.ReadBytes())
+}
+
+func TestSessionStreamWrappersWithCompression(t *testing.T) {
+	conn, peer := newTCPPair(t)
+	clt := newClient(TCP_CLIENT, WithServerAddress("127.0.0.1:0"), WithConnectionNumber(1))
+	msgs := make(chan interface{}, 4)
+	sessions := make([]*session, 2)
+	meters := make([]*StreamMeter, 2)
+	for i, c := range []net.Conn{conn, peer} {
+		ss := newTCPSession(c, clt).(*session)
+		ss.SetPkgHandler(&lineTransferCodec{})
+		ss.SetEventListener(&lineListener{msgs: msgs})
+		meters[i] = &StreamMeter{}
+		// the compressed stream is encrypted and metered
+		ss.SetStreamWrappers(meters[i], xorWrapper(0x5a))
+		ss.SetCompressType(CompressSnappy)
+		ss.run()
+		defer ss.Close()
+		sessions[i] = ss
+	}
+
+	msg := string(bytes.Repeat([]byte("hello"), 100))
+	assert.Nil(t, sessions[0].WritePkg(msg, 0))
+	select {
+	case pkg := <-msgs:
+		assert.Equal(t, msg, pkg)
+	case <-time.After(time.Second):
+		t.Fatal("the pkg is not received")
+	}
+	assert.True(t, meters[0].WriteBytes() < uint64(len(msg)))
+	assert.Equal(t, meters[0].WriteBytes(), meters[1].ReadBytes())
+}